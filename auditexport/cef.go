@@ -0,0 +1,97 @@
+package auditexport
+
+import (
+	"encoding/json"
+	"net"
+	"oauth2-server/models"
+	"strconv"
+	"strings"
+)
+
+// cefSeverity rates the events worth a SIEM's attention; everything else is 3
+var cefSeverity = map[string]int{
+	models.AuditLoginFailure:    5,
+	models.AuditMFAFailure:      5,
+	models.AuditTokenDenied:     5,
+	models.AuditLoginUnfamiliar: 6,
+	models.AuditCodeIPMismatch:  6,
+	models.AuditUserDisabled:    6,
+	models.AuditUserDeleted:     6,
+	models.AuditCodeReplayed:    8,
+}
+
+// cef writes ArcSight Common Event Format lines. The chain fields travel in
+// custom extensions so a SIEM can spot gaps; only JSON Lines exports can be
+// verified, since CEF drops the port of the client address.
+type cef struct {
+	product string
+	version string
+}
+
+func (c cef) Entry(entry *models.AuditEntry) []byte {
+	severity, ok := cefSeverity[entry.Event]
+	if !ok {
+		severity = 3
+	}
+
+	ext := []string{
+		"rt=" + strconv.FormatInt(entry.CreatedAt.UnixMilli(), 10),
+		"cn1Label=seq", "cn1=" + strconv.FormatInt(entry.Seq, 10),
+		"cs2Label=hash", "cs2=" + entry.Hash,
+		"cs3Label=prevHash", "cs3=" + entry.PrevHash,
+	}
+	if entry.UserID != "" {
+		ext = append(ext, "suser="+cefValue(entry.UserID))
+	}
+	if entry.ClientID != "" {
+		ext = append(ext, "cs1Label=clientId", "cs1="+cefValue(entry.ClientID))
+	}
+	if entry.IPAddress != "" {
+		host, _, err := net.SplitHostPort(entry.IPAddress)
+		if err != nil {
+			host = entry.IPAddress
+		}
+		ext = append(ext, "src="+cefValue(host))
+	}
+	if len(entry.Details) > 0 {
+		details, _ := json.Marshal(entry.Details)
+		ext = append(ext, "cs4Label=details", "cs4="+cefValue(string(details)))
+	}
+	return c.line(entry.Event, strings.ReplaceAll(entry.Event, "_", " "), severity, ext)
+}
+
+func (c cef) Checkpoint(checkpoint *models.AuditAnchor) []byte {
+	return c.line("audit_checkpoint", "audit checkpoint", 1, []string{
+		"rt=" + strconv.FormatInt(checkpoint.CreatedAt.UnixMilli(), 10),
+		"cn1Label=seq", "cn1=" + strconv.FormatInt(checkpoint.Seq, 10),
+		"cs2Label=hash", "cs2=" + checkpoint.HeadHash,
+		"cs6Label=signature", "cs6=" + checkpoint.Signature,
+	})
+}
+
+func (cef) ContentType() string {
+	return "text/plain; charset=utf-8"
+}
+
+func (c cef) line(classID, name string, severity int, ext []string) []byte {
+	header := []string{
+		"CEF:0",
+		cefHeader(c.product),
+		cefHeader(c.product),
+		cefHeader(c.version),
+		cefHeader(classID),
+		cefHeader(name),
+		strconv.Itoa(severity),
+	}
+	return []byte(strings.Join(header, "|") + "|" + strings.Join(ext, " ") + "\n")
+}
+
+// cefHeader escapes a header field
+func cefHeader(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "|", `\|`, "\n", " ", "\r", " ").Replace(value)
+}
+
+// cefValue escapes an extension value
+func cefValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "=", `\=`, "\n", `\n`, "\r", `\r`).Replace(value)
+}
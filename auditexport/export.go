@@ -0,0 +1,213 @@
+// Package auditexport streams the audit log to an external store, such as a
+// file collected by a log shipper or a SIEM's HTTP collector, as JSON Lines
+// or CEF. Every batch ends with a checkpoint: the head of the hash chain
+// exported so far, signed with the server key, so a copy of the export can be
+// checked for changes without access to the database.
+package auditexport
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"oauth2-server/models"
+	"oauth2-server/utils"
+	"time"
+)
+
+// Export formats
+const (
+	FormatJSONLines = "jsonl"
+	FormatCEF       = "cef"
+)
+
+// DefaultBatchSize is how many entries go out between two checkpoints
+const DefaultBatchSize = 500
+
+// Store reads the audit log in sequence order and remembers, per export, the
+// sequence of the last entry delivered
+type Store interface {
+	ListAfter(ctx context.Context, seq, limit int64) ([]*models.AuditEntry, error)
+	ExportPosition(ctx context.Context, name string) (int64, error)
+	SetExportPosition(ctx context.Context, name string, seq int64) error
+}
+
+// Sink receives encoded batches
+type Sink interface {
+	Write(ctx context.Context, data []byte) error
+}
+
+// Encoder turns entries and checkpoints into lines of one format
+type Encoder interface {
+	Entry(entry *models.AuditEntry) []byte
+	Checkpoint(checkpoint *models.AuditAnchor) []byte
+	ContentType() string
+}
+
+// NewEncoder returns the encoder for format. CEF headers name the product
+// and version that produced the events.
+func NewEncoder(format, product, version string) (Encoder, error) {
+	switch format {
+	case FormatJSONLines:
+		return jsonLines{}, nil
+	case FormatCEF:
+		return cef{product: product, version: version}, nil
+	}
+	return nil, fmt.Errorf("unknown audit export format %q", format)
+}
+
+// Exporter delivers the entries appended since its last run to one sink.
+// Delivery is at least once: a batch whose position could not be saved is
+// sent again, and receivers drop entries by sequence.
+type Exporter struct {
+	name       string
+	store      Store
+	sink       Sink
+	encoder    Encoder
+	privateKey *rsa.PrivateKey
+	batchSize  int64
+}
+
+// NewExporter creates an exporter whose position is stored under name
+func NewExporter(name string, store Store, sink Sink, encoder Encoder, privateKey *rsa.PrivateKey, batchSize int64) *Exporter {
+	if batchSize < 1 {
+		batchSize = DefaultBatchSize
+	}
+	return &Exporter{
+		name:       name,
+		store:      store,
+		sink:       sink,
+		encoder:    encoder,
+		privateKey: privateKey,
+		batchSize:  batchSize,
+	}
+}
+
+// Export sends every entry after the stored position, one signed
+// checkpoint per batch, and returns how many entries were sent
+func (e *Exporter) Export(ctx context.Context) (int, error) {
+	sent := 0
+	for {
+		after, err := e.store.ExportPosition(ctx, e.name)
+		if err != nil {
+			return sent, err
+		}
+		entries, err := e.store.ListAfter(ctx, after, e.batchSize)
+		if err != nil || len(entries) == 0 {
+			return sent, err
+		}
+
+		last := entries[len(entries)-1]
+		checkpoint := &models.AuditAnchor{
+			Seq:       last.Seq,
+			HeadHash:  last.Hash,
+			CreatedAt: utils.AuditTime(time.Now()),
+		}
+		if checkpoint.Signature, err = utils.SignAuditAnchor(checkpoint, e.privateKey); err != nil {
+			return sent, err
+		}
+
+		var batch bytes.Buffer
+		for _, entry := range entries {
+			batch.Write(e.encoder.Entry(entry))
+		}
+		batch.Write(e.encoder.Checkpoint(checkpoint))
+		if err := e.sink.Write(ctx, batch.Bytes()); err != nil {
+			return sent, err
+		}
+		if err := e.store.SetExportPosition(ctx, e.name, last.Seq); err != nil {
+			return sent, err
+		}
+
+		sent += len(entries)
+		if int64(len(entries)) < e.batchSize {
+			return sent, nil
+		}
+	}
+}
+
+// Run exports every interval until ctx is done
+func (e *Exporter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := e.Export(ctx); err != nil {
+				log.Printf("Failed to export audit log to %s: %v", e.name, err)
+			}
+		}
+	}
+}
+
+// Line is one line of a JSON Lines export: an audit entry or a signed
+// checkpoint of the chain head exported so far
+type Line struct {
+	Type       string              `json:"type"` // "entry" or "checkpoint"
+	Entry      *models.AuditEntry  `json:"entry,omitempty"`
+	Checkpoint *models.AuditAnchor `json:"checkpoint,omitempty"`
+}
+
+type jsonLines struct{}
+
+func (jsonLines) Entry(entry *models.AuditEntry) []byte {
+	return jsonLine(Line{Type: "entry", Entry: entry})
+}
+
+func (jsonLines) Checkpoint(checkpoint *models.AuditAnchor) []byte {
+	return jsonLine(Line{Type: "checkpoint", Checkpoint: checkpoint})
+}
+
+func (jsonLines) ContentType() string {
+	return "application/x-ndjson"
+}
+
+func jsonLine(line Line) []byte {
+	data, _ := json.Marshal(line)
+	return append(data, '\n')
+}
+
+// ReadJSONLines parses a JSON Lines export into its entries, in sequence
+// order, and checkpoints. Entries delivered twice are kept once; an entry
+// repeated with different content is kept twice so chain verification
+// reports it.
+func ReadJSONLines(r io.Reader) ([]*models.AuditEntry, []*models.AuditAnchor, error) {
+	var entries []*models.AuditEntry
+	var checkpoints []*models.AuditAnchor
+	hashes := make(map[int64]string)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var line Line
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			return nil, nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		switch {
+		case line.Type == "entry" && line.Entry != nil:
+			if hash, seen := hashes[line.Entry.Seq]; seen && hash == line.Entry.Hash {
+				continue
+			}
+			hashes[line.Entry.Seq] = line.Entry.Hash
+			entries = append(entries, line.Entry)
+		case line.Type == "checkpoint" && line.Checkpoint != nil:
+			checkpoints = append(checkpoints, line.Checkpoint)
+		default:
+			return nil, nil, fmt.Errorf("line %d: not an audit entry or checkpoint", n)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	return entries, checkpoints, nil
+}
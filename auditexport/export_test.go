@@ -0,0 +1,160 @@
+package auditexport
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"oauth2-server/models"
+	"oauth2-server/utils"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeStore struct {
+	entries   []*models.AuditEntry
+	positions map[string]int64
+}
+
+func (s *fakeStore) ListAfter(ctx context.Context, seq, limit int64) ([]*models.AuditEntry, error) {
+	var entries []*models.AuditEntry
+	for _, entry := range s.entries {
+		if entry.Seq > seq && int64(len(entries)) < limit {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (s *fakeStore) ExportPosition(ctx context.Context, name string) (int64, error) {
+	return s.positions[name], nil
+}
+
+func (s *fakeStore) SetExportPosition(ctx context.Context, name string, seq int64) error {
+	s.positions[name] = seq
+	return nil
+}
+
+type bufferSink struct{ bytes.Buffer }
+
+func (s *bufferSink) Write(ctx context.Context, data []byte) error {
+	_, err := s.Buffer.Write(data)
+	return err
+}
+
+func buildChain(n int) []*models.AuditEntry {
+	var entries []*models.AuditEntry
+	prevHash := ""
+	for i := 1; i <= n; i++ {
+		entry := &models.AuditEntry{
+			Seq:       int64(i),
+			Event:     models.AuditTokenIssued,
+			ClientID:  "client-1",
+			IPAddress: "203.0.113.7:52100",
+			Details:   map[string]string{"scope": "openid"},
+			CreatedAt: utils.AuditTime(time.Now()),
+			PrevHash:  prevHash,
+		}
+		entry.Hash = utils.HashAuditEntry(entry)
+		prevHash = entry.Hash
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestExporter_JSONLines(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	store := &fakeStore{entries: buildChain(5), positions: map[string]int64{}}
+	sink := &bufferSink{}
+	encoder, _ := NewEncoder(FormatJSONLines, "oauth2-server", "1.0.0")
+	exporter := NewExporter("file", store, sink, encoder, privateKey, 2)
+
+	sent, err := exporter.Export(context.Background())
+	if err != nil || sent != 5 {
+		t.Fatalf("Expected 5 entries sent, got %d: %v", sent, err)
+	}
+	if store.positions["file"] != 5 {
+		t.Errorf("Expected the position to be 5, got %d", store.positions["file"])
+	}
+	if sent, _ := exporter.Export(context.Background()); sent != 0 {
+		t.Errorf("Expected nothing new to send, got %d", sent)
+	}
+
+	// A batch delivered twice is read once
+	export := sink.String() + strings.SplitAfter(sink.String(), "\n")[0]
+	entries, checkpoints, err := ReadJSONLines(strings.NewReader(export))
+	if err != nil {
+		t.Fatalf("Failed to read export: %v", err)
+	}
+	if len(entries) != 5 || len(checkpoints) != 3 {
+		t.Fatalf("Expected 5 entries and a checkpoint per batch of 2, got %d and %d", len(entries), len(checkpoints))
+	}
+	if seq, err := utils.VerifyAuditChain(entries); err != nil {
+		t.Errorf("Expected the exported chain to verify, broken at %d: %v", seq, err)
+	}
+	for _, checkpoint := range checkpoints {
+		if err := utils.VerifyAuditAnchor(checkpoint, &privateKey.PublicKey); err != nil {
+			t.Errorf("Checkpoint %d is not correctly signed: %v", checkpoint.Seq, err)
+		}
+	}
+
+	if _, _, err := ReadJSONLines(strings.NewReader(`{"type":"note"}`)); err == nil {
+		t.Error("Expected an unknown line to be rejected")
+	}
+}
+
+func TestCEF(t *testing.T) {
+	encoder, err := NewEncoder(FormatCEF, "oauth|server", "1.0.0")
+	if err != nil {
+		t.Fatalf("Failed to create encoder: %v", err)
+	}
+	entry := buildChain(1)[0]
+	entry.Event = models.AuditCodeReplayed
+	entry.UserID = `user=1\x`
+
+	line := string(encoder.Entry(entry))
+	if !strings.HasPrefix(line, `CEF:0|oauth\|server|oauth\|server|1.0.0|code_replayed|code replayed|8|`) {
+		t.Errorf("Unexpected header: %s", line)
+	}
+	for _, want := range []string{`suser=user\=1\\x`, "src=203.0.113.7 ", "cn1=1 ", "cs2=" + entry.Hash, `cs4={"scope":"openid"}`} {
+		if !strings.Contains(line, want) {
+			t.Errorf("Expected %q in %s", want, line)
+		}
+	}
+	if strings.Count(line, "\n") != 1 || !strings.HasSuffix(line, "\n") {
+		t.Errorf("Expected a single line, got %q", line)
+	}
+
+	if _, err := NewEncoder("syslog", "oauth2-server", "1.0.0"); err == nil {
+		t.Error("Expected an unknown format to be rejected")
+	}
+}
+
+func TestHTTPSink(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	var received *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	sink := NewHTTPSink(server.URL, "Bearer collector-token", "application/x-ndjson", time.Second)
+	if err := sink.Write(context.Background(), []byte("{}\n")); err == nil {
+		t.Error("Expected a rejected batch to fail")
+	}
+
+	status = http.StatusOK
+	if err := sink.Write(context.Background(), []byte("{}\n")); err != nil {
+		t.Fatalf("Expected the batch to be accepted: %v", err)
+	}
+	if received.Header.Get("Authorization") != "Bearer collector-token" || received.Header.Get("Content-Type") != "application/x-ndjson" {
+		t.Errorf("Unexpected headers %v", received.Header)
+	}
+}
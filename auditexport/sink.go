@@ -0,0 +1,77 @@
+package auditexport
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// FileSink appends batches to a file, creating it when missing
+type FileSink struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewFileSink(path string) *FileSink {
+	return &FileSink{path: path}
+}
+
+func (s *FileSink) Write(ctx context.Context, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// HTTPSink posts each batch to a collector URL. A batch the collector does
+// not accept with a 2xx status is sent again on the next run.
+type HTTPSink struct {
+	url           string
+	authorization string
+	contentType   string
+	client        *http.Client
+}
+
+// NewHTTPSink creates a sink posting to url. authorization, when set, is sent
+// as the Authorization header, e.g. a collector token.
+func NewHTTPSink(url, authorization, contentType string, timeout time.Duration) *HTTPSink {
+	return &HTTPSink{
+		url:           url,
+		authorization: authorization,
+		contentType:   contentType,
+		client:        &http.Client{Timeout: timeout},
+	}
+}
+
+func (s *HTTPSink) Write(ctx context.Context, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", s.contentType)
+	if s.authorization != "" {
+		req.Header.Set("Authorization", s.authorization)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("collector responded with %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+// Package backchannel delivers OpenID Connect Back-Channel Logout 1.0 logout
+// tokens to clients, telling them to end the user's sessions and drop the
+// tokens they hold instead of waiting for the next API call to fail.
+package backchannel
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Notifier posts logout tokens in the background. A failed delivery is
+// retried with exponential backoff using the same token, so a client that
+// records the jti of each token it accepts handles every logout once.
+type Notifier struct {
+	client      *http.Client
+	maxAttempts int
+	backoff     time.Duration
+}
+
+// NewNotifier creates a notifier that tries each delivery up to maxAttempts
+// times, waiting backoff before the first retry and doubling the wait after
+// each one
+func NewNotifier(timeout time.Duration, maxAttempts int, backoff time.Duration) *Notifier {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	return &Notifier{
+		client:      &http.Client{Timeout: timeout},
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
+	}
+}
+
+// Notify sends the logout token to the client's back-channel logout URI
+// without blocking the caller
+func (n *Notifier) Notify(logoutURI, logoutToken string) {
+	go n.deliver(logoutURI, logoutToken)
+}
+
+// deliver posts the token until the client accepts it, gives a permanent
+// error or the attempts run out
+func (n *Notifier) deliver(logoutURI, logoutToken string) {
+	wait := n.backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := n.send(logoutURI, logoutToken)
+		if err == nil {
+			return
+		}
+		if !retry || attempt >= n.maxAttempts {
+			log.Printf("Back-channel logout to %s gave up after %d attempts: %v", logoutURI, attempt, err)
+			return
+		}
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// send makes one delivery attempt and reports whether a failure is worth
+// retrying. A client answers 200 when it has logged the user out and 400
+// when it rejects the token, which sending it again will not change.
+func (n *Notifier) send(logoutURI, logoutToken string) (bool, error) {
+	form := url.Values{"logout_token": {logoutToken}}
+	req, err := http.NewRequest(http.MethodPost, logoutURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Cache-Control", "no-store")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("client responded with %d", resp.StatusCode)
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
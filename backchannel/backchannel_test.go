@@ -0,0 +1,48 @@
+package backchannel
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNotifier_RetriesWithTheSameToken(t *testing.T) {
+	var attempts int32
+	tokens := make(chan string, 3)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens <- r.PostFormValue("logout_token")
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	n := NewNotifier(time.Second, 3, time.Millisecond)
+	n.deliver(server.URL, "logout-token")
+
+	if got := atomic.LoadInt32(&attempts); got != 3 {
+		t.Fatalf("Expected 3 attempts, got %d", got)
+	}
+	for i := 0; i < 3; i++ {
+		if token := <-tokens; token != "logout-token" {
+			t.Errorf("Attempt %d sent %q", i+1, token)
+		}
+	}
+}
+
+func TestNotifier_RejectedTokenIsNotRetried(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	n := NewNotifier(time.Second, 5, time.Millisecond)
+	n.deliver(server.URL, "logout-token")
+	if got := atomic.LoadInt32(&attempts); got != 1 {
+		t.Errorf("Expected a single attempt, got %d", got)
+	}
+}
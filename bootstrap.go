@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"oauth2-server/config"
+	"oauth2-server/database"
+	"oauth2-server/handlers"
+	"oauth2-server/models"
+	"oauth2-server/repository"
+	"oauth2-server/utils"
+	"os"
+	"time"
+)
+
+// runBootstrap implements `oauth2-server bootstrap`. It creates the initial
+// admin user and client as startup does with BOOTSTRAP_ADMIN_EMAIL set, for
+// deployments that provision them in a one-off job instead.
+func runBootstrap(args []string) error {
+	cfg := config.Load()
+	if err := setPasswordHashing(cfg); err != nil {
+		return err
+	}
+	flags := flag.NewFlagSet("bootstrap", flag.ContinueOnError)
+	flags.StringVar(&cfg.BootstrapAdminEmail, "email", cfg.BootstrapAdminEmail, "admin user email (default: BOOTSTRAP_ADMIN_EMAIL)")
+	flags.StringVar(&cfg.BootstrapAdminPassword, "password", cfg.BootstrapAdminPassword, "admin user password (default: generated)")
+	flags.StringVar(&cfg.BootstrapClientID, "client-id", cfg.BootstrapClientID, "admin client ID")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if cfg.BootstrapAdminEmail == "" {
+		return errors.New("-email or BOOTSTRAP_ADMIN_EMAIL is required")
+	}
+
+	db, err := database.Connect(cfg.MongoURI, cfg.DatabaseName, time.Duration(cfg.DBOperationTimeout)*time.Second, database.PoolOptions{})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := database.CreateIndexes(db.DB); err != nil {
+		return err
+	}
+	return bootstrap(context.Background(), repository.NewUserRepository(db.DB), repository.NewClientRepository(db.DB), cfg, os.Stdout)
+}
+
+// bootstrap creates the admin user and admin client named in cfg unless
+// they exist, writing the credentials of what it creates to out. An
+// existing user with the email is given the admin role. Running it again
+// changes nothing and prints no credentials.
+func bootstrap(ctx context.Context, userRepo *repository.UserRepository, clientRepo *repository.ClientRepository, cfg *config.Config, out io.Writer) error {
+	admin, err := userRepo.FindByEmail(ctx, cfg.BootstrapAdminEmail)
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		password := cfg.BootstrapAdminPassword
+		generated := password == ""
+		if generated {
+			if password, err = utils.GenerateRandomString(24); err != nil {
+				return err
+			}
+		}
+		hashedPassword, err := utils.HashPassword(password)
+		if err != nil {
+			return err
+		}
+		admin = &models.User{
+			Email:         cfg.BootstrapAdminEmail,
+			Password:      hashedPassword,
+			Name:          "Administrator",
+			EmailVerified: true,
+			Roles:         []string{models.RoleAdmin},
+			// A generated password has been printed, so it is replaced at
+			// first login
+			PasswordResetRequired: generated,
+		}
+		if err := userRepo.Create(ctx, admin); errors.Is(err, repository.ErrDuplicate) {
+			log.Println("Bootstrap: admin user created by another instance")
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("creating admin user: %w", err)
+		}
+		if generated {
+			fmt.Fprintf(out, "Bootstrap: created admin user %s with password %s (change it at first login)\n", admin.Email, password)
+		} else {
+			fmt.Fprintf(out, "Bootstrap: created admin user %s with the password from BOOTSTRAP_ADMIN_PASSWORD\n", admin.Email)
+		}
+	case err != nil:
+		return fmt.Errorf("looking up admin user: %w", err)
+	case !admin.HasRole(models.RoleAdmin):
+		if err := userRepo.AddRole(ctx, admin.ID, models.RoleAdmin); err != nil {
+			return fmt.Errorf("granting admin role: %w", err)
+		}
+		log.Printf("Bootstrap: granted the admin role to existing user %s", admin.Email)
+	}
+
+	_, err = clientRepo.FindByClientID(ctx, cfg.BootstrapClientID)
+	if !errors.Is(err, repository.ErrNotFound) {
+		return err
+	}
+	secret, err := utils.GenerateRandomString(64)
+	if err != nil {
+		return err
+	}
+	client := &models.Client{
+		ClientID:      cfg.BootstrapClientID,
+		ClientSecret:  secret,
+		OwnerUserID:   admin.ID,
+		Name:          "Admin Console",
+		RedirectURIs:  cfg.BootstrapRedirectURIs,
+		AllowedScopes: []string{"openid", "profile", "email", handlers.AdminScope},
+		FirstParty:    true,
+	}
+	if err := clientRepo.Create(ctx, client); errors.Is(err, repository.ErrDuplicate) {
+		log.Println("Bootstrap: admin client created by another instance")
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("creating admin client: %w", err)
+	}
+	fmt.Fprintf(out, "Bootstrap: created admin client %s with secret %s\n", client.ClientID, secret)
+	return nil
+}
@@ -0,0 +1,79 @@
+//go:build integration
+// +build integration
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"oauth2-server/config"
+	"oauth2-server/models"
+	"oauth2-server/repository"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestBootstrap(t *testing.T) {
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI("mongodb://localhost:27017"))
+	if err != nil {
+		t.Skip("MongoDB not available, skipping integration test")
+		return
+	}
+	defer client.Disconnect(ctx)
+
+	db := client.Database("oauth2_test_bootstrap")
+	defer db.Drop(ctx)
+
+	userRepo := repository.NewUserRepository(db)
+	clientRepo := repository.NewClientRepository(db)
+	cfg := &config.Config{
+		BootstrapAdminEmail:   "admin@example.com",
+		BootstrapClientID:     "admin-console",
+		BootstrapRedirectURIs: []string{"http://localhost:3000/callback"},
+	}
+
+	var out bytes.Buffer
+	if err := bootstrap(ctx, userRepo, clientRepo, cfg, &out); err != nil {
+		t.Fatalf("Bootstrap failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "with password") || !strings.Contains(out.String(), "with secret") {
+		t.Errorf("Expected the credentials to be printed, got %q", out.String())
+	}
+
+	admin, err := userRepo.FindByEmail(ctx, "admin@example.com")
+	if err != nil || !admin.HasRole(models.RoleAdmin) || !admin.PasswordResetRequired {
+		t.Fatalf("Expected an admin who must reset the generated password, got %+v, %v", admin, err)
+	}
+	adminClient, err := clientRepo.FindByClientID(ctx, "admin-console")
+	if err != nil || adminClient.OwnerUserID != admin.ID || adminClient.ClientSecret == "" || !adminClient.FirstParty {
+		t.Fatalf("Expected the admin client, got %+v, %v", adminClient, err)
+	}
+
+	// A second run creates nothing and prints no credentials
+	out.Reset()
+	if err := bootstrap(ctx, userRepo, clientRepo, cfg, &out); err != nil {
+		t.Fatalf("Second bootstrap failed: %v", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("Expected no output on a second run, got %q", out.String())
+	}
+	again, _ := clientRepo.FindByClientID(ctx, "admin-console")
+	if again.ClientSecret != adminClient.ClientSecret {
+		t.Error("Expected the admin client secret to be kept")
+	}
+
+	// An existing user named as the admin is promoted
+	userRepo.Create(ctx, &models.User{Email: "ops@example.com", Name: "Ops"})
+	cfg.BootstrapAdminEmail = "ops@example.com"
+	if err := bootstrap(ctx, userRepo, clientRepo, cfg, &out); err != nil {
+		t.Fatalf("Bootstrap with an existing user failed: %v", err)
+	}
+	ops, _ := userRepo.FindByEmail(ctx, "ops@example.com")
+	if !ops.HasRole(models.RoleAdmin) {
+		t.Error("Expected the existing user to be granted the admin role")
+	}
+}
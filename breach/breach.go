@@ -0,0 +1,79 @@
+package breach
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// List is a set of passwords known from data breaches, kept as SHA-1 hashes
+type List struct {
+	hashes map[string]struct{}
+}
+
+// New builds a list from plain passwords
+func New(passwords ...string) *List {
+	list := &List{hashes: make(map[string]struct{}, len(passwords))}
+	for _, password := range passwords {
+		list.hashes[hash(password)] = struct{}{}
+	}
+	return list
+}
+
+// LoadFile reads a file with one password per line. Lines of 40 hex digits,
+// optionally followed by ":count" as in the Have I Been Pwned downloads, are
+// SHA-1 hashes of the password; anything else is the password itself. Blank
+// lines and lines starting with # are skipped.
+func LoadFile(path string) (*List, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	list := New()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if sum, _, _ := strings.Cut(line, ":"); isSHA1(sum) {
+			list.hashes[strings.ToUpper(sum)] = struct{}{}
+			continue
+		}
+		list.hashes[hash(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("invalid breach list: %w", err)
+	}
+	return list, nil
+}
+
+// Len is the number of passwords on the list
+func (l *List) Len() int {
+	return len(l.hashes)
+}
+
+// Breached reports whether the password is on the list
+func (l *List) Breached(ctx context.Context, password string) (bool, error) {
+	_, ok := l.hashes[hash(password)]
+	return ok, nil
+}
+
+func hash(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func isSHA1(s string) bool {
+	if len(s) != 2*sha1.Size {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
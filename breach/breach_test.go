@@ -0,0 +1,41 @@
+package breach
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	// 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8 is SHA-1("password")
+	os.WriteFile(path, []byte(`# common passwords
+5baa61e4c9b93f3f0682250b6cf8331b7ee68fd8:9545824
+
+letmein
+`), 0o600)
+
+	list, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+	if list.Len() != 2 {
+		t.Errorf("Expected 2 passwords, got %d", list.Len())
+	}
+
+	tests := []struct {
+		password string
+		want     bool
+	}{
+		{"password", true},
+		{"letmein", true},
+		{"# common passwords", false},
+		{"correct horse battery staple", false},
+	}
+	for _, tt := range tests {
+		if got, _ := list.Breached(context.Background(), tt.password); got != tt.want {
+			t.Errorf("Breached(%q) = %v, want %v", tt.password, got, tt.want)
+		}
+	}
+}
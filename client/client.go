@@ -0,0 +1,204 @@
+// Package client is a relying party library for applications written in Go
+// that sign users in with this server. It discovers the server's endpoints,
+// builds authorization URLs with PKCE, redeems and refreshes tokens,
+// verifies ID tokens against the server's JWKS and calls UserInfo.
+//
+// A web application creates one Client at startup:
+//
+//	rp, err := client.New(ctx, client.Config{
+//		Issuer:       "https://auth.example.com",
+//		ClientID:     "my-app",
+//		ClientSecret: os.Getenv("CLIENT_SECRET"),
+//		RedirectURL:  "https://app.example.com/callback",
+//	})
+//
+// then, for each sign in, stores a new AuthRequest in the user's session,
+// redirects to AuthCodeURL and completes the sign in with Callback.
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+// Config describes the relying party
+type Config struct {
+	// Issuer is the server's issuer URL; its endpoints are discovered from
+	// Issuer/.well-known/openid-configuration
+	Issuer       string
+	ClientID     string
+	ClientSecret string // empty for public clients, which rely on PKCE
+	RedirectURL  string
+	// Scopes requested by AuthCodeURL; "openid", "profile" and "email" when
+	// empty
+	Scopes []string
+	// HTTPClient makes the requests to the server; a client with a 10 second
+	// timeout when nil
+	HTTPClient *http.Client
+}
+
+// Metadata is the part of the server's OpenID configuration the client uses
+type Metadata struct {
+	Issuer                        string   `json:"issuer"`
+	AuthorizationEndpoint         string   `json:"authorization_endpoint"`
+	TokenEndpoint                 string   `json:"token_endpoint"`
+	UserInfoEndpoint              string   `json:"userinfo_endpoint"`
+	JWKSURI                       string   `json:"jwks_uri"`
+	ScopesSupported               []string `json:"scopes_supported"`
+	CodeChallengeMethodsSupported []string `json:"code_challenge_methods_supported"`
+}
+
+// Client talks to one server as one registered client. It is safe for
+// concurrent use.
+type Client struct {
+	config   Config
+	metadata Metadata
+	http     *http.Client
+
+	mu   sync.Mutex
+	keys *jose.JSONWebKeySet
+}
+
+// New discovers the issuer's endpoints. The discovered issuer must match
+// config.Issuer exactly.
+func New(ctx context.Context, config Config) (*Client, error) {
+	if config.Issuer == "" || config.ClientID == "" {
+		return nil, fmt.Errorf("client: issuer and client ID are required")
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "profile", "email"}
+	}
+	c := &Client{config: config, http: config.HTTPClient}
+	if c.http == nil {
+		c.http = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimSuffix(config.Issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.do(req, &c.metadata); err != nil {
+		return nil, fmt.Errorf("client: discovery failed: %w", err)
+	}
+	if c.metadata.Issuer != config.Issuer {
+		return nil, fmt.Errorf("client: discovery returned issuer %q", c.metadata.Issuer)
+	}
+	if c.metadata.AuthorizationEndpoint == "" || c.metadata.TokenEndpoint == "" || c.metadata.JWKSURI == "" {
+		return nil, fmt.Errorf("client: discovery document is missing endpoints")
+	}
+	return c, nil
+}
+
+// Metadata returns the discovered server metadata
+func (c *Client) Metadata() Metadata {
+	return c.metadata
+}
+
+// AuthRequest holds the secrets of one authorization request. Keep it, for
+// example in the user's session, until the callback and use it only once.
+type AuthRequest struct {
+	State        string `json:"state"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"code_verifier"`
+}
+
+// NewAuthRequest generates a random state, nonce and PKCE code verifier
+func NewAuthRequest() (*AuthRequest, error) {
+	var values [3]string
+	for i := range values {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		values[i] = base64.RawURLEncoding.EncodeToString(b)
+	}
+	return &AuthRequest{State: values[0], Nonce: values[1], CodeVerifier: values[2]}, nil
+}
+
+// CodeChallenge is the S256 PKCE challenge of the request's code verifier
+func (r *AuthRequest) CodeChallenge() string {
+	sum := sha256.Sum256([]byte(r.CodeVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL returns the URL to send the user to for an authorization code
+// flow with PKCE. params adds or overrides request parameters such as prompt
+// or login_hint.
+func (c *Client) AuthCodeURL(req *AuthRequest, params url.Values) string {
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {c.config.ClientID},
+		"redirect_uri":          {c.config.RedirectURL},
+		"scope":                 {strings.Join(c.config.Scopes, " ")},
+		"state":                 {req.State},
+		"nonce":                 {req.Nonce},
+		"code_challenge":        {req.CodeChallenge()},
+		"code_challenge_method": {"S256"},
+	}
+	for name, values := range params {
+		query[name] = values
+	}
+
+	separator := "?"
+	if strings.Contains(c.metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return c.metadata.AuthorizationEndpoint + separator + query.Encode()
+}
+
+// Error is an error response from the server, such as invalid_grant for a
+// refresh token that has been revoked
+type Error struct {
+	StatusCode  int    `json:"-"`
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *Error) Error() string {
+	if e.Description != "" {
+		return fmt.Sprintf("%s: %s", e.Code, e.Description)
+	}
+	return e.Code
+}
+
+// do sends req and decodes a successful JSON response into v. Error
+// responses are returned as *Error when the server described them.
+func (c *Client) do(req *http.Request, v interface{}) error {
+	if req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		oauthErr := &Error{StatusCode: resp.StatusCode}
+		if json.Unmarshal(body, oauthErr) == nil && oauthErr.Code != "" {
+			return oauthErr
+		}
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	if raw, ok := v.(*[]byte); ok {
+		*raw = body
+		return nil
+	}
+	return json.Unmarshal(body, v)
+}
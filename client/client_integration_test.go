@@ -0,0 +1,142 @@
+//go:build integration
+// +build integration
+
+package client_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"oauth2-server/client"
+	"oauth2-server/config"
+	"oauth2-server/handlers"
+	"oauth2-server/models"
+	"oauth2-server/repository"
+	"oauth2-server/utils"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TestClientAgainstServer runs the client against the server's discovery,
+// JWKS, token and UserInfo handlers. The authorization code is stored
+// directly, as the login and consent pages need a browser.
+func TestClientAgainstServer(t *testing.T) {
+	ctx := context.Background()
+	mongoClient, err := mongo.Connect(ctx, options.Client().ApplyURI("mongodb://localhost:27017"))
+	if err != nil {
+		t.Skip("MongoDB not available, skipping integration test")
+		return
+	}
+	defer mongoClient.Disconnect(ctx)
+
+	db := mongoClient.Database("oauth2_test_client_sdk")
+	defer db.Drop(ctx)
+
+	privateKey, publicKey, err := utils.LoadTestKeys()
+	if err != nil {
+		t.Fatalf("Failed to load test keys: %v", err)
+	}
+	cfg := &config.Config{
+		PrivateKey:         privateKey,
+		PublicKey:          publicKey,
+		AccessTokenExpiry:  3600,
+		RefreshTokenExpiry: 86400,
+	}
+
+	userRepo := repository.NewUserRepository(db)
+	clientRepo := repository.NewClientRepository(db)
+	authCodeRepo := repository.NewAuthCodeRepository(db)
+	oauthHandler := handlers.NewOAuthHandler(userRepo, clientRepo, authCodeRepo,
+		repository.NewAuthorizationRequestRepository(db), repository.NewUserConsentRepository(db), repository.NewSSOSessionRepository(db), nil, nil, nil, nil, nil, nil, cfg)
+
+	router := mux.NewRouter()
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	originalIssuer := utils.TokenIssuer
+	utils.TokenIssuer = server.URL
+	defer func() { utils.TokenIssuer = originalIssuer }()
+
+	discovery := handlers.NewDiscoveryHandler(server.URL, utils.GlobalScopeRegistry, utils.SigningKeyID, repository.NewMetadataRepository(db), nil, 0, cfg, oauthHandler.GrantTypes())
+	jwks := handlers.NewJWKSHandler(publicKey, utils.SigningKeyID, nil, "", 0)
+	router.HandleFunc("/.well-known/openid-configuration", discovery.WellKnown).Methods("GET")
+	router.HandleFunc("/.well-known/jwks.json", jwks.JWKS).Methods("GET")
+	router.HandleFunc("/oauth/token", oauthHandler.Token).Methods("POST")
+	router.HandleFunc("/oauth/userinfo", oauthHandler.UserInfo).Methods("GET")
+
+	user := &models.User{ID: "sdk-user", Email: "sdk@example.com", Name: "SDK User", EmailVerified: true, CreatedAt: time.Now()}
+	if err := userRepo.Create(ctx, user); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if err := clientRepo.Create(ctx, &models.Client{
+		ClientID:      "sdk-client",
+		ClientSecret:  "sdk-secret",
+		Name:          "SDK App",
+		RedirectURIs:  []string{"http://localhost:3000/callback"},
+		AllowedScopes: []string{"openid", "profile", "email"},
+	}); err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	rp, err := client.New(ctx, client.Config{
+		Issuer:       server.URL,
+		ClientID:     "sdk-client",
+		ClientSecret: "sdk-secret",
+		RedirectURL:  "http://localhost:3000/callback",
+	})
+	if err != nil {
+		t.Fatalf("Discovery failed: %v", err)
+	}
+
+	req, err := client.NewAuthRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := authCodeRepo.Create(ctx, &models.AuthorizationCode{
+		Code:            "sdk-code",
+		ClientID:        "sdk-client",
+		UserID:          user.ID,
+		RedirectURI:     "http://localhost:3000/callback",
+		Scope:           "openid profile email",
+		Nonce:           req.Nonce,
+		CodeChallenge:   req.CodeChallenge(),
+		ChallengeMethod: "S256",
+		ExpiresAt:       time.Now().Add(5 * time.Minute),
+		CreatedAt:       time.Now(),
+	}); err != nil {
+		t.Fatalf("Failed to create authorization code: %v", err)
+	}
+
+	token, err := rp.Callback(ctx, url.Values{"code": {"sdk-code"}, "state": {req.State}}, req)
+	if err != nil {
+		t.Fatalf("Callback failed: %v", err)
+	}
+	if token.IDTokenClaims.Subject != user.ID || token.IDTokenClaims.String("email") != user.Email {
+		t.Errorf("Unexpected ID token claims %+v", token.IDTokenClaims)
+	}
+
+	claims, err := rp.UserInfo(ctx, token.AccessToken)
+	if err != nil || claims["email"] != user.Email {
+		t.Errorf("Unexpected UserInfo %v, %v", claims, err)
+	}
+
+	refreshed, err := rp.Refresh(ctx, token.RefreshToken)
+	if err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+	if refreshed.AccessToken == "" || refreshed.RefreshToken == "" {
+		t.Errorf("Unexpected refreshed token %+v", refreshed)
+	}
+
+	// The code was redeemed, so redeeming it again is refused
+	var oauthErr *client.Error
+	if _, err := rp.Exchange(ctx, "sdk-code", req); !errors.As(err, &oauthErr) || oauthErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected a replayed code to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,274 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// fakeServer serves discovery, JWKS, token and userinfo endpoints. The token
+// endpoint accepts the code "good-code" with the challenge's verifier and
+// the refresh token "refresh-1".
+type fakeServer struct {
+	*httptest.Server
+	key       *rsa.PrivateKey
+	challenge string
+	nonce     string
+	audience  string
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{key: key, audience: "client-1"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                           s.URL,
+			"authorization_endpoint":           s.URL + "/oauth/authorize",
+			"token_endpoint":                   s.URL + "/oauth/token",
+			"userinfo_endpoint":                s.URL + "/oauth/userinfo",
+			"jwks_uri":                         s.URL + "/.well-known/jwks.json",
+			"code_challenge_methods_supported": []string{"S256"},
+		})
+	})
+	mux.HandleFunc("/.well-known/jwks.json", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &key.PublicKey, KeyID: "key-1", Algorithm: "RS256", Use: "sig"},
+		}})
+	})
+	mux.HandleFunc("/oauth/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("client_id") != "client-1" || r.PostFormValue("client_secret") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"invalid_client","error_description":"Invalid client credentials"}`))
+			return
+		}
+
+		var nonce string
+		switch r.PostFormValue("grant_type") {
+		case "authorization_code":
+			verifier := &AuthRequest{CodeVerifier: r.PostFormValue("code_verifier")}
+			if r.PostFormValue("code") != "good-code" || verifier.CodeChallenge() != s.challenge {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error":"invalid_grant","error_description":"Invalid authorization code"}`))
+				return
+			}
+			nonce = s.nonce
+		case "refresh_token":
+			if r.PostFormValue("refresh_token") != "refresh-1" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error":"invalid_grant","error_description":"Refresh token has been revoked"}`))
+				return
+			}
+		}
+
+		claims := jwt.MapClaims{
+			"iss":   s.URL,
+			"aud":   s.audience,
+			"sub":   "user-1",
+			"email": "user@example.com",
+			"exp":   time.Now().Add(time.Minute).Unix(),
+			"iat":   time.Now().Unix(),
+		}
+		if nonce != "" {
+			claims["nonce"] = nonce
+		}
+		response := map[string]interface{}{
+			"access_token": "access-1",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     s.sign(t, claims),
+		}
+		if r.PostFormValue("grant_type") == "authorization_code" {
+			response["refresh_token"] = "refresh-1"
+		}
+		json.NewEncoder(w).Encode(response)
+	})
+	mux.HandleFunc("/oauth/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("Authorization") {
+		case "Bearer access-1":
+			w.Write([]byte(`{"sub":"user-1","email":"user@example.com"}`))
+		case "Bearer signed":
+			w.Header().Set("Content-Type", "application/jwt")
+			w.Write([]byte(s.sign(t, jwt.MapClaims{"iss": s.URL, "aud": "client-1", "sub": "user-1"})))
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"invalid_token"}`))
+		}
+	})
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *fakeServer) sign(t *testing.T, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "key-1"
+	signed, err := token.SignedString(s.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func newTestClient(t *testing.T, server *fakeServer) *Client {
+	c, err := New(context.Background(), Config{
+		Issuer:       server.URL,
+		ClientID:     "client-1",
+		ClientSecret: "secret",
+		RedirectURL:  "https://app.example.com/callback",
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	return c
+}
+
+func TestNew_RejectsIssuerMismatch(t *testing.T) {
+	server := newFakeServer(t)
+	_, err := New(context.Background(), Config{Issuer: server.URL + "/other", ClientID: "client-1"})
+	if err == nil {
+		t.Error("Expected discovery from another issuer to fail")
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	server := newFakeServer(t)
+	c := newTestClient(t, server)
+
+	req, err := NewAuthRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(req.CodeVerifier) < 43 || req.State == req.Nonce {
+		t.Errorf("Unexpected auth request %+v", req)
+	}
+
+	location, _ := url.Parse(c.AuthCodeURL(req, url.Values{"prompt": {"login"}}))
+	query := location.Query()
+	if location.Path != "/oauth/authorize" {
+		t.Errorf("Expected the authorization endpoint, got %s", location)
+	}
+	for name, want := range map[string]string{
+		"response_type":         "code",
+		"client_id":             "client-1",
+		"redirect_uri":          "https://app.example.com/callback",
+		"scope":                 "openid profile email",
+		"state":                 req.State,
+		"nonce":                 req.Nonce,
+		"code_challenge":        req.CodeChallenge(),
+		"code_challenge_method": "S256",
+		"prompt":                "login",
+	} {
+		if got := query.Get(name); got != want {
+			t.Errorf("Expected %s=%q, got %q", name, want, got)
+		}
+	}
+}
+
+func TestCallback(t *testing.T) {
+	server := newFakeServer(t)
+	c := newTestClient(t, server)
+	req, _ := NewAuthRequest()
+	server.challenge, server.nonce = req.CodeChallenge(), req.Nonce
+	ctx := context.Background()
+
+	token, err := c.Callback(ctx, url.Values{"code": {"good-code"}, "state": {req.State}}, req)
+	if err != nil {
+		t.Fatalf("Callback failed: %v", err)
+	}
+	if token.AccessToken != "access-1" || token.RefreshToken != "refresh-1" || token.Expired(time.Minute) {
+		t.Errorf("Unexpected token %+v", token)
+	}
+	if token.IDTokenClaims.Subject != "user-1" || token.IDTokenClaims.String("email") != "user@example.com" {
+		t.Errorf("Unexpected ID token claims %+v", token.IDTokenClaims)
+	}
+
+	if _, err := c.Callback(ctx, url.Values{"code": {"good-code"}, "state": {"forged"}}, req); !errors.Is(err, ErrStateMismatch) {
+		t.Errorf("Expected a state mismatch, got %v", err)
+	}
+
+	var oauthErr *Error
+	_, err = c.Callback(ctx, url.Values{"error": {"access_denied"}, "state": {req.State}}, req)
+	if !errors.As(err, &oauthErr) || oauthErr.Code != "access_denied" {
+		t.Errorf("Expected access_denied, got %v", err)
+	}
+
+	other, _ := NewAuthRequest()
+	_, err = c.Exchange(ctx, "good-code", other)
+	if !errors.As(err, &oauthErr) || oauthErr.Code != "invalid_grant" || oauthErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected invalid_grant for a wrong code verifier, got %v", err)
+	}
+}
+
+func TestExchange_VerifiesIDToken(t *testing.T) {
+	server := newFakeServer(t)
+	c := newTestClient(t, server)
+	req, _ := NewAuthRequest()
+	server.challenge = req.CodeChallenge()
+	ctx := context.Background()
+
+	server.nonce = "replayed"
+	if _, err := c.Exchange(ctx, "good-code", req); err == nil || !strings.Contains(err.Error(), "nonce") {
+		t.Errorf("Expected a nonce mismatch, got %v", err)
+	}
+
+	server.nonce, server.audience = req.Nonce, "client-2"
+	if _, err := c.Exchange(ctx, "good-code", req); err == nil {
+		t.Error("Expected an ID token issued to another client to fail")
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	server := newFakeServer(t)
+	c := newTestClient(t, server)
+	ctx := context.Background()
+
+	token, err := c.Refresh(ctx, "refresh-1")
+	if err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+	if token.RefreshToken != "refresh-1" || token.IDTokenClaims.Subject != "user-1" {
+		t.Errorf("Unexpected refreshed token %+v", token)
+	}
+
+	var oauthErr *Error
+	if _, err := c.Refresh(ctx, "revoked"); !errors.As(err, &oauthErr) || oauthErr.Code != "invalid_grant" {
+		t.Errorf("Expected invalid_grant, got %v", err)
+	}
+}
+
+func TestUserInfo(t *testing.T) {
+	server := newFakeServer(t)
+	c := newTestClient(t, server)
+	ctx := context.Background()
+
+	claims, err := c.UserInfo(ctx, "access-1")
+	if err != nil || claims["email"] != "user@example.com" {
+		t.Errorf("Unexpected UserInfo %v, %v", claims, err)
+	}
+
+	claims, err = c.UserInfo(ctx, "signed")
+	if err != nil || claims["sub"] != "user-1" {
+		t.Errorf("Unexpected signed UserInfo %v, %v", claims, err)
+	}
+
+	var oauthErr *Error
+	if _, err := c.UserInfo(ctx, "expired"); !errors.As(err, &oauthErr) || oauthErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected a 401, got %v", err)
+	}
+}
@@ -0,0 +1,154 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// IDTokenClaims are the verified claims of an ID token
+type IDTokenClaims struct {
+	Subject  string
+	Nonce    string
+	Expiry   time.Time
+	IssuedAt time.Time
+	// AuthTime is when the user authenticated; zero when not sent
+	AuthTime time.Time
+
+	// Claims holds every claim, including the user claims the granted
+	// scopes release such as email and name
+	Claims map[string]interface{}
+}
+
+// String returns a string claim, or "" when it is absent
+func (c *IDTokenClaims) String(name string) string {
+	s, _ := c.Claims[name].(string)
+	return s
+}
+
+// VerifyIDToken checks that an ID token is signed by a key in the server's
+// JWKS, was issued by the server to this client and has not expired. A
+// non-empty nonce must match the token's nonce claim.
+func (c *Client) VerifyIDToken(ctx context.Context, idToken, nonce string) (*IDTokenClaims, error) {
+	if strings.Count(idToken, ".") == 4 {
+		return nil, errors.New("client: encrypted ID tokens are not supported")
+	}
+	claims, err := c.verify(ctx, idToken, jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, fmt.Errorf("client: invalid ID token: %w", err)
+	}
+	if nonce != "" {
+		if claimNonce, _ := claims["nonce"].(string); claimNonce != nonce {
+			return nil, errors.New("client: invalid ID token: nonce mismatch")
+		}
+	}
+
+	result := &IDTokenClaims{Claims: claims}
+	result.Subject, _ = claims["sub"].(string)
+	result.Nonce, _ = claims["nonce"].(string)
+	result.Expiry = numericTime(claims["exp"])
+	result.IssuedAt = numericTime(claims["iat"])
+	result.AuthTime = numericTime(claims["auth_time"])
+	if result.Subject == "" {
+		return nil, errors.New("client: invalid ID token: no subject")
+	}
+	return result, nil
+}
+
+// UserInfo returns the claims the access token's scopes release. Clients
+// registered for signed UserInfo responses get the signature verified;
+// encrypted responses are not supported.
+func (c *Client) UserInfo(ctx context.Context, accessToken string) (map[string]interface{}, error) {
+	if c.metadata.UserInfoEndpoint == "" {
+		return nil, errors.New("client: the server has no UserInfo endpoint")
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", c.metadata.UserInfoEndpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json, application/jwt")
+
+	var body []byte
+	if err := c.do(req, &body); err != nil {
+		return nil, fmt.Errorf("client: userinfo request failed: %w", err)
+	}
+
+	response := strings.TrimSpace(string(body))
+	if !strings.HasPrefix(response, "{") {
+		if strings.Count(response, ".") != 2 {
+			return nil, errors.New("client: encrypted UserInfo responses are not supported")
+		}
+		claims, err := c.verify(ctx, response)
+		if err != nil {
+			return nil, fmt.Errorf("client: invalid UserInfo response: %w", err)
+		}
+		return claims, nil
+	}
+
+	var claims map[string]interface{}
+	if err := json.Unmarshal(body, &claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// verify checks a JWT's signature, issuer and audience
+func (c *Client) verify(ctx context.Context, token string, opts ...jwt.ParserOption) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	opts = append(opts,
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "ES256", "ES384"}),
+		jwt.WithIssuer(c.metadata.Issuer),
+		jwt.WithAudience(c.config.ClientID),
+	)
+	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return c.signingKey(ctx, kid)
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// signingKey returns the server key with the given ID, fetching the key set
+// again once when the key is not known yet, so a rotated key is picked up
+func (c *Client) signingKey(ctx context.Context, kid string) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for attempt := 0; attempt < 2; attempt++ {
+		if c.keys == nil || attempt > 0 {
+			req, err := http.NewRequestWithContext(ctx, "GET", c.metadata.JWKSURI, nil)
+			if err != nil {
+				return nil, err
+			}
+			var keys jose.JSONWebKeySet
+			if err := c.do(req, &keys); err != nil {
+				return nil, fmt.Errorf("fetching JWKS: %w", err)
+			}
+			c.keys = &keys
+		}
+		for _, key := range c.keys.Keys {
+			if (kid == "" || key.KeyID == kid) && key.Use != "enc" {
+				return key.Key, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// numericTime converts a NumericDate claim, zero when absent
+func numericTime(v interface{}) time.Time {
+	if seconds, ok := v.(float64); ok {
+		return time.Unix(int64(seconds), 0)
+	}
+	return time.Time{}
+}
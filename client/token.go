@@ -0,0 +1,134 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrStateMismatch means the callback's state is not the one sent with the
+// authorization request, so the response may have been forged
+var ErrStateMismatch = errors.New("client: state mismatch")
+
+// Token is a token response. IDTokenClaims holds the verified claims of
+// IDToken when the server returned one.
+type Token struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+
+	// Expiry is when the access token expires
+	Expiry        time.Time      `json:"-"`
+	IDTokenClaims *IDTokenClaims `json:"-"`
+}
+
+// Expired reports whether the access token has expired, or will within
+// leeway
+func (t *Token) Expired(leeway time.Duration) bool {
+	return !t.Expiry.IsZero() && time.Now().Add(leeway).After(t.Expiry)
+}
+
+// Callback completes a sign in from the query of the request to the redirect
+// URL. An error response from the authorization endpoint, such as
+// access_denied, is returned as *Error.
+func (c *Client) Callback(ctx context.Context, query url.Values, req *AuthRequest) (*Token, error) {
+	if code := query.Get("error"); code != "" {
+		return nil, &Error{Code: code, Description: query.Get("error_description")}
+	}
+	if query.Get("state") != req.State {
+		return nil, ErrStateMismatch
+	}
+	if query.Get("code") == "" {
+		return nil, errors.New("client: no authorization code in the callback")
+	}
+	return c.Exchange(ctx, query.Get("code"), req)
+}
+
+// Exchange redeems an authorization code issued for req. When the openid
+// scope was requested the response must carry an ID token issued to this
+// client with req's nonce.
+func (c *Client) Exchange(ctx context.Context, code string, req *AuthRequest) (*Token, error) {
+	token, err := c.token(ctx, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {c.config.RedirectURL},
+		"code_verifier": {req.CodeVerifier},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if token.IDToken == "" {
+		if slices.Contains(c.config.Scopes, "openid") {
+			return nil, errors.New("client: no ID token in the token response")
+		}
+		return token, nil
+	}
+	if token.IDTokenClaims, err = c.VerifyIDToken(ctx, token.IDToken, req.Nonce); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// Refresh uses a refresh token to get new tokens. scope narrows the granted
+// scopes and is left out when empty. The response keeps refreshToken when
+// the server did not rotate it.
+func (c *Client) Refresh(ctx context.Context, refreshToken string, scope ...string) (*Token, error) {
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+	}
+	if len(scope) > 0 {
+		form.Set("scope", strings.Join(scope, " "))
+	}
+	token, err := c.token(ctx, form)
+	if err != nil {
+		return nil, err
+	}
+	if token.RefreshToken == "" {
+		token.RefreshToken = refreshToken
+	}
+
+	// ID tokens issued on refresh carry no nonce
+	if token.IDToken != "" {
+		if token.IDTokenClaims, err = c.VerifyIDToken(ctx, token.IDToken, ""); err != nil {
+			return nil, err
+		}
+	}
+	return token, nil
+}
+
+// token posts a grant to the token endpoint, authenticating with
+// client_secret_post
+func (c *Client) token(ctx context.Context, form url.Values) (*Token, error) {
+	form.Set("client_id", c.config.ClientID)
+	if c.config.ClientSecret != "" {
+		form.Set("client_secret", c.config.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var token Token
+	if err := c.do(req, &token); err != nil {
+		return nil, fmt.Errorf("client: token request failed: %w", err)
+	}
+	if token.AccessToken == "" {
+		return nil, errors.New("client: no access token in the token response")
+	}
+	if token.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+	return &token, nil
+}
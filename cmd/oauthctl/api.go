@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// apiClient calls the server's admin, developer, SCIM and discovery APIs
+type apiClient struct {
+	baseURL   string
+	token     string
+	scimToken string
+	http      *http.Client
+}
+
+// apiError is an error response from the server
+type apiError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// call sends body as JSON and writes the response to out, indented
+func (c *apiClient) call(method, path string, body interface{}, out io.Writer) error {
+	response, err := c.send(method, path, body)
+	if err != nil {
+		return err
+	}
+	if len(response) == 0 {
+		return nil
+	}
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, response, "", "  "); err != nil {
+		_, err = out.Write(response)
+		return err
+	}
+	indented.WriteByte('\n')
+	_, err = indented.WriteTo(out)
+	return err
+}
+
+// send sends body as JSON and returns the response body. SCIM paths are
+// authenticated with the SCIM token, everything else with the admin token.
+func (c *apiClient) send(method, path string, body interface{}) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	token, tokenFlag := c.token, "-token"
+	if strings.HasPrefix(path, "/scim/") {
+		token, tokenFlag = c.scimToken, "-scim-token"
+	}
+	if !strings.HasPrefix(path, "/.well-known/") {
+		if token == "" {
+			return nil, fmt.Errorf("%s %s needs %s", method, path, tokenFlag)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	httpClient := c.http
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, &apiError{StatusCode: resp.StatusCode, Message: errorMessage(data)}
+	}
+	return data, nil
+}
+
+// errorMessage extracts the description of an OAuth or SCIM error body
+func errorMessage(data []byte) string {
+	var body struct {
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+		Detail      string `json:"detail"`
+	}
+	if json.Unmarshal(data, &body) != nil {
+		return strings.TrimSpace(string(data))
+	}
+	switch {
+	case body.Detail != "":
+		return body.Detail
+	case body.Description != "":
+		return body.Error + ": " + body.Description
+	}
+	return body.Error
+}
+
+// isConflict reports whether err is a 409 response
+func isConflict(err error) bool {
+	var apiErr *apiError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusConflict
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"oauth2-server/handlers"
+	"oauth2-server/utils"
+	"os"
+)
+
+// createClient registers a client owned by the admin whose token is used
+func createClient(api *apiClient, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("clients create", flag.ContinueOnError)
+	name := flags.String("name", "", "client name")
+	public := flags.Bool("public", false, "register a public client that authenticates with PKCE only")
+	var redirectURIs, scopes stringList
+	flags.Var(&redirectURIs, "redirect-uri", "allowed redirect URI (repeatable)")
+	flags.Var(&scopes, "scope", "allowed scope (repeatable, default: openid profile email)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *name == "" || len(redirectURIs) == 0 {
+		return errors.New("clients create needs -name and -redirect-uri")
+	}
+
+	return api.call("POST", "/developer/clients", handlers.ClientRequest{
+		Name:          *name,
+		RedirectURIs:  redirectURIs,
+		AllowedScopes: scopes,
+		IsPublic:      *public,
+	}, out)
+}
+
+// newUser is a user to provision, as given to users create or in a seed file
+type newUser struct {
+	Email    string `json:"email"`
+	Name     string `json:"name,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+func (u newUser) scimUser() handlers.SCIMUser {
+	return handlers.SCIMUser{
+		Schemas:     []string{handlers.SCIMUserSchema},
+		UserName:    u.Email,
+		DisplayName: u.Name,
+		Emails:      []handlers.SCIMMultiValue{{Value: u.Email, Primary: true}},
+		Password:    u.Password,
+	}
+}
+
+// createUser provisions a user through SCIM
+func createUser(api *apiClient, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("users create", flag.ContinueOnError)
+	var user newUser
+	flags.StringVar(&user.Email, "email", "", "email address, the user's login")
+	flags.StringVar(&user.Name, "name", "", "display name")
+	flags.StringVar(&user.Password, "password", "", "initial password (default: none, for federated login)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if user.Email == "" {
+		return errors.New("users create needs -email")
+	}
+	return api.call("POST", "/scim/v2/Users", user.scimUser(), out)
+}
+
+// seedUsers provisions the users in a JSON array file. Users that already
+// exist are skipped, so a seed file can be applied more than once.
+func seedUsers(api *apiClient, file string, out io.Writer) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var users []newUser
+	if err := json.Unmarshal(data, &users); err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+
+	var created, skipped int
+	for _, user := range users {
+		if user.Email == "" {
+			return errors.New("every seeded user needs an email")
+		}
+		_, err := api.send("POST", "/scim/v2/Users", user.scimUser())
+		switch {
+		case isConflict(err):
+			skipped++
+		case err != nil:
+			return fmt.Errorf("%s: %w", user.Email, err)
+		default:
+			created++
+		}
+	}
+	fmt.Fprintf(out, "Created %d users, %d already existed\n", created, skipped)
+	return nil
+}
+
+// rotateKey generates a new signing key in the server's keys directory,
+// keeping the replaced key as .bak. The server signs with it and publishes
+// it from its next start; tokens signed with the old key stop verifying then.
+func rotateKey(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("keys rotate", flag.ContinueOnError)
+	dir := flags.String("dir", "keys", "the server's keys directory")
+	kid := flags.String("kid", "", "key ID to publish (default: the key thumbprint)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	privateKey, err := utils.GenerateRSAKeyPair(2048)
+	if err != nil {
+		return err
+	}
+	if *kid == "" {
+		*kid = utils.KeyThumbprint(&privateKey.PublicKey)
+	}
+	if err := utils.ReplaceSigningKey(*dir, privateKey, *kid); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Generated signing key with kid %s; restart the server to use it\n", *kid)
+	return nil
+}
@@ -0,0 +1,119 @@
+// Command oauthctl administers a running server through its HTTP APIs:
+// registering clients and rotating their secrets, listing and revoking a
+// user's sessions, provisioning users and printing the discovery document.
+// It also rotates the signing key in a server's keys directory.
+//
+//	export OAUTHCTL_URL=https://auth.example.com OAUTHCTL_TOKEN=<admin access token>
+//	oauthctl clients create -name "Billing" -redirect-uri https://billing.example.com/callback
+//	oauthctl sessions revoke <user_id>
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const usage = `Usage: oauthctl [-url URL] [-token TOKEN] [-scim-token TOKEN] <command> [arguments]
+
+Commands:
+  clients create -name NAME -redirect-uri URI [-scope SCOPE]... [-public]
+  clients rotate-secret CLIENT_ID
+  clients delete CLIENT_ID
+  sessions list USER_ID
+  sessions revoke USER_ID
+  users create -email EMAIL [-name NAME] [-password PASSWORD]
+  users seed FILE
+  keys rotate [-dir DIR] [-kid KID]
+  discovery
+
+Client and session commands need an access token with the admin scope
+(-token or OAUTHCTL_TOKEN). User commands go through SCIM and need one of
+the server's SCIM_TOKENS (-scim-token or OAUTHCTL_SCIM_TOKEN). keys rotate
+works on the keys directory of the server host and takes effect when the
+server restarts.
+`
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "oauthctl:", err)
+		os.Exit(1)
+	}
+}
+
+// run parses the global flags and dispatches to a command, writing its
+// output to out
+func run(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("oauthctl", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(flags.Output(), usage) }
+	baseURL := flags.String("url", envOr("OAUTHCTL_URL", "http://localhost:8080"), "server URL")
+	token := flags.String("token", os.Getenv("OAUTHCTL_TOKEN"), "admin access token")
+	scimToken := flags.String("scim-token", os.Getenv("OAUTHCTL_SCIM_TOKEN"), "SCIM bearer token")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	api := &apiClient{baseURL: strings.TrimSuffix(*baseURL, "/"), token: *token, scimToken: *scimToken}
+	args = flags.Args()
+	if len(args) == 0 {
+		flags.Usage()
+		return errors.New("no command given")
+	}
+
+	var sub string
+	if len(args) > 1 {
+		sub = args[1]
+	}
+	switch command := args[0] + " " + sub; command {
+	case "clients create":
+		return createClient(api, args[2:], out)
+	case "clients rotate-secret":
+		return withID(args, func(id string) error { return api.call("POST", "/admin/clients/"+id+"/rotate-secret", nil, out) })
+	case "clients delete":
+		return withID(args, func(id string) error { return api.call("DELETE", "/admin/clients/"+id, nil, out) })
+	case "sessions list":
+		return withID(args, func(id string) error { return api.call("GET", "/admin/users/"+id+"/sessions", nil, out) })
+	case "sessions revoke":
+		return withID(args, func(id string) error { return api.call("POST", "/admin/users/"+id+"/revoke-sessions", nil, out) })
+	case "users create":
+		return createUser(api, args[2:], out)
+	case "users seed":
+		return withID(args, func(file string) error { return seedUsers(api, file, out) })
+	case "keys rotate":
+		return rotateKey(args[2:], out)
+	default:
+		if args[0] == "discovery" {
+			return api.call("GET", "/.well-known/openid-configuration", nil, out)
+		}
+		flags.Usage()
+		return fmt.Errorf("unknown command %q", strings.TrimSpace(command))
+	}
+}
+
+// withID runs fn with the single positional argument after the command
+func withID(args []string, fn func(string) error) error {
+	if len(args) != 3 || args[2] == "" {
+		return fmt.Errorf("%s %s takes exactly one argument", args[0], args[1])
+	}
+	return fn(args[2])
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// stringList is a flag that may be repeated
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
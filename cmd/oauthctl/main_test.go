@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"oauth2-server/handlers"
+	"oauth2-server/utils"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun_AdminCommands(t *testing.T) {
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.Method+" "+r.URL.Path+" "+r.Header.Get("Authorization"))
+		switch r.URL.Path {
+		case "/admin/clients/client-1/rotate-secret":
+			w.Write([]byte(`{"client_id":"client-1","client_secret":"new-secret"}`))
+		case "/admin/users/missing/sessions":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"not_found","error_description":"User not found"}`))
+		default:
+			w.WriteHeader(http.StatusTeapot)
+		}
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	if err := run([]string{"-url", server.URL, "-token", "admin-token", "clients", "rotate-secret", "client-1"}, &out); err != nil {
+		t.Fatalf("rotate-secret failed: %v", err)
+	}
+	if !strings.Contains(out.String(), `"client_secret": "new-secret"`) {
+		t.Errorf("Expected the indented response, got %s", out.String())
+	}
+	if calls[0] != "POST /admin/clients/client-1/rotate-secret Bearer admin-token" {
+		t.Errorf("Unexpected request %s", calls[0])
+	}
+
+	err := run([]string{"-url", server.URL, "-token", "admin-token", "sessions", "list", "missing"}, &out)
+	if err == nil || !strings.Contains(err.Error(), "User not found") {
+		t.Errorf("Expected the server's error, got %v", err)
+	}
+
+	if err := run([]string{"-url", server.URL, "-token", "", "sessions", "revoke", "u-1"}, &out); err == nil || len(calls) != 2 {
+		t.Errorf("Expected a missing token to fail before calling the server, got %v", err)
+	}
+	if err := run([]string{"-url", server.URL, "clients", "frobnicate"}, &out); err == nil {
+		t.Error("Expected an unknown command to fail")
+	}
+}
+
+func TestRun_SeedUsers(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var user handlers.SCIMUser
+		json.NewDecoder(r.Body).Decode(&user)
+		if r.Header.Get("Authorization") != "Bearer scim-token" || user.Schemas[0] != handlers.SCIMUserSchema {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if user.UserName == "existing@example.com" {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(`{"detail":"A user with this userName already exists","status":"409"}`))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	file := filepath.Join(t.TempDir(), "users.json")
+	os.WriteFile(file, []byte(`[{"email":"new@example.com","name":"New","password":"password123"},{"email":"existing@example.com"}]`), 0600)
+
+	var out bytes.Buffer
+	if err := run([]string{"-url", server.URL, "-scim-token", "scim-token", "users", "seed", file}, &out); err != nil {
+		t.Fatalf("seed failed: %v", err)
+	}
+	if out.String() != "Created 1 users, 1 already existed\n" {
+		t.Errorf("Unexpected output %q", out.String())
+	}
+}
+
+func TestRun_RotateKey(t *testing.T) {
+	dir := t.TempDir()
+	var out bytes.Buffer
+	if err := run([]string{"keys", "rotate", "-dir", dir, "-kid", "first"}, &out); err != nil {
+		t.Fatalf("rotate failed: %v", err)
+	}
+	first, err := utils.LoadPrivateKeyFromFile(filepath.Join(dir, utils.PrivateKeyFile))
+	if err != nil {
+		t.Fatalf("Expected a key to be written: %v", err)
+	}
+
+	if err := run([]string{"keys", "rotate", "-dir", dir}, &out); err != nil {
+		t.Fatalf("second rotate failed: %v", err)
+	}
+	backup, err := utils.LoadPrivateKeyFromFile(filepath.Join(dir, utils.PrivateKeyFile+".bak"))
+	if err != nil || !backup.Equal(first) {
+		t.Errorf("Expected the replaced key to be kept as .bak, got %v", err)
+	}
+	kid, _ := os.ReadFile(filepath.Join(dir, utils.KeyIDFile))
+	second, _ := utils.LoadPrivateKeyFromFile(filepath.Join(dir, utils.PrivateKeyFile))
+	if strings.TrimSpace(string(kid)) != utils.KeyThumbprint(&second.PublicKey) {
+		t.Errorf("Expected the thumbprint as kid, got %q", kid)
+	}
+}
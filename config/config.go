@@ -2,27 +2,441 @@ package config
 
 import (
 	"crypto/rsa"
+	"crypto/x509"
+	"oauth2-server/logger"
 	"os"
 	"strconv"
+	"strings"
 )
 
 type Config struct {
-	MongoURI            string
-	DatabaseName        string
-	PrivateKey          *rsa.PrivateKey
-	PublicKey           *rsa.PublicKey
-	ServerPort          string
-	AccessTokenExpiry   int64
-	RefreshTokenExpiry  int64
+	MongoURI           string
+	DatabaseName       string
+	PrivateKey         *rsa.PrivateKey
+	PublicKey          *rsa.PublicKey
+	EncryptionKey      *rsa.PrivateKey // JWE tokens are encrypted to this key, not the signing key
+	EncryptionKeyID    string
+	ServerPort         string
+	AccessTokenExpiry  int64
+	RefreshTokenExpiry int64
+	// AuthorizeDedupWindow is how many seconds an identical pending
+	// authorization request is reused instead of creating a new session
+	AuthorizeDedupWindow int64
+	// AutoRegisterOnLogin creates an account when an unknown email logs in.
+	// Only meant for demos; production should use the registration flow.
+	AutoRegisterOnLogin bool
+	// RequireEmailVerification blocks login until the address is confirmed
+	RequireEmailVerification bool
+	// EmailVerificationExpiry is the verification link lifetime in seconds
+	EmailVerificationExpiry int64
+	// RememberDeviceExpiry is how many seconds a browser remembered after
+	// MFA skips step-up challenges (0 = browsers are never remembered)
+	RememberDeviceExpiry int64
+	// RejectCodeIPMismatch refuses authorization codes redeemed from another
+	// IP address than the authorization request came from. Mismatches are
+	// always audited; confidential clients usually redeem from their own
+	// servers, so only enable this when clients redeem from the user's device.
+	RejectCodeIPMismatch bool
+	// RejectCodeEndedSession refuses authorization codes whose SSO session
+	// was logged out or revoked before redemption, so a code obtained in a
+	// fixated or hijacked session is useless once the user signs it out.
+	// Ended sessions are always audited.
+	RejectCodeEndedSession bool
+	// SSOIdleTimeout ends an SSO session after this many seconds without
+	// activity, independently of its absolute lifetime (0 = off)
+	SSOIdleTimeout int64
+	// SSOActivityInterval is the minimum number of seconds between writes of
+	// a session's last activity, so busy sessions do not write on every request
+	SSOActivityInterval int64
+	// RequireOfflineAccess issues refresh tokens on the authorization code
+	// grant only when the offline_access scope was granted. Off by default so
+	// existing clients keep receiving refresh tokens.
+	RequireOfflineAccess bool
+	// IssuerURL identifies this server in the iss claim of issued tokens and
+	// is the base of the discovery metadata. Tokens from any other issuer are
+	// rejected.
+	IssuerURL string
+	// PublicURL is the externally reachable base URL used in emailed links
+	// and the CLI activation page; it defaults to IssuerURL
+	PublicURL string
+	// LoginUIURL and ConsentUIURL replace the built-in login and consent
+	// pages with a custom UI, which gets a login_challenge or
+	// consent_challenge and completes it through the /api/auth endpoints
+	LoginUIURL   string
+	ConsentUIURL string
+	// ConsentPolicy is the default consent persistence policy:
+	// "permanent", "ttl" or "session" (clients may override it)
+	ConsentPolicy string
+	// ConsentTTLDays is how long a consent stored under the "ttl" policy
+	// stays valid. Clients may override it; ScopeConsentTTLDays sets shorter
+	// lifetimes for sensitive scopes (e.g. "phone=30,address=30").
+	ConsentTTLDays      int64
+	ScopeConsentTTLDays map[string]int64
+	// ConsentRenewalWindowDays re-prompts for consent this many days before
+	// it expires instead of auto-approving (0 disables)
+	ConsentRenewalWindowDays int64
+	// ScopeGrantTTLMinutes gives individual scopes their own short-lived
+	// grant inside a consent (e.g. "payment=15"); once it lapses the scope
+	// must be consented to again
+	ScopeGrantTTLMinutes map[string]int64
+	// DevMode re-parses templates from TemplateDir on every request and
+	// shows template errors in the response
+	DevMode     bool
+	TemplateDir string
+	// TemplateOverrideDir holds pages, named like the built-in ones (e.g.
+	// "login.html"), that replace the built-in login, registration, consent
+	// and account pages. TranslationsDir holds <locale>.json files adding
+	// to or replacing the built-in page and error message translations.
+	TemplateOverrideDir string
+	TranslationsDir     string
+	// ProductionMode requires confidential clients to register https
+	// redirect URIs and turns off wildcard redirect URI matching, which is
+	// only meant for development environments
+	ProductionMode bool
+	// StateSigningKey is the HMAC key for signed state values; derived from
+	// the RSA private key when empty. StateTTL is in seconds.
+	StateSigningKey string
+	StateTTL        int64
+	// AuditAnchorInterval is how often, in seconds, the audit log chain head
+	// is signed and stored as an anchor (0 disables periodic anchoring)
+	AuditAnchorInterval int64
+	// AuditExportFile and AuditExportURL are where the audit log is streamed
+	// to, either or both, as AuditExportFormat (jsonl or cef) every
+	// AuditExportInterval seconds. AuditExportAuthorization is sent as the
+	// Authorization header to the URL.
+	AuditExportFormat        string
+	AuditExportFile          string
+	AuditExportURL           string
+	AuditExportAuthorization string
+	AuditExportInterval      int64
+	// MailTransport delivers verification links, MFA codes and sign-in
+	// alerts: smtp through SMTPAddr, http through MailHTTPURL, or log, which
+	// only records that a message was sent and is refused in production
+	// mode. MailFrom is the sender address.
+	MailTransport         string
+	MailFrom              string
+	SMTPAddr              string
+	SMTPUsername          string
+	SMTPPassword          string
+	MailHTTPURL           string
+	MailHTTPAuthorization string
+	// ScopeReloadInterval is how often, in seconds, custom scopes are
+	// reloaded from the database to pick up changes from other instances
+	ScopeReloadInterval int64
+	// MetadataWebhooks are URLs sent a metadata_changed event when the
+	// discovery document's scopes, keys or endpoints change.
+	// MetadataCheckInterval is how often, in seconds, the document is checked
+	// for changes (0 checks only at startup and on discovery requests).
+	MetadataWebhooks      []string
+	MetadataCheckInterval int64
+	// MetadataCacheMaxAge is the Cache-Control max-age, in seconds, of the
+	// discovery document and JWKS
+	MetadataCacheMaxAge int64
+	// ServiceDocumentationURL, OPPolicyURI and OPTosURI are published in the
+	// discovery document when set
+	ServiceDocumentationURL string
+	OPPolicyURI             string
+	OPTosURI                string
+	// WebhookTimeout bounds, in seconds, each security event delivery.
+	// Failed deliveries are tried up to WebhookMaxAttempts times, waiting
+	// WebhookRetryBackoff seconds before the first retry and doubling it.
+	WebhookTimeout      int64
+	WebhookMaxAttempts  int64
+	WebhookRetryBackoff int64
+	// DBOperationTimeout bounds, in seconds, each database operation. Handlers
+	// also pass the request context, so operations stop early when the client
+	// disconnects.
+	DBOperationTimeout int64
+	// DBMaxPoolSize and DBMinPoolSize bound the open connections to MongoDB;
+	// DBMaxConnIdleTime closes, in seconds, connections idle for longer.
+	// Zero keeps the driver default.
+	DBMaxPoolSize     int64
+	DBMinPoolSize     int64
+	DBMaxConnIdleTime int64
+	// ClientCacheSize is how many clients are kept in memory, for at most
+	// ClientCacheTTL seconds, to answer lookups by client_id; 0 disables the
+	// cache
+	ClientCacheSize int64
+	ClientCacheTTL  int64
+	// TokenValidationCacheSize is how many /token/validate results are
+	// kept, for at most TokenValidationCacheTTL seconds; 0 disables the cache
+	TokenValidationCacheSize int64
+	TokenValidationCacheTTL  int64
+	// SecondaryReads lists the collections whose hot-path lookups read from
+	// replica set secondaries: "clients", "user_consents" and "access_tokens"
+	SecondaryReads []string
+	// VerifyIndexes explains the hot queries at startup and logs a warning
+	// for each one answered by a collection scan
+	VerifyIndexes bool
+	// ServerReadTimeout, ServerWriteTimeout and ServerIdleTimeout bound, in
+	// seconds, how long a connection may spend reading a request, writing a
+	// response and waiting between keep-alive requests
+	ServerReadTimeout  int64
+	ServerWriteTimeout int64
+	ServerIdleTimeout  int64
+	// ShutdownTimeout is how long, in seconds, in-flight requests may take to
+	// finish after SIGINT or SIGTERM before connections are closed
+	ShutdownTimeout int64
+	// TLSCertFile and TLSKeyFile make the server terminate TLS itself and
+	// request client certificates for mutual TLS (RFC 8705)
+	TLSCertFile string
+	TLSKeyFile  string
+	// MTLSClientCAFile lists the CAs trusted to issue certificates for
+	// tls_client_auth clients; main loads it into MTLSClientCAs
+	MTLSClientCAFile string
+	MTLSClientCAs    *x509.CertPool
+	// MTLSCertHeader is the header a TLS terminating proxy forwards the
+	// client certificate in. Only set it when the proxy overwrites the
+	// header on every request, or clients can forge certificates.
+	MTLSCertHeader string
+	// GRPCPort, when set, serves token validation and key set updates over
+	// gRPC on this port. GRPCTLSCertFile and GRPCTLSKeyFile enable TLS, and
+	// GRPCClientCAFile then requires callers to present a certificate issued
+	// by one of its CAs.
+	GRPCPort         string
+	GRPCTLSCertFile  string
+	GRPCTLSKeyFile   string
+	GRPCClientCAFile string
+	// CLILoginExpiry is how long, in seconds, a CLI login waits for approval
+	// at /activate; CLILoginInterval is the minimum polling interval
+	CLILoginExpiry   int64
+	CLILoginInterval int64
+	// BFFTokenExpiry is the lifetime, in seconds, of access tokens relayed
+	// to a backend-for-frontend for a downstream API
+	BFFTokenExpiry int64
+	// DPoPNonceLifetime is how long, in seconds, a server-issued DPoP nonce
+	// stays current; it is still accepted for one more lifetime after that
+	DPoPNonceLifetime int64
+	// JWKSCacheMaxAge is the longest, in seconds, a client's key set fetched
+	// from its jwks_uri is used before it is fetched again; a shorter
+	// Cache-Control max-age is honored. An unknown key ID fetches the set
+	// again at most once per JWKSRefreshInterval seconds. Each fetch waits
+	// JWKSFetchTimeout seconds and is tried JWKSFetchAttempts times.
+	JWKSCacheMaxAge     int64
+	JWKSRefreshInterval int64
+	JWKSFetchTimeout    int64
+	JWKSFetchAttempts   int64
+	// TokenPolicyFile holds the rules, a JSON array, that may deny or
+	// shrink a grant before a token is issued; empty allows every grant
+	TokenPolicyFile string
+	// IdentityProvidersFile lists, as a JSON array, the upstream OpenID
+	// Connect and OAuth 2.0 providers users can sign in with
+	IdentityProvidersFile string
+	// GeoIPFile maps networks to locations, as network,city,region,country
+	// CSV rows, to show where SSO sessions were started; empty disables it
+	GeoIPFile string
+	// SignInConfirmation holds sign-ins from an unfamiliar device, network
+	// or country until the user follows the link emailed to them
+	SignInConfirmation bool
+	// PasswordHashAlgorithm is bcrypt or argon2id; new passwords are hashed
+	// with it and the costs below, and weaker hashes are replaced at login
+	PasswordHashAlgorithm    string
+	PasswordBcryptCost       int64
+	PasswordArgon2Memory     int64
+	PasswordArgon2Iterations int64
+	PasswordArgon2Threads    int64
+	// PasswordMinLength is the shortest password accepted at registration
+	// and password reset; PasswordBreachListFile lists passwords known from
+	// breaches, which are refused
+	PasswordMinLength      int64
+	PasswordBreachListFile string
+	// SCIMTokens are the bearer tokens identity providers provision users
+	// with through /scim/v2; with none set the SCIM API rejects every request
+	SCIMTokens []string
+	// MetricsTokens are bearer tokens Prometheus scrapes /metrics with;
+	// without one, /metrics needs an admin access token
+	MetricsTokens []string
+	// BootstrapAdminEmail, when set, makes startup create an admin user with
+	// this email and an admin client with BootstrapClientID unless they
+	// already exist. An empty BootstrapAdminPassword is generated, printed
+	// once and must be changed at first login.
+	BootstrapAdminEmail    string
+	BootstrapAdminPassword string
+	BootstrapClientID      string
+	BootstrapRedirectURIs  []string
+	// JWEAcceptLegacy keeps encrypted tokens issued in the pre-RFC 7516
+	// format readable until they have expired
+	JWEAcceptLegacy bool
+	// JWEAcceptSigningKey keeps JWE tokens encrypted to the signing key,
+	// before the server had a separate encryption key, readable until they
+	// have expired
+	JWEAcceptSigningKey bool
+	// AcceptUntypedTokens keeps access and refresh tokens issued before
+	// tokens carried a typ header valid until they expire
+	AcceptUntypedTokens bool
+	// SLOLatencyBudgets maps route templates to their latency budget in
+	// milliseconds; only these routes are tracked. SLOErrorBudgets holds the
+	// percentage of each route's requests allowed to be slow or fail, and
+	// SLOWindow the seconds burn rates are measured over.
+	SLOLatencyBudgets map[string]int64
+	SLOErrorBudgets   map[string]float64
+	SLOWindow         int64
+	// TokenMaxInFlight caps the token requests served at once, 0 for no
+	// limit; the cap shrinks while requests take longer than
+	// TokenLatencyTarget milliseconds. Up to TokenQueueSize more wait
+	// TokenQueueTimeout milliseconds for a slot before a 503.
+	TokenMaxInFlight   int64
+	TokenQueueSize     int64
+	TokenQueueTimeout  int64
+	TokenLatencyTarget int64
+	// CORSAllowedOrigins may call every endpoint from a browser, with
+	// cookies. CORSPublicOrigins ("*" for any) may call the token, UserInfo,
+	// JWKS and discovery endpoints without cookies.
+	CORSAllowedOrigins []string
+	CORSPublicOrigins  []string
+	// HSTSMaxAge is the Strict-Transport-Security max-age in seconds (0
+	// omits the header); ContentSecurityPolicy is sent with every response
+	HSTSMaxAge            int64
+	ContentSecurityPolicy string
+	// ErrorURIBase is a page documenting the error codes; error responses
+	// link to ErrorURIBase#<code> in error_uri. Empty omits error_uri.
+	ErrorURIBase string
+	// ServiceName and ServiceVersion label every request log entry; Logging
+	// sets where the detail and summary logs are written
+	ServiceName    string
+	ServiceVersion string
+	Logging        *logger.LoggerConfig
 }
 
+// defaultContentSecurityPolicy allows the inline scripts and styles of the
+// server's own pages and https images for client logos, and forbids framing
+// them, so the consent page cannot be overlaid by another site
+const defaultContentSecurityPolicy = "default-src 'self'; script-src 'self' 'unsafe-inline'; style-src 'self' 'unsafe-inline'; img-src 'self' data: https:; object-src 'none'; base-uri 'none'; frame-ancestors 'none'"
+
 func Load() *Config {
+	issuerURL := strings.TrimSuffix(getEnv("ISSUER_URL", "http://localhost:"+getEnv("SERVER_PORT", "8080")), "/")
+
 	return &Config{
-		MongoURI:            getEnv("MONGODB_URI", "mongodb://localhost:27017"),
-		DatabaseName:        getEnv("DATABASE_NAME", "oauth2_db"),
-		ServerPort:          getEnv("SERVER_PORT", "8080"),
-		AccessTokenExpiry:   getEnvAsInt("ACCESS_TOKEN_EXPIRY", 3600),
-		RefreshTokenExpiry:  getEnvAsInt("REFRESH_TOKEN_EXPIRY", 604800),
+		MongoURI:                 getEnv("MONGODB_URI", "mongodb://localhost:27017"),
+		DatabaseName:             getEnv("DATABASE_NAME", "oauth2_db"),
+		ServerPort:               getEnv("SERVER_PORT", "8080"),
+		AccessTokenExpiry:        getEnvAsInt("ACCESS_TOKEN_EXPIRY", 3600),
+		RefreshTokenExpiry:       getEnvAsInt("REFRESH_TOKEN_EXPIRY", 604800),
+		AuthorizeDedupWindow:     getEnvAsInt("AUTHORIZE_DEDUP_WINDOW", 10),
+		AutoRegisterOnLogin:      getEnvAsBool("AUTO_REGISTER_ON_LOGIN", false),
+		RequireEmailVerification: getEnvAsBool("REQUIRE_EMAIL_VERIFICATION", false),
+		EmailVerificationExpiry:  getEnvAsInt("EMAIL_VERIFICATION_EXPIRY", 86400),
+		RememberDeviceExpiry:     getEnvAsInt("REMEMBER_DEVICE_EXPIRY", 2592000),
+		RejectCodeIPMismatch:     getEnvAsBool("REJECT_CODE_IP_MISMATCH", false),
+		RejectCodeEndedSession:   getEnvAsBool("REJECT_CODE_ENDED_SESSION", false),
+		SSOIdleTimeout:           getEnvAsInt("SSO_IDLE_TIMEOUT", 0),
+		SSOActivityInterval:      getEnvAsInt("SSO_ACTIVITY_INTERVAL", 60),
+		RequireOfflineAccess:     getEnvAsBool("REQUIRE_OFFLINE_ACCESS", false),
+		IssuerURL:                issuerURL,
+		PublicURL:                strings.TrimSuffix(getEnv("PUBLIC_URL", issuerURL), "/"),
+		LoginUIURL:               getEnv("LOGIN_UI_URL", ""),
+		ConsentUIURL:             getEnv("CONSENT_UI_URL", ""),
+		ConsentPolicy:            getEnv("CONSENT_POLICY", "ttl"),
+		ConsentTTLDays:           getEnvAsInt("SSO_CONSENT_EXPIRY_DAYS", 365),
+		ScopeConsentTTLDays:      getEnvAsIntMap("CONSENT_SCOPE_TTL_DAYS"),
+		ConsentRenewalWindowDays: getEnvAsInt("CONSENT_RENEWAL_WINDOW_DAYS", 7),
+		ScopeGrantTTLMinutes:     getEnvAsIntMap("SCOPE_GRANT_TTL_MINUTES"),
+		DevMode:                  getEnvAsBool("DEV_MODE", false),
+		TemplateDir:              getEnv("TEMPLATE_DIR", "templates"),
+		TemplateOverrideDir:      getEnv("TEMPLATE_OVERRIDE_DIR", ""),
+		TranslationsDir:          getEnv("TRANSLATIONS_DIR", ""),
+		ProductionMode:           getEnvAsBool("PRODUCTION_MODE", false),
+		StateSigningKey:          getEnv("STATE_SIGNING_KEY", ""),
+		StateTTL:                 getEnvAsInt("STATE_TTL", 600),
+		AuditAnchorInterval:      getEnvAsInt("AUDIT_ANCHOR_INTERVAL", 3600),
+		AuditExportFormat:        getEnv("AUDIT_EXPORT_FORMAT", "jsonl"),
+		AuditExportFile:          getEnv("AUDIT_EXPORT_FILE", ""),
+		AuditExportURL:           getEnv("AUDIT_EXPORT_URL", ""),
+		AuditExportAuthorization: getEnv("AUDIT_EXPORT_AUTHORIZATION", ""),
+		AuditExportInterval:      getEnvAsInt("AUDIT_EXPORT_INTERVAL", 60),
+		MailTransport:            getEnv("MAIL_TRANSPORT", "log"),
+		MailFrom:                 getEnv("MAIL_FROM", ""),
+		SMTPAddr:                 getEnv("SMTP_ADDR", ""),
+		SMTPUsername:             getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:             getEnv("SMTP_PASSWORD", ""),
+		MailHTTPURL:              getEnv("MAIL_HTTP_URL", ""),
+		MailHTTPAuthorization:    getEnv("MAIL_HTTP_AUTHORIZATION", ""),
+		ScopeReloadInterval:      getEnvAsInt("SCOPE_RELOAD_INTERVAL", 60),
+		MetadataWebhooks:         getEnvAsList("METADATA_WEBHOOK_URLS"),
+		MetadataCheckInterval:    getEnvAsInt("METADATA_CHECK_INTERVAL", 60),
+		MetadataCacheMaxAge:      getEnvAsInt("METADATA_CACHE_MAX_AGE", 300),
+		ServiceDocumentationURL:  getEnv("SERVICE_DOCUMENTATION_URL", ""),
+		OPPolicyURI:              getEnv("OP_POLICY_URI", ""),
+		OPTosURI:                 getEnv("OP_TOS_URI", ""),
+		WebhookTimeout:           getEnvAsInt("WEBHOOK_TIMEOUT", 10),
+		WebhookMaxAttempts:       getEnvAsInt("WEBHOOK_MAX_ATTEMPTS", 5),
+		WebhookRetryBackoff:      getEnvAsInt("WEBHOOK_RETRY_BACKOFF", 2),
+		DBOperationTimeout:       getEnvAsInt("DB_OPERATION_TIMEOUT", 10),
+		DBMaxPoolSize:            getEnvAsInt("DB_MAX_POOL_SIZE", 100),
+		DBMinPoolSize:            getEnvAsInt("DB_MIN_POOL_SIZE", 0),
+		DBMaxConnIdleTime:        getEnvAsInt("DB_MAX_CONN_IDLE_TIME", 0),
+		ClientCacheSize:          getEnvAsInt("CLIENT_CACHE_SIZE", 1000),
+		ClientCacheTTL:           getEnvAsInt("CLIENT_CACHE_TTL", 30),
+		TokenValidationCacheSize: getEnvAsInt("TOKEN_VALIDATION_CACHE_SIZE", 10000),
+		TokenValidationCacheTTL:  getEnvAsInt("TOKEN_VALIDATION_CACHE_TTL", 10),
+		SecondaryReads:           getEnvAsList("DB_SECONDARY_READS"),
+		VerifyIndexes:            getEnvAsBool("VERIFY_INDEXES", false),
+		ServerReadTimeout:        getEnvAsInt("SERVER_READ_TIMEOUT", 15),
+		ServerWriteTimeout:       getEnvAsInt("SERVER_WRITE_TIMEOUT", 30),
+		ServerIdleTimeout:        getEnvAsInt("SERVER_IDLE_TIMEOUT", 120),
+		ShutdownTimeout:          getEnvAsInt("SHUTDOWN_TIMEOUT", 30),
+		TLSCertFile:              getEnv("TLS_CERT_FILE", ""),
+		TLSKeyFile:               getEnv("TLS_KEY_FILE", ""),
+		MTLSClientCAFile:         getEnv("MTLS_CLIENT_CA_FILE", ""),
+		MTLSCertHeader:           getEnv("MTLS_CERT_HEADER", ""),
+		GRPCPort:                 getEnv("GRPC_PORT", ""),
+		GRPCTLSCertFile:          getEnv("GRPC_TLS_CERT_FILE", ""),
+		GRPCTLSKeyFile:           getEnv("GRPC_TLS_KEY_FILE", ""),
+		GRPCClientCAFile:         getEnv("GRPC_CLIENT_CA_FILE", ""),
+		CLILoginExpiry:           getEnvAsInt("CLI_LOGIN_EXPIRY", 600),
+		CLILoginInterval:         getEnvAsInt("CLI_LOGIN_INTERVAL", 5),
+		BFFTokenExpiry:           getEnvAsInt("BFF_TOKEN_EXPIRY", 300),
+		DPoPNonceLifetime:        getEnvAsInt("DPOP_NONCE_LIFETIME", 300),
+		JWKSCacheMaxAge:          getEnvAsInt("JWKS_CACHE_MAX_AGE", 3600),
+		JWKSRefreshInterval:      getEnvAsInt("JWKS_REFRESH_INTERVAL", 60),
+		JWKSFetchTimeout:         getEnvAsInt("JWKS_FETCH_TIMEOUT", 5),
+		JWKSFetchAttempts:        getEnvAsInt("JWKS_FETCH_ATTEMPTS", 3),
+		TokenPolicyFile:          getEnv("TOKEN_POLICY_FILE", ""),
+		IdentityProvidersFile:    getEnv("IDENTITY_PROVIDERS_FILE", ""),
+		GeoIPFile:                getEnv("GEOIP_FILE", ""),
+		SignInConfirmation:       getEnvAsBool("SIGN_IN_CONFIRMATION", false),
+		PasswordHashAlgorithm:    getEnv("PASSWORD_HASH_ALGORITHM", "bcrypt"),
+		PasswordBcryptCost:       getEnvAsInt("PASSWORD_BCRYPT_COST", 10),
+		PasswordArgon2Memory:     getEnvAsInt("PASSWORD_ARGON2_MEMORY", 65536),
+		PasswordArgon2Iterations: getEnvAsInt("PASSWORD_ARGON2_ITERATIONS", 3),
+		PasswordArgon2Threads:    getEnvAsInt("PASSWORD_ARGON2_THREADS", 2),
+		PasswordMinLength:        getEnvAsInt("PASSWORD_MIN_LENGTH", 8),
+		PasswordBreachListFile:   getEnv("PASSWORD_BREACH_LIST_FILE", ""),
+		SCIMTokens:               getEnvAsList("SCIM_TOKENS"),
+		MetricsTokens:            getEnvAsList("METRICS_TOKENS"),
+		BootstrapAdminEmail:      getEnv("BOOTSTRAP_ADMIN_EMAIL", ""),
+		BootstrapAdminPassword:   getEnv("BOOTSTRAP_ADMIN_PASSWORD", ""),
+		BootstrapClientID:        getEnv("BOOTSTRAP_CLIENT_ID", "admin-console"),
+		BootstrapRedirectURIs:    getEnvAsListOr("BOOTSTRAP_REDIRECT_URIS", []string{"http://localhost:3000/callback"}),
+		JWEAcceptLegacy:          getEnvAsBool("JWE_ACCEPT_LEGACY", true),
+		JWEAcceptSigningKey:      getEnvAsBool("JWE_ACCEPT_SIGNING_KEY", true),
+		AcceptUntypedTokens:      getEnvAsBool("ACCEPT_UNTYPED_TOKENS", true),
+		SLOLatencyBudgets:        getEnvAsIntMap("SLO_LATENCY_BUDGETS"),
+		SLOErrorBudgets:          getEnvAsFloatMap("SLO_ERROR_BUDGETS"),
+		SLOWindow:                getEnvAsInt("SLO_WINDOW", 3600),
+		TokenMaxInFlight:         getEnvAsInt("TOKEN_MAX_IN_FLIGHT", 0),
+		TokenQueueSize:           getEnvAsInt("TOKEN_QUEUE_SIZE", 100),
+		TokenQueueTimeout:        getEnvAsInt("TOKEN_QUEUE_TIMEOUT", 2000),
+		TokenLatencyTarget:       getEnvAsInt("TOKEN_LATENCY_TARGET", 250),
+		CORSAllowedOrigins:       getEnvAsList("CORS_ALLOWED_ORIGINS"),
+		CORSPublicOrigins:        getEnvAsListOr("CORS_PUBLIC_ORIGINS", []string{"*"}),
+		HSTSMaxAge:               getEnvAsInt("HSTS_MAX_AGE", 31536000),
+		ContentSecurityPolicy:    getEnv("CONTENT_SECURITY_POLICY", defaultContentSecurityPolicy),
+		ErrorURIBase:             getEnv("ERROR_URI_BASE", ""),
+		ServiceName:              getEnv("SERVICE_NAME", "oauth2-server"),
+		ServiceVersion:           getEnv("SERVICE_VERSION", "1.0.0"),
+		Logging: &logger.LoggerConfig{
+			Detail: logger.LogOutputConfig{
+				Path:    getEnv("LOG_DETAIL_PATH", "./logs/detail/"),
+				Console: getEnvAsBool("LOG_DETAIL_CONSOLE", true),
+				File:    getEnvAsBool("LOG_DETAIL_FILE", true),
+			},
+			Summary: logger.LogOutputConfig{
+				Path:    getEnv("LOG_SUMMARY_PATH", "./logs/summary/"),
+				Console: getEnvAsBool("LOG_SUMMARY_CONSOLE", true),
+				File:    getEnvAsBool("LOG_SUMMARY_FILE", true),
+			},
+		},
 	}
 }
 
@@ -41,3 +455,64 @@ func getEnvAsInt(key string, defaultValue int64) int64 {
 	}
 	return defaultValue
 }
+
+func getEnvAsBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if boolVal, err := strconv.ParseBool(value); err == nil {
+			return boolVal
+		}
+	}
+	return defaultValue
+}
+
+// getEnvAsList parses a comma separated list, skipping empty entries
+func getEnvAsList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// getEnvAsListOr is getEnvAsList with a default for an unset variable; set
+// to an empty string it yields an empty list
+func getEnvAsListOr(key string, defaultValue []string) []string {
+	if _, ok := os.LookupEnv(key); !ok {
+		return defaultValue
+	}
+	return getEnvAsList(key)
+}
+
+// getEnvAsFloatMap parses a comma separated list of key=value pairs with
+// decimal values such as "/oauth/token=0.5". Malformed entries are skipped.
+func getEnvAsFloatMap(key string) map[string]float64 {
+	result := make(map[string]float64)
+	for _, pair := range strings.Split(os.Getenv(key), ",") {
+		name, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found || name == "" {
+			continue
+		}
+		if floatVal, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+			result[strings.TrimSpace(name)] = floatVal
+		}
+	}
+	return result
+}
+
+// getEnvAsIntMap parses a comma separated list of key=value pairs such as
+// "phone=30,address=30". Malformed entries are skipped.
+func getEnvAsIntMap(key string) map[string]int64 {
+	result := make(map[string]int64)
+	for _, pair := range strings.Split(os.Getenv(key), ",") {
+		name, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found || name == "" {
+			continue
+		}
+		if intVal, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64); err == nil {
+			result[strings.TrimSpace(name)] = intVal
+		}
+	}
+	return result
+}
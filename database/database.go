@@ -13,11 +13,37 @@ type Database struct {
 	DB     *mongo.Database
 }
 
-func Connect(uri, dbName string) (*Database, error) {
+// PoolOptions sizes the driver's connection pool. Zero values keep the
+// driver defaults (at most 100 connections, none kept open when idle, no idle
+// limit).
+type PoolOptions struct {
+	MaxSize     uint64
+	MinSize     uint64
+	MaxIdleTime time.Duration
+}
+
+// Connect opens the client and checks the server is reachable. A positive
+// operationTimeout bounds every operation whose context has no deadline of
+// its own, so a slow query cannot hold a request open indefinitely.
+func Connect(uri, dbName string, operationTimeout time.Duration, pool PoolOptions) (*Database, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	clientOptions := options.Client().ApplyURI(uri)
+	if operationTimeout > 0 {
+		clientOptions.SetTimeout(operationTimeout)
+	}
+	if pool.MaxSize > 0 {
+		clientOptions.SetMaxPoolSize(pool.MaxSize)
+	}
+	if pool.MinSize > 0 {
+		clientOptions.SetMinPoolSize(pool.MinSize)
+	}
+	if pool.MaxIdleTime > 0 {
+		clientOptions.SetMaxConnIdleTime(pool.MaxIdleTime)
+	}
+
+	client, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,84 @@
+package database
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// QueryShape is a query the server runs on every request and that must be
+// answered from an index
+type QueryShape struct {
+	Name       string
+	Collection string
+	Filter     bson.D
+}
+
+// HotQueries are the lookups on the authorization and token paths. Only the
+// plan matters to explain, so the filter values are placeholders.
+var HotQueries = []QueryShape{
+	{Name: "client by client_id", Collection: "clients", Filter: bson.D{{Key: "client_id", Value: "index-check"}}},
+	{Name: "authorization code by code", Collection: "auth_codes", Filter: bson.D{{Key: "code", Value: "index-check"}}},
+	{Name: "authorization request by challenge", Collection: "authorization_requests", Filter: bson.D{{Key: "challenge", Value: "index-check"}}},
+	{Name: "SSO session by session_id", Collection: "sso_sessions", Filter: bson.D{{Key: "session_id", Value: "index-check"}}},
+	{Name: "opaque access token by token", Collection: "access_tokens", Filter: bson.D{{Key: "token", Value: "index-check"}}},
+	{Name: "CLI login by device_code", Collection: "cli_logins", Filter: bson.D{{Key: "device_code", Value: "index-check"}}},
+	{Name: "consents by user", Collection: "user_consents", Filter: bson.D{{Key: "user_id", Value: "index-check"}}},
+	{Name: "consents by client", Collection: "user_consents", Filter: bson.D{{Key: "client_id", Value: "index-check"}}},
+	{Name: "SSO sessions by user", Collection: "sso_sessions", Filter: bson.D{{Key: "user_id", Value: "index-check"}}},
+	{Name: "authorization codes by user", Collection: "auth_codes", Filter: bson.D{{Key: "user_id", Value: "index-check"}}},
+	{Name: "consent by user and client", Collection: "user_consents", Filter: bson.D{{Key: "user_id", Value: "index-check"}, {Key: "client_id", Value: "index-check"}, {Key: "status", Value: "active"}}},
+}
+
+// CheckIndexUsage explains each query and returns those whose winning plan
+// scans the whole collection
+func CheckIndexUsage(ctx context.Context, db *mongo.Database, shapes []QueryShape) ([]QueryShape, error) {
+	var scans []QueryShape
+	for _, shape := range shapes {
+		command := bson.D{
+			{Key: "explain", Value: bson.D{
+				{Key: "find", Value: shape.Collection},
+				{Key: "filter", Value: shape.Filter},
+			}},
+			{Key: "verbosity", Value: "queryPlanner"},
+		}
+
+		var result bson.M
+		if err := db.RunCommand(ctx, command).Decode(&result); err != nil {
+			return nil, err
+		}
+
+		planner, _ := result["queryPlanner"].(bson.M)
+		if planUsesCollectionScan(planner["winningPlan"]) {
+			scans = append(scans, shape)
+		}
+	}
+	return scans, nil
+}
+
+// planUsesCollectionScan walks an explain plan looking for a COLLSCAN stage.
+// The nesting differs between server versions and query engines, so every
+// nested document and array is searched.
+func planUsesCollectionScan(plan interface{}) bool {
+	switch node := plan.(type) {
+	case bson.M:
+		if node["stage"] == "COLLSCAN" {
+			return true
+		}
+		for _, child := range node {
+			if planUsesCollectionScan(child) {
+				return true
+			}
+		}
+	case bson.D:
+		return planUsesCollectionScan(node.Map())
+	case bson.A:
+		for _, child := range node {
+			if planUsesCollectionScan(child) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,31 @@
+package database
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestPlanUsesCollectionScan(t *testing.T) {
+	tests := []struct {
+		name     string
+		plan     interface{}
+		expected bool
+	}{
+		{"nil plan", nil, false},
+		{"index scan", bson.M{"stage": "FETCH", "inputStage": bson.M{"stage": "IXSCAN", "indexName": "client_id_1"}}, false},
+		{"collection scan", bson.M{"stage": "COLLSCAN"}, true},
+		{"nested collection scan", bson.M{"stage": "LIMIT", "inputStage": bson.M{"stage": "COLLSCAN"}}, true},
+		{"query engine plan", bson.M{"queryPlan": bson.M{"stage": "COLLSCAN"}}, true},
+		{"scan inside array", bson.M{"stage": "OR", "inputStages": bson.A{bson.M{"stage": "IXSCAN"}, bson.M{"stage": "COLLSCAN"}}}, true},
+		{"ordered document", bson.D{{Key: "stage", Value: "COLLSCAN"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := planUsesCollectionScan(tt.plan); got != tt.expected {
+				t.Errorf("planUsesCollectionScan() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,431 @@
+package database
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CreateIndexes creates the indexes the repositories rely on, including the
+// TTL indexes that expire short-lived records
+func CreateIndexes(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	usersCollection := db.Collection("users")
+	_, err := usersCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "email", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	// An upstream identity can be linked to only one user
+	_, err = usersCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "identities.provider", Value: 1}, {Key: "identities.subject", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"identities.subject": bson.M{"$exists": true}}),
+	})
+	if err != nil {
+		return err
+	}
+
+	clientsCollection := db.Collection("clients")
+	_, err = clientsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "client_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = clientsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "owner_user_id", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	authCodesCollection := db.Collection("auth_codes")
+	_, err = authCodesCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "code", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = authCodesCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Revoking a user's or a client's grants deletes their pending codes
+	_, err = authCodesCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = authCodesCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "client_id", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	authRequestsCollection := db.Collection("authorization_requests")
+	_, err = authRequestsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "challenge", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = authRequestsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Used to detect duplicate pending authorization requests
+	_, err = authRequestsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "client_id", Value: 1},
+			{Key: "state", Value: 1},
+			{Key: "created_at", Value: 1},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	emailVerificationsCollection := db.Collection("email_verifications")
+	_, err = emailVerificationsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "token", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = emailVerificationsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return err
+	}
+
+	usedStatesCollection := db.Collection("used_states")
+	_, err = usedStatesCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "state_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = usedStatesCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return err
+	}
+
+	// SSO Sessions indexes
+	ssoSessionsCollection := db.Collection("sso_sessions")
+	_, err = ssoSessionsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "session_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = ssoSessionsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = ssoSessionsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "expires_at", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Session listings page through a user's sessions by either time field
+	_, err = ssoSessionsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}, {Key: "session_id", Value: -1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = ssoSessionsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "last_activity", Value: -1}, {Key: "session_id", Value: -1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Sign-ins waiting for email confirmation are found by the link's token
+	_, err = ssoSessionsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "confirmation_hash", Value: 1}},
+		Options: options.Index().SetSparse(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	// User Consents indexes
+	userConsentsCollection := db.Collection("user_consents")
+	_, err = userConsentsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "client_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Consent checks only match active consents
+	_, err = userConsentsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "client_id", Value: 1}, {Key: "status", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = userConsentsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Revoking a client's consents for every user looks them up by client
+	_, err = userConsentsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "client_id", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Consent listings page through a user's consents by grant time
+	_, err = userConsentsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "granted_at", Value: -1}, {Key: "client_id", Value: -1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Opaque access tokens expire out of the store on their own
+	accessTokensCollection := db.Collection("access_tokens")
+	_, err = accessTokensCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "token", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = accessTokensCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = accessTokensCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = accessTokensCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "client_id", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	cliLoginsCollection := db.Collection("cli_logins")
+	_, err = cliLoginsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "device_code", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = cliLoginsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_code", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = cliLoginsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Collection("scopes").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "name", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Revocations are kept only as long as a token issued before them can be valid
+	_, err = db.Collection("revocations").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Webhooks are looked up by subscribed event on every security event
+	_, err = db.Collection("webhooks").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "events", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Remembered devices are looked up by cookie on step-up authentication
+	trustedDevicesCollection := db.Collection("trusted_devices")
+	_, err = trustedDevicesCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "token_hash", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = trustedDevicesCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = trustedDevicesCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return err
+	}
+
+	// SCIM: users and groups are looked up by the provisioning IdP's ID
+	_, err = usersCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "external_id", Value: 1}},
+		Options: options.Index().SetSparse(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	groupsCollection := db.Collection("groups")
+	_, err = groupsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "display_name", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = groupsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "members", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Audit log: seq is unique so concurrent appends cannot fork the chain
+	auditLogCollection := db.Collection("audit_log")
+	_, err = auditLogCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "seq", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = auditLogCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "seq", Value: -1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Admin audit listing filtered by event type
+	_, err = auditLogCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "event", Value: 1}, {Key: "seq", Value: -1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Per-client usage statistics for the developer portal and client dashboard
+	_, err = auditLogCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "client_id", Value: 1}, {Key: "event", Value: 1}, {Key: "created_at", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Collection("audit_anchors").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "seq", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Println("Database indexes created successfully")
+	return nil
+}
+
+// VerifyIndexUsage warns about hot queries that would scan a whole collection,
+// which usually means an index is missing in this deployment
+func VerifyIndexUsage(db *mongo.Database) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	scans, err := CheckIndexUsage(ctx, db, HotQueries)
+	if err != nil {
+		log.Printf("Warning: failed to verify index usage: %v", err)
+		return
+	}
+	for _, shape := range scans {
+		log.Printf("Warning: query %q on %s uses a collection scan; check its index", shape.Name, shape.Collection)
+	}
+	if len(scans) == 0 {
+		log.Println("Index usage verified for hot queries")
+	}
+}
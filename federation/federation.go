@@ -0,0 +1,337 @@
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Provider is an upstream identity provider users can sign in with. With an
+// issuer it is an OpenID Connect provider whose endpoints are discovered and
+// whose ID tokens are verified; without one it is a plain OAuth 2.0 provider
+// (such as GitHub) and the identity is read from the userinfo endpoint.
+type Provider struct {
+	Name         string   `json:"name"`
+	DisplayName  string   `json:"display_name,omitempty"`
+	Issuer       string   `json:"issuer,omitempty"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	Scopes       []string `json:"scopes,omitempty"`
+
+	// AutoProvision creates a local user for an external identity that
+	// matches no existing account
+	AutoProvision bool `json:"auto_provision,omitempty"`
+
+	// Endpoints, discovered from the issuer unless set
+	AuthorizationEndpoint string `json:"authorization_endpoint,omitempty"`
+	TokenEndpoint         string `json:"token_endpoint,omitempty"`
+	UserInfoEndpoint      string `json:"userinfo_endpoint,omitempty"`
+	JWKSURI               string `json:"jwks_uri,omitempty"`
+
+	// Claims holding the subject and email, "sub" and "email" by default
+	SubjectClaim string `json:"subject_claim,omitempty"`
+	EmailClaim   string `json:"email_claim,omitempty"`
+
+	mu   sync.Mutex
+	keys *jose.JSONWebKeySet
+}
+
+// Identity is the user an upstream provider signed in
+type Identity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// Providers are the configured identity providers in display order
+type Providers []*Provider
+
+// Get returns the provider with the given name, or nil
+func (p Providers) Get(name string) *Provider {
+	for _, provider := range p {
+		if provider.Name == name {
+			return provider
+		}
+	}
+	return nil
+}
+
+var providerName = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// LoadFile reads providers from a JSON array
+func LoadFile(path string) (Providers, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var providers Providers
+	if err := json.Unmarshal(data, &providers); err != nil {
+		return nil, fmt.Errorf("invalid identity provider file: %w", err)
+	}
+
+	seen := map[string]bool{}
+	for _, p := range providers {
+		if !providerName.MatchString(p.Name) {
+			return nil, fmt.Errorf("invalid identity provider name %q", p.Name)
+		}
+		if seen[p.Name] {
+			return nil, fmt.Errorf("%s: duplicate identity provider", p.Name)
+		}
+		seen[p.Name] = true
+		if p.ClientID == "" {
+			return nil, fmt.Errorf("%s: client_id is required", p.Name)
+		}
+		if p.Issuer == "" && (p.AuthorizationEndpoint == "" || p.TokenEndpoint == "" || p.UserInfoEndpoint == "") {
+			return nil, fmt.Errorf("%s: an issuer or the authorization, token and userinfo endpoints are required", p.Name)
+		}
+		if p.DisplayName == "" {
+			p.DisplayName = p.Name
+		}
+		if len(p.Scopes) == 0 && p.Issuer != "" {
+			p.Scopes = []string{"openid", "email", "profile"}
+		}
+	}
+	return providers, nil
+}
+
+// Client makes the requests to upstream providers
+var Client = &http.Client{Timeout: 10 * time.Second}
+
+// AuthCodeURL returns the provider's authorization URL for a code flow with
+// PKCE (S256)
+func (p *Provider) AuthCodeURL(ctx context.Context, redirectURI, state, nonce, codeChallenge string) (string, error) {
+	if err := p.discover(ctx); err != nil {
+		return "", err
+	}
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {redirectURI},
+		"state":                 {state},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+	if len(p.Scopes) > 0 {
+		params.Set("scope", strings.Join(p.Scopes, " "))
+	}
+	if p.Issuer != "" {
+		params.Set("nonce", nonce)
+	}
+
+	separator := "?"
+	if strings.Contains(p.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return p.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+// Exchange redeems an authorization code and returns the signed-in identity.
+// An OpenID Connect provider's ID token must be validly signed, issued to
+// this client and carry nonce.
+func (p *Provider) Exchange(ctx context.Context, code, redirectURI, codeVerifier, nonce string) (*Identity, error) {
+	if err := p.discover(ctx); err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {p.ClientID},
+		"client_secret": {p.ClientSecret},
+		"code_verifier": {codeVerifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", p.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var tokens struct {
+		AccessToken string `json:"access_token"`
+		IDToken     string `json:"id_token"`
+	}
+	if err := fetchJSON(req, &tokens); err != nil {
+		return nil, fmt.Errorf("%s: token request failed: %w", p.Name, err)
+	}
+
+	var claims map[string]interface{}
+	switch {
+	case p.Issuer != "":
+		if tokens.IDToken == "" {
+			return nil, fmt.Errorf("%s: no ID token in the token response", p.Name)
+		}
+		if claims, err = p.verifyIDToken(ctx, tokens.IDToken, nonce); err != nil {
+			return nil, fmt.Errorf("%s: %w", p.Name, err)
+		}
+	default:
+		if tokens.AccessToken == "" {
+			return nil, fmt.Errorf("%s: no access token in the token response", p.Name)
+		}
+		if claims, err = p.userInfo(ctx, tokens.AccessToken); err != nil {
+			return nil, fmt.Errorf("%s: userinfo request failed: %w", p.Name, err)
+		}
+	}
+
+	identity := &Identity{
+		Provider: p.Name,
+		Subject:  claimString(claims, p.SubjectClaim, "sub"),
+		Email:    claimString(claims, p.EmailClaim, "email"),
+		Name:     claimString(claims, "name", ""),
+	}
+	identity.EmailVerified, _ = claims["email_verified"].(bool)
+	if identity.Subject == "" {
+		return nil, fmt.Errorf("%s: identity has no subject", p.Name)
+	}
+	return identity, nil
+}
+
+func (p *Provider) verifyIDToken(ctx context.Context, idToken, nonce string) (map[string]interface{}, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.signingKey(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "ES256", "ES384"}),
+		jwt.WithIssuer(p.Issuer),
+		jwt.WithAudience(p.ClientID),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+	if claimNonce, _ := claims["nonce"].(string); claimNonce != nonce {
+		return nil, errors.New("invalid ID token: nonce mismatch")
+	}
+	return claims, nil
+}
+
+// signingKey returns the provider key with the given ID, fetching the key set
+// again once when the key is not known yet
+func (p *Provider) signingKey(ctx context.Context, kid string) (interface{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for attempt := 0; attempt < 2; attempt++ {
+		if p.keys == nil || attempt > 0 {
+			req, err := http.NewRequestWithContext(ctx, "GET", p.JWKSURI, nil)
+			if err != nil {
+				return nil, err
+			}
+			var keys jose.JSONWebKeySet
+			if err := fetchJSON(req, &keys); err != nil {
+				return nil, fmt.Errorf("fetching JWKS: %w", err)
+			}
+			p.keys = &keys
+		}
+		for _, key := range p.keys.Keys {
+			if (kid == "" || key.KeyID == kid) && key.Use != "enc" {
+				return key.Key, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+func (p *Provider) userInfo(ctx context.Context, accessToken string) (map[string]interface{}, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", p.UserInfoEndpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	var claims map[string]interface{}
+	if err := fetchJSON(req, &claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// discover fills in the endpoints from the issuer's OpenID configuration
+func (p *Provider) discover(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.Issuer == "" || (p.AuthorizationEndpoint != "" && p.TokenEndpoint != "" && p.JWKSURI != "") {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimSuffix(p.Issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return err
+	}
+	var metadata struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		UserInfoEndpoint      string `json:"userinfo_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	if err := fetchJSON(req, &metadata); err != nil {
+		return fmt.Errorf("%s: discovery failed: %w", p.Name, err)
+	}
+	if metadata.Issuer != p.Issuer {
+		return fmt.Errorf("%s: discovery returned issuer %q", p.Name, metadata.Issuer)
+	}
+
+	if p.AuthorizationEndpoint == "" {
+		p.AuthorizationEndpoint = metadata.AuthorizationEndpoint
+	}
+	if p.TokenEndpoint == "" {
+		p.TokenEndpoint = metadata.TokenEndpoint
+	}
+	if p.UserInfoEndpoint == "" {
+		p.UserInfoEndpoint = metadata.UserInfoEndpoint
+	}
+	if p.JWKSURI == "" {
+		p.JWKSURI = metadata.JWKSURI
+	}
+	return nil
+}
+
+func fetchJSON(req *http.Request, v interface{}) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return json.Unmarshal(body, v)
+}
+
+// claimString reads a string or numeric claim; GitHub user IDs are numbers
+func claimString(claims map[string]interface{}, name, fallback string) string {
+	if name == "" {
+		name = fallback
+	}
+	if name == "" {
+		return ""
+	}
+	switch v := claims[name].(type) {
+	case string:
+		return v
+	case float64:
+		return fmt.Sprintf("%.0f", v)
+	}
+	return ""
+}
@@ -0,0 +1,177 @@
+package federation
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// fakeProvider serves discovery, JWKS, token and userinfo endpoints. The
+// token endpoint returns an ID token with the given claims.
+func fakeProvider(t *testing.T, claims func(issuer string) jwt.MapClaims) *httptest.Server {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var server *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 server.URL,
+			"authorization_endpoint": server.URL + "/authorize",
+			"token_endpoint":         server.URL + "/token",
+			"userinfo_endpoint":      server.URL + "/userinfo",
+			"jwks_uri":               server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &key.PublicKey, KeyID: "key-1", Algorithm: "RS256", Use: "sig"},
+		}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("code") != "good-code" || r.PostFormValue("code_verifier") != "verifier" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims(server.URL))
+		token.Header["kid"] = "key-1"
+		idToken, err := token.SignedString(key)
+		if err != nil {
+			t.Error(err)
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "upstream-token", "id_token": idToken})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer upstream-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"id": 583231, "login": "octocat", "name": "The Octocat"}`))
+	})
+	server = httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func idTokenClaims(nonce string) func(issuer string) jwt.MapClaims {
+	return func(issuer string) jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":            issuer,
+			"aud":            "client-1",
+			"sub":            "upstream-user",
+			"email":          "user@example.com",
+			"email_verified": true,
+			"name":           "Upstream User",
+			"nonce":          nonce,
+			"exp":            time.Now().Add(time.Minute).Unix(),
+		}
+	}
+}
+
+func TestExchange_OpenIDConnect(t *testing.T) {
+	server := fakeProvider(t, idTokenClaims("nonce-1"))
+	p := &Provider{Name: "test", Issuer: server.URL, ClientID: "client-1"}
+
+	location, err := p.AuthCodeURL(context.Background(), "https://op.example.com/cb", "state-1", "nonce-1", "challenge")
+	if err != nil {
+		t.Fatalf("AuthCodeURL failed: %v", err)
+	}
+	u, _ := url.Parse(location)
+	if u.Path != "/authorize" || u.Query().Get("nonce") != "nonce-1" || u.Query().Get("code_challenge_method") != "S256" {
+		t.Errorf("Unexpected authorization URL %s", location)
+	}
+
+	identity, err := p.Exchange(context.Background(), "good-code", "https://op.example.com/cb", "verifier", "nonce-1")
+	if err != nil {
+		t.Fatalf("Exchange failed: %v", err)
+	}
+	if identity.Subject != "upstream-user" || identity.Email != "user@example.com" || !identity.EmailVerified || identity.Name != "Upstream User" {
+		t.Errorf("Unexpected identity %+v", identity)
+	}
+
+	if _, err := p.Exchange(context.Background(), "good-code", "https://op.example.com/cb", "verifier", "other-nonce"); err == nil {
+		t.Error("Expected a nonce mismatch to fail")
+	}
+	if _, err := p.Exchange(context.Background(), "bad-code", "https://op.example.com/cb", "verifier", "nonce-1"); err == nil {
+		t.Error("Expected a rejected code to fail")
+	}
+}
+
+func TestExchange_RejectsTokenForAnotherClient(t *testing.T) {
+	server := fakeProvider(t, idTokenClaims("nonce-1"))
+	p := &Provider{Name: "test", Issuer: server.URL, ClientID: "client-2"}
+
+	if _, err := p.Exchange(context.Background(), "good-code", "https://op.example.com/cb", "verifier", "nonce-1"); err == nil {
+		t.Error("Expected an ID token with another audience to fail")
+	}
+}
+
+func TestExchange_UserInfo(t *testing.T) {
+	server := fakeProvider(t, idTokenClaims(""))
+	p := &Provider{
+		Name:                  "github",
+		ClientID:              "client-1",
+		AuthorizationEndpoint: server.URL + "/authorize",
+		TokenEndpoint:         server.URL + "/token",
+		UserInfoEndpoint:      server.URL + "/userinfo",
+		SubjectClaim:          "id",
+	}
+
+	identity, err := p.Exchange(context.Background(), "good-code", "https://op.example.com/cb", "verifier", "")
+	if err != nil {
+		t.Fatalf("Exchange failed: %v", err)
+	}
+	if identity.Subject != "583231" || identity.Name != "The Octocat" || identity.EmailVerified {
+		t.Errorf("Unexpected identity %+v", identity)
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{"OpenID Connect provider", `[{"name": "google", "issuer": "https://accounts.google.com", "client_id": "id"}]`, false},
+		{"OAuth provider with endpoints", `[{"name": "github", "client_id": "id", "authorization_endpoint": "a", "token_endpoint": "t", "userinfo_endpoint": "u"}]`, false},
+		{"OAuth provider without endpoints", `[{"name": "github", "client_id": "id"}]`, true},
+		{"Invalid name", `[{"name": "Google Login", "issuer": "https://accounts.google.com", "client_id": "id"}]`, true},
+		{"Duplicate name", `[{"name": "g", "issuer": "https://a", "client_id": "id"}, {"name": "g", "issuer": "https://b", "client_id": "id"}]`, true},
+		{"Missing client ID", `[{"name": "google", "issuer": "https://accounts.google.com"}]`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "providers.json")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			providers, err := LoadFile(path)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("Expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if providers[0].DisplayName == "" {
+				t.Error("Expected the display name to default to the name")
+			}
+		})
+	}
+}
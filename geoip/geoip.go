@@ -0,0 +1,113 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"oauth2-server/models"
+	"os"
+	"sort"
+	"strings"
+)
+
+type network struct {
+	prefix   netip.Prefix
+	last     netip.Addr
+	location models.GeoLocation
+}
+
+// Database maps networks to locations
+type Database struct {
+	networks []network
+}
+
+// New builds a database from networks in CIDR notation, which must not
+// overlap
+func New(locations map[string]models.GeoLocation) (*Database, error) {
+	db := &Database{networks: make([]network, 0, len(locations))}
+	for cidr, location := range locations {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q: %w", cidr, err)
+		}
+		prefix = prefix.Masked()
+		db.networks = append(db.networks, network{prefix: prefix, last: lastAddr(prefix), location: location})
+	}
+
+	sort.Slice(db.networks, func(i, j int) bool {
+		return db.networks[i].prefix.Addr().Less(db.networks[j].prefix.Addr())
+	})
+	for i := 1; i < len(db.networks); i++ {
+		if prev := db.networks[i-1]; !prev.last.Less(db.networks[i].prefix.Addr()) {
+			return nil, fmt.Errorf("network %s overlaps %s", db.networks[i].prefix, prev.prefix)
+		}
+	}
+	return db, nil
+}
+
+// LoadFile reads a CSV file of network,city,region,country rows; a header
+// row starting with "network" is skipped. The GeoLite2 City blocks joined
+// with their locations can be exported to this format.
+func LoadFile(path string) (*Database, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = 4
+	reader.TrimLeadingSpace = true
+
+	locations := map[string]models.GeoLocation{}
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid GeoIP file: %w", err)
+		}
+		if line == 1 && strings.EqualFold(record[0], "network") {
+			continue
+		}
+		if _, ok := locations[record[0]]; ok {
+			return nil, fmt.Errorf("line %d: duplicate network %s", line, record[0])
+		}
+		locations[record[0]] = models.GeoLocation{City: record[1], Region: record[2], Country: record[3]}
+	}
+	return New(locations)
+}
+
+// Lookup returns the location of ip, which may carry a port
+func (db *Database) Lookup(ip string) (models.GeoLocation, bool) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		addrPort, err := netip.ParseAddrPort(ip)
+		if err != nil {
+			return models.GeoLocation{}, false
+		}
+		addr = addrPort.Addr()
+	}
+	addr = addr.Unmap()
+
+	// The last network starting at or before addr is the only candidate
+	i := sort.Search(len(db.networks), func(i int) bool {
+		return addr.Less(db.networks[i].prefix.Addr())
+	})
+	if i == 0 || !db.networks[i-1].prefix.Contains(addr) {
+		return models.GeoLocation{}, false
+	}
+	return db.networks[i-1].location, true
+}
+
+// lastAddr is the highest address of the network
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	b := prefix.Addr().AsSlice()
+	for bit := prefix.Bits(); bit < len(b)*8; bit++ {
+		b[bit/8] |= 0x80 >> (bit % 8)
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
@@ -0,0 +1,53 @@
+package geoip
+
+import (
+	"oauth2-server/models"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "geoip.csv")
+	os.WriteFile(path, []byte(`network,city,region,country
+1.0.0.0/24,Bangkok,Bangkok,Thailand
+1.0.1.0/24,,,Thailand
+2001:db8::/32,Chiang Mai,Chiang Mai,Thailand
+`), 0o600)
+
+	db, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+
+	tests := []struct {
+		ip   string
+		want string
+		ok   bool
+	}{
+		{"1.0.0.1", "Bangkok", true},
+		{"1.0.0.255:51234", "Bangkok", true},
+		{"::ffff:1.0.0.7", "Bangkok", true},
+		{"1.0.1.200", "Thailand", true},
+		{"1.0.2.1", "", false},
+		{"0.255.255.255", "", false},
+		{"[2001:db8::1]:443", "Chiang Mai", true},
+		{"not-an-ip", "", false},
+	}
+	for _, tt := range tests {
+		location, ok := db.Lookup(tt.ip)
+		if ok != tt.ok || location.String() != tt.want {
+			t.Errorf("Lookup(%q) = %q, %v; want %q, %v", tt.ip, location, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestNew_RejectsOverlappingNetworks(t *testing.T) {
+	_, err := New(map[string]models.GeoLocation{
+		"10.0.0.0/8":  {Country: "A"},
+		"10.1.0.0/16": {Country: "B"},
+	})
+	if err == nil {
+		t.Error("Expected overlapping networks to be rejected")
+	}
+}
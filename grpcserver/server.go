@@ -0,0 +1,219 @@
+// Package grpcserver serves token validation and the signing key set over
+// gRPC, for internal services that validate a token on every request.
+package grpcserver
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"oauth2-server/handlers"
+	"oauth2-server/models"
+	tokenvalidationv1 "oauth2-server/proto/tokenvalidation/v1"
+	"oauth2-server/utils"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// Server implements the TokenValidation service with the same checks as
+// /token/validate
+type Server struct {
+	tokenvalidationv1.UnimplementedTokenValidationServer
+	validation *handlers.TokenValidationHandler
+
+	mu      sync.Mutex
+	keys    *tokenvalidationv1.JWKS
+	changed chan struct{} // closed when keys is replaced
+	done    chan struct{} // closed when the server stops
+}
+
+// New serves validation results from validation and keys to WatchJWKS
+// callers
+func New(validation *handlers.TokenValidationHandler, keys models.JSONWebKeySet) *Server {
+	return &Server{
+		validation: validation,
+		keys:       keySet(keys),
+		changed:    make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+// PublishKeys replaces the key set and sends it to every WatchJWKS stream
+func (s *Server) PublishKeys(keys models.JSONWebKeySet) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keySet(keys)
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *Server) Validate(ctx context.Context, req *tokenvalidationv1.ValidateRequest) (*tokenvalidationv1.ValidateResponse, error) {
+	validationReq := &handlers.TokenValidationRequest{
+		Token:           req.GetToken(),
+		Resource:        req.GetResource(),
+		Audience:        req.GetAudience(),
+		Scope:           strings.Join(req.GetScopes(), " "),
+		CheckRevocation: req.CheckRevocation,
+	}
+	switch req.GetExpectedTokenType() {
+	case tokenvalidationv1.TokenType_TOKEN_TYPE_UNSPECIFIED, tokenvalidationv1.TokenType_TOKEN_TYPE_ACCESS_TOKEN:
+		validationReq.ExpectedTokenType = "access_token"
+	case tokenvalidationv1.TokenType_TOKEN_TYPE_REFRESH_TOKEN:
+		validationReq.ExpectedTokenType = "refresh_token"
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unknown expected_token_type %d", req.GetExpectedTokenType())
+	}
+
+	result, err := s.validation.Validate(ctx, validationReq)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	response := &tokenvalidationv1.ValidateResponse{
+		Valid:         result.Valid,
+		Format:        result.TokenType,
+		Error:         result.Error,
+		Reason:        result.Reason,
+		MissingScopes: result.MissingScopes,
+		ExpiresAt:     result.ExpiresAt,
+		IssuedAt:      result.IssuedAt,
+	}
+	if result.Valid {
+		response.Claims = claims(result.Claims)
+	}
+	return response, nil
+}
+
+func (s *Server) WatchJWKS(_ *tokenvalidationv1.WatchJWKSRequest, stream tokenvalidationv1.TokenValidation_WatchJWKSServer) error {
+	for {
+		s.mu.Lock()
+		keys, changed := s.keys, s.changed
+		s.mu.Unlock()
+
+		if err := stream.Send(keys); err != nil {
+			return err
+		}
+		select {
+		case <-changed:
+		case <-stream.Context().Done():
+			return nil
+		case <-s.done:
+			return status.Error(codes.Unavailable, "server is shutting down")
+		}
+	}
+}
+
+// Serve accepts connections on ln until ctx is cancelled, then ends the
+// WatchJWKS streams and waits up to shutdownTimeout for calls in flight.
+// creds is nil to serve without TLS.
+func (s *Server) Serve(ctx context.Context, ln net.Listener, creds credentials.TransportCredentials, shutdownTimeout time.Duration) error {
+	var options []grpc.ServerOption
+	if creds != nil {
+		options = append(options, grpc.Creds(creds))
+	}
+	server := grpc.NewServer(options...)
+	tokenvalidationv1.RegisterTokenValidationServer(server, s)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.Serve(ln)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	close(s.done)
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(shutdownTimeout):
+		server.Stop()
+	}
+	if err := <-errCh; err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+		return err
+	}
+	return nil
+}
+
+// TLSConfig loads the server certificate. With a clientCAFile, callers must
+// present a certificate issued by one of its CAs.
+func TLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if clientCAFile != "" {
+		pemData, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pemData) {
+			return nil, fmt.Errorf("no certificates found in %s", clientCAFile)
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+func keySet(keys models.JSONWebKeySet) *tokenvalidationv1.JWKS {
+	set := &tokenvalidationv1.JWKS{}
+	for _, key := range keys.Keys {
+		set.Keys = append(set.Keys, &tokenvalidationv1.JWK{
+			Kty: key.Kty,
+			Use: key.Use,
+			Alg: key.Alg,
+			Kid: key.Kid,
+			N:   key.N,
+			E:   key.E,
+		})
+	}
+	return set
+}
+
+// claims converts the claims of a /token/validate response. The aud claim
+// of encrypted tokens is a single string.
+func claims(values map[string]interface{}) *tokenvalidationv1.Claims {
+	str := func(name string) string {
+		value, _ := values[name].(string)
+		return value
+	}
+	result := &tokenvalidationv1.Claims{
+		Sub:      str("sub"),
+		ClientId: str("client_id"),
+		Scope:    str("scope"),
+		Email:    str("email"),
+		Name:     str("name"),
+	}
+	switch aud := values["aud"].(type) {
+	case []string:
+		result.Aud = aud
+	case string:
+		if aud != "" {
+			result.Aud = []string{aud}
+		}
+	}
+	if cnf, ok := values["cnf"].(*utils.ConfirmationClaim); ok {
+		result.Cnf = &tokenvalidationv1.Confirmation{X5TS256: cnf.X5tS256, Jkt: cnf.JKT}
+	}
+	return result
+}
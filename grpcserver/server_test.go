@@ -0,0 +1,114 @@
+package grpcserver
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"net"
+	"oauth2-server/config"
+	"oauth2-server/handlers"
+	"oauth2-server/models"
+	tokenvalidationv1 "oauth2-server/proto/tokenvalidation/v1"
+	"oauth2-server/utils"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// startServer serves s on a local port until the test ends
+func startServer(t *testing.T, s *Server) tokenvalidationv1.TokenValidationClient {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve(ctx, ln, nil, time.Second)
+	}()
+
+	conn, err := grpc.NewClient(ln.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		cancel()
+		if err := <-served; err != nil {
+			t.Errorf("Serve failed: %v", err)
+		}
+	})
+	return tokenvalidationv1.NewTokenValidationClient(conn)
+}
+
+func TestServer_Validate(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	cfg := &config.Config{PrivateKey: privateKey, PublicKey: &privateKey.PublicKey}
+	client := startServer(t, New(handlers.NewTokenValidationHandler(nil, cfg), models.JSONWebKeySet{}))
+
+	token, _ := utils.GenerateAccessTokenForClient("user-1", "client-1", "user@example.com", "User", "openid profile", []string{"https://api.example.com"}, privateKey, 3600)
+	refreshToken, _ := utils.GenerateRefreshToken("user-1", "client-1", "openid", privateKey, 3600)
+	ctx := context.Background()
+
+	response, err := client.Validate(ctx, &tokenvalidationv1.ValidateRequest{Token: token, Resource: "https://api.example.com", Scopes: []string{"profile"}})
+	if err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	want := &tokenvalidationv1.Claims{Sub: "user-1", ClientId: "client-1", Scope: "openid profile", Aud: []string{"https://api.example.com"}}
+	if !response.Valid || response.Format != "JWT" || response.ExpiresAt == 0 {
+		t.Errorf("Expected a valid JWT, got %v", response)
+	}
+	if !proto.Equal(response.Claims, want) {
+		t.Errorf("Expected claims %v, got %v", want, response.Claims)
+	}
+
+	response, _ = client.Validate(ctx, &tokenvalidationv1.ValidateRequest{Token: token, Scopes: []string{"profile", "admin"}})
+	if response.Valid || response.Reason != "insufficient_scope" || !reflect.DeepEqual(response.MissingScopes, []string{"admin"}) || response.Claims != nil {
+		t.Errorf("Expected the missing admin scope, got %v", response)
+	}
+
+	response, _ = client.Validate(ctx, &tokenvalidationv1.ValidateRequest{Token: refreshToken})
+	if response.Valid || response.Reason != "wrong_token_type" {
+		t.Errorf("Expected a refresh token to be rejected as an access token, got %v", response)
+	}
+	response, _ = client.Validate(ctx, &tokenvalidationv1.ValidateRequest{Token: refreshToken, ExpectedTokenType: tokenvalidationv1.TokenType_TOKEN_TYPE_REFRESH_TOKEN})
+	if !response.Valid {
+		t.Errorf("Expected a valid refresh token, got %v", response)
+	}
+
+	if _, err := client.Validate(ctx, &tokenvalidationv1.ValidateRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument for a missing token, got %v", err)
+	}
+}
+
+func TestServer_WatchJWKS(t *testing.T) {
+	first := models.JSONWebKeySet{Keys: []models.JSONWebKey{{Kty: "RSA", Kid: "first", N: "n", E: "AQAB"}}}
+	server := New(handlers.NewTokenValidationHandler(nil, &config.Config{}), first)
+	client := startServer(t, server)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.WatchJWKS(ctx, &tokenvalidationv1.WatchJWKSRequest{})
+	if err != nil {
+		t.Fatalf("WatchJWKS failed: %v", err)
+	}
+	keys, err := stream.Recv()
+	if err != nil || len(keys.Keys) != 1 || keys.Keys[0].Kid != "first" {
+		t.Fatalf("Expected the current key set, got %v, %v", keys, err)
+	}
+
+	server.PublishKeys(models.JSONWebKeySet{Keys: []models.JSONWebKey{first.Keys[0], {Kty: "RSA", Kid: "second", N: "n", E: "AQAB"}}})
+	keys, err = stream.Recv()
+	if err != nil || len(keys.Keys) != 2 || keys.Keys[1].Kid != "second" {
+		t.Errorf("Expected the published key set, got %v, %v", keys, err)
+	}
+}
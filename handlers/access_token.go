@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rsa"
+	"errors"
+	"oauth2-server/config"
+	"oauth2-server/models"
+	"oauth2-server/repository"
+	"oauth2-server/utils"
+	"time"
+)
+
+// TokenStore issues and resolves access tokens and records and honors
+// revocations. Without an access token repository opaque tokens can be
+// neither issued nor resolved, so there are none to revoke either; without a
+// revocation repository, or with a nil TokenStore as in unit tests, there are
+// no revocations to honor.
+type TokenStore struct {
+	accessTokens *repository.AccessTokenRepository
+	revocations  *repository.RevocationRepository
+}
+
+func NewTokenStore(accessTokens *repository.AccessTokenRepository, revocations *repository.RevocationRepository) *TokenStore {
+	return &TokenStore{
+		accessTokens: accessTokens,
+		revocations:  revocations,
+	}
+}
+
+// opaqueTokens returns the opaque access token repository, or nil when
+// opaque tokens are not available
+func (s *TokenStore) opaqueTokens() *repository.AccessTokenRepository {
+	if s == nil {
+		return nil
+	}
+	return s.accessTokens
+}
+
+// opaqueTokenLength is the length of an opaque access token
+const opaqueTokenLength = 48
+
+// errInvalidAccessToken is returned for tokens that are malformed, expired,
+// revoked or unknown
+var errInvalidAccessToken = errors.New("invalid or expired token")
+
+// accessTokenInfo is what resource endpoints need to know about a presented
+// access token, whatever its format
+type accessTokenInfo struct {
+	Format     string // "JWT", "JWE" or "opaque"
+	UserID     string
+	Email      string
+	Name       string
+	Scope      string
+	ClientID   string
+	GrantType  string // client_credentials for machine tokens, which have no user
+	Audience   []string
+	Thumbprint string // x5t#S256 of the certificate a bound token must be used with
+	JKT        string // thumbprint of the DPoP key a bound token must be used with
+	ExpiresAt  time.Time
+	IssuedAt   time.Time
+}
+
+// issueAccessToken mints an access token in the format the client is
+// configured for. Opaque tokens are stored so they can be resolved and
+// revoked; JWTs are self-contained. A non-nil cnf binds the token to the
+// client certificate (RFC 8705) or DPoP key (RFC 9449).
+func (s *TokenStore) issueAccessToken(ctx context.Context, client *models.Client, userID, email, name, scope string, audience []string, cnf *utils.ConfirmationClaim, cfg *config.Config) (string, error) {
+	return s.issueAccessTokenWithExpiry(ctx, client, userID, email, name, scope, audience, cnf, cfg.AccessTokenExpiry, cfg)
+}
+
+// issueAccessTokenWithExpiry is issueAccessToken with a lifetime, in seconds,
+// other than the configured access token expiry
+func (s *TokenStore) issueAccessTokenWithExpiry(ctx context.Context, client *models.Client, userID, email, name, scope string, audience []string, cnf *utils.ConfirmationClaim, expiry int64, cfg *config.Config) (string, error) {
+	if client.TokenFormat != models.TokenFormatOpaque {
+		return utils.GenerateBoundAccessToken(userID, client.ClientID, email, name, scope, audience, nil, cnf, cfg.PrivateKey, expiry)
+	}
+
+	return s.storeOpaqueToken(ctx, &models.AccessToken{
+		UserID:    userID,
+		ClientID:  client.ClientID,
+		Scope:     scope,
+		Audience:  audience,
+		ExpiresAt: time.Now().Add(time.Duration(expiry) * time.Second),
+	}, cnf)
+}
+
+// issueClientAccessToken mints a machine token for a client acting on its
+// own behalf, in the format the client is configured for. Its subject is the
+// client and it carries no user claims.
+func (s *TokenStore) issueClientAccessToken(ctx context.Context, client *models.Client, scope string, audience []string, cnf *utils.ConfirmationClaim, cfg *config.Config) (string, error) {
+	if client.TokenFormat != models.TokenFormatOpaque {
+		return utils.GenerateClientAccessToken(client.ClientID, scope, audience, cnf, cfg.PrivateKey, cfg.AccessTokenExpiry)
+	}
+	return s.storeOpaqueToken(ctx, &models.AccessToken{
+		UserID:    client.ClientID,
+		ClientID:  client.ClientID,
+		GrantType: utils.ClientCredentialsGrantType,
+		Scope:     scope,
+		Audience:  audience,
+		ExpiresAt: time.Now().Add(time.Duration(cfg.AccessTokenExpiry) * time.Second),
+	}, cnf)
+}
+
+// storeOpaqueToken stores the record under a new random token and returns
+// the token
+func (s *TokenStore) storeOpaqueToken(ctx context.Context, record *models.AccessToken, cnf *utils.ConfirmationClaim) (string, error) {
+	accessTokens := s.opaqueTokens()
+	if accessTokens == nil {
+		return "", errors.New("opaque access tokens are not available")
+	}
+
+	token, err := utils.GenerateRandomString(opaqueTokenLength)
+	if err != nil {
+		return "", err
+	}
+	record.Token = token
+	if cnf != nil {
+		record.Thumbprint = cnf.X5tS256
+		record.JKT = cnf.JKT
+	}
+	if err := accessTokens.Create(ctx, record); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// jweEncryptionKey returns the public key server JWE tokens are encrypted to
+func jweEncryptionKey(cfg *config.Config) *rsa.PublicKey {
+	if cfg.EncryptionKey != nil {
+		return &cfg.EncryptionKey.PublicKey
+	}
+	return cfg.PublicKey
+}
+
+// jweDecryptionKeys returns the keys server JWE tokens are decrypted with,
+// the encryption key first and then the signing key tokens were encrypted to
+// before the server had a separate encryption key
+func jweDecryptionKeys(cfg *config.Config) []*rsa.PrivateKey {
+	if cfg.EncryptionKey == nil {
+		return []*rsa.PrivateKey{cfg.PrivateKey}
+	}
+	if !cfg.JWEAcceptSigningKey {
+		return []*rsa.PrivateKey{cfg.EncryptionKey}
+	}
+	return []*rsa.PrivateKey{cfg.EncryptionKey, cfg.PrivateKey}
+}
+
+// resolveAccessToken validates a JWT or JWE access token, or looks up an
+// opaque one in the token store. Tokens of revoked users and clients are
+// rejected.
+func (s *TokenStore) resolveAccessToken(ctx context.Context, token string, cfg *config.Config) (*accessTokenInfo, error) {
+	if utils.IsJWE(token) {
+		claims, err := utils.ValidateJWE(token, jweDecryptionKeys(cfg)...)
+		if err != nil || claims.TokenUse != "" {
+			return nil, errInvalidAccessToken
+		}
+		info := &accessTokenInfo{
+			Format:    "JWE",
+			UserID:    claims.UserID,
+			Email:     claims.Email,
+			Name:      claims.Name,
+			Scope:     claims.Scope,
+			ClientID:  claims.ClientID,
+			GrantType: claims.GrantType,
+			ExpiresAt: time.Unix(claims.Exp, 0),
+			IssuedAt:  time.Unix(claims.Iat, 0),
+		}
+		if claims.Aud != "" {
+			info.Audience = []string{claims.Aud}
+		}
+		if s.checkRevocation(ctx, info.UserID, info.ClientID, info.IssuedAt) != nil {
+			return nil, errInvalidAccessToken
+		}
+		return info, nil
+	}
+
+	if utils.IsJWT(token) {
+		claims, err := utils.ValidateToken(token, cfg.PublicKey)
+		if err != nil {
+			return nil, errInvalidAccessToken
+		}
+		info := &accessTokenInfo{
+			Format:    "JWT",
+			UserID:    claims.UserID,
+			Email:     claims.Email,
+			Name:      claims.Name,
+			Scope:     claims.Scope,
+			ClientID:  claims.ClientID,
+			GrantType: claims.GrantType,
+			Audience:  claims.Audience,
+		}
+		if claims.ExpiresAt != nil {
+			info.ExpiresAt = claims.ExpiresAt.Time
+		}
+		if claims.IssuedAt != nil {
+			info.IssuedAt = claims.IssuedAt.Time
+		}
+		if claims.Cnf != nil {
+			info.Thumbprint = claims.Cnf.X5tS256
+			info.JKT = claims.Cnf.JKT
+		}
+		if s.checkRevocation(ctx, info.UserID, info.ClientID, info.IssuedAt) != nil {
+			return nil, errInvalidAccessToken
+		}
+		return info, nil
+	}
+
+	accessTokens := s.opaqueTokens()
+	if accessTokens == nil || token == "" {
+		return nil, errInvalidAccessToken
+	}
+
+	record, err := accessTokens.FindByToken(ctx, token)
+	if err != nil {
+		return nil, errInvalidAccessToken
+	}
+	return &accessTokenInfo{
+		Format:     "opaque",
+		UserID:     record.UserID,
+		Scope:      record.Scope,
+		ClientID:   record.ClientID,
+		GrantType:  record.GrantType,
+		Audience:   record.Audience,
+		Thumbprint: record.Thumbprint,
+		JKT:        record.JKT,
+		ExpiresAt:  record.ExpiresAt,
+		IssuedAt:   record.CreatedAt,
+	}, nil
+}
+
+// machine reports whether the token was issued to a client for itself, so
+// there is no user behind it
+func (t *accessTokenInfo) machine() bool {
+	return t.GrantType == utils.ClientCredentialsGrantType
+}
+
+// revokeUserAccessTokens deletes the user's opaque access tokens. JWTs are
+// revoked through Revoker instead.
+func (s *TokenStore) revokeUserAccessTokens(ctx context.Context, userID string) error {
+	if s.opaqueTokens() == nil {
+		return nil
+	}
+	return s.accessTokens.DeleteByUserID(ctx, userID)
+}
+
+// revokeClientAccessTokens deletes the opaque access tokens issued to the
+// client
+func (s *TokenStore) revokeClientAccessTokens(ctx context.Context, clientID string) error {
+	if s.opaqueTokens() == nil {
+		return nil
+	}
+	return s.accessTokens.DeleteByClientID(ctx, clientID)
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"oauth2-server/config"
+	"oauth2-server/models"
+	"oauth2-server/utils"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIssueAccessToken_JWT(t *testing.T) {
+	tokens := NewTokenStore(nil, nil)
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	cfg := &config.Config{PrivateKey: privateKey, PublicKey: &privateKey.PublicKey, AccessTokenExpiry: 3600}
+	client := &models.Client{ClientID: "client-1"}
+
+	token, err := tokens.issueAccessToken(context.Background(), client, "user-1", "user@example.com", "User", "openid", []string{"https://api.example.com"}, nil, cfg)
+	if err != nil {
+		t.Fatalf("Failed to issue token: %v", err)
+	}
+	if !utils.IsJWT(token) {
+		t.Fatalf("Expected a JWT for a client without a token format, got %q", token)
+	}
+
+	info, err := tokens.resolveAccessToken(context.Background(), token, cfg)
+	if err != nil {
+		t.Fatalf("Failed to resolve token: %v", err)
+	}
+	if info.Format != "JWT" || info.UserID != "user-1" || info.ClientID != "client-1" || info.Scope != "openid" {
+		t.Errorf("Unexpected token info: %+v", info)
+	}
+	if len(info.Audience) != 1 || info.Audience[0] != "https://api.example.com" {
+		t.Errorf("Expected audience to be resolved, got %v", info.Audience)
+	}
+}
+
+func TestResolveAccessToken_JWE(t *testing.T) {
+	tokens := NewTokenStore(nil, nil)
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	cfg := &config.Config{PrivateKey: privateKey, PublicKey: &privateKey.PublicKey}
+	expiry := time.Now().Add(time.Hour).Unix()
+
+	token, err := utils.GenerateJWEAccessTokenForClient("user-1", "client-1", "", "", "openid", &privateKey.PublicKey, expiry)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	info, err := tokens.resolveAccessToken(context.Background(), token, cfg)
+	if err != nil {
+		t.Fatalf("Failed to resolve token: %v", err)
+	}
+	if info.Format != "JWE" || info.UserID != "user-1" || info.ClientID != "client-1" || info.machine() {
+		t.Errorf("Unexpected token info: %+v", info)
+	}
+
+	// A client credentials token carries the grant type and has no user
+	token, err = utils.EncryptJWE(utils.JWEAccessTokenClaims{
+		UserID:    "service-1",
+		ClientID:  "service-1",
+		GrantType: utils.ClientCredentialsGrantType,
+		Scope:     "reports:read",
+		Exp:       expiry,
+		Iat:       time.Now().Unix(),
+	}, &privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("Failed to encrypt token: %v", err)
+	}
+	info, err = tokens.resolveAccessToken(context.Background(), token, cfg)
+	if err != nil {
+		t.Fatalf("Failed to resolve token: %v", err)
+	}
+	if !info.machine() || info.ClientID != "service-1" {
+		t.Errorf("Expected a machine token, got %+v", info)
+	}
+}
+
+func TestIssueAccessToken_OpaqueWithoutStore(t *testing.T) {
+	tokens := NewTokenStore(nil, nil)
+	client := &models.Client{ClientID: "client-1", TokenFormat: models.TokenFormatOpaque}
+
+	if _, err := tokens.issueAccessToken(context.Background(), client, "user-1", "", "", "openid", nil, nil, &config.Config{}); err == nil {
+		t.Error("Expected opaque issuance to fail without a token store")
+	}
+}
+
+func TestResolveAccessToken_RejectsUnknownTokens(t *testing.T) {
+	tokens := NewTokenStore(nil, nil)
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	cfg := &config.Config{PrivateKey: privateKey, PublicKey: &privateKey.PublicKey}
+
+	for _, token := range []string{"", "opaque-token-without-store", "a.b.c"} {
+		if _, err := tokens.resolveAccessToken(context.Background(), token, cfg); err != errInvalidAccessToken {
+			t.Errorf("Expected %q to be rejected, got %v", token, err)
+		}
+	}
+}
+
+func TestIssueClientAccessToken(t *testing.T) {
+	tokens := NewTokenStore(nil, nil)
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	cfg := &config.Config{PrivateKey: privateKey, PublicKey: &privateKey.PublicKey, AccessTokenExpiry: 3600}
+	client := &models.Client{ClientID: "service-1", Name: "Reporting Service"}
+
+	token, err := tokens.issueClientAccessToken(context.Background(), client, "reports:read", nil, nil, cfg)
+	if err != nil {
+		t.Fatalf("Failed to issue token: %v", err)
+	}
+	info, err := tokens.resolveAccessToken(context.Background(), token, cfg)
+	if err != nil {
+		t.Fatalf("Failed to resolve token: %v", err)
+	}
+	if !info.machine() || info.UserID != "service-1" || info.ClientID != "service-1" || info.Name != "" {
+		t.Errorf("Expected a machine token without user claims, got %+v", info)
+	}
+
+	// Endpoints acting for a user reject it instead of looking up a user
+	req := httptest.NewRequest("GET", "/userinfo", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	(&OAuthHandler{config: cfg}).UserInfo(rr, req)
+	if rr.Code != http.StatusUnauthorized || !strings.Contains(rr.Body.String(), "not issued for a user") {
+		t.Errorf("Expected UserInfo to reject the machine token, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if _, _, err := parseBearerToken(req, tokens, cfg); err == nil {
+		t.Error("Expected parseBearerToken to reject the machine token")
+	}
+}
@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"oauth2-server/models"
+	"oauth2-server/repository"
+	"slices"
+	"strings"
+	"time"
+)
+
+// SSOAccountsCookieName lists the SSO sessions signed in on the browser, the
+// current one first, so prompt=select_account can offer a choice between
+// them. Signing in with another account adds its session instead of
+// replacing the others.
+const SSOAccountsCookieName = "oauth_sso_accounts"
+
+// maxSignedInAccounts bounds the accounts remembered on one browser
+const maxSignedInAccounts = 5
+
+// setSSOCookie makes sessionID the browser's current SSO session and adds it
+// to the accounts signed in on the browser
+func setSSOCookie(w http.ResponseWriter, r *http.Request, sessionID string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SSOCookieName,
+		Value:    sessionID,
+		Path:     SSOCookiePath,
+		MaxAge:   SSOCookieMaxAge,
+		HttpOnly: SSOCookieHTTPOnly,
+		Secure:   SSOCookieSecure,
+		SameSite: SSOCookieSameSite,
+	})
+
+	ids := slices.DeleteFunc(accountSessionIDs(r), func(id string) bool { return id == sessionID })
+	ids = append([]string{sessionID}, ids...)
+	if len(ids) > maxSignedInAccounts {
+		ids = ids[:maxSignedInAccounts]
+	}
+	setAccountsCookie(w, ids)
+}
+
+// forgetSSOAccount removes a signed-out session from the accounts signed in
+// on the browser, keeping the others
+func forgetSSOAccount(w http.ResponseWriter, r *http.Request, sessionID string) {
+	ids := accountSessionIDs(r)
+	if !slices.Contains(ids, sessionID) {
+		return
+	}
+	setAccountsCookie(w, slices.DeleteFunc(ids, func(id string) bool { return id == sessionID }))
+}
+
+func setAccountsCookie(w http.ResponseWriter, ids []string) {
+	cookie := &http.Cookie{
+		Name:     SSOAccountsCookieName,
+		Value:    strings.Join(ids, "."),
+		Path:     SSOCookiePath,
+		MaxAge:   SSOCookieMaxAge,
+		HttpOnly: SSOCookieHTTPOnly,
+		Secure:   SSOCookieSecure,
+		SameSite: SSOCookieSameSite,
+	}
+	if len(ids) == 0 {
+		cookie.MaxAge = -1
+	}
+	http.SetCookie(w, cookie)
+}
+
+// accountSessionIDs returns the session IDs in the browser's accounts
+// cookie. Session IDs are base64url, so never contain the separator.
+func accountSessionIDs(r *http.Request) []string {
+	cookie, err := r.Cookie(SSOAccountsCookieName)
+	if err != nil || cookie.Value == "" {
+		return nil
+	}
+	ids := strings.Split(cookie.Value, ".")
+	if len(ids) > maxSignedInAccounts {
+		ids = ids[:maxSignedInAccounts]
+	}
+	return ids
+}
+
+// signedInAccounts returns the active SSO sessions of the browser's accounts
+// cookie, one per user, in the cookie's order. Sessions that ended or
+// expired are left out.
+func signedInAccounts(ctx context.Context, r *http.Request, ssoRepo *repository.SSOSessionRepository, idleTimeout time.Duration) []*models.SSOSession {
+	var sessions []*models.SSOSession
+	seen := make(map[string]bool)
+	for _, id := range accountSessionIDs(r) {
+		session, err := ssoRepo.FindBySessionID(ctx, id)
+		if err != nil || !session.Active(time.Now(), idleTimeout) || seen[session.UserID] {
+			continue
+		}
+		seen[session.UserID] = true
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
+// selectAccountURL is the account chooser page for the authorization request
+// a login challenge names
+func selectAccountURL(challenge string) string {
+	return "/auth/select-account?login_challenge=" + url.QueryEscape(challenge)
+}
+
+// accountSessionRef stands in for a session ID in pages, so the ID itself is
+// never exposed to scripts
+func accountSessionRef(sessionID string) string {
+	sum := sha256.Sum256([]byte(sessionID))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// selectableAccount is an account listed on the chooser page. Ref stands in
+// for the session ID, which never appears in the page.
+type selectableAccount struct {
+	Ref     string
+	Name    string
+	Email   string
+	Current bool
+}
+
+// ShowSelectAccount lists the accounts signed in on the browser for an
+// authorization request sent with prompt=select_account, with a link to sign
+// in with another account. Without any, the login page is shown instead.
+// GET /auth/select-account?login_challenge=...
+func (h *AuthHandler) ShowSelectAccount(w http.ResponseWriter, r *http.Request) {
+	challenge := r.URL.Query().Get("login_challenge")
+	ctx := r.Context()
+	request, err := h.authRequests.FindByChallenge(ctx, challenge)
+	if err != nil || !request.Pending(models.AuthorizationStageLogin, time.Now()) {
+		respondError(w, http.StatusBadRequest, "invalid_request", "Invalid or expired login_challenge")
+		return
+	}
+
+	current := ""
+	if cookie, err := r.Cookie(SSOCookieName); err == nil {
+		current = cookie.Value
+	}
+	var accounts []selectableAccount
+	for _, session := range signedInAccounts(ctx, r, h.ssoSessionRepo, h.idleTimeout()) {
+		user, err := h.userRepo.FindByID(ctx, session.UserID)
+		if err != nil {
+			continue
+		}
+		accounts = append(accounts, selectableAccount{
+			Ref:     accountSessionRef(session.SessionID),
+			Name:    user.Name,
+			Email:   user.Email,
+			Current: session.SessionID == current,
+		})
+	}
+	if len(accounts) == 0 {
+		http.Redirect(w, r, loginURL(h.config, challenge), http.StatusFound)
+		return
+	}
+
+	data := h.authPageData(ctx, challenge)
+	data["CSRFToken"] = csrfToken(w, r)
+	data["Accounts"] = accounts
+	data["AddAccountURL"] = loginURL(h.config, challenge)
+	h.templates.Render(w, "select_account.html", data)
+}
+
+// SelectAccount switches the browser's current SSO session to the chosen
+// account, leaving the other accounts signed in, and continues the
+// authorization request
+// POST /auth/select-account
+func (h *AuthHandler) SelectAccount(w http.ResponseWriter, r *http.Request) {
+	if !requireCSRF(w, r, h.config) {
+		return
+	}
+
+	ctx := r.Context()
+	ref := r.PostFormValue("account")
+	var chosen *models.SSOSession
+	for _, session := range signedInAccounts(ctx, r, h.ssoSessionRepo, h.idleTimeout()) {
+		if ref != "" && accountSessionRef(session.SessionID) == ref {
+			chosen = session
+			break
+		}
+	}
+	if chosen == nil {
+		respondError(w, http.StatusBadRequest, "invalid_request", "The account is no longer signed in")
+		return
+	}
+
+	location, ok := h.continueAuthorization(ctx, r.PostFormValue("login_challenge"))
+	if !ok {
+		respondError(w, http.StatusBadRequest, "invalid_request", "Invalid or expired login_challenge")
+		return
+	}
+	setSSOCookie(w, r, chosen.SessionID)
+	http.Redirect(w, r, location, http.StatusSeeOther)
+}
+
+func (h *AuthHandler) idleTimeout() time.Duration {
+	return time.Duration(h.config.SSOIdleTimeout) * time.Second
+}
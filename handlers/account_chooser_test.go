@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// accountsCookie returns the accounts cookie a response set, if any
+func accountsCookie(w *httptest.ResponseRecorder) *http.Cookie {
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == SSOAccountsCookieName {
+			return cookie
+		}
+	}
+	return nil
+}
+
+func TestSetSSOCookie_RemembersAccounts(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: SSOAccountsCookieName, Value: "s1.s2.s3.s4.s5"})
+	w := httptest.NewRecorder()
+	setSSOCookie(w, r, "s3")
+
+	// The chosen session moves to the front
+	if got := accountsCookie(w).Value; got != "s3.s1.s2.s4.s5" {
+		t.Errorf("Expected s3 first, got %q", got)
+	}
+
+	// A new account pushes out the oldest one
+	w = httptest.NewRecorder()
+	setSSOCookie(w, r, "s6")
+	if got := accountsCookie(w).Value; got != "s6.s1.s2.s3.s4" {
+		t.Errorf("Expected at most %d accounts, got %q", maxSignedInAccounts, got)
+	}
+	var current *http.Cookie
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == SSOCookieName {
+			current = cookie
+		}
+	}
+	if current == nil || current.Value != "s6" || !current.HttpOnly {
+		t.Errorf("Expected s6 to be the current session, got %+v", current)
+	}
+}
+
+func TestForgetSSOAccount(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: SSOAccountsCookieName, Value: "s1.s2"})
+
+	w := httptest.NewRecorder()
+	forgetSSOAccount(w, r, "s1")
+	if got := accountSessionIDs(&http.Request{Header: http.Header{"Cookie": {accountsCookie(w).String()}}}); !reflect.DeepEqual(got, []string{"s2"}) {
+		t.Errorf("Expected only s2 to stay signed in, got %v", got)
+	}
+
+	w = httptest.NewRecorder()
+	forgetSSOAccount(w, r, "unknown")
+	if accountsCookie(w) != nil {
+		t.Error("Expected no change for a session that was not listed")
+	}
+
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: SSOAccountsCookieName, Value: "s1"})
+	w = httptest.NewRecorder()
+	forgetSSOAccount(w, r, "s1")
+	if cookie := accountsCookie(w); cookie == nil || cookie.MaxAge >= 0 {
+		t.Errorf("Expected the cookie to be cleared, got %+v", cookie)
+	}
+}
+
+func TestSelectAccountTemplate(t *testing.T) {
+	renderer := NewTemplateRenderer(false, "", "")
+	w := httptest.NewRecorder()
+	renderer.Render(w, "select_account.html", map[string]interface{}{
+		"LoginChallenge": "challenge-123",
+		"CSRFToken":      "csrf-456",
+		"ClientName":     "Demo App",
+		"AddAccountURL":  "/auth/login?login_challenge=challenge-123",
+		"Accounts": []selectableAccount{
+			{Ref: "ref-1", Name: "Alice", Email: "alice@example.com", Current: true},
+			{Ref: "ref-2", Name: "Bob", Email: "bob@example.com"},
+		},
+	})
+
+	body := w.Body.String()
+	for _, want := range []string{"alice@example.com", "bob@example.com", `value="ref-2"`, `value="csrf-456"`, "Use another account"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected %q in the page", want)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"oauth2-server/models"
+	"oauth2-server/repository"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// DeviceResponse is a browser remembered after MFA, as listed at
+// /account/devices
+type DeviceResponse struct {
+	ID         string `json:"id"`
+	UserAgent  string `json:"user_agent,omitempty"`
+	IPAddress  string `json:"ip_address,omitempty"`
+	CreatedAt  string `json:"created_at"`
+	LastUsedAt string `json:"last_used_at"`
+	ExpiresAt  string `json:"expires_at"`
+	// Current is set for the browser making the request
+	Current bool `json:"current"`
+}
+
+// ListDevicesResponse represents the response for listing trusted devices
+type ListDevicesResponse struct {
+	Devices []DeviceResponse `json:"devices"`
+}
+
+// ListDevices returns the browsers the authenticated user chose to remember
+// after MFA, which skip step-up challenges until they expire
+// GET /account/devices
+func (h *SessionHandler) ListDevices(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.authenticateDeviceRequest(w, r)
+	if !ok {
+		return
+	}
+
+	response := ListDevicesResponse{Devices: []DeviceResponse{}}
+	if h.trustedDevices == nil {
+		respondJSON(w, http.StatusOK, response)
+		return
+	}
+
+	devices, err := h.trustedDevices.ListByUserID(r.Context(), userID)
+	if err != nil {
+		respondInternalError(w, err, "Failed to retrieve devices")
+		return
+	}
+	current := ""
+	if cookie, err := r.Cookie(TrustedDeviceCookieName); err == nil && cookie.Value != "" {
+		current = secretHash(cookie.Value)
+	}
+	for _, device := range devices {
+		response.Devices = append(response.Devices, DeviceResponse{
+			ID:         device.ID,
+			UserAgent:  device.UserAgent,
+			IPAddress:  device.IPAddress,
+			CreatedAt:  device.CreatedAt.Format(time.RFC3339),
+			LastUsedAt: device.LastUsedAt.Format(time.RFC3339),
+			ExpiresAt:  device.ExpiresAt.Format(time.RFC3339),
+			Current:    device.TokenHash == current,
+		})
+	}
+	respondJSON(w, http.StatusOK, response)
+}
+
+// RevokeDevice forgets one remembered browser; its next step-up request
+// asks for a code again
+// DELETE /account/devices/{device_id}
+func (h *SessionHandler) RevokeDevice(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.authenticateDeviceRequest(w, r)
+	if !ok {
+		return
+	}
+	if h.trustedDevices == nil {
+		respondError(w, http.StatusNotFound, "not_found", "Device not found")
+		return
+	}
+
+	err := h.trustedDevices.Delete(r.Context(), userID, mux.Vars(r)["device_id"])
+	if errors.Is(err, repository.ErrNotFound) {
+		respondError(w, http.StatusNotFound, "not_found", "Device not found")
+		return
+	}
+	if err != nil {
+		respondInternalError(w, err, "Failed to revoke device")
+		return
+	}
+	h.audit.record(r, models.AuditDeviceForgotten, userID, "", nil)
+
+	respondJSON(w, http.StatusOK, map[string]string{
+		"message": "Device revoked successfully",
+	})
+}
+
+// RevokeAllDevices forgets every browser the user remembered
+// DELETE /account/devices
+func (h *SessionHandler) RevokeAllDevices(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.authenticateDeviceRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if h.trustedDevices != nil {
+		if err := h.trustedDevices.DeleteByUserID(r.Context(), userID); err != nil {
+			respondInternalError(w, err, "Failed to revoke devices")
+			return
+		}
+	}
+	h.audit.record(r, models.AuditDeviceForgotten, userID, "", map[string]string{"bulk": "true"})
+
+	respondJSON(w, http.StatusOK, map[string]string{
+		"message": "All devices revoked successfully",
+	})
+}
+
+// authenticateDeviceRequest returns the user of the request's access token,
+// answering 401 when there is none
+func (h *SessionHandler) authenticateDeviceRequest(w http.ResponseWriter, r *http.Request) (string, bool) {
+	userID, err := h.extractUserIDFromToken(r)
+	if err != nil {
+		if authErr, ok := err.(*AuthError); ok {
+			respondError(w, http.StatusUnauthorized, authErr.Code, authErr.Message)
+			return "", false
+		}
+		respondError(w, http.StatusUnauthorized, "unauthorized", "Authentication failed")
+		return "", false
+	}
+	return userID, true
+}
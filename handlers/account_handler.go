@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"oauth2-server/config"
+	"oauth2-server/models"
+	"oauth2-server/repository"
+	"oauth2-server/utils"
+	"time"
+)
+
+// accountExportAuditLimit caps the audit entries included in a data export
+const accountExportAuditLimit = 10000
+
+// AccountHandler lets users download the data held about them and delete
+// their account, authenticated with an access token
+type AccountHandler struct {
+	userRepo       *repository.UserRepository
+	clientRepo     *repository.ClientRepository
+	ssoSessionRepo *repository.SSOSessionRepository
+	consentRepo    *repository.UserConsentRepository
+	groupRepo      *repository.GroupRepository
+	auditRepo      *repository.AuditRepository
+	deleter        *UserDeleter
+	audit          *Auditor
+	tokens         *TokenStore
+	config         *config.Config
+}
+
+func NewAccountHandler(
+	userRepo *repository.UserRepository,
+	clientRepo *repository.ClientRepository,
+	ssoSessionRepo *repository.SSOSessionRepository,
+	consentRepo *repository.UserConsentRepository,
+	groupRepo *repository.GroupRepository,
+	auditRepo *repository.AuditRepository,
+	deleter *UserDeleter,
+	audit *Auditor,
+	tokens *TokenStore,
+	cfg *config.Config,
+) *AccountHandler {
+	return &AccountHandler{
+		userRepo:       userRepo,
+		clientRepo:     clientRepo,
+		ssoSessionRepo: ssoSessionRepo,
+		consentRepo:    consentRepo,
+		groupRepo:      groupRepo,
+		auditRepo:      auditRepo,
+		deleter:        deleter,
+		audit:          audit,
+		tokens:         tokens,
+		config:         cfg,
+	}
+}
+
+// AccountExport is everything held about a user
+type AccountExport struct {
+	ExportedAt  time.Time             `json:"exported_at"`
+	Profile     *models.User          `json:"profile"`
+	Consents    []*models.UserConsent `json:"consents"`
+	Sessions    []ExportedSession     `json:"sessions"`
+	Groups      []string              `json:"groups"`
+	Clients     []*models.Client      `json:"clients"`
+	AuditEvents []*models.AuditEntry  `json:"audit_events"`
+}
+
+// ExportedSession is an SSO session in a data export. The session ID is
+// left out since it would sign the holder of the file in.
+type ExportedSession struct {
+	CreatedAt    time.Time `json:"created_at"`
+	LastActivity time.Time `json:"last_activity"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	IPAddress    string    `json:"ip_address,omitempty"`
+	UserAgent    string    `json:"user_agent,omitempty"`
+}
+
+// ExportAccount returns the user's profile, consents, sessions, groups,
+// registered clients and audit events as a JSON download
+// GET /account/export
+func (h *AccountHandler) ExportAccount(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	export := AccountExport{
+		ExportedAt:  time.Now().UTC(),
+		Profile:     user,
+		Sessions:    []ExportedSession{},
+		Groups:      []string{},
+		AuditEvents: []*models.AuditEntry{},
+	}
+
+	var err error
+	if export.Consents, err = h.consentRepo.ListUserConsents(ctx, user.ID); err != nil {
+		respondInternalError(w, err, "Failed to retrieve consents")
+		return
+	}
+	if export.Clients, err = h.clientRepo.FindByOwner(ctx, user.ID); err != nil {
+		respondInternalError(w, err, "Failed to retrieve clients")
+		return
+	}
+
+	sessions, err := h.ssoSessionRepo.FindByUserID(ctx, user.ID)
+	if err != nil {
+		respondInternalError(w, err, "Failed to retrieve sessions")
+		return
+	}
+	for _, session := range sessions {
+		export.Sessions = append(export.Sessions, ExportedSession{
+			CreatedAt:    session.CreatedAt,
+			LastActivity: session.LastActivity,
+			ExpiresAt:    session.ExpiresAt,
+			IPAddress:    session.IPAddress,
+			UserAgent:    session.UserAgent,
+		})
+	}
+
+	groups, err := h.groupRepo.FindByMember(ctx, user.ID)
+	if err != nil {
+		respondInternalError(w, err, "Failed to retrieve groups")
+		return
+	}
+	for _, group := range groups {
+		export.Groups = append(export.Groups, group.DisplayName)
+	}
+
+	if h.auditRepo != nil {
+		if export.AuditEvents, err = h.auditRepo.List(ctx, repository.AuditFilter{UserID: user.ID}, accountExportAuditLimit); err != nil {
+			respondInternalError(w, err, "Failed to retrieve activity")
+			return
+		}
+	}
+
+	h.audit.record(r, models.AuditAccountExported, user.ID, "", nil)
+	w.Header().Set("Content-Disposition", `attachment; filename="account-export.json"`)
+	w.Header().Set("Cache-Control", "no-store")
+	respondJSON(w, http.StatusOK, export)
+}
+
+// DeleteAccount deletes the user's account once they have confirmed their
+// password. Every token issued to them is revoked and their sessions and
+// consents are removed.
+// DELETE /account
+func (h *AccountHandler) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Password == "" {
+		respondError(w, http.StatusBadRequest, "invalid_request", "The current password is required")
+		return
+	}
+	if user.Password == "" {
+		respondError(w, http.StatusForbidden, "access_denied", "This account has no password; ask an administrator to delete it")
+		return
+	}
+	if !utils.CheckPasswordHash(req.Password, user.Password) {
+		h.audit.record(r, models.AuditLoginFailure, user.ID, "", map[string]string{"reason": "invalid_password", "flow": "account_deletion"})
+		respondError(w, http.StatusUnauthorized, "invalid_credentials", "Invalid password")
+		return
+	}
+
+	if err := h.deleter.Delete(r.Context(), user.ID); err != nil {
+		respondInternalError(w, err, "Failed to delete account")
+		return
+	}
+	h.audit.record(r, models.AuditUserDeleted, user.ID, "", map[string]string{"flow": "self_service"})
+
+	// The browser's SSO session is gone; drop its cookie too
+	http.SetCookie(w, &http.Cookie{Name: SSOCookieName, Path: SSOCookiePath, MaxAge: -1})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authenticate resolves the access token's user
+func (h *AccountHandler) authenticate(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	userID, _, err := parseBearerToken(r, h.tokens, h.config)
+	if err != nil {
+		if authErr, ok := err.(*AuthError); ok {
+			respondError(w, http.StatusUnauthorized, authErr.Code, authErr.Message)
+			return nil, false
+		}
+		respondError(w, http.StatusUnauthorized, "unauthorized", "Authentication failed")
+		return nil, false
+	}
+
+	user, err := h.userRepo.FindByID(r.Context(), userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		respondError(w, http.StatusUnauthorized, "invalid_token", "Invalid or expired token")
+		return nil, false
+	}
+	if err != nil {
+		respondInternalError(w, err, "Failed to look up user")
+		return nil, false
+	}
+	return user, true
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"oauth2-server/config"
+	"oauth2-server/models"
+	"strings"
+	"testing"
+)
+
+func TestAccountHandler_RequiresAccessToken(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	h := NewAccountHandler(nil, nil, nil, nil, nil, nil, nil, nil, nil, &config.Config{PrivateKey: privateKey, PublicKey: &privateKey.PublicKey})
+
+	tests := []struct {
+		name          string
+		handler       http.HandlerFunc
+		method        string
+		target        string
+		authorization string
+		wantError     string
+	}{
+		{"Export without token", h.ExportAccount, "GET", "/account/export", "", "unauthorized"},
+		{"Export with invalid token", h.ExportAccount, "GET", "/account/export", "Bearer not-a-token", "invalid_token"},
+		{"Delete without token", h.DeleteAccount, "DELETE", "/account", "", "unauthorized"},
+		{"Delete with invalid token", h.DeleteAccount, "DELETE", "/account", "Bearer not-a-token", "invalid_token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(`{"password": "secret"}`))
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			tt.handler(w, r)
+
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
+			}
+			var resp models.ErrorResponse
+			json.NewDecoder(w.Body).Decode(&resp)
+			if resp.Error != tt.wantError {
+				t.Errorf("Expected error %q, got %q", tt.wantError, resp.Error)
+			}
+		})
+	}
+}
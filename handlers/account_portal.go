@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"oauth2-server/middleware"
+	"oauth2-server/models"
+	"oauth2-server/repository"
+	"strings"
+)
+
+// Number of audit entries read for the recent activity list, and how many
+// of the ones worth showing are kept
+const (
+	accountActivityScan  = 100
+	accountActivityLimit = 20
+)
+
+// accountActivityLabels names the audit events shown to users as recent
+// activity; other events, such as every token refresh, are left out
+var accountActivityLabels = map[string]string{
+	models.AuditLoginSuccess:    "Signed in",
+	models.AuditLoginFailure:    "Failed sign-in attempt",
+	models.AuditLogout:          "Signed out",
+	models.AuditConsentGranted:  "Allowed access to",
+	models.AuditConsentDenied:   "Denied access to",
+	models.AuditConsentRevoked:  "Removed access for",
+	models.AuditSessionRevoked:  "Signed out a session",
+	models.AuditIdentityLinked:  "Linked a sign-in provider",
+	models.AuditAccountExported: "Downloaded your data",
+	models.AuditMFAVerified:     "Completed two-step verification",
+	models.AuditLoginUnfamiliar: "Signed in from a new device or location",
+	models.AuditLoginConfirmed:  "Confirmed a sign-in",
+}
+
+// accountSession is an SSO session as listed on the account page. Ref
+// identifies it in the revoke form without putting the session ID, the
+// cookie value, in the page.
+type accountSession struct {
+	Ref          string
+	DeviceName   string
+	UserAgent    string
+	IPAddress    string
+	LastActivity string
+	Current      bool
+}
+
+// accountApp is an application the user has authorized
+type accountApp struct {
+	ClientID   string
+	ClientName string
+	Scopes     []string
+	GrantedAt  string
+}
+
+// accountActivity is one line of the recent activity list
+type accountActivity struct {
+	Label      string
+	ClientName string
+	IPAddress  string
+	When       string
+}
+
+// ShowAccount renders the account page: connected applications, signed-in
+// sessions and recent activity, each with a way to revoke access. It serves
+// deployments without a separate frontend for the /account JSON API.
+// GET /account
+func (h *SessionHandler) ShowAccount(w http.ResponseWriter, r *http.Request) {
+	ssoSession, _ := r.Context().Value(middleware.SSOSessionContextKey).(*models.SSOSession)
+	if ssoSession == nil || !ssoSession.Authenticated {
+		h.templates.Render(w, "account.html", map[string]interface{}{"CSRFToken": csrfToken(w, r)})
+		return
+	}
+
+	ctx := r.Context()
+	userID := ssoSession.UserID
+
+	sessions, err := h.ssoSessionRepo.FindByUserID(ctx, userID)
+	if err != nil {
+		respondInternalError(w, err, "Failed to retrieve sessions")
+		return
+	}
+	consents, err := h.consentRepo.ListUserConsents(ctx, userID)
+	if err != nil {
+		respondInternalError(w, err, "Failed to retrieve authorizations")
+		return
+	}
+
+	// Client names are looked up once per page
+	names := make(map[string]string)
+	clientName := func(clientID string) string {
+		if name, ok := names[clientID]; ok {
+			return name
+		}
+		name := clientID
+		if client, err := h.clientRepo.FindByClientID(ctx, clientID); err == nil {
+			name = client.Name
+		}
+		names[clientID] = name
+		return name
+	}
+
+	apps := make([]accountApp, 0, len(consents))
+	for _, consent := range consents {
+		apps = append(apps, accountApp{
+			ClientID:   consent.ClientID,
+			ClientName: clientName(consent.ClientID),
+			Scopes:     consent.Scopes,
+			GrantedAt:  consent.GrantedAt.Format("2 Jan 2006"),
+		})
+	}
+
+	listed := make([]accountSession, 0, len(sessions))
+	for _, session := range sessions {
+		listed = append(listed, accountSession{
+			Ref:          accountSessionRef(session.SessionID),
+			DeviceName:   sessionDeviceName(session),
+			UserAgent:    session.UserAgent,
+			IPAddress:    session.IPAddress,
+			LastActivity: session.LastActivity.Format("2 Jan 2006 15:04"),
+			Current:      session.SessionID == ssoSession.SessionID,
+		})
+	}
+
+	var activity []accountActivity
+	if h.auditRepo != nil {
+		entries, err := h.auditRepo.List(ctx, repository.AuditFilter{UserID: userID}, accountActivityScan)
+		if err != nil {
+			respondInternalError(w, err, "Failed to retrieve activity")
+			return
+		}
+		for _, entry := range entries {
+			label, ok := accountActivityLabels[entry.Event]
+			if !ok {
+				continue
+			}
+			item := accountActivity{
+				Label:     label,
+				IPAddress: entry.IPAddress,
+				When:      entry.CreatedAt.Format("2 Jan 2006 15:04"),
+			}
+			if entry.ClientID != "" {
+				item.ClientName = clientName(entry.ClientID)
+			}
+			activity = append(activity, item)
+			if len(activity) == accountActivityLimit {
+				break
+			}
+		}
+	}
+
+	h.templates.Render(w, "account.html", map[string]interface{}{
+		"SignedIn":  true,
+		"CSRFToken": csrfToken(w, r),
+		"Apps":      apps,
+		"Sessions":  listed,
+		"Activity":  activity,
+	})
+}
+
+// ManageAccount handles the revoke buttons of the account page and sends
+// the user back to it
+// POST /account
+func (h *SessionHandler) ManageAccount(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_request", "Failed to parse form")
+		return
+	}
+
+	ssoSession, _ := r.Context().Value(middleware.SSOSessionContextKey).(*models.SSOSession)
+	if ssoSession == nil || !ssoSession.Authenticated {
+		respondError(w, http.StatusUnauthorized, "unauthorized", "Authentication required")
+		return
+	}
+	if !requireCSRF(w, r, h.config) {
+		return
+	}
+
+	ctx := r.Context()
+	userID := ssoSession.UserID
+	switch r.FormValue("action") {
+	case "revoke_session":
+		// Only the user's own sessions are searched for the one to revoke
+		sessions, err := h.ssoSessionRepo.FindByUserID(ctx, userID)
+		if err != nil {
+			respondInternalError(w, err, "Failed to revoke session")
+			return
+		}
+		ref := r.FormValue("session")
+		for _, session := range sessions {
+			if accountSessionRef(session.SessionID) != ref {
+				continue
+			}
+			if err := h.ssoSessionRepo.Delete(ctx, session.SessionID); err != nil {
+				respondInternalError(w, err, "Failed to revoke session")
+				return
+			}
+			h.audit.record(r, models.AuditSessionRevoked, userID, "", nil)
+		}
+
+	case "revoke_authorization":
+		clientID := r.FormValue("client_id")
+		consent, err := h.consentRepo.FindByUserAndClient(ctx, userID, clientID)
+		if errors.Is(err, repository.ErrNotFound) {
+			break
+		}
+		if err != nil {
+			respondInternalError(w, err, "Failed to revoke authorization")
+			return
+		}
+		if err := h.consentRepo.RevokeConsent(ctx, userID, clientID, accountSessionRef(ssoSession.SessionID)); err != nil {
+			respondInternalError(w, err, "Failed to revoke authorization")
+			return
+		}
+		if err := h.revokeClientGrant(ctx, userID, clientID); err != nil {
+			respondInternalError(w, err, "Failed to revoke authorization")
+			return
+		}
+		h.audit.record(r, models.AuditConsentRevoked, userID, clientID, map[string]string{"scope": strings.Join(consent.Scopes, " ")})
+
+	default:
+		respondError(w, http.StatusBadRequest, "invalid_request", "Invalid action")
+		return
+	}
+
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
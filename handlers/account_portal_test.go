@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"oauth2-server/config"
+	"oauth2-server/middleware"
+	"oauth2-server/models"
+	"strings"
+	"testing"
+)
+
+func TestSessionHandler_ShowAccountAsksSignedOutUserToSignIn(t *testing.T) {
+	handler := NewSessionHandler(nil, nil, nil, nil, nil, nil, NewTemplateRenderer(false, "", ""), nil, nil, &config.Config{})
+
+	w := httptest.NewRecorder()
+	handler.ShowAccount(w, httptest.NewRequest(http.MethodGet, "/account", nil))
+
+	body := w.Body.String()
+	if !strings.Contains(body, `id="loginForm"`) {
+		t.Error("Expected the sign-in form")
+	}
+	if strings.Contains(body, `action="/account"`) {
+		t.Error("Expected no revoke forms before signing in")
+	}
+}
+
+func TestSessionHandler_ManageAccountChecksCSRFToken(t *testing.T) {
+	handler := NewSessionHandler(nil, nil, nil, nil, nil, nil, NewTemplateRenderer(false, "", ""), nil, nil, &config.Config{})
+	session := &models.SSOSession{SessionID: "sso-1", UserID: "user-1", Authenticated: true}
+
+	tests := []struct {
+		name     string
+		session  *models.SSOSession
+		token    string
+		expected int
+	}{
+		{"Signed out", nil, "csrf-1", http.StatusUnauthorized},
+		{"Missing token", session, "", http.StatusForbidden},
+		{"Wrong token", session, "csrf-2", http.StatusForbidden},
+		{"Unknown action", session, "csrf-1", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{"csrf_token": {tt.token}, "action": {"bogus"}}
+			req := httptest.NewRequest(http.MethodPost, "/account", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.AddCookie(&http.Cookie{Name: CSRFCookieName, Value: "csrf-1"})
+			if tt.session != nil {
+				req = req.WithContext(context.WithValue(req.Context(), middleware.SSOSessionContextKey, tt.session))
+			}
+			w := httptest.NewRecorder()
+			handler.ManageAccount(w, req)
+
+			if w.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, w.Code)
+			}
+		})
+	}
+}
+
+func TestAccountTemplate(t *testing.T) {
+	w := httptest.NewRecorder()
+	NewTemplateRenderer(false, "", "").Render(w, "account.html", map[string]interface{}{
+		"SignedIn":  true,
+		"CSRFToken": "token-1",
+		"Apps":      []accountApp{{ClientID: "client-1", ClientName: "<b>Demo</b>", Scopes: []string{"openid", "email"}, GrantedAt: "1 Jan 2026"}},
+		"Sessions":  []accountSession{{Ref: "ref-1", UserAgent: "Firefox", Current: true}},
+		"Activity":  []accountActivity{{Label: "Allowed access to", ClientName: "Demo"}},
+	})
+
+	body := w.Body.String()
+	for _, want := range []string{
+		`value="client-1"`,
+		`value="ref-1"`,
+		`value="token-1"`,
+		"&lt;b&gt;Demo&lt;/b&gt;",
+		"openid, email",
+		"This device",
+		"Allowed access to",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected page to contain %q", want)
+		}
+	}
+}
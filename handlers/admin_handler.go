@@ -0,0 +1,466 @@
+package handlers
+
+import (
+	"net/http"
+	"oauth2-server/config"
+	"oauth2-server/models"
+	"oauth2-server/repository"
+	"oauth2-server/utils"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// AdminScope must be present in the access token used for the admin API.
+// The token's user must also hold the admin role, so a client cannot grant
+// admin access just by requesting the scope.
+const AdminScope = "admin"
+
+const (
+	defaultAdminPageSize = 20
+	maxAdminPageSize     = 100
+)
+
+// AdminHandler serves the user management API under /admin/users
+type AdminHandler struct {
+	userRepo       *repository.UserRepository
+	clientRepo     *repository.ClientRepository
+	ssoSessionRepo *repository.SSOSessionRepository
+	consentRepo    *repository.UserConsentRepository
+	deleter        *UserDeleter
+	revoker        *Revoker
+	audit          *Auditor
+	tokens         *TokenStore
+	config         *config.Config
+}
+
+func NewAdminHandler(
+	userRepo *repository.UserRepository,
+	clientRepo *repository.ClientRepository,
+	ssoSessionRepo *repository.SSOSessionRepository,
+	consentRepo *repository.UserConsentRepository,
+	deleter *UserDeleter,
+	revoker *Revoker,
+	audit *Auditor,
+	tokens *TokenStore,
+	cfg *config.Config,
+) *AdminHandler {
+	return &AdminHandler{
+		userRepo:       userRepo,
+		clientRepo:     clientRepo,
+		ssoSessionRepo: ssoSessionRepo,
+		consentRepo:    consentRepo,
+		deleter:        deleter,
+		revoker:        revoker,
+		audit:          audit,
+		tokens:         tokens,
+		config:         cfg,
+	}
+}
+
+// ListUsersResponse is a page of users from the admin API
+type ListUsersResponse struct {
+	Users []*models.User `json:"users"`
+	Total int64          `json:"total"`
+	Page  int64          `json:"page"`
+	Limit int64          `json:"limit"`
+}
+
+// ListConsentsResponse is a page of a user's consents from the admin API
+type ListConsentsResponse struct {
+	Consents []*models.UserConsent `json:"consents"`
+	PageInfo
+}
+
+// ConsentHistoryResponse is a user's consent for a client with every change
+// made to it
+type ConsentHistoryResponse struct {
+	UserID   string                `json:"user_id"`
+	ClientID string                `json:"client_id"`
+	Status   string                `json:"status"`
+	Version  int                   `json:"version"`
+	History  []models.ConsentEvent `json:"history"`
+}
+
+// RequireAdmin wraps a handler so it only runs for a valid access token that
+// carries the admin scope and belongs to a user with the admin role
+func (h *AdminHandler) RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if authorizeAdmin(w, r, h.userRepo, h.tokens, h.config) {
+			next(w, r)
+		}
+	}
+}
+
+// authorizeAdmin checks that the bearer token carries the admin scope and
+// belongs to an enabled user with the admin role, writing the error response
+// when it does not
+func authorizeAdmin(w http.ResponseWriter, r *http.Request, userRepo *repository.UserRepository, tokens *TokenStore, cfg *config.Config) bool {
+	userID, scope, err := parseBearerToken(r, tokens, cfg)
+	if err != nil {
+		if authErr, ok := err.(*AuthError); ok {
+			respondError(w, http.StatusUnauthorized, authErr.Code, authErr.Message)
+			return false
+		}
+		respondError(w, http.StatusUnauthorized, "unauthorized", "Authentication failed")
+		return false
+	}
+
+	if !utils.HasScope(scope, AdminScope) {
+		respondError(w, http.StatusForbidden, "insufficient_scope", "The admin scope is required")
+		return false
+	}
+
+	admin, err := userRepo.FindByID(r.Context(), userID)
+	if err != nil || admin.Disabled || !admin.HasRole(models.RoleAdmin) {
+		respondError(w, http.StatusForbidden, "access_denied", "Admin role required")
+		return false
+	}
+	return true
+}
+
+// ListUsers returns users matching the optional search query, paginated
+// GET /admin/users?q=...&page=1&limit=20
+func (h *AdminHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	page := parsePositiveInt(query.Get("page"), 1)
+	limit := parsePositiveInt(query.Get("limit"), defaultAdminPageSize)
+	if limit > maxAdminPageSize {
+		limit = maxAdminPageSize
+	}
+
+	users, total, err := h.userRepo.List(r.Context(), query.Get("q"), page, limit)
+	if err != nil {
+		respondInternalError(w, err, "Failed to list users")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, ListUsersResponse{
+		Users: users,
+		Total: total,
+		Page:  page,
+		Limit: limit,
+	})
+}
+
+// GetUser returns a single user
+// GET /admin/users/{user_id}
+func (h *AdminHandler) GetUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.findUser(w, r)
+	if !ok {
+		return
+	}
+	respondJSON(w, http.StatusOK, user)
+}
+
+// ListUserSessions returns a page of the user's SSO sessions, with the same
+// parameters as GET /account/sessions
+// GET /admin/users/{user_id}/sessions
+func (h *AdminHandler) ListUserSessions(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.findUser(w, r)
+	if !ok {
+		return
+	}
+
+	page, err := parsePageRequest(r.URL.Query(), "created_at", "last_activity")
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	sessions, next, err := h.ssoSessionRepo.ListByUserID(r.Context(), user.ID, sessionFilter(r, h.config), page)
+	if err != nil {
+		respondPageError(w, err, "Failed to retrieve sessions")
+		return
+	}
+
+	sessionResponses := make([]SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		sessionResponses = append(sessionResponses, newSessionResponse(session, h.config))
+	}
+
+	respondJSON(w, http.StatusOK, ListSessionsResponse{Sessions: sessionResponses, PageInfo: newPageInfo(page, next)})
+}
+
+// ListUserConsents returns a page of the consents the user has granted, with
+// the same parameters as GET /account/authorizations plus status=revoked to
+// list revoked consents instead of active ones
+// GET /admin/users/{user_id}/consents
+func (h *AdminHandler) ListUserConsents(w http.ResponseWriter, r *http.Request) {
+	filter := consentFilter(r)
+	filter.Status = r.URL.Query().Get("status")
+	if filter.Status != "" && filter.Status != models.ConsentStatusActive && filter.Status != models.ConsentStatusRevoked {
+		respondError(w, http.StatusBadRequest, "invalid_request", "status must be active or revoked")
+		return
+	}
+
+	user, ok := h.findUser(w, r)
+	if !ok {
+		return
+	}
+
+	page, err := parsePageRequest(r.URL.Query(), "granted_at")
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	consents, next, err := h.consentRepo.ListPageByUserID(r.Context(), user.ID, filter, page)
+	if err != nil {
+		respondPageError(w, err, "Failed to retrieve consents")
+		return
+	}
+	if consents == nil {
+		consents = []*models.UserConsent{}
+	}
+
+	respondJSON(w, http.StatusOK, ListConsentsResponse{Consents: consents, PageInfo: newPageInfo(page, next)})
+}
+
+// ConsentHistory returns the user's consent for a client, active or revoked,
+// with the history of grants, added scopes, expiries and revocations
+// GET /admin/users/{user_id}/consents/{client_id}/history
+func (h *AdminHandler) ConsentHistory(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.findUser(w, r)
+	if !ok {
+		return
+	}
+
+	consent, err := h.consentRepo.FindHistory(r.Context(), user.ID, mux.Vars(r)["client_id"])
+	if err != nil {
+		respondRepositoryError(w, err, "Consent", "Failed to retrieve consent history")
+		return
+	}
+	history := consent.History
+	if history == nil {
+		history = []models.ConsentEvent{}
+	}
+
+	respondJSON(w, http.StatusOK, ConsentHistoryResponse{
+		UserID:   consent.UserID,
+		ClientID: consent.ClientID,
+		Status:   consent.Status,
+		Version:  consent.Version,
+		History:  history,
+	})
+}
+
+// DisableUser blocks the account from logging in, ends its SSO sessions and
+// revokes every token issued to it
+// POST /admin/users/{user_id}/disable
+func (h *AdminHandler) DisableUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.findUser(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	if err := h.userRepo.SetDisabled(ctx, user.ID, true); err != nil {
+		respondInternalError(w, err, "Failed to disable user")
+		return
+	}
+	if err := h.revoker.RevokeUser(ctx, user.ID); err != nil {
+		respondInternalError(w, err, "Failed to revoke tokens")
+		return
+	}
+
+	h.audit.record(r, models.AuditUserDisabled, user.ID, "", nil)
+	respondJSON(w, http.StatusOK, map[string]interface{}{"user_id": user.ID, "disabled": true})
+}
+
+// EnableUser re-enables a disabled account
+// POST /admin/users/{user_id}/enable
+func (h *AdminHandler) EnableUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.findUser(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.userRepo.SetDisabled(r.Context(), user.ID, false); err != nil {
+		respondInternalError(w, err, "Failed to enable user")
+		return
+	}
+
+	h.audit.record(r, models.AuditUserEnabled, user.ID, "", nil)
+	respondJSON(w, http.StatusOK, map[string]interface{}{"user_id": user.ID, "disabled": false})
+}
+
+// ForcePasswordReset requires the user to choose a new password at next
+// login and ends their SSO sessions
+// POST /admin/users/{user_id}/force-password-reset
+func (h *AdminHandler) ForcePasswordReset(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.findUser(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	if err := h.userRepo.SetPasswordResetRequired(ctx, user.ID, true); err != nil {
+		respondInternalError(w, err, "Failed to require password reset")
+		return
+	}
+	if err := h.ssoSessionRepo.DeleteByUserID(ctx, user.ID); err != nil {
+		respondInternalError(w, err, "Failed to revoke sessions")
+		return
+	}
+
+	h.audit.record(r, models.AuditPasswordResetForced, user.ID, "", nil)
+	respondJSON(w, http.StatusOK, map[string]interface{}{"user_id": user.ID, "password_reset_required": true})
+}
+
+// RevokeUserSessions logs the user out everywhere: their SSO sessions end
+// and every token issued to them, refresh tokens included, is revoked
+// POST /admin/users/{user_id}/revoke-sessions
+func (h *AdminHandler) RevokeUserSessions(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.findUser(w, r)
+	if !ok {
+		return
+	}
+
+	revoked, err := h.revoker.RevokeSessions(r.Context(), user.ID, "")
+	if err != nil {
+		respondInternalError(w, err, "Failed to revoke sessions")
+		return
+	}
+
+	h.audit.record(r, models.AuditSessionRevoked, user.ID, "", map[string]string{"bulk": "true", "flow": "admin"})
+	respondJSON(w, http.StatusOK, map[string]interface{}{"user_id": user.ID, "revoked": revoked})
+}
+
+// DeleteUser removes the user along with their sessions and consents, and
+// revokes every token issued to them
+// DELETE /admin/users/{user_id}
+func (h *AdminHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.findUser(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.deleter.Delete(r.Context(), user.ID); err != nil {
+		respondInternalError(w, err, "Failed to delete user")
+		return
+	}
+	h.audit.record(r, models.AuditUserDeleted, user.ID, "", nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RevokeClientConsents revokes every user's consent for a client along with
+// every token issued to it, e.g. when the client is compromised. Users are
+// asked for consent again on their next authorization.
+// DELETE /admin/clients/{client_id}/consents
+func (h *AdminHandler) RevokeClientConsents(w http.ResponseWriter, r *http.Request) {
+	clientID := mux.Vars(r)["client_id"]
+	if clientID == "" {
+		respondError(w, http.StatusBadRequest, "invalid_request", "Client ID is required")
+		return
+	}
+
+	// RequireAdmin has already validated the token; parse it again for the actor
+	adminID, _, _ := parseBearerToken(r, h.tokens, h.config)
+
+	ctx := r.Context()
+	revoked, err := h.consentRepo.RevokeAllForClient(ctx, clientID, adminID)
+	if err != nil {
+		respondInternalError(w, err, "Failed to revoke consents")
+		return
+	}
+	if err := h.revoker.RevokeClient(ctx, clientID); err != nil {
+		respondInternalError(w, err, "Failed to revoke tokens")
+		return
+	}
+
+	h.audit.record(r, models.AuditConsentRevoked, "", clientID, map[string]string{
+		"revoked":    strconv.FormatInt(revoked, 10),
+		"revoked_by": adminID,
+	})
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"client_id": clientID,
+		"revoked":   revoked,
+	})
+}
+
+// DeleteClient removes a client along with its consents and revokes every
+// token issued to it
+// DELETE /admin/clients/{client_id}
+func (h *AdminHandler) DeleteClient(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	client, err := h.clientRepo.FindByClientID(ctx, mux.Vars(r)["client_id"])
+	if err != nil {
+		respondRepositoryError(w, err, "Client", "Failed to retrieve client")
+		return
+	}
+
+	if err := h.consentRepo.DeleteByClientID(ctx, client.ClientID); err != nil {
+		respondInternalError(w, err, "Failed to remove consents")
+		return
+	}
+	if err := h.revoker.RevokeClient(ctx, client.ClientID); err != nil {
+		respondInternalError(w, err, "Failed to revoke tokens")
+		return
+	}
+	if err := h.clientRepo.Delete(ctx, client.ClientID); err != nil {
+		respondInternalError(w, err, "Failed to delete client")
+		return
+	}
+
+	adminID, _, _ := parseBearerToken(r, h.tokens, h.config)
+	h.audit.record(r, models.AuditClientDeleted, "", client.ClientID, map[string]string{"deleted_by": adminID})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RotateClientSecret replaces the secret of any confidential client, for
+// operators provisioning clients they do not own. The new secret is only
+// shown in this response.
+// POST /admin/clients/{client_id}/rotate-secret
+func (h *AdminHandler) RotateClientSecret(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	client, err := h.clientRepo.FindByClientID(ctx, mux.Vars(r)["client_id"])
+	if err != nil {
+		respondRepositoryError(w, err, "Client", "Failed to retrieve client")
+		return
+	}
+	if client.ClientSecret == "" {
+		respondError(w, http.StatusBadRequest, "invalid_request", "Public clients do not have a secret")
+		return
+	}
+
+	secret, err := utils.GenerateRandomString(64)
+	if err != nil {
+		respondInternalError(w, err, "Failed to generate client secret")
+		return
+	}
+	if err := h.clientRepo.UpdateSecret(ctx, client.ClientID, secret); err != nil {
+		respondInternalError(w, err, "Failed to rotate client secret")
+		return
+	}
+
+	adminID, _, _ := parseBearerToken(r, h.tokens, h.config)
+	h.audit.record(r, models.AuditClientSecretRotated, client.OwnerUserID, client.ClientID, map[string]string{"rotated_by": adminID})
+	respondJSON(w, http.StatusOK, map[string]string{
+		"client_id":     client.ClientID,
+		"client_secret": secret,
+	})
+}
+
+// findUser loads the user named by the user_id path variable, writing a
+// 404 response when it does not exist
+func (h *AdminHandler) findUser(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	userID := mux.Vars(r)["user_id"]
+	user, err := h.userRepo.FindByID(r.Context(), userID)
+	if err != nil {
+		respondRepositoryError(w, err, "User", "Failed to retrieve user")
+		return nil, false
+	}
+	return user, true
+}
+
+// parsePositiveInt parses a positive integer query value, falling back to def
+func parsePositiveInt(value string, def int64) int64 {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 1 {
+		return def
+	}
+	return n
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"oauth2-server/config"
+	"oauth2-server/models"
+	"oauth2-server/utils"
+	"testing"
+)
+
+func TestAdminHandler_RequireAdmin_RejectsWithoutAdminScope(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	cfg := &config.Config{PrivateKey: privateKey, PublicKey: &privateKey.PublicKey}
+	h := NewAdminHandler(nil, nil, nil, nil, nil, nil, nil, nil, cfg)
+
+	called := false
+	protected := h.RequireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+
+	userToken, err := utils.GenerateAccessToken("user-1", "user@example.com", "User", "openid profile", privateKey, 3600)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantStatus    int
+		wantError     string
+	}{
+		{"missing token", "", http.StatusUnauthorized, "unauthorized"},
+		{"invalid token", "Bearer not-a-token", http.StatusUnauthorized, "invalid_token"},
+		{"missing admin scope", "Bearer " + userToken, http.StatusForbidden, "insufficient_scope"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/admin/users", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			protected(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d", tt.wantStatus, w.Code)
+			}
+			var resp models.ErrorResponse
+			json.NewDecoder(w.Body).Decode(&resp)
+			if resp.Error != tt.wantError {
+				t.Errorf("Expected error %q, got %q", tt.wantError, resp.Error)
+			}
+		})
+	}
+
+	if called {
+		t.Error("Protected handler should not run without admin access")
+	}
+}
+
+func TestParsePositiveInt(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+	}{
+		{"", 20},
+		{"5", 5},
+		{"0", 20},
+		{"-3", 20},
+		{"abc", 20},
+	}
+
+	for _, tt := range tests {
+		if got := parsePositiveInt(tt.value, 20); got != tt.want {
+			t.Errorf("parsePositiveInt(%q) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestAdminHandler_RevokeClientConsents_RequiresClientID(t *testing.T) {
+	h := NewAdminHandler(nil, nil, nil, nil, nil, nil, nil, nil, &config.Config{})
+
+	r := httptest.NewRequest("DELETE", "/admin/clients//consents", nil)
+	w := httptest.NewRecorder()
+	h.RevokeClientConsents(w, r)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+}
+
+func TestAdminHandler_ListUserConsents_RejectsUnknownStatus(t *testing.T) {
+	h := NewAdminHandler(nil, nil, nil, nil, nil, nil, nil, nil, &config.Config{})
+
+	r := httptest.NewRequest("GET", "/admin/users/user-1/consents?status=expired", nil)
+	w := httptest.NewRecorder()
+	h.ListUserConsents(w, r)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+}
@@ -0,0 +1,279 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"oauth2-server/auditexport"
+	"oauth2-server/config"
+	"oauth2-server/models"
+	"oauth2-server/repository"
+	"oauth2-server/utils"
+	"oauth2-server/webhook"
+	"sort"
+	"time"
+)
+
+// Auditor records security events to the hash-chained audit log and
+// delivers them to registered webhooks. Handlers that record events are
+// given one; a nil Auditor, as in unit tests, leaves events unrecorded.
+type Auditor struct {
+	auditRepo *repository.AuditRepository
+	webhooks  *webhook.Dispatcher
+}
+
+func NewAuditor(auditRepo *repository.AuditRepository, webhooks *webhook.Dispatcher) *Auditor {
+	return &Auditor{
+		auditRepo: auditRepo,
+		webhooks:  webhooks,
+	}
+}
+
+// record appends an event to the audit log and publishes it to the webhooks
+// subscribed to it. Failures are logged but never fail the request that
+// triggered the event. The entry is written even if the client has already
+// disconnected.
+func (a *Auditor) record(r *http.Request, event, userID, clientID string, details map[string]string) {
+	if a == nil {
+		return
+	}
+	publishWebhook(a.webhooks, event, userID, clientID, details)
+
+	entry := &models.AuditEntry{
+		Event:     event,
+		UserID:    userID,
+		ClientID:  clientID,
+		IPAddress: r.RemoteAddr,
+		Details:   details,
+	}
+	if err := a.auditRepo.Append(context.Background(), entry); err != nil {
+		log.Printf("Failed to record audit event %s: %v", event, err)
+	}
+}
+
+// AuditHandler serves the audit log and its verification to administrators
+type AuditHandler struct {
+	auditRepo *repository.AuditRepository
+	config    *config.Config
+}
+
+func NewAuditHandler(auditRepo *repository.AuditRepository, cfg *config.Config) *AuditHandler {
+	return &AuditHandler{
+		auditRepo: auditRepo,
+		config:    cfg,
+	}
+}
+
+// AuditVerification is the result of checking the chain and its anchors
+type AuditVerification struct {
+	Valid          bool   `json:"valid"`
+	EntriesChecked int    `json:"entries_checked"`
+	AnchorsChecked int    `json:"anchors_checked"`
+	HeadSeq        int64  `json:"head_seq"`
+	HeadHash       string `json:"head_hash,omitempty"`
+	BrokenAtSeq    int64  `json:"broken_at_seq,omitempty"`
+	Reason         string `json:"reason,omitempty"`
+}
+
+// ListEntries returns the newest audit entries, optionally filtered by user,
+// client, event type and an RFC 3339 time range
+// GET /admin/audit?user_id=...&client_id=...&event=...&since=...&until=...&limit=50
+func (h *AuditHandler) ListEntries(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit := parsePositiveInt(query.Get("limit"), defaultAdminPageSize)
+	if limit > maxAdminPageSize {
+		limit = maxAdminPageSize
+	}
+
+	filter := repository.AuditFilter{
+		UserID:   query.Get("user_id"),
+		ClientID: query.Get("client_id"),
+		Event:    query.Get("event"),
+	}
+	var err error
+	if filter.Since, err = parseAuditTime(query.Get("since")); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_request", "since must be an RFC 3339 time")
+		return
+	}
+	if filter.Until, err = parseAuditTime(query.Get("until")); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_request", "until must be an RFC 3339 time")
+		return
+	}
+
+	entries, err := h.auditRepo.List(r.Context(), filter, limit)
+	if err != nil {
+		respondInternalError(w, err, "Failed to retrieve audit log")
+		return
+	}
+	if entries == nil {
+		entries = []*models.AuditEntry{}
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{"entries": entries})
+}
+
+// parseAuditTime parses an optional RFC 3339 time; empty means no bound
+func parseAuditTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// Verify recomputes the hash chain and checks every anchor's signature and
+// head against the stored entries
+// GET /admin/audit/verify
+func (h *AuditHandler) Verify(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	entries, err := h.auditRepo.All(ctx)
+	if err != nil {
+		respondInternalError(w, err, "Failed to retrieve audit log")
+		return
+	}
+	anchors, err := h.auditRepo.ListAnchors(ctx)
+	if err != nil {
+		respondInternalError(w, err, "Failed to retrieve audit anchors")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, verifyAudit(entries, anchors, h.config))
+}
+
+// maxAuditExportSize bounds an export uploaded for verification
+const maxAuditExportSize = 64 << 20
+
+// VerifyExport checks a JSON Lines audit export: the chain links of its
+// entries and the signature of every checkpoint against the entry it was
+// taken at. Entries after the last checkpoint are not signed by anyone, so an
+// export ending with them fails verification.
+// POST /admin/audit/export/verify
+func (h *AuditHandler) VerifyExport(w http.ResponseWriter, r *http.Request) {
+	entries, checkpoints, err := auditexport.ReadJSONLines(http.MaxBytesReader(w, r.Body, maxAuditExportSize))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_request", "Invalid audit export: "+err.Error())
+		return
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Seq < entries[j].Seq })
+
+	result := verifyAudit(entries, checkpoints, h.config)
+	if result.Valid && len(entries) > 0 {
+		var signedSeq int64
+		for _, checkpoint := range checkpoints {
+			signedSeq = max(signedSeq, checkpoint.Seq)
+		}
+		if signedSeq < result.HeadSeq {
+			result.Valid = false
+			result.BrokenAtSeq = signedSeq + 1
+			result.Reason = "entries after the last checkpoint are not signed"
+		}
+	}
+
+	respondJSON(w, http.StatusOK, result)
+}
+
+// Anchor signs and stores the current chain head immediately
+// POST /admin/audit/anchor
+func (h *AuditHandler) Anchor(w http.ResponseWriter, r *http.Request) {
+	anchor, err := h.AnchorHead(r.Context())
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			respondError(w, http.StatusConflict, "empty_audit_log", "There are no audit entries to anchor")
+			return
+		}
+		respondInternalError(w, err, "Failed to anchor audit log")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, anchor)
+}
+
+// AnchorHead signs the current chain head with the server key and stores it.
+// When the head has not moved since the last anchor that anchor is returned.
+func (h *AuditHandler) AnchorHead(ctx context.Context) (*models.AuditAnchor, error) {
+	head, err := h.auditRepo.Head(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	latest, err := h.auditRepo.LatestAnchor(ctx)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return nil, err
+	}
+	if latest != nil && latest.Seq == head.Seq {
+		return latest, nil
+	}
+
+	anchor := &models.AuditAnchor{
+		Seq:       head.Seq,
+		HeadHash:  head.Hash,
+		CreatedAt: utils.AuditTime(time.Now()),
+	}
+	anchor.Signature, err = utils.SignAuditAnchor(anchor, h.config.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.auditRepo.CreateAnchor(ctx, anchor); err != nil {
+		return nil, err
+	}
+	return anchor, nil
+}
+
+// RunAnchoring anchors the chain head every interval until ctx is done
+func (h *AuditHandler) RunAnchoring(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := h.AnchorHead(ctx); err != nil && !errors.Is(err, repository.ErrNotFound) {
+				log.Printf("Failed to anchor audit log: %v", err)
+			}
+		}
+	}
+}
+
+// verifyAudit checks the chain links and that every anchor is correctly
+// signed and matches the entry it was taken at
+func verifyAudit(entries []*models.AuditEntry, anchors []*models.AuditAnchor, cfg *config.Config) AuditVerification {
+	result := AuditVerification{EntriesChecked: len(entries)}
+	if len(entries) > 0 {
+		head := entries[len(entries)-1]
+		result.HeadSeq = head.Seq
+		result.HeadHash = head.Hash
+	}
+
+	if brokenAt, err := utils.VerifyAuditChain(entries); err != nil {
+		result.BrokenAtSeq = brokenAt
+		result.Reason = err.Error()
+		return result
+	}
+
+	bySeq := make(map[int64]*models.AuditEntry, len(entries))
+	for _, entry := range entries {
+		bySeq[entry.Seq] = entry
+	}
+
+	for _, anchor := range anchors {
+		result.AnchorsChecked++
+		if err := utils.VerifyAuditAnchor(anchor, cfg.PublicKey); err != nil {
+			result.BrokenAtSeq = anchor.Seq
+			result.Reason = "anchor signature is invalid"
+			return result
+		}
+		entry, ok := bySeq[anchor.Seq]
+		if !ok || entry.Hash != anchor.HeadHash {
+			result.BrokenAtSeq = anchor.Seq
+			result.Reason = "chain does not match the signed anchor"
+			return result
+		}
+	}
+
+	result.Valid = true
+	return result
+}
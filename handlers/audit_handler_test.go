@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"oauth2-server/auditexport"
+	"oauth2-server/config"
+	"oauth2-server/models"
+	"oauth2-server/utils"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyAudit(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	cfg := &config.Config{PrivateKey: privateKey, PublicKey: &privateKey.PublicKey}
+
+	var entries []*models.AuditEntry
+	prevHash := ""
+	for i, event := range []string{models.AuditLoginSuccess, models.AuditTokenIssued, models.AuditLogout} {
+		entry := &models.AuditEntry{
+			Seq:       int64(i + 1),
+			Event:     event,
+			UserID:    "user-1",
+			CreatedAt: utils.AuditTime(time.Now()),
+			PrevHash:  prevHash,
+		}
+		entry.Hash = utils.HashAuditEntry(entry)
+		prevHash = entry.Hash
+		entries = append(entries, entry)
+	}
+
+	anchor := &models.AuditAnchor{Seq: 2, HeadHash: entries[1].Hash, CreatedAt: utils.AuditTime(time.Now())}
+	anchor.Signature, err = utils.SignAuditAnchor(anchor, privateKey)
+	if err != nil {
+		t.Fatalf("Failed to sign anchor: %v", err)
+	}
+
+	result := verifyAudit(entries, []*models.AuditAnchor{anchor}, cfg)
+	if !result.Valid || result.EntriesChecked != 3 || result.AnchorsChecked != 1 || result.HeadSeq != 3 {
+		t.Errorf("Expected a valid chain, got %+v", result)
+	}
+
+	// Rewriting the whole chain keeps the links intact but no longer matches the anchor
+	rewritten := make([]*models.AuditEntry, len(entries))
+	prevHash = ""
+	for i, entry := range entries {
+		copied := *entry
+		copied.UserID = "someone-else"
+		copied.PrevHash = prevHash
+		copied.Hash = utils.HashAuditEntry(&copied)
+		prevHash = copied.Hash
+		rewritten[i] = &copied
+	}
+
+	result = verifyAudit(rewritten, []*models.AuditAnchor{anchor}, cfg)
+	if result.Valid || result.BrokenAtSeq != 2 {
+		t.Errorf("Expected rewritten chain to fail at the anchor, got %+v", result)
+	}
+
+	// A forged anchor for the rewritten head fails signature verification
+	forged := *anchor
+	forged.HeadHash = rewritten[1].Hash
+	result = verifyAudit(rewritten, []*models.AuditAnchor{&forged}, cfg)
+	if result.Valid || result.Reason != "anchor signature is invalid" {
+		t.Errorf("Expected forged anchor to be rejected, got %+v", result)
+	}
+}
+
+func TestParseAuditTime(t *testing.T) {
+	if got, err := parseAuditTime(""); err != nil || !got.IsZero() {
+		t.Errorf("Expected an empty value to mean no bound, got %v, %v", got, err)
+	}
+	got, err := parseAuditTime("2024-03-01T10:00:00Z")
+	if err != nil || !got.Equal(time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected time %v, %v", got, err)
+	}
+	if _, err := parseAuditTime("yesterday"); err == nil {
+		t.Error("Expected an error for a non RFC 3339 time")
+	}
+}
+
+func TestAuditHandler_VerifyExport(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	cfg := &config.Config{PrivateKey: privateKey, PublicKey: &privateKey.PublicKey}
+	h := NewAuditHandler(nil, cfg)
+
+	var entries []*models.AuditEntry
+	prevHash := ""
+	for i := 1; i <= 3; i++ {
+		entry := &models.AuditEntry{Seq: int64(i), Event: models.AuditTokenIssued, CreatedAt: utils.AuditTime(time.Now()), PrevHash: prevHash}
+		entry.Hash = utils.HashAuditEntry(entry)
+		prevHash = entry.Hash
+		entries = append(entries, entry)
+	}
+	checkpoint := &models.AuditAnchor{Seq: 2, HeadHash: entries[1].Hash, CreatedAt: utils.AuditTime(time.Now())}
+	checkpoint.Signature, _ = utils.SignAuditAnchor(checkpoint, privateKey)
+
+	encode := func(lines ...auditexport.Line) string {
+		var b strings.Builder
+		for _, line := range lines {
+			data, _ := json.Marshal(line)
+			b.Write(append(data, '\n'))
+		}
+		return b.String()
+	}
+	entryLine := func(e *models.AuditEntry) auditexport.Line { return auditexport.Line{Type: "entry", Entry: e} }
+	checkpointLine := auditexport.Line{Type: "checkpoint", Checkpoint: checkpoint}
+
+	tests := []struct {
+		name      string
+		body      string
+		wantValid bool
+		wantCode  int
+	}{
+		{"signed", encode(entryLine(entries[0]), entryLine(entries[1]), checkpointLine), true, http.StatusOK},
+		{"unsigned tail", encode(entryLine(entries[0]), entryLine(entries[1]), checkpointLine, entryLine(entries[2])), false, http.StatusOK},
+		{"missing entry", encode(entryLine(entries[0]), entryLine(entries[2]), checkpointLine), false, http.StatusOK},
+		{"not an export", "not json\n", false, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.VerifyExport(w, httptest.NewRequest("POST", "/admin/audit/export/verify", strings.NewReader(tt.body)))
+			if w.Code != tt.wantCode {
+				t.Fatalf("Expected status %d, got %d: %s", tt.wantCode, w.Code, w.Body.String())
+			}
+			var result AuditVerification
+			json.NewDecoder(w.Body).Decode(&result)
+			if result.Valid != tt.wantValid {
+				t.Errorf("Expected valid=%v, got %+v", tt.wantValid, result)
+			}
+		})
+	}
+}
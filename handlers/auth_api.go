@@ -0,0 +1,390 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"oauth2-server/config"
+	"oauth2-server/middleware"
+	"oauth2-server/models"
+	"oauth2-server/utils"
+	"strings"
+	"time"
+)
+
+// The /api/auth endpoints let a custom login and consent UI (LOGIN_UI_URL,
+// CONSENT_UI_URL) take the place of the built-in pages. The authorization
+// endpoint sends the user agent to the UI with a login_challenge or
+// consent_challenge; the UI reads the pending request, then accepts or
+// rejects it and sends the user agent to the returned redirect_to.
+
+// LoginChallengeResponse describes a pending login to a custom login UI
+type LoginChallengeResponse struct {
+	Challenge      string    `json:"challenge"`
+	ClientID       string    `json:"client_id"`
+	ClientName     string    `json:"client_name"`
+	RequestedScope []string  `json:"requested_scope"`
+	ExpiresAt      time.Time `json:"expires_at"`
+	// LoginHint is the client's login_hint, to prefill the email field
+	LoginHint string `json:"login_hint,omitempty"`
+	// CSRFToken must be sent back in the X-CSRF-Token header
+	CSRFToken string `json:"csrf_token"`
+	// IdentityProviders can be offered instead of a password; their start
+	// URLs continue this login
+	IdentityProviders []IdentityProviderLink `json:"identity_providers,omitempty"`
+}
+
+// ConsentChallengeResponse describes a pending consent to a custom consent UI
+type ConsentChallengeResponse struct {
+	Challenge      string         `json:"challenge"`
+	ClientID       string         `json:"client_id"`
+	ClientName     string         `json:"client_name"`
+	ConsentMessage string         `json:"consent_message,omitempty"`
+	RequestedScope []ConsentScope `json:"requested_scope"`
+	// GrantedScope lists the scopes the user already granted the client
+	GrantedScope []string  `json:"granted_scope,omitempty"`
+	AllowOnce    bool      `json:"allow_once"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	CSRFToken    string    `json:"csrf_token"`
+}
+
+// ConsentScope is a requested scope as shown on a consent screen
+type ConsentScope struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Required    bool   `json:"required,omitempty"`
+	Sensitive   bool   `json:"sensitive,omitempty"`
+}
+
+// ChallengeRedirect tells the UI where to send the user agent next
+type ChallengeRedirect struct {
+	RedirectTo string `json:"redirect_to"`
+}
+
+// challengeRequest is the body of the accept and reject endpoints
+type challengeRequest struct {
+	LoginChallenge   string `json:"login_challenge"`
+	ConsentChallenge string `json:"consent_challenge"`
+
+	// Login
+	Email       string `json:"email"`
+	Password    string `json:"password"`
+	NewPassword string `json:"new_password,omitempty"`
+
+	// Consent; a nil GrantScope grants every requested scope
+	GrantScope []string `json:"grant_scope"`
+	AllowOnce  bool     `json:"allow_once"`
+}
+
+// LoginChallenge returns the authorization request waiting for the user to
+// sign in
+// GET /api/auth/login?login_challenge=...
+func (h *AuthHandler) LoginChallenge(w http.ResponseWriter, r *http.Request) {
+	request, ok := h.pendingLogin(w, r, r.URL.Query().Get("login_challenge"))
+	if !ok {
+		return
+	}
+
+	response := LoginChallengeResponse{
+		Challenge:      request.Challenge,
+		ClientID:       request.ClientID,
+		RequestedScope: strings.Fields(request.Scope),
+		ExpiresAt:      request.ExpiresAt,
+		LoginHint:      request.LoginHint,
+		CSRFToken:      csrfToken(w, r),
+
+		IdentityProviders: h.identityProviderLinks(request.Challenge),
+	}
+	if client, err := h.clientRepo.FindByClientID(r.Context(), request.ClientID); err == nil {
+		response.ClientName = client.Name
+	}
+	respondJSON(w, http.StatusOK, response)
+}
+
+// AcceptLogin signs the user in with their email and password and continues
+// the authorization request, which asks for consent next if needed
+// POST /api/auth/login/accept
+func (h *AuthHandler) AcceptLogin(w http.ResponseWriter, r *http.Request) {
+	if !requireCSRF(w, r, h.config) {
+		return
+	}
+	req, ok := decodeChallengeRequest(w, r)
+	if !ok {
+		return
+	}
+	request, ok := h.pendingLogin(w, r, req.LoginChallenge)
+	if !ok {
+		return
+	}
+
+	user, ok := h.authenticateUser(w, r, req.Email, req.Password, req.NewPassword)
+	if !ok {
+		return
+	}
+	if _, ok := h.startSSOSession(w, r, user, request.Challenge); !ok {
+		return
+	}
+	location, ok := h.continueAuthorization(r.Context(), request.Challenge)
+	if !ok {
+		respondError(w, http.StatusNotFound, "invalid_request", "Unknown or expired login challenge")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, ChallengeRedirect{RedirectTo: location})
+}
+
+// RejectLogin ends the authorization request with access_denied
+// POST /api/auth/login/reject
+func (h *AuthHandler) RejectLogin(w http.ResponseWriter, r *http.Request) {
+	if !requireCSRF(w, r, h.config) {
+		return
+	}
+	req, ok := decodeChallengeRequest(w, r)
+	if !ok {
+		return
+	}
+	request, ok := h.pendingLogin(w, r, req.LoginChallenge)
+	if !ok {
+		return
+	}
+	h.authRequests.Delete(r.Context(), request.Challenge)
+
+	respondChallengeRedirect(w, request.RedirectURI, authorizationError(w, "access_denied", "User cancelled the login", request.State))
+}
+
+// pendingLogin loads the authorization request waiting for login that a
+// login challenge names
+func (h *AuthHandler) pendingLogin(w http.ResponseWriter, r *http.Request, challenge string) (*models.AuthorizationRequest, bool) {
+	if challenge == "" {
+		respondError(w, http.StatusBadRequest, "invalid_request", "Missing login_challenge")
+		return nil, false
+	}
+	request, err := h.authRequests.FindByChallenge(r.Context(), challenge)
+	if err != nil || !request.Pending(models.AuthorizationStageLogin, time.Now()) {
+		respondError(w, http.StatusNotFound, "invalid_request", "Unknown or expired login challenge")
+		return nil, false
+	}
+	return request, true
+}
+
+// ConsentChallenge returns the authorization request waiting for the
+// signed-in user's consent
+// GET /api/auth/consent?consent_challenge=...
+func (h *ConsentHandler) ConsentChallenge(w http.ResponseWriter, r *http.Request) {
+	request, _, client, ok := h.pendingConsent(w, r, r.URL.Query().Get("consent_challenge"))
+	if !ok {
+		return
+	}
+
+	scopes := strings.Fields(request.Scope)
+	response := ConsentChallengeResponse{
+		Challenge:      request.Challenge,
+		ClientID:       client.ClientID,
+		ClientName:     client.Name,
+		ConsentMessage: consentMessage(client),
+		RequestedScope: make([]ConsentScope, len(scopes)),
+		AllowOnce:      resolveConsentPolicy(client, h.config) != models.ConsentPolicySession,
+		ExpiresAt:      request.ExpiresAt,
+		CSRFToken:      csrfToken(w, r),
+	}
+	for i, name := range scopes {
+		scope := ConsentScope{Name: name, Description: "Access to " + name, Required: isRequiredConsentScope(name)}
+		if scopeDef, exists := utils.GlobalScopeRegistry.GetScope(name); exists {
+			scope.Description = scopeDef.Description
+			scope.Sensitive = scopeDef.Sensitive
+		}
+		response.RequestedScope[i] = scope
+	}
+	if _, consent, err := h.consentRepo.CheckConsent(r.Context(), request.UserID, client.ClientID, scopes); err == nil && consent != nil {
+		response.GrantedScope = consent.ActiveScopes(time.Now())
+	}
+	respondJSON(w, http.StatusOK, response)
+}
+
+// AcceptConsent grants the client the scopes the user selected and issues
+// the authorization code
+// POST /api/auth/consent/accept
+func (h *ConsentHandler) AcceptConsent(w http.ResponseWriter, r *http.Request) {
+	if !requireCSRF(w, r, h.config) {
+		return
+	}
+	req, ok := decodeChallengeRequest(w, r)
+	if !ok {
+		return
+	}
+	request, ssoSession, client, ok := h.pendingConsent(w, r, req.ConsentChallenge)
+	if !ok {
+		return
+	}
+
+	params, err := h.approveConsent(r, client, ssoSession, request, req.GrantScope, req.AllowOnce)
+	if err != nil {
+		respondInternalError(w, err, "Failed to approve consent")
+		return
+	}
+	h.authRequests.Delete(r.Context(), request.Challenge)
+
+	respondChallengeRedirect(w, request.RedirectURI, params)
+}
+
+// RejectConsent ends the authorization request with access_denied
+// POST /api/auth/consent/reject
+func (h *ConsentHandler) RejectConsent(w http.ResponseWriter, r *http.Request) {
+	if !requireCSRF(w, r, h.config) {
+		return
+	}
+	req, ok := decodeChallengeRequest(w, r)
+	if !ok {
+		return
+	}
+	request, ssoSession, client, ok := h.pendingConsent(w, r, req.ConsentChallenge)
+	if !ok {
+		return
+	}
+	h.authRequests.Delete(r.Context(), request.Challenge)
+
+	h.audit.record(r, models.AuditConsentDenied, ssoSession.UserID, client.ClientID, map[string]string{"scope": request.Scope})
+	respondChallengeRedirect(w, request.RedirectURI, authorizationError(w, "access_denied", "User denied consent", request.State))
+}
+
+// pendingConsent loads the authorization request a consent challenge names
+// along with its client. Only the user it was issued to, signed in with an
+// SSO session, may answer it.
+func (h *ConsentHandler) pendingConsent(w http.ResponseWriter, r *http.Request, challenge string) (*models.AuthorizationRequest, *models.SSOSession, *models.Client, bool) {
+	if challenge == "" {
+		respondError(w, http.StatusBadRequest, "invalid_request", "Missing consent_challenge")
+		return nil, nil, nil, false
+	}
+
+	ssoSession, _ := r.Context().Value(middleware.SSOSessionContextKey).(*models.SSOSession)
+	if ssoSession == nil || !ssoSession.Authenticated {
+		respondError(w, http.StatusUnauthorized, "unauthorized", "Authentication required")
+		return nil, nil, nil, false
+	}
+
+	ctx := r.Context()
+	request, err := h.authRequests.FindByChallenge(ctx, challenge)
+	if err != nil || !request.Pending(models.AuthorizationStageConsent, time.Now()) || request.UserID != ssoSession.UserID {
+		respondError(w, http.StatusNotFound, "invalid_request", "Unknown or expired consent challenge")
+		return nil, nil, nil, false
+	}
+
+	client, err := h.clientRepo.FindByClientID(ctx, request.ClientID)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_client", "Client not found")
+		return nil, nil, nil, false
+	}
+	if client.Disabled {
+		respondClientDisabled(w, http.StatusBadRequest)
+		return nil, nil, nil, false
+	}
+	return request, ssoSession, client, true
+}
+
+// startConsentChallenge stores the authorization request for the signed-in
+// user's consent and sends the user agent to the custom consent UI, or to the
+// built-in consent page when none is configured
+func (h *OAuthHandler) startConsentChallenge(w http.ResponseWriter, r *http.Request, request *models.AuthorizationRequest) {
+	challenge, err := utils.GenerateRandomString(32)
+	if err != nil {
+		respondInternalError(w, err, "Failed to generate consent challenge")
+		return
+	}
+	request.Challenge = challenge
+	request.Stage = models.AuthorizationStageConsent
+	request.ExpiresAt = time.Now().Add(10 * time.Minute)
+
+	if err := h.authRequests.Create(r.Context(), request); err != nil {
+		respondInternalError(w, err, "Failed to create consent challenge")
+		return
+	}
+
+	if h.config.ConsentUIURL == "" {
+		http.Redirect(w, r, "/oauth/consent?consent_challenge="+url.QueryEscape(challenge), http.StatusFound)
+		return
+	}
+	location, err := clientRedirectURL(h.config.ConsentUIURL, url.Values{"consent_challenge": {challenge}})
+	if err != nil {
+		respondInternalError(w, err, "Invalid consent UI URL")
+		return
+	}
+	http.Redirect(w, r, location, http.StatusFound)
+}
+
+// loginURL is where the user agent signs in to continue a pending
+// authorization request: the custom login UI when one is configured, the
+// built-in login page otherwise
+func loginURL(cfg *config.Config, challenge string) string {
+	if cfg.LoginUIURL != "" {
+		if location, err := clientRedirectURL(cfg.LoginUIURL, url.Values{"login_challenge": {challenge}}); err == nil {
+			return location
+		}
+	}
+	return "/auth/login?login_challenge=" + url.QueryEscape(challenge)
+}
+
+// continueAuthorization ends the login stage of the authorization request a
+// login challenge names, now that the user has signed in, and returns the
+// authorization endpoint URL that continues it. The endpoint finds the SSO
+// session and asks for MFA or consent next if needed. It reports false when
+// no request is waiting for login under the challenge.
+func (h *AuthHandler) continueAuthorization(ctx context.Context, challenge string) (string, bool) {
+	if challenge == "" {
+		return "", false
+	}
+	request, err := h.authRequests.FindByChallenge(ctx, challenge)
+	if err != nil || !request.Pending(models.AuthorizationStageLogin, time.Now()) {
+		return "", false
+	}
+	h.authRequests.Delete(ctx, challenge)
+	return h.config.PublicURL + "/oauth/authorize?" + authorizeParams(request).Encode(), true
+}
+
+// authorizeParams rebuilds the parameters of a stored authorization request,
+// so it can be sent through the authorization endpoint again
+func authorizeParams(request *models.AuthorizationRequest) url.Values {
+	params := url.Values{
+		"response_type": {request.ResponseType},
+		"client_id":     {request.ClientID},
+		"redirect_uri":  {request.RedirectURI},
+		"scope":         {request.Scope},
+	}
+	if request.State != "" {
+		params.Set("state", request.State)
+	}
+	if request.Nonce != "" {
+		params.Set("nonce", request.Nonce)
+	}
+	if request.CodeChallenge != "" {
+		params.Set("code_challenge", request.CodeChallenge)
+		params.Set("code_challenge_method", request.ChallengeMethod)
+	}
+	if len(request.Resources) > 0 {
+		params["resource"] = request.Resources
+	}
+	if request.ACRValues != "" {
+		params.Set("acr_values", request.ACRValues)
+	}
+	return params
+}
+
+func decodeChallengeRequest(w http.ResponseWriter, r *http.Request) (*challengeRequest, bool) {
+	var req challengeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return nil, false
+	}
+	return &req, true
+}
+
+// respondChallengeRedirect answers an accept or reject call with the client
+// redirect the UI should follow
+func respondChallengeRedirect(w http.ResponseWriter, redirectURI string, params url.Values) {
+	location, err := clientRedirectURL(redirectURI, params)
+	if err != nil {
+		respondInternalError(w, err, "Invalid redirect URI")
+		return
+	}
+	respondJSON(w, http.StatusOK, ChallengeRedirect{RedirectTo: location})
+}
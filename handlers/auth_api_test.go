@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"oauth2-server/config"
+	"oauth2-server/models"
+	"strings"
+	"testing"
+)
+
+func TestLoginURL(t *testing.T) {
+	cfg := &config.Config{}
+	if got := loginURL(cfg, "a b"); got != "/auth/login?login_challenge=a+b" {
+		t.Errorf("Expected the built-in login page, got %s", got)
+	}
+
+	cfg.LoginUIURL = "https://login.example.com/signin?theme=dark"
+	u, err := url.Parse(loginURL(cfg, "challenge-1"))
+	if err != nil || u.Host != "login.example.com" || u.Query().Get("login_challenge") != "challenge-1" || u.Query().Get("theme") != "dark" {
+		t.Errorf("Expected the custom login UI with the challenge, got %v", u)
+	}
+}
+
+func TestAuthorizeParams(t *testing.T) {
+	params := authorizeParams(&models.AuthorizationRequest{
+		ClientID:        "client-1",
+		RedirectURI:     "https://app.example.com/cb",
+		Scope:           "openid profile",
+		State:           "xyz",
+		ResponseType:    "code",
+		CodeChallenge:   "challenge",
+		ChallengeMethod: "S256",
+		Resources:       []string{"https://api.example.com"},
+	})
+
+	for key, want := range map[string]string{
+		"response_type":         "code",
+		"client_id":             "client-1",
+		"redirect_uri":          "https://app.example.com/cb",
+		"scope":                 "openid profile",
+		"state":                 "xyz",
+		"code_challenge_method": "S256",
+		"resource":              "https://api.example.com",
+	} {
+		if got := params.Get(key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+	if params.Has("nonce") {
+		t.Error("Expected no empty nonce")
+	}
+}
+
+func TestAuthAPI_RejectsBadRequests(t *testing.T) {
+	cfg := &config.Config{}
+	auth := NewAuthHandler(nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, NewTemplateRenderer(false, "", ""), nil, nil, nil, cfg)
+	consent := NewConsentHandler(nil, nil, nil, nil, NewTemplateRenderer(false, "", ""), nil, cfg)
+
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		method   string
+		target   string
+		body     string
+		origin   string
+		expected int
+	}{
+		{"Login without challenge", auth.LoginChallenge, "GET", "/api/auth/login", "", "", http.StatusBadRequest},
+		{"Accept login from another site", auth.AcceptLogin, "POST", "/api/auth/login/accept", `{"login_challenge":"c"}`, "https://evil.example.net", http.StatusForbidden},
+		{"Reject login without challenge", auth.RejectLogin, "POST", "/api/auth/login/reject", `{}`, "", http.StatusBadRequest},
+		{"Consent without challenge", consent.ConsentChallenge, "GET", "/api/auth/consent", "", "", http.StatusBadRequest},
+		{"Consent signed out", consent.ConsentChallenge, "GET", "/api/auth/consent?consent_challenge=c", "", "", http.StatusUnauthorized},
+		{"Accept consent signed out", consent.AcceptConsent, "POST", "/api/auth/consent/accept", `{"consent_challenge":"c"}`, "", http.StatusUnauthorized},
+		{"Consent page without challenge", consent.ShowConsent, "GET", "/oauth/consent?client_id=client-1&scope=openid", "", "", http.StatusBadRequest},
+		{"Consent page signed out", consent.ShowConsent, "GET", "/oauth/consent?consent_challenge=c", "", "", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			w := httptest.NewRecorder()
+			tt.handler(w, req)
+
+			if w.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d: %s", tt.expected, w.Code, w.Body.String())
+			}
+		})
+	}
+}
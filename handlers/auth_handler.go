@@ -3,16 +3,21 @@ package handlers
 import (
 	"context"
 	"encoding/json"
-	"html/template"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"net/url"
 	"oauth2-server/config"
+	"oauth2-server/federation"
+	"oauth2-server/geoip"
+	"oauth2-server/mailer"
 	"oauth2-server/models"
+	"oauth2-server/policy"
 	"oauth2-server/repository"
 	"oauth2-server/utils"
 	"strings"
 	"time"
-
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // SSO Cookie constants
@@ -26,57 +31,87 @@ const (
 )
 
 type AuthHandler struct {
-	userRepo        *repository.UserRepository
-	clientRepo      *repository.ClientRepository
-	authCodeRepo    *repository.AuthCodeRepository
-	sessionRepo     *repository.SessionRepository
-	ssoSessionRepo  *repository.SSOSessionRepository
-	config          *config.Config
+	userRepo         *repository.UserRepository
+	clientRepo       *repository.ClientRepository
+	authCodeRepo     *repository.AuthCodeRepository
+	authRequests     *repository.AuthorizationRequestRepository
+	ssoSessionRepo   *repository.SSOSessionRepository
+	verificationRepo *repository.EmailVerificationRepository
+	trustedDevices   *repository.TrustedDeviceRepository
+	signInProfiles   *repository.SignInProfileRepository
+	mailer           mailer.Mailer
+	locations        *geoip.Database
+	breached         BreachChecker
+	templates        *TemplateRenderer
+	audit            *Auditor
+	tokenPolicy      policy.Policy
+	providers        federation.Providers
+	config           *config.Config
 }
 
+// NewAuthHandler creates the login handler. locations places new sessions
+// and breached vets new passwords; each is nil unless GEOIP_FILE or
+// PASSWORD_BREACH_LIST_FILE is configured. providers are the upstream
+// identity providers users can sign in with instead.
 func NewAuthHandler(
 	userRepo *repository.UserRepository,
 	clientRepo *repository.ClientRepository,
 	authCodeRepo *repository.AuthCodeRepository,
-	sessionRepo *repository.SessionRepository,
+	authRequests *repository.AuthorizationRequestRepository,
 	ssoSessionRepo *repository.SSOSessionRepository,
+	verificationRepo *repository.EmailVerificationRepository,
+	trustedDevices *repository.TrustedDeviceRepository,
+	signInProfiles *repository.SignInProfileRepository,
+	mail mailer.Mailer,
+	locations *geoip.Database,
+	breached BreachChecker,
+	templates *TemplateRenderer,
+	audit *Auditor,
+	tokenPolicy policy.Policy,
+	providers federation.Providers,
 	cfg *config.Config,
 ) *AuthHandler {
 	return &AuthHandler{
-		userRepo:       userRepo,
-		clientRepo:     clientRepo,
-		authCodeRepo:   authCodeRepo,
-		sessionRepo:    sessionRepo,
-		ssoSessionRepo: ssoSessionRepo,
-		config:         cfg,
+		userRepo:         userRepo,
+		clientRepo:       clientRepo,
+		authCodeRepo:     authCodeRepo,
+		authRequests:     authRequests,
+		ssoSessionRepo:   ssoSessionRepo,
+		verificationRepo: verificationRepo,
+		trustedDevices:   trustedDevices,
+		signInProfiles:   signInProfiles,
+		mailer:           mail,
+		locations:        locations,
+		breached:         breached,
+		templates:        templates,
+		audit:            audit,
+		tokenPolicy:      tokenPolicy,
+		providers:        providers,
+		config:           cfg,
 	}
 }
 
 func (h *AuthHandler) ShowRegister(w http.ResponseWriter, r *http.Request) {
-	sessionID := r.URL.Query().Get("session_id")
-	if sessionID == "" {
-		sessionID, _ = utils.GenerateRandomString(32)
-	}
-
-	tmpl, err := template.ParseFiles("templates/register.html")
-	if err != nil {
-		http.Error(w, "Template error", http.StatusInternalServerError)
-		return
-	}
+	// Registration may be reached without a pending authorization request;
+	// when one exists its login_challenge is carried through so it resumes
+	challenge := r.URL.Query().Get("login_challenge")
 
-	data := map[string]interface{}{
-		"SessionID": sessionID,
-	}
+	data := h.authPageData(r.Context(), challenge)
+	data["CSRFToken"] = csrfToken(w, r)
 
-	tmpl.Execute(w, data)
+	h.templates.Render(w, "register.html", data)
 }
 
 func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
+	if !requireCSRF(w, r, h.config) {
+		return
+	}
+
 	var req struct {
-		Email     string `json:"email"`
-		Password  string `json:"password"`
-		Name      string `json:"name"`
-		SessionID string `json:"session_id"`
+		Email          string `json:"email"`
+		Password       string `json:"password"`
+		Name           string `json:"name"`
+		LoginChallenge string `json:"login_challenge"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -88,10 +123,13 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		respondError(w, http.StatusBadRequest, "invalid_request", "Missing required fields")
 		return
 	}
+	if !h.checkPasswordPolicy(w, r, req.Password) {
+		return
+	}
 
 	hashedPassword, err := utils.HashPassword(req.Password)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, "server_error", "Failed to hash password")
+		respondInternalError(w, err, "Failed to hash password")
 		return
 	}
 
@@ -101,20 +139,31 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		Name:     req.Name,
 	}
 
-	ctx := context.Background()
+	ctx := r.Context()
 	if err := h.userRepo.Create(ctx, user); err != nil {
-		if mongo.IsDuplicateKeyError(err) {
-			respondError(w, http.StatusConflict, "user_exists", "User already exists")
+		respondRepositoryError(w, err, "User", "Failed to create user")
+		return
+	}
+	h.audit.record(r, models.AuditUserCreated, user.ID, "", nil)
+
+	// When verification is required the account stays inactive until the
+	// emailed link is followed; the authorization request resumes from there
+	if h.config.RequireEmailVerification {
+		if err := h.sendVerificationEmail(ctx, user, req.LoginChallenge); err != nil {
+			respondInternalError(w, err, "Failed to send verification email")
 			return
 		}
-		respondError(w, http.StatusInternalServerError, "server_error", "Failed to create user")
+		respondJSON(w, http.StatusAccepted, map[string]interface{}{
+			"message":               "Verification email sent",
+			"verification_required": true,
+		})
 		return
 	}
 
 	// Create SSO Session after successful registration
 	ssoSessionID, err := utils.GenerateRandomString(32)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, "server_error", "Failed to generate session ID")
+		respondInternalError(w, err, "Failed to generate session ID")
 		return
 	}
 
@@ -128,64 +177,22 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		IPAddress:     r.RemoteAddr,
 		UserAgent:     r.UserAgent(),
 	}
+	h.describeSession(r, ssoSession)
 
 	if err := h.ssoSessionRepo.Create(ctx, ssoSession); err != nil {
-		respondError(w, http.StatusInternalServerError, "server_error", "Failed to create SSO session")
+		respondInternalError(w, err, "Failed to create SSO session")
 		return
 	}
+	h.rememberSignIn(ctx, ssoSession)
 
 	// Set SSO Cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     SSOCookieName,
-		Value:    ssoSessionID,
-		Path:     SSOCookiePath,
-		MaxAge:   SSOCookieMaxAge,
-		HttpOnly: SSOCookieHTTPOnly,
-		Secure:   SSOCookieSecure,
-		SameSite: SSOCookieSameSite,
-	})
+	setSSOCookie(w, r, ssoSessionID)
 
-	if req.SessionID != "" {
-		session, err := h.sessionRepo.FindBySessionID(ctx, req.SessionID)
-		if err == nil {
-			session.UserID = user.ID
-			session.Authenticated = true
-			h.sessionRepo.Update(ctx, session)
-
-			code, _ := utils.GenerateRandomString(16)
-			code = code + "_" + req.SessionID
-
-			authCode := &models.AuthorizationCode{
-				Code:            code,
-				ClientID:        session.ClientID,
-				UserID:          user.ID,
-				RedirectURI:     session.RedirectURI,
-				Scope:           session.Scope,
-				Nonce:           session.Nonce,
-				CodeChallenge:   session.CodeChallenge,
-				ChallengeMethod: session.ChallengeMethod,
-				ExpiresAt:       time.Now().Add(10 * time.Minute),
-			}
-			h.authCodeRepo.Create(ctx, authCode)
-
-			// Determine response mode
-			responseMode := GetResponseMode(r)
-			
-			// Prepare response parameters
-			params := map[string]string{
-				"code": code,
-			}
-			if session.State != "" {
-				params["state"] = session.State
-			}
-
-			// Send response based on mode
-			SendAuthorizationResponse(w, r, session.RedirectURI, params, responseMode)
-			return
-		}
+	if h.resumeAuthorization(w, r, req.LoginChallenge) {
+		return
 	}
 
-	// If no session, return JSON response
+	// Without a pending authorization request, return JSON response
 	response := map[string]interface{}{
 		"message": "User registered successfully",
 		"user": map[string]string{
@@ -197,95 +204,198 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *AuthHandler) ShowLogin(w http.ResponseWriter, r *http.Request) {
-	sessionID := r.URL.Query().Get("session_id")
-	if sessionID == "" {
-		http.Error(w, "Missing session_id", http.StatusBadRequest)
+	challenge := r.URL.Query().Get("login_challenge")
+	if challenge == "" {
+		respondError(w, http.StatusBadRequest, "invalid_request", "Missing login_challenge")
 		return
 	}
 
-	ctx := context.Background()
-	session, err := h.sessionRepo.FindBySessionID(ctx, sessionID)
+	data := h.authPageData(r.Context(), challenge)
+	data["CSRFToken"] = csrfToken(w, r)
 
-	data := map[string]interface{}{
-		"SessionID": sessionID,
-	}
+	h.templates.Render(w, "login.html", data)
+}
 
-	if err == nil {
-		client, err := h.clientRepo.FindByClientID(ctx, session.ClientID)
-		if err == nil {
-			data["ClientName"] = client.Name
-		}
-		if session.Scope != "" {
-			data["Scope"] = session.Scope
-			data["Scopes"] = strings.Split(session.Scope, " ")
-		}
+func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
+	if !requireCSRF(w, r, h.config) {
+		return
 	}
 
-		// setHeader to return session ID to client
-	w.Header().Set("X-Session-ID", sessionID)
+	var req struct {
+		Email          string `json:"email"`
+		Password       string `json:"password"`
+		NewPassword    string `json:"new_password,omitempty"`
+		LoginChallenge string `json:"login_challenge"`
+	}
 
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
 
-	tmpl, err := template.ParseFiles("templates/login.html")
-	if err != nil {
-		http.Error(w, "Template error", http.StatusInternalServerError)
+	user, ok := h.authenticateUser(w, r, req.Email, req.Password, req.NewPassword)
+	if !ok {
+		return
+	}
+	if _, ok := h.startSSOSession(w, r, user, req.LoginChallenge); !ok {
 		return
 	}
 
-	tmpl.Execute(w, data)
-}
+	if h.resumeAuthorization(w, r, req.LoginChallenge) {
+		return
+	}
 
-func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		Email     string `json:"email"`
-		Password  string `json:"password"`
-		SessionID string `json:"session_id"`
+	scope, ok := applyTokenPolicy(w, r, h.tokenPolicy, h.audit, "password", user, nil, "openid profile email")
+	if !ok {
+		return
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
+	accessToken, err := utils.GenerateAccessToken(
+		user.ID,
+		user.Email,
+		user.Name,
+		scope,
+		h.config.PrivateKey,
+		h.config.AccessTokenExpiry,
+	)
+	if err != nil {
+		respondInternalError(w, err, "Failed to generate token")
 		return
 	}
 
-	ctx := context.Background()
-	user, err := h.userRepo.FindByEmail(ctx, req.Email)
+	// No client asked for this login, so there is no client to bind a
+	// refresh token to; clients get one through /oauth/token
+	response := models.TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   h.config.AccessTokenExpiry,
+		Scope:       scope,
+	}
+
+	respondJSON(w, http.StatusOK, response)
+}
+
+// authenticateUser checks a user's email and password, creating the account
+// first when AUTO_REGISTER_ON_LOGIN is on, and sets the new password an
+// administrator required. It writes the error response when login fails.
+func (h *AuthHandler) authenticateUser(w http.ResponseWriter, r *http.Request, email, password, newPassword string) (*models.User, bool) {
+	ctx := r.Context()
+	user, err := h.userRepo.FindByEmail(ctx, email)
 	if err != nil {
-		// Auto-register user if not found
-		hashedPassword, err := utils.HashPassword(req.Password)
+		if !errors.Is(err, repository.ErrNotFound) {
+			respondInternalError(w, err, "Failed to look up user")
+			return nil, false
+		}
+		if !h.config.AutoRegisterOnLogin {
+			h.audit.record(r, models.AuditLoginFailure, "", "", map[string]string{"email": email, "reason": "unknown_user"})
+			respondError(w, http.StatusUnauthorized, "invalid_credentials", "Invalid email or password")
+			return nil, false
+		}
+
+		// Auto-register user if not found (opt-in, see AUTO_REGISTER_ON_LOGIN)
+		if !h.checkPasswordPolicy(w, r, password) {
+			return nil, false
+		}
+		hashedPassword, err := utils.HashPassword(password)
 		if err != nil {
-			respondError(w, http.StatusInternalServerError, "server_error", "Failed to hash password")
-			return
+			respondInternalError(w, err, "Failed to hash password")
+			return nil, false
 		}
 
 		newUser := &models.User{
-			Email:    req.Email,
+			Email:    email,
 			Password: hashedPassword,
-			Name:     req.Email, // Use email as name by default
+			Name:     email, // Use email as name by default
 		}
 
 		if err := h.userRepo.Create(ctx, newUser); err != nil {
-			respondError(w, http.StatusInternalServerError, "server_error", "Failed to create user")
-			return
+			respondInternalError(w, err, "Failed to create user")
+			return nil, false
 		}
 
 		// Fetch the user back to get the generated ID
-		user, err = h.userRepo.FindByEmail(ctx, req.Email)
+		user, err = h.userRepo.FindByEmail(ctx, email)
 		if err != nil {
-			respondError(w, http.StatusInternalServerError, "server_error", "Failed to retrieve created user")
-			return
+			respondInternalError(w, err, "Failed to retrieve created user")
+			return nil, false
 		}
+		h.audit.record(r, models.AuditUserCreated, user.ID, "", map[string]string{"flow": "login"})
 	} else {
 		// User exists, verify password
-		if !utils.CheckPasswordHash(req.Password, user.Password) {
+		if !utils.CheckPasswordHash(password, user.Password) {
+			h.audit.record(r, models.AuditLoginFailure, user.ID, "", map[string]string{"reason": "invalid_password"})
 			respondError(w, http.StatusUnauthorized, "invalid_credentials", "Invalid email or password")
-			return
+			return nil, false
 		}
 	}
 
-	// Create SSO Session after successful authentication
+	if !h.canSignIn(w, r, user) {
+		return nil, false
+	}
+
+	// An administrator required a new password; it is set as part of this login
+	if user.PasswordResetRequired {
+		if newPassword == "" {
+			respondError(w, http.StatusForbidden, "password_reset_required", "A new password must be set")
+			return nil, false
+		}
+		if newPassword == password {
+			respondError(w, http.StatusBadRequest, "invalid_request", "New password must differ from the current password")
+			return nil, false
+		}
+		if !h.checkPasswordPolicy(w, r, newPassword) {
+			return nil, false
+		}
+		hashedPassword, err := utils.HashPassword(newPassword)
+		if err != nil {
+			respondInternalError(w, err, "Failed to hash password")
+			return nil, false
+		}
+		if err := h.userRepo.UpdatePassword(ctx, user.ID, hashedPassword); err != nil {
+			respondInternalError(w, err, "Failed to update password")
+			return nil, false
+		}
+	} else if user.Password != "" && utils.PasswordNeedsRehash(user.Password) {
+		// The hash predates the current hashing policy; the password is
+		// known now, so it is upgraded without the user noticing
+		if hashedPassword, err := utils.HashPassword(password); err != nil {
+			log.Printf("Failed to rehash password of %s: %v", user.ID, err)
+		} else if err := h.userRepo.UpdatePassword(ctx, user.ID, hashedPassword); err != nil {
+			log.Printf("Failed to rehash password of %s: %v", user.ID, err)
+		}
+	}
+
+	return user, true
+}
+
+// canSignIn rejects disabled accounts and, when verification is required,
+// unverified email addresses
+func (h *AuthHandler) canSignIn(w http.ResponseWriter, r *http.Request, user *models.User) bool {
+	if user.Disabled {
+		h.audit.record(r, models.AuditLoginFailure, user.ID, "", map[string]string{"reason": "account_disabled"})
+		respondError(w, http.StatusForbidden, "account_disabled", "This account has been disabled")
+		return false
+	}
+
+	if h.config.RequireEmailVerification && !user.EmailVerified {
+		respondError(w, http.StatusForbidden, "email_not_verified", "Email address has not been verified")
+		return false
+	}
+
+	return true
+}
+
+// startSSOSession signs the user in to the browser with a new SSO session
+// cookie and returns the session ID. A sign-in from a device, network or
+// country the user has not used before is flagged and the user notified;
+// with SIGN_IN_CONFIRMATION the session then waits for the emailed link,
+// which continues the authorization request resumeChallenge names, and the
+// response asking the user to confirm has been written when it returns false.
+func (h *AuthHandler) startSSOSession(w http.ResponseWriter, r *http.Request, user *models.User, resumeChallenge string) (string, bool) {
 	ssoSessionID, err := utils.GenerateRandomString(32)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, "server_error", "Failed to generate session ID")
-		return
+		respondInternalError(w, err, "Failed to generate session ID")
+		return "", false
 	}
 
 	ssoSession := &models.SSOSession{
@@ -298,105 +408,213 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		IPAddress:     r.RemoteAddr,
 		UserAgent:     r.UserAgent(),
 	}
+	h.describeSession(r, ssoSession)
+
+	ctx := r.Context()
+	unfamiliar := h.unfamiliarSignIn(ctx, ssoSession)
+	confirmationLink := ""
+	if len(unfamiliar) > 0 && h.config.SignInConfirmation {
+		if confirmationLink, err = h.holdForConfirmation(ssoSession, resumeChallenge); err != nil {
+			respondInternalError(w, err, "Failed to generate confirmation link")
+			return "", false
+		}
+	}
 
 	if err := h.ssoSessionRepo.Create(ctx, ssoSession); err != nil {
-		respondError(w, http.StatusInternalServerError, "server_error", "Failed to create SSO session")
+		respondInternalError(w, err, "Failed to create SSO session")
+		return "", false
+	}
+
+	// Set SSO Cookie. A session held for confirmation cannot be used until
+	// it is confirmed, but the browser then continues with it.
+	setSSOCookie(w, r, ssoSessionID)
+
+	if len(unfamiliar) > 0 {
+		h.audit.record(r, models.AuditLoginUnfamiliar, user.ID, "", map[string]string{
+			"reasons":               strings.Join(unfamiliar, " "),
+			"device":                sessionDeviceName(ssoSession),
+			"confirmation_required": fmt.Sprint(confirmationLink != ""),
+		})
+		err := h.notifyUnfamiliarSignIn(ctx, user, ssoSession, confirmationLink)
+		if confirmationLink != "" {
+			if err != nil {
+				respondInternalError(w, err, "Failed to send confirmation email")
+				return "", false
+			}
+			respondJSON(w, http.StatusAccepted, map[string]interface{}{
+				"message":               "Confirm the sign-in from the email we sent",
+				"confirmation_required": true,
+			})
+			return "", false
+		}
+		if err != nil {
+			log.Printf("Failed to notify %s of an unfamiliar sign-in: %v", user.ID, err)
+		}
+	}
+	h.rememberSignIn(ctx, ssoSession)
+
+	h.audit.record(r, models.AuditLoginSuccess, user.ID, "", nil)
+	return ssoSessionID, true
+}
+
+// authPageData builds the template data shared by the login and registration
+// pages so both show the same client and scopes for the pending request
+func (h *AuthHandler) authPageData(ctx context.Context, challenge string) map[string]interface{} {
+	data := map[string]interface{}{
+		"LoginChallenge": challenge,
+	}
+	if len(h.providers) > 0 {
+		data["IdentityProviders"] = h.identityProviderLinks(challenge)
+	}
+	if challenge == "" {
+		return data
+	}
+
+	request, err := h.authRequests.FindByChallenge(ctx, challenge)
+	if err != nil || !request.Pending(models.AuthorizationStageLogin, time.Now()) {
+		return data
+	}
+
+	client, err := h.clientRepo.FindByClientID(ctx, request.ClientID)
+	if err == nil {
+		data["ClientName"] = client.Name
+		data["LogoURI"] = client.LogoURI
+		data["BrandColor"] = client.BrandColor
+	}
+	if request.Scope != "" {
+		data["Scope"] = request.Scope
+		data["Scopes"] = strings.Split(request.Scope, " ")
+	}
+	if request.LoginHint != "" {
+		data["LoginHint"] = request.LoginHint
+	}
+	return data
+}
+
+// resumeAuthorization answers a login or registration from the built-in
+// pages with the authorization endpoint URL that continues the request the
+// login challenge names, which asks for MFA or consent next if needed. It
+// reports whether a response was written.
+func (h *AuthHandler) resumeAuthorization(w http.ResponseWriter, r *http.Request, challenge string) bool {
+	location, ok := h.continueAuthorization(r.Context(), challenge)
+	if !ok {
+		return false
+	}
+	respondJSON(w, http.StatusOK, map[string]string{"redirect_uri": location})
+	return true
+}
+
+// VerifyEmail confirms an email address from the link sent at registration
+// GET /auth/verify-email?token=...
+func (h *AuthHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		respondError(w, http.StatusBadRequest, "invalid_request", "Missing token")
 		return
 	}
 
-	// Set SSO Cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     SSOCookieName,
-		Value:    ssoSessionID,
-		Path:     SSOCookiePath,
-		MaxAge:   SSOCookieMaxAge,
-		HttpOnly: SSOCookieHTTPOnly,
-		Secure:   SSOCookieSecure,
-		SameSite: SSOCookieSameSite,
-	})
+	ctx := r.Context()
+	verification, err := h.verificationRepo.FindByToken(ctx, token)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_token", "Invalid or expired verification link")
+		return
+	}
 
-	if req.SessionID != "" {
-		session, err := h.sessionRepo.FindBySessionID(ctx, req.SessionID)
-		if err == nil && !session.Authenticated {
-			session.UserID = user.ID
-			session.Authenticated = true
-			h.sessionRepo.Update(ctx, session)
-
-			code, _ := utils.GenerateRandomString(16)
-			code = code + "_" + req.SessionID
-
-			authCode := &models.AuthorizationCode{
-				Code:            code,
-				ClientID:        session.ClientID,
-				UserID:          user.ID,
-				RedirectURI:     session.RedirectURI,
-				Scope:           session.Scope,
-				Nonce:           session.Nonce,
-				CodeChallenge:   session.CodeChallenge,
-				ChallengeMethod: session.ChallengeMethod,
-				ExpiresAt:       time.Now().Add(10 * time.Minute),
-			}
-			h.authCodeRepo.Create(ctx, authCode)
-
-			// Determine response mode
-			responseMode := GetResponseMode(r)
-			
-			// Prepare response parameters
-			params := map[string]string{
-				"code": code,
-			}
-			if session.State != "" {
-				params["state"] = session.State
-			}
+	if verification.ExpiresAt.Before(time.Now()) {
+		h.verificationRepo.Delete(ctx, token)
+		respondError(w, http.StatusBadRequest, "invalid_token", "Invalid or expired verification link")
+		return
+	}
+
+	if err := h.userRepo.MarkEmailVerified(ctx, verification.UserID); err != nil {
+		respondInternalError(w, err, "Failed to verify email")
+		return
+	}
+	h.verificationRepo.DeleteByUserID(ctx, verification.UserID)
 
-			// Send response based on mode
-			SendAuthorizationResponse(w, r, session.RedirectURI, params, responseMode)
+	// Resume the authorization request the user registered from
+	if verification.LoginChallenge != "" {
+		request, err := h.authRequests.FindByChallenge(ctx, verification.LoginChallenge)
+		if err == nil && request.Pending(models.AuthorizationStageLogin, time.Now()) {
+			http.Redirect(w, r, loginURL(h.config, request.Challenge), http.StatusFound)
 			return
 		}
 	}
 
-	accessToken, err := utils.GenerateAccessToken(
-		user.ID,
-		user.Email,
-		user.Name,
-		"openid profile email",
-		h.config.PrivateKey,
-		h.config.AccessTokenExpiry,
-	)
-	if err != nil {
-		respondError(w, http.StatusInternalServerError, "server_error", "Failed to generate token")
+	respondJSON(w, http.StatusOK, map[string]string{
+		"message": "Email verified successfully",
+	})
+}
+
+// ResendVerification issues a new verification link for an unverified account
+// POST /auth/verify-email/resend
+func (h *AuthHandler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email          string `json:"email"`
+		LoginChallenge string `json:"login_challenge"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+		respondError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
 		return
 	}
 
-	scope := "openid profile email"
-	refreshToken, err := utils.GenerateRefreshToken(user.ID, scope, h.config.PrivateKey, h.config.RefreshTokenExpiry)
+	ctx := r.Context()
+	user, err := h.userRepo.FindByEmail(ctx, req.Email)
+	if err == nil && !user.EmailVerified {
+		if err := h.sendVerificationEmail(ctx, user, req.LoginChallenge); err != nil {
+			respondInternalError(w, err, "Failed to send verification email")
+			return
+		}
+	}
+
+	// Same response whether or not the account exists to avoid enumeration
+	respondJSON(w, http.StatusAccepted, map[string]string{
+		"message": "If the account exists and is unverified, a verification email has been sent",
+	})
+}
+
+// sendVerificationEmail stores a verification token and mails the link
+func (h *AuthHandler) sendVerificationEmail(ctx context.Context, user *models.User, loginChallenge string) error {
+	token, err := utils.GenerateRandomString(32)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, "server_error", "Failed to generate refresh token")
-		return
+		return err
 	}
 
-	response := models.TokenResponse{
-		AccessToken:  accessToken,
-		TokenType:    "Bearer",
-		ExpiresIn:    h.config.AccessTokenExpiry,
-		RefreshToken: refreshToken,
-		Scope:        scope,
+	verification := &models.EmailVerification{
+		Token:          token,
+		UserID:         user.ID,
+		Email:          user.Email,
+		LoginChallenge: loginChallenge,
+		ExpiresAt:      time.Now().Add(time.Duration(h.config.EmailVerificationExpiry) * time.Second),
+	}
+	if err := h.verificationRepo.Create(ctx, verification); err != nil {
+		return err
 	}
 
-	respondJSON(w, http.StatusOK, response)
+	link := h.config.PublicURL + "/auth/verify-email?token=" + url.QueryEscape(token)
+	return h.mailer.Send(ctx, user.Email, "Verify your email address",
+		"Confirm your email address by opening this link: "+link)
 }
 
 func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
+	ctx := r.Context()
 
 	// Extract SSO cookie and delete session from database
 	cookie, err := r.Cookie(SSOCookieName)
 	if err == nil && cookie.Value != "" {
+		if session, err := h.ssoSessionRepo.FindBySessionID(ctx, cookie.Value); err == nil {
+			h.audit.record(r, models.AuditLogout, session.UserID, "", nil)
+		}
+
 		// Delete session from database
 		if err := h.ssoSessionRepo.Delete(ctx, cookie.Value); err != nil {
 			// Log error but continue with cookie clearing
 			// We don't want to fail logout if session is already gone
 		}
+
+		// Other accounts signed in on the browser stay signed in
+		forgetSSOAccount(w, r, cookie.Value)
 	}
 
 	// Clear SSO cookie by setting MaxAge to -1
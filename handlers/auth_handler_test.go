@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAuthPages_LinksCarryLoginChallenge(t *testing.T) {
+	renderer := NewTemplateRenderer(false, "", "")
+
+	pages := map[string]string{
+		"login.html":    "/auth/register?login_challenge=challenge-123",
+		"register.html": "/auth/login?login_challenge=challenge-123",
+	}
+	for page, link := range pages {
+		w := httptest.NewRecorder()
+		renderer.Render(w, page, map[string]interface{}{"LoginChallenge": "challenge-123"})
+		if !strings.Contains(w.Body.String(), link) {
+			t.Errorf("Expected %s to link to %s", page, link)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	renderer.Render(w, "register.html", map[string]interface{}{"LoginChallenge": ""})
+	if !strings.Contains(w.Body.String(), `href="/auth/login"`) {
+		t.Error("Expected register page without a pending request to omit login_challenge from links")
+	}
+}
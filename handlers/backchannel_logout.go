@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"errors"
+	"oauth2-server/backchannel"
+	"oauth2-server/config"
+	"oauth2-server/models"
+	"oauth2-server/utils"
+)
+
+// logoutTokenExpiry is how long, in seconds, a logout token is accepted. It
+// covers the retries of a slow delivery; a client only needs to remember the
+// jti of tokens it has processed for this long.
+const logoutTokenExpiry = 300
+
+// notifyBackchannelLogout sends the client a logout token for the user
+// through the notifier when it registered a back-channel logout URI
+func notifyBackchannelLogout(notifier *backchannel.Notifier, client *models.Client, userID string, cfg *config.Config) error {
+	if client.BackchannelLogoutURI == "" {
+		return nil
+	}
+	if notifier == nil {
+		return errors.New("back-channel logout is not configured")
+	}
+	jti, err := utils.GenerateRandomString(16)
+	if err != nil {
+		return err
+	}
+	token, err := utils.GenerateLogoutToken(userID, client.ClientID, jti, cfg.PrivateKey, logoutTokenExpiry)
+	if err != nil {
+		return err
+	}
+	notifier.Notify(client.BackchannelLogoutURI, token)
+	return nil
+}
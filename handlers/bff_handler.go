@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"net/http"
+	"oauth2-server/config"
+	"oauth2-server/jwks"
+	"oauth2-server/middleware"
+	"oauth2-server/models"
+	"oauth2-server/policy"
+	"oauth2-server/repository"
+	"oauth2-server/utils"
+	"slices"
+	"strings"
+)
+
+// BFFRelayGrantType registers a client as a backend-for-frontend allowed to
+// relay its users' SSO sessions into access tokens for downstream APIs
+const BFFRelayGrantType = "urn:oauth2-server:params:oauth:grant-type:bff-relay"
+
+// BFFHandler relays a browser's SSO session into a short-lived access token
+// for one downstream API. The browser only holds the session cookie; the BFF
+// calls APIs on its behalf, so tokens never reach browser code.
+type BFFHandler struct {
+	userRepo    *repository.UserRepository
+	clientRepo  *repository.ClientRepository
+	ssoRepo     *repository.SSOSessionRepository
+	consentRepo *repository.UserConsentRepository
+	audit       *Auditor
+	tokens      *TokenStore
+	dpopNonces  *utils.NonceSource
+	tokenPolicy policy.Policy
+	clientKeys  *jwks.Cache
+	config      *config.Config
+}
+
+func NewBFFHandler(
+	userRepo *repository.UserRepository,
+	clientRepo *repository.ClientRepository,
+	ssoRepo *repository.SSOSessionRepository,
+	consentRepo *repository.UserConsentRepository,
+	audit *Auditor,
+	tokens *TokenStore,
+	dpopNonces *utils.NonceSource,
+	tokenPolicy policy.Policy,
+	clientKeys *jwks.Cache,
+	cfg *config.Config,
+) *BFFHandler {
+	return &BFFHandler{
+		userRepo:    userRepo,
+		clientRepo:  clientRepo,
+		ssoRepo:     ssoRepo,
+		consentRepo: consentRepo,
+		audit:       audit,
+		tokens:      tokens,
+		dpopNonces:  dpopNonces,
+		tokenPolicy: tokenPolicy,
+		clientKeys:  clientKeys,
+		config:      cfg,
+	}
+}
+
+// RelayToken issues an access token addressed to a single downstream API for
+// the user signed in to the SSO session the BFF forwards, either as the
+// session parameter or as the SSO cookie. No refresh token is issued; the BFF
+// relays again once the token expires.
+// POST /bff/token
+func (h *BFFHandler) RelayToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_request", "Failed to parse form")
+		return
+	}
+
+	clientID, clientSecret := clientCredentials(r)
+	if clientID == "" {
+		respondError(w, http.StatusBadRequest, "invalid_request", "Missing required parameters")
+		return
+	}
+
+	ctx := r.Context()
+	client, err := h.clientRepo.FindByClientID(ctx, clientID)
+	if err != nil {
+		respondError(w, http.StatusUnauthorized, "invalid_client", "Invalid client credentials")
+		return
+	}
+	// A BFF is a confidential client by definition
+	if client.ClientSecret == "" && !client.UsesCertificateAuth() {
+		respondError(w, http.StatusBadRequest, "unauthorized_client", "Public clients cannot relay sessions")
+		return
+	}
+	if !authenticateClient(r, h.clientKeys, client, clientSecret, h.config) {
+		respondError(w, http.StatusUnauthorized, "invalid_client", "Invalid client credentials")
+		return
+	}
+	if client.Disabled {
+		respondClientDisabled(w, http.StatusUnauthorized)
+		return
+	}
+	if !slices.Contains(client.GrantTypes, BFFRelayGrantType) {
+		respondError(w, http.StatusBadRequest, "unauthorized_client", "Client is not registered as a backend-for-frontend")
+		return
+	}
+
+	thumbprint, ok := certificateBinding(r, client, h.config)
+	if !ok {
+		respondError(w, http.StatusBadRequest, "invalid_request", "A client certificate is required for certificate-bound tokens")
+		return
+	}
+	jkt, ok := dpopBinding(w, r, h.dpopNonces, client, h.config)
+	if !ok {
+		return
+	}
+	cnf := utils.NewConfirmationClaim(thumbprint, jkt)
+
+	// The relayed token is addressed to exactly one downstream API
+	audience, err := exchangeAudience(r.Form["audience"], r.Form["resource"], client.AllowedResources)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_target", err.Error())
+		return
+	}
+	if len(audience) != 1 {
+		respondError(w, http.StatusBadRequest, "invalid_target", "Exactly one downstream API must be requested")
+		return
+	}
+
+	sessionID := r.FormValue("session")
+	if sessionID == "" {
+		if cookie, err := r.Cookie(middleware.SSOCookieName); err == nil {
+			sessionID = cookie.Value
+		}
+	}
+	if sessionID == "" {
+		respondError(w, http.StatusBadRequest, "invalid_request", "Missing SSO session")
+		return
+	}
+
+	activity := sessionActivity(h.config)
+	session, err := h.ssoRepo.FindBySessionID(ctx, sessionID)
+	if err != nil || !activity.Active(session) {
+		respondError(w, http.StatusBadRequest, "invalid_grant", "SSO session is not active")
+		return
+	}
+	// Calls made through the BFF count as activity in the session
+	activity.Touch(ctx, h.ssoRepo, session)
+
+	scope, err := resolveScope(client, r.FormValue("scope"), utils.GetDefaultScope())
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_scope", err.Error())
+		return
+	}
+
+	// The user must have approved the BFF for these scopes, unless it is a
+	// first-party client that only needs the SSO session
+	if promptNoneRequiresConsent(client) {
+		status, _, err := h.consentRepo.CheckConsent(ctx, session.UserID, clientID, strings.Split(scope, " "))
+		if err != nil {
+			respondInternalError(w, err, "Failed to check consent")
+			return
+		}
+		if status != repository.ConsentGranted {
+			respondError(w, http.StatusBadRequest, "invalid_grant", "User has not consented to the requested scopes")
+			return
+		}
+	}
+
+	user, err := h.userRepo.FindByID(ctx, session.UserID)
+	if err != nil {
+		respondInternalError(w, err, "Failed to find user")
+		return
+	}
+	scope, ok = applyTokenPolicy(w, r, h.tokenPolicy, h.audit, BFFRelayGrantType, user, client, scope)
+	if !ok {
+		return
+	}
+
+	accessToken, err := h.tokens.issueAccessTokenWithExpiry(ctx, client, user.ID, user.Email, user.Name, scope, audience, cnf, h.config.BFFTokenExpiry, h.config)
+	if err != nil {
+		respondInternalError(w, err, "Failed to generate access token")
+		return
+	}
+
+	h.audit.record(r, models.AuditTokenIssued, user.ID, clientID, map[string]string{
+		"grant_type": BFFRelayGrantType,
+		"scope":      scope,
+		"audience":   audience[0],
+	})
+
+	respondJSON(w, http.StatusOK, TokenExchangeResponse{
+		AccessToken:     accessToken,
+		IssuedTokenType: AccessTokenType,
+		TokenType:       tokenType(cnf),
+		ExpiresIn:       h.config.BFFTokenExpiry,
+		Scope:           scope,
+	})
+}
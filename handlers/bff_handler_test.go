@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"oauth2-server/config"
+	"strings"
+	"testing"
+)
+
+func TestBFFHandler_RelayTokenRequiresClientID(t *testing.T) {
+	handler := NewBFFHandler(nil, nil, nil, nil, nil, nil, nil, nil, nil, &config.Config{})
+
+	form := url.Values{"session": {"sso-session"}, "audience": {"https://api.example.com"}}
+	req := httptest.NewRequest(http.MethodPost, "/bff/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	handler.RelayToken(w, req)
+
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "invalid_request") {
+		t.Errorf("Expected 400 invalid_request, got %d: %s", w.Code, w.Body.String())
+	}
+}
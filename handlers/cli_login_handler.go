@@ -0,0 +1,354 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"oauth2-server/config"
+	"oauth2-server/jwks"
+	"oauth2-server/middleware"
+	"oauth2-server/models"
+	"oauth2-server/policy"
+	"oauth2-server/repository"
+	"oauth2-server/utils"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DeviceCodeGrantType is the grant a CLI polls the token endpoint with while
+// its login waits for approval (RFC 8628)
+const DeviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// deviceCodeLength is the length of the secret code a CLI polls with
+const deviceCodeLength = 48
+
+// CLILoginHandler lets command line tools log in without a browser redirect.
+// The CLI starts a login and shows a short user code; a signed-in user
+// approves it at /activate while the CLI polls the token endpoint.
+type CLILoginHandler struct {
+	userRepo    *repository.UserRepository
+	clientRepo  *repository.ClientRepository
+	loginRepo   *repository.CLILoginRepository
+	templates   *TemplateRenderer
+	audit       *Auditor
+	tokens      *TokenStore
+	dpopNonces  *utils.NonceSource
+	tokenPolicy policy.Policy
+	clientKeys  *jwks.Cache
+	config      *config.Config
+}
+
+func NewCLILoginHandler(
+	userRepo *repository.UserRepository,
+	clientRepo *repository.ClientRepository,
+	loginRepo *repository.CLILoginRepository,
+	templates *TemplateRenderer,
+	audit *Auditor,
+	tokens *TokenStore,
+	dpopNonces *utils.NonceSource,
+	tokenPolicy policy.Policy,
+	clientKeys *jwks.Cache,
+	cfg *config.Config,
+) *CLILoginHandler {
+	return &CLILoginHandler{
+		userRepo:    userRepo,
+		clientRepo:  clientRepo,
+		loginRepo:   loginRepo,
+		templates:   templates,
+		audit:       audit,
+		tokens:      tokens,
+		dpopNonces:  dpopNonces,
+		tokenPolicy: tokenPolicy,
+		clientKeys:  clientKeys,
+		config:      cfg,
+	}
+}
+
+// CLILoginResponse tells the CLI what to show the user and how to poll
+type CLILoginResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+// StartLogin creates a pending login for a client registered with the device
+// code grant
+// POST /cli/authorize
+func (h *CLILoginHandler) StartLogin(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_request", "Failed to parse form")
+		return
+	}
+
+	clientID, _ := clientCredentials(r)
+	if clientID == "" {
+		respondError(w, http.StatusBadRequest, "invalid_request", "Missing required parameters")
+		return
+	}
+
+	ctx := r.Context()
+	client, ok := h.authenticate(w, r, clientID)
+	if !ok {
+		return
+	}
+
+	if !slices.Contains(client.GrantTypes, DeviceCodeGrantType) {
+		respondError(w, http.StatusBadRequest, "unauthorized_client", "Client is not registered for CLI login")
+		return
+	}
+
+	scope, err := resolveScope(client, r.FormValue("scope"), utils.GetDefaultScope())
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_scope", err.Error())
+		return
+	}
+
+	deviceCode, err := utils.GenerateRandomString(deviceCodeLength)
+	if err != nil {
+		respondInternalError(w, err, "Failed to generate device code")
+		return
+	}
+
+	login := &models.CLILogin{
+		DeviceCode: deviceCode,
+		ClientID:   clientID,
+		Scope:      scope,
+		ExpiresAt:  time.Now().Add(time.Duration(h.config.CLILoginExpiry) * time.Second),
+	}
+
+	// User codes are short, so retry the rare collision with a pending login
+	for attempt := 0; ; attempt++ {
+		login.UserCode, err = utils.GenerateUserCode()
+		if err == nil {
+			err = h.loginRepo.Create(ctx, login)
+		}
+		if err == nil || !errors.Is(err, repository.ErrDuplicate) || attempt == 2 {
+			break
+		}
+	}
+	if err != nil {
+		respondInternalError(w, err, "Failed to create login")
+		return
+	}
+
+	verificationURI := h.config.PublicURL + "/activate"
+	respondJSON(w, http.StatusOK, CLILoginResponse{
+		DeviceCode:              deviceCode,
+		UserCode:                login.UserCode,
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURI + "?user_code=" + url.QueryEscape(login.UserCode),
+		ExpiresIn:               h.config.CLILoginExpiry,
+		Interval:                h.config.CLILoginInterval,
+	})
+}
+
+// ShowActivate renders the activation page. Signed-out users are asked to
+// sign in first; with a user code the pending login is shown for approval.
+// GET /activate
+func (h *CLILoginHandler) ShowActivate(w http.ResponseWriter, r *http.Request) {
+	userCode := utils.NormalizeUserCode(r.URL.Query().Get("user_code"))
+	data := map[string]interface{}{"UserCode": userCode, "CSRFToken": csrfToken(w, r)}
+
+	ssoSession, _ := r.Context().Value(middleware.SSOSessionContextKey).(*models.SSOSession)
+	if ssoSession == nil || !ssoSession.Authenticated {
+		h.templates.Render(w, "activate.html", data)
+		return
+	}
+	data["SignedIn"] = true
+
+	if userCode != "" {
+		ctx := r.Context()
+		login, err := h.loginRepo.FindPendingByUserCode(ctx, userCode)
+		if err != nil {
+			data["Error"] = "This code is invalid or has expired."
+		} else {
+			data["Confirm"] = true
+			data["ClientName"] = login.ClientID
+			if client, err := h.clientRepo.FindByClientID(ctx, login.ClientID); err == nil {
+				data["ClientName"] = client.Name
+			}
+			data["Scopes"] = strings.Fields(login.Scope)
+		}
+	}
+
+	h.templates.Render(w, "activate.html", data)
+}
+
+// Activate approves or denies the login for the submitted user code
+// POST /activate
+func (h *CLILoginHandler) Activate(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_request", "Failed to parse form")
+		return
+	}
+
+	ssoSession, _ := r.Context().Value(middleware.SSOSessionContextKey).(*models.SSOSession)
+	if ssoSession == nil || !ssoSession.Authenticated {
+		respondError(w, http.StatusUnauthorized, "unauthorized", "Authentication required")
+		return
+	}
+	if !requireCSRF(w, r, h.config) {
+		return
+	}
+
+	userCode := utils.NormalizeUserCode(r.FormValue("user_code"))
+	status, event := models.CLILoginApproved, models.AuditConsentGranted
+	if r.FormValue("action") != "approve" {
+		status, event = models.CLILoginDenied, models.AuditConsentDenied
+	}
+
+	ctx := r.Context()
+	login, err := h.loginRepo.FindPendingByUserCode(ctx, userCode)
+	if err == nil {
+		err = h.loginRepo.Decide(ctx, userCode, status, ssoSession.UserID)
+	}
+	data := map[string]interface{}{"SignedIn": true, "UserCode": userCode, "CSRFToken": csrfToken(w, r)}
+	if err != nil {
+		if !errors.Is(err, repository.ErrNotFound) && !errors.Is(err, repository.ErrConflict) {
+			respondInternalError(w, err, "Failed to update login")
+			return
+		}
+		data["Error"] = "This code is invalid or has expired."
+		h.templates.Render(w, "activate.html", data)
+		return
+	}
+
+	h.audit.record(r, event, ssoSession.UserID, login.ClientID, map[string]string{"scope": login.Scope, "flow": "cli_login"})
+
+	data["Result"] = status
+	h.templates.Render(w, "activate.html", data)
+}
+
+// HandleGrant answers a CLI polling with its device code: pending and denied
+// logins get the RFC 8628 errors, an approved login is exchanged for tokens
+// exactly once
+func (h *CLILoginHandler) HandleGrant(w http.ResponseWriter, r *http.Request) {
+	deviceCode := r.FormValue("device_code")
+	clientID, _ := clientCredentials(r)
+	if deviceCode == "" || clientID == "" {
+		respondError(w, http.StatusBadRequest, "invalid_request", "Missing required parameters")
+		return
+	}
+
+	ctx := r.Context()
+	client, ok := h.authenticate(w, r, clientID)
+	if !ok {
+		return
+	}
+
+	login, err := h.loginRepo.Poll(ctx, deviceCode)
+	if err != nil || login.ClientID != clientID {
+		respondError(w, http.StatusBadRequest, "invalid_grant", "Invalid device code")
+		return
+	}
+
+	if time.Now().After(login.ExpiresAt) {
+		h.loginRepo.Delete(ctx, deviceCode)
+		respondError(w, http.StatusBadRequest, "expired_token", "The login has expired")
+		return
+	}
+
+	switch login.Status {
+	case models.CLILoginDenied:
+		h.loginRepo.Delete(ctx, deviceCode)
+		respondError(w, http.StatusBadRequest, "access_denied", "The user denied the login")
+		return
+	case models.CLILoginPending:
+		interval := time.Duration(h.config.CLILoginInterval) * time.Second
+		if !login.LastPolledAt.IsZero() && time.Since(login.LastPolledAt) < interval {
+			respondError(w, http.StatusBadRequest, "slow_down", "Polling too frequently")
+			return
+		}
+		respondError(w, http.StatusBadRequest, "authorization_pending", "The login has not been approved yet")
+		return
+	}
+
+	thumbprint, ok := certificateBinding(r, client, h.config)
+	if !ok {
+		respondError(w, http.StatusBadRequest, "invalid_request", "A client certificate is required for certificate-bound tokens")
+		return
+	}
+	jkt, ok := dpopBinding(w, r, h.dpopNonces, client, h.config)
+	if !ok {
+		return
+	}
+	cnf := utils.NewConfirmationClaim(thumbprint, jkt)
+
+	login, err = h.loginRepo.Redeem(ctx, deviceCode)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_grant", "Invalid device code")
+		return
+	}
+
+	user, err := h.userRepo.FindByID(ctx, login.UserID)
+	if err != nil {
+		respondInternalError(w, err, "Failed to find user")
+		return
+	}
+
+	login.Scope, ok = applyTokenPolicy(w, r, h.tokenPolicy, h.audit, DeviceCodeGrantType, user, client, login.Scope)
+	if !ok {
+		return
+	}
+
+	accessToken, err := h.tokens.issueAccessToken(ctx, client, user.ID, user.Email, user.Name, login.Scope, nil, cnf, h.config)
+	if err != nil {
+		respondInternalError(w, err, "Failed to generate access token")
+		return
+	}
+
+	refreshToken, err := utils.GenerateRefreshToken(user.ID, clientID, login.Scope, h.config.PrivateKey, h.config.RefreshTokenExpiry)
+	if err != nil {
+		respondInternalError(w, err, "Failed to generate refresh token")
+		return
+	}
+
+	response := models.TokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    tokenType(cnf),
+		ExpiresIn:    h.config.AccessTokenExpiry,
+		RefreshToken: refreshToken,
+		Scope:        login.Scope,
+	}
+
+	if utils.RequiresOpenID(login.Scope) {
+		response.IDToken, err = buildIDToken(&idTokenRequest{User: user, Client: client, Scope: login.Scope}, h.config)
+		if err != nil {
+			respondInternalError(w, err, "Failed to generate ID token")
+			return
+		}
+	}
+
+	h.audit.record(r, models.AuditTokenIssued, user.ID, clientID, map[string]string{"grant_type": DeviceCodeGrantType, "scope": login.Scope})
+
+	respondJSON(w, http.StatusOK, response)
+}
+
+// authenticate loads the client and checks its credentials and that it is
+// registered for the device code grant. CLIs are usually public clients;
+// confidential ones must authenticate as at the token endpoint.
+func (h *CLILoginHandler) authenticate(w http.ResponseWriter, r *http.Request, clientID string) (*models.Client, bool) {
+	client, err := h.clientRepo.FindByClientID(r.Context(), clientID)
+	if err != nil {
+		respondError(w, http.StatusUnauthorized, "invalid_client", "Invalid client")
+		return nil, false
+	}
+	_, secret := clientCredentials(r)
+	if (client.ClientSecret != "" || client.UsesCertificateAuth()) && !authenticateClient(r, h.clientKeys, client, secret, h.config) {
+		respondError(w, http.StatusUnauthorized, "invalid_client", "Invalid client credentials")
+		return nil, false
+	}
+	if client.Disabled {
+		respondClientDisabled(w, http.StatusUnauthorized)
+		return nil, false
+	}
+	if !utils.GrantTypeAllowed(DeviceCodeGrantType, client.GrantTypes) {
+		respondError(w, http.StatusBadRequest, "unauthorized_client", "Client is not authorized to use CLI login")
+		return nil, false
+	}
+	return client, true
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"oauth2-server/config"
+	"oauth2-server/middleware"
+	"oauth2-server/models"
+	"strings"
+	"testing"
+)
+
+func TestCLILoginHandler_MissingParameters(t *testing.T) {
+	handler := NewCLILoginHandler(nil, nil, nil, NewTemplateRenderer(false, "", ""), nil, nil, nil, nil, nil, &config.Config{})
+
+	for name, serve := range map[string]http.HandlerFunc{
+		"start": handler.StartLogin,
+		"grant": handler.HandleGrant,
+	} {
+		form := url.Values{"device_code": {"abc"}}
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		serve(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", name, w.Code)
+		}
+		if !strings.Contains(w.Body.String(), "invalid_request") {
+			t.Errorf("%s: expected invalid_request, got %s", name, w.Body.String())
+		}
+	}
+}
+
+func TestCLILoginHandler_ShowActivateAsksSignedOutUserToSignIn(t *testing.T) {
+	handler := NewCLILoginHandler(nil, nil, nil, NewTemplateRenderer(false, "", ""), nil, nil, nil, nil, nil, &config.Config{})
+
+	req := httptest.NewRequest(http.MethodGet, "/activate?user_code=bcdf-ghjk", nil)
+	w := httptest.NewRecorder()
+	handler.ShowActivate(w, req)
+
+	body := w.Body.String()
+	if !strings.Contains(body, `id="loginForm"`) {
+		t.Error("Expected the sign-in form")
+	}
+	if strings.Contains(body, `action="/activate"`) {
+		t.Error("Expected no activation form before signing in")
+	}
+}
+
+func TestCLILoginHandler_ShowActivateAsksForCode(t *testing.T) {
+	handler := NewCLILoginHandler(nil, nil, nil, NewTemplateRenderer(false, "", ""), nil, nil, nil, nil, nil, &config.Config{})
+
+	session := &models.SSOSession{UserID: "user-1", Authenticated: true}
+	req := httptest.NewRequest(http.MethodGet, "/activate", nil)
+	req = req.WithContext(context.WithValue(req.Context(), middleware.SSOSessionContextKey, session))
+	w := httptest.NewRecorder()
+	handler.ShowActivate(w, req)
+
+	body := w.Body.String()
+	if !strings.Contains(body, `name="user_code"`) || !strings.Contains(body, `method="GET"`) {
+		t.Error("Expected the user code form")
+	}
+}
+
+func TestCLILoginHandler_ActivateRequiresSignIn(t *testing.T) {
+	handler := NewCLILoginHandler(nil, nil, nil, NewTemplateRenderer(false, "", ""), nil, nil, nil, nil, nil, &config.Config{})
+
+	form := url.Values{"user_code": {"BCDF-GHJK"}, "action": {"approve"}}
+	req := httptest.NewRequest(http.MethodPost, "/activate", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	handler.Activate(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401, got %d", w.Code)
+	}
+}
+
+func TestTemplateRenderer_ActivateStates(t *testing.T) {
+	renderer := NewTemplateRenderer(false, "", "")
+
+	for _, tc := range []struct {
+		name string
+		data map[string]interface{}
+		want string
+	}{
+		{"confirm", map[string]interface{}{"SignedIn": true, "Confirm": true, "UserCode": "BCDF-GHJK", "ClientName": "Demo CLI", "Scopes": []string{"openid"}}, `value="approve"`},
+		{"approved", map[string]interface{}{"SignedIn": true, "Result": models.CLILoginApproved}, "Login approved"},
+		{"denied", map[string]interface{}{"SignedIn": true, "Result": models.CLILoginDenied}, "Login denied"},
+		{"error", map[string]interface{}{"SignedIn": true, "Error": "This code is invalid or has expired."}, "invalid or has expired"},
+	} {
+		w := httptest.NewRecorder()
+		renderer.Render(w, "activate.html", tc.data)
+		if !strings.Contains(w.Body.String(), tc.want) {
+			t.Errorf("%s: expected page to contain %q", tc.name, tc.want)
+		}
+	}
+}
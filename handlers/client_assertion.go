@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"oauth2-server/config"
+	"oauth2-server/jwks"
+	"oauth2-server/models"
+	"oauth2-server/utils"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ClientAssertionType is the client_assertion_type of private_key_jwt
+// client authentication (RFC 7523 section 2.2)
+const ClientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// clientSigningAlgs are the algorithms accepted for client assertions and
+// request objects
+var clientSigningAlgs = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// clientAssertionMaxLifetime bounds how far in the future an assertion may
+// expire, which is also how long its jti is remembered
+const clientAssertionMaxLifetime = time.Hour
+
+// usedAssertions remembers the assertions already presented until they
+// expire, so a captured assertion cannot be replayed
+var usedAssertions = &assertionReplayCache{entries: map[string]time.Time{}}
+
+type assertionReplayCache struct {
+	mu       sync.Mutex
+	entries  map[string]time.Time // client_id and jti to expiry
+	prunedAt time.Time
+}
+
+// use records the assertion and reports false when it was seen before
+func (c *assertionReplayCache) use(clientID, jti string, expiresAt time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.prunedAt) > time.Minute {
+		for id, expiry := range c.entries {
+			if now.After(expiry) {
+				delete(c.entries, id)
+			}
+		}
+		c.prunedAt = now
+	}
+
+	id := clientID + "\x00" + jti
+	if expiry, ok := c.entries[id]; ok && now.Before(expiry) {
+		return false
+	}
+	c.entries[id] = expiresAt
+	return true
+}
+
+// clientVerificationKey returns the client's public key with the given ID,
+// from its jwks_uri when it registered one and otherwise from its jwks.
+// keys fetches the key sets; while nil, clients registered with a jwks_uri
+// cannot sign assertions or request objects.
+func clientVerificationKey(ctx context.Context, keys *jwks.Cache, client *models.Client, kid string) (interface{}, error) {
+	if client.JWKSURI != "" {
+		if keys == nil {
+			return nil, errors.New("client key sets are not fetched")
+		}
+		key, err := keys.Key(ctx, client.JWKSURI, kid)
+		if err != nil {
+			return nil, err
+		}
+		return key.Key, nil
+	}
+
+	key := client.JWKS.SigningKey(kid)
+	if key == nil {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return utils.ParsePublicKeyJWK(key.N, key.E)
+}
+
+// parseClientJWT checks the signature of a JWT signed by the client
+func parseClientJWT(ctx context.Context, keys *jwks.Cache, client *models.Client, token string, claims jwt.Claims, opts ...jwt.ParserOption) error {
+	opts = append(opts, jwt.WithValidMethods(clientSigningAlgs), jwt.WithIssuer(client.ClientID))
+	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return clientVerificationKey(ctx, keys, client, kid)
+	}, opts...)
+	return err
+}
+
+// verifyClientAssertion checks a private_key_jwt client assertion (RFC 7523
+// section 3): issued by the client about itself, for this server or the
+// endpoint it was sent to, not expired and not presented before
+func verifyClientAssertion(r *http.Request, keys *jwks.Cache, client *models.Client, cfg *config.Config) error {
+	if r.FormValue("client_assertion_type") != ClientAssertionType {
+		return errors.New("unsupported client_assertion_type")
+	}
+	assertion := r.FormValue("client_assertion")
+	if assertion == "" {
+		return errors.New("missing client_assertion")
+	}
+
+	var claims jwt.RegisteredClaims
+	if err := parseClientJWT(r.Context(), keys, client, assertion, &claims, jwt.WithSubject(client.ClientID), jwt.WithExpirationRequired()); err != nil {
+		return err
+	}
+	audiences := []string{cfg.IssuerURL, cfg.IssuerURL + r.URL.Path}
+	if !slices.ContainsFunc(claims.Audience, func(aud string) bool { return slices.Contains(audiences, aud) }) {
+		return errors.New("assertion is not addressed to this server")
+	}
+	if claims.ID == "" {
+		return errors.New("assertion has no jti")
+	}
+	expiresAt := claims.ExpiresAt.Time
+	if time.Until(expiresAt) > clientAssertionMaxLifetime {
+		return errors.New("assertion expires too far in the future")
+	}
+	if !usedAssertions.use(client.ClientID, claims.ID, expiresAt) {
+		return errors.New("assertion was already used")
+	}
+	return nil
+}
+
+// assertionClientID returns the unverified subject of the client assertion,
+// for private_key_jwt clients that leave out client_id
+func assertionClientID(r *http.Request) string {
+	if r.FormValue("client_assertion_type") != ClientAssertionType {
+		return ""
+	}
+	var claims jwt.RegisteredClaims
+	if _, _, err := jwt.NewParser().ParseUnverified(r.FormValue("client_assertion"), &claims); err != nil {
+		return ""
+	}
+	return claims.Subject
+}
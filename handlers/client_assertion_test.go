@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"oauth2-server/config"
+	"oauth2-server/jwks"
+	"oauth2-server/models"
+	"oauth2-server/utils"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// signAssertion signs a client assertion for the token endpoint
+func signAssertion(t *testing.T, key *rsa.PrivateKey, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("Failed to sign assertion: %v", err)
+	}
+	return signed
+}
+
+func assertionRequest(clientID, assertion string) *http.Request {
+	form := url.Values{
+		"client_id":             {clientID},
+		"client_assertion_type": {ClientAssertionType},
+		"client_assertion":      {assertion},
+	}
+	req := httptest.NewRequest("POST", "/oauth/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+func TestVerifyClientAssertion(t *testing.T) {
+	clientKey, err := utils.GenerateRSAKeyPair(2048)
+	if err != nil {
+		t.Fatalf("Failed to generate client key: %v", err)
+	}
+	cfg := &config.Config{IssuerURL: "https://auth.example.com"}
+	client := &models.Client{
+		ClientID:                "client-1",
+		TokenEndpointAuthMethod: models.AuthMethodPrivateKeyJWT,
+		JWKS: &models.JSONWebKeySet{Keys: []models.JSONWebKey{{
+			Kty: "RSA",
+			Kid: "key-1",
+			N:   base64.RawURLEncoding.EncodeToString(clientKey.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(clientKey.E)).Bytes()),
+		}}},
+	}
+	claims := func(jti string) jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss": "client-1",
+			"sub": "client-1",
+			"aud": "https://auth.example.com/oauth/token",
+			"jti": jti,
+			"exp": time.Now().Add(5 * time.Minute).Unix(),
+		}
+	}
+
+	assertion := signAssertion(t, clientKey, "key-1", claims("assertion-1"))
+	if err := verifyClientAssertion(assertionRequest("client-1", assertion), nil, client, cfg); err != nil {
+		t.Fatalf("Expected the assertion to be accepted: %v", err)
+	}
+	if err := verifyClientAssertion(assertionRequest("client-1", assertion), nil, client, cfg); err == nil {
+		t.Error("Expected a replayed assertion to be rejected")
+	}
+
+	tests := []struct {
+		name   string
+		modify func(jwt.MapClaims)
+	}{
+		{"other audience", func(c jwt.MapClaims) { c["aud"] = "https://other.example.com" }},
+		{"other subject", func(c jwt.MapClaims) { c["sub"] = "client-2" }},
+		{"expired", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() }},
+		{"long lived", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(24 * time.Hour).Unix() }},
+		{"no jti", func(c jwt.MapClaims) { delete(c, "jti") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := claims("assertion-" + tt.name)
+			tt.modify(c)
+			if err := verifyClientAssertion(assertionRequest("client-1", signAssertion(t, clientKey, "key-1", c)), nil, client, cfg); err == nil {
+				t.Error("Expected the assertion to be rejected")
+			}
+		})
+	}
+
+	// Signed with a key the client did not register
+	otherKey, _ := utils.GenerateRSAKeyPair(2048)
+	if err := verifyClientAssertion(assertionRequest("client-1", signAssertion(t, otherKey, "key-1", claims("assertion-2"))), nil, client, cfg); err == nil {
+		t.Error("Expected an assertion signed with another key to be rejected")
+	}
+
+	// client_id may be left out and taken from the assertion
+	req := assertionRequest("", assertion)
+	if id := assertionClientID(req); id != "client-1" {
+		t.Errorf("Expected client-1 from the assertion, got %q", id)
+	}
+}
+
+func TestClientVerificationKey_JWKSURI(t *testing.T) {
+	clientKey, err := utils.GenerateRSAKeyPair(2048)
+	if err != nil {
+		t.Fatalf("Failed to generate client key: %v", err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: &clientKey.PublicKey, KeyID: "key-1", Use: "sig"}}})
+	}))
+	defer server.Close()
+
+	keys := jwks.NewCache(time.Second, time.Hour, time.Minute, 1, 0)
+	cfg := &config.Config{IssuerURL: "https://auth.example.com"}
+	client := &models.Client{ClientID: "client-1", TokenEndpointAuthMethod: models.AuthMethodPrivateKeyJWT, JWKSURI: server.URL}
+
+	assertion := signAssertion(t, clientKey, "key-1", jwt.MapClaims{
+		"iss": "client-1",
+		"sub": "client-1",
+		"aud": "https://auth.example.com",
+		"jti": "jwks-uri-assertion",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	})
+	if !authenticateClient(assertionRequest("client-1", assertion), keys, client, "", cfg) {
+		t.Error("Expected the client to authenticate with a key from its jwks_uri")
+	}
+	if _, err := clientVerificationKey(context.Background(), keys, client, "key-2"); err == nil {
+		t.Error("Expected an unknown key ID to be rejected")
+	}
+}
+
+func TestRequestObjectParams(t *testing.T) {
+	clientKey, err := utils.GenerateRSAKeyPair(2048)
+	if err != nil {
+		t.Fatalf("Failed to generate client key: %v", err)
+	}
+	h := &OAuthHandler{config: &config.Config{IssuerURL: "https://auth.example.com"}}
+	client := &models.Client{
+		ClientID: "client-1",
+		JWKS: &models.JSONWebKeySet{Keys: []models.JSONWebKey{{
+			Kty: "RSA",
+			N:   base64.RawURLEncoding.EncodeToString(clientKey.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(clientKey.E)).Bytes()),
+		}}},
+	}
+	req := httptest.NewRequest("GET", "/oauth/authorize", nil)
+
+	requestObject := signAssertion(t, clientKey, "", jwt.MapClaims{
+		"iss":           "client-1",
+		"aud":           "https://auth.example.com",
+		"client_id":     "client-1",
+		"response_type": "code",
+		"redirect_uri":  "https://app.example.com/callback",
+		"scope":         "openid profile",
+		"max_age":       300,
+		"resource":      []string{"https://api.example.com", "https://files.example.com"},
+	})
+	params, err := h.requestObjectParams(req, client, requestObject)
+	if err != nil {
+		t.Fatalf("Expected the request object to be accepted: %v", err)
+	}
+	if params.Get("client_id") != "client-1" || params.Get("scope") != "openid profile" || params.Get("max_age") != "300" || len(params["resource"]) != 2 {
+		t.Errorf("Unexpected parameters %v", params)
+	}
+	if params.Has("iss") || params.Has("aud") {
+		t.Errorf("Expected JWT claims to be left out, got %v", params)
+	}
+
+	for name, claims := range map[string]jwt.MapClaims{
+		"other client":   {"iss": "client-1", "aud": "https://auth.example.com", "client_id": "client-2"},
+		"other issuer":   {"iss": "client-2", "aud": "https://auth.example.com"},
+		"other audience": {"iss": "client-1", "aud": "https://other.example.com"},
+	} {
+		if _, err := h.requestObjectParams(req, client, signAssertion(t, clientKey, "", claims)); err == nil {
+			t.Errorf("%s: expected the request object to be rejected", name)
+		}
+	}
+}
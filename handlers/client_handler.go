@@ -1,50 +1,155 @@
 package handlers
 
 import (
-	"context"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"net/http"
+	"net/url"
+	"oauth2-server/config"
 	"oauth2-server/models"
 	"oauth2-server/repository"
 	"oauth2-server/utils"
+	"regexp"
+	"slices"
 	"strings"
-
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type ClientHandler struct {
 	clientRepo     *repository.ClientRepository
 	scopeRegistry  *models.ScopeRegistry
 	scopeValidator utils.ScopeValidator
+	audit          *Auditor
+	config         *config.Config
 }
 
-func NewClientHandler(clientRepo *repository.ClientRepository, scopeRegistry *models.ScopeRegistry, scopeValidator utils.ScopeValidator) *ClientHandler {
+func NewClientHandler(clientRepo *repository.ClientRepository, scopeRegistry *models.ScopeRegistry, scopeValidator utils.ScopeValidator, audit *Auditor, cfg *config.Config) *ClientHandler {
 	return &ClientHandler{
 		clientRepo:     clientRepo,
 		scopeRegistry:  scopeRegistry,
 		scopeValidator: scopeValidator,
+		audit:          audit,
+		config:         cfg,
 	}
 }
 
+// ClientRequest is the body for registering a client or, through the
+// developer portal, updating one
+type ClientRequest struct {
+	Name          string   `json:"name"`
+	RedirectURIs  []string `json:"redirect_uris"`
+	AllowedScopes []string `json:"allowed_scopes,omitempty"`
+	GrantTypes    []string `json:"grant_types,omitempty"`
+	ResponseTypes []string `json:"response_types,omitempty"`
+	Resources     []string `json:"allowed_resources,omitempty"`
+	IsPublic      bool     `json:"is_public,omitempty"` // For PKCE clients (SPA, mobile apps)
+	ConsentPolicy string   `json:"consent_policy,omitempty"`
+	ConsentTTL    int64    `json:"consent_ttl_days,omitempty"`
+	PromptNone    string   `json:"prompt_none_policy,omitempty"`
+	ScopeMode     string   `json:"scope_mode,omitempty"`
+	RedirectMatch string   `json:"redirect_uri_matching,omitempty"`
+	TokenExchange string   `json:"token_exchange_policy,omitempty"`
+	TokenFormat   string   `json:"access_token_format,omitempty"`
+
+	// Mutual TLS client authentication and certificate-bound tokens (RFC 8705)
+	AuthMethod    string `json:"token_endpoint_auth_method,omitempty"`
+	TLSSubjectDN  string `json:"tls_client_auth_subject_dn,omitempty"`
+	TLSThumbprint string `json:"tls_client_certificate_thumbprint,omitempty"`
+	BoundTokens   bool   `json:"tls_client_certificate_bound_access_tokens,omitempty"`
+
+	// DPoP-bound access tokens (RFC 9449)
+	DPoPBound bool `json:"dpop_bound_access_tokens,omitempty"`
+
+	// Signed and encrypted UserInfo responses; encryption uses a key in jwks
+	UserInfoSigned string                `json:"userinfo_signed_response_alg,omitempty"`
+	UserInfoEncAlg string                `json:"userinfo_encrypted_response_alg,omitempty"`
+	UserInfoEncEnc string                `json:"userinfo_encrypted_response_enc,omitempty"`
+	JWKS           *models.JSONWebKeySet `json:"jwks,omitempty"`
+
+	// Where the client publishes its keys, instead of jwks, for
+	// private_key_jwt and request objects
+	JWKSURI string `json:"jwks_uri,omitempty"`
+
+	// Encrypted ID tokens, also using a key in jwks
+	IDTokenEncAlg string `json:"id_token_encrypted_response_alg,omitempty"`
+	IDTokenEncEnc string `json:"id_token_encrypted_response_enc,omitempty"`
+
+	// Shown on the consent page once an administrator approves it
+	ConsentMessage string `json:"consent_message,omitempty"`
+
+	// Branding for the login, registration and consent pages
+	LogoURI    string `json:"logo_uri,omitempty"`
+	BrandColor string `json:"brand_color,omitempty"`
+
+	// Where logout tokens are posted when the user revokes the client
+	BackchannelLogoutURI string `json:"backchannel_logout_uri,omitempty"`
+}
+
+// clientRequestError is a validation failure for a client request
+type clientRequestError struct {
+	code        string
+	description string
+}
+
+func (e *clientRequestError) Error() string {
+	return e.description
+}
+
+// RegisterClient registers a third-party client
+// POST /clients/register
 func (h *ClientHandler) RegisterClient(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		Name          string   `json:"name"`
-		RedirectURIs  []string `json:"redirect_uris"`
-		AllowedScopes []string `json:"allowed_scopes,omitempty"`
-		GrantTypes    []string `json:"grant_types,omitempty"`
-		IsPublic      bool     `json:"is_public,omitempty"` // For PKCE clients (SPA, mobile apps)
-	}
+	h.registerClient(w, r, false)
+}
+
+// RegisterFirstPartyClient registers a first-party client, which may request
+// the admin scope and skip consent for prompt=none. It must be wrapped in
+// RequireAdmin.
+// POST /admin/clients
+func (h *ClientHandler) RegisterFirstPartyClient(w http.ResponseWriter, r *http.Request) {
+	h.registerClient(w, r, true)
+}
 
+func (h *ClientHandler) registerClient(w http.ResponseWriter, r *http.Request, firstParty bool) {
+	var req ClientRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		respondError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
 		return
 	}
 
-	if req.Name == "" || len(req.RedirectURIs) == 0 {
-		respondError(w, http.StatusBadRequest, "invalid_request", "Missing required fields")
+	if err := h.validateClientRequest(&req, firstParty); err != nil {
+		respondError(w, http.StatusBadRequest, err.code, err.description)
+		return
+	}
+
+	client, err := newClient(&req, "")
+	if err != nil {
+		respondInternalError(w, err, "Failed to generate client credentials")
+		return
+	}
+	client.FirstParty = firstParty
+
+	ctx := r.Context()
+	if err := h.clientRepo.Create(ctx, client); err != nil {
+		respondRepositoryError(w, err, "Client", "Failed to create client")
 		return
 	}
 
+	h.audit.record(r, models.AuditClientRegistered, "", client.ClientID, map[string]string{"name": client.Name})
+	respondJSON(w, http.StatusCreated, clientResponse(client, req.IsPublic))
+}
+
+// validateClientRequest checks the requested client settings and fills in
+// defaults for those left empty. The admin scope and skipping consent for
+// prompt=none are reserved for first-party clients.
+func (h *ClientHandler) validateClientRequest(req *ClientRequest, firstParty bool) *clientRequestError {
+	if req.Name == "" {
+		return &clientRequestError{"invalid_request", "Missing required fields"}
+	}
+
+	if !firstParty && slices.Contains(req.AllowedScopes, AdminScope) {
+		return &clientRequestError{"invalid_scope", "The admin scope is reserved for first-party clients"}
+	}
+
 	// Validate allowed_scopes if provided
 	if len(req.AllowedScopes) > 0 {
 		var invalidScopes []string
@@ -54,15 +159,17 @@ func (h *ClientHandler) RegisterClient(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 		if len(invalidScopes) > 0 {
-			respondError(w, http.StatusBadRequest, "invalid_scope", 
-				"Invalid scopes in allowed_scopes: "+strings.Join(invalidScopes, ", "))
-			return
+			return &clientRequestError{"invalid_scope", "Invalid scopes in allowed_scopes: " + strings.Join(invalidScopes, ", ")}
 		}
 	} else {
-		// Default to all scopes if not specified
+		// Default to all scopes if not specified; admin access must be
+		// requested explicitly
 		allScopes := h.scopeRegistry.GetAllScopes()
 		req.AllowedScopes = make([]string, 0, len(allScopes))
 		for _, scope := range allScopes {
+			if scope.Name == AdminScope {
+				continue
+			}
 			req.AllowedScopes = append(req.AllowedScopes, scope.Name)
 		}
 	}
@@ -73,29 +180,276 @@ func (h *ClientHandler) RegisterClient(w http.ResponseWriter, r *http.Request) {
 		"refresh_token":      true,
 		"client_credentials": true,
 		"password":           true,
+		DeviceCodeGrantType:  true,
+		BFFRelayGrantType:    true,
+	}
+
+	var invalidGrantTypes []string
+	for _, grantType := range req.GrantTypes {
+		if !supportedGrantTypes[grantType] {
+			invalidGrantTypes = append(invalidGrantTypes, grantType)
+		}
+	}
+	if len(invalidGrantTypes) > 0 {
+		return &clientRequestError{"invalid_request", "Unsupported grant types: " + strings.Join(invalidGrantTypes, ", ")}
+	}
+
+	// Validate response_types if provided
+	var invalidResponseTypes []string
+	for i, responseType := range req.ResponseTypes {
+		if !utils.IsSupportedResponseType(responseType) {
+			invalidResponseTypes = append(invalidResponseTypes, responseType)
+			continue
+		}
+		req.ResponseTypes[i] = utils.NormalizeResponseType(responseType)
+	}
+	if len(invalidResponseTypes) > 0 {
+		return &clientRequestError{"invalid_request", "Unsupported response types: " + strings.Join(invalidResponseTypes, ", ")}
 	}
-	
-	if len(req.GrantTypes) > 0 {
-		var invalidGrantTypes []string
-		for _, grantType := range req.GrantTypes {
-			if !supportedGrantTypes[grantType] {
-				invalidGrantTypes = append(invalidGrantTypes, grantType)
+
+	switch {
+	case len(req.GrantTypes) == 0 && len(req.ResponseTypes) == 0:
+		// Default to the authorization code flow if neither is specified
+		req.GrantTypes = utils.DefaultGrantTypes
+		req.ResponseTypes = utils.DefaultResponseTypes
+	case len(req.GrantTypes) == 0:
+		// Grant the flows the response types rely on
+		for _, responseType := range req.ResponseTypes {
+			for _, grantType := range utils.ResponseTypeGrantTypes(responseType) {
+				if !slices.Contains(req.GrantTypes, grantType) {
+					req.GrantTypes = append(req.GrantTypes, grantType)
+				}
 			}
 		}
-		if len(invalidGrantTypes) > 0 {
-			respondError(w, http.StatusBadRequest, "invalid_request", 
-				"Unsupported grant types: "+strings.Join(invalidGrantTypes, ", "))
-			return
+		if slices.Contains(req.GrantTypes, "authorization_code") {
+			req.GrantTypes = append(req.GrantTypes, "refresh_token")
 		}
-	} else {
-		// Default to authorization_code and refresh_token if not specified
-		req.GrantTypes = []string{"authorization_code", "refresh_token"}
+	case len(req.ResponseTypes) == 0 && slices.Contains(req.GrantTypes, "authorization_code"):
+		req.ResponseTypes = utils.DefaultResponseTypes
+	}
+
+	// Every response type needs the grant types it relies on; a client
+	// without response types cannot use the authorization endpoint
+	for _, responseType := range req.ResponseTypes {
+		for _, grantType := range utils.ResponseTypeGrantTypes(responseType) {
+			if !slices.Contains(req.GrantTypes, grantType) {
+				return &clientRequestError{"invalid_request", "Response type '" + responseType + "' requires the " + grantType + " grant type"}
+			}
+		}
+	}
+	if len(req.ResponseTypes) > 0 && len(req.RedirectURIs) == 0 {
+		return &clientRequestError{"invalid_request", "Missing required fields"}
+	}
+	if err := h.validateRedirectURIs(req); err != nil {
+		return err
+	}
+
+	// Resource servers must be absolute URIs (RFC 8707)
+	for _, resource := range req.Resources {
+		if err := utils.ValidateResourceIndicator(resource); err != nil {
+			return &clientRequestError{"invalid_request", err.Error()}
+		}
+	}
+
+	// Validate consent_policy override if provided
+	if req.ConsentPolicy != "" && !models.IsValidConsentPolicy(req.ConsentPolicy) {
+		return &clientRequestError{"invalid_request", "Unsupported consent policy: " + req.ConsentPolicy}
+	}
+
+	if req.ConsentTTL < 0 {
+		return &clientRequestError{"invalid_request", "consent_ttl_days must not be negative"}
+	}
+
+	if req.PromptNone != "" && !models.IsValidPromptNonePolicy(req.PromptNone) {
+		return &clientRequestError{"invalid_request", "Unsupported prompt_none_policy: " + req.PromptNone}
+	}
+	if !firstParty && req.PromptNone == models.PromptNoneAuthentication {
+		return &clientRequestError{"invalid_request", "Only first-party clients can skip consent for prompt=none"}
+	}
+
+	if req.ScopeMode != "" && !models.IsValidScopeMode(req.ScopeMode) {
+		return &clientRequestError{"invalid_request", "Unsupported scope_mode: " + req.ScopeMode}
+	}
+
+	if req.TokenExchange != "" && !models.IsValidTokenExchangePolicy(req.TokenExchange) {
+		return &clientRequestError{"invalid_request", "Unsupported token_exchange_policy: " + req.TokenExchange}
+	}
+
+	if req.TokenFormat != "" && !models.IsValidTokenFormat(req.TokenFormat) {
+		return &clientRequestError{"invalid_request", "Unsupported access_token_format: " + req.TokenFormat}
+	}
+
+	if req.AuthMethod != "" && !models.IsValidTokenEndpointAuthMethod(req.AuthMethod) {
+		return &clientRequestError{"invalid_request", "Unsupported token_endpoint_auth_method: " + req.AuthMethod}
+	}
+
+	// Certificate authentication needs something to match the certificate against
+	switch req.AuthMethod {
+	case models.AuthMethodTLSClientAuth:
+		if req.TLSSubjectDN == "" {
+			return &clientRequestError{"invalid_request", "tls_client_auth requires tls_client_auth_subject_dn"}
+		}
+	case models.AuthMethodSelfSignedTLSClientAuth:
+		if thumbprint, err := base64.RawURLEncoding.DecodeString(req.TLSThumbprint); err != nil || len(thumbprint) != sha256.Size {
+			return &clientRequestError{"invalid_request", "self_signed_tls_client_auth requires a base64url SHA-256 tls_client_certificate_thumbprint"}
+		}
+	}
+
+	if req.IsPublic && (req.AuthMethod == models.AuthMethodTLSClientAuth || req.AuthMethod == models.AuthMethodSelfSignedTLSClientAuth) {
+		return &clientRequestError{"invalid_request", "Public clients cannot authenticate with a certificate"}
+	}
+
+	if err := h.validateJWKSURI(req); err != nil {
+		return err
+	}
+
+	if err := h.validateBackchannelLogoutURI(req); err != nil {
+		return err
+	}
+
+	if err := validateConsentMessage(req); err != nil {
+		return err
+	}
+
+	if err := validateBranding(req); err != nil {
+		return err
+	}
+
+	if err := validateUserInfoResponse(req); err != nil {
+		return err
+	}
+	return validateEncryptedResponse("id_token", &req.IDTokenEncAlg, &req.IDTokenEncEnc, req.JWKS)
+}
+
+// validateJWKSURI checks the jwks_uri, which replaces jwks (RFC 7591
+// section 2), and that private_key_jwt clients have keys to verify. Production
+// mode requires https.
+func (h *ClientHandler) validateJWKSURI(req *ClientRequest) *clientRequestError {
+	if req.JWKSURI != "" {
+		if req.JWKS != nil {
+			return &clientRequestError{"invalid_request", "jwks and jwks_uri cannot both be registered"}
+		}
+		u, err := url.Parse(req.JWKSURI)
+		if err != nil || u.Host == "" || (u.Scheme != "https" && (h.config.ProductionMode || u.Scheme != "http")) {
+			return &clientRequestError{"invalid_request", "jwks_uri must be an https URL"}
+		}
+	}
+
+	if req.AuthMethod == models.AuthMethodPrivateKeyJWT {
+		if req.IsPublic {
+			return &clientRequestError{"invalid_request", "Public clients cannot authenticate with private_key_jwt"}
+		}
+		if req.JWKSURI == "" && req.JWKS.SigningKey("") == nil {
+			return &clientRequestError{"invalid_request", "private_key_jwt requires jwks_uri or an RSA signing key in jwks"}
+		}
+	}
+	return nil
+}
+
+// validateBackchannelLogoutURI checks the back-channel logout URI, which
+// must be absolute and may not have a fragment (OpenID Connect Back-Channel
+// Logout section 2.2). Production mode requires https.
+func (h *ClientHandler) validateBackchannelLogoutURI(req *ClientRequest) *clientRequestError {
+	if req.BackchannelLogoutURI == "" {
+		return nil
+	}
+	u, err := url.Parse(req.BackchannelLogoutURI)
+	if err != nil || u.Host == "" || u.Fragment != "" || (u.Scheme != "https" && (h.config.ProductionMode || u.Scheme != "http")) {
+		return &clientRequestError{"invalid_request", "backchannel_logout_uri must be an https URL without a fragment"}
+	}
+	return nil
+}
+
+// validateRedirectURIs checks the redirect URIs against the requested
+// matching mode. Production mode refuses wildcard matching and requires
+// confidential clients to use https.
+func (h *ClientHandler) validateRedirectURIs(req *ClientRequest) *clientRequestError {
+	if req.RedirectMatch != "" && !models.IsValidRedirectMatching(req.RedirectMatch) {
+		return &clientRequestError{"invalid_request", "Unsupported redirect_uri_matching: " + req.RedirectMatch}
+	}
+	if h.config.ProductionMode && req.RedirectMatch == models.RedirectMatchWildcard {
+		return &clientRequestError{"invalid_request", "wildcard_subdomain redirect URI matching is not available in production mode"}
+	}
+
+	for _, uri := range req.RedirectURIs {
+		if err := utils.ValidateRedirectURI(uri, req.RedirectMatch); err != nil {
+			return &clientRequestError{"invalid_redirect_uri", err.Error()}
+		}
+		if h.config.ProductionMode && !req.IsPublic && !strings.HasPrefix(uri, "https://") {
+			return &clientRequestError{"invalid_redirect_uri", "Confidential clients must use https redirect URIs: " + uri}
+		}
+	}
+	return nil
+}
+
+// brandColorPattern is the form accepted for brand_color; anything else
+// could break out of the style rule the pages put it in
+var brandColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// validateBranding checks the logo and color shown on the login, registration
+// and consent pages. The logo is loaded by the user's browser, so it must be
+// served over https.
+func validateBranding(req *ClientRequest) *clientRequestError {
+	if req.LogoURI != "" {
+		u, err := url.Parse(req.LogoURI)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return &clientRequestError{"invalid_request", "logo_uri must be an https URL"}
+		}
+	}
+	if req.BrandColor != "" && !brandColorPattern.MatchString(req.BrandColor) {
+		return &clientRequestError{"invalid_request", "brand_color must be a color in #rrggbb form"}
+	}
+	return nil
+}
+
+// validateUserInfoResponse checks the UserInfo signing and encryption
+// settings
+func validateUserInfoResponse(req *ClientRequest) *clientRequestError {
+	if req.UserInfoSigned != "" && !slices.Contains(userInfoSigningAlgs, req.UserInfoSigned) {
+		return &clientRequestError{"invalid_request", "Unsupported userinfo_signed_response_alg: " + req.UserInfoSigned}
+	}
+	return validateEncryptedResponse("userinfo", &req.UserInfoEncAlg, &req.UserInfoEncEnc, req.JWKS)
+}
+
+// validateEncryptedResponse checks the <kind>_encrypted_response_alg and
+// _enc pair and defaults enc. A client asking for encryption must register a
+// key to encrypt to.
+func validateEncryptedResponse(kind string, alg, enc *string, jwks *models.JSONWebKeySet) *clientRequestError {
+	algParam := kind + "_encrypted_response_alg"
+	encParam := kind + "_encrypted_response_enc"
+
+	if *alg == "" {
+		if *enc != "" {
+			return &clientRequestError{"invalid_request", encParam + " requires " + algParam}
+		}
+		return nil
 	}
+	if !slices.Contains(utils.JWEKeyAlgs, *alg) {
+		return &clientRequestError{"invalid_request", "Unsupported " + algParam + ": " + *alg}
+	}
+	if *enc == "" {
+		*enc = utils.DefaultJWEContentEnc
+	}
+	if !slices.Contains(utils.JWEContentEncs, *enc) {
+		return &clientRequestError{"invalid_request", "Unsupported " + encParam + ": " + *enc}
+	}
+
+	key := jwks.EncryptionKey(*alg)
+	if key == nil {
+		return &clientRequestError{"invalid_request", algParam + " requires an RSA encryption key in jwks"}
+	}
+	if _, err := utils.ParsePublicKeyJWK(key.N, key.E); err != nil {
+		return &clientRequestError{"invalid_request", err.Error()}
+	}
+	return nil
+}
 
+// newClient builds a client with fresh credentials from a validated request.
+// Public clients get no secret.
+func newClient(req *ClientRequest, ownerUserID string) (*models.Client, error) {
 	clientID, err := utils.GenerateRandomString(32)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, "server_error", "Failed to generate client ID")
-		return
+		return nil, err
 	}
 
 	var clientSecret string
@@ -103,49 +457,173 @@ func (h *ClientHandler) RegisterClient(w http.ResponseWriter, r *http.Request) {
 	if !req.IsPublic {
 		clientSecret, err = utils.GenerateRandomString(64)
 		if err != nil {
-			respondError(w, http.StatusInternalServerError, "server_error", "Failed to generate client secret")
-			return
+			return nil, err
 		}
 	}
 
-	client := &models.Client{
-		ClientID:      clientID,
-		ClientSecret:  clientSecret,
-		RedirectURIs:  req.RedirectURIs,
-		Name:          req.Name,
-		AllowedScopes: req.AllowedScopes,
-		GrantTypes:    req.GrantTypes,
-	}
+	return &models.Client{
+		ClientID:         clientID,
+		ClientSecret:     clientSecret,
+		OwnerUserID:      ownerUserID,
+		RedirectURIs:     req.RedirectURIs,
+		Name:             req.Name,
+		AllowedScopes:    req.AllowedScopes,
+		GrantTypes:       req.GrantTypes,
+		ResponseTypes:    req.ResponseTypes,
+		AllowedResources: req.Resources,
+		ConsentPolicy:    req.ConsentPolicy,
+		ConsentTTLDays:   req.ConsentTTL,
+		PromptNonePolicy: req.PromptNone,
+		ScopeMode:        req.ScopeMode,
+		RedirectMatching: req.RedirectMatch,
+		TokenExchange:    req.TokenExchange,
+		TokenFormat:      req.TokenFormat,
 
-	ctx := context.Background()
-	if err := h.clientRepo.Create(ctx, client); err != nil {
-		if mongo.IsDuplicateKeyError(err) {
-			respondError(w, http.StatusConflict, "client_exists", "Client already exists")
-			return
-		}
-		respondError(w, http.StatusInternalServerError, "server_error", "Failed to create client")
-		return
-	}
+		TokenEndpointAuthMethod: req.AuthMethod,
+		TLSClientAuthSubjectDN:  req.TLSSubjectDN,
+		TLSClientThumbprint:     req.TLSThumbprint,
+		CertificateBoundTokens:  req.BoundTokens,
+
+		DPoPBoundTokens: req.DPoPBound,
+
+		UserInfoSignedAlg:    req.UserInfoSigned,
+		UserInfoEncryptedAlg: req.UserInfoEncAlg,
+		UserInfoEncryptedEnc: req.UserInfoEncEnc,
+		JWKS:                 req.JWKS,
+		JWKSURI:              req.JWKSURI,
+
+		IDTokenEncryptedAlg: req.IDTokenEncAlg,
+		IDTokenEncryptedEnc: req.IDTokenEncEnc,
+
+		ConsentMessage: req.ConsentMessage,
+
+		LogoURI:    req.LogoURI,
+		BrandColor: req.BrandColor,
 
+		BackchannelLogoutURI: req.BackchannelLogoutURI,
+	}, nil
+}
+
+// clientResponse describes a client; the secret is included for confidential clients
+func clientResponse(client *models.Client, isPublic bool) map[string]interface{} {
 	response := map[string]interface{}{
 		"client_id":     client.ClientID,
 		"name":          client.Name,
 		"redirect_uris": client.RedirectURIs,
-		"is_public":     req.IsPublic,
+		"is_public":     isPublic,
 	}
-	
+
 	// Only include client_secret for confidential clients
 	if client.ClientSecret != "" {
 		response["client_secret"] = client.ClientSecret
 	}
-	
+
 	if len(client.AllowedScopes) > 0 {
 		response["allowed_scopes"] = client.AllowedScopes
 	}
-	
+
 	if len(client.GrantTypes) > 0 {
 		response["grant_types"] = client.GrantTypes
 	}
 
-	respondJSON(w, http.StatusCreated, response)
+	if len(client.ResponseTypes) > 0 {
+		response["response_types"] = client.ResponseTypes
+	}
+
+	if len(client.AllowedResources) > 0 {
+		response["allowed_resources"] = client.AllowedResources
+	}
+
+	if client.ConsentPolicy != "" {
+		response["consent_policy"] = client.ConsentPolicy
+	}
+
+	if client.ConsentTTLDays > 0 {
+		response["consent_ttl_days"] = client.ConsentTTLDays
+	}
+
+	if client.PromptNonePolicy != "" {
+		response["prompt_none_policy"] = client.PromptNonePolicy
+	}
+
+	if client.FirstParty {
+		response["first_party"] = true
+	}
+
+	if client.ScopeMode != "" {
+		response["scope_mode"] = client.ScopeMode
+	}
+
+	if client.RedirectMatching != "" {
+		response["redirect_uri_matching"] = client.RedirectMatching
+	}
+
+	if client.TokenExchange != "" {
+		response["token_exchange_policy"] = client.TokenExchange
+	}
+
+	if client.TokenFormat != "" {
+		response["access_token_format"] = client.TokenFormat
+	}
+
+	if client.TokenEndpointAuthMethod != "" {
+		response["token_endpoint_auth_method"] = client.TokenEndpointAuthMethod
+	}
+
+	if client.TLSClientAuthSubjectDN != "" {
+		response["tls_client_auth_subject_dn"] = client.TLSClientAuthSubjectDN
+	}
+
+	if client.TLSClientThumbprint != "" {
+		response["tls_client_certificate_thumbprint"] = client.TLSClientThumbprint
+	}
+
+	if client.CertificateBoundTokens {
+		response["tls_client_certificate_bound_access_tokens"] = true
+	}
+
+	if client.DPoPBoundTokens {
+		response["dpop_bound_access_tokens"] = true
+	}
+
+	if client.UserInfoSignedAlg != "" {
+		response["userinfo_signed_response_alg"] = client.UserInfoSignedAlg
+	}
+
+	if client.UserInfoEncryptedAlg != "" {
+		response["userinfo_encrypted_response_alg"] = client.UserInfoEncryptedAlg
+		response["userinfo_encrypted_response_enc"] = client.UserInfoEncryptedEnc
+	}
+
+	if client.IDTokenEncryptedAlg != "" {
+		response["id_token_encrypted_response_alg"] = client.IDTokenEncryptedAlg
+		response["id_token_encrypted_response_enc"] = client.IDTokenEncryptedEnc
+	}
+
+	if client.JWKS != nil {
+		response["jwks"] = client.JWKS
+	}
+
+	if client.JWKSURI != "" {
+		response["jwks_uri"] = client.JWKSURI
+	}
+
+	if client.ConsentMessage != "" {
+		response["consent_message"] = client.ConsentMessage
+		response["consent_message_approved"] = client.ConsentMessageApproved
+	}
+
+	if client.LogoURI != "" {
+		response["logo_uri"] = client.LogoURI
+	}
+
+	if client.BrandColor != "" {
+		response["brand_color"] = client.BrandColor
+	}
+
+	if client.BackchannelLogoutURI != "" {
+		response["backchannel_logout_uri"] = client.BackchannelLogoutURI
+	}
+
+	return response
 }
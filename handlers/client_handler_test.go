@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"oauth2-server/config"
+	"oauth2-server/models"
+	"oauth2-server/utils"
+	"slices"
+	"testing"
+)
+
+func TestValidateClientRequest_GrantAndResponseTypes(t *testing.T) {
+	registry := models.NewScopeRegistry()
+	h := NewClientHandler(nil, registry, utils.NewScopeValidator(registry), nil, &config.Config{})
+	redirectURIs := []string{"https://app.example.com/cb"}
+
+	tests := []struct {
+		name          string
+		req           ClientRequest
+		grantTypes    []string
+		responseTypes []string
+		wantErr       bool
+	}{
+		{
+			name:          "Defaults",
+			req:           ClientRequest{Name: "App", RedirectURIs: redirectURIs},
+			grantTypes:    []string{"authorization_code", "refresh_token"},
+			responseTypes: []string{"code"},
+		},
+		{
+			name:       "Client credentials only needs no redirect URI",
+			req:        ClientRequest{Name: "Service", GrantTypes: []string{"client_credentials"}},
+			grantTypes: []string{"client_credentials"},
+		},
+		{
+			name:          "Grant types derived from the response type",
+			req:           ClientRequest{Name: "App", RedirectURIs: redirectURIs, ResponseTypes: []string{"code"}},
+			grantTypes:    []string{"authorization_code", "refresh_token"},
+			responseTypes: []string{"code"},
+		},
+		{
+			name:    "Hybrid response type",
+			req:     ClientRequest{Name: "App", RedirectURIs: redirectURIs, ResponseTypes: []string{"id_token code"}},
+			wantErr: true,
+		},
+		{
+			name:    "Implicit grant type",
+			req:     ClientRequest{Name: "App", RedirectURIs: redirectURIs, GrantTypes: []string{"authorization_code", "implicit"}},
+			wantErr: true,
+		},
+		{
+			name:          "Code response type added for the code grant",
+			req:           ClientRequest{Name: "App", RedirectURIs: redirectURIs, GrantTypes: []string{"authorization_code"}},
+			grantTypes:    []string{"authorization_code"},
+			responseTypes: []string{"code"},
+		},
+		{
+			name:    "Code response type without the code grant",
+			req:     ClientRequest{Name: "App", RedirectURIs: redirectURIs, GrantTypes: []string{"client_credentials"}, ResponseTypes: []string{"code"}},
+			wantErr: true,
+		},
+		{
+			name:    "Implicit response type without the implicit grant",
+			req:     ClientRequest{Name: "App", RedirectURIs: redirectURIs, GrantTypes: []string{"authorization_code"}, ResponseTypes: []string{"id_token token"}},
+			wantErr: true,
+		},
+		{
+			name:    "Code flow without redirect URIs",
+			req:     ClientRequest{Name: "App", GrantTypes: []string{"authorization_code"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := tt.req
+			err := h.validateClientRequest(&req, false)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected an error, got grant types %v and response types %v", req.GrantTypes, req.ResponseTypes)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !slices.Equal(req.GrantTypes, tt.grantTypes) || !slices.Equal(req.ResponseTypes, tt.responseTypes) {
+				t.Errorf("Got grant types %v and response types %v, want %v and %v", req.GrantTypes, req.ResponseTypes, tt.grantTypes, tt.responseTypes)
+			}
+		})
+	}
+}
+
+func TestValidateClientRequest_FirstPartySettings(t *testing.T) {
+	registry := models.NewScopeRegistry()
+	h := NewClientHandler(nil, registry, utils.NewScopeValidator(registry), nil, &config.Config{})
+	redirectURIs := []string{"https://app.example.com/cb"}
+
+	tests := []struct {
+		name       string
+		req        ClientRequest
+		firstParty bool
+		wantCode   string
+	}{
+		{"Admin scope for a third-party client", ClientRequest{Name: "App", RedirectURIs: redirectURIs, AllowedScopes: []string{"openid", AdminScope}}, false, "invalid_scope"},
+		{"Admin scope for a first-party client", ClientRequest{Name: "App", RedirectURIs: redirectURIs, AllowedScopes: []string{"openid", AdminScope}}, true, ""},
+		{"Prompt none without consent for a third-party client", ClientRequest{Name: "App", RedirectURIs: redirectURIs, PromptNone: models.PromptNoneAuthentication}, false, "invalid_request"},
+		{"Prompt none without consent for a first-party client", ClientRequest{Name: "App", RedirectURIs: redirectURIs, PromptNone: models.PromptNoneAuthentication}, true, ""},
+		{"Prompt none with consent for a third-party client", ClientRequest{Name: "App", RedirectURIs: redirectURIs, PromptNone: models.PromptNoneConsent}, false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := tt.req
+			err := h.validateClientRequest(&req, tt.firstParty)
+			if tt.wantCode == "" {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || err.code != tt.wantCode {
+				t.Errorf("Expected a %s error, got %v", tt.wantCode, err)
+			}
+		})
+	}
+}
+
+func TestValidateClientRequest_RedirectURIs(t *testing.T) {
+	registry := models.NewScopeRegistry()
+	dev := NewClientHandler(nil, registry, utils.NewScopeValidator(registry), nil, &config.Config{})
+	production := NewClientHandler(nil, registry, utils.NewScopeValidator(registry), nil, &config.Config{ProductionMode: true})
+
+	tests := []struct {
+		name     string
+		handler  *ClientHandler
+		req      ClientRequest
+		wantCode string
+	}{
+		{"http redirect in development", dev, ClientRequest{Name: "App", RedirectURIs: []string{"http://app.test/cb"}}, ""},
+		{"Wildcard in development", dev, ClientRequest{Name: "App", RedirectURIs: []string{"https://*.dev.example.com/cb"}, RedirectMatch: models.RedirectMatchWildcard}, ""},
+		{"Wildcard without wildcard matching", dev, ClientRequest{Name: "App", RedirectURIs: []string{"https://*.dev.example.com/cb"}}, "invalid_redirect_uri"},
+		{"Fragment", dev, ClientRequest{Name: "App", RedirectURIs: []string{"https://app.example.com/cb#x"}}, "invalid_redirect_uri"},
+		{"Unknown matching mode", dev, ClientRequest{Name: "App", RedirectURIs: []string{"https://app.example.com/cb"}, RedirectMatch: "prefix"}, "invalid_request"},
+		{"Wildcard in production", production, ClientRequest{Name: "App", RedirectURIs: []string{"https://*.dev.example.com/cb"}, RedirectMatch: models.RedirectMatchWildcard}, "invalid_request"},
+		{"Confidential http redirect in production", production, ClientRequest{Name: "App", RedirectURIs: []string{"http://app.example.com/cb"}}, "invalid_redirect_uri"},
+		{"Public loopback redirect in production", production, ClientRequest{Name: "CLI", IsPublic: true, RedirectURIs: []string{"http://127.0.0.1/cb"}}, ""},
+		{"Ignore query in production", production, ClientRequest{Name: "App", RedirectURIs: []string{"https://app.example.com/cb"}, RedirectMatch: models.RedirectMatchIgnoreQuery}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := tt.req
+			err := tt.handler.validateClientRequest(&req, false)
+			if tt.wantCode == "" {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || err.code != tt.wantCode {
+				t.Errorf("Expected a %s error, got %v", tt.wantCode, err)
+			}
+		})
+	}
+}
+
+func TestValidateBranding(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     ClientRequest
+		wantErr bool
+	}{
+		{"No branding", ClientRequest{}, false},
+		{"Logo and color", ClientRequest{LogoURI: "https://acme.example.com/logo.png", BrandColor: "#FF6600"}, false},
+		{"http logo", ClientRequest{LogoURI: "http://acme.example.com/logo.png"}, true},
+		{"Relative logo", ClientRequest{LogoURI: "/logo.png"}, true},
+		{"Short color", ClientRequest{BrandColor: "#f60"}, true},
+		{"CSS injection", ClientRequest{BrandColor: "red; background: url(https://evil.example)"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateBranding(&tt.req); (err != nil) != tt.wantErr {
+				t.Errorf("validateBranding() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateBackchannelLogoutURI(t *testing.T) {
+	dev := &ClientHandler{config: &config.Config{}}
+	production := &ClientHandler{config: &config.Config{ProductionMode: true}}
+
+	tests := []struct {
+		name    string
+		handler *ClientHandler
+		uri     string
+		wantErr bool
+	}{
+		{"Not registered", production, "", false},
+		{"https", production, "https://app.example.com/logout", false},
+		{"http in development", dev, "http://localhost:3000/logout", false},
+		{"http in production", production, "http://app.example.com/logout", true},
+		{"Fragment", dev, "https://app.example.com/logout#x", true},
+		{"Relative", dev, "/logout", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.handler.validateBackchannelLogoutURI(&ClientRequest{BackchannelLogoutURI: tt.uri})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateBackchannelLogoutURI() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"oauth2-server/config"
+	"oauth2-server/jwks"
+	"oauth2-server/models"
+	"oauth2-server/repository"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultClientStatsDays = 30
+	maxClientStatsDays     = 90
+)
+
+// clientErrorEvents are the audit events counted as errors of a client
+var clientErrorEvents = []string{
+	models.AuditTokenDenied,
+	models.AuditCodeReplayed,
+	models.AuditCodeIPMismatch,
+	models.AuditConsentDenied,
+}
+
+// ClientStatsHandler serves the data behind a client's dashboard to the
+// client itself or to an admin
+type ClientStatsHandler struct {
+	userRepo    *repository.UserRepository
+	clientRepo  *repository.ClientRepository
+	consentRepo *repository.UserConsentRepository
+	auditRepo   *repository.AuditRepository
+	tokens      *TokenStore
+	clientKeys  *jwks.Cache
+	config      *config.Config
+}
+
+func NewClientStatsHandler(
+	userRepo *repository.UserRepository,
+	clientRepo *repository.ClientRepository,
+	consentRepo *repository.UserConsentRepository,
+	auditRepo *repository.AuditRepository,
+	tokens *TokenStore,
+	clientKeys *jwks.Cache,
+	cfg *config.Config,
+) *ClientStatsHandler {
+	return &ClientStatsHandler{
+		userRepo:    userRepo,
+		clientRepo:  clientRepo,
+		consentRepo: consentRepo,
+		auditRepo:   auditRepo,
+		tokens:      tokens,
+		clientKeys:  clientKeys,
+		config:      cfg,
+	}
+}
+
+// ClientDashboardStats is a client's adoption and health over the last Days
+// UTC days, today included
+type ClientDashboardStats struct {
+	ClientID          string                  `json:"client_id"`
+	ConsentedUsers    int64                   `json:"consented_users"`
+	Days              int                     `json:"days"`
+	TokensIssued      int64                   `json:"tokens_issued"`
+	TokensIssuedDaily []repository.DailyCount `json:"tokens_issued_daily"`
+	LastTokenIssuedAt *time.Time              `json:"last_token_issued_at,omitempty"`
+	Errors            map[string]int64        `json:"errors"`
+}
+
+// ClientStats returns the number of users with an active consent, tokens
+// issued per day, the last token issuance and error counts for a client,
+// aggregated from the audit log. The caller authenticates as the client, with
+// HTTP Basic or its registered authentication method, or with an admin token.
+// GET /clients/{client_id}/stats?days=30
+func (h *ClientStatsHandler) ClientStats(w http.ResponseWriter, r *http.Request) {
+	clientID := mux.Vars(r)["client_id"]
+	if !h.authorize(w, r, clientID) {
+		return
+	}
+
+	ctx := r.Context()
+	client, err := h.clientRepo.FindByClientID(ctx, clientID)
+	if err != nil {
+		respondRepositoryError(w, err, "Client", "Failed to retrieve client")
+		return
+	}
+
+	days := int(parsePositiveInt(r.URL.Query().Get("days"), defaultClientStatsDays))
+	if days > maxClientStatsDays {
+		days = maxClientStatsDays
+	}
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	since := today.AddDate(0, 0, 1-days)
+
+	stats := ClientDashboardStats{ClientID: client.ClientID, Days: days}
+	if stats.ConsentedUsers, err = h.consentRepo.CountByClientID(ctx, client.ClientID); err != nil {
+		respondInternalError(w, err, "Failed to compute statistics")
+		return
+	}
+
+	daily, err := h.auditRepo.CountEventsByDay(ctx, client.ClientID, models.AuditTokenIssued, since)
+	if err != nil {
+		respondInternalError(w, err, "Failed to compute statistics")
+		return
+	}
+	stats.TokensIssuedDaily = fillDays(daily, since, days)
+	for _, day := range stats.TokensIssuedDaily {
+		stats.TokensIssued += day.Count
+	}
+
+	last, err := h.auditRepo.LastEvent(ctx, client.ClientID, models.AuditTokenIssued)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		respondInternalError(w, err, "Failed to compute statistics")
+		return
+	}
+	if last != nil {
+		stats.LastTokenIssuedAt = &last.CreatedAt
+	}
+
+	counts, err := h.auditRepo.CountEventTypes(ctx, client.ClientID, clientErrorEvents, since)
+	if err != nil {
+		respondInternalError(w, err, "Failed to compute statistics")
+		return
+	}
+	stats.Errors = make(map[string]int64, len(clientErrorEvents))
+	for _, event := range clientErrorEvents {
+		stats.Errors[event] = counts[event]
+	}
+
+	respondJSON(w, http.StatusOK, stats)
+}
+
+// authorize lets through the client named in the path, authenticated with
+// HTTP Basic, a client certificate or a client assertion, and admins
+// presenting a bearer token. It writes the error response otherwise.
+func (h *ClientStatsHandler) authorize(w http.ResponseWriter, r *http.Request, clientID string) bool {
+	id, secret, basic := r.BasicAuth()
+	if !basic && r.Header.Get("Authorization") != "" {
+		return authorizeAdmin(w, r, h.userRepo, h.tokens, h.config)
+	}
+	if !basic && clientCertificate(r, h.config) == nil && r.FormValue("client_assertion") == "" {
+		respondError(w, http.StatusUnauthorized, "unauthorized", "Client credentials or an admin token are required")
+		return false
+	}
+	if basic && id != clientID {
+		respondError(w, http.StatusUnauthorized, "invalid_client", "Invalid client credentials")
+		return false
+	}
+
+	client, err := h.clientRepo.FindByClientID(r.Context(), clientID)
+	if err != nil || !authenticateClient(r, h.clientKeys, client, secret, h.config) {
+		respondError(w, http.StatusUnauthorized, "invalid_client", "Invalid client credentials")
+		return false
+	}
+	if client.Disabled {
+		respondClientDisabled(w, http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// fillDays returns one count for each of the days starting at since, taking
+// zero for days missing from counts
+func fillDays(counts []repository.DailyCount, since time.Time, days int) []repository.DailyCount {
+	byDate := make(map[string]int64, len(counts))
+	for _, c := range counts {
+		byDate[c.Date] = c.Count
+	}
+	filled := make([]repository.DailyCount, days)
+	for i := range filled {
+		date := since.AddDate(0, 0, i).Format("2006-01-02")
+		filled[i] = repository.DailyCount{Date: date, Count: byDate[date]}
+	}
+	return filled
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"oauth2-server/config"
+	"oauth2-server/models"
+	"oauth2-server/repository"
+	"oauth2-server/utils"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestClientStatsHandler_RequiresCredentials(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	cfg := &config.Config{PrivateKey: privateKey, PublicKey: &privateKey.PublicKey}
+	h := NewClientStatsHandler(nil, nil, nil, nil, nil, nil, cfg)
+
+	userToken, err := utils.GenerateAccessToken("user-1", "user@example.com", "User", "openid profile", privateKey, 3600)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		setAuth    func(r *http.Request)
+		wantStatus int
+		wantError  string
+	}{
+		{"no credentials", func(r *http.Request) {}, http.StatusUnauthorized, "unauthorized"},
+		{"another client", func(r *http.Request) { r.SetBasicAuth("client-2", "secret") }, http.StatusUnauthorized, "invalid_client"},
+		{"user token", func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+userToken) }, http.StatusForbidden, "insufficient_scope"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/clients/client-1/stats", nil)
+			r = mux.SetURLVars(r, map[string]string{"client_id": "client-1"})
+			tt.setAuth(r)
+			w := httptest.NewRecorder()
+			h.ClientStats(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d", tt.wantStatus, w.Code)
+			}
+			var resp models.ErrorResponse
+			json.NewDecoder(w.Body).Decode(&resp)
+			if resp.Error != tt.wantError {
+				t.Errorf("Expected error %q, got %q", tt.wantError, resp.Error)
+			}
+		})
+	}
+}
+
+func TestFillDays(t *testing.T) {
+	since := time.Date(2024, 2, 28, 0, 0, 0, 0, time.UTC)
+	counts := []repository.DailyCount{{Date: "2024-02-28", Count: 3}, {Date: "2024-03-01", Count: 5}}
+
+	got := fillDays(counts, since, 3)
+	want := []repository.DailyCount{{Date: "2024-02-28", Count: 3}, {Date: "2024-02-29", Count: 0}, {Date: "2024-03-01", Count: 5}}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d days, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Day %d: expected %+v, got %+v", i, want[i], got[i])
+		}
+	}
+}
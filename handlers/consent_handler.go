@@ -1,25 +1,24 @@
 package handlers
 
 import (
-	"context"
-	"html/template"
+	"fmt"
 	"net/http"
+	"net/url"
 	"oauth2-server/config"
-	"oauth2-server/middleware"
 	"oauth2-server/models"
 	"oauth2-server/repository"
 	"oauth2-server/utils"
 	"strings"
 	"time"
-
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type ConsentHandler struct {
 	clientRepo   *repository.ClientRepository
 	consentRepo  *repository.UserConsentRepository
 	authCodeRepo *repository.AuthCodeRepository
-	sessionRepo  *repository.SessionRepository
+	authRequests *repository.AuthorizationRequestRepository
+	templates    *TemplateRenderer
+	audit        *Auditor
 	config       *config.Config
 }
 
@@ -27,186 +26,320 @@ func NewConsentHandler(
 	clientRepo *repository.ClientRepository,
 	consentRepo *repository.UserConsentRepository,
 	authCodeRepo *repository.AuthCodeRepository,
-	sessionRepo *repository.SessionRepository,
+	authRequests *repository.AuthorizationRequestRepository,
+	templates *TemplateRenderer,
+	audit *Auditor,
 	cfg *config.Config,
 ) *ConsentHandler {
 	return &ConsentHandler{
 		clientRepo:   clientRepo,
 		consentRepo:  consentRepo,
 		authCodeRepo: authCodeRepo,
-		sessionRepo:  sessionRepo,
+		authRequests: authRequests,
+		templates:    templates,
+		audit:        audit,
 		config:       cfg,
 	}
 }
 
-// ShowConsent renders the consent screen with client info and scope descriptions
+// ShowConsent renders the consent screen for the authorization request a
+// consent challenge names, with client info and scope descriptions
+// GET /oauth/consent?consent_challenge=...
 func (h *ConsentHandler) ShowConsent(w http.ResponseWriter, r *http.Request) {
-	// Extract parameters from query string
-	clientID := r.URL.Query().Get("client_id")
-	scope := r.URL.Query().Get("scope")
-	state := r.URL.Query().Get("state")
-	redirectURI := r.URL.Query().Get("redirect_uri")
-	responseType := r.URL.Query().Get("response_type")
-	codeChallenge := r.URL.Query().Get("code_challenge")
-	codeChallengeMethod := r.URL.Query().Get("code_challenge_method")
-	nonce := r.URL.Query().Get("nonce")
-
-	if clientID == "" || redirectURI == "" {
-		respondError(w, http.StatusBadRequest, "invalid_request", "Missing required parameters")
+	request, _, client, ok := h.pendingConsent(w, r, r.URL.Query().Get("consent_challenge"))
+	if !ok {
 		return
 	}
 
-	ctx := context.Background()
+	ctx := r.Context()
 
-	// Fetch client information
-	client, err := h.clientRepo.FindByClientID(ctx, clientID)
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			respondError(w, http.StatusBadRequest, "invalid_client", "Client not found")
-			return
+	// Parse scopes
+	scopes := strings.Split(request.Scope, " ")
+
+	// Prepare template data; the request itself stays on the server and the
+	// form only carries its challenge
+	data := map[string]interface{}{
+		"ClientName":       client.Name,
+		"ConsentChallenge": request.Challenge,
+		"ConsentMessage":   consentMessage(client),
+		"CSRFToken":        csrfToken(w, r),
+		"LogoURI":          client.LogoURI,
+		"BrandColor":       client.BrandColor,
+	}
+
+	// Scopes shown on the page; every scope starts checked
+	displayScopes := scopes
+	var checked []bool
+
+	// Offer to allow just this once unless the client never remembers consent
+	policy := resolveConsentPolicy(client, h.config)
+	data["AllowOnce"] = policy != models.ConsentPolicySession
+
+	if policy != models.ConsentPolicySession {
+		status, consent, err := h.consentRepo.CheckConsent(ctx, request.UserID, client.ClientID, scopes)
+		if err == nil {
+			if status == repository.ConsentGranted && consentNeedsRenewal(consent, h.config, time.Now()) {
+				status = repository.ConsentExpired
+			}
+
+			switch status {
+			case repository.ConsentExpired:
+				// A consent that lapsed or is about to gets a streamlined
+				// renewal page with the previously granted scopes already checked
+				data["Renewal"] = true
+				data["PreviousGrantedAt"] = consent.GrantedAt.Format("2 Jan 2006")
+				checked = previouslyGranted(scopes, consent.Scopes)
+			case repository.ConsentInsufficient:
+				// Incremental authorization: only ask about the new scopes
+				// and those whose time-limited grant has expired
+				activeScopes := consent.ActiveScopes(time.Now())
+				displayScopes = newlyRequestedScopes(scopes, activeScopes)
+				data["Incremental"] = true
+				data["AlreadyGranted"] = strings.Join(activeScopes, " ")
+			}
 		}
-		respondError(w, http.StatusInternalServerError, "server_error", "Failed to fetch client")
-		return
 	}
 
-	// Parse scopes
-	scopes := strings.Split(scope, " ")
-	scopeDescriptions := make([]string, len(scopes))
+	scopeDescriptions := make([]string, len(displayScopes))
+	scopeRequired := make([]bool, len(displayScopes))
+	scopeSensitive := make([]bool, len(displayScopes))
+	if checked == nil {
+		checked = make([]bool, len(displayScopes))
+		for i := range checked {
+			checked[i] = true
+		}
+	}
 
-	// Get scope descriptions from registry
-	for i, scopeName := range scopes {
+	// Get scope descriptions from registry; required scopes cannot be unchecked
+	for i, scopeName := range displayScopes {
 		if scopeDef, exists := utils.GlobalScopeRegistry.GetScope(scopeName); exists {
 			scopeDescriptions[i] = scopeDef.Description
+			scopeSensitive[i] = scopeDef.Sensitive
 		} else {
 			scopeDescriptions[i] = "Access to " + scopeName
 		}
+		scopeRequired[i] = isRequiredConsentScope(scopeName)
 	}
 
-	// Prepare template data
-	data := map[string]interface{}{
-		"ClientName":            client.Name,
-		"ClientID":              clientID,
-		"Scopes":                scopes,
-		"ScopeDescriptions":     scopeDescriptions,
-		"ScopeString":           scope,
-		"State":                 state,
-		"RedirectURI":           redirectURI,
-		"ResponseType":          responseType,
-		"CodeChallenge":         codeChallenge,
-		"CodeChallengeMethod":   codeChallengeMethod,
-		"Nonce":                 nonce,
-	}
+	data["Scopes"] = displayScopes
+	data["ScopeDescriptions"] = scopeDescriptions
+	data["ScopeRequired"] = scopeRequired
+	data["ScopeSensitive"] = scopeSensitive
+	data["ScopeChecked"] = checked
 
 	// Render consent template
-	tmpl, err := template.ParseFiles("templates/consent.html")
-	if err != nil {
-		respondError(w, http.StatusInternalServerError, "server_error", "Failed to load template")
-		return
-	}
-
-	tmpl.Execute(w, data)
+	h.templates.Render(w, "consent.html", data)
 }
 
-// HandleConsent processes the consent form submission
+// HandleConsent processes the consent form submission. The client, scope,
+// redirect URI and PKCE parameters come from the stored authorization
+// request, never from the form.
+// POST /oauth/consent
 func (h *ConsentHandler) HandleConsent(w http.ResponseWriter, r *http.Request) {
 	// Parse form data
 	if err := r.ParseForm(); err != nil {
 		respondError(w, http.StatusBadRequest, "invalid_request", "Failed to parse form")
 		return
 	}
-
-	action := r.FormValue("action")
-	clientID := r.FormValue("client_id")
-	scope := r.FormValue("scope")
-	state := r.FormValue("state")
-	redirectURI := r.FormValue("redirect_uri")
-	codeChallenge := r.FormValue("code_challenge")
-	codeChallengeMethod := r.FormValue("code_challenge_method")
-	nonce := r.FormValue("nonce")
-
-	if clientID == "" || redirectURI == "" {
-		respondError(w, http.StatusBadRequest, "invalid_request", "Missing required parameters")
+	if !requireCSRF(w, r, h.config) {
 		return
 	}
 
-	// Get SSO session from context
-	ssoSession, ok := r.Context().Value(middleware.SSOSessionContextKey).(*models.SSOSession)
-	if !ok || ssoSession == nil || !ssoSession.Authenticated {
-		// No valid SSO session - redirect to login
-		respondError(w, http.StatusUnauthorized, "unauthorized", "Authentication required")
+	request, ssoSession, client, ok := h.pendingConsent(w, r, r.FormValue("consent_challenge"))
+	if !ok {
 		return
 	}
-
-	ctx := context.Background()
+	action := r.FormValue("action")
 
 	// Handle denial
 	if action == "deny" {
-		// Build error redirect URL
-		errorURL := redirectURI + "?error=access_denied&error_description=User+denied+consent"
-		if state != "" {
-			errorURL += "&state=" + state
-		}
-		http.Redirect(w, r, errorURL, http.StatusFound)
+		h.authRequests.Delete(r.Context(), request.Challenge)
+		h.audit.record(r, models.AuditConsentDenied, ssoSession.UserID, client.ClientID, map[string]string{"scope": request.Scope})
+		redirectToClient(w, r, request.RedirectURI, authorizationError(w, "access_denied", "User denied consent", request.State))
 		return
 	}
 
-	// Handle approval
-	if action == "allow" {
-		// Parse scopes
-		scopes := strings.Split(scope, " ")
-
-		// Save UserConsent record with 1-year expiration
-		consent := &models.UserConsent{
-			UserID:    ssoSession.UserID,
-			ClientID:  clientID,
-			Scopes:    scopes,
-			GrantedAt: time.Now(),
-			ExpiresAt: time.Now().Add(365 * 24 * time.Hour), // 1 year
-		}
-
-		// Create or update consent
-		if err := h.consentRepo.Create(ctx, consent); err != nil {
-			// If consent already exists, it's fine - the unique index will prevent duplicates
-			// We can continue with authorization code generation
-			if !mongo.IsDuplicateKeyError(err) {
-				respondError(w, http.StatusInternalServerError, "server_error", "Failed to save consent")
-				return
+	// Handle approval. "allow_once" grants access for this authorization only,
+	// e.g. on a shared machine: no consent is stored, so the next
+	// authorization for the client asks again.
+	if action == "allow" || action == "allow_once" {
+		var selected []string
+		if r.FormValue("scope_selection") != "" {
+			selected = r.Form["granted_scope"]
+			if selected == nil {
+				selected = []string{}
 			}
 		}
 
-		// Generate authorization code
-		code, err := utils.GenerateRandomString(16)
+		params, err := h.approveConsent(r, client, ssoSession, request, selected, action == "allow_once")
 		if err != nil {
-			respondError(w, http.StatusInternalServerError, "server_error", "Failed to generate authorization code")
+			respondInternalError(w, err, "Failed to approve consent")
 			return
 		}
+		h.authRequests.Delete(r.Context(), request.Challenge)
+		redirectToClient(w, r, request.RedirectURI, params)
+		return
+	}
+
+	// Invalid action
+	respondError(w, http.StatusBadRequest, "invalid_request", "Invalid action")
+}
+
+// approveConsent grants the client the scopes the user selected for the
+// stored authorization request and issues the authorization code, returning
+// the parameters for the client's redirect URI. A nil selection grants every
+// requested scope. With once, e.g. on a shared machine, no consent is stored
+// and the next authorization asks again.
+func (h *ConsentHandler) approveConsent(r *http.Request, client *models.Client, ssoSession *models.SSOSession, req *models.AuthorizationRequest, selected []string, once bool) (url.Values, error) {
+	ctx := r.Context()
+	scope := req.Scope
+	scopes := strings.Split(scope, " ")
+
+	// Per-session policy grants access for this authorization only,
+	// nothing is persisted so the next request prompts again
+	policy := resolveConsentPolicy(client, h.config)
+	remember := policy != models.ConsentPolicySession && !once
+
+	// Scopes from a still valid consent were not shown on an incremental
+	// consent page and stay granted
+	now := time.Now()
+	var existing *models.UserConsent
+	var existingScopes []string
+	if policy != models.ConsentPolicySession {
+		status, consent, err := h.consentRepo.CheckConsent(ctx, ssoSession.UserID, client.ClientID, scopes)
+		if err == nil && (status == repository.ConsentGranted || status == repository.ConsentInsufficient) {
+			existing = consent
+			existingScopes = consent.ActiveScopes(now)
+		}
+	}
 
-		authCode := &models.AuthorizationCode{
-			Code:            code,
-			ClientID:        clientID,
-			UserID:          ssoSession.UserID,
-			RedirectURI:     redirectURI,
-			Scope:           scope,
-			Nonce:           nonce,
-			CodeChallenge:   codeChallenge,
-			ChallengeMethod: codeChallengeMethod,
-			ExpiresAt:       time.Now().Add(10 * time.Minute),
+	// Only the scopes the user left checked are granted
+	if selected != nil {
+		scopes = selectGrantedScopes(scopes, append(append([]string{}, selected...), existingScopes...))
+		scope = strings.Join(scopes, " ")
+	}
+
+	if remember {
+		grantedScopes := mergeScopes(existingScopes, scopes)
+		consent := &models.UserConsent{
+			UserID:         ssoSession.UserID,
+			ClientID:       client.ClientID,
+			Scopes:         grantedScopes,
+			GrantedAt:      now,
+			ExpiresAt:      consentExpiry(policy, consentTTL(client, h.config, scopes), now),
+			ScopeExpiresAt: scopeGrantExpiries(existing, grantedScopes, h.config, now),
 		}
 
-		if err := h.authCodeRepo.Create(ctx, authCode); err != nil {
-			respondError(w, http.StatusInternalServerError, "server_error", "Failed to create authorization code")
-			return
+		// Create, renew or extend the consent
+		if err := h.consentRepo.Save(ctx, consent, accountSessionRef(ssoSession.SessionID)); err != nil {
+			return nil, fmt.Errorf("saving consent: %w", err)
+		}
+	}
+
+	details := map[string]string{"scope": scope}
+	if !remember {
+		details["once"] = "true"
+	}
+	h.audit.record(r, models.AuditConsentGranted, ssoSession.UserID, client.ClientID, details)
+
+	code, err := utils.GenerateRandomString(16)
+	if err != nil {
+		return nil, fmt.Errorf("generating authorization code: %w", err)
+	}
+
+	authCode := &models.AuthorizationCode{
+		Code:            code,
+		ClientID:        client.ClientID,
+		UserID:          ssoSession.UserID,
+		RedirectURI:     req.RedirectURI,
+		Scope:           scope,
+		Nonce:           req.Nonce,
+		CodeChallenge:   req.CodeChallenge,
+		ChallengeMethod: req.ChallengeMethod,
+		Resources:       req.Resources,
+		ExpiresAt:       now.Add(10 * time.Minute),
+
+		RequestedAt:      now,
+		RequestIP:        clientIP(r),
+		RequestUserAgent: r.UserAgent(),
+		Fingerprint:      requestFingerprint(r),
+		SSOSessionID:     ssoSession.SessionID,
+
+		AuthTime: ssoSession.CreatedAt,
+		ACR:      sessionACR(ssoSession),
+	}
+
+	if err := h.authCodeRepo.Create(ctx, authCode); err != nil {
+		return nil, fmt.Errorf("creating authorization code: %w", err)
+	}
+
+	params := url.Values{"code": {code}}
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	return params, nil
+}
+
+// previouslyGranted marks, for each requested scope, whether it was part of the stored consent
+func previouslyGranted(requested, granted []string) []bool {
+	grantedSet := make(map[string]bool, len(granted))
+	for _, scope := range granted {
+		grantedSet[scope] = true
+	}
+
+	marks := make([]bool, len(requested))
+	for i, scope := range requested {
+		marks[i] = grantedSet[scope]
+	}
+	return marks
+}
+
+// isRequiredConsentScope reports whether a scope must be granted for the
+// request to proceed; openid is what makes this an OIDC authorization
+func isRequiredConsentScope(scope string) bool {
+	return scope == "openid"
+}
+
+// selectGrantedScopes returns the requested scopes the user selected on the
+// consent screen, in request order. Required scopes are always granted and
+// selections that were never requested are ignored.
+func selectGrantedScopes(requested, selected []string) []string {
+	selectedSet := make(map[string]bool, len(selected))
+	for _, scope := range selected {
+		selectedSet[scope] = true
+	}
+
+	granted := make([]string, 0, len(requested))
+	for _, scope := range requested {
+		if isRequiredConsentScope(scope) || selectedSet[scope] {
+			granted = append(granted, scope)
 		}
+	}
+	return granted
+}
 
-		// Redirect with authorization code
-		redirectURL := redirectURI + "?code=" + code
-		if state != "" {
-			redirectURL += "&state=" + state
+// newlyRequestedScopes returns the requested scopes not covered by the stored consent
+func newlyRequestedScopes(requested, granted []string) []string {
+	marks := previouslyGranted(requested, granted)
+
+	newScopes := make([]string, 0, len(requested))
+	for i, scope := range requested {
+		if !marks[i] {
+			newScopes = append(newScopes, scope)
 		}
-		http.Redirect(w, r, redirectURL, http.StatusFound)
-		return
 	}
+	return newScopes
+}
 
-	// Invalid action
-	respondError(w, http.StatusBadRequest, "invalid_request", "Invalid action")
+// mergeScopes returns the existing scopes followed by any added scopes not already present
+func mergeScopes(existing, added []string) []string {
+	seen := make(map[string]bool, len(existing)+len(added))
+	merged := make([]string, 0, len(existing)+len(added))
+	for _, scope := range append(append([]string{}, existing...), added...) {
+		if !seen[scope] {
+			seen[scope] = true
+			merged = append(merged, scope)
+		}
+	}
+	return merged
 }
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPreviouslyGranted(t *testing.T) {
+	marks := previouslyGranted([]string{"openid", "email", "profile"}, []string{"profile", "openid"})
+	want := []bool{true, false, true}
+
+	for i := range want {
+		if marks[i] != want[i] {
+			t.Errorf("Expected mark %d to be %v, got %v", i, want[i], marks[i])
+		}
+	}
+}
+
+func TestConsentTemplate_Renewal(t *testing.T) {
+	renderer := NewTemplateRenderer(false, "", "")
+
+	w := httptest.NewRecorder()
+	renderer.Render(w, "consent.html", map[string]interface{}{
+		"ClientName":        "Test App",
+		"Scopes":            []string{"openid", "profile"},
+		"ScopeDescriptions": []string{"", ""},
+		"ScopeRequired":     []bool{true, false},
+		"ScopeChecked":      []bool{true, true},
+		"Renewal":           true,
+	})
+
+	body := w.Body.String()
+	if !strings.Contains(body, "Renew access") {
+		t.Error("Expected renewal page to offer renewing access")
+	}
+	if !strings.Contains(body, `name="granted_scope" value="profile" form="consentForm" checked`) {
+		t.Error("Expected previously granted scopes to be pre-checked")
+	}
+}
+
+func TestConsentTemplate_SensitiveScope(t *testing.T) {
+	renderer := NewTemplateRenderer(false, "", "")
+
+	w := httptest.NewRecorder()
+	renderer.Render(w, "consent.html", map[string]interface{}{
+		"ClientName":        "Test App",
+		"Scopes":            []string{"openid", "orders:write"},
+		"ScopeDescriptions": []string{"", "Place orders"},
+		"ScopeRequired":     []bool{true, false},
+		"ScopeChecked":      []bool{true, true},
+		"ScopeSensitive":    []bool{false, true},
+	})
+
+	body := w.Body.String()
+	if strings.Count(body, `<span class="scope-sensitive">`) != 1 {
+		t.Error("Expected only the sensitive scope to be marked")
+	}
+}
+
+func TestConsentTemplate_AllowOnce(t *testing.T) {
+	renderer := NewTemplateRenderer(false, "", "")
+	data := map[string]interface{}{
+		"ClientName":        "Test App",
+		"Scopes":            []string{"openid"},
+		"ScopeDescriptions": []string{""},
+		"ScopeRequired":     []bool{true},
+		"ScopeChecked":      []bool{true},
+		"AllowOnce":         true,
+	}
+
+	w := httptest.NewRecorder()
+	renderer.Render(w, "consent.html", data)
+	if !strings.Contains(w.Body.String(), `value="allow_once"`) {
+		t.Error("Expected the page to offer allowing once")
+	}
+
+	// Clients with the per-session policy never remember consent anyway
+	data["AllowOnce"] = false
+	w = httptest.NewRecorder()
+	renderer.Render(w, "consent.html", data)
+	if strings.Contains(w.Body.String(), `value="allow_once"`) {
+		t.Error("Expected no allow once option when consent is never remembered")
+	}
+}
+
+func TestSelectGrantedScopes(t *testing.T) {
+	tests := []struct {
+		name      string
+		requested []string
+		selected  []string
+		want      string
+	}{
+		{"all selected", []string{"openid", "profile", "email"}, []string{"openid", "profile", "email"}, "openid profile email"},
+		{"optional unchecked", []string{"openid", "profile", "email"}, []string{"openid", "email"}, "openid email"},
+		{"required always granted", []string{"openid", "profile"}, nil, "openid"},
+		{"unrequested selection ignored", []string{"openid"}, []string{"openid", "phone"}, "openid"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := strings.Join(selectGrantedScopes(tt.requested, tt.selected), " ")
+			if got != tt.want {
+				t.Errorf("selectGrantedScopes() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIncrementalConsentScopes(t *testing.T) {
+	granted := []string{"openid", "profile"}
+
+	newScopes := newlyRequestedScopes([]string{"openid", "profile", "email", "phone"}, granted)
+	if got := strings.Join(newScopes, " "); got != "email phone" {
+		t.Errorf("Expected only new scopes to be shown, got %q", got)
+	}
+
+	merged := mergeScopes(granted, []string{"openid", "email"})
+	if got := strings.Join(merged, " "); got != "openid profile email" {
+		t.Errorf("Expected merged consent scopes, got %q", got)
+	}
+}
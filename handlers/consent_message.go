@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"net/http"
+	"oauth2-server/models"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+// maxConsentMessageLength is the longest consent message, in characters,
+// a client may register
+const maxConsentMessageLength = 300
+
+var htmlTagPattern = regexp.MustCompile(`<[^>]*>`)
+
+// sanitizeConsentMessage reduces a client's consent message to plain text
+// on a single line: markup, control and invisible formatting characters
+// (such as bidi overrides) are removed and whitespace is collapsed
+func sanitizeConsentMessage(message string) string {
+	message = htmlTagPattern.ReplaceAllString(message, " ")
+	message = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return ' '
+		}
+		if unicode.IsControl(r) || unicode.Is(unicode.Cf, r) {
+			return -1
+		}
+		return r
+	}, message)
+	return strings.Join(strings.Fields(message), " ")
+}
+
+// validateConsentMessage sanitizes the requested consent message in place
+func validateConsentMessage(req *ClientRequest) *clientRequestError {
+	req.ConsentMessage = sanitizeConsentMessage(req.ConsentMessage)
+	if utf8.RuneCountInString(req.ConsentMessage) > maxConsentMessageLength {
+		return &clientRequestError{"invalid_request", "consent_message must be at most 300 characters"}
+	}
+	return nil
+}
+
+// consentMessage is the text shown to users on the consent page, present
+// only once an administrator has approved it
+func consentMessage(client *models.Client) string {
+	if !client.ConsentMessageApproved {
+		return ""
+	}
+	return client.ConsentMessage
+}
+
+// ListPendingConsentMessages returns the clients whose consent message
+// waits for review
+// GET /admin/consent-messages
+func (h *AdminHandler) ListPendingConsentMessages(w http.ResponseWriter, r *http.Request) {
+	clients, err := h.clientRepo.FindPendingConsentMessages(r.Context())
+	if err != nil {
+		respondInternalError(w, err, "Failed to retrieve clients")
+		return
+	}
+
+	pending := make([]map[string]interface{}, 0, len(clients))
+	for _, client := range clients {
+		pending = append(pending, map[string]interface{}{
+			"client_id":       client.ClientID,
+			"client_name":     client.Name,
+			"owner_user_id":   client.OwnerUserID,
+			"consent_message": client.ConsentMessage,
+		})
+	}
+	respondJSON(w, http.StatusOK, map[string]interface{}{"clients": pending})
+}
+
+// ApproveConsentMessage shows the client's consent message on the consent
+// page. The reviewed text must be sent back, so a message changed since it
+// was read is not approved by mistake.
+// POST /admin/clients/{client_id}/consent-message/approve
+func (h *AdminHandler) ApproveConsentMessage(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_request", "Failed to parse form")
+		return
+	}
+	message := r.FormValue("consent_message")
+	if message == "" {
+		respondError(w, http.StatusBadRequest, "invalid_request", "consent_message is required")
+		return
+	}
+
+	ctx := r.Context()
+	client, err := h.clientRepo.FindByClientID(ctx, mux.Vars(r)["client_id"])
+	if err != nil {
+		respondRepositoryError(w, err, "Client", "Failed to retrieve client")
+		return
+	}
+	if err := h.clientRepo.ApproveConsentMessage(ctx, client.ClientID, message); err != nil {
+		respondRepositoryError(w, err, "Client", "Failed to approve consent message")
+		return
+	}
+
+	adminID, _, _ := parseBearerToken(r, h.tokens, h.config)
+	h.audit.record(r, models.AuditConsentMessageApproved, "", client.ClientID, map[string]string{"approved_by": adminID})
+	respondJSON(w, http.StatusOK, map[string]interface{}{"client_id": client.ClientID, "consent_message_approved": true})
+}
+
+// RejectConsentMessage removes the client's consent message; the owner may
+// submit a new one
+// DELETE /admin/clients/{client_id}/consent-message
+func (h *AdminHandler) RejectConsentMessage(w http.ResponseWriter, r *http.Request) {
+	clientID := mux.Vars(r)["client_id"]
+	if err := h.clientRepo.ClearConsentMessage(r.Context(), clientID); err != nil {
+		respondRepositoryError(w, err, "Client", "Failed to reject consent message")
+		return
+	}
+
+	adminID, _, _ := parseBearerToken(r, h.tokens, h.config)
+	h.audit.record(r, models.AuditConsentMessageRejected, "", clientID, map[string]string{"rejected_by": adminID})
+	w.WriteHeader(http.StatusNoContent)
+}
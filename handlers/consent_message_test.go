@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"oauth2-server/models"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeConsentMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    string
+	}{
+		{"Plain text", "We sync your calendar.", "We sync your calendar."},
+		{"Markup removed", `We <b>sync</b> your <a href="https://evil.example">calendar</a> daily`, "We sync your calendar daily"},
+		{"Whitespace collapsed", "  We sync\n\tyour   calendar. ", "We sync your calendar."},
+		{"Control and bidi characters removed", "We sync\x00 your \u202ecalendar.", "We sync your calendar."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeConsentMessage(tt.message); got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestConsentMessage_RequiresApproval(t *testing.T) {
+	client := &models.Client{ConsentMessage: "We sync your calendar."}
+	if consentMessage(client) != "" {
+		t.Error("Expected an unapproved message to be hidden")
+	}
+
+	client.ConsentMessageApproved = true
+	if consentMessage(client) != "We sync your calendar." {
+		t.Error("Expected an approved message to be shown")
+	}
+}
+
+func TestConsentTemplate_ConsentMessage(t *testing.T) {
+	renderer := NewTemplateRenderer(false, "", "")
+
+	w := httptest.NewRecorder()
+	renderer.Render(w, "consent.html", map[string]interface{}{
+		"ClientName":        "Test App",
+		"Scopes":            []string{"openid"},
+		"ScopeDescriptions": []string{""},
+		"ScopeRequired":     []bool{true},
+		"ScopeChecked":      []bool{true},
+		"ConsentMessage":    "We sync your <calendar>.",
+	})
+
+	body := w.Body.String()
+	if !strings.Contains(body, "We sync your &lt;calendar&gt;.") {
+		t.Error("Expected the consent message to be shown escaped")
+	}
+	if strings.Index(body, `class="client-message"`) < strings.Index(body, `class="scope-list"`) {
+		t.Error("Expected the consent message beneath the scope list")
+	}
+}
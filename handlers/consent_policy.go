@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"oauth2-server/config"
+	"oauth2-server/models"
+	"time"
+)
+
+// defaultConsentTTL is used when no consent lifetime is configured
+const defaultConsentTTL = 365 * 24 * time.Hour
+
+// resolveConsentPolicy returns the consent persistence policy for a client:
+// the client's own override when set, otherwise the global default
+func resolveConsentPolicy(client *models.Client, cfg *config.Config) string {
+	if client != nil && models.IsValidConsentPolicy(client.ConsentPolicy) {
+		return client.ConsentPolicy
+	}
+	if models.IsValidConsentPolicy(cfg.ConsentPolicy) {
+		return cfg.ConsentPolicy
+	}
+	return models.ConsentPolicyTTL
+}
+
+// promptNoneRequiresConsent reports whether prompt=none needs a stored consent
+// for the client, or only an authenticated SSO session. Only first-party
+// clients may skip consent; the policy is ignored on any other client.
+func promptNoneRequiresConsent(client *models.Client) bool {
+	return client == nil || !client.FirstParty || client.PromptNonePolicy != models.PromptNoneAuthentication
+}
+
+// consentTTL returns how long a consent for the given scopes stays valid under
+// the TTL policy: the client's override or the global default, shortened by
+// any per-scope lifetime configured for the granted scopes
+func consentTTL(client *models.Client, cfg *config.Config, scopes []string) time.Duration {
+	ttl := defaultConsentTTL
+	if cfg.ConsentTTLDays > 0 {
+		ttl = days(cfg.ConsentTTLDays)
+	}
+	if client != nil && client.ConsentTTLDays > 0 {
+		ttl = days(client.ConsentTTLDays)
+	}
+
+	for _, scope := range scopes {
+		if scopeDays, ok := cfg.ScopeConsentTTLDays[scope]; ok && scopeDays > 0 && days(scopeDays) < ttl {
+			ttl = days(scopeDays)
+		}
+	}
+	return ttl
+}
+
+// consentExpiry returns the expiration for a newly stored consent under the
+// given policy. A zero time means the consent never expires.
+func consentExpiry(policy string, ttl time.Duration, now time.Time) time.Time {
+	if policy == models.ConsentPolicyPermanent {
+		return time.Time{}
+	}
+	return now.Add(ttl)
+}
+
+// consentNeedsRenewal reports whether a stored consent is close enough to
+// expiring that the user should be asked to renew it instead of auto-approving
+func consentNeedsRenewal(consent *models.UserConsent, cfg *config.Config, now time.Time) bool {
+	if consent == nil || consent.ExpiresAt.IsZero() || cfg.ConsentRenewalWindowDays <= 0 {
+		return false
+	}
+	return consent.ExpiresAt.Sub(now) < days(cfg.ConsentRenewalWindowDays)
+}
+
+// scopeGrantExpiries returns the per-scope expiries for a consent covering
+// the given scopes. Scopes that were still active in the existing consent keep
+// their expiry; newly granted scopes with a configured grant lifetime start a
+// fresh one. Returns nil when no scope is time-limited.
+func scopeGrantExpiries(existing *models.UserConsent, scopes []string, cfg *config.Config, now time.Time) map[string]time.Time {
+	expiries := make(map[string]time.Time)
+	for _, scope := range scopes {
+		if existing != nil && !existing.ScopeExpired(scope, now) {
+			if expiresAt, ok := existing.ScopeExpiresAt[scope]; ok {
+				expiries[scope] = expiresAt
+				continue
+			}
+		}
+		if minutes := cfg.ScopeGrantTTLMinutes[scope]; minutes > 0 {
+			expiries[scope] = now.Add(time.Duration(minutes) * time.Minute)
+		}
+	}
+
+	if len(expiries) == 0 {
+		return nil
+	}
+	return expiries
+}
+
+func days(n int64) time.Duration {
+	return time.Duration(n) * 24 * time.Hour
+}
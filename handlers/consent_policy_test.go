@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"oauth2-server/config"
+	"oauth2-server/models"
+	"testing"
+	"time"
+)
+
+func TestResolveConsentPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		global   string
+		client   string
+		expected string
+	}{
+		{"Defaults to ttl", "", "", models.ConsentPolicyTTL},
+		{"Uses global policy", models.ConsentPolicyPermanent, "", models.ConsentPolicyPermanent},
+		{"Client overrides global", models.ConsentPolicyTTL, models.ConsentPolicySession, models.ConsentPolicySession},
+		{"Ignores unknown client policy", models.ConsentPolicySession, "bogus", models.ConsentPolicySession},
+		{"Ignores unknown global policy", "bogus", "", models.ConsentPolicyTTL},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{ConsentPolicy: tt.global}
+			client := &models.Client{ConsentPolicy: tt.client}
+			if got := resolveConsentPolicy(client, cfg); got != tt.expected {
+				t.Errorf("Expected policy %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestPromptNoneRequiresConsent(t *testing.T) {
+	tests := []struct {
+		name     string
+		client   *models.Client
+		expected bool
+	}{
+		{"No client", nil, true},
+		{"Default policy", &models.Client{}, true},
+		{"Consent policy", &models.Client{PromptNonePolicy: models.PromptNoneConsent}, true},
+		{"Authentication policy", &models.Client{PromptNonePolicy: models.PromptNoneAuthentication, FirstParty: true}, false},
+		{"Authentication policy on a third-party client", &models.Client{PromptNonePolicy: models.PromptNoneAuthentication}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := promptNoneRequiresConsent(tt.client); got != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestConsentExpiry(t *testing.T) {
+	now := time.Now()
+	ttl := 30 * 24 * time.Hour
+
+	if expiry := consentExpiry(models.ConsentPolicyPermanent, ttl, now); !expiry.IsZero() {
+		t.Errorf("Expected permanent consent to have no expiry, got %v", expiry)
+	}
+
+	if expiry := consentExpiry(models.ConsentPolicyTTL, ttl, now); !expiry.Equal(now.Add(ttl)) {
+		t.Errorf("Expected ttl consent to expire at %v, got %v", now.Add(ttl), expiry)
+	}
+}
+
+func TestConsentTTL(t *testing.T) {
+	cfg := &config.Config{
+		ConsentTTLDays:      365,
+		ScopeConsentTTLDays: map[string]int64{"phone": 30},
+	}
+
+	tests := []struct {
+		name     string
+		client   *models.Client
+		scopes   []string
+		expected int64
+	}{
+		{"Global default", &models.Client{}, []string{"openid", "profile"}, 365},
+		{"Client override", &models.Client{ConsentTTLDays: 90}, []string{"openid"}, 90},
+		{"Sensitive scope shortens", &models.Client{ConsentTTLDays: 90}, []string{"openid", "phone"}, 30},
+		{"Scope override never lengthens", &models.Client{ConsentTTLDays: 7}, []string{"phone"}, 7},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := consentTTL(tt.client, cfg, tt.scopes); got != days(tt.expected) {
+				t.Errorf("Expected ttl %v, got %v", days(tt.expected), got)
+			}
+		})
+	}
+
+	if got := consentTTL(nil, &config.Config{}, nil); got != defaultConsentTTL {
+		t.Errorf("Expected fallback ttl %v, got %v", defaultConsentTTL, got)
+	}
+}
+
+func TestConsentNeedsRenewal(t *testing.T) {
+	cfg := &config.Config{ConsentRenewalWindowDays: 7}
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		expiresAt time.Time
+		expected  bool
+	}{
+		{"Never expires", time.Time{}, false},
+		{"Far from expiry", now.Add(days(30)), false},
+		{"Within renewal window", now.Add(days(3)), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			consent := &models.UserConsent{ExpiresAt: tt.expiresAt}
+			if got := consentNeedsRenewal(consent, cfg, now); got != tt.expected {
+				t.Errorf("Expected renewal %v, got %v", tt.expected, got)
+			}
+		})
+	}
+
+	if consentNeedsRenewal(&models.UserConsent{ExpiresAt: now.Add(days(3))}, &config.Config{}, now) {
+		t.Error("Expected renewal window of 0 to disable re-prompting")
+	}
+}
+
+func TestScopeGrantExpiries(t *testing.T) {
+	cfg := &config.Config{ScopeGrantTTLMinutes: map[string]int64{"payment": 15}}
+	now := time.Now()
+
+	if got := scopeGrantExpiries(nil, []string{"openid", "profile"}, cfg, now); got != nil {
+		t.Errorf("Expected no expiries without time-limited scopes, got %v", got)
+	}
+
+	got := scopeGrantExpiries(nil, []string{"openid", "payment"}, cfg, now)
+	if !got["payment"].Equal(now.Add(15 * time.Minute)) {
+		t.Errorf("Expected payment grant to expire in 15 minutes, got %v", got["payment"])
+	}
+	if _, ok := got["openid"]; ok {
+		t.Error("Expected openid to have no grant expiry")
+	}
+
+	// An active grant keeps its expiry; an expired one starts over
+	active := &models.UserConsent{
+		Scopes:         []string{"openid", "payment"},
+		ScopeExpiresAt: map[string]time.Time{"payment": now.Add(5 * time.Minute)},
+	}
+	if got := scopeGrantExpiries(active, []string{"openid", "payment"}, cfg, now); !got["payment"].Equal(now.Add(5 * time.Minute)) {
+		t.Errorf("Expected active payment grant to keep its expiry, got %v", got["payment"])
+	}
+
+	lapsed := &models.UserConsent{
+		Scopes:         []string{"openid", "payment"},
+		ScopeExpiresAt: map[string]time.Time{"payment": now.Add(-time.Minute)},
+	}
+	if got := scopeGrantExpiries(lapsed, []string{"openid", "payment"}, cfg, now); !got["payment"].Equal(now.Add(15 * time.Minute)) {
+		t.Errorf("Expected lapsed payment grant to restart, got %v", got["payment"])
+	}
+}
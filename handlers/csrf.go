@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"net/http"
+	"net/url"
+	"oauth2-server/config"
+	"oauth2-server/utils"
+	"slices"
+)
+
+const (
+	// CSRFCookieName holds the random per-browser CSRF token of the login,
+	// registration, consent, activation and account pages
+	CSRFCookieName = "oauth_csrf"
+
+	// CSRFHeaderName carries the token on requests sent by page scripts;
+	// HTML forms send it as the csrf_token field
+	CSRFHeaderName = "X-CSRF-Token"
+)
+
+// csrfToken returns the token to embed in a page's forms: the value of the
+// browser's CSRF cookie, which the first page it loads sets. Another site
+// can neither read the cookie nor the page, so it cannot send the token back
+// (double-submit cookie).
+func csrfToken(w http.ResponseWriter, r *http.Request) string {
+	if cookie, err := r.Cookie(CSRFCookieName); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+
+	secret, err := utils.GenerateRandomString(32)
+	if err != nil {
+		return ""
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     CSRFCookieName,
+		Value:    secret,
+		Path:     SSOCookiePath,
+		HttpOnly: true,
+		Secure:   SSOCookieSecure,
+		SameSite: SSOCookieSameSite,
+	})
+	return secret
+}
+
+// requireCSRF rejects a state-changing request that a page on another site
+// may have sent, answering 403. Browser requests must come from this
+// server's origin or one of CORS_ALLOWED_ORIGINS, such as a custom login
+// UI, and present the token of their CSRF cookie. A request with no Origin,
+// Sec-Fetch-Site or cookies is an API call made outside a browser and has
+// nothing to forge.
+func requireCSRF(w http.ResponseWriter, r *http.Request, cfg *config.Config) bool {
+	origin := r.Header.Get("Origin")
+	fetchSite := r.Header.Get("Sec-Fetch-Site")
+	if origin == "" && fetchSite == "" && r.Header.Get("Cookie") == "" {
+		return true
+	}
+
+	if fetchSite == "cross-site" || (origin != "" && !sameOrigin(r, origin, cfg) && !slices.Contains(cfg.CORSAllowedOrigins, origin)) {
+		respondError(w, http.StatusForbidden, "access_denied", "Cross-origin request rejected")
+		return false
+	}
+
+	submitted := r.Header.Get(CSRFHeaderName)
+	if submitted == "" {
+		submitted = r.PostFormValue("csrf_token")
+	}
+	cookie, err := r.Cookie(CSRFCookieName)
+	if err != nil || cookie.Value == "" || submitted == "" ||
+		!hmac.Equal([]byte(submitted), []byte(cookie.Value)) {
+		respondError(w, http.StatusForbidden, "access_denied", "Invalid CSRF token, reload the page and try again")
+		return false
+	}
+	return true
+}
+
+// sameOrigin reports whether an Origin header names this server, either as
+// it was reached or at its configured public or issuer URL
+func sameOrigin(r *http.Request, origin string, cfg *config.Config) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if u.Host == r.Host {
+		return true
+	}
+	for _, base := range []string{cfg.PublicURL, cfg.IssuerURL} {
+		if b, err := url.Parse(base); err == nil && b.Host != "" && b.Scheme == u.Scheme && b.Host == u.Host {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"oauth2-server/config"
+	"strings"
+	"testing"
+)
+
+func TestCSRFToken_SetsCookieOnce(t *testing.T) {
+	w := httptest.NewRecorder()
+	token := csrfToken(w, httptest.NewRequest(http.MethodGet, "/auth/login", nil))
+
+	cookies := w.Result().Cookies()
+	if token == "" || len(cookies) != 1 || cookies[0].Name != CSRFCookieName || cookies[0].Value != token || !cookies[0].HttpOnly {
+		t.Fatalf("Expected an HttpOnly cookie holding the token, got %q %+v", token, cookies)
+	}
+
+	// Later pages reuse the browser's token
+	req := httptest.NewRequest(http.MethodGet, "/auth/login", nil)
+	req.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	if got := csrfToken(w, req); got != token || len(w.Result().Cookies()) != 0 {
+		t.Errorf("Expected the existing token without a new cookie, got %q", got)
+	}
+}
+
+func TestRequireCSRF(t *testing.T) {
+	cfg := &config.Config{PublicURL: "https://auth.example.com", IssuerURL: "https://auth.example.com", CORSAllowedOrigins: []string{"https://login.example.com"}}
+
+	tests := []struct {
+		name      string
+		origin    string
+		fetchSite string
+		cookie    string
+		header    string
+		form      string
+		expected  bool
+	}{
+		{"API call without browser headers", "", "", "", "", "", true},
+		{"Header token", "https://auth.example.com", "same-origin", "csrf-1", "csrf-1", "", true},
+		{"Form token", "", "same-origin", "csrf-1", "", "csrf-1", true},
+		{"Request host origin", "http://example.com", "", "csrf-1", "csrf-1", "", true},
+		{"Trusted login UI origin", "https://login.example.com", "same-site", "csrf-1", "csrf-1", "", true},
+		{"Missing token", "https://auth.example.com", "same-origin", "csrf-1", "", "", false},
+		{"Wrong token", "https://auth.example.com", "same-origin", "csrf-1", "csrf-2", "", false},
+		{"Missing cookie", "https://auth.example.com", "same-origin", "", "csrf-1", "", false},
+		{"Cross-site fetch", "", "cross-site", "csrf-1", "csrf-1", "", false},
+		{"Foreign origin", "https://evil.example.net", "", "csrf-1", "csrf-1", "", false},
+		{"Null origin", "null", "", "csrf-1", "csrf-1", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			if tt.form != "" {
+				form.Set("csrf_token", tt.form)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/oauth/consent", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.fetchSite != "" {
+				req.Header.Set("Sec-Fetch-Site", tt.fetchSite)
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: CSRFCookieName, Value: tt.cookie})
+			}
+			if tt.header != "" {
+				req.Header.Set(CSRFHeaderName, tt.header)
+			}
+
+			w := httptest.NewRecorder()
+			if got := requireCSRF(w, req, cfg); got != tt.expected {
+				t.Errorf("requireCSRF() = %v, want %v", got, tt.expected)
+			}
+			if !tt.expected && w.Code != http.StatusForbidden {
+				t.Errorf("Expected status 403, got %d", w.Code)
+			}
+		})
+	}
+}
+
+func TestAuthHandler_LoginRejectsForgedRequest(t *testing.T) {
+	handler := NewAuthHandler(nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, NewTemplateRenderer(false, "", ""), nil, nil, nil, &config.Config{})
+
+	// A form on another site posting a JSON-looking text/plain body
+	req := httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(`{"email":"a@example.com","password":"x"}`))
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("Origin", "https://evil.example.net")
+	req.AddCookie(&http.Cookie{Name: SSOCookieName, Value: "sso-1"})
+	w := httptest.NewRecorder()
+	handler.Login(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403, got %d", w.Code)
+	}
+}
@@ -0,0 +1,326 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"oauth2-server/config"
+	"oauth2-server/models"
+	"oauth2-server/repository"
+	"oauth2-server/utils"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// DeveloperHandler lets signed-in users register and manage their own OAuth
+// clients. Every client route only sees clients owned by the caller.
+type DeveloperHandler struct {
+	userRepo    *repository.UserRepository
+	clientRepo  *repository.ClientRepository
+	consentRepo *repository.UserConsentRepository
+	auditRepo   *repository.AuditRepository
+	clients     *ClientHandler
+	revoker     *Revoker
+	audit       *Auditor
+	tokens      *TokenStore
+	config      *config.Config
+}
+
+func NewDeveloperHandler(
+	userRepo *repository.UserRepository,
+	clientRepo *repository.ClientRepository,
+	consentRepo *repository.UserConsentRepository,
+	auditRepo *repository.AuditRepository,
+	clients *ClientHandler,
+	revoker *Revoker,
+	audit *Auditor,
+	tokens *TokenStore,
+	cfg *config.Config,
+) *DeveloperHandler {
+	return &DeveloperHandler{
+		userRepo:    userRepo,
+		clientRepo:  clientRepo,
+		consentRepo: consentRepo,
+		auditRepo:   auditRepo,
+		clients:     clients,
+		revoker:     revoker,
+		audit:       audit,
+		tokens:      tokens,
+		config:      cfg,
+	}
+}
+
+// ClientUsageStats summarizes how a client is being used
+type ClientUsageStats struct {
+	ClientID           string `json:"client_id"`
+	AuthorizedUsers    int64  `json:"authorized_users"`
+	TokensIssued       int64  `json:"tokens_issued"`
+	TokensIssuedLast30 int64  `json:"tokens_issued_last_30_days"`
+}
+
+// ListClients returns the caller's clients
+// GET /developer/clients
+func (h *DeveloperHandler) ListClients(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	clients, err := h.clientRepo.FindByOwner(r.Context(), userID)
+	if err != nil {
+		respondInternalError(w, err, "Failed to retrieve clients")
+		return
+	}
+	if clients == nil {
+		clients = []*models.Client{}
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{"clients": clients})
+}
+
+// CreateClient registers a client owned by the caller. The admin scope
+// cannot be requested through the developer portal.
+// POST /developer/clients
+func (h *DeveloperHandler) CreateClient(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	req, ok := h.decodeClientRequest(w, r, nil)
+	if !ok {
+		return
+	}
+
+	client, err := newClient(req, userID)
+	if err != nil {
+		respondInternalError(w, err, "Failed to generate client credentials")
+		return
+	}
+
+	if err := h.clientRepo.Create(r.Context(), client); err != nil {
+		respondRepositoryError(w, err, "Client", "Failed to create client")
+		return
+	}
+
+	h.audit.record(r, models.AuditClientRegistered, userID, client.ClientID, map[string]string{"name": client.Name})
+	respondJSON(w, http.StatusCreated, clientResponse(client, req.IsPublic))
+}
+
+// GetClient returns one of the caller's clients. The secret is never shown again.
+// GET /developer/clients/{client_id}
+func (h *DeveloperHandler) GetClient(w http.ResponseWriter, r *http.Request) {
+	client, ok := h.findOwnedClient(w, r)
+	if !ok {
+		return
+	}
+	respondJSON(w, http.StatusOK, client)
+}
+
+// UpdateClient replaces the client's settings. Whether the client is public
+// cannot be changed.
+// PUT /developer/clients/{client_id}
+func (h *DeveloperHandler) UpdateClient(w http.ResponseWriter, r *http.Request) {
+	client, ok := h.findOwnedClient(w, r)
+	if !ok {
+		return
+	}
+
+	req, ok := h.decodeClientRequest(w, r, client)
+	if !ok {
+		return
+	}
+
+	client.Name = req.Name
+	client.RedirectURIs = req.RedirectURIs
+	client.AllowedScopes = req.AllowedScopes
+	client.GrantTypes = req.GrantTypes
+	client.ResponseTypes = req.ResponseTypes
+	client.AllowedResources = req.Resources
+	client.ConsentPolicy = req.ConsentPolicy
+	client.ConsentTTLDays = req.ConsentTTL
+	client.PromptNonePolicy = req.PromptNone
+	client.ScopeMode = req.ScopeMode
+	client.RedirectMatching = req.RedirectMatch
+	client.TokenExchange = req.TokenExchange
+	client.TokenFormat = req.TokenFormat
+	client.TokenEndpointAuthMethod = req.AuthMethod
+	client.TLSClientAuthSubjectDN = req.TLSSubjectDN
+	client.TLSClientThumbprint = req.TLSThumbprint
+	client.CertificateBoundTokens = req.BoundTokens
+	client.DPoPBoundTokens = req.DPoPBound
+	client.UserInfoSignedAlg = req.UserInfoSigned
+	client.UserInfoEncryptedAlg = req.UserInfoEncAlg
+	client.UserInfoEncryptedEnc = req.UserInfoEncEnc
+	client.JWKS = req.JWKS
+	client.JWKSURI = req.JWKSURI
+	client.IDTokenEncryptedAlg = req.IDTokenEncAlg
+	client.IDTokenEncryptedEnc = req.IDTokenEncEnc
+	client.LogoURI = req.LogoURI
+	client.BrandColor = req.BrandColor
+	client.BackchannelLogoutURI = req.BackchannelLogoutURI
+	if req.ConsentMessage != client.ConsentMessage {
+		client.ConsentMessage = req.ConsentMessage
+		client.ConsentMessageApproved = false
+	}
+
+	if err := h.clientRepo.UpdateSettings(r.Context(), client); err != nil {
+		respondInternalError(w, err, "Failed to update client")
+		return
+	}
+	h.audit.record(r, models.AuditClientUpdated, client.OwnerUserID, client.ClientID, nil)
+
+	respondJSON(w, http.StatusOK, client)
+}
+
+// DeleteClient removes the client and the consents users granted it, and
+// revokes every token issued to it
+// DELETE /developer/clients/{client_id}
+func (h *DeveloperHandler) DeleteClient(w http.ResponseWriter, r *http.Request) {
+	client, ok := h.findOwnedClient(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	if err := h.consentRepo.DeleteByClientID(ctx, client.ClientID); err != nil {
+		respondInternalError(w, err, "Failed to remove consents")
+		return
+	}
+	if err := h.revoker.RevokeClient(ctx, client.ClientID); err != nil {
+		respondInternalError(w, err, "Failed to revoke tokens")
+		return
+	}
+	if err := h.clientRepo.Delete(ctx, client.ClientID); err != nil {
+		respondInternalError(w, err, "Failed to delete client")
+		return
+	}
+
+	h.audit.record(r, models.AuditClientDeleted, client.OwnerUserID, client.ClientID, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RotateSecret issues a new client secret; the old one stops working immediately
+// POST /developer/clients/{client_id}/rotate-secret
+func (h *DeveloperHandler) RotateSecret(w http.ResponseWriter, r *http.Request) {
+	client, ok := h.findOwnedClient(w, r)
+	if !ok {
+		return
+	}
+
+	if client.ClientSecret == "" {
+		respondError(w, http.StatusBadRequest, "invalid_request", "Public clients do not have a secret")
+		return
+	}
+
+	secret, err := utils.GenerateRandomString(64)
+	if err != nil {
+		respondInternalError(w, err, "Failed to generate client secret")
+		return
+	}
+
+	if err := h.clientRepo.UpdateSecret(r.Context(), client.ClientID, secret); err != nil {
+		respondInternalError(w, err, "Failed to rotate client secret")
+		return
+	}
+	h.audit.record(r, models.AuditClientSecretRotated, client.OwnerUserID, client.ClientID, nil)
+
+	respondJSON(w, http.StatusOK, map[string]string{
+		"client_id":     client.ClientID,
+		"client_secret": secret,
+	})
+}
+
+// ClientStats returns usage statistics for one of the caller's clients.
+// Token counts come from the audit log.
+// GET /developer/clients/{client_id}/stats
+func (h *DeveloperHandler) ClientStats(w http.ResponseWriter, r *http.Request) {
+	client, ok := h.findOwnedClient(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	stats := ClientUsageStats{ClientID: client.ClientID}
+
+	var err error
+	if stats.AuthorizedUsers, err = h.consentRepo.CountByClientID(ctx, client.ClientID); err != nil {
+		respondInternalError(w, err, "Failed to compute usage")
+		return
+	}
+	if stats.TokensIssued, err = h.auditRepo.CountEvents(ctx, client.ClientID, models.AuditTokenIssued, time.Time{}); err != nil {
+		respondInternalError(w, err, "Failed to compute usage")
+		return
+	}
+	since := time.Now().Add(-30 * 24 * time.Hour)
+	if stats.TokensIssuedLast30, err = h.auditRepo.CountEvents(ctx, client.ClientID, models.AuditTokenIssued, since); err != nil {
+		respondInternalError(w, err, "Failed to compute usage")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, stats)
+}
+
+// authenticate resolves the caller from the bearer token, writing an error
+// response when the token is invalid or the account is disabled
+func (h *DeveloperHandler) authenticate(w http.ResponseWriter, r *http.Request) (string, bool) {
+	userID, _, err := parseBearerToken(r, h.tokens, h.config)
+	if err != nil {
+		if authErr, ok := err.(*AuthError); ok {
+			respondError(w, http.StatusUnauthorized, authErr.Code, authErr.Message)
+			return "", false
+		}
+		respondError(w, http.StatusUnauthorized, "unauthorized", "Authentication failed")
+		return "", false
+	}
+
+	user, err := h.userRepo.FindByID(r.Context(), userID)
+	if err != nil || user.Disabled {
+		respondError(w, http.StatusUnauthorized, "invalid_token", "Token does not belong to an active user")
+		return "", false
+	}
+	return user.ID, true
+}
+
+// findOwnedClient authenticates the caller and loads the client named by the
+// client_id path variable. Clients owned by someone else are reported as not
+// found so their existence is not revealed.
+func (h *DeveloperHandler) findOwnedClient(w http.ResponseWriter, r *http.Request) (*models.Client, bool) {
+	userID, ok := h.authenticate(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	client, err := h.clientRepo.FindByClientID(r.Context(), mux.Vars(r)["client_id"])
+	if err != nil {
+		respondRepositoryError(w, err, "Client", "Failed to retrieve client")
+		return nil, false
+	}
+	if client.OwnerUserID != userID {
+		respondError(w, http.StatusNotFound, "not_found", "Client not found")
+		return nil, false
+	}
+	return client, true
+}
+
+// decodeClientRequest parses and validates a client request body. When
+// updating, existing is the stored client, whose public or confidential
+// type the request cannot change.
+func (h *DeveloperHandler) decodeClientRequest(w http.ResponseWriter, r *http.Request, existing *models.Client) (*ClientRequest, bool) {
+	var req ClientRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return nil, false
+	}
+	if existing != nil {
+		req.IsPublic = existing.ClientSecret == ""
+	}
+
+	// Developer clients are never first-party, so they cannot request the
+	// admin scope or skip consent for prompt=none
+	if err := h.clients.validateClientRequest(&req, false); err != nil {
+		respondError(w, http.StatusBadRequest, err.code, err.description)
+		return nil, false
+	}
+	return &req, true
+}
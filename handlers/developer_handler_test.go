@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"oauth2-server/config"
+	"oauth2-server/models"
+	"oauth2-server/utils"
+	"strings"
+	"testing"
+)
+
+func TestDeveloperHandler_RequiresAuthentication(t *testing.T) {
+	h := NewDeveloperHandler(nil, nil, nil, nil, nil, nil, nil, nil, &config.Config{})
+
+	routes := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{"ListClients", h.ListClients},
+		{"CreateClient", h.CreateClient},
+		{"GetClient", h.GetClient},
+		{"RotateSecret", h.RotateSecret},
+		{"ClientStats", h.ClientStats},
+	}
+
+	for _, tt := range routes {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.handler(w, httptest.NewRequest("GET", "/developer/clients", nil))
+
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("Expected status 401, got %d", w.Code)
+			}
+		})
+	}
+}
+
+func TestDeveloperHandler_DecodeClientRequest(t *testing.T) {
+	registry := models.NewScopeRegistry()
+	clients := NewClientHandler(nil, registry, utils.NewScopeValidator(registry), nil, &config.Config{})
+	h := NewDeveloperHandler(nil, nil, nil, nil, clients, nil, nil, nil, &config.Config{})
+
+	tests := []struct {
+		name     string
+		body     string
+		expected int
+	}{
+		{"Admin scope", `{"name":"App","redirect_uris":["https://app.example.com/cb"],"allowed_scopes":["openid","admin"]}`, http.StatusBadRequest},
+		{"Missing redirect URIs", `{"name":"App"}`, http.StatusBadRequest},
+		{"Prompt none without consent", `{"name":"App","redirect_uris":["https://app.example.com/cb"],"prompt_none_policy":"authentication"}`, http.StatusBadRequest},
+		{"Unknown prompt none policy", `{"name":"App","redirect_uris":["https://app.example.com/cb"],"prompt_none_policy":"bogus"}`, http.StatusBadRequest},
+		{"Unknown auth method", `{"name":"App","redirect_uris":["https://app.example.com/cb"],"token_endpoint_auth_method":"bogus"}`, http.StatusBadRequest},
+		{"tls_client_auth without subject", `{"name":"App","redirect_uris":["https://app.example.com/cb"],"token_endpoint_auth_method":"tls_client_auth"}`, http.StatusBadRequest},
+		{"Self-signed without thumbprint", `{"name":"App","redirect_uris":["https://app.example.com/cb"],"token_endpoint_auth_method":"self_signed_tls_client_auth","tls_client_certificate_thumbprint":"abc"}`, http.StatusBadRequest},
+		{"Public client with certificate", `{"name":"App","redirect_uris":["https://app.example.com/cb"],"is_public":true,"token_endpoint_auth_method":"tls_client_auth","tls_client_auth_subject_dn":"CN=app"}`, http.StatusBadRequest},
+		{"Unknown userinfo signing alg", `{"name":"App","redirect_uris":["https://app.example.com/cb"],"userinfo_signed_response_alg":"HS256"}`, http.StatusBadRequest},
+		{"Userinfo enc without alg", `{"name":"App","redirect_uris":["https://app.example.com/cb"],"userinfo_encrypted_response_enc":"A256GCM"}`, http.StatusBadRequest},
+		{"Userinfo encryption without jwks", `{"name":"App","redirect_uris":["https://app.example.com/cb"],"userinfo_encrypted_response_alg":"RSA-OAEP-256"}`, http.StatusBadRequest},
+		{"ID token encryption without jwks", `{"name":"App","redirect_uris":["https://app.example.com/cb"],"id_token_encrypted_response_alg":"RSA-OAEP-256"}`, http.StatusBadRequest},
+		{"Unsupported ID token enc", `{"name":"App","redirect_uris":["https://app.example.com/cb"],"id_token_encrypted_response_alg":"RSA-OAEP-256","id_token_encrypted_response_enc":"A128GCM"}`, http.StatusBadRequest},
+		{"Consent message too long", `{"name":"App","redirect_uris":["https://app.example.com/cb"],"consent_message":"` + strings.Repeat("a", 301) + `"}`, http.StatusBadRequest},
+		{"Valid", `{"name":"App","redirect_uris":["https://app.example.com/cb"]}`, http.StatusOK},
+		{"Valid signed userinfo", `{"name":"App","redirect_uris":["https://app.example.com/cb"],"userinfo_signed_response_alg":"RS256"}`, http.StatusOK},
+		{"Valid mTLS", `{"name":"App","redirect_uris":["https://app.example.com/cb"],"token_endpoint_auth_method":"tls_client_auth","tls_client_auth_subject_dn":"CN=app","tls_client_certificate_bound_access_tokens":true}`, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, ok := h.decodeClientRequest(w, httptest.NewRequest("POST", "/developer/clients", strings.NewReader(tt.body)), nil)
+
+			if tt.expected == http.StatusOK {
+				if !ok {
+					t.Fatalf("Expected request to be accepted, got %d: %s", w.Code, w.Body.String())
+				}
+				if len(req.GrantTypes) == 0 || len(req.ResponseTypes) == 0 {
+					t.Error("Expected defaults to be filled in")
+				}
+				for _, scope := range req.AllowedScopes {
+					if scope == AdminScope {
+						t.Error("Expected default allowed scopes to exclude admin")
+					}
+				}
+				return
+			}
+			if ok || w.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, w.Code)
+			}
+		})
+	}
+}
@@ -1,36 +1,235 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
 	"net/http"
-	"oauth2-server/logger"
-	"oauth2-server/mlog"
+	"oauth2-server/config"
 	"oauth2-server/models"
+	"oauth2-server/repository"
+	"oauth2-server/utils"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
 )
 
+// MetadataChangedEvent is the event posted to metadata webhooks
+const MetadataChangedEvent = "metadata_changed"
+
+// DiscoveryHandler publishes the discovery document, both as OpenID Connect
+// discovery and as OAuth 2.0 authorization server metadata (RFC 8414). The
+// document is built from the registered grant types and the configuration,
+// so it only advertises what this deployment supports. It is versioned by a hash of its content and the signing key ID, so a change to
+// scopes, keys or endpoints yields a new metadata_version and Last-Modified
+// and is announced to the configured webhooks. The serialized document is
+// kept until the scope registry changes.
 type DiscoveryHandler struct {
-	issuer   string
-	registry *models.ScopeRegistry
+	issuer       string
+	registry     *models.ScopeRegistry
+	keyID        string
+	metadataRepo *repository.MetadataRepository
+	webhooks     []string
+	maxAge       int64
+	config       *config.Config
+	grantTypes   []string
+	httpClient   *http.Client
+
+	mu      sync.Mutex
+	current models.MetadataVersion
+	cache   *cachedDocument
 }
 
-func NewDiscoveryHandler(issuer string, registry *models.ScopeRegistry) *DiscoveryHandler {
+func NewDiscoveryHandler(
+	issuer string,
+	registry *models.ScopeRegistry,
+	keyID string,
+	metadataRepo *repository.MetadataRepository,
+	webhooks []string,
+	maxAge int64,
+	cfg *config.Config,
+	grantTypes []string,
+) *DiscoveryHandler {
 	return &DiscoveryHandler{
-		issuer:   issuer,
-		registry: registry,
+		issuer:       issuer,
+		registry:     registry,
+		keyID:        keyID,
+		metadataRepo: metadataRepo,
+		webhooks:     webhooks,
+		maxAge:       maxAge,
+		config:       cfg,
+		grantTypes:   grantTypes,
+		httpClient:   &http.Client{Timeout: 10 * time.Second},
 	}
 }
 
+// cachedDocument is a serialized metadata response with its validators
+type cachedDocument struct {
+	body       []byte
+	etag       string
+	modifiedAt time.Time
+	// generation is the scope registry generation the document was built from
+	generation uint64
+}
+
 func (h *DiscoveryHandler) WellKnown(w http.ResponseWriter, r *http.Request) {
-	l := mlog.L(r)
-	l.Info(logger.ActionInfo{
-		Action:            "inbound",
-		ActionDescription: "get -> " + r.URL.RawPath,
-	}, map[string]any{
-		"method":  r.Method,
-		"url":     r.URL.String(),
-		"headers": r.Header,
-		"query":   r.URL.Query(),
-		"body":    r.Body,
+	generation := h.registry.Generation()
+	h.mu.Lock()
+	doc := h.cache
+	h.mu.Unlock()
+	if doc == nil || doc.generation != generation {
+		doc, _ = h.build(r.Context(), generation)
+	}
+	serveCached(w, r, doc, h.maxAge)
+}
+
+// Refresh recomputes the metadata version and announces it if it changed.
+// It runs at startup, so a new signing key is announced once the server
+// restarts with it.
+func (h *DiscoveryHandler) Refresh(ctx context.Context) models.MetadataVersion {
+	_, version := h.build(ctx, h.registry.Generation())
+	return version
+}
+
+// build serializes the document for the given registry generation and keeps
+// it for later requests, unless its version could not be recorded
+func (h *DiscoveryHandler) build(ctx context.Context, generation uint64) (*cachedDocument, models.MetadataVersion) {
+	discovery := h.document()
+	version, recorded := h.refresh(ctx, discovery)
+	discovery["metadata_version"] = version.Version
+
+	body, _ := json.Marshal(discovery)
+	doc := &cachedDocument{
+		body:       append(body, '\n'),
+		etag:       `"` + version.Version + `"`,
+		modifiedAt: version.ModifiedAt,
+		generation: generation,
+	}
+	if recorded {
+		h.mu.Lock()
+		h.cache = doc
+		h.mu.Unlock()
+	}
+	return doc, version
+}
+
+// serveCached writes a serialized JSON document with caching headers, or
+// 304 Not Modified when the client's copy is current
+func serveCached(w http.ResponseWriter, r *http.Request, doc *cachedDocument, maxAge int64) {
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.FormatInt(maxAge, 10))
+	w.Header().Set("ETag", doc.etag)
+	w.Header().Set("Last-Modified", doc.modifiedAt.Format(http.TimeFormat))
+	if notModified(r, doc.etag, doc.modifiedAt) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(doc.body)
+}
+
+// RunWatch refreshes the metadata version every interval, so changes such as
+// scopes reloaded from another instance are announced without waiting for a
+// discovery request
+func (h *DiscoveryHandler) RunWatch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.Refresh(ctx)
+		}
+	}
+}
+
+// refresh records the document's version and reports whether it was
+// recorded; an unrecorded version is recomputed on the next request
+func (h *DiscoveryHandler) refresh(ctx context.Context, discovery map[string]interface{}) (models.MetadataVersion, bool) {
+	version := metadataVersion(discovery, h.keyID+h.config.EncryptionKeyID)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.current.Version == version {
+		return h.current, true
+	}
+
+	current := models.MetadataVersion{Version: version, ModifiedAt: time.Now()}
+	changed := h.current.Version != ""
+	if h.metadataRepo != nil {
+		stored, storedChanged, err := h.metadataRepo.Record(ctx, version)
+		if err != nil {
+			// Not cached, so the next request records it again
+			log.Printf("Failed to record discovery metadata version: %v", err)
+			current.ModifiedAt = current.ModifiedAt.UTC().Truncate(time.Second)
+			return current, false
+		}
+		// The stored version decides, so only the instance that
+		// recorded the change announces it
+		current, changed = *stored, storedChanged
+	}
+	// HTTP dates have second precision
+	current.ModifiedAt = current.ModifiedAt.UTC().Truncate(time.Second)
+	h.current = current
+
+	if changed && len(h.webhooks) > 0 {
+		go h.notify(current)
+	}
+	return current, true
+}
+
+// notify posts a metadata_changed event to each webhook. The event carries
+// no metadata itself; receivers fetch the discovery document and JWKS again.
+func (h *DiscoveryHandler) notify(version models.MetadataVersion) {
+	body, _ := json.Marshal(map[string]string{
+		"event":            MetadataChangedEvent,
+		"issuer":           h.issuer,
+		"metadata_version": version.Version,
+		"last_modified":    version.ModifiedAt.Format(time.RFC3339),
 	})
+	for _, url := range h.webhooks {
+		resp, err := h.httpClient.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("Failed to notify metadata webhook %s: %v", url, err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Printf("Metadata webhook %s responded with %d", url, resp.StatusCode)
+		}
+	}
+}
+
+// metadataVersion hashes the discovery document together with the signing
+// key ID, which the document only references through jwks_uri
+func metadataVersion(discovery map[string]interface{}, keyID string) string {
+	data, _ := json.Marshal(discovery)
+	sum := sha256.Sum256(append(data, keyID...))
+	return hex.EncodeToString(sum[:8])
+}
+
+// notModified reports whether the client's cached copy is current. As in
+// net/http, If-None-Match takes precedence over If-Modified-Since.
+func notModified(r *http.Request, etag string, modifiedAt time.Time) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		return match == etag || match == "*"
+	}
+	if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil {
+		return !modifiedAt.After(since)
+	}
+	return false
+}
+
+// document builds the discovery document without its version
+func (h *DiscoveryHandler) document() map[string]interface{} {
 	// Get all registered scopes
 	scopes := h.getScopesSupported()
 
@@ -43,30 +242,82 @@ func (h *DiscoveryHandler) WellKnown(w http.ResponseWriter, r *http.Request) {
 		"authorization_endpoint":                h.issuer + "/oauth/authorize",
 		"token_endpoint":                        h.issuer + "/oauth/token",
 		"jwks_uri":                              h.issuer + "/.well-known/jwks.json",
-		"response_types_supported":              []string{"code", "token", "id_token", "code id_token", "code token", "id_token token", "code id_token token"},
+		"response_types_supported":              utils.SupportedResponseTypes,
 		"subject_types_supported":               []string{"public"},
 		"id_token_signing_alg_values_supported": []string{"RS256"},
 
 		// Recommended OIDC Discovery fields
 		"userinfo_endpoint":                     h.issuer + "/oauth/userinfo",
+		"registration_endpoint":                 h.issuer + "/clients/register",
 		"scopes_supported":                      scopes,
 		"claims_supported":                      claims,
-		"grant_types_supported":                 []string{"authorization_code", "refresh_token", "client_credentials", "implicit"},
-		"token_endpoint_auth_methods_supported": []string{"client_secret_post", "client_secret_basic"},
+		"grant_types_supported":                 h.getGrantTypesSupported(),
+		"token_endpoint_auth_methods_supported": h.getAuthMethodsSupported(),
 
 		// Additional useful fields
-		"response_modes_supported":                         []string{"query", "fragment"},
+		"response_modes_supported":                         []string{ResponseModeQuery, ResponseModeFragment, ResponseModeFormPost},
 		"code_challenge_methods_supported":                 []string{"S256", "plain"},
-		"token_endpoint_auth_signing_alg_values_supported": []string{"RS256"},
-		"userinfo_signing_alg_values_supported":            []string{"RS256"},
-		"request_parameter_supported":                      false,
+		"token_endpoint_auth_signing_alg_values_supported": clientSigningAlgs,
+		"userinfo_signing_alg_values_supported":            userInfoSigningAlgs,
+		"userinfo_encryption_alg_values_supported":         utils.JWEKeyAlgs,
+		"userinfo_encryption_enc_values_supported":         utils.JWEContentEncs,
+		"id_token_encryption_alg_values_supported":         utils.JWEKeyAlgs,
+		"id_token_encryption_enc_values_supported":         utils.JWEContentEncs,
+		"request_parameter_supported":                      true,
+		"request_object_signing_alg_values_supported":      clientSigningAlgs,
 		"request_uri_parameter_supported":                  false,
 		"require_request_uri_registration":                 false,
 		"claims_parameter_supported":                       false,
+		"acr_values_supported":                             []string{ACRMFA},
+		"tls_client_certificate_bound_access_tokens":       h.clientCertificates(),
+		"dpop_signing_alg_values_supported":                utils.DPoPSigningAlgs,
+		"backchannel_logout_supported":                     true,
+		"backchannel_logout_session_supported":             false,
+	}
+
+	// Optional endpoints and documents, advertised only when enabled
+	if slices.Contains(h.grantTypes, DeviceCodeGrantType) {
+		discovery["device_authorization_endpoint"] = h.issuer + "/cli/authorize"
 	}
+	for field, uri := range map[string]string{
+		"service_documentation": h.config.ServiceDocumentationURL,
+		"op_policy_uri":         h.config.OPPolicyURI,
+		"op_tos_uri":            h.config.OPTosURI,
+	} {
+		if uri != "" {
+			discovery[field] = uri
+		}
+	}
+
+	return discovery
+}
+
+// getGrantTypesSupported returns the grant types registered at the token
+// endpoint
+func (h *DiscoveryHandler) getGrantTypesSupported() []string {
+	grantTypes := slices.Clone(h.grantTypes)
+	sort.Strings(grantTypes)
+	return grantTypes
+}
+
+// getAuthMethodsSupported returns the client authentication methods the
+// token endpoint can check. The mTLS methods need client certificates, and
+// tls_client_auth also the CAs that issue them.
+func (h *DiscoveryHandler) getAuthMethodsSupported() []string {
+	methods := []string{models.AuthMethodClientSecretPost, models.AuthMethodClientSecretBasic, models.AuthMethodPrivateKeyJWT}
+	if h.clientCertificates() {
+		if h.config.MTLSClientCAs != nil {
+			methods = append(methods, models.AuthMethodTLSClientAuth)
+		}
+		methods = append(methods, models.AuthMethodSelfSignedTLSClientAuth)
+	}
+	return methods
+}
 
-	respondJSON(w, http.StatusOK, discovery)
-	l.Flush(200, "success")
+// clientCertificates reports whether client certificates reach the server,
+// from its own TLS handshake or a TLS terminating proxy
+func (h *DiscoveryHandler) clientCertificates() bool {
+	return h.config.TLSCertFile != "" || h.config.MTLSCertHeader != ""
 }
 
 // getScopesSupported returns all registered scope names
@@ -77,6 +328,8 @@ func (h *DiscoveryHandler) getScopesSupported() []string {
 	for _, scope := range allScopes {
 		scopes = append(scopes, scope.Name)
 	}
+	// Sorted so the document, and its version, are stable
+	sort.Strings(scopes)
 
 	return scopes
 }
@@ -109,6 +362,7 @@ func (h *DiscoveryHandler) getClaimsSupported() []string {
 	for claim := range claimsMap {
 		claims = append(claims, claim)
 	}
+	sort.Strings(claims)
 
 	return claims
 }
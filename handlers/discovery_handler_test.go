@@ -1,11 +1,17 @@
 package handlers
 
 import (
+	"context"
+	"crypto/x509"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"oauth2-server/config"
 	"oauth2-server/models"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestDiscoveryHandler_WellKnown(t *testing.T) {
@@ -13,7 +19,7 @@ func TestDiscoveryHandler_WellKnown(t *testing.T) {
 	registry := models.NewScopeRegistry()
 	
 	// Create discovery handler
-	handler := NewDiscoveryHandler("https://example.com", registry)
+	handler := NewDiscoveryHandler("https://example.com", registry, "kid-1", nil, nil, 300, &config.Config{}, []string{"authorization_code", "client_credentials", "refresh_token"})
 	
 	// Create test request
 	req := httptest.NewRequest("GET", "/.well-known/openid-configuration", nil)
@@ -172,7 +178,7 @@ func TestDiscoveryHandler_WellKnown(t *testing.T) {
 
 func TestDiscoveryHandler_GetScopesSupported(t *testing.T) {
 	registry := models.NewScopeRegistry()
-	handler := NewDiscoveryHandler("https://example.com", registry)
+	handler := NewDiscoveryHandler("https://example.com", registry, "kid-1", nil, nil, 300, &config.Config{}, nil)
 	
 	scopes := handler.getScopesSupported()
 	
@@ -196,7 +202,7 @@ func TestDiscoveryHandler_GetScopesSupported(t *testing.T) {
 
 func TestDiscoveryHandler_GetClaimsSupported(t *testing.T) {
 	registry := models.NewScopeRegistry()
-	handler := NewDiscoveryHandler("https://example.com", registry)
+	handler := NewDiscoveryHandler("https://example.com", registry, "kid-1", nil, nil, 300, &config.Config{}, nil)
 	
 	claims := handler.getClaimsSupported()
 	
@@ -225,3 +231,159 @@ func TestDiscoveryHandler_GetClaimsSupported(t *testing.T) {
 		}
 	}
 }
+
+func TestDiscoveryHandler_MetadataVersion(t *testing.T) {
+	registry := models.NewScopeRegistry()
+	events := make(chan map[string]string, 1)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event map[string]string
+		json.NewDecoder(r.Body).Decode(&event)
+		events <- event
+	}))
+	defer webhook.Close()
+
+	handler := NewDiscoveryHandler("https://example.com", registry, "kid-1", nil, []string{webhook.URL}, 300, &config.Config{}, nil)
+
+	fetch := func(header, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/.well-known/openid-configuration", nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		w := httptest.NewRecorder()
+		handler.WellKnown(w, req)
+		return w
+	}
+
+	w := fetch("", "")
+	var discovery map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &discovery)
+	version, _ := discovery["metadata_version"].(string)
+	if version == "" {
+		t.Fatal("Expected a metadata_version")
+	}
+	if w.Header().Get("ETag") != `"`+version+`"` || w.Header().Get("Last-Modified") == "" {
+		t.Errorf("Unexpected caching headers: %v", w.Header())
+	}
+
+	if w := fetch("If-None-Match", `"`+version+`"`); w.Code != http.StatusNotModified {
+		t.Errorf("Expected 304 for a current ETag, got %d", w.Code)
+	}
+	if w := fetch("If-Modified-Since", w.Header().Get("Last-Modified")); w.Code != http.StatusNotModified {
+		t.Errorf("Expected 304 for a current Last-Modified, got %d", w.Code)
+	}
+
+	registry.RegisterScope(&models.ScopeDefinition{Name: "orders:read", Claims: []string{}})
+	if w := fetch("If-None-Match", `"`+version+`"`); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200 after a scope change, got %d", w.Code)
+	}
+	changed := handler.Refresh(context.Background())
+	if changed.Version == version {
+		t.Fatal("Expected a new metadata_version after a scope change")
+	}
+
+	select {
+	case event := <-events:
+		if event["event"] != MetadataChangedEvent || event["metadata_version"] != changed.Version {
+			t.Errorf("Unexpected webhook event: %v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the webhook to be notified")
+	}
+}
+
+func TestMetadataVersion_DependsOnKeyID(t *testing.T) {
+	discovery := map[string]interface{}{"issuer": "https://example.com"}
+	if metadataVersion(discovery, "kid-1") == metadataVersion(discovery, "kid-2") {
+		t.Error("Expected a key change to change the metadata version")
+	}
+}
+
+func TestDiscoveryHandler_CachesUntilScopesChange(t *testing.T) {
+	registry := models.NewScopeRegistry()
+	handler := NewDiscoveryHandler("https://example.com", registry, "kid-1", nil, nil, 300, &config.Config{}, nil)
+
+	fetch := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.WellKnown(w, httptest.NewRequest("GET", "/.well-known/openid-configuration", nil))
+		return w
+	}
+
+	first := fetch()
+	if first.Header().Get("Cache-Control") != "public, max-age=300" {
+		t.Errorf("Unexpected Cache-Control: %q", first.Header().Get("Cache-Control"))
+	}
+	cached := handler.cache
+	if fetch(); handler.cache != cached {
+		t.Error("Expected the serialized document to be reused")
+	}
+
+	registry.RegisterScope(&models.ScopeDefinition{Name: "orders:read", Claims: []string{}})
+	w := fetch()
+	if handler.cache == cached || w.Header().Get("ETag") == first.Header().Get("ETag") {
+		t.Fatal("Expected a scope change to rebuild the document")
+	}
+	if !strings.Contains(w.Body.String(), "orders:read") {
+		t.Error("Expected the rebuilt document to list the new scope")
+	}
+}
+
+func TestDiscoveryHandler_RuntimeConfiguration(t *testing.T) {
+	minimal := NewDiscoveryHandler("https://example.com", models.NewScopeRegistry(), "kid-1", nil, nil, 300, &config.Config{}, []string{"authorization_code"})
+	doc := minimal.document()
+	for _, field := range []string{"device_authorization_endpoint", "op_policy_uri", "op_tos_uri", "service_documentation"} {
+		if _, ok := doc[field]; ok {
+			t.Errorf("Expected %s to be left out when not enabled", field)
+		}
+	}
+	if doc["tls_client_certificate_bound_access_tokens"] != false {
+		t.Error("Expected no certificate-bound tokens without client certificates")
+	}
+	if methods := doc["token_endpoint_auth_methods_supported"].([]string); slices.Contains(methods, models.AuthMethodTLSClientAuth) || slices.Contains(methods, models.AuthMethodSelfSignedTLSClientAuth) {
+		t.Errorf("Expected no mTLS methods without client certificates, got %v", methods)
+	}
+	if grants := doc["grant_types_supported"].([]string); !slices.Equal(grants, []string{"authorization_code"}) {
+		t.Errorf("Expected the registered grant types, got %v", grants)
+	}
+
+	cfg := &config.Config{
+		MTLSCertHeader: "X-Client-Cert",
+		MTLSClientCAs:  x509.NewCertPool(),
+		OPPolicyURI:    "https://example.com/privacy",
+	}
+	full := NewDiscoveryHandler("https://example.com", models.NewScopeRegistry(), "kid-1", nil, nil, 300, cfg, []string{"authorization_code", DeviceCodeGrantType})
+	doc = full.document()
+	if doc["device_authorization_endpoint"] != "https://example.com/cli/authorize" {
+		t.Errorf("Expected the device endpoint with the device grant, got %v", doc["device_authorization_endpoint"])
+	}
+	if doc["op_policy_uri"] != "https://example.com/privacy" {
+		t.Errorf("Expected the configured op_policy_uri, got %v", doc["op_policy_uri"])
+	}
+	if doc["tls_client_certificate_bound_access_tokens"] != true {
+		t.Error("Expected certificate-bound tokens with client certificates")
+	}
+	if methods := doc["token_endpoint_auth_methods_supported"].([]string); !slices.Contains(methods, models.AuthMethodTLSClientAuth) || !slices.Contains(methods, models.AuthMethodSelfSignedTLSClientAuth) {
+		t.Errorf("Expected the mTLS methods with client certificates, got %v", methods)
+	}
+}
+
+func BenchmarkDiscoveryHandler_WellKnown(b *testing.B) {
+	handler := NewDiscoveryHandler("https://example.com", models.NewScopeRegistry(), "kid-1", nil, nil, 300, &config.Config{}, nil)
+	req := httptest.NewRequest("GET", "/.well-known/openid-configuration", nil)
+
+	b.Run("cached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			handler.WellKnown(httptest.NewRecorder(), req)
+		}
+	})
+	// What every request cost before the document was kept between requests
+	b.Run("rebuilt", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			discovery := handler.document()
+			version, _ := handler.refresh(context.Background(), discovery)
+			discovery["metadata_version"] = version.Version
+			respondJSON(httptest.NewRecorder(), http.StatusOK, discovery)
+		}
+	})
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"oauth2-server/config"
+	"oauth2-server/models"
+	"oauth2-server/utils"
+	"strings"
+	"time"
+)
+
+const (
+	// DPoPHeader carries the DPoP proof; DPoPNonceHeader carries the nonce
+	// the next proof must include
+	DPoPHeader      = "DPoP"
+	DPoPNonceHeader = "DPoP-Nonce"
+
+	// dpopProofMaxAge is how far a proof's iat may be from the server clock
+	dpopProofMaxAge = 5 * time.Minute
+)
+
+// dpopBinding checks the DPoP proof sent to the token endpoint and returns
+// the thumbprint of its key, or "" when the client sent none and is not
+// required to. A proof without the current server nonce is answered with
+// use_dpop_nonce and a fresh nonce to retry with (RFC 9449 section 8).
+// nonces is nil unless DPOP_NONCE_LIFETIME is positive, and proofs are
+// refused while it is configured but missing. ok is false once an error
+// response has been written.
+func dpopBinding(w http.ResponseWriter, r *http.Request, nonces *utils.NonceSource, client *models.Client, cfg *config.Config) (string, bool) {
+	proof := r.Header.Get(DPoPHeader)
+	if proof == "" {
+		if client.DPoPBoundTokens {
+			respondError(w, http.StatusBadRequest, "invalid_dpop_proof", "A DPoP proof is required for this client")
+			return "", false
+		}
+		return "", true
+	}
+
+	parsed, err := utils.ParseDPoPProof(proof, r.Method, cfg.IssuerURL+r.URL.Path, dpopProofMaxAge)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_dpop_proof", err.Error())
+		return "", false
+	}
+
+	if cfg.DPoPNonceLifetime > 0 && nonces == nil {
+		respondInternalError(w, errors.New("DPoP nonces are not set up"), "Failed to check DPoP proof")
+		return "", false
+	}
+	if nonces != nil {
+		w.Header().Set(DPoPNonceHeader, nonces.Current())
+		if !nonces.Valid(parsed.Nonce) {
+			respondError(w, http.StatusBadRequest, "use_dpop_nonce", "Authorization server requires nonce in DPoP proof")
+			return "", false
+		}
+	}
+	return parsed.Thumbprint, true
+}
+
+// tokenType is the token_type of an access token issued with cnf
+func tokenType(cnf *utils.ConfirmationClaim) string {
+	if cnf != nil && cnf.JKT != "" {
+		return "DPoP"
+	}
+	return "Bearer"
+}
+
+// accessTokenFromHeader strips the Bearer or DPoP scheme from an
+// Authorization header value and reports which one was used
+func accessTokenFromHeader(value string) (string, bool) {
+	if token, ok := strings.CutPrefix(value, "DPoP "); ok {
+		return token, true
+	}
+	return strings.TrimPrefix(value, "Bearer "), false
+}
+
+// presentedProofMatches reports whether a DPoP-bound token is presented with
+// the DPoP scheme and a proof, made for this request and this token, signed
+// with the key it was bound to. Tokens not bound to a DPoP key always match.
+func presentedProofMatches(r *http.Request, token *accessTokenInfo, tokenString string, dpop bool, cfg *config.Config) bool {
+	if token.JKT == "" {
+		return true
+	}
+	proof := r.Header.Get(DPoPHeader)
+	if !dpop || proof == "" {
+		return false
+	}
+	parsed, err := utils.ParseDPoPProof(proof, r.Method, cfg.IssuerURL+r.URL.Path, dpopProofMaxAge)
+	if err != nil || parsed.TokenHash != utils.AccessTokenHash(tokenString) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(parsed.Thumbprint), []byte(token.JKT)) == 1
+}
+
+// presentedBindingMatches reports whether a bound token is used with the
+// certificate or DPoP key it was bound to
+func presentedBindingMatches(r *http.Request, token *accessTokenInfo, tokenString string, dpop bool, cfg *config.Config) bool {
+	return presentedCertificateMatches(r, token, cfg) && presentedProofMatches(r, token, tokenString, dpop, cfg)
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"oauth2-server/config"
+	"oauth2-server/models"
+	"oauth2-server/utils"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// signTestDPoPProof signs a DPoP proof for the request with key
+func signTestDPoPProof(t *testing.T, key *ecdsa.PrivateKey, method, url string, extra jwt.MapClaims) string {
+	t.Helper()
+	claims := jwt.MapClaims{"jti": "proof-1", "htm": method, "htu": url, "iat": time.Now().Unix()}
+	for k, v := range extra {
+		claims[k] = v
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["typ"] = utils.DPoPProofType
+	token.Header["jwk"] = map[string]string{
+		"kty": "EC",
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	}
+	proof, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("Failed to sign proof: %v", err)
+	}
+	return proof
+}
+
+func TestDPoPBinding_NonceChallenge(t *testing.T) {
+	nonces := utils.NewNonceSource([]byte("secret"), time.Minute)
+
+	cfg := &config.Config{IssuerURL: "https://auth.example.com"}
+	client := &models.Client{ClientID: "app", DPoPBoundTokens: true}
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	url := cfg.IssuerURL + "/oauth/token"
+
+	// A DPoP-bound client must send a proof
+	w := httptest.NewRecorder()
+	if _, ok := dpopBinding(w, httptest.NewRequest("POST", "/oauth/token", nil), nonces, client, cfg); ok {
+		t.Fatal("Expected a missing proof to be rejected")
+	}
+
+	// A proof without the server nonce is answered with a nonce to retry with
+	r := httptest.NewRequest("POST", "/oauth/token", nil)
+	r.Header.Set(DPoPHeader, signTestDPoPProof(t, key, "POST", url, nil))
+	w = httptest.NewRecorder()
+	if _, ok := dpopBinding(w, r, nonces, client, cfg); ok {
+		t.Fatal("Expected a proof without nonce to be rejected")
+	}
+	var body map[string]string
+	json.Unmarshal(w.Body.Bytes(), &body)
+	nonce := w.Header().Get(DPoPNonceHeader)
+	if w.Code != http.StatusBadRequest || body["error"] != "use_dpop_nonce" || nonce == "" {
+		t.Fatalf("Expected use_dpop_nonce with a DPoP-Nonce header, got %d %v %q", w.Code, body, nonce)
+	}
+
+	r = httptest.NewRequest("POST", "/oauth/token", nil)
+	r.Header.Set(DPoPHeader, signTestDPoPProof(t, key, "POST", url, jwt.MapClaims{"nonce": nonce}))
+	w = httptest.NewRecorder()
+	jkt, ok := dpopBinding(w, r, nonces, client, cfg)
+	if !ok || jkt == "" {
+		t.Fatalf("Expected the retried proof to be accepted, got %d %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get(DPoPNonceHeader) == "" {
+		t.Error("Expected the current nonce on success")
+	}
+
+	// Clients that do not require DPoP may still send bearer requests
+	if jkt, ok := dpopBinding(httptest.NewRecorder(), httptest.NewRequest("POST", "/oauth/token", nil), nonces, &models.Client{}, cfg); !ok || jkt != "" {
+		t.Error("Expected a bearer request without proof to be accepted")
+	}
+}
+
+func TestPresentedProofMatches(t *testing.T) {
+	privateKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	cfg := &config.Config{IssuerURL: "https://auth.example.com", PrivateKey: privateKey, PublicKey: &privateKey.PublicKey, AccessTokenExpiry: 3600}
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	url := cfg.IssuerURL + "/oauth/userinfo"
+
+	proof := signTestDPoPProof(t, key, "GET", url, nil)
+	parsed, err := utils.ParseDPoPProof(proof, "GET", url, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to parse proof: %v", err)
+	}
+
+	tokens := NewTokenStore(nil, nil)
+	token, err := tokens.issueAccessToken(context.Background(), &models.Client{ClientID: "app"}, "user-1", "", "", "openid", nil, utils.NewConfirmationClaim("", parsed.Thumbprint), cfg)
+	if err != nil {
+		t.Fatalf("Failed to issue token: %v", err)
+	}
+	info, err := tokens.resolveAccessToken(context.Background(), token, cfg)
+	if err != nil || info.JKT != parsed.Thumbprint {
+		t.Fatalf("Expected the token to be bound to the proof key, got %+v, %v", info, err)
+	}
+
+	request := func(scheme string, proof string) *http.Request {
+		r := httptest.NewRequest("GET", "/oauth/userinfo", nil)
+		r.Header.Set("Authorization", scheme+" "+token)
+		if proof != "" {
+			r.Header.Set(DPoPHeader, proof)
+		}
+		return r
+	}
+	check := func(r *http.Request) bool {
+		tokenString, dpop := accessTokenFromHeader(r.Header.Get("Authorization"))
+		return presentedBindingMatches(r, info, tokenString, dpop, cfg)
+	}
+
+	ath := jwt.MapClaims{"ath": utils.AccessTokenHash(token)}
+	if !check(request("DPoP", signTestDPoPProof(t, key, "GET", url, ath))) {
+		t.Error("Expected a proof with ath from the bound key to match")
+	}
+	if check(request("Bearer", signTestDPoPProof(t, key, "GET", url, ath))) {
+		t.Error("Expected a DPoP-bound token sent as Bearer to be rejected")
+	}
+	if check(request("DPoP", signTestDPoPProof(t, key, "GET", url, nil))) {
+		t.Error("Expected a proof without ath to be rejected")
+	}
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if check(request("DPoP", signTestDPoPProof(t, other, "GET", url, ath))) {
+		t.Error("Expected a proof from another key to be rejected")
+	}
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"oauth2-server/middleware"
+	"oauth2-server/models"
+	"oauth2-server/repository"
+	"oauth2-server/utils"
+	"strings"
+)
+
+// respondError writes an OAuth error response. The description is translated
+// into the locale negotiated by the locale middleware, and error_uri links to
+// the page set up by the error URI middleware.
+func respondError(w http.ResponseWriter, status int, error, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(models.ErrorResponse{
+		Error:            error,
+		ErrorDescription: utils.GlobalMessageCatalog.Translate(middleware.LocaleOf(w), description),
+		ErrorURI:         middleware.ErrorURIOf(w, error),
+	})
+}
+
+// respondInternalError reports an unexpected error without its details,
+// which are logged under the request's transaction ID. A database that
+// could not be reached in time is a 503 the client may retry; anything else
+// is a 500 described by failure.
+func respondInternalError(w http.ResponseWriter, err error, failure string) {
+	transactionID := w.Header().Get(middleware.TransactionIDHeader)
+	if transactionID == "" {
+		transactionID = "-"
+	}
+	log.Printf("[%s] %s: %v", transactionID, failure, err)
+
+	if errors.Is(err, repository.ErrUnavailable) || errors.Is(err, context.DeadlineExceeded) {
+		respondError(w, http.StatusServiceUnavailable, "temporarily_unavailable", "The server is temporarily unavailable, retry later")
+		return
+	}
+	respondError(w, http.StatusInternalServerError, "server_error", failure)
+}
+
+// respondRepositoryError maps a repository error to a response: ErrNotFound
+// is a 404, ErrDuplicate a 409 with a "<resource>_exists" code and
+// ErrConflict a 409. Anything else is reported by respondInternalError.
+func respondRepositoryError(w http.ResponseWriter, err error, resource, failure string) {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		respondError(w, http.StatusNotFound, "not_found", resource+" not found")
+	case errors.Is(err, repository.ErrDuplicate):
+		respondError(w, http.StatusConflict, strings.ToLower(resource)+"_exists", resource+" already exists")
+	case errors.Is(err, repository.ErrConflict):
+		respondError(w, http.StatusConflict, "conflict", resource+" was changed by another request")
+	default:
+		respondInternalError(w, err, failure)
+	}
+}
+
+// NotFound answers requests for paths no route matches
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	respondError(w, http.StatusNotFound, "not_found", "No such endpoint")
+}
+
+// MethodNotAllowed answers requests whose path has no route for the method
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	respondError(w, http.StatusMethodNotAllowed, "invalid_request", "Method not allowed")
+}
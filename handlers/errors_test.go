@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"oauth2-server/middleware"
+	"oauth2-server/models"
+	"oauth2-server/repository"
+	"oauth2-server/utils"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRespondError_Localized(t *testing.T) {
+	handler := middleware.LocaleMiddleware(utils.GlobalMessageCatalog)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		respondError(w, http.StatusNotFound, "not_found", "Client not found")
+	}))
+
+	tests := []struct {
+		name           string
+		target         string
+		acceptLanguage string
+		expected       string
+	}{
+		{"default", "/", "", "Client not found"},
+		{"accept-language", "/", "th-TH,th;q=0.9,en;q=0.8", "ไม่พบไคลเอนต์"},
+		{"ui_locales wins", "/?ui_locales=en", "th", "Client not found"},
+		{"unsupported locale", "/", "de", "Client not found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.acceptLanguage != "" {
+				req.Header.Set("Accept-Language", tt.acceptLanguage)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			var resp models.ErrorResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if resp.Error != "not_found" {
+				t.Errorf("Expected error code to stay untranslated, got %q", resp.Error)
+			}
+			if resp.ErrorDescription != tt.expected {
+				t.Errorf("Expected description %q, got %q", tt.expected, resp.ErrorDescription)
+			}
+		})
+	}
+}
+
+func TestRespondRepositoryError(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{"not found", repository.ErrNotFound, http.StatusNotFound, "not_found"},
+		{"duplicate", repository.ErrDuplicate, http.StatusConflict, "scope_exists"},
+		{"wrapped duplicate", repository.ErrScopeExists, http.StatusConflict, "scope_exists"},
+		{"conflict", repository.ErrConflict, http.StatusConflict, "conflict"},
+		{"unavailable", fmt.Errorf("%w: timeout", repository.ErrUnavailable), http.StatusServiceUnavailable, "temporarily_unavailable"},
+		{"other", errors.New("connection refused"), http.StatusInternalServerError, "server_error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			respondRepositoryError(w, tt.err, "Scope", "Failed to create scope")
+
+			var resp models.ErrorResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if w.Code != tt.status || resp.Error != tt.code {
+				t.Errorf("Expected %d %s, got %d %s", tt.status, tt.code, w.Code, resp.Error)
+			}
+		})
+	}
+}
+
+func TestRespondInternalError(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{"unexpected", errors.New("mongo: connection 10.0.0.5:27017 refused"), http.StatusInternalServerError, "server_error"},
+		{"deadline", fmt.Errorf("find user: %w", context.DeadlineExceeded), http.StatusServiceUnavailable, "temporarily_unavailable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logged.Reset()
+			w := httptest.NewRecorder()
+			w.Header().Set(middleware.TransactionIDHeader, "tx-123")
+			respondInternalError(w, tt.err, "Failed to find user")
+
+			if w.Code != tt.status || strings.Contains(w.Body.String(), tt.err.Error()) {
+				t.Errorf("Expected %d without the error details, got %d %s", tt.status, w.Code, w.Body.String())
+			}
+			var resp models.ErrorResponse
+			json.NewDecoder(w.Body).Decode(&resp)
+			if resp.Error != tt.code {
+				t.Errorf("Expected %s, got %s", tt.code, resp.Error)
+			}
+			if !strings.Contains(logged.String(), "[tx-123]") || !strings.Contains(logged.String(), tt.err.Error()) {
+				t.Errorf("Expected the error logged with the transaction ID, got %q", logged.String())
+			}
+		})
+	}
+}
+
+func TestErrorURI(t *testing.T) {
+	var params url.Values
+	handler := middleware.ErrorURIMiddleware("https://docs.example.com/errors")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params = authorizationError(w, "access_denied", "User denied consent", "")
+		respondError(w, http.StatusBadRequest, "invalid_request", "Missing session_id")
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/oauth/token", nil))
+
+	var resp models.ErrorResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.ErrorURI != "https://docs.example.com/errors#invalid_request" {
+		t.Errorf("Expected error_uri for the code, got %q", resp.ErrorURI)
+	}
+	if params.Get("error_uri") != "https://docs.example.com/errors#access_denied" {
+		t.Errorf("Expected error_uri in redirected errors, got %v", params)
+	}
+
+	if params := authorizationError(httptest.NewRecorder(), "access_denied", "User denied consent", ""); params.Has("error_uri") {
+		t.Errorf("Expected no error_uri without ERROR_URI_BASE, got %v", params)
+	}
+}
@@ -0,0 +1,293 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"oauth2-server/config"
+	"oauth2-server/federation"
+	"oauth2-server/models"
+	"oauth2-server/repository"
+	"oauth2-server/utils"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// FederationCookieName binds a federated login to the browser that started it
+const FederationCookieName = "oauth_federation"
+
+// federationStateTTL is how long the user has to sign in upstream
+const federationStateTTL = 10 * time.Minute
+
+// IdentityProviderLink is an upstream provider offered on a login page
+type IdentityProviderLink struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	StartURL    string `json:"start_url"`
+}
+
+// FederationHandler signs users in through an upstream identity provider.
+// The external identity is linked to a local user, who gets an SSO session
+// as after a password login, and the pending authorization request carries
+// on as if the user had signed in locally.
+type FederationHandler struct {
+	auth      *AuthHandler
+	stateRepo *repository.StateRepository
+	secret    []byte
+	config    *config.Config
+}
+
+// NewFederationHandler creates a federation handler; secret signs the state
+// sent upstream
+func NewFederationHandler(
+	auth *AuthHandler,
+	stateRepo *repository.StateRepository,
+	secret []byte,
+	cfg *config.Config,
+) *FederationHandler {
+	return &FederationHandler{
+		auth:      auth,
+		stateRepo: stateRepo,
+		secret:    secret,
+		config:    cfg,
+	}
+}
+
+// Start sends the user agent to the provider's authorization endpoint
+// GET /auth/federated/{provider}/start?login_challenge=...
+func (h *FederationHandler) Start(w http.ResponseWriter, r *http.Request) {
+	provider, ok := h.provider(w, r)
+	if !ok {
+		return
+	}
+
+	// The state carries the login challenge; the PKCE verifier and
+	// nonce are derived from it with the secret so nothing is stored
+	state, payload, err := utils.GenerateSignedState(h.secret, federationStateClient(provider), r.URL.Query().Get("login_challenge"), federationStateTTL)
+	if err != nil {
+		respondInternalError(w, err, "Failed to generate state")
+		return
+	}
+	verifier, nonce := h.derive(payload.ID)
+
+	location, err := provider.AuthCodeURL(r.Context(), h.callbackURL(provider), state, nonce, utils.GenerateCodeChallenge(verifier, "S256"))
+	if err != nil {
+		log.Printf("Failed to start login with %s: %v", provider.Name, err)
+		respondError(w, http.StatusBadGateway, "temporarily_unavailable", "The identity provider is unavailable")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     FederationCookieName,
+		Value:    payload.ID,
+		Path:     "/auth/federated/",
+		MaxAge:   int(federationStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   SSOCookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, location, http.StatusFound)
+}
+
+// Callback completes the upstream login, signs the linked user in and
+// continues the pending authorization request
+// GET /auth/federated/{provider}/callback?code=...&state=...
+func (h *FederationHandler) Callback(w http.ResponseWriter, r *http.Request) {
+	provider, ok := h.provider(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	ctx := r.Context()
+
+	payload, err := utils.VerifySignedState(h.secret, query.Get("state"), federationStateClient(provider))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_request", "Invalid or expired state")
+		return
+	}
+	cookie, err := r.Cookie(FederationCookieName)
+	if err != nil || !hmac.Equal([]byte(cookie.Value), []byte(payload.ID)) {
+		respondError(w, http.StatusBadRequest, "invalid_request", "The login was started in another browser")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: FederationCookieName, Path: "/auth/federated/", MaxAge: -1})
+
+	if err := h.stateRepo.MarkUsed(ctx, payload.ID, federationStateClient(provider), time.Unix(payload.Exp, 0)); err != nil {
+		if errors.Is(err, repository.ErrStateReused) {
+			respondError(w, http.StatusBadRequest, "invalid_request", "State has already been used")
+			return
+		}
+		respondInternalError(w, err, "Failed to record state")
+		return
+	}
+	challenge := payload.Data
+
+	// The user cancelled or the provider refused; offer the login page again
+	if query.Get("error") != "" {
+		http.Redirect(w, r, loginURL(h.config, challenge), http.StatusFound)
+		return
+	}
+
+	verifier, nonce := h.derive(payload.ID)
+	identity, err := provider.Exchange(ctx, query.Get("code"), h.callbackURL(provider), verifier, nonce)
+	if err != nil {
+		log.Printf("Failed to complete login with %s: %v", provider.Name, err)
+		h.auth.audit.record(r, models.AuditLoginFailure, "", "", map[string]string{"provider": provider.Name, "reason": "federation_failed"})
+		respondError(w, http.StatusBadGateway, "server_error", "Login with the identity provider failed")
+		return
+	}
+
+	user, ok := h.linkedUser(w, r, provider, identity)
+	if !ok {
+		return
+	}
+	if !h.auth.canSignIn(w, r, user) {
+		return
+	}
+	if _, ok := h.auth.startSSOSession(w, r, user, challenge); !ok {
+		return
+	}
+
+	// Send the user agent back through the authorization endpoint, which now
+	// finds the SSO session and asks for consent if needed
+	if location, ok := h.auth.continueAuthorization(ctx, challenge); ok {
+		http.Redirect(w, r, location, http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, "/account", http.StatusFound)
+}
+
+// linkedUser returns the local user for an external identity. An identity
+// seen before maps to the user it was linked to; otherwise it is linked to
+// the account with the same email if both the provider and the account
+// verified that address, or, when the provider allows it, to a newly created
+// account.
+func (h *FederationHandler) linkedUser(w http.ResponseWriter, r *http.Request, provider *federation.Provider, identity *federation.Identity) (*models.User, bool) {
+	ctx := r.Context()
+	userRepo := h.auth.userRepo
+
+	user, err := userRepo.FindByIdentity(ctx, identity.Provider, identity.Subject)
+	if err == nil {
+		return user, true
+	}
+	if !errors.Is(err, repository.ErrNotFound) {
+		respondInternalError(w, err, "Failed to look up user")
+		return nil, false
+	}
+
+	link := models.ExternalIdentity{
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+	}
+
+	// Both sides must have verified the address: otherwise whoever
+	// registered it first, locally or at the provider, would take over the
+	// other's account
+	if identity.Email != "" && identity.EmailVerified {
+		user, err := userRepo.FindByEmail(ctx, identity.Email)
+		if err == nil && !user.EmailVerified {
+			h.auth.audit.record(r, models.AuditLoginFailure, user.ID, "", map[string]string{"provider": provider.Name, "reason": "unverified_local_email"})
+			respondError(w, http.StatusConflict, "account_exists", "An account with this email already exists; sign in with your password and verify your email before using this provider")
+			return nil, false
+		}
+		if err == nil {
+			if err := userRepo.LinkIdentity(ctx, user.ID, link); err != nil {
+				respondInternalError(w, err, "Failed to link identity")
+				return nil, false
+			}
+			h.auth.audit.record(r, models.AuditIdentityLinked, user.ID, "", map[string]string{"provider": provider.Name})
+			return user, true
+		}
+		if !errors.Is(err, repository.ErrNotFound) {
+			respondInternalError(w, err, "Failed to look up user")
+			return nil, false
+		}
+	}
+
+	if !provider.AutoProvision {
+		h.auth.audit.record(r, models.AuditLoginFailure, "", "", map[string]string{"provider": provider.Name, "reason": "unknown_identity"})
+		respondError(w, http.StatusForbidden, "access_denied", "No account is linked to this identity")
+		return nil, false
+	}
+	if identity.Email == "" {
+		respondError(w, http.StatusBadRequest, "invalid_request", "The identity provider did not share an email address")
+		return nil, false
+	}
+
+	name := identity.Name
+	if name == "" {
+		name = identity.Email
+	}
+	link.LinkedAt = time.Now()
+	user = &models.User{
+		Email:         identity.Email,
+		Name:          name,
+		EmailVerified: identity.EmailVerified,
+		Identities:    []models.ExternalIdentity{link},
+	}
+	if err := userRepo.Create(ctx, user); err != nil {
+		// An unverified address is never linked to the account holding it
+		if errors.Is(err, repository.ErrDuplicate) {
+			respondError(w, http.StatusConflict, "account_exists", "An account with this email already exists; sign in with your password")
+			return nil, false
+		}
+		respondInternalError(w, err, "Failed to create user")
+		return nil, false
+	}
+	h.auth.audit.record(r, models.AuditUserCreated, user.ID, "", map[string]string{"flow": "federation", "provider": provider.Name})
+	return user, true
+}
+
+func (h *FederationHandler) provider(w http.ResponseWriter, r *http.Request) (*federation.Provider, bool) {
+	provider := h.auth.providers.Get(mux.Vars(r)["provider"])
+	if provider == nil {
+		respondError(w, http.StatusNotFound, "invalid_request", "Unknown identity provider")
+		return nil, false
+	}
+	return provider, true
+}
+
+func (h *FederationHandler) callbackURL(provider *federation.Provider) string {
+	return h.config.PublicURL + "/auth/federated/" + provider.Name + "/callback"
+}
+
+// derive returns the PKCE verifier and nonce for a state ID. They are keyed
+// by the secret, so seeing the state upstream does not reveal them.
+func (h *FederationHandler) derive(stateID string) (verifier, nonce string) {
+	mac := func(label string) string {
+		m := hmac.New(sha256.New, h.secret)
+		m.Write([]byte(label + "." + stateID))
+		return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+	}
+	return mac("pkce"), mac("nonce")
+}
+
+// federationStateClient scopes federation states to their provider so they
+// cannot be redeemed as client states
+func federationStateClient(provider *federation.Provider) string {
+	return "federation:" + provider.Name
+}
+
+// identityProviderLinks lists the configured providers with start URLs that
+// continue the authorization request the login challenge names
+func (h *AuthHandler) identityProviderLinks(challenge string) []IdentityProviderLink {
+	links := make([]IdentityProviderLink, 0, len(h.providers))
+	for _, provider := range h.providers {
+		start := h.config.PublicURL + "/auth/federated/" + provider.Name + "/start"
+		if challenge != "" {
+			start += "?" + url.Values{"login_challenge": {challenge}}.Encode()
+		}
+		links = append(links, IdentityProviderLink{
+			Name:        provider.Name,
+			DisplayName: provider.DisplayName,
+			StartURL:    start,
+		})
+	}
+	return links
+}
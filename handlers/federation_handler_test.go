@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"oauth2-server/config"
+	"oauth2-server/federation"
+	"oauth2-server/utils"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func authWithIdentityProviders(providers federation.Providers, cfg *config.Config) *AuthHandler {
+	return NewAuthHandler(nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, NewTemplateRenderer(false, "", ""), nil, nil, providers, cfg)
+}
+
+func TestFederationStart(t *testing.T) {
+	cfg := &config.Config{PublicURL: "https://op.example.com"}
+	auth := authWithIdentityProviders(federation.Providers{{
+		Name:                  "github",
+		ClientID:              "client-1",
+		AuthorizationEndpoint: "https://github.example.com/login/oauth/authorize",
+		TokenEndpoint:         "https://github.example.com/login/oauth/access_token",
+		UserInfoEndpoint:      "https://api.github.example.com/user",
+	}}, cfg)
+	secret := []byte("test-secret")
+	h := NewFederationHandler(auth, nil, secret, cfg)
+
+	req := mux.SetURLVars(httptest.NewRequest("GET", "/auth/federated/github/start?login_challenge=challenge-1", nil), map[string]string{"provider": "github"})
+	w := httptest.NewRecorder()
+	h.Start(w, req)
+
+	if w.Code != http.StatusFound {
+		t.Fatalf("Expected a redirect, got %d: %s", w.Code, w.Body.String())
+	}
+	location, _ := url.Parse(w.Header().Get("Location"))
+	query := location.Query()
+	if location.Host != "github.example.com" || query.Get("redirect_uri") != "https://op.example.com/auth/federated/github/callback" {
+		t.Errorf("Unexpected authorization URL %s", location)
+	}
+
+	state, err := utils.VerifySignedState(secret, query.Get("state"), "federation:github")
+	if err != nil || state.Data != "challenge-1" {
+		t.Fatalf("Expected a signed state carrying the login challenge, got %+v, %v", state, err)
+	}
+	verifier, _ := h.derive(state.ID)
+	if query.Get("code_challenge") != utils.GenerateCodeChallenge(verifier, "S256") {
+		t.Error("Expected the PKCE challenge of the derived verifier")
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != FederationCookieName || cookies[0].Value != state.ID || !cookies[0].HttpOnly {
+		t.Errorf("Expected the state bound to the browser, got %v", cookies)
+	}
+}
+
+func TestFederationCallback_RejectsBadRequests(t *testing.T) {
+	auth := authWithIdentityProviders(federation.Providers{{Name: "google", Issuer: "https://accounts.example.com", ClientID: "client-1"}}, &config.Config{})
+	secret := []byte("test-secret")
+	h := NewFederationHandler(auth, nil, secret, &config.Config{})
+
+	state, payload, err := utils.GenerateSignedState(secret, "federation:google", "", federationStateTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientState, _, _ := utils.GenerateSignedState(secret, "client-1", "", federationStateTTL)
+
+	tests := []struct {
+		name     string
+		provider string
+		state    string
+		cookie   string
+		expected int
+	}{
+		{"Unknown provider", "other", state, payload.ID, http.StatusNotFound},
+		{"Missing state", "google", "", payload.ID, http.StatusBadRequest},
+		{"State issued to a client", "google", clientState, payload.ID, http.StatusBadRequest},
+		{"Started in another browser", "google", state, "other", http.StatusBadRequest},
+		{"No browser cookie", "google", state, "", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/auth/federated/"+tt.provider+"/callback?code=c&state="+url.QueryEscape(tt.state), nil)
+			req = mux.SetURLVars(req, map[string]string{"provider": tt.provider})
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: FederationCookieName, Value: tt.cookie})
+			}
+			w := httptest.NewRecorder()
+			h.Callback(w, req)
+
+			if w.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d: %s", tt.expected, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestIdentityProviderLinks(t *testing.T) {
+	auth := authWithIdentityProviders(federation.Providers{{Name: "google", DisplayName: "Google"}}, &config.Config{PublicURL: "https://op.example.com"})
+
+	links := auth.identityProviderLinks("a b")
+	if len(links) != 1 || links[0].DisplayName != "Google" || links[0].StartURL != "https://op.example.com/auth/federated/google/start?login_challenge=a+b" {
+		t.Errorf("Unexpected links %+v", links)
+	}
+}
@@ -0,0 +1,83 @@
+//go:build integration
+// +build integration
+
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"oauth2-server/config"
+	"oauth2-server/federation"
+	"oauth2-server/models"
+	"oauth2-server/repository"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TestLinkedUser_EmailMatch tests that an external identity is only linked
+// by email to a local account that verified the address
+func TestLinkedUser_EmailMatch(t *testing.T) {
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI("mongodb://localhost:27017"))
+	if err != nil || client.Ping(ctx, nil) != nil {
+		t.Skip("MongoDB not available, skipping integration test")
+		return
+	}
+	defer client.Disconnect(ctx)
+
+	db := client.Database("oauth2_test_federation_link")
+	defer db.Drop(ctx)
+
+	userRepo := repository.NewUserRepository(db)
+	auth := NewAuthHandler(userRepo, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, NewTemplateRenderer(false, "", ""), nil, nil, nil, &config.Config{})
+	h := NewFederationHandler(auth, nil, []byte("secret"), &config.Config{})
+	provider := &federation.Provider{Name: "google", AutoProvision: true}
+
+	for _, local := range []*models.User{
+		{ID: "unverified-user", Email: "unverified@example.com", Name: "Unverified", Password: "hashed_password", CreatedAt: time.Now()},
+		{ID: "verified-user", Email: "verified@example.com", Name: "Verified", Password: "hashed_password", EmailVerified: true, CreatedAt: time.Now()},
+	} {
+		if err := userRepo.Create(ctx, local); err != nil {
+			t.Fatalf("Failed to create test user: %v", err)
+		}
+	}
+
+	t.Run("Unverified local account is not linked", func(t *testing.T) {
+		identity := &federation.Identity{Provider: "google", Subject: "sub-1", Email: "unverified@example.com", EmailVerified: true}
+		w := httptest.NewRecorder()
+		user, ok := h.linkedUser(w, httptest.NewRequest("GET", "/", nil), provider, identity)
+		if ok || user != nil {
+			t.Fatalf("Expected no user, got %+v", user)
+		}
+		if w.Code != http.StatusConflict {
+			t.Errorf("Expected status 409, got %d", w.Code)
+		}
+
+		local, err := userRepo.FindByID(ctx, "unverified-user")
+		if err != nil {
+			t.Fatalf("Failed to load user: %v", err)
+		}
+		if len(local.Identities) != 0 {
+			t.Errorf("Expected no linked identity, got %+v", local.Identities)
+		}
+		if _, err := userRepo.FindByIdentity(ctx, "google", "sub-1"); err == nil {
+			t.Error("Expected the identity to stay unlinked")
+		}
+	})
+
+	t.Run("Verified local account is linked", func(t *testing.T) {
+		identity := &federation.Identity{Provider: "google", Subject: "sub-2", Email: "verified@example.com", EmailVerified: true}
+		w := httptest.NewRecorder()
+		user, ok := h.linkedUser(w, httptest.NewRequest("GET", "/", nil), provider, identity)
+		if !ok || user.ID != "verified-user" {
+			t.Fatalf("Expected verified-user, got %+v (status %d)", user, w.Code)
+		}
+		if linked, err := userRepo.FindByIdentity(ctx, "google", "sub-2"); err != nil || linked.ID != "verified-user" {
+			t.Errorf("Expected the identity to be linked to verified-user, got %v", err)
+		}
+	})
+}
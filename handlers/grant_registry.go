@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// GrantHandler handles a token request for a single grant_type
+type GrantHandler interface {
+	HandleGrant(w http.ResponseWriter, r *http.Request)
+}
+
+// GrantHandlerFunc adapts an ordinary function to the GrantHandler interface
+type GrantHandlerFunc func(w http.ResponseWriter, r *http.Request)
+
+// HandleGrant calls f(w, r)
+func (f GrantHandlerFunc) HandleGrant(w http.ResponseWriter, r *http.Request) {
+	f(w, r)
+}
+
+// GrantRegistry maps grant_type values to their handlers so new grants
+// (device code, JWT bearer, CIBA, ...) can be plugged into the token endpoint
+// without modifying OAuthHandler.Token
+type GrantRegistry struct {
+	mu       sync.RWMutex
+	handlers map[string]GrantHandler
+}
+
+// NewGrantRegistry creates an empty grant registry
+func NewGrantRegistry() *GrantRegistry {
+	return &GrantRegistry{
+		handlers: make(map[string]GrantHandler),
+	}
+}
+
+// Register adds or replaces the handler for a grant type
+func (g *GrantRegistry) Register(grantType string, handler GrantHandler) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.handlers[grantType] = handler
+}
+
+// Get returns the handler registered for a grant type
+func (g *GrantRegistry) Get(grantType string) (GrantHandler, bool) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	handler, exists := g.handlers[grantType]
+	return handler, exists
+}
+
+// GrantTypes returns all registered grant types in sorted order
+func (g *GrantRegistry) GrantTypes() []string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	grantTypes := make([]string, 0, len(g.handlers))
+	for grantType := range g.handlers {
+		grantTypes = append(grantTypes, grantType)
+	}
+	sort.Strings(grantTypes)
+	return grantTypes
+}
+
+// ServeToken dispatches a parsed token request to the handler for its
+// grant_type, responding with a standard error when none is registered
+func (g *GrantRegistry) ServeToken(w http.ResponseWriter, r *http.Request) {
+	grantType := r.FormValue("grant_type")
+	if grantType == "" {
+		respondError(w, http.StatusBadRequest, "invalid_request", "Missing grant_type parameter")
+		return
+	}
+
+	handler, exists := g.Get(grantType)
+	if !exists {
+		respondError(w, http.StatusBadRequest, "unsupported_grant_type", "Grant type not supported")
+		return
+	}
+
+	handler.HandleGrant(w, r)
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"oauth2-server/models"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newTokenRequest(form url.Values) *http.Request {
+	req := httptest.NewRequest("POST", "/oauth/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.ParseForm()
+	return req
+}
+
+func TestGrantRegistry_Dispatch(t *testing.T) {
+	registry := NewGrantRegistry()
+
+	called := ""
+	registry.Register("custom_grant", GrantHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = r.FormValue("grant_type")
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	w := httptest.NewRecorder()
+	registry.ServeToken(w, newTokenRequest(url.Values{"grant_type": {"custom_grant"}}))
+
+	if called != "custom_grant" {
+		t.Errorf("Expected custom_grant handler to be called, got %q", called)
+	}
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", w.Code)
+	}
+}
+
+func TestGrantRegistry_Errors(t *testing.T) {
+	registry := NewGrantRegistry()
+
+	tests := []struct {
+		name          string
+		grantType     string
+		expectedError string
+	}{
+		{"Missing grant type", "", "invalid_request"},
+		{"Unregistered grant type", "urn:ietf:params:oauth:grant-type:device_code", "unsupported_grant_type"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			registry.ServeToken(w, newTokenRequest(url.Values{"grant_type": {tt.grantType}}))
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("Expected status 400, got %d", w.Code)
+			}
+
+			var errResp models.ErrorResponse
+			if err := json.NewDecoder(w.Body).Decode(&errResp); err != nil {
+				t.Fatalf("Failed to decode error response: %v", err)
+			}
+			if errResp.Error != tt.expectedError {
+				t.Errorf("Expected error %q, got %q", tt.expectedError, errResp.Error)
+			}
+		})
+	}
+}
+
+func TestGrantRegistry_GrantTypes(t *testing.T) {
+	registry := NewGrantRegistry()
+	noop := GrantHandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	registry.Register("refresh_token", noop)
+	registry.Register("authorization_code", noop)
+
+	expected := []string{"authorization_code", "refresh_token"}
+	if got := registry.GrantTypes(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected grant types %v, got %v", expected, got)
+	}
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"oauth2-server/config"
+	"oauth2-server/models"
+	"oauth2-server/utils"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestBuildIDToken(t *testing.T) {
+	privateKey, err := utils.GenerateRSAKeyPair(2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	cfg := &config.Config{PrivateKey: privateKey, AccessTokenExpiry: 3600}
+	user := &models.User{ID: "user-1", Email: "user@example.com", Name: "User"}
+	client := &models.Client{ClientID: "client-1"}
+	authTime := time.Unix(1700000000, 0)
+
+	parse := func(t *testing.T, token string) jwt.MapClaims {
+		t.Helper()
+		claims := jwt.MapClaims{}
+		if _, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) { return &privateKey.PublicKey, nil }); err != nil {
+			t.Fatalf("Failed to parse ID token: %v", err)
+		}
+		return claims
+	}
+
+	t.Run("Authorization code", func(t *testing.T) {
+		token, err := buildIDToken(&idTokenRequest{User: user, Client: client, Scope: "openid email", Nonce: "n-1", AuthTime: authTime}, cfg)
+		if err != nil {
+			t.Fatalf("Failed to build ID token: %v", err)
+		}
+		claims := parse(t, token)
+		if claims["nonce"] != "n-1" || claims["email"] != "user@example.com" || claims["aud"] != "client-1" {
+			t.Errorf("Unexpected claims %v", claims)
+		}
+		if claims["auth_time"] != float64(authTime.Unix()) {
+			t.Errorf("Expected auth_time %d, got %v", authTime.Unix(), claims["auth_time"])
+		}
+	})
+
+	t.Run("Refresh", func(t *testing.T) {
+		token, err := buildIDToken(&idTokenRequest{User: user, Client: client, Scope: "openid email", AuthTime: authTime}, cfg)
+		if err != nil {
+			t.Fatalf("Failed to build ID token: %v", err)
+		}
+		claims := parse(t, token)
+		if _, ok := claims["nonce"]; ok {
+			t.Error("Expected no nonce on a refreshed ID token")
+		}
+		if claims["auth_time"] != float64(authTime.Unix()) {
+			t.Errorf("Expected the original auth_time, got %v", claims["auth_time"])
+		}
+		if iat, _ := claims.GetIssuedAt(); iat == nil || time.Since(iat.Time) > time.Minute {
+			t.Errorf("Expected a fresh iat, got %v", iat)
+		}
+	})
+
+	t.Run("Claims request", func(t *testing.T) {
+		token, err := buildIDToken(&idTokenRequest{User: user, Client: client, Scope: "openid email profile", AuthTime: authTime, Claims: []string{"name"}}, cfg)
+		if err != nil {
+			t.Fatalf("Failed to build ID token: %v", err)
+		}
+		claims := parse(t, token)
+		if _, ok := claims["email"]; ok {
+			t.Error("Expected claims outside the request to be left out")
+		}
+		if claims["name"] != "User" || claims["auth_time"] == nil {
+			t.Errorf("Unexpected claims %v", claims)
+		}
+	})
+
+	t.Run("Step-up", func(t *testing.T) {
+		token, err := buildIDToken(&idTokenRequest{User: user, Client: client, Scope: "openid", AuthTime: authTime, ACR: ACRMFA}, cfg)
+		if err != nil {
+			t.Fatalf("Failed to build ID token: %v", err)
+		}
+		if acr := parse(t, token)["acr"]; acr != ACRMFA {
+			t.Errorf("Expected acr %q, got %v", ACRMFA, acr)
+		}
+	})
+
+	t.Run("Unknown auth time", func(t *testing.T) {
+		token, err := buildIDToken(&idTokenRequest{User: user, Client: client, Scope: "openid"}, cfg)
+		if err != nil {
+			t.Fatalf("Failed to build ID token: %v", err)
+		}
+		if _, ok := parse(t, token)["auth_time"]; ok {
+			t.Error("Expected no auth_time when it is unknown")
+		}
+		if _, ok := parse(t, token)["acr"]; ok {
+			t.Error("Expected no acr without a second factor")
+		}
+	})
+
+	if authTimeOf(authTimeUnix(authTime)) != authTime || !authTimeOf(authTimeUnix(time.Time{})).IsZero() {
+		t.Error("Expected auth times to round-trip through unix seconds")
+	}
+}
@@ -2,37 +2,70 @@ package handlers
 
 import (
 	"crypto/rsa"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"math/big"
 	"net/http"
+	"oauth2-server/models"
+	"time"
 )
 
+// JWKSHandler publishes the signing key and the encryption key. The key set
+// only changes when the server restarts with a new key, so it is serialized
+// once.
 type JWKSHandler struct {
-	publicKey *rsa.PublicKey
+	publicKey       *rsa.PublicKey
+	keyID           string
+	encryptionKey   *rsa.PublicKey
+	encryptionKeyID string
+	maxAge          int64
+	doc             *cachedDocument
 }
 
-func NewJWKSHandler(publicKey *rsa.PublicKey) *JWKSHandler {
-	return &JWKSHandler{
-		publicKey: publicKey,
+// NewJWKSHandler publishes the signing key under keyID, the kid sent in the
+// header of issued tokens, and the encryption key, when not nil, under
+// encryptionKeyID. Responses may be cached for maxAge seconds.
+func NewJWKSHandler(publicKey *rsa.PublicKey, keyID string, encryptionKey *rsa.PublicKey, encryptionKeyID string, maxAge int64) *JWKSHandler {
+	h := &JWKSHandler{
+		publicKey:       publicKey,
+		keyID:           keyID,
+		encryptionKey:   encryptionKey,
+		encryptionKeyID: encryptionKeyID,
+		maxAge:          maxAge,
 	}
+
+	body, _ := json.Marshal(h.KeySet())
+	sum := sha256.Sum256(body)
+	h.doc = &cachedDocument{
+		body:       append(body, '\n'),
+		etag:       `"` + hex.EncodeToString(sum[:8]) + `"`,
+		modifiedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	return h
 }
 
 func (h *JWKSHandler) JWKS(w http.ResponseWriter, r *http.Request) {
-	n := base64.RawURLEncoding.EncodeToString(h.publicKey.N.Bytes())
-	e := base64.RawURLEncoding.EncodeToString(big.NewInt(int64(h.publicKey.E)).Bytes())
+	serveCached(w, r, h.doc, h.maxAge)
+}
 
-	jwks := map[string]interface{}{
-		"keys": []map[string]interface{}{
-			{
-				"kty": "RSA",
-				"use": "sig",
-				"alg": "RS256",
-				"kid": "1",
-				"n":   n,
-				"e":   e,
-			},
-		},
+// KeySet returns the published keys
+func (h *JWKSHandler) KeySet() models.JSONWebKeySet {
+	keys := []models.JSONWebKey{rsaJWK(h.publicKey, h.keyID, "sig", "RS256")}
+	if h.encryptionKey != nil {
+		keys = append(keys, rsaJWK(h.encryptionKey, h.encryptionKeyID, "enc", "RSA-OAEP-256"))
 	}
+	return models.JSONWebKeySet{Keys: keys}
+}
 
-	respondJSON(w, http.StatusOK, jwks)
+func rsaJWK(publicKey *rsa.PublicKey, kid, use, alg string) models.JSONWebKey {
+	return models.JSONWebKey{
+		Kty: "RSA",
+		Use: use,
+		Alg: alg,
+		Kid: kid,
+		N:   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+	}
 }
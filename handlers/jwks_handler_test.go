@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestJWKSHandler(t testing.TB) *JWKSHandler {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	return NewJWKSHandler(&key.PublicKey, "kid-1", nil, "", 300)
+}
+
+func TestJWKSHandler_Caching(t *testing.T) {
+	handler := newTestJWKSHandler(t)
+
+	w := httptest.NewRecorder()
+	handler.JWKS(w, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	var jwks struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &jwks); err != nil || len(jwks.Keys) != 1 || jwks.Keys[0]["kid"] != "kid-1" {
+		t.Fatalf("Unexpected key set: %s", w.Body.String())
+	}
+	if w.Header().Get("Cache-Control") != "public, max-age=300" {
+		t.Errorf("Unexpected Cache-Control: %q", w.Header().Get("Cache-Control"))
+	}
+
+	etag := w.Header().Get("ETag")
+	req := httptest.NewRequest("GET", "/.well-known/jwks.json", nil)
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	handler.JWKS(w, req)
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("Expected an empty 304 for a current ETag, got %d", w.Code)
+	}
+}
+
+func TestJWKSHandler_EncryptionKey(t *testing.T) {
+	signingKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	encryptionKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	handler := NewJWKSHandler(&signingKey.PublicKey, "sig-1", &encryptionKey.PublicKey, "enc-1", 300)
+
+	keys := handler.KeySet().Keys
+	if len(keys) != 2 {
+		t.Fatalf("Expected the signing and encryption keys, got %d keys", len(keys))
+	}
+	if keys[0].Kid != "sig-1" || keys[0].Use != "sig" {
+		t.Errorf("Expected the signing key first, got %+v", keys[0])
+	}
+	if keys[1].Kid != "enc-1" || keys[1].Use != "enc" || keys[1].Alg != "RSA-OAEP-256" {
+		t.Errorf("Expected the encryption key with use enc, got %+v", keys[1])
+	}
+}
+
+func BenchmarkJWKSHandler(b *testing.B) {
+	handler := newTestJWKSHandler(b)
+	req := httptest.NewRequest("GET", "/.well-known/jwks.json", nil)
+
+	b.Run("cached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			handler.JWKS(httptest.NewRecorder(), req)
+		}
+	})
+	// What every request cost before the key set was serialized once
+	b.Run("rebuilt", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			respondJSON(httptest.NewRecorder(), http.StatusOK, handler.KeySet())
+		}
+	})
+}
@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"oauth2-server/middleware"
+	"oauth2-server/models"
+	"oauth2-server/repository"
+	"oauth2-server/utils"
+	"strings"
+	"time"
+)
+
+// ACRMFA is the acr_values a client sends to require a second factor even
+// when the user already has an SSO session (step-up authentication), and
+// the acr of ID tokens issued once the user completed one
+const ACRMFA = "mfa"
+
+// TrustedDeviceCookieName holds the token of a browser remembered after MFA
+const TrustedDeviceCookieName = "oauth_trusted_device"
+
+// One-time codes emailed for step-up authentication
+const (
+	mfaCodeExpiry      = 10 * time.Minute
+	mfaCodeMaxAttempts = 5
+)
+
+// requestsMFA reports whether the space separated acr_values ask for a
+// second factor. Other values are voluntary claims and are ignored.
+func requestsMFA(acrValues string) bool {
+	for _, value := range strings.Fields(acrValues) {
+		if value == ACRMFA {
+			return true
+		}
+	}
+	return false
+}
+
+// sessionACR is the acr of codes issued in the SSO session
+func sessionACR(ssoSession *models.SSOSession) string {
+	if ssoSession != nil && !ssoSession.MFAAt.IsZero() {
+		return ACRMFA
+	}
+	return ""
+}
+
+// secretHash is how MFA codes and remember-device tokens are stored
+func secretHash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// trustedDevice returns the remembered browser the request's cookie names
+// when it was remembered for userID. Without a store no browser is
+// remembered.
+func trustedDevice(r *http.Request, devices *repository.TrustedDeviceRepository, userID string) *models.TrustedDevice {
+	if devices == nil {
+		return nil
+	}
+	cookie, err := r.Cookie(TrustedDeviceCookieName)
+	if err != nil || cookie.Value == "" {
+		return nil
+	}
+	device, err := devices.FindByTokenHash(r.Context(), secretHash(cookie.Value))
+	if err != nil || device.UserID != userID {
+		return nil
+	}
+	return device
+}
+
+// startStepUp stores the authorization request for the signed-in user and
+// sends the user agent to the MFA challenge, which continues the request
+// once the user enters the emailed code
+func (h *OAuthHandler) startStepUp(w http.ResponseWriter, r *http.Request, request *models.AuthorizationRequest) {
+	challenge, err := utils.GenerateRandomString(32)
+	if err != nil {
+		respondInternalError(w, err, "Failed to generate MFA challenge")
+		return
+	}
+	request.Challenge = challenge
+	request.Stage = models.AuthorizationStageMFA
+	request.ExpiresAt = time.Now().Add(10 * time.Minute)
+
+	if err := h.authRequests.Create(r.Context(), request); err != nil {
+		respondInternalError(w, err, "Failed to create MFA challenge")
+		return
+	}
+	http.Redirect(w, r, "/auth/mfa?mfa_challenge="+url.QueryEscape(challenge), http.StatusFound)
+}
+
+// ShowMFA renders the MFA challenge of a pending authorization request and
+// emails the user a one-time code, unless one that can still be used was
+// already sent
+// GET /auth/mfa?mfa_challenge=...
+func (h *AuthHandler) ShowMFA(w http.ResponseWriter, r *http.Request) {
+	request, ssoSession, ok := h.pendingMFA(w, r, r.URL.Query().Get("mfa_challenge"))
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	user, err := h.userRepo.FindByID(ctx, ssoSession.UserID)
+	if err != nil {
+		respondRepositoryError(w, err, "User", "Failed to look up user")
+		return
+	}
+
+	if request.MFACodeHash == "" || !time.Now().Before(request.MFACodeExpiresAt) || request.MFAAttempts >= mfaCodeMaxAttempts {
+		code, err := mfaCode()
+		if err != nil {
+			respondInternalError(w, err, "Failed to generate verification code")
+			return
+		}
+		request.MFACodeHash = secretHash(code)
+		request.MFACodeExpiresAt = time.Now().Add(mfaCodeExpiry)
+		request.MFAAttempts = 0
+		if err := h.authRequests.Update(ctx, request); err != nil {
+			respondInternalError(w, err, "Failed to store verification code")
+			return
+		}
+		if err := h.mailer.Send(ctx, user.Email, "Your verification code",
+			fmt.Sprintf("Your verification code is %s. It expires in %d minutes.", code, int(mfaCodeExpiry.Minutes()))); err != nil {
+			respondInternalError(w, err, "Failed to send verification code")
+			return
+		}
+	}
+
+	data := map[string]interface{}{
+		"MFAChallenge":   request.Challenge,
+		"CSRFToken":      csrfToken(w, r),
+		"Email":          user.Email,
+		"RememberDevice": h.trustedDevices != nil && h.config.RememberDeviceExpiry > 0,
+	}
+	if client, err := h.clientRepo.FindByClientID(ctx, request.ClientID); err == nil {
+		data["ClientName"] = client.Name
+	}
+	h.templates.Render(w, "mfa.html", data)
+}
+
+// VerifyMFA checks the emailed code, marks the SSO session as having
+// completed MFA and answers with the authorization request to continue.
+// With remember_device the browser skips later challenges for
+// REMEMBER_DEVICE_EXPIRY seconds.
+// POST /auth/mfa
+func (h *AuthHandler) VerifyMFA(w http.ResponseWriter, r *http.Request) {
+	if !requireCSRF(w, r, h.config) {
+		return
+	}
+
+	var req struct {
+		MFAChallenge   string `json:"mfa_challenge"`
+		Code           string `json:"code"`
+		RememberDevice bool   `json:"remember_device"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+
+	request, ssoSession, ok := h.pendingMFA(w, r, req.MFAChallenge)
+	if !ok {
+		return
+	}
+	if request.MFACodeHash == "" || !time.Now().Before(request.MFACodeExpiresAt) || request.MFAAttempts >= mfaCodeMaxAttempts {
+		respondError(w, http.StatusBadRequest, "invalid_request", "The verification code has expired, reload the page to get a new one")
+		return
+	}
+
+	ctx := r.Context()
+	if !hmac.Equal([]byte(secretHash(strings.TrimSpace(req.Code))), []byte(request.MFACodeHash)) {
+		request.MFAAttempts++
+		if err := h.authRequests.Update(ctx, request); err != nil {
+			respondInternalError(w, err, "Failed to record verification attempt")
+			return
+		}
+		h.audit.record(r, models.AuditMFAFailure, ssoSession.UserID, request.ClientID, nil)
+		respondError(w, http.StatusUnauthorized, "invalid_code", "Invalid verification code")
+		return
+	}
+
+	if err := h.ssoSessionRepo.MarkMFA(ctx, ssoSession.SessionID, time.Now()); err != nil {
+		respondInternalError(w, err, "Failed to update SSO session")
+		return
+	}
+	if req.RememberDevice && h.trustedDevices != nil && h.config.RememberDeviceExpiry > 0 {
+		if err := h.rememberDevice(w, r, ssoSession.UserID); err != nil {
+			respondInternalError(w, err, "Failed to remember device")
+			return
+		}
+	}
+	h.authRequests.Delete(ctx, request.Challenge)
+	h.audit.record(r, models.AuditMFAVerified, ssoSession.UserID, request.ClientID, map[string]string{
+		"remember_device": fmt.Sprint(req.RememberDevice),
+	})
+
+	respondJSON(w, http.StatusOK, map[string]string{
+		"redirect_uri": h.config.PublicURL + "/oauth/authorize?" + authorizeParams(request).Encode(),
+	})
+}
+
+// pendingMFA loads the authorization request waiting for the signed-in user
+// to complete MFA
+func (h *AuthHandler) pendingMFA(w http.ResponseWriter, r *http.Request, challenge string) (*models.AuthorizationRequest, *models.SSOSession, bool) {
+	ssoSession, _ := r.Context().Value(middleware.SSOSessionContextKey).(*models.SSOSession)
+	if ssoSession == nil || !ssoSession.Authenticated {
+		respondError(w, http.StatusUnauthorized, "login_required", "Sign in before verifying")
+		return nil, nil, false
+	}
+	if challenge == "" {
+		respondError(w, http.StatusBadRequest, "invalid_request", "Missing mfa_challenge")
+		return nil, nil, false
+	}
+
+	request, err := h.authRequests.FindByChallenge(r.Context(), challenge)
+	if err != nil || !request.Pending(models.AuthorizationStageMFA, time.Now()) || request.UserID != ssoSession.UserID {
+		respondError(w, http.StatusNotFound, "invalid_request", "Unknown or expired verification request")
+		return nil, nil, false
+	}
+	return request, ssoSession, true
+}
+
+// rememberDevice sets the remember-device cookie and stores its hash
+func (h *AuthHandler) rememberDevice(w http.ResponseWriter, r *http.Request, userID string) error {
+	id, err := utils.GenerateRandomString(16)
+	if err != nil {
+		return err
+	}
+	token, err := utils.GenerateRandomString(32)
+	if err != nil {
+		return err
+	}
+
+	expiry := time.Duration(h.config.RememberDeviceExpiry) * time.Second
+	device := &models.TrustedDevice{
+		ID:        id,
+		UserID:    userID,
+		TokenHash: secretHash(token),
+		UserAgent: r.UserAgent(),
+		IPAddress: clientIP(r),
+		ExpiresAt: time.Now().Add(expiry),
+	}
+	if err := h.trustedDevices.Create(r.Context(), device); err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     TrustedDeviceCookieName,
+		Value:    token,
+		Path:     SSOCookiePath,
+		MaxAge:   int(expiry.Seconds()),
+		HttpOnly: SSOCookieHTTPOnly,
+		Secure:   SSOCookieSecure,
+		SameSite: SSOCookieSameSite,
+	})
+	return nil
+}
+
+// mfaCode returns a random six digit code
+func mfaCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"oauth2-server/config"
+	"oauth2-server/middleware"
+	"oauth2-server/models"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestsMFA(t *testing.T) {
+	tests := []struct {
+		acrValues string
+		want      bool
+	}{
+		{"", false},
+		{"mfa", true},
+		{"urn:example:loa:1 mfa", true},
+		{"urn:example:loa:1", false},
+		{"MFA", false},
+	}
+	for _, tt := range tests {
+		if got := requestsMFA(tt.acrValues); got != tt.want {
+			t.Errorf("requestsMFA(%q) = %v, want %v", tt.acrValues, got, tt.want)
+		}
+	}
+}
+
+func TestSessionACR(t *testing.T) {
+	if acr := sessionACR(&models.SSOSession{}); acr != "" {
+		t.Errorf("Expected no acr for a password session, got %q", acr)
+	}
+	if acr := sessionACR(&models.SSOSession{MFAAt: time.Now()}); acr != ACRMFA {
+		t.Errorf("Expected acr %q after MFA, got %q", ACRMFA, acr)
+	}
+}
+
+func TestMFACode(t *testing.T) {
+	digits := regexp.MustCompile(`^[0-9]{6}$`)
+	for i := 0; i < 20; i++ {
+		code, err := mfaCode()
+		if err != nil {
+			t.Fatalf("mfaCode failed: %v", err)
+		}
+		if !digits.MatchString(code) {
+			t.Fatalf("Expected six digits, got %q", code)
+		}
+	}
+}
+
+func TestPendingMFA_RequiresSignIn(t *testing.T) {
+	handler := NewAuthHandler(nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, NewTemplateRenderer(false, "", ""), nil, nil, nil, &config.Config{})
+
+	w := httptest.NewRecorder()
+	handler.ShowMFA(w, httptest.NewRequest("GET", "/auth/mfa?mfa_challenge=c-1", nil))
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "login_required") {
+		t.Errorf("Expected login_required without an SSO session, got %d %s", w.Code, w.Body.String())
+	}
+
+	req := httptest.NewRequest("GET", "/auth/mfa", nil)
+	req = req.WithContext(context.WithValue(req.Context(), middleware.SSOSessionContextKey, &models.SSOSession{UserID: "user-1", Authenticated: true}))
+	w = httptest.NewRecorder()
+	handler.ShowMFA(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without an mfa_challenge, got %d", w.Code)
+	}
+}
+
+func TestMFAPage_RememberDevice(t *testing.T) {
+	renderer := NewTemplateRenderer(false, "", "")
+	for _, remember := range []bool{true, false} {
+		w := httptest.NewRecorder()
+		renderer.Render(w, "mfa.html", map[string]interface{}{
+			"MFAChallenge":   "challenge-123",
+			"Email":          "user@example.com",
+			"RememberDevice": remember,
+		})
+		body := w.Body.String()
+		if !strings.Contains(body, "user@example.com") || !strings.Contains(body, `value="challenge-123"`) {
+			t.Errorf("Expected the email and challenge in the page, got %s", body)
+		}
+		if strings.Contains(body, `name="remember_device"`) != remember {
+			t.Errorf("Expected the remember-device option only when offered (%v)", remember)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"crypto/x509"
+	"net/http"
+	"net/url"
+	"oauth2-server/config"
+	"oauth2-server/jwks"
+	"oauth2-server/models"
+	"oauth2-server/utils"
+)
+
+// clientCertificate returns the TLS client certificate presented with the
+// request, taken from the handshake or, behind a TLS terminating proxy, from
+// the configured forwarding header. It returns nil when there is none.
+func clientCertificate(r *http.Request, cfg *config.Config) *x509.Certificate {
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return r.TLS.PeerCertificates[0]
+	}
+	if cfg.MTLSCertHeader == "" {
+		return nil
+	}
+	cert, err := utils.ParseForwardedCertificate(r.Header.Get(cfg.MTLSCertHeader))
+	if err != nil {
+		return nil
+	}
+	return cert
+}
+
+// clientCredentials returns the client_id and client_secret of a request to
+// the token endpoint, sent with HTTP Basic (client_secret_basic) or in the
+// form (client_secret_post). Basic credentials are form-urlencoded before
+// being joined (RFC 6749 section 2.3.1).
+func clientCredentials(r *http.Request) (string, string) {
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		return r.FormValue("client_id"), r.FormValue("client_secret")
+	}
+	if decoded, err := url.QueryUnescape(id); err == nil {
+		id = decoded
+	}
+	if decoded, err := url.QueryUnescape(secret); err == nil {
+		secret = decoded
+	}
+	return id, secret
+}
+
+// authenticateClient checks the credentials presented at the token endpoint
+// against the client's registered authentication method (RFC 8705 section 2,
+// RFC 7523 section 2.2)
+func authenticateClient(r *http.Request, keys *jwks.Cache, client *models.Client, clientSecret string, cfg *config.Config) bool {
+	switch client.TokenEndpointAuthMethod {
+	case models.AuthMethodPrivateKeyJWT:
+		return verifyClientAssertion(r, keys, client, cfg) == nil
+
+	case models.AuthMethodTLSClientAuth:
+		cert := clientCertificate(r, cfg)
+		return cert != nil && verifyIssuedCertificate(r, cert, client.TLSClientAuthSubjectDN, cfg)
+
+	case models.AuthMethodSelfSignedTLSClientAuth:
+		cert := clientCertificate(r, cfg)
+		if cert == nil || client.TLSClientThumbprint == "" {
+			return false
+		}
+		return subtle.ConstantTimeCompare([]byte(utils.CertificateThumbprint(cert)), []byte(client.TLSClientThumbprint)) == 1
+	}
+
+	return clientSecret != "" && subtle.ConstantTimeCompare([]byte(client.ClientSecret), []byte(clientSecret)) == 1
+}
+
+// verifyIssuedCertificate checks that the certificate chains to one of the
+// configured client CAs and carries the registered subject DN
+func verifyIssuedCertificate(r *http.Request, cert *x509.Certificate, subjectDN string, cfg *config.Config) bool {
+	if cfg.MTLSClientCAs == nil || subjectDN == "" || cert.Subject.String() != subjectDN {
+		return false
+	}
+
+	intermediates := x509.NewCertPool()
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 1 {
+		for _, c := range r.TLS.PeerCertificates[1:] {
+			intermediates.AddCert(c)
+		}
+	}
+
+	_, err := cert.Verify(x509.VerifyOptions{
+		Roots:         cfg.MTLSClientCAs,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	return err == nil
+}
+
+// certificateBinding returns the thumbprint the client's access tokens are
+// bound to, or "" for clients issued bearer tokens. ok is false when the
+// client requires bound tokens but presented no certificate.
+func certificateBinding(r *http.Request, client *models.Client, cfg *config.Config) (string, bool) {
+	if !client.CertificateBoundTokens {
+		return "", true
+	}
+	cert := clientCertificate(r, cfg)
+	if cert == nil {
+		return "", false
+	}
+	return utils.CertificateThumbprint(cert), true
+}
+
+// presentedCertificateMatches reports whether a certificate-bound token is
+// used with the certificate it was bound to. Bearer tokens always match.
+func presentedCertificateMatches(r *http.Request, token *accessTokenInfo, cfg *config.Config) bool {
+	if token.Thumbprint == "" {
+		return true
+	}
+	cert := clientCertificate(r, cfg)
+	if cert == nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(utils.CertificateThumbprint(cert)), []byte(token.Thumbprint)) == 1
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"oauth2-server/config"
+	"oauth2-server/models"
+	"oauth2-server/utils"
+	"strings"
+	"testing"
+	"time"
+)
+
+// issueTestCertificate creates a client certificate signed by parent, or a
+// self-signed one when parent is nil
+func issueTestCertificate(t *testing.T, commonName string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, isCA bool) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:                  isCA,
+		BasicConstraintsValid: isCA,
+	}
+	if isCA {
+		template.KeyUsage = x509.KeyUsageCertSign
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+	return cert, key
+}
+
+func requestWithCertificate(cert *x509.Certificate) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/oauth/token", nil)
+	if cert != nil {
+		r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	}
+	return r
+}
+
+func TestAuthenticateClient(t *testing.T) {
+	ca, caKey := issueTestCertificate(t, "Test CA", nil, nil, true)
+	issued, _ := issueTestCertificate(t, "payments", ca, caKey, false)
+	otherCA, otherKey := issueTestCertificate(t, "Other CA", nil, nil, true)
+	untrusted, _ := issueTestCertificate(t, "payments", otherCA, otherKey, false)
+	selfSigned, _ := issueTestCertificate(t, "self", nil, nil, false)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	cfg := &config.Config{MTLSClientCAs: pool}
+
+	secretClient := &models.Client{ClientSecret: "secret"}
+	caClient := &models.Client{TokenEndpointAuthMethod: models.AuthMethodTLSClientAuth, TLSClientAuthSubjectDN: "CN=payments", ClientSecret: "secret"}
+	selfSignedClient := &models.Client{TokenEndpointAuthMethod: models.AuthMethodSelfSignedTLSClientAuth, TLSClientThumbprint: utils.CertificateThumbprint(selfSigned)}
+
+	tests := []struct {
+		name   string
+		client *models.Client
+		cert   *x509.Certificate
+		secret string
+		want   bool
+	}{
+		{"client secret", secretClient, nil, "secret", true},
+		{"wrong client secret", secretClient, nil, "other", false},
+		{"CA-issued certificate", caClient, issued, "", true},
+		{"secret does not replace the certificate", caClient, nil, "secret", false},
+		{"certificate from an untrusted CA", caClient, untrusted, "", false},
+		{"certificate with another subject", &models.Client{TokenEndpointAuthMethod: models.AuthMethodTLSClientAuth, TLSClientAuthSubjectDN: "CN=orders"}, issued, "", false},
+		{"registered self-signed certificate", selfSignedClient, selfSigned, "", true},
+		{"other self-signed certificate", selfSignedClient, issued, "", false},
+		{"no certificate", selfSignedClient, nil, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := authenticateClient(requestWithCertificate(tt.cert), nil, tt.client, tt.secret, cfg); got != tt.want {
+				t.Errorf("authenticateClient() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientCredentials(t *testing.T) {
+	form := url.Values{"client_id": {"form-client"}, "client_secret": {"form-secret"}}
+	r := httptest.NewRequest("POST", "/oauth/token", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if id, secret := clientCredentials(r); id != "form-client" || secret != "form-secret" {
+		t.Errorf("Expected the form credentials, got %q/%q", id, secret)
+	}
+
+	// Basic credentials are form-urlencoded before being joined
+	r = httptest.NewRequest("POST", "/oauth/token", nil)
+	r.SetBasicAuth(url.QueryEscape("basic:client"), url.QueryEscape("s3cr%t+"))
+	if id, secret := clientCredentials(r); id != "basic:client" || secret != "s3cr%t+" {
+		t.Errorf("Expected the decoded Basic credentials, got %q/%q", id, secret)
+	}
+}
+
+func TestClientCertificate_ForwardedHeader(t *testing.T) {
+	cert, _ := issueTestCertificate(t, "client", nil, nil, false)
+	header := url.QueryEscape(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})))
+
+	r := requestWithCertificate(nil)
+	r.Header.Set("X-Client-Cert", header)
+
+	// The header is ignored unless the server is configured to trust it
+	if clientCertificate(r, &config.Config{}) != nil {
+		t.Error("Expected the forwarding header to be ignored when not configured")
+	}
+	if got := clientCertificate(r, &config.Config{MTLSCertHeader: "X-Client-Cert"}); got == nil || !got.Equal(cert) {
+		t.Error("Expected the forwarded certificate to be used")
+	}
+}
+
+func TestCertificateBoundAccessToken(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	cfg := &config.Config{PrivateKey: privateKey, PublicKey: &privateKey.PublicKey, AccessTokenExpiry: 3600}
+	cert, _ := issueTestCertificate(t, "client", nil, nil, false)
+	other, _ := issueTestCertificate(t, "other", nil, nil, false)
+	client := &models.Client{ClientID: "client-1", CertificateBoundTokens: true}
+
+	if _, ok := certificateBinding(requestWithCertificate(nil), client, cfg); ok {
+		t.Fatal("Expected binding to require a client certificate")
+	}
+	thumbprint, ok := certificateBinding(requestWithCertificate(cert), client, cfg)
+	if !ok || thumbprint != utils.CertificateThumbprint(cert) {
+		t.Fatalf("Expected the certificate thumbprint, got %q", thumbprint)
+	}
+
+	tokens := NewTokenStore(nil, nil)
+	token, err := tokens.issueAccessToken(context.Background(), client, "user-1", "", "", "openid", nil, utils.NewConfirmationClaim(thumbprint, ""), cfg)
+	if err != nil {
+		t.Fatalf("Failed to issue token: %v", err)
+	}
+	info, err := tokens.resolveAccessToken(context.Background(), token, cfg)
+	if err != nil {
+		t.Fatalf("Failed to resolve token: %v", err)
+	}
+	if info.Thumbprint != thumbprint {
+		t.Errorf("Expected cnf thumbprint %q, got %q", thumbprint, info.Thumbprint)
+	}
+
+	if !presentedCertificateMatches(requestWithCertificate(cert), info, cfg) {
+		t.Error("Expected the bound certificate to be accepted")
+	}
+	if presentedCertificateMatches(requestWithCertificate(other), info, cfg) {
+		t.Error("Expected another certificate to be rejected")
+	}
+	if presentedCertificateMatches(requestWithCertificate(nil), info, cfg) {
+		t.Error("Expected a bound token without a certificate to be rejected")
+	}
+
+	// Bearer tokens need no certificate
+	if _, ok := certificateBinding(requestWithCertificate(nil), &models.Client{}, cfg); !ok {
+		t.Error("Expected clients without bound tokens to need no certificate")
+	}
+	if !presentedCertificateMatches(requestWithCertificate(nil), &accessTokenInfo{}, cfg) {
+		t.Error("Expected bearer tokens to be accepted without a certificate")
+	}
+}
@@ -2,24 +2,35 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"net/http"
+	"net/url"
 	"oauth2-server/config"
+	"oauth2-server/jwks"
 	"oauth2-server/middleware"
 	"oauth2-server/models"
+	"oauth2-server/policy"
 	"oauth2-server/repository"
 	"oauth2-server/utils"
+	"strconv"
 	"strings"
 	"time"
-
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type OAuthHandler struct {
 	userRepo     *repository.UserRepository
 	clientRepo   *repository.ClientRepository
 	authCodeRepo *repository.AuthCodeRepository
-	sessionRepo  *repository.SessionRepository
+	authRequests *repository.AuthorizationRequestRepository
 	consentRepo  *repository.UserConsentRepository
+	ssoRepo      *repository.SSOSessionRepository
+	devices      *repository.TrustedDeviceRepository
+	grants       *GrantRegistry
+	audit        *Auditor
+	tokens       *TokenStore
+	dpopNonces   *utils.NonceSource
+	tokenPolicy  policy.Policy
+	clientKeys   *jwks.Cache
 	config       *config.Config
 }
 
@@ -27,21 +38,58 @@ func NewOAuthHandler(
 	userRepo *repository.UserRepository,
 	clientRepo *repository.ClientRepository,
 	authCodeRepo *repository.AuthCodeRepository,
-	sessionRepo *repository.SessionRepository,
+	authRequests *repository.AuthorizationRequestRepository,
 	consentRepo *repository.UserConsentRepository,
+	ssoRepo *repository.SSOSessionRepository,
+	devices *repository.TrustedDeviceRepository,
+	audit *Auditor,
+	tokens *TokenStore,
+	dpopNonces *utils.NonceSource,
+	tokenPolicy policy.Policy,
+	clientKeys *jwks.Cache,
 	cfg *config.Config,
 ) *OAuthHandler {
-	return &OAuthHandler{
+	h := &OAuthHandler{
 		userRepo:     userRepo,
 		clientRepo:   clientRepo,
 		authCodeRepo: authCodeRepo,
-		sessionRepo:  sessionRepo,
+		authRequests: authRequests,
 		consentRepo:  consentRepo,
+		ssoRepo:      ssoRepo,
+		devices:      devices,
+		grants:       NewGrantRegistry(),
+		audit:        audit,
+		tokens:       tokens,
+		dpopNonces:   dpopNonces,
+		tokenPolicy:  tokenPolicy,
+		clientKeys:   clientKeys,
 		config:       cfg,
 	}
+
+	h.grants.Register("authorization_code", GrantHandlerFunc(h.handleAuthorizationCodeGrant))
+	h.grants.Register("refresh_token", GrantHandlerFunc(h.handleRefreshTokenGrant))
+	h.grants.Register("client_credentials", GrantHandlerFunc(h.handleClientCredentialsGrant))
+	// Delegate to token exchange handler
+	h.grants.Register(TokenExchangeGrantType, GrantHandlerFunc(h.handleTokenExchange))
+
+	return h
+}
+
+// RegisterGrant plugs an additional grant type into the token endpoint
+func (h *OAuthHandler) RegisterGrant(grantType string, handler GrantHandler) {
+	h.grants.Register(grantType, handler)
+}
+
+// GrantTypes returns the grant types the token endpoint accepts
+func (h *OAuthHandler) GrantTypes() []string {
+	return h.grants.GrantTypes()
 }
 
 func (h *OAuthHandler) Authorize(w http.ResponseWriter, r *http.Request) {
+	if !h.applyRequestObject(w, r) {
+		return
+	}
+
 	responseType := r.URL.Query().Get("response_type")
 	clientID := r.URL.Query().Get("client_id")
 	redirectURI := r.URL.Query().Get("redirect_uri")
@@ -51,16 +99,23 @@ func (h *OAuthHandler) Authorize(w http.ResponseWriter, r *http.Request) {
 	codeChallenge := r.URL.Query().Get("code_challenge")
 	challengeMethod := r.URL.Query().Get("code_challenge_method")
 	prompt := r.URL.Query().Get("prompt")
-	// from headers
-	sessionID := r.Header.Get("X-Session-ID")
+	loginHint := strings.TrimSpace(r.URL.Query().Get("login_hint"))
+	acrValues := r.URL.Query().Get("acr_values")
+	resources := r.URL.Query()["resource"]
 
 	// Validate nonce length (max 512 characters as per OIDC spec)
 	if len(nonce) > 512 {
 		respondError(w, http.StatusBadRequest, "invalid_request", "Nonce exceeds maximum length of 512 characters")
 		return
 	}
+	if len(loginHint) > maxLoginHintLength {
+		respondError(w, http.StatusBadRequest, "invalid_request", "login_hint exceeds maximum length of 256 characters")
+		return
+	}
 
-	if responseType != "code" {
+	// Response types the server does not implement are refused for every
+	// client; the client's registered response types are checked below
+	if !utils.IsSupportedResponseType(responseType) {
 		respondError(w, http.StatusBadRequest, "unsupported_response_type", "Only 'code' response type is supported")
 		return
 	}
@@ -70,44 +125,43 @@ func (h *OAuthHandler) Authorize(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctx := context.Background()
+	ctx := r.Context()
 	client, err := h.clientRepo.FindByClientID(ctx, clientID)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
+		if errors.Is(err, repository.ErrNotFound) {
 			respondError(w, http.StatusBadRequest, "invalid_client", "Client not found")
 			return
 		}
-		respondError(w, http.StatusInternalServerError, "server_error", "Failed to validate client")
+		respondInternalError(w, err, "Failed to validate client")
 		return
 	}
 
-	validRedirect := false
-	for _, uri := range client.RedirectURIs {
-		if uri == redirectURI {
-			validRedirect = true
-			break
-		}
+	if client.Disabled {
+		respondClientDisabled(w, http.StatusBadRequest)
+		return
 	}
 
-	if !validRedirect {
+	if !redirectURIAllowed(client, redirectURI, h.config) {
 		respondError(w, http.StatusBadRequest, "invalid_request", "Invalid redirect URI")
 		return
 	}
 
-	// Validate and normalize scope
-	if scope == "" {
-		scope = utils.GetDefaultScope()
-	} else {
-		// Validate scope format and existence
-		if err := utils.GlobalScopeValidator.ValidateScope(scope); err != nil {
-			respondError(w, http.StatusBadRequest, "invalid_scope", err.Error())
+	// Client must be registered for the requested response type
+	if !utils.ResponseTypeAllowed(responseType, client.ResponseTypes) {
+		respondError(w, http.StatusBadRequest, "unauthorized_client", "Client is not authorized to use response type '"+responseType+"'")
+		return
+	}
+	for _, grantType := range utils.ResponseTypeGrantTypes(responseType) {
+		if !utils.GrantTypeAllowed(grantType, client.GrantTypes) {
+			respondError(w, http.StatusBadRequest, "unauthorized_client", "Client is not authorized to use the "+grantType+" grant type")
 			return
 		}
-		scope = utils.NormalizeScope(scope)
 	}
 
-	// Validate scopes against client's AllowedScopes
-	if err := utils.GlobalScopeValidator.ValidateScopeAgainstAllowed(scope, client.AllowedScopes); err != nil {
+	// Validate and normalize scope against the registry and the client's
+	// AllowedScopes; lenient clients have unknown scopes dropped instead
+	scope, err = resolveScope(client, scope, utils.GetDefaultScope())
+	if err != nil {
 		respondError(w, http.StatusBadRequest, "invalid_scope", err.Error())
 		return
 	}
@@ -118,6 +172,12 @@ func (h *OAuthHandler) Authorize(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Resource indicators must be registered for the client (RFC 8707)
+	if err := utils.ValidateResources(resources, client.AllowedResources); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_target", err.Error())
+		return
+	}
+
 	// Validate PKCE parameters if present
 	if codeChallenge != "" {
 		if challengeMethod == "" {
@@ -137,36 +197,77 @@ func (h *OAuthHandler) Authorize(w http.ResponseWriter, r *http.Request) {
 		ssoSession = nil // Ignore SSO session to force login
 	}
 
-	// Handle prompt=select_account: display account selection (placeholder for future)
-	// For now, treat it like prompt=login and force re-authentication
-	if prompt == "select_account" {
-		ssoSession = nil // Force account selection by requiring login
+	// Handle prompt=select_account: the user picks one of the accounts
+	// signed in on the browser, or signs in with another, before continuing
+	selectAccount := prompt == "select_account"
+	if selectAccount {
+		ssoSession = nil
+	}
+
+	// A session for another user than the hinted one is never reused
+	// silently: the user selects the account by signing in again
+	if loginHint != "" && ssoSession != nil && ssoSession.Authenticated && !h.loginHintMatches(ctx, loginHint, ssoSession.UserID) {
+		if prompt == "none" {
+			redirectToClient(w, r, redirectURI, authorizationError(w, "account_selection_required", "Signed in as a different user than login_hint", state))
+			return
+		}
+		ssoSession = nil
+	}
+
+	// Step-up authentication: a session signed in with a password alone
+	// must complete MFA first, unless the browser was remembered after an
+	// earlier challenge
+	if requestsMFA(acrValues) && ssoSession != nil && ssoSession.Authenticated && ssoSession.MFAAt.IsZero() {
+		if device := trustedDevice(r, h.devices, ssoSession.UserID); device != nil {
+			ssoSession.MFAAt = time.Now()
+			if err := h.ssoRepo.MarkMFA(ctx, ssoSession.SessionID, ssoSession.MFAAt); err != nil {
+				respondInternalError(w, err, "Failed to update SSO session")
+				return
+			}
+			h.devices.Touch(ctx, device.ID)
+		} else if prompt == "none" {
+			redirectToClient(w, r, redirectURI, authorizationError(w, "interaction_required", "Multi-factor authentication required", state))
+			return
+		} else {
+			h.startStepUp(w, r, &models.AuthorizationRequest{
+				UserID:          ssoSession.UserID,
+				ClientID:        clientID,
+				RedirectURI:     redirectURI,
+				Scope:           scope,
+				State:           state,
+				ResponseType:    responseType,
+				Nonce:           nonce,
+				CodeChallenge:   codeChallenge,
+				ChallengeMethod: challengeMethod,
+				Resources:       resources,
+				ACRValues:       acrValues,
+				RequestIP:       clientIP(r),
+				UserAgent:       r.UserAgent(),
+			})
+			return
+		}
 	}
 
 	// Handle prompt=none: fail immediately if not authenticated or no consent
 	if prompt == "none" {
 		// Check if user is authenticated
 		if ssoSession == nil || !ssoSession.Authenticated {
-			// Build error redirect URL
-			errorURL := redirectURI + "?error=login_required&error_description=User+authentication+required"
-			if state != "" {
-				errorURL += "&state=" + state
-			}
-			http.Redirect(w, r, errorURL, http.StatusFound)
+			redirectToClient(w, r, redirectURI, authorizationError(w, "login_required", "User authentication required", state))
 			return
 		}
 
-		// User is authenticated, check for consent
-		requestedScopes := strings.Split(scope, " ")
-		hasConsent, err := h.consentRepo.HasConsent(ctx, ssoSession.UserID, clientID, requestedScopes)
-		if err != nil || !hasConsent {
-			// Build error redirect URL
-			errorURL := redirectURI + "?error=consent_required&error_description=User+consent+required"
-			if state != "" {
-				errorURL += "&state=" + state
+		// User is authenticated, check for consent unless the client only
+		// requires authentication for silent requests
+		if promptNoneRequiresConsent(client) {
+			requestedScopes := strings.Split(scope, " ")
+			hasConsent, err := h.consentRepo.HasConsent(ctx, ssoSession.UserID, clientID, requestedScopes)
+			if resolveConsentPolicy(client, h.config) == models.ConsentPolicySession {
+				hasConsent = false
+			}
+			if err != nil || !hasConsent {
+				redirectToClient(w, r, redirectURI, authorizationError(w, "consent_required", "User consent required", state))
+				return
 			}
-			http.Redirect(w, r, errorURL, http.StatusFound)
-			return
 		}
 
 		// Both authentication and consent exist, proceed with auto-approval
@@ -179,9 +280,11 @@ func (h *OAuthHandler) Authorize(w http.ResponseWriter, r *http.Request) {
 		requestedScopes := strings.Split(scope, " ")
 
 		// Check for existing user consent
-		hasConsent, err := h.consentRepo.HasConsent(ctx, ssoSession.UserID, clientID, requestedScopes)
-		if err != nil {
-			// Log error but continue to consent screen
+		status, consent, err := h.consentRepo.CheckConsent(ctx, ssoSession.UserID, clientID, requestedScopes)
+		hasConsent := err == nil && status == repository.ConsentGranted
+
+		// Re-prompt interactively when the consent is about to expire
+		if hasConsent && prompt != "none" && consentNeedsRenewal(consent, h.config, time.Now()) {
 			hasConsent = false
 		}
 
@@ -190,12 +293,22 @@ func (h *OAuthHandler) Authorize(w http.ResponseWriter, r *http.Request) {
 			hasConsent = false
 		}
 
+		// Per-session consent policy: never auto-approve, ask every time
+		if resolveConsentPolicy(client, h.config) == models.ConsentPolicySession {
+			hasConsent = false
+		}
+
+		// prompt=none already passed its checks above
+		if prompt == "none" && !promptNoneRequiresConsent(client) {
+			hasConsent = true
+		}
+
 		// Auto-approve if consent exists
 		if hasConsent {
 			// Generate authorization code immediately
 			code, err := utils.GenerateRandomString(16)
 			if err != nil {
-				respondError(w, http.StatusInternalServerError, "server_error", "Failed to generate authorization code")
+				respondInternalError(w, err, "Failed to generate authorization code")
 				return
 			}
 
@@ -208,54 +321,54 @@ func (h *OAuthHandler) Authorize(w http.ResponseWriter, r *http.Request) {
 				Nonce:           nonce,
 				CodeChallenge:   codeChallenge,
 				ChallengeMethod: challengeMethod,
+				Resources:       resources,
 				ExpiresAt:       time.Now().Add(10 * time.Minute),
+
+				RequestedAt:      time.Now(),
+				RequestIP:        clientIP(r),
+				RequestUserAgent: r.UserAgent(),
+				Fingerprint:      requestFingerprint(r),
+				SSOSessionID:     ssoSession.SessionID,
+
+				AuthTime: ssoSession.CreatedAt,
+				ACR:      sessionACR(ssoSession),
 			}
 
 			if err := h.authCodeRepo.Create(ctx, authCode); err != nil {
-				respondError(w, http.StatusInternalServerError, "server_error", "Failed to create authorization code")
+				respondInternalError(w, err, "Failed to create authorization code")
 				return
 			}
 
 			// Redirect with authorization code
-			redirectURL := redirectURI + "?code=" + code
+			params := url.Values{"code": {code}}
 			if state != "" {
-				redirectURL += "&state=" + state
+				params.Set("state", state)
 			}
-			http.Redirect(w, r, redirectURL, http.StatusFound)
+			redirectToClient(w, r, redirectURI, params)
 			return
 		}
 
-		// No consent - redirect to consent screen
-		consentURL := "/oauth/consent?client_id=" + clientID +
-			"&scope=" + scope +
-			"&redirect_uri=" + redirectURI +
-			"&response_type=" + responseType
-		if state != "" {
-			consentURL += "&state=" + state
-		}
-		if nonce != "" {
-			consentURL += "&nonce=" + nonce
-		}
-		if codeChallenge != "" {
-			consentURL += "&code_challenge=" + codeChallenge
-			if challengeMethod != "" {
-				consentURL += "&code_challenge_method=" + challengeMethod
-			}
-		}
-		http.Redirect(w, r, consentURL, http.StatusFound)
+		// No consent - store the request and ask for it on the consent screen
+		h.startConsentChallenge(w, r, &models.AuthorizationRequest{
+			UserID:          ssoSession.UserID,
+			ClientID:        clientID,
+			RedirectURI:     redirectURI,
+			Scope:           scope,
+			State:           state,
+			ResponseType:    responseType,
+			Nonce:           nonce,
+			CodeChallenge:   codeChallenge,
+			ChallengeMethod: challengeMethod,
+			Resources:       resources,
+			RequestIP:       clientIP(r),
+			UserAgent:       r.UserAgent(),
+		})
 		return
 	}
 
-	// No SSO session or no consent - create OAuth session and redirect to login
-	if sessionID == "" {
-		sessionID, err = utils.GenerateRandomString(32)
-		if err != nil {
-			respondError(w, http.StatusInternalServerError, "server_error", "Failed to generate session")
-			return
-		}
-	}
-	session := &models.Session{
-		SessionID:       sessionID,
+	// No SSO session - store the request and redirect to login
+	request := &models.AuthorizationRequest{
+		Stage:           models.AuthorizationStageLogin,
 		ClientID:        clientID,
 		RedirectURI:     redirectURI,
 		Scope:           scope,
@@ -264,17 +377,73 @@ func (h *OAuthHandler) Authorize(w http.ResponseWriter, r *http.Request) {
 		Nonce:           nonce,
 		CodeChallenge:   codeChallenge,
 		ChallengeMethod: challengeMethod,
-		Authenticated:   false,
+		Resources:       resources,
+		LoginHint:       loginHint,
+		ACRValues:       acrValues,
+		RequestIP:       clientIP(r),
+		UserAgent:       r.UserAgent(),
 		ExpiresAt:       time.Now().Add(10 * time.Minute),
 	}
 
-	if err := h.sessionRepo.Create(ctx, session); err != nil {
-		respondError(w, http.StatusInternalServerError, "server_error", "Failed to create session")
+	// Collapse rapid duplicate submissions (refresh, back button) into the
+	// pending request that was already stored for the same parameters
+	if existing := h.findDuplicateRequest(ctx, request); existing != nil {
+		http.Redirect(w, r, h.signInURL(r, existing.Challenge, selectAccount), http.StatusFound)
 		return
 	}
 
-	loginURL := "/auth/login?session_id=" + sessionID
-	http.Redirect(w, r, loginURL, http.StatusFound)
+	request.Challenge, err = utils.GenerateRandomString(32)
+	if err != nil {
+		respondInternalError(w, err, "Failed to generate login challenge")
+		return
+	}
+	if err := h.authRequests.Create(ctx, request); err != nil {
+		respondInternalError(w, err, "Failed to store authorization request")
+		return
+	}
+
+	http.Redirect(w, r, h.signInURL(r, request.Challenge, selectAccount), http.StatusFound)
+}
+
+// signInURL is where the user signs in for a stored request: the account
+// chooser when prompt=select_account and any account is signed in on the
+// browser, otherwise the login page
+func (h *OAuthHandler) signInURL(r *http.Request, challenge string, selectAccount bool) string {
+	idleTimeout := time.Duration(h.config.SSOIdleTimeout) * time.Second
+	if selectAccount && len(signedInAccounts(r.Context(), r, h.ssoRepo, idleTimeout)) > 0 {
+		return selectAccountURL(challenge)
+	}
+	return loginURL(h.config, challenge)
+}
+
+// maxLoginHintLength bounds login_hint, which holds an email address or a
+// subject identifier
+const maxLoginHintLength = 256
+
+// loginHintMatches reports whether login_hint names the user userID, by
+// subject or by email address. A user that cannot be looked up does not match.
+func (h *OAuthHandler) loginHintMatches(ctx context.Context, hint, userID string) bool {
+	if hint == userID {
+		return true
+	}
+	user, err := h.userRepo.FindByID(ctx, userID)
+	return err == nil && strings.EqualFold(user.Email, hint)
+}
+
+// findDuplicateRequest looks up a request waiting for login stored within
+// the dedup window with identical parameters. Requests without state or
+// nonce are never collapsed since nothing ties them to a single user agent.
+func (h *OAuthHandler) findDuplicateRequest(ctx context.Context, request *models.AuthorizationRequest) *models.AuthorizationRequest {
+	if h.config.AuthorizeDedupWindow <= 0 || (request.State == "" && request.Nonce == "") {
+		return nil
+	}
+
+	since := time.Now().Add(-time.Duration(h.config.AuthorizeDedupWindow) * time.Second)
+	existing, err := h.authRequests.FindPendingDuplicate(ctx, request, since)
+	if err != nil {
+		return nil
+	}
+	return existing
 }
 
 func (h *OAuthHandler) Token(w http.ResponseWriter, r *http.Request) {
@@ -282,28 +451,19 @@ func (h *OAuthHandler) Token(w http.ResponseWriter, r *http.Request) {
 		respondError(w, http.StatusBadRequest, "invalid_request", "Failed to parse form")
 		return
 	}
-
-	grantType := r.FormValue("grant_type")
-
-	switch grantType {
-	case "authorization_code":
-		h.handleAuthorizationCodeGrant(w, r)
-	case "refresh_token":
-		h.handleRefreshTokenGrant(w, r)
-	case "client_credentials":
-		h.handleClientCredentialsGrant(w, r)
-	case "urn:ietf:params:oauth:grant-type:token-exchange":
-		// Delegate to token exchange handler
-		h.handleTokenExchange(w, r)
-	default:
-		respondError(w, http.StatusBadRequest, "unsupported_grant_type", "Grant type not supported")
+	// A private_key_jwt client may identify itself only by its assertion
+	if r.Form.Get("client_id") == "" {
+		if clientID := assertionClientID(r); clientID != "" {
+			r.Form.Set("client_id", clientID)
+		}
 	}
+
+	h.grants.ServeToken(w, r)
 }
 
 func (h *OAuthHandler) handleAuthorizationCodeGrant(w http.ResponseWriter, r *http.Request) {
 	code := r.FormValue("code")
-	clientID := r.FormValue("client_id")
-	clientSecret := r.FormValue("client_secret")
+	clientID, clientSecret := clientCredentials(r)
 	redirectURI := r.FormValue("redirect_uri")
 	codeVerifier := r.FormValue("code_verifier")
 
@@ -312,28 +472,58 @@ func (h *OAuthHandler) handleAuthorizationCodeGrant(w http.ResponseWriter, r *ht
 		return
 	}
 
-	ctx := context.Background()
+	ctx := r.Context()
 	client, err := h.clientRepo.FindByClientID(ctx, clientID)
 	if err != nil {
 		respondError(w, http.StatusUnauthorized, "invalid_client", "Invalid client")
 		return
 	}
 
-	// For confidential clients, verify client_secret
+	// For confidential clients, verify client_secret or the mTLS certificate
 	// For public clients (PKCE), verify code_verifier instead
-	if client.ClientSecret != "" {
-		// Confidential client - require client_secret
-		if clientSecret == "" || client.ClientSecret != clientSecret {
+	if client.ClientSecret != "" || client.UsesCertificateAuth() {
+		if !authenticateClient(r, h.clientKeys, client, clientSecret, h.config) {
 			respondError(w, http.StatusUnauthorized, "invalid_client", "Invalid client credentials")
 			return
 		}
 	}
 
+	if client.Disabled {
+		respondClientDisabled(w, http.StatusUnauthorized)
+		return
+	}
+	if !utils.GrantTypeAllowed("authorization_code", client.GrantTypes) {
+		respondError(w, http.StatusBadRequest, "unauthorized_client", "Client is not authorized to use this grant type")
+		return
+	}
+
+	thumbprint, ok := certificateBinding(r, client, h.config)
+	if !ok {
+		respondError(w, http.StatusBadRequest, "invalid_request", "A client certificate is required for certificate-bound tokens")
+		return
+	}
+	jkt, ok := dpopBinding(w, r, h.dpopNonces, client, h.config)
+	if !ok {
+		return
+	}
+	cnf := utils.NewConfirmationClaim(thumbprint, jkt)
+
 	authCode, err := h.authCodeRepo.FindByCode(ctx, code)
 	if err != nil {
 		respondError(w, http.StatusBadRequest, "invalid_grant", "Invalid authorization code")
 		return
 	}
+	// A code presented by another client, or with another redirect_uri, is
+	// refused before the replay check: only the client the code was issued
+	// to may trigger revoking its grant
+	if authCode.ClientID != clientID || authCode.RedirectURI != redirectURI {
+		respondError(w, http.StatusBadRequest, "invalid_grant", "Code mismatch")
+		return
+	}
+	if !authCode.RedeemedAt.IsZero() {
+		h.rejectReplayedCode(w, r, authCode)
+		return
+	}
 
 	if authCode.ExpiresAt.Before(time.Now()) {
 		h.authCodeRepo.Delete(ctx, code)
@@ -341,11 +531,6 @@ func (h *OAuthHandler) handleAuthorizationCodeGrant(w http.ResponseWriter, r *ht
 		return
 	}
 
-	if authCode.ClientID != clientID || authCode.RedirectURI != redirectURI {
-		respondError(w, http.StatusBadRequest, "invalid_grant", "Code mismatch")
-		return
-	}
-
 	// Verify PKCE if code_challenge was used
 	if authCode.CodeChallenge != "" {
 		if codeVerifier == "" {
@@ -358,85 +543,232 @@ func (h *OAuthHandler) handleAuthorizationCodeGrant(w http.ResponseWriter, r *ht
 		}
 	}
 
-	h.authCodeRepo.Delete(ctx, code)
+	if !h.checkCodeOrigin(r, authCode) {
+		h.authCodeRepo.Delete(ctx, code)
+		respondError(w, http.StatusBadRequest, "invalid_grant", "Authorization code was redeemed from a different address")
+		return
+	}
+	if !h.checkCodeSession(r, authCode) {
+		h.authCodeRepo.Delete(ctx, code)
+		respondError(w, http.StatusBadRequest, "invalid_grant", "The session the authorization code was issued in has ended")
+		return
+	}
+
+	// The code is single use: of concurrent requests only the one that marks
+	// it redeemed proceeds, and the others are treated as a replay
+	if err := h.authCodeRepo.Redeem(ctx, code); err != nil {
+		if errors.Is(err, repository.ErrConflict) {
+			h.rejectReplayedCode(w, r, authCode)
+			return
+		}
+		respondInternalError(w, err, "Failed to redeem authorization code")
+		return
+	}
 
-	user, err := h.userRepo.FindByID(ctx, authCode.UserID)
+	user, err := h.userRepo.FindProfileByID(ctx, authCode.UserID)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, "server_error", "Failed to find user")
+		respondInternalError(w, err, "Failed to find user")
 		return
 	}
 
 	// Validate scopes from authorization code (already validated during authorization)
-	// Scopes are stored in authCode.Scope
+	// Scopes are stored in authCode.Scope; drop any whose time-limited grant
+	// lapsed before the code was redeemed
+	scope, err := h.activeGrantedScope(ctx, user.ID, clientID, authCode.Scope)
+	if err != nil {
+		respondInternalError(w, err, "Failed to check consent")
+		return
+	}
+	scope, ok = applyTokenPolicy(w, r, h.tokenPolicy, h.audit, "authorization_code", user, client, scope)
+	if !ok {
+		return
+	}
 
-	// Generate access token with scope claim only (no user claims)
-	accessToken, err := utils.GenerateAccessToken(
-		user.ID,
-		user.Email,
-		user.Name,
-		authCode.Scope,
-		h.config.PrivateKey,
-		h.config.AccessTokenExpiry,
-	)
+	audience, err := tokenAudience(r.Form["resource"], authCode.Resources, client.AllowedResources)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, "server_error", "Failed to generate access token")
+		respondError(w, http.StatusBadRequest, "invalid_target", err.Error())
 		return
 	}
 
-	refreshToken, err := utils.GenerateRefreshToken(user.ID, authCode.Scope, h.config.PrivateKey, h.config.RefreshTokenExpiry)
+	// Generate access token with scope claim only (no user claims)
+	accessToken, err := h.tokens.issueAccessToken(ctx, client, user.ID, user.Email, user.Name, scope, audience, cnf, h.config)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, "server_error", "Failed to generate refresh token")
+		respondInternalError(w, err, "Failed to generate access token")
 		return
 	}
 
+	var refreshToken string
+	if issuesRefreshToken(scope, h.config) {
+		refreshToken, err = utils.GenerateRefreshTokenForResources(user.ID, clientID, scope, authCode.Resources, authTimeUnix(authCode.AuthTime), h.config.PrivateKey, h.config.RefreshTokenExpiry)
+		if err != nil {
+			respondInternalError(w, err, "Failed to generate refresh token")
+			return
+		}
+	}
+
 	// Generate ID token with user claims based on scopes using ClaimFilter
 	// Include nonce in ID token if present (for replay protection)
-	userClaims := utils.GetIDTokenClaimsForUser(user, authCode.Scope, authCode.Nonce)
-	idToken, err := utils.GenerateIDToken(
-		user.ID,
-		clientID,
-		userClaims,
-		h.config.PrivateKey,
-		h.config.AccessTokenExpiry,
-	)
+	idToken, err := buildIDToken(&idTokenRequest{
+		User:     user,
+		Client:   client,
+		Scope:    scope,
+		Nonce:    authCode.Nonce,
+		AuthTime: authCode.AuthTime,
+		ACR:      authCode.ACR,
+	}, h.config)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, "server_error", "Failed to generate ID token")
+		respondInternalError(w, err, "Failed to generate ID token")
 		return
 	}
 
 	response := models.TokenResponse{
 		AccessToken:  accessToken,
-		TokenType:    "Bearer",
+		TokenType:    tokenType(cnf),
 		ExpiresIn:    h.config.AccessTokenExpiry,
 		RefreshToken: refreshToken,
 		IDToken:      idToken,
-		Scope:        authCode.Scope,
+		Scope:        scope,
 	}
 
+	h.audit.record(r, models.AuditTokenIssued, user.ID, clientID, map[string]string{"grant_type": "authorization_code", "scope": scope})
+
 	respondJSON(w, http.StatusOK, response)
 }
 
+// issuesRefreshToken reports whether the authorization code grant returns a
+// refresh token for the granted scope. With RequireOfflineAccess only grants
+// the user consented to offline_access for get one.
+func issuesRefreshToken(scope string, cfg *config.Config) bool {
+	return !cfg.RequireOfflineAccess || utils.ScopeIncludesOfflineAccess(scope)
+}
+
+// rejectReplayedCode answers a request redeeming a code that was already
+// redeemed. The code may have been stolen, so every token the user holds
+// for the client is revoked along with it (RFC 6749 section 4.1.2).
+func (h *OAuthHandler) rejectReplayedCode(w http.ResponseWriter, r *http.Request, authCode *models.AuthorizationCode) {
+	if err := h.tokens.revokeGrant(r.Context(), authCode.UserID, authCode.ClientID, h.config); err != nil {
+		respondInternalError(w, err, "Failed to revoke tokens")
+		return
+	}
+	// A concurrent redemption read the code before it was marked redeemed
+	var details map[string]string
+	if !authCode.RedeemedAt.IsZero() {
+		details = map[string]string{"redeemed_at": authCode.RedeemedAt.UTC().Format(time.RFC3339)}
+	}
+	h.audit.record(r, models.AuditCodeReplayed, authCode.UserID, authCode.ClientID, details)
+	respondError(w, http.StatusBadRequest, "invalid_grant", "Authorization code has already been used")
+}
+
+// checkCodeOrigin audits a code redeemed from another IP address than its
+// authorization request and reports whether the redemption may proceed
+func (h *OAuthHandler) checkCodeOrigin(r *http.Request, authCode *models.AuthorizationCode) bool {
+	redemptionIP := clientIP(r)
+	if authCode.RequestIP == "" || authCode.RequestIP == redemptionIP {
+		return true
+	}
+
+	details := map[string]string{
+		"request_ip":            authCode.RequestIP,
+		"redemption_ip":         redemptionIP,
+		"request_user_agent":    authCode.RequestUserAgent,
+		"redemption_user_agent": r.UserAgent(),
+		"rejected":              strconv.FormatBool(h.config.RejectCodeIPMismatch),
+		"fingerprint_matches":   strconv.FormatBool(authCode.Fingerprint != "" && authCode.Fingerprint == requestFingerprint(r)),
+	}
+	if !authCode.RequestedAt.IsZero() {
+		details["request_age"] = time.Since(authCode.RequestedAt).Round(time.Second).String()
+	}
+	h.audit.record(r, models.AuditCodeIPMismatch, authCode.UserID, authCode.ClientID, details)
+
+	return !h.config.RejectCodeIPMismatch
+}
+
+// checkCodeSession audits a code whose SSO session was logged out or revoked
+// after the code was issued and reports whether the redemption may proceed.
+// A code from an ended session may have been obtained by whoever fixated or
+// hijacked that session.
+func (h *OAuthHandler) checkCodeSession(r *http.Request, authCode *models.AuthorizationCode) bool {
+	if authCode.SSOSessionID == "" {
+		return true
+	}
+	session, err := h.ssoRepo.FindBySessionID(r.Context(), authCode.SSOSessionID)
+	switch {
+	case err == nil && session.UserID == authCode.UserID && sessionActivity(h.config).Active(session):
+		return true
+	case err != nil && !errors.Is(err, repository.ErrNotFound):
+		// Whether the session ended is unknown; only strict mode refuses
+		return !h.config.RejectCodeEndedSession
+	}
+
+	h.audit.record(r, models.AuditCodeSessionEnded, authCode.UserID, authCode.ClientID, map[string]string{
+		"rejected": strconv.FormatBool(h.config.RejectCodeEndedSession),
+	})
+	return !h.config.RejectCodeEndedSession
+}
+
 func (h *OAuthHandler) handleRefreshTokenGrant(w http.ResponseWriter, r *http.Request) {
 	refreshToken := r.FormValue("refresh_token")
-	clientID := r.FormValue("client_id")
-	clientSecret := r.FormValue("client_secret")
+	clientID, clientSecret := clientCredentials(r)
 	requestedScope := r.FormValue("scope")
 
-	if refreshToken == "" || clientID == "" || clientSecret == "" {
+	if refreshToken == "" || clientID == "" || (clientSecret == "" && clientCertificate(r, h.config) == nil && r.FormValue("client_assertion") == "") {
 		respondError(w, http.StatusBadRequest, "invalid_request", "Missing required parameters")
 		return
 	}
 
-	ctx := context.Background()
+	ctx := r.Context()
 	client, err := h.clientRepo.FindByClientID(ctx, clientID)
-	if err != nil || client.ClientSecret != clientSecret {
+	if err != nil || !authenticateClient(r, h.clientKeys, client, clientSecret, h.config) {
 		respondError(w, http.StatusUnauthorized, "invalid_client", "Invalid client credentials")
 		return
 	}
 
-	claims, err := utils.ValidateRefreshToken(refreshToken, h.config.PublicKey)
-	if err != nil {
-		respondError(w, http.StatusBadRequest, "invalid_grant", "Invalid refresh token")
+	if client.Disabled {
+		respondClientDisabled(w, http.StatusUnauthorized)
+		return
+	}
+	if !utils.GrantTypeAllowed("refresh_token", client.GrantTypes) {
+		respondError(w, http.StatusBadRequest, "unauthorized_client", "Client is not authorized to use this grant type")
+		return
+	}
+
+	thumbprint, ok := certificateBinding(r, client, h.config)
+	if !ok {
+		respondError(w, http.StatusBadRequest, "invalid_request", "A client certificate is required for certificate-bound tokens")
+		return
+	}
+	jkt, ok := dpopBinding(w, r, h.dpopNonces, client, h.config)
+	if !ok {
+		return
+	}
+	cnf := utils.NewConfirmationClaim(thumbprint, jkt)
+
+	// Refresh tokens from the JWE path of token exchange are encrypted and
+	// are refreshed into encrypted tokens again
+	encrypted := utils.IsJWE(refreshToken)
+	var claims *utils.RefreshTokenClaims
+	var issuedAt time.Time
+	if encrypted {
+		jweClaims, err := utils.ValidateJWERefreshToken(refreshToken, jweDecryptionKeys(h.config)...)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "invalid_grant", "Invalid refresh token")
+			return
+		}
+		claims = &utils.RefreshTokenClaims{UserID: jweClaims.UserID, Scope: jweClaims.Scope, ClientID: jweClaims.ClientID}
+		issuedAt = time.Unix(jweClaims.Iat, 0)
+	} else {
+		claims, err = utils.ValidateRefreshToken(refreshToken, h.config.PublicKey)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "invalid_grant", "Invalid refresh token")
+			return
+		}
+		issuedAt = claimIssuedAt(claims.IssuedAt)
+	}
+
+	// Refresh tokens may only be redeemed by the client they were issued to.
+	// Tokens not bound to any client are rejected as well.
+	if claims.ClientID != clientID {
+		respondError(w, http.StatusBadRequest, "invalid_grant", "Refresh token was not issued to this client")
 		return
 	}
 
@@ -446,9 +778,27 @@ func (h *OAuthHandler) handleRefreshTokenGrant(w http.ResponseWriter, r *http.Re
 		userID = claims.Subject
 	}
 
-	user, err := h.userRepo.FindByID(ctx, userID)
+	// Refresh tokens issued before the user or client was revoked are dead
+	if err := h.tokens.checkRevocation(ctx, userID, clientID, issuedAt); err != nil {
+		if errors.Is(err, errTokenRevoked) {
+			respondError(w, http.StatusBadRequest, "invalid_grant", "Refresh token has been revoked")
+			return
+		}
+		respondInternalError(w, err, "Failed to check token revocation")
+		return
+	}
+
+	user, err := h.userRepo.FindProfileByID(ctx, userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		respondError(w, http.StatusBadRequest, "invalid_grant", "Refresh token has been revoked")
+		return
+	}
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, "server_error", "Failed to find user")
+		respondInternalError(w, err, "Failed to find user")
+		return
+	}
+	if user.Disabled {
+		respondError(w, http.StatusBadRequest, "invalid_grant", "User account is disabled")
 		return
 	}
 
@@ -473,22 +823,105 @@ func (h *OAuthHandler) handleRefreshTokenGrant(w http.ResponseWriter, r *http.Re
 		}
 	}
 
-	accessToken, err := utils.GenerateAccessToken(
+	// Time-limited scope grants are not carried over once they expire
+	scope, err = h.activeGrantedScope(ctx, user.ID, clientID, scope)
+	if err != nil {
+		respondInternalError(w, err, "Failed to check consent")
+		return
+	}
+	scope, ok = applyTokenPolicy(w, r, h.tokenPolicy, h.audit, "refresh_token", user, client, scope)
+	if !ok {
+		return
+	}
+
+	if encrypted {
+		if cnf != nil {
+			respondError(w, http.StatusBadRequest, "invalid_request", "Encrypted tokens cannot be bound to a certificate or DPoP key")
+			return
+		}
+		h.refreshEncrypted(w, r, user, clientID, scope)
+		return
+	}
+
+	audience, err := tokenAudience(r.Form["resource"], claims.Resources, client.AllowedResources)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_target", err.Error())
+		return
+	}
+
+	accessToken, err := h.tokens.issueAccessToken(ctx, client, user.ID, user.Email, user.Name, scope, audience, cnf, h.config)
+	if err != nil {
+		respondInternalError(w, err, "Failed to generate access token")
+		return
+	}
+
+	newRefreshToken, err := utils.GenerateRefreshTokenForResources(user.ID, clientID, scope, claims.Resources, claims.AuthTime, h.config.PrivateKey, h.config.RefreshTokenExpiry)
+	if err != nil {
+		respondInternalError(w, err, "Failed to generate refresh token")
+		return
+	}
+
+	response := models.TokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    tokenType(cnf),
+		ExpiresIn:    h.config.AccessTokenExpiry,
+		RefreshToken: newRefreshToken,
+		Scope:        scope,
+	}
+
+	// A fresh ID token for the same authentication: new iat, the original
+	// auth_time and no nonce
+	if utils.RequiresOpenID(scope) {
+		response.IDToken, err = buildIDToken(&idTokenRequest{
+			User:     user,
+			Client:   client,
+			Scope:    scope,
+			AuthTime: authTimeOf(claims.AuthTime),
+		}, h.config)
+		if err != nil {
+			respondInternalError(w, err, "Failed to generate ID token")
+			return
+		}
+	}
+
+	h.audit.record(r, models.AuditTokenIssued, user.ID, clientID, map[string]string{"grant_type": "refresh_token", "scope": scope})
+
+	respondJSON(w, http.StatusOK, response)
+}
+
+// refreshEncrypted completes a refresh grant for an encrypted refresh token,
+// issuing a JWE access token and a new JWE refresh token. Encrypted tokens
+// carry no audience, so resource indicators are refused.
+func (h *OAuthHandler) refreshEncrypted(w http.ResponseWriter, r *http.Request, user *models.User, clientID, scope string) {
+	if len(r.Form["resource"]) > 0 {
+		respondError(w, http.StatusBadRequest, "invalid_target", "Resource indicators are not supported for encrypted tokens")
+		return
+	}
+
+	now := time.Now()
+	accessToken, err := utils.GenerateJWEAccessTokenForClient(
 		user.ID,
+		clientID,
 		user.Email,
 		user.Name,
 		scope,
-		h.config.PrivateKey,
-		h.config.AccessTokenExpiry,
+		jweEncryptionKey(h.config),
+		now.Add(time.Duration(h.config.AccessTokenExpiry)*time.Second).Unix(),
 	)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, "server_error", "Failed to generate access token")
+		respondInternalError(w, err, "Failed to generate access token")
 		return
 	}
 
-	newRefreshToken, err := utils.GenerateRefreshToken(user.ID, scope, h.config.PrivateKey, h.config.RefreshTokenExpiry)
+	refreshToken, err := utils.GenerateJWERefreshTokenForClient(
+		user.ID,
+		clientID,
+		scope,
+		jweEncryptionKey(h.config),
+		now.Add(time.Duration(h.config.RefreshTokenExpiry)*time.Second).Unix(),
+	)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, "server_error", "Failed to generate refresh token")
+		respondInternalError(w, err, "Failed to generate refresh token")
 		return
 	}
 
@@ -496,70 +929,84 @@ func (h *OAuthHandler) handleRefreshTokenGrant(w http.ResponseWriter, r *http.Re
 		AccessToken:  accessToken,
 		TokenType:    "Bearer",
 		ExpiresIn:    h.config.AccessTokenExpiry,
-		RefreshToken: newRefreshToken,
+		RefreshToken: refreshToken,
 		Scope:        scope,
 	}
 
+	h.audit.record(r, models.AuditTokenIssued, user.ID, clientID, map[string]string{"grant_type": "refresh_token", "scope": scope, "format": "jwe"})
+
 	respondJSON(w, http.StatusOK, response)
 }
 
 func (h *OAuthHandler) handleClientCredentialsGrant(w http.ResponseWriter, r *http.Request) {
-	clientID := r.FormValue("client_id")
-	clientSecret := r.FormValue("client_secret")
+	clientID, clientSecret := clientCredentials(r)
 	requestedScope := r.FormValue("scope")
 
-	if clientID == "" || clientSecret == "" {
+	if clientID == "" || (clientSecret == "" && clientCertificate(r, h.config) == nil && r.FormValue("client_assertion") == "") {
 		respondError(w, http.StatusBadRequest, "invalid_request", "Missing required parameters")
 		return
 	}
 
-	ctx := context.Background()
+	ctx := r.Context()
 	client, err := h.clientRepo.FindByClientID(ctx, clientID)
-	if err != nil || client.ClientSecret != clientSecret {
+	if err != nil || !authenticateClient(r, h.clientKeys, client, clientSecret, h.config) {
 		respondError(w, http.StatusUnauthorized, "invalid_client", "Invalid client credentials")
 		return
 	}
 
-	// Use minimal default scope if none provided
-	scope := requestedScope
-	if scope == "" {
-		scope = "openid"
-	} else {
-		// Validate requested scope format and existence
-		if err := utils.GlobalScopeValidator.ValidateScope(scope); err != nil {
-			respondError(w, http.StatusBadRequest, "invalid_scope", err.Error())
-			return
-		}
-		scope = utils.NormalizeScope(scope)
+	if client.Disabled {
+		respondClientDisabled(w, http.StatusUnauthorized)
+		return
+	}
+	if !utils.GrantTypeAllowed("client_credentials", client.GrantTypes) {
+		respondError(w, http.StatusBadRequest, "unauthorized_client", "Client is not authorized to use this grant type")
+		return
+	}
+
+	thumbprint, ok := certificateBinding(r, client, h.config)
+	if !ok {
+		respondError(w, http.StatusBadRequest, "invalid_request", "A client certificate is required for certificate-bound tokens")
+		return
+	}
+	jkt, ok := dpopBinding(w, r, h.dpopNonces, client, h.config)
+	if !ok {
+		return
 	}
+	cnf := utils.NewConfirmationClaim(thumbprint, jkt)
 
-	// Validate requested scopes against client's AllowedScopes
-	if err := utils.GlobalScopeValidator.ValidateScopeAgainstAllowed(scope, client.AllowedScopes); err != nil {
+	// Use minimal default scope if none provided
+	scope, err := resolveScope(client, requestedScope, "openid")
+	if err != nil {
 		respondError(w, http.StatusBadRequest, "invalid_scope", err.Error())
 		return
 	}
+	scope, ok = applyTokenPolicy(w, r, h.tokenPolicy, h.audit, "client_credentials", nil, client, scope)
+	if !ok {
+		return
+	}
 
-	// Generate access token with scope claim
-	accessToken, err := utils.GenerateAccessToken(
-		clientID,
-		"",
-		client.Name,
-		scope,
-		h.config.PrivateKey,
-		h.config.AccessTokenExpiry,
-	)
+	audience, err := tokenAudience(r.Form["resource"], nil, client.AllowedResources)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_target", err.Error())
+		return
+	}
+
+	// The client acts on its own behalf, so the token has no user claims
+	accessToken, err := h.tokens.issueClientAccessToken(ctx, client, scope, audience, cnf, h.config)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, "server_error", "Failed to generate access token")
+		respondInternalError(w, err, "Failed to generate access token")
 		return
 	}
 
 	response := models.TokenResponse{
 		AccessToken: accessToken,
-		TokenType:   "Bearer",
+		TokenType:   tokenType(cnf),
 		ExpiresIn:   h.config.AccessTokenExpiry,
 		Scope:       scope,
 	}
 
+	h.audit.record(r, models.AuditTokenIssued, "", clientID, map[string]string{"grant_type": "client_credentials", "scope": scope})
+
 	respondJSON(w, http.StatusOK, response)
 }
 
@@ -570,46 +1017,105 @@ func (h *OAuthHandler) UserInfo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	tokenString, dpop := accessTokenFromHeader(authHeader)
+
+	// Support JWT, JWE and opaque tokens
+	ctx := r.Context()
+	token, err := h.tokens.resolveAccessToken(ctx, tokenString, h.config)
+	if err != nil || !presentedBindingMatches(r, token, tokenString, dpop, h.config) {
+		respondError(w, http.StatusUnauthorized, "invalid_token", "Invalid or expired token")
+		return
+	}
+	if token.machine() {
+		respondError(w, http.StatusUnauthorized, "invalid_token", "The access token was not issued for a user")
+		return
+	}
+	userID := token.UserID
+	scope := token.Scope
+	clientID := token.ClientID
 
-	var scope string
-	var userID string
+	// Get user from database
+	user, err := h.userRepo.FindProfileByID(ctx, userID)
+	if err != nil {
+		respondInternalError(w, err, "Failed to find user")
+		return
+	}
 
-	// Support both JWT and JWE tokens
-	if utils.IsJWE(tokenString) {
-		jweClaims, err := utils.ValidateJWE(tokenString, h.config.PrivateKey)
+	// Tokens issued to a client only release claims for scopes whose
+	// time-limited grant is still active
+	if clientID != "" {
+		scope, err = h.activeGrantedScope(ctx, userID, clientID, scope)
 		if err != nil {
-			respondError(w, http.StatusUnauthorized, "invalid_token", "Invalid or expired token")
+			respondInternalError(w, err, "Failed to check consent")
 			return
 		}
-		userID = jweClaims.UserID
-		scope = jweClaims.Scope
-	} else {
-		jwtClaims, err := utils.ValidateToken(tokenString, h.config.PublicKey)
-		if err != nil {
-			respondError(w, http.StatusUnauthorized, "invalid_token", "Invalid or expired token")
+	}
+
+	// Filter claims based on scope using claim filtering service
+	filteredClaims := utils.FilterClaimsForUser(user, scope)
+
+	// Clients may register for a signed or encrypted response
+	if clientID != "" {
+		client, err := h.clientRepo.FindByClientID(ctx, clientID)
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			respondInternalError(w, err, "Failed to find client")
+			return
+		}
+		if client != nil && userInfoAsJWT(client) {
+			response, err := userInfoJWT(client, filteredClaims, h.config)
+			if err != nil {
+				respondInternalError(w, err, "Failed to sign or encrypt UserInfo response")
+				return
+			}
+			respondJWT(w, http.StatusOK, response)
 			return
 		}
-		userID = jwtClaims.UserID
-		scope = jwtClaims.Scope
 	}
 
-	// Get user from database
-	ctx := context.Background()
-	user, err := h.userRepo.FindByID(ctx, userID)
+	respondJSON(w, http.StatusOK, filteredClaims)
+}
+
+// activeGrantedScope removes scopes whose time-limited grant in the user's
+// consent for the client has expired. Scopes are returned unchanged when the
+// user has no stored consent for the client.
+func (h *OAuthHandler) activeGrantedScope(ctx context.Context, userID, clientID, scope string) (string, error) {
+	consent, err := h.consentRepo.FindByUserAndClient(ctx, userID, clientID)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, "server_error", "Failed to find user")
-		return
+		if errors.Is(err, repository.ErrNotFound) {
+			return scope, nil
+		}
+		return "", err
 	}
 
-	// Filter claims based on scope using claim filtering service
-	filteredClaims := utils.FilterClaimsForUser(user, scope)
+	now := time.Now()
+	active := make([]string, 0)
+	for _, s := range strings.Fields(scope) {
+		if !consent.ScopeExpired(s, now) {
+			active = append(active, s)
+		}
+	}
+	return strings.Join(active, " "), nil
+}
 
-	respondJSON(w, http.StatusOK, filteredClaims)
+// tokenAudience picks the audience for an access token. Resources requested
+// at the token endpoint must be within those granted at authorization, or
+// within the client's registered resources when nothing was granted. With no
+// resource parameter the token is bound to everything that was granted.
+func tokenAudience(requested, granted, allowed []string) ([]string, error) {
+	if len(granted) > 0 {
+		allowed = granted
+	}
+	if err := utils.ValidateResources(requested, allowed); err != nil {
+		return nil, err
+	}
+	if len(requested) > 0 {
+		return requested, nil
+	}
+	return granted, nil
 }
 
 func (h *OAuthHandler) handleTokenExchange(w http.ResponseWriter, r *http.Request) {
 	// Create a temporary TokenExchangeHandler to handle the request
-	tokenExchangeHandler := NewTokenExchangeHandler(h.userRepo, h.clientRepo, h.config)
+	tokenExchangeHandler := NewTokenExchangeHandler(h.userRepo, h.clientRepo, h.audit, h.tokens, h.dpopNonces, h.tokenPolicy, h.clientKeys, h.config)
 	tokenExchangeHandler.HandleTokenExchange(w, r)
 }
@@ -8,10 +8,12 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"oauth2-server/config"
 	"oauth2-server/models"
 	"oauth2-server/repository"
 	"oauth2-server/utils"
+	"strings"
 	"testing"
 	"time"
 
@@ -37,7 +39,7 @@ func TestUserInfoEndpoint(t *testing.T) {
 	userRepo := repository.NewUserRepository(db)
 	clientRepo := repository.NewClientRepository(db)
 	authCodeRepo := repository.NewAuthCodeRepository(db)
-	sessionRepo := repository.NewSessionRepository(db)
+	authRequestRepo := repository.NewAuthorizationRequestRepository(db)
 	consentRepo := repository.NewUserConsentRepository(db)
 
 	// Load test keys
@@ -64,7 +66,7 @@ func TestUserInfoEndpoint(t *testing.T) {
 		t.Fatalf("Failed to create test user: %v", err)
 	}
 
-	handler := NewOAuthHandler(userRepo, clientRepo, authCodeRepo, sessionRepo, consentRepo, cfg)
+	handler := NewOAuthHandler(userRepo, clientRepo, authCodeRepo, authRequestRepo, consentRepo, nil, nil, nil, nil, nil, nil, nil, cfg)
 
 	tests := []struct {
 		name           string
@@ -173,7 +175,7 @@ func TestUserInfoEndpointWithJWE(t *testing.T) {
 	userRepo := repository.NewUserRepository(db)
 	clientRepo := repository.NewClientRepository(db)
 	authCodeRepo := repository.NewAuthCodeRepository(db)
-	sessionRepo := repository.NewSessionRepository(db)
+	authRequestRepo := repository.NewAuthorizationRequestRepository(db)
 	consentRepo := repository.NewUserConsentRepository(db)
 
 	// Load test keys
@@ -200,7 +202,7 @@ func TestUserInfoEndpointWithJWE(t *testing.T) {
 		t.Fatalf("Failed to create test user: %v", err)
 	}
 
-	handler := NewOAuthHandler(userRepo, clientRepo, authCodeRepo, sessionRepo, consentRepo, cfg)
+	handler := NewOAuthHandler(userRepo, clientRepo, authCodeRepo, authRequestRepo, consentRepo, nil, nil, nil, nil, nil, nil, nil, cfg)
 
 	// Test with JWE token containing only openid scope
 	scope := "openid"
@@ -266,7 +268,7 @@ func TestUserInfoEndpointUnauthorized(t *testing.T) {
 	userRepo := repository.NewUserRepository(db)
 	clientRepo := repository.NewClientRepository(db)
 	authCodeRepo := repository.NewAuthCodeRepository(db)
-	sessionRepo := repository.NewSessionRepository(db)
+	authRequestRepo := repository.NewAuthorizationRequestRepository(db)
 	consentRepo := repository.NewUserConsentRepository(db)
 
 	privateKey, publicKey, err := utils.LoadTestKeys()
@@ -281,7 +283,7 @@ func TestUserInfoEndpointUnauthorized(t *testing.T) {
 		RefreshTokenExpiry: 86400,
 	}
 
-	handler := NewOAuthHandler(userRepo, clientRepo, authCodeRepo, sessionRepo, consentRepo, cfg)
+	handler := NewOAuthHandler(userRepo, clientRepo, authCodeRepo, authRequestRepo, consentRepo, nil, nil, nil, nil, nil, nil, nil, cfg)
 
 	// Test without Authorization header
 	req := httptest.NewRequest("GET", "/oauth/userinfo", nil)
@@ -321,7 +323,7 @@ func TestAuthorizeWithPromptParameter(t *testing.T) {
 	userRepo := repository.NewUserRepository(db)
 	clientRepo := repository.NewClientRepository(db)
 	authCodeRepo := repository.NewAuthCodeRepository(db)
-	sessionRepo := repository.NewSessionRepository(db)
+	authRequestRepo := repository.NewAuthorizationRequestRepository(db)
 	ssoSessionRepo := repository.NewSSOSessionRepository(db)
 	consentRepo := repository.NewUserConsentRepository(db)
 
@@ -362,7 +364,7 @@ func TestAuthorizeWithPromptParameter(t *testing.T) {
 		t.Fatalf("Failed to create test client: %v", err)
 	}
 
-	handler := NewOAuthHandler(userRepo, clientRepo, authCodeRepo, sessionRepo, consentRepo, cfg)
+	handler := NewOAuthHandler(userRepo, clientRepo, authCodeRepo, authRequestRepo, consentRepo, ssoSessionRepo, nil, nil, nil, nil, nil, nil, cfg)
 
 	t.Run("prompt=none without SSO session returns login_required", func(t *testing.T) {
 		req := httptest.NewRequest("GET", "/oauth/authorize?response_type=code&client_id=test-client-prompt&redirect_uri=http://localhost:3000/callback&scope=openid&state=xyz&prompt=none", nil)
@@ -489,8 +491,8 @@ func TestAuthorizeWithPromptParameter(t *testing.T) {
 		if !contains(location, "/auth/login") {
 			t.Errorf("Expected redirect to login page, got: %s", location)
 		}
-		if !contains(location, "session_id=") {
-			t.Errorf("Expected session_id in redirect, got: %s", location)
+		if !contains(location, "login_challenge=") {
+			t.Errorf("Expected login_challenge in redirect, got: %s", location)
 		}
 	})
 
@@ -519,15 +521,17 @@ func TestAuthorizeWithPromptParameter(t *testing.T) {
 		if !contains(location, "/oauth/consent") {
 			t.Errorf("Expected redirect to consent screen, got: %s", location)
 		}
-		if !contains(location, "client_id=test-client-prompt") {
-			t.Errorf("Expected client_id in redirect, got: %s", location)
+		u, _ := url.Parse(location)
+		request, err := authRequestRepo.FindByChallenge(context.Background(), u.Query().Get("consent_challenge"))
+		if err != nil {
+			t.Fatalf("Expected the request to be stored under the consent challenge: %v", err)
 		}
-		if !contains(location, "state=jkl") {
-			t.Errorf("Expected state parameter in redirect, got: %s", location)
+		if request.Stage != models.AuthorizationStageConsent || request.ClientID != "test-client-prompt" || request.State != "jkl" {
+			t.Errorf("Unexpected stored request %+v", request)
 		}
 	})
 
-	t.Run("prompt=select_account forces re-authentication", func(t *testing.T) {
+	t.Run("prompt=select_account without signed-in accounts shows the login page", func(t *testing.T) {
 		ssoSession := &models.SSOSession{
 			SessionID:     "test-sso-session-5",
 			UserID:        testUser.ID,
@@ -543,7 +547,7 @@ func TestAuthorizeWithPromptParameter(t *testing.T) {
 
 		handler.Authorize(w, req)
 
-		// Should redirect to login page (placeholder behavior)
+		// No accounts cookie, so there is nothing to choose from
 		if w.Code != http.StatusFound {
 			t.Errorf("Expected status 302, got %d", w.Code)
 		}
@@ -553,6 +557,353 @@ func TestAuthorizeWithPromptParameter(t *testing.T) {
 			t.Errorf("Expected redirect to login page, got: %s", location)
 		}
 	})
+
+	t.Run("prompt=select_account with signed-in accounts shows the chooser", func(t *testing.T) {
+		ssoSession := &models.SSOSession{
+			SessionID:     "test-sso-session-select",
+			UserID:        testUser.ID,
+			Authenticated: true,
+			CreatedAt:     time.Now(),
+			ExpiresAt:     time.Now().Add(7 * 24 * time.Hour),
+			LastActivity:  time.Now(),
+		}
+		if err := ssoSessionRepo.Create(ctx, ssoSession); err != nil {
+			t.Fatalf("Failed to create SSO session: %v", err)
+		}
+
+		req := httptest.NewRequest("GET", "/oauth/authorize?response_type=code&client_id=test-client-prompt&redirect_uri=http://localhost:3000/callback&scope=openid&state=mno2&prompt=select_account", nil)
+		req.AddCookie(&http.Cookie{Name: SSOAccountsCookieName, Value: "expired-session." + ssoSession.SessionID})
+		req = req.WithContext(context.WithValue(req.Context(), "sso_session", ssoSession))
+		w := httptest.NewRecorder()
+
+		handler.Authorize(w, req)
+
+		location := w.Header().Get("Location")
+		if w.Code != http.StatusFound || !contains(location, "/auth/select-account?login_challenge=") {
+			t.Fatalf("Expected redirect to the account chooser, got %d %s", w.Code, location)
+		}
+		u, _ := url.Parse(location)
+		request, err := authRequestRepo.FindByChallenge(ctx, u.Query().Get("login_challenge"))
+		if err != nil || request.Stage != models.AuthorizationStageLogin || request.State != "mno2" {
+			t.Errorf("Expected the request to wait for login, got %+v (%v)", request, err)
+		}
+	})
+
+	t.Run("login_hint for another user with prompt=none returns account_selection_required", func(t *testing.T) {
+		ssoSession := &models.SSOSession{
+			SessionID:     "test-sso-session-6",
+			UserID:        testUser.ID,
+			Authenticated: true,
+			CreatedAt:     time.Now(),
+			ExpiresAt:     time.Now().Add(7 * 24 * time.Hour),
+			LastActivity:  time.Now(),
+		}
+
+		req := httptest.NewRequest("GET", "/oauth/authorize?response_type=code&client_id=test-client-prompt&redirect_uri=http://localhost:3000/callback&scope=openid+profile&state=pqr&prompt=none&login_hint=other@example.com", nil)
+		req = req.WithContext(context.WithValue(req.Context(), "sso_session", ssoSession))
+		w := httptest.NewRecorder()
+
+		handler.Authorize(w, req)
+
+		location := w.Header().Get("Location")
+		if !contains(location, "error=account_selection_required") {
+			t.Errorf("Expected account_selection_required error in redirect, got: %s", location)
+		}
+	})
+
+	t.Run("login_hint for another user forces login", func(t *testing.T) {
+		ssoSession := &models.SSOSession{
+			SessionID:     "test-sso-session-7",
+			UserID:        testUser.ID,
+			Authenticated: true,
+			CreatedAt:     time.Now(),
+			ExpiresAt:     time.Now().Add(7 * 24 * time.Hour),
+			LastActivity:  time.Now(),
+		}
+
+		req := httptest.NewRequest("GET", "/oauth/authorize?response_type=code&client_id=test-client-prompt&redirect_uri=http://localhost:3000/callback&scope=openid+profile&state=stu&login_hint=other@example.com", nil)
+		req = req.WithContext(context.WithValue(req.Context(), "sso_session", ssoSession))
+		w := httptest.NewRecorder()
+
+		handler.Authorize(w, req)
+
+		location := w.Header().Get("Location")
+		if !contains(location, "/auth/login") {
+			t.Errorf("Expected redirect to login page, got: %s", location)
+		}
+	})
+
+	t.Run("login_hint matching the signed in user reuses the SSO session", func(t *testing.T) {
+		ssoSession := &models.SSOSession{
+			SessionID:     "test-sso-session-8",
+			UserID:        testUser.ID,
+			Authenticated: true,
+			CreatedAt:     time.Now(),
+			ExpiresAt:     time.Now().Add(7 * 24 * time.Hour),
+			LastActivity:  time.Now(),
+		}
+
+		req := httptest.NewRequest("GET", "/oauth/authorize?response_type=code&client_id=test-client-prompt&redirect_uri=http://localhost:3000/callback&scope=openid+profile&state=vwx&prompt=none&login_hint=Prompt@Example.com", nil)
+		req = req.WithContext(context.WithValue(req.Context(), "sso_session", ssoSession))
+		w := httptest.NewRecorder()
+
+		handler.Authorize(w, req)
+
+		location := w.Header().Get("Location")
+		if !contains(location, "code=") {
+			t.Errorf("Expected authorization code in redirect, got: %s", location)
+		}
+	})
+
+	t.Run("acr_values=mfa sends a password session to the MFA challenge", func(t *testing.T) {
+		ssoSession := &models.SSOSession{
+			SessionID:     "test-sso-session-9",
+			UserID:        testUser.ID,
+			Authenticated: true,
+			CreatedAt:     time.Now(),
+			ExpiresAt:     time.Now().Add(7 * 24 * time.Hour),
+			LastActivity:  time.Now(),
+		}
+
+		req := httptest.NewRequest("GET", "/oauth/authorize?response_type=code&client_id=test-client-prompt&redirect_uri=http://localhost:3000/callback&scope=openid+profile&state=yza&acr_values=mfa", nil)
+		req = req.WithContext(context.WithValue(req.Context(), "sso_session", ssoSession))
+		w := httptest.NewRecorder()
+
+		handler.Authorize(w, req)
+
+		location := w.Header().Get("Location")
+		if !contains(location, "/auth/mfa?mfa_challenge=") {
+			t.Errorf("Expected redirect to the MFA challenge, got: %s", location)
+		}
+
+		req = httptest.NewRequest("GET", "/oauth/authorize?response_type=code&client_id=test-client-prompt&redirect_uri=http://localhost:3000/callback&scope=openid+profile&state=yza&acr_values=mfa&prompt=none", nil)
+		req = req.WithContext(context.WithValue(req.Context(), "sso_session", ssoSession))
+		w = httptest.NewRecorder()
+
+		handler.Authorize(w, req)
+
+		if location := w.Header().Get("Location"); !contains(location, "error=interaction_required") {
+			t.Errorf("Expected interaction_required error in redirect, got: %s", location)
+		}
+	})
+
+	t.Run("acr_values=mfa reuses a session that completed MFA", func(t *testing.T) {
+		ssoSession := &models.SSOSession{
+			SessionID:     "test-sso-session-10",
+			UserID:        testUser.ID,
+			Authenticated: true,
+			CreatedAt:     time.Now(),
+			ExpiresAt:     time.Now().Add(7 * 24 * time.Hour),
+			LastActivity:  time.Now(),
+			MFAAt:         time.Now(),
+		}
+
+		req := httptest.NewRequest("GET", "/oauth/authorize?response_type=code&client_id=test-client-prompt&redirect_uri=http://localhost:3000/callback&scope=openid+profile&state=bcd&acr_values=mfa", nil)
+		req = req.WithContext(context.WithValue(req.Context(), "sso_session", ssoSession))
+		w := httptest.NewRecorder()
+
+		handler.Authorize(w, req)
+
+		location := w.Header().Get("Location")
+		if !contains(location, "code=") {
+			t.Errorf("Expected authorization code in redirect, got: %s", location)
+		}
+		code, _ := url.Parse(location)
+		authCode, err := authCodeRepo.FindByCode(ctx, code.Query().Get("code"))
+		if err != nil || authCode.ACR != ACRMFA {
+			t.Errorf("Expected the code to carry acr %q, got %v, %v", ACRMFA, authCode, err)
+		}
+	})
+}
+
+// TestCheckCodeOrigin tests the IP address check at code redemption
+func TestCheckCodeOrigin(t *testing.T) {
+	authCode := &models.AuthorizationCode{
+		Code:        "code",
+		RequestedAt: time.Now().Add(-time.Minute),
+		RequestIP:   "192.0.2.1",
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		strict     bool
+		want       bool
+	}{
+		{"same address", "192.0.2.1:5000", true, true},
+		{"different address warns", "198.51.100.7:5000", false, true},
+		{"different address rejected", "198.51.100.7:5000", true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewOAuthHandler(nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, &config.Config{RejectCodeIPMismatch: tt.strict})
+			req := httptest.NewRequest("POST", "/oauth/token", nil)
+			req.RemoteAddr = tt.remoteAddr
+
+			if got := handler.checkCodeOrigin(req, authCode); got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	// Codes issued before the address was recorded are not checked
+	handler := NewOAuthHandler(nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, &config.Config{RejectCodeIPMismatch: true})
+	if !handler.checkCodeOrigin(httptest.NewRequest("POST", "/oauth/token", nil), &models.AuthorizationCode{Code: "legacy"}) {
+		t.Error("Expected a code without a request address to be accepted")
+	}
+}
+
+// TestCheckCodeSession tests the SSO session check at code redemption
+func TestCheckCodeSession(t *testing.T) {
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI("mongodb://localhost:27017"))
+	if err != nil {
+		t.Skip("MongoDB not available, skipping integration test")
+		return
+	}
+	defer client.Disconnect(ctx)
+
+	db := client.Database("oauth2_test_code_session")
+	defer db.Drop(ctx)
+
+	ssoSessionRepo := repository.NewSSOSessionRepository(db)
+	active := &models.SSOSession{
+		SessionID:     "sso-active",
+		UserID:        "user-1",
+		Authenticated: true,
+		CreatedAt:     time.Now(),
+		ExpiresAt:     time.Now().Add(time.Hour),
+	}
+	if err := ssoSessionRepo.Create(ctx, active); err != nil {
+		t.Skipf("MongoDB not available, skipping integration test: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		sessionID string
+		strict    bool
+		want      bool
+	}{
+		{"no session recorded", "", true, true},
+		{"active session", "sso-active", true, true},
+		{"ended session warns", "sso-revoked", false, true},
+		{"ended session rejected", "sso-revoked", true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewOAuthHandler(nil, nil, nil, nil, nil, ssoSessionRepo, nil, nil, nil, nil, nil, nil, &config.Config{RejectCodeEndedSession: tt.strict})
+			authCode := &models.AuthorizationCode{Code: "code", UserID: "user-1", SSOSessionID: tt.sessionID}
+
+			if got := handler.checkCodeSession(httptest.NewRequest("POST", "/oauth/token", nil), authCode); got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestAuthorizationCodeGrant_ForeignClient tests that another client
+// presenting a redeemed code gets a mismatch instead of revoking the grant
+func TestAuthorizationCodeGrant_ForeignClient(t *testing.T) {
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI("mongodb://localhost:27017"))
+	if err != nil {
+		t.Skip("MongoDB not available, skipping integration test")
+		return
+	}
+	defer client.Disconnect(ctx)
+
+	db := client.Database("oauth2_test_foreign_client")
+	defer db.Drop(ctx)
+
+	clientRepo := repository.NewClientRepository(db)
+	authCodeRepo := repository.NewAuthCodeRepository(db)
+	for _, c := range []*models.Client{
+		{ClientID: "owner-client", Name: "Owner", RedirectURIs: []string{"http://localhost:3000/callback"}},
+		{ClientID: "foreign-client", Name: "Foreign", RedirectURIs: []string{"http://localhost:3000/callback"}},
+	} {
+		if err := clientRepo.Create(ctx, c); err != nil {
+			t.Skipf("MongoDB not available, skipping integration test: %v", err)
+		}
+	}
+	if err := authCodeRepo.Create(ctx, &models.AuthorizationCode{
+		Code:        "redeemed-code",
+		ClientID:    "owner-client",
+		UserID:      "user-1",
+		RedirectURI: "http://localhost:3000/callback",
+		ExpiresAt:   time.Now().Add(time.Minute),
+	}); err != nil {
+		t.Fatalf("Failed to create code: %v", err)
+	}
+	if err := authCodeRepo.Redeem(ctx, "redeemed-code"); err != nil {
+		t.Fatalf("Failed to redeem code: %v", err)
+	}
+
+	revocations := repository.NewRevocationRepository(db)
+	handler := NewOAuthHandler(nil, clientRepo, authCodeRepo, nil, nil, nil, nil, nil, NewTokenStore(nil, revocations), nil, nil, nil, &config.Config{AccessTokenExpiry: 3600})
+	redeem := func(clientID, redirectURI string) map[string]string {
+		form := url.Values{
+			"grant_type":   {"authorization_code"},
+			"code":         {"redeemed-code"},
+			"client_id":    {clientID},
+			"redirect_uri": {redirectURI},
+		}
+		req := httptest.NewRequest("POST", "/oauth/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		handler.handleAuthorizationCodeGrant(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("Expected status 400, got %d: %s", w.Code, w.Body.String())
+		}
+		var body map[string]string
+		json.Unmarshal(w.Body.Bytes(), &body)
+		return body
+	}
+
+	for _, tt := range []struct{ clientID, redirectURI string }{
+		{"foreign-client", "http://localhost:3000/callback"},
+		{"owner-client", "http://localhost:3000/other"},
+	} {
+		body := redeem(tt.clientID, tt.redirectURI)
+		if body["error_description"] != "Code mismatch" {
+			t.Errorf("Expected a code mismatch for %s at %s, got %v", tt.clientID, tt.redirectURI, body)
+		}
+	}
+	if revokedAt, err := revocations.RevokedAt(ctx, "user-1", "owner-client"); err != nil || !revokedAt.IsZero() {
+		t.Errorf("Expected the owner's grant to stay valid, got %v (%v)", revokedAt, err)
+	}
+
+	// The client the code was issued to still triggers replay handling
+	body := redeem("owner-client", "http://localhost:3000/callback")
+	if body["error_description"] != "Authorization code has already been used" {
+		t.Errorf("Expected a replay, got %v", body)
+	}
+	if revokedAt, err := revocations.RevokedAt(ctx, "user-1", "owner-client"); err != nil || revokedAt.IsZero() {
+		t.Errorf("Expected the owner's grant to be revoked, got %v (%v)", revokedAt, err)
+	}
+}
+
+// TestIssuesRefreshToken tests offline_access enforcement on the code grant
+func TestIssuesRefreshToken(t *testing.T) {
+	tests := []struct {
+		name    string
+		scope   string
+		require bool
+		want    bool
+	}{
+		{"not enforced", "openid profile", false, true},
+		{"offline access granted", "openid offline_access", true, true},
+		{"offline access not granted", "openid profile", true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := issuesRefreshToken(tt.scope, &config.Config{RequireOfflineAccess: tt.require}); got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
 }
 
 // Helper function to check if a string contains a substring
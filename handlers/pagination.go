@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"oauth2-server/config"
+	"oauth2-server/repository"
+	"slices"
+	"strings"
+)
+
+// PageInfo is the paging metadata of a list response. NextCursor is passed
+// back as cursor to fetch the following page.
+type PageInfo struct {
+	Limit      int64  `json:"limit"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	HasMore    bool   `json:"has_more"`
+}
+
+func newPageInfo(page repository.PageRequest, next string) PageInfo {
+	return PageInfo{Limit: page.Limit, NextCursor: next, HasMore: next != ""}
+}
+
+// parsePageRequest reads limit, cursor, sort and order from the query.
+// sortFields lists the fields the list can be sorted by, the first being the
+// default; lists are newest first unless order=asc.
+func parsePageRequest(query url.Values, sortFields ...string) (repository.PageRequest, error) {
+	limit := parsePositiveInt(query.Get("limit"), defaultAdminPageSize)
+	if limit > maxAdminPageSize {
+		limit = maxAdminPageSize
+	}
+
+	sort := query.Get("sort")
+	if sort == "" {
+		sort = sortFields[0]
+	}
+	if !slices.Contains(sortFields, sort) {
+		return repository.PageRequest{}, errors.New("sort must be one of " + strings.Join(sortFields, ", "))
+	}
+
+	order := query.Get("order")
+	if order != "" && order != "asc" && order != "desc" {
+		return repository.PageRequest{}, errors.New("order must be asc or desc")
+	}
+
+	return repository.PageRequest{
+		Limit:     limit,
+		Cursor:    query.Get("cursor"),
+		SortField: sort,
+		Ascending: order == "asc",
+	}, nil
+}
+
+// sessionFilter reads the active filter of a session listing
+func sessionFilter(r *http.Request, cfg *config.Config) repository.SessionFilter {
+	return repository.SessionFilter{
+		ActiveOnly:  r.URL.Query().Get("active") == "true",
+		IdleTimeout: sessionActivity(cfg).IdleTimeout,
+	}
+}
+
+// consentFilter reads the client_id and active filters of a consent listing
+func consentFilter(r *http.Request) repository.ConsentFilter {
+	query := r.URL.Query()
+	return repository.ConsentFilter{
+		ClientID:   query.Get("client_id"),
+		ActiveOnly: query.Get("active") == "true",
+	}
+}
+
+// respondPageError reports a failed page query: a bad cursor is the
+// caller's fault, anything else is a server error
+func respondPageError(w http.ResponseWriter, err error, description string) {
+	if errors.Is(err, repository.ErrInvalidCursor) {
+		respondError(w, http.StatusBadRequest, "invalid_request", "Invalid cursor")
+		return
+	}
+	respondInternalError(w, err, description)
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestParsePageRequest(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		wantErr   bool
+		limit     int64
+		sortField string
+		ascending bool
+	}{
+		{"Defaults", "", false, defaultAdminPageSize, "created_at", false},
+		{"Sort and order", "sort=last_activity&order=asc&limit=5", false, 5, "last_activity", true},
+		{"Limit capped", "limit=1000", false, maxAdminPageSize, "created_at", false},
+		{"Unknown sort", "sort=user_agent", true, 0, "", false},
+		{"Unknown order", "order=sideways", true, 0, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, _ := url.ParseQuery(tt.query)
+			page, err := parsePageRequest(query, "created_at", "last_activity")
+			if tt.wantErr {
+				if err == nil {
+					t.Error("Expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if page.Limit != tt.limit || page.SortField != tt.sortField || page.Ascending != tt.ascending {
+				t.Errorf("Unexpected page request: %+v", page)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"oauth2-server/utils"
+	"unicode/utf8"
+)
+
+// BreachChecker reports whether a password is known from a data breach
+type BreachChecker interface {
+	Breached(ctx context.Context, password string) (bool, error)
+}
+
+// passwordWeakness returns why a new password is refused, or "" when it
+// meets the policy. Without a breach list passwords are not checked against
+// breaches; a failing breach check is logged and does not block the user.
+func (h *AuthHandler) passwordWeakness(ctx context.Context, password string) string {
+	if minLength := int(h.config.PasswordMinLength); utf8.RuneCountInString(password) < minLength {
+		return fmt.Sprintf("Password must be at least %d characters", minLength)
+	}
+	if maxLength := utils.PasswordHashing.MaxPasswordLength(); maxLength > 0 && len(password) > maxLength {
+		return fmt.Sprintf("Password must be at most %d bytes", maxLength)
+	}
+	if h.breached != nil {
+		breached, err := h.breached.Breached(ctx, password)
+		if err != nil {
+			log.Printf("Failed to check password against breaches: %v", err)
+		} else if breached {
+			return "This password has appeared in a data breach, choose another one"
+		}
+	}
+	return ""
+}
+
+// checkPasswordPolicy writes a weak_password error when a new password does
+// not meet the policy
+func (h *AuthHandler) checkPasswordPolicy(w http.ResponseWriter, r *http.Request, password string) bool {
+	if reason := h.passwordWeakness(r.Context(), password); reason != "" {
+		respondError(w, http.StatusBadRequest, "weak_password", reason)
+		return false
+	}
+	return true
+}
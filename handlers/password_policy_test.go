@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"context"
+	"oauth2-server/breach"
+	"oauth2-server/config"
+	"strings"
+	"testing"
+)
+
+func TestPasswordWeakness(t *testing.T) {
+	h := &AuthHandler{breached: breach.New("password123"), config: &config.Config{PasswordMinLength: 8}}
+
+	tests := []struct {
+		name     string
+		password string
+		want     string
+	}{
+		{"acceptable", "correct horse battery", ""},
+		{"too short", "short", "Password must be at least 8 characters"},
+		{"counted in characters", "รหัสผ่านยาว", ""},
+		{"too long for bcrypt", strings.Repeat("a", 73), "Password must be at most 72 bytes"},
+		{"breached", "password123", "This password has appeared in a data breach, choose another one"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := h.passwordWeakness(context.Background(), tt.password); got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
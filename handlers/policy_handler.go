@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"net/http"
+	"oauth2-server/config"
+	"oauth2-server/models"
+	"oauth2-server/utils"
+	"sort"
+)
+
+// authorizationCodeLifetime is how long an issued authorization code stays valid
+const authorizationCodeLifetime = 600
+
+// PolicyHandler serves the authorization server policy document used by
+// platform tooling to auto-configure relying parties
+type PolicyHandler struct {
+	issuer     string
+	registry   *models.ScopeRegistry
+	config     *config.Config
+	grantTypes []string
+}
+
+func NewPolicyHandler(issuer string, registry *models.ScopeRegistry, cfg *config.Config, grantTypes []string) *PolicyHandler {
+	return &PolicyHandler{
+		issuer:     issuer,
+		registry:   registry,
+		config:     cfg,
+		grantTypes: grantTypes,
+	}
+}
+
+// PolicyDocument is the body of /.well-known/oauth-policy
+type PolicyDocument struct {
+	Issuer         string            `json:"issuer"`
+	TokenLifetimes TokenLifetimes    `json:"token_lifetimes"`
+	Prompts        []string          `json:"prompts_supported"`
+	PKCE           PKCEPolicy        `json:"pkce"`
+	Consent        ConsentPolicyInfo `json:"consent"`
+	GrantTypes     []string          `json:"grant_types_supported"`
+	ResponseTypes  []string          `json:"response_types_supported"`
+	Scopes         []ScopePolicy     `json:"scopes"`
+}
+
+// TokenLifetimes lists lifetimes in seconds
+type TokenLifetimes struct {
+	AccessToken       int64 `json:"access_token"`
+	RefreshToken      int64 `json:"refresh_token"`
+	IDToken           int64 `json:"id_token"`
+	AuthorizationCode int64 `json:"authorization_code"`
+}
+
+// PKCEPolicy describes PKCE support. PKCE is optional; when a code_challenge
+// is sent at authorization the code_verifier is required at the token endpoint.
+type PKCEPolicy struct {
+	Required         bool     `json:"required"`
+	Methods          []string `json:"code_challenge_methods_supported"`
+	DefaultMethod    string   `json:"default_code_challenge_method"`
+	VerifierRequired bool     `json:"verifier_required_when_challenged"`
+}
+
+// ConsentPolicyInfo describes the default consent persistence behaviour
+type ConsentPolicyInfo struct {
+	DefaultPolicy      string           `json:"default_policy"`
+	Policies           []string         `json:"policies_supported"`
+	TTLDays            int64            `json:"ttl_days"`
+	ScopeTTLDays       map[string]int64 `json:"scope_ttl_days,omitempty"`
+	ScopeGrantMinutes  map[string]int64 `json:"scope_grant_ttl_minutes,omitempty"`
+	RenewalWindowDays  int64            `json:"renewal_window_days"`
+	ClientOverridesTTL bool             `json:"client_ttl_override_supported"`
+	PartialConsent     bool             `json:"partial_consent_supported"`
+	RequiredScopes     []string         `json:"required_scopes"`
+	IncrementalConsent bool             `json:"incremental_consent_supported"`
+}
+
+// ScopePolicy is one entry of the scope catalog
+type ScopePolicy struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Claims      []string `json:"claims,omitempty"`
+	Default     bool     `json:"default"`
+	Sensitive   bool     `json:"sensitive"`
+}
+
+// Policy returns the policy document
+// GET /.well-known/oauth-policy
+func (h *PolicyHandler) Policy(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, h.document())
+}
+
+func (h *PolicyHandler) document() PolicyDocument {
+	consentTTLDays := h.config.ConsentTTLDays
+	if consentTTLDays <= 0 {
+		consentTTLDays = int64(defaultConsentTTL.Hours() / 24)
+	}
+
+	return PolicyDocument{
+		Issuer: h.issuer,
+		TokenLifetimes: TokenLifetimes{
+			AccessToken:       h.config.AccessTokenExpiry,
+			RefreshToken:      h.config.RefreshTokenExpiry,
+			IDToken:           h.config.AccessTokenExpiry,
+			AuthorizationCode: authorizationCodeLifetime,
+		},
+		Prompts: []string{"none", "login", "consent", "select_account"},
+		PKCE: PKCEPolicy{
+			Required:         false,
+			Methods:          []string{"S256", "plain"},
+			DefaultMethod:    "plain",
+			VerifierRequired: true,
+		},
+		Consent: ConsentPolicyInfo{
+			DefaultPolicy:      resolveConsentPolicy(nil, h.config),
+			Policies:           []string{models.ConsentPolicyPermanent, models.ConsentPolicyTTL, models.ConsentPolicySession},
+			TTLDays:            consentTTLDays,
+			ScopeTTLDays:       h.config.ScopeConsentTTLDays,
+			ScopeGrantMinutes:  h.config.ScopeGrantTTLMinutes,
+			RenewalWindowDays:  h.config.ConsentRenewalWindowDays,
+			ClientOverridesTTL: true,
+			PartialConsent:     true,
+			RequiredScopes:     []string{"openid"},
+			IncrementalConsent: true,
+		},
+		GrantTypes:    h.grantTypes,
+		ResponseTypes: utils.SupportedResponseTypes,
+		Scopes:        h.scopeCatalog(),
+	}
+}
+
+// scopeCatalog lists registered scopes sorted by name
+func (h *PolicyHandler) scopeCatalog() []ScopePolicy {
+	allScopes := h.registry.GetAllScopes()
+	catalog := make([]ScopePolicy, 0, len(allScopes))
+	for _, scope := range allScopes {
+		catalog = append(catalog, ScopePolicy{
+			Name:        scope.Name,
+			Description: scope.Description,
+			Claims:      scope.Claims,
+			Default:     scope.IsDefault,
+			Sensitive:   scope.Sensitive,
+		})
+	}
+
+	sort.Slice(catalog, func(i, j int) bool {
+		return catalog[i].Name < catalog[j].Name
+	})
+	return catalog
+}
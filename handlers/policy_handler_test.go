@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"oauth2-server/config"
+	"oauth2-server/models"
+	"testing"
+)
+
+func TestPolicyHandler_Policy(t *testing.T) {
+	cfg := &config.Config{
+		AccessTokenExpiry:  3600,
+		RefreshTokenExpiry: 604800,
+		ConsentPolicy:      models.ConsentPolicyTTL,
+		ConsentTTLDays:     90,
+	}
+	handler := NewPolicyHandler("https://example.com", models.NewScopeRegistry(), cfg, []string{"authorization_code", "refresh_token"})
+
+	w := httptest.NewRecorder()
+	handler.Policy(w, httptest.NewRequest("GET", "/.well-known/oauth-policy", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	var doc PolicyDocument
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+
+	if doc.Issuer != "https://example.com" {
+		t.Errorf("Expected issuer https://example.com, got %s", doc.Issuer)
+	}
+	if doc.TokenLifetimes.AccessToken != 3600 || doc.TokenLifetimes.RefreshToken != 604800 {
+		t.Errorf("Unexpected token lifetimes: %+v", doc.TokenLifetimes)
+	}
+	if doc.Consent.TTLDays != 90 {
+		t.Errorf("Expected consent ttl of 90 days, got %d", doc.Consent.TTLDays)
+	}
+	if len(doc.GrantTypes) != 2 {
+		t.Errorf("Expected 2 grant types, got %v", doc.GrantTypes)
+	}
+
+	found := false
+	for _, scope := range doc.Scopes {
+		if scope.Name == "openid" {
+			found = true
+			if scope.Description == "" {
+				t.Error("Expected openid scope to have a description")
+			}
+		}
+	}
+	if !found {
+		t.Error("Expected openid in the scope catalog")
+	}
+}
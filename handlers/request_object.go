@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"oauth2-server/models"
+	"oauth2-server/repository"
+	"strconv"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// requestObjectClaims are JWT claims of a request object that are not
+// authorization request parameters
+var requestObjectClaims = map[string]bool{"iss": true, "aud": true, "exp": true, "iat": true, "nbf": true, "jti": true, "sub": true}
+
+// applyRequestObject replaces the authorization request parameters with
+// those of the signed request object passed in the request parameter (RFC
+// 9101 section 6.3), so the rest of the authorization flow reads them from
+// the query as usual. It writes the error response and returns false when
+// the request object is not valid.
+func (h *OAuthHandler) applyRequestObject(w http.ResponseWriter, r *http.Request) bool {
+	query := r.URL.Query()
+	if query.Has("request_uri") {
+		respondError(w, http.StatusBadRequest, "request_uri_not_supported", "The request_uri parameter is not supported")
+		return false
+	}
+	if !query.Has("request") {
+		return true
+	}
+
+	clientID := query.Get("client_id")
+	if clientID == "" {
+		respondError(w, http.StatusBadRequest, "invalid_request", "client_id is required with a request object")
+		return false
+	}
+	client, err := h.clientRepo.FindByClientID(r.Context(), clientID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			respondError(w, http.StatusBadRequest, "invalid_client", "Client not found")
+			return false
+		}
+		respondInternalError(w, err, "Failed to validate client")
+		return false
+	}
+
+	params, err := h.requestObjectParams(r, client, query.Get("request"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_request_object", err.Error())
+		return false
+	}
+	r.URL.RawQuery = params.Encode()
+	return true
+}
+
+// requestObjectParams verifies a request object signed by the client for
+// this server and returns the authorization request parameters it carries
+func (h *OAuthHandler) requestObjectParams(r *http.Request, client *models.Client, requestObject string) (url.Values, error) {
+	claims := jwt.MapClaims{}
+	if err := parseClientJWT(r.Context(), h.clientKeys, client, requestObject, claims, jwt.WithAudience(h.config.IssuerURL)); err != nil {
+		return nil, fmt.Errorf("Invalid request object: %v", err)
+	}
+	if id, ok := claims["client_id"]; ok && id != client.ClientID {
+		return nil, errors.New("The client_id of the request object does not match the request")
+	}
+
+	params := url.Values{"client_id": {client.ClientID}}
+	for name, value := range claims {
+		if requestObjectClaims[name] || name == "client_id" {
+			continue
+		}
+		switch v := value.(type) {
+		case string:
+			params.Set(name, v)
+		case float64:
+			params.Set(name, strconv.FormatFloat(v, 'f', -1, 64))
+		case bool:
+			params.Set(name, strconv.FormatBool(v))
+		case []interface{}:
+			// Parameters that may repeat, such as resource
+			for _, item := range v {
+				s, ok := item.(string)
+				if !ok {
+					return nil, fmt.Errorf("Unsupported value of %s in the request object", name)
+				}
+				params.Add(name, s)
+			}
+		default:
+			return nil, fmt.Errorf("Unsupported value of %s in the request object", name)
+		}
+	}
+	return params, nil
+}
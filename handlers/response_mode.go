@@ -2,8 +2,10 @@ package handlers
 
 import (
 	"encoding/json"
+	"html"
 	"net/http"
 	"net/url"
+	"oauth2-server/middleware"
 )
 
 // Response modes supported by the OAuth server
@@ -74,26 +76,62 @@ func sendJSONResponse(w http.ResponseWriter, redirectURI string, params map[stri
 
 // sendQueryResponse redirects with parameters in query string (default OAuth behavior)
 func sendQueryResponse(w http.ResponseWriter, redirectURI string, params map[string]string) {
-	u, err := url.Parse(redirectURI)
+	values := url.Values{}
+	for key, value := range params {
+		values.Set(key, value)
+	}
+	redirectToClient(w, &http.Request{}, redirectURI, values)
+}
+
+// redirectToClient sends the user agent back to the client's redirect URI
+// with params added to its query. Parameters are always encoded, so a state
+// or scope containing "&", "#" or spaces arrives intact, and any query the
+// redirect URI was registered with is kept (RFC 6749 section 3.1.2).
+func redirectToClient(w http.ResponseWriter, r *http.Request, redirectURI string, params url.Values) {
+	location, err := clientRedirectURL(redirectURI, params)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, "server_error", "Invalid redirect URI")
+		respondInternalError(w, err, "Invalid redirect URI")
 		return
 	}
+	http.Redirect(w, r, location, http.StatusFound)
+}
+
+// clientRedirectURL returns the redirect URI with params added to its query
+func clientRedirectURL(redirectURI string, params url.Values) (string, error) {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return "", err
+	}
 
 	q := u.Query()
-	for key, value := range params {
-		q.Set(key, value)
+	for key, values := range params {
+		q[key] = values
 	}
 	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
 
-	http.Redirect(w, &http.Request{}, u.String(), http.StatusFound)
+// authorizationError holds the parameters of an error response sent to the
+// client's redirect URI
+func authorizationError(w http.ResponseWriter, code, description, state string) url.Values {
+	params := url.Values{
+		"error":             {code},
+		"error_description": {description},
+	}
+	if uri := middleware.ErrorURIOf(w, code); uri != "" {
+		params.Set("error_uri", uri)
+	}
+	if state != "" {
+		params.Set("state", state)
+	}
+	return params
 }
 
 // sendFragmentResponse redirects with parameters in URL fragment
 func sendFragmentResponse(w http.ResponseWriter, redirectURI string, params map[string]string) {
 	u, err := url.Parse(redirectURI)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, "server_error", "Invalid redirect URI")
+		respondInternalError(w, err, "Invalid redirect URI")
 		return
 	}
 
@@ -113,26 +151,26 @@ func sendFormPostResponse(w http.ResponseWriter, redirectURI string, params map[
 	w.WriteHeader(http.StatusOK)
 
 	// Generate HTML form
-	html := `<!DOCTYPE html>
+	page := `<!DOCTYPE html>
 <html>
 <head>
     <title>Authorization Response</title>
 </head>
 <body onload="document.forms[0].submit()">
-    <form method="post" action="` + redirectURI + `">
+    <form method="post" action="` + html.EscapeString(redirectURI) + `">
 `
 	for key, value := range params {
-		html += `        <input type="hidden" name="` + key + `" value="` + value + `"/>
+		page += `        <input type="hidden" name="` + html.EscapeString(key) + `" value="` + html.EscapeString(value) + `"/>
 `
 	}
-	html += `        <noscript>
+	page += `        <noscript>
             <button type="submit">Continue</button>
         </noscript>
     </form>
 </body>
 </html>`
 
-	w.Write([]byte(html))
+	w.Write([]byte(page))
 }
 
 // SendErrorResponse sends an error response based on response_mode
@@ -141,6 +179,9 @@ func SendErrorResponse(w http.ResponseWriter, r *http.Request, redirectURI strin
 		"error":             errorCode,
 		"error_description": errorDescription,
 	}
+	if uri := middleware.ErrorURIOf(w, errorCode); uri != "" {
+		params["error_uri"] = uri
+	}
 	if state != "" {
 		params["state"] = state
 	}
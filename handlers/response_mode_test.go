@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRedirectToClient_EncodesParameters(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/oauth/authorize", nil)
+	redirectToClient(w, r, "https://app.example.com/cb?tenant=a%26b", authorizationError(w, "login_required", "User authentication required", "x&code=evil #frag"))
+
+	if w.Code != http.StatusFound {
+		t.Fatalf("Expected 302, got %d", w.Code)
+	}
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("Invalid Location: %v", err)
+	}
+	if location.Fragment != "" {
+		t.Errorf("Expected no fragment, got %q", location.Fragment)
+	}
+
+	q := location.Query()
+	if q.Get("state") != "x&code=evil #frag" || q.Has("code") {
+		t.Errorf("Expected state to arrive intact, got %v", q)
+	}
+	if q.Get("tenant") != "a&b" {
+		t.Errorf("Expected the registered query to be kept, got %v", q)
+	}
+	if q.Get("error") != "login_required" || q.Get("error_description") != "User authentication required" {
+		t.Errorf("Unexpected error parameters %v", q)
+	}
+}
+
+func TestSendFormPostResponse_EscapesValues(t *testing.T) {
+	w := httptest.NewRecorder()
+	sendFormPostResponse(w, "https://app.example.com/cb", map[string]string{"state": `"><script>alert(1)</script>`})
+
+	body := w.Body.String()
+	if strings.Contains(body, "<script>") {
+		t.Errorf("Expected state to be escaped, got %s", body)
+	}
+	if !strings.Contains(body, `value="&#34;&gt;&lt;script&gt;alert(1)&lt;/script&gt;"`) {
+		t.Errorf("Expected escaped state in the form, got %s", body)
+	}
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"oauth2-server/backchannel"
+	"oauth2-server/config"
+	"oauth2-server/models"
+	"oauth2-server/repository"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// errTokenRevoked is returned for a token issued to a user or client that
+// has since been revoked
+var errTokenRevoked = errors.New("token has been revoked")
+
+// Revoker cascades revocation to everything a user or client holds: SSO
+// sessions, unredeemed authorization codes and opaque access tokens are
+// deleted, and refresh tokens and JWT access tokens issued so far stop being
+// honored.
+type Revoker struct {
+	ssoSessionRepo *repository.SSOSessionRepository
+	authCodeRepo   *repository.AuthCodeRepository
+	tokens         *TokenStore
+	logout         *backchannel.Notifier
+	config         *config.Config
+}
+
+// NewRevoker creates a revoker; logout delivers the logout tokens clients
+// are sent when a user revokes them, and may be nil only in tests
+func NewRevoker(
+	ssoSessionRepo *repository.SSOSessionRepository,
+	authCodeRepo *repository.AuthCodeRepository,
+	tokens *TokenStore,
+	logout *backchannel.Notifier,
+	cfg *config.Config,
+) *Revoker {
+	return &Revoker{
+		ssoSessionRepo: ssoSessionRepo,
+		authCodeRepo:   authCodeRepo,
+		tokens:         tokens,
+		logout:         logout,
+		config:         cfg,
+	}
+}
+
+// RevokeUser ends the user's SSO sessions and revokes every token issued to
+// them
+func (v *Revoker) RevokeUser(ctx context.Context, userID string) error {
+	_, err := v.RevokeSessions(ctx, userID, "")
+	return err
+}
+
+// RevokeSessions ends the user's SSO sessions, except keepSessionID when it
+// is set, and revokes every token issued to them, including refresh tokens.
+// It returns how many sessions were ended.
+func (v *Revoker) RevokeSessions(ctx context.Context, userID, keepSessionID string) (int64, error) {
+	ended, err := v.ssoSessionRepo.DeleteByUserIDExcept(ctx, userID, keepSessionID)
+	if err != nil {
+		return 0, err
+	}
+	cleanups := []func(context.Context, string) error{
+		v.authCodeRepo.DeleteByUserID,
+		v.tokens.revokeUserAccessTokens,
+	}
+	for _, cleanup := range cleanups {
+		if err := cleanup(ctx, userID); err != nil {
+			return 0, err
+		}
+	}
+	return ended, v.record(ctx, models.RevocationSubjectUser, userID)
+}
+
+// RevokeClient revokes every token issued to the client, for any user
+func (v *Revoker) RevokeClient(ctx context.Context, clientID string) error {
+	if err := v.authCodeRepo.DeleteByClientID(ctx, clientID); err != nil {
+		return err
+	}
+	if err := v.tokens.revokeClientAccessTokens(ctx, clientID); err != nil {
+		return err
+	}
+	return v.record(ctx, models.RevocationSubjectClient, clientID)
+}
+
+// RevokeGrant revokes everything the user holds for one client: unredeemed
+// authorization codes, access tokens and refresh tokens. A client that
+// registered a back-channel logout URI is then sent a logout token.
+func (v *Revoker) RevokeGrant(ctx context.Context, userID string, client *models.Client) error {
+	if err := v.authCodeRepo.DeleteByUserAndClient(ctx, userID, client.ClientID); err != nil {
+		return err
+	}
+	if err := v.tokens.revokeGrant(ctx, userID, client.ClientID, v.config); err != nil {
+		return err
+	}
+	return notifyBackchannelLogout(v.logout, client, userID, v.config)
+}
+
+func (v *Revoker) record(ctx context.Context, subjectType, subjectID string) error {
+	return v.tokens.recordRevocation(ctx, subjectType, subjectID, v.config)
+}
+
+// revokeGrant revokes every token the user holds for the client, e.g. when
+// the authorization code they were issued for is replayed
+func (s *TokenStore) revokeGrant(ctx context.Context, userID, clientID string, cfg *config.Config) error {
+	if accessTokens := s.opaqueTokens(); accessTokens != nil {
+		if err := accessTokens.DeleteByUserAndClient(ctx, userID, clientID); err != nil {
+			return err
+		}
+	}
+	return s.recordRevocation(ctx, models.RevocationSubjectGrant, models.GrantSubjectID(userID, clientID), cfg)
+}
+
+// recordRevocation stores the revocation for as long as a token issued
+// before it can still be valid
+func (s *TokenStore) recordRevocation(ctx context.Context, subjectType, subjectID string, cfg *config.Config) error {
+	if s == nil || s.revocations == nil {
+		return nil
+	}
+	ttl := max(cfg.RefreshTokenExpiry, cfg.AccessTokenExpiry)
+	return s.revocations.Revoke(ctx, subjectType, subjectID, time.Duration(ttl)*time.Second)
+}
+
+// checkRevocation returns errTokenRevoked when the user or client the token
+// was issued to, or the user's grant to the client, has been revoked since
+// issuedAt. Token times only have
+// second precision, so a token issued in the same second as the revocation
+// is treated as revoked.
+func (s *TokenStore) checkRevocation(ctx context.Context, userID, clientID string, issuedAt time.Time) error {
+	if s == nil || s.revocations == nil {
+		return nil
+	}
+	revokedAt, err := s.revocations.RevokedAt(ctx, userID, clientID)
+	if err != nil {
+		return err
+	}
+	if revokedAt.IsZero() || issuedAt.Truncate(time.Second).After(revokedAt.Truncate(time.Second)) {
+		return nil
+	}
+	return errTokenRevoked
+}
+
+// claimIssuedAt converts an iat claim to a time; a missing claim is the zero time
+func claimIssuedAt(iat *jwt.NumericDate) time.Time {
+	if iat == nil {
+		return time.Time{}
+	}
+	return iat.Time
+}
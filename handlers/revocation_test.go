@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"oauth2-server/config"
+	"oauth2-server/models"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestCheckRevocation_WithoutStore(t *testing.T) {
+	if err := NewTokenStore(nil, nil).checkRevocation(context.Background(), "user-1", "client-1", time.Time{}); err != nil {
+		t.Errorf("Expected tokens to stay valid without a revocation store, got %v", err)
+	}
+}
+
+func TestClaimIssuedAt(t *testing.T) {
+	if got := claimIssuedAt(nil); !got.IsZero() {
+		t.Errorf("Expected the zero time for a missing iat, got %v", got)
+	}
+
+	iat := time.Unix(1700000000, 0)
+	if got := claimIssuedAt(jwt.NewNumericDate(iat)); !got.Equal(iat) {
+		t.Errorf("Expected %v, got %v", iat, got)
+	}
+}
+
+func TestRejectReplayedCode(t *testing.T) {
+	handler := NewOAuthHandler(nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, &config.Config{})
+	authCode := &models.AuthorizationCode{
+		Code:       "code",
+		ClientID:   "client-1",
+		UserID:     "user-1",
+		RedeemedAt: time.Now().Add(-time.Minute),
+	}
+
+	w := httptest.NewRecorder()
+	handler.rejectReplayedCode(w, httptest.NewRequest("POST", "/oauth/token", nil), authCode)
+
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "invalid_grant") {
+		t.Errorf("Expected invalid_grant, got %d: %s", w.Code, w.Body.String())
+	}
+}
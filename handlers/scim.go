@@ -0,0 +1,417 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"oauth2-server/models"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// SCIM 2.0 (RFC 7643, RFC 7644) schema URNs
+const (
+	SCIMUserSchema                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SCIMGroupSchema                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SCIMServiceProviderConfigSchema = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	SCIMListResponseSchema          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SCIMPatchOpSchema               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SCIMErrorSchema                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// SCIMContentType is the media type of SCIM responses
+const SCIMContentType = "application/scim+json"
+
+// SCIM error types (RFC 7644 section 3.12)
+const (
+	scimInvalidFilter = "invalidFilter"
+	scimInvalidValue  = "invalidValue"
+	scimInvalidPath   = "invalidPath"
+	scimInvalidSyntax = "invalidSyntax"
+	scimUniqueness    = "uniqueness"
+)
+
+type SCIMMeta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location"`
+}
+
+type SCIMName struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+// SCIMMultiValue is an entry of a multi-valued attribute such as emails,
+// groups or members
+type SCIMMultiValue struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+// SCIMUser is the core User resource. userName is the user's email address
+// and displayName their name; password is write-only.
+type SCIMUser struct {
+	Schemas     []string         `json:"schemas"`
+	ID          string           `json:"id,omitempty"`
+	ExternalID  string           `json:"externalId,omitempty"`
+	UserName    string           `json:"userName"`
+	Name        *SCIMName        `json:"name,omitempty"`
+	DisplayName string           `json:"displayName,omitempty"`
+	Emails      []SCIMMultiValue `json:"emails,omitempty"`
+	Active      *bool            `json:"active,omitempty"`
+	Password    string           `json:"password,omitempty"`
+	Groups      []SCIMMultiValue `json:"groups,omitempty"`
+	Meta        *SCIMMeta        `json:"meta,omitempty"`
+}
+
+// SCIMGroup is the core Group resource
+type SCIMGroup struct {
+	Schemas     []string         `json:"schemas"`
+	ID          string           `json:"id,omitempty"`
+	ExternalID  string           `json:"externalId,omitempty"`
+	DisplayName string           `json:"displayName"`
+	Members     []SCIMMultiValue `json:"members"`
+	Meta        *SCIMMeta        `json:"meta,omitempty"`
+}
+
+type SCIMListResponse struct {
+	Schemas      []string    `json:"schemas"`
+	TotalResults int64       `json:"totalResults"`
+	StartIndex   int64       `json:"startIndex"`
+	ItemsPerPage int         `json:"itemsPerPage"`
+	Resources    interface{} `json:"Resources"`
+}
+
+type SCIMPatchRequest struct {
+	Schemas    []string             `json:"schemas"`
+	Operations []SCIMPatchOperation `json:"Operations"`
+}
+
+type SCIMPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+type SCIMError struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	SCIMType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail"`
+}
+
+func respondSCIM(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", SCIMContentType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
+
+func respondSCIMError(w http.ResponseWriter, status int, scimType, detail string) {
+	respondSCIM(w, status, SCIMError{
+		Schemas:  []string{SCIMErrorSchema},
+		Status:   fmt.Sprint(status),
+		SCIMType: scimType,
+		Detail:   detail,
+	})
+}
+
+// scimUser converts a user and the groups it belongs to into a SCIM resource
+func scimUser(user *models.User, groups []*models.Group, baseURL string) SCIMUser {
+	active := !user.Disabled
+	resource := SCIMUser{
+		Schemas:     []string{SCIMUserSchema},
+		ID:          user.ID,
+		ExternalID:  user.ExternalID,
+		UserName:    user.Email,
+		Name:        &SCIMName{Formatted: user.Name},
+		DisplayName: user.Name,
+		Emails:      []SCIMMultiValue{{Value: user.Email, Type: "work", Primary: true}},
+		Active:      &active,
+		Meta: &SCIMMeta{
+			ResourceType: "User",
+			Created:      user.CreatedAt,
+			LastModified: user.CreatedAt,
+			Location:     baseURL + "/Users/" + user.ID,
+		},
+	}
+	for _, group := range groups {
+		resource.Groups = append(resource.Groups, SCIMMultiValue{
+			Value:   group.ID,
+			Display: group.DisplayName,
+			Ref:     baseURL + "/Groups/" + group.ID,
+		})
+	}
+	return resource
+}
+
+func scimGroup(group *models.Group, baseURL string) SCIMGroup {
+	resource := SCIMGroup{
+		Schemas:     []string{SCIMGroupSchema},
+		ID:          group.ID,
+		ExternalID:  group.ExternalID,
+		DisplayName: group.DisplayName,
+		Members:     make([]SCIMMultiValue, 0, len(group.Members)),
+		Meta: &SCIMMeta{
+			ResourceType: "Group",
+			Created:      group.CreatedAt,
+			LastModified: group.UpdatedAt,
+			Location:     baseURL + "/Groups/" + group.ID,
+		},
+	}
+	for _, member := range group.Members {
+		resource.Members = append(resource.Members, SCIMMultiValue{Value: member, Ref: baseURL + "/Users/" + member})
+	}
+	return resource
+}
+
+// scimDisplayName picks the name to store for a SCIM user
+func scimDisplayName(user *SCIMUser) string {
+	if user.DisplayName != "" {
+		return user.DisplayName
+	}
+	if user.Name != nil {
+		if user.Name.Formatted != "" {
+			return user.Name.Formatted
+		}
+		if full := strings.TrimSpace(user.Name.GivenName + " " + user.Name.FamilyName); full != "" {
+			return full
+		}
+	}
+	return user.UserName
+}
+
+// scimFilter is a filter of the form `attribute eq "value"`, the only form
+// identity providers use to look up a resource before provisioning it.
+// Attribute is lower-cased since SCIM attribute names are case-insensitive.
+type scimFilter struct {
+	Attribute string
+	Value     string
+}
+
+var scimFilterPattern = regexp.MustCompile(`^\s*([A-Za-z][\w.:]*)\s+(?i:eq)\s+"((?:[^"\\]|\\.)*)"\s*$`)
+
+func parseSCIMFilter(filter string) (*scimFilter, error) {
+	match := scimFilterPattern.FindStringSubmatch(filter)
+	if match == nil {
+		return nil, errors.New(`only filters of the form attribute eq "value" are supported`)
+	}
+	var value string
+	if err := json.Unmarshal([]byte(`"`+match[2]+`"`), &value); err != nil {
+		return nil, errors.New("invalid filter value")
+	}
+	return &scimFilter{Attribute: strings.ToLower(match[1]), Value: value}, nil
+}
+
+// scimPatchValues returns the attributes an operation sets, keyed by
+// lower-cased path. An operation without a path carries them in an object
+// value; a nested name object is flattened to name.* paths.
+func scimPatchValues(op SCIMPatchOperation) (map[string]json.RawMessage, error) {
+	if op.Path != "" {
+		return map[string]json.RawMessage{strings.ToLower(op.Path): op.Value}, nil
+	}
+
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(op.Value, &object); err != nil {
+		return nil, errors.New("an operation without a path needs an object value")
+	}
+	values := map[string]json.RawMessage{}
+	for key, value := range object {
+		key = strings.ToLower(key)
+		var nested map[string]json.RawMessage
+		if key == "name" && json.Unmarshal(value, &nested) == nil {
+			for nestedKey, nestedValue := range nested {
+				values["name."+strings.ToLower(nestedKey)] = nestedValue
+			}
+			continue
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+// scimString reads a string value; null reads as empty
+func scimString(value json.RawMessage) (string, error) {
+	var s *string
+	if err := json.Unmarshal(value, &s); err != nil {
+		return "", errors.New("expected a string value")
+	}
+	if s == nil {
+		return "", nil
+	}
+	return *s, nil
+}
+
+// scimBool reads a boolean value. Some identity providers send booleans as
+// the strings "True" and "False".
+func scimBool(value json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(value, &b); err == nil {
+		return b, nil
+	}
+	var s string
+	if err := json.Unmarshal(value, &s); err == nil {
+		switch strings.ToLower(s) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+	}
+	return false, errors.New("expected a boolean value")
+}
+
+// applySCIMUserPatch applies patch operations to a user and returns the
+// active state they set, if any. Attributes the server does not store, such
+// as phone numbers or addresses, are ignored.
+func applySCIMUserPatch(user *models.User, ops []SCIMPatchOperation) (*bool, error) {
+	var active *bool
+	for _, op := range ops {
+		operation := strings.ToLower(op.Op)
+		if operation != "add" && operation != "replace" && operation != "remove" {
+			return nil, fmt.Errorf("unsupported operation %q", op.Op)
+		}
+		if operation == "remove" {
+			if strings.EqualFold(op.Path, "externalId") {
+				user.ExternalID = ""
+			}
+			continue
+		}
+
+		values, err := scimPatchValues(op)
+		if err != nil {
+			return nil, err
+		}
+		for path, value := range values {
+			switch path {
+			case "active":
+				b, err := scimBool(value)
+				if err != nil {
+					return nil, fmt.Errorf("active: %w", err)
+				}
+				active = &b
+			case "username", "displayname", "name.formatted", "externalid":
+				s, err := scimString(value)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", path, err)
+				}
+				switch path {
+				case "username":
+					if s == "" {
+						return nil, errors.New("userName cannot be empty")
+					}
+					user.Email = s
+				case "externalid":
+					user.ExternalID = s
+				default:
+					if s != "" {
+						user.Name = s
+					}
+				}
+			}
+		}
+	}
+	return active, nil
+}
+
+var scimMemberFilterPath = regexp.MustCompile(`^(?i:members)\[(.*)\]$`)
+
+// applySCIMGroupPatch applies patch operations to a group's name, external
+// ID and members
+func applySCIMGroupPatch(group *models.Group, ops []SCIMPatchOperation) error {
+	for _, op := range ops {
+		operation := strings.ToLower(op.Op)
+		if operation != "add" && operation != "replace" && operation != "remove" {
+			return fmt.Errorf("unsupported operation %q", op.Op)
+		}
+
+		// remove members[value eq "id"]
+		if match := scimMemberFilterPath.FindStringSubmatch(op.Path); match != nil {
+			filter, err := parseSCIMFilter(match[1])
+			if err != nil || filter.Attribute != "value" || operation != "remove" {
+				return fmt.Errorf("unsupported path %q", op.Path)
+			}
+			group.Members = slices.DeleteFunc(group.Members, func(member string) bool { return member == filter.Value })
+			continue
+		}
+
+		if operation == "remove" {
+			switch strings.ToLower(op.Path) {
+			case "members":
+				if len(op.Value) == 0 {
+					group.Members = []string{}
+					continue
+				}
+				members, err := scimMembers(op.Value)
+				if err != nil {
+					return err
+				}
+				group.Members = slices.DeleteFunc(group.Members, func(member string) bool { return slices.Contains(members, member) })
+			case "externalid":
+				group.ExternalID = ""
+			default:
+				return fmt.Errorf("unsupported path %q", op.Path)
+			}
+			continue
+		}
+
+		values, err := scimPatchValues(op)
+		if err != nil {
+			return err
+		}
+		for path, value := range values {
+			switch path {
+			case "displayname":
+				s, err := scimString(value)
+				if err != nil || s == "" {
+					return errors.New("displayName must be a non-empty string")
+				}
+				group.DisplayName = s
+			case "externalid":
+				s, err := scimString(value)
+				if err != nil {
+					return fmt.Errorf("externalId: %w", err)
+				}
+				group.ExternalID = s
+			case "members":
+				members, err := scimMembers(value)
+				if err != nil {
+					return err
+				}
+				if operation == "replace" {
+					group.Members = []string{}
+				}
+				for _, member := range members {
+					if !slices.Contains(group.Members, member) {
+						group.Members = append(group.Members, member)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// scimMembers reads the user IDs of a members value
+func scimMembers(value json.RawMessage) ([]string, error) {
+	var members []SCIMMultiValue
+	if err := json.Unmarshal(value, &members); err != nil {
+		return nil, errors.New("members must be a list of {\"value\": id}")
+	}
+	ids := make([]string, 0, len(members))
+	for _, member := range members {
+		if member.Value == "" {
+			return nil, errors.New("member value is required")
+		}
+		ids = append(ids, member.Value)
+	}
+	return ids, nil
+}
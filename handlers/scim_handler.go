@@ -0,0 +1,519 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"oauth2-server/config"
+	"oauth2-server/models"
+	"oauth2-server/repository"
+	"oauth2-server/utils"
+	"slices"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// SCIM list paging
+const (
+	scimDefaultCount = 100
+	scimMaxCount     = 500
+)
+
+// SCIMHandler serves the SCIM 2.0 provisioning API so a corporate identity
+// provider can create, update and remove users and groups. Requests carry
+// one of the static SCIM_TOKENS as a bearer token.
+type SCIMHandler struct {
+	userRepo  *repository.UserRepository
+	groupRepo *repository.GroupRepository
+	deleter   *UserDeleter
+	revoker   *Revoker
+	audit     *Auditor
+	config    *config.Config
+}
+
+func NewSCIMHandler(
+	userRepo *repository.UserRepository,
+	groupRepo *repository.GroupRepository,
+	deleter *UserDeleter,
+	revoker *Revoker,
+	audit *Auditor,
+	cfg *config.Config,
+) *SCIMHandler {
+	return &SCIMHandler{
+		userRepo:  userRepo,
+		groupRepo: groupRepo,
+		deleter:   deleter,
+		revoker:   revoker,
+		audit:     audit,
+		config:    cfg,
+	}
+}
+
+// RequireToken rejects requests without a configured SCIM bearer token
+func (h *SCIMHandler) RequireToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if found && token != "" {
+			for _, allowed := range h.config.SCIMTokens {
+				if subtle.ConstantTimeCompare([]byte(token), []byte(allowed)) == 1 {
+					next(w, r)
+					return
+				}
+			}
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="scim"`)
+		respondSCIMError(w, http.StatusUnauthorized, "", "A valid SCIM bearer token is required")
+	}
+}
+
+// ServiceProviderConfig describes the supported SCIM features
+// GET /scim/v2/ServiceProviderConfig
+func (h *SCIMHandler) ServiceProviderConfig(w http.ResponseWriter, r *http.Request) {
+	supported := func(b bool) map[string]bool { return map[string]bool{"supported": b} }
+	respondSCIM(w, http.StatusOK, map[string]interface{}{
+		"schemas":        []string{SCIMServiceProviderConfigSchema},
+		"patch":          supported(true),
+		"bulk":           map[string]interface{}{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]interface{}{"supported": true, "maxResults": scimMaxCount},
+		"changePassword": supported(false),
+		"sort":           supported(false),
+		"etag":           supported(false),
+		"authenticationSchemes": []map[string]string{{
+			"type":        "oauthbearertoken",
+			"name":        "Bearer Token",
+			"description": "A token from SCIM_TOKENS in the Authorization header",
+		}},
+	})
+}
+
+// ListUsers returns a page of users or the user matching filter
+// GET /scim/v2/Users?filter=userName eq "..."&startIndex=1&count=100
+func (h *SCIMHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	startIndex, count := scimPaging(r)
+
+	var users []*models.User
+	var total int64
+	if filter := r.URL.Query().Get("filter"); filter != "" {
+		f, err := parseSCIMFilter(filter)
+		if err != nil {
+			respondSCIMError(w, http.StatusBadRequest, scimInvalidFilter, err.Error())
+			return
+		}
+		var user *models.User
+		switch f.Attribute {
+		case "username", "emails.value", "emails":
+			user, err = h.userRepo.FindByEmail(ctx, f.Value)
+		case "externalid":
+			user, err = h.userRepo.FindByExternalID(ctx, f.Value)
+		case "id":
+			user, err = h.userRepo.FindByID(ctx, f.Value)
+		default:
+			respondSCIMError(w, http.StatusBadRequest, scimInvalidFilter, "Users can be filtered by userName, emails.value, externalId or id")
+			return
+		}
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			respondSCIMError(w, http.StatusInternalServerError, "", "Failed to look up users")
+			return
+		}
+		if user != nil {
+			users, total = []*models.User{user}, 1
+		}
+	} else {
+		var err error
+		users, total, err = h.userRepo.ListFrom(ctx, startIndex-1, count)
+		if err != nil {
+			respondSCIMError(w, http.StatusInternalServerError, "", "Failed to list users")
+			return
+		}
+	}
+
+	resources := make([]SCIMUser, 0, len(users))
+	for _, user := range users {
+		resource, err := h.userResource(ctx, user)
+		if err != nil {
+			respondSCIMError(w, http.StatusInternalServerError, "", "Failed to look up groups")
+			return
+		}
+		resources = append(resources, resource)
+	}
+	respondSCIM(w, http.StatusOK, scimList(total, startIndex, resources, len(resources)))
+}
+
+// CreateUser provisions a user. Users provisioned by the identity provider
+// count as having a verified email address.
+// POST /scim/v2/Users
+func (h *SCIMHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
+	var req SCIMUser
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondSCIMError(w, http.StatusBadRequest, scimInvalidSyntax, "Invalid request body")
+		return
+	}
+	if req.UserName == "" {
+		respondSCIMError(w, http.StatusBadRequest, scimInvalidValue, "userName is required")
+		return
+	}
+
+	user := &models.User{
+		Email:         req.UserName,
+		Name:          scimDisplayName(&req),
+		ExternalID:    req.ExternalID,
+		EmailVerified: true,
+		Disabled:      req.Active != nil && !*req.Active,
+	}
+	if req.Password != "" {
+		hashedPassword, err := utils.HashPassword(req.Password)
+		if err != nil {
+			respondSCIMError(w, http.StatusInternalServerError, "", "Failed to hash password")
+			return
+		}
+		user.Password = hashedPassword
+	}
+
+	ctx := r.Context()
+	if err := h.userRepo.Create(ctx, user); err != nil {
+		if errors.Is(err, repository.ErrDuplicate) {
+			respondSCIMError(w, http.StatusConflict, scimUniqueness, "A user with this userName already exists")
+			return
+		}
+		respondSCIMError(w, http.StatusInternalServerError, "", "Failed to create user")
+		return
+	}
+	h.audit.record(r, models.AuditUserCreated, user.ID, "", map[string]string{"flow": "scim"})
+
+	resource := scimUser(user, nil, h.baseURL())
+	w.Header().Set("Location", resource.Meta.Location)
+	respondSCIM(w, http.StatusCreated, resource)
+}
+
+// GetUser returns a user
+// GET /scim/v2/Users/{id}
+func (h *SCIMHandler) GetUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.findUser(w, r)
+	if !ok {
+		return
+	}
+	resource, err := h.userResource(r.Context(), user)
+	if err != nil {
+		respondSCIMError(w, http.StatusInternalServerError, "", "Failed to look up groups")
+		return
+	}
+	respondSCIM(w, http.StatusOK, resource)
+}
+
+// PatchUser updates a user. Setting active to false disables the account
+// and revokes its tokens and SSO sessions.
+// PATCH /scim/v2/Users/{id}
+func (h *SCIMHandler) PatchUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.findUser(w, r)
+	if !ok {
+		return
+	}
+	ops, ok := decodeSCIMPatch(w, r)
+	if !ok {
+		return
+	}
+
+	before := *user
+	active, err := applySCIMUserPatch(user, ops)
+	if err != nil {
+		respondSCIMError(w, http.StatusBadRequest, scimInvalidValue, err.Error())
+		return
+	}
+
+	ctx := r.Context()
+	if user.Email != before.Email || user.Name != before.Name || user.ExternalID != before.ExternalID {
+		if err := h.userRepo.UpdateProfile(ctx, user.ID, user.Email, user.Name, user.ExternalID); err != nil {
+			if errors.Is(err, repository.ErrDuplicate) {
+				respondSCIMError(w, http.StatusConflict, scimUniqueness, "A user with this userName already exists")
+				return
+			}
+			respondSCIMError(w, http.StatusInternalServerError, "", "Failed to update user")
+			return
+		}
+	}
+
+	if active != nil && *active == user.Disabled {
+		if err := h.userRepo.SetDisabled(ctx, user.ID, !*active); err != nil {
+			respondSCIMError(w, http.StatusInternalServerError, "", "Failed to update user")
+			return
+		}
+		user.Disabled = !*active
+		if user.Disabled {
+			if err := h.revoker.RevokeUser(ctx, user.ID); err != nil {
+				respondSCIMError(w, http.StatusInternalServerError, "", "Failed to revoke tokens")
+				return
+			}
+			h.audit.record(r, models.AuditUserDisabled, user.ID, "", map[string]string{"flow": "scim"})
+		} else {
+			h.audit.record(r, models.AuditUserEnabled, user.ID, "", map[string]string{"flow": "scim"})
+		}
+	}
+
+	resource, err := h.userResource(ctx, user)
+	if err != nil {
+		respondSCIMError(w, http.StatusInternalServerError, "", "Failed to look up groups")
+		return
+	}
+	respondSCIM(w, http.StatusOK, resource)
+}
+
+// DeleteUser deprovisions a user, revoking its tokens and removing the data
+// held about it
+// DELETE /scim/v2/Users/{id}
+func (h *SCIMHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.findUser(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.deleter.Delete(r.Context(), user.ID); err != nil {
+		respondSCIMError(w, http.StatusInternalServerError, "", "Failed to delete user")
+		return
+	}
+	h.audit.record(r, models.AuditUserDeleted, user.ID, "", map[string]string{"flow": "scim"})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListGroups returns a page of groups or the group matching filter
+// GET /scim/v2/Groups?filter=displayName eq "..."
+func (h *SCIMHandler) ListGroups(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	startIndex, count := scimPaging(r)
+
+	var groups []*models.Group
+	var total int64
+	if filter := r.URL.Query().Get("filter"); filter != "" {
+		f, err := parseSCIMFilter(filter)
+		if err != nil {
+			respondSCIMError(w, http.StatusBadRequest, scimInvalidFilter, err.Error())
+			return
+		}
+		var group *models.Group
+		switch f.Attribute {
+		case "displayname":
+			group, err = h.groupRepo.FindByDisplayName(ctx, f.Value)
+		case "externalid":
+			group, err = h.groupRepo.FindByExternalID(ctx, f.Value)
+		case "id":
+			group, err = h.groupRepo.FindByID(ctx, f.Value)
+		default:
+			respondSCIMError(w, http.StatusBadRequest, scimInvalidFilter, "Groups can be filtered by displayName, externalId or id")
+			return
+		}
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			respondSCIMError(w, http.StatusInternalServerError, "", "Failed to look up groups")
+			return
+		}
+		if group != nil {
+			groups, total = []*models.Group{group}, 1
+		}
+	} else {
+		var err error
+		groups, total, err = h.groupRepo.ListFrom(ctx, startIndex-1, count)
+		if err != nil {
+			respondSCIMError(w, http.StatusInternalServerError, "", "Failed to list groups")
+			return
+		}
+	}
+
+	resources := make([]SCIMGroup, 0, len(groups))
+	for _, group := range groups {
+		resources = append(resources, scimGroup(group, h.baseURL()))
+	}
+	respondSCIM(w, http.StatusOK, scimList(total, startIndex, resources, len(resources)))
+}
+
+// CreateGroup creates a group
+// POST /scim/v2/Groups
+func (h *SCIMHandler) CreateGroup(w http.ResponseWriter, r *http.Request) {
+	var req SCIMGroup
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondSCIMError(w, http.StatusBadRequest, scimInvalidSyntax, "Invalid request body")
+		return
+	}
+	if req.DisplayName == "" {
+		respondSCIMError(w, http.StatusBadRequest, scimInvalidValue, "displayName is required")
+		return
+	}
+
+	id, err := utils.GenerateRandomString(16)
+	if err != nil {
+		respondSCIMError(w, http.StatusInternalServerError, "", "Failed to generate group ID")
+		return
+	}
+	group := &models.Group{
+		ID:          id,
+		DisplayName: req.DisplayName,
+		ExternalID:  req.ExternalID,
+		Members:     []string{},
+	}
+	for _, member := range req.Members {
+		if !slices.Contains(group.Members, member.Value) {
+			group.Members = append(group.Members, member.Value)
+		}
+	}
+
+	ctx := r.Context()
+	if !h.checkMembers(w, r, group.Members) {
+		return
+	}
+	if err := h.groupRepo.Create(ctx, group); err != nil {
+		if errors.Is(err, repository.ErrDuplicate) {
+			respondSCIMError(w, http.StatusConflict, scimUniqueness, "A group with this displayName already exists")
+			return
+		}
+		respondSCIMError(w, http.StatusInternalServerError, "", "Failed to create group")
+		return
+	}
+
+	resource := scimGroup(group, h.baseURL())
+	w.Header().Set("Location", resource.Meta.Location)
+	respondSCIM(w, http.StatusCreated, resource)
+}
+
+// GetGroup returns a group
+// GET /scim/v2/Groups/{id}
+func (h *SCIMHandler) GetGroup(w http.ResponseWriter, r *http.Request) {
+	group, ok := h.findGroup(w, r)
+	if !ok {
+		return
+	}
+	respondSCIM(w, http.StatusOK, scimGroup(group, h.baseURL()))
+}
+
+// PatchGroup renames a group or changes its members
+// PATCH /scim/v2/Groups/{id}
+func (h *SCIMHandler) PatchGroup(w http.ResponseWriter, r *http.Request) {
+	group, ok := h.findGroup(w, r)
+	if !ok {
+		return
+	}
+	ops, ok := decodeSCIMPatch(w, r)
+	if !ok {
+		return
+	}
+
+	before := slices.Clone(group.Members)
+	if err := applySCIMGroupPatch(group, ops); err != nil {
+		respondSCIMError(w, http.StatusBadRequest, scimInvalidPath, err.Error())
+		return
+	}
+	added := slices.DeleteFunc(slices.Clone(group.Members), func(member string) bool { return slices.Contains(before, member) })
+	if !h.checkMembers(w, r, added) {
+		return
+	}
+
+	if err := h.groupRepo.Update(r.Context(), group); err != nil {
+		if errors.Is(err, repository.ErrDuplicate) {
+			respondSCIMError(w, http.StatusConflict, scimUniqueness, "A group with this displayName already exists")
+			return
+		}
+		respondSCIMError(w, http.StatusInternalServerError, "", "Failed to update group")
+		return
+	}
+	respondSCIM(w, http.StatusOK, scimGroup(group, h.baseURL()))
+}
+
+// DeleteGroup removes a group; its members are not affected
+// DELETE /scim/v2/Groups/{id}
+func (h *SCIMHandler) DeleteGroup(w http.ResponseWriter, r *http.Request) {
+	err := h.groupRepo.Delete(r.Context(), mux.Vars(r)["id"])
+	if errors.Is(err, repository.ErrNotFound) {
+		respondSCIMError(w, http.StatusNotFound, "", "Group not found")
+		return
+	}
+	if err != nil {
+		respondSCIMError(w, http.StatusInternalServerError, "", "Failed to delete group")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *SCIMHandler) findUser(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	user, err := h.userRepo.FindByID(r.Context(), mux.Vars(r)["id"])
+	if errors.Is(err, repository.ErrNotFound) {
+		respondSCIMError(w, http.StatusNotFound, "", "User not found")
+		return nil, false
+	}
+	if err != nil {
+		respondSCIMError(w, http.StatusInternalServerError, "", "Failed to look up user")
+		return nil, false
+	}
+	return user, true
+}
+
+func (h *SCIMHandler) findGroup(w http.ResponseWriter, r *http.Request) (*models.Group, bool) {
+	group, err := h.groupRepo.FindByID(r.Context(), mux.Vars(r)["id"])
+	if errors.Is(err, repository.ErrNotFound) {
+		respondSCIMError(w, http.StatusNotFound, "", "Group not found")
+		return nil, false
+	}
+	if err != nil {
+		respondSCIMError(w, http.StatusInternalServerError, "", "Failed to look up group")
+		return nil, false
+	}
+	return group, true
+}
+
+// checkMembers rejects user IDs that name no user
+func (h *SCIMHandler) checkMembers(w http.ResponseWriter, r *http.Request, members []string) bool {
+	for _, member := range members {
+		if _, err := h.userRepo.FindByID(r.Context(), member); err != nil {
+			if errors.Is(err, repository.ErrNotFound) {
+				respondSCIMError(w, http.StatusBadRequest, scimInvalidValue, "Unknown member "+member)
+				return false
+			}
+			respondSCIMError(w, http.StatusInternalServerError, "", "Failed to look up user")
+			return false
+		}
+	}
+	return true
+}
+
+func (h *SCIMHandler) userResource(ctx context.Context, user *models.User) (SCIMUser, error) {
+	groups, err := h.groupRepo.FindByMember(ctx, user.ID)
+	if err != nil {
+		return SCIMUser{}, err
+	}
+	return scimUser(user, groups, h.baseURL()), nil
+}
+
+func (h *SCIMHandler) baseURL() string {
+	return h.config.PublicURL + "/scim/v2"
+}
+
+func decodeSCIMPatch(w http.ResponseWriter, r *http.Request) ([]SCIMPatchOperation, bool) {
+	var req SCIMPatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondSCIMError(w, http.StatusBadRequest, scimInvalidSyntax, "Invalid request body")
+		return nil, false
+	}
+	if !slices.Contains(req.Schemas, SCIMPatchOpSchema) || len(req.Operations) == 0 {
+		respondSCIMError(w, http.StatusBadRequest, scimInvalidSyntax, "Expected a PatchOp with at least one operation")
+		return nil, false
+	}
+	return req.Operations, true
+}
+
+// scimPaging reads the 1-based startIndex and the page size
+func scimPaging(r *http.Request) (startIndex, count int64) {
+	query := r.URL.Query()
+	startIndex = parsePositiveInt(query.Get("startIndex"), 1)
+	count = min(parsePositiveInt(query.Get("count"), scimDefaultCount), scimMaxCount)
+	return startIndex, count
+}
+
+func scimList(total, startIndex int64, resources interface{}, n int) SCIMListResponse {
+	return SCIMListResponse{
+		Schemas:      []string{SCIMListResponseSchema},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: n,
+		Resources:    resources,
+	}
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"oauth2-server/config"
+	"strings"
+	"testing"
+)
+
+func TestSCIMRequireToken(t *testing.T) {
+	h := NewSCIMHandler(nil, nil, nil, nil, nil, &config.Config{SCIMTokens: []string{"scim-secret"}})
+	handler := h.RequireToken(h.ServiceProviderConfig)
+
+	tests := []struct {
+		name          string
+		authorization string
+		expected      int
+	}{
+		{"Configured token", "Bearer scim-secret", http.StatusOK},
+		{"Wrong token", "Bearer other", http.StatusUnauthorized},
+		{"No token", "", http.StatusUnauthorized},
+		{"Basic auth", "Basic c2NpbS1zZWNyZXQ=", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/scim/v2/ServiceProviderConfig", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			handler(w, req)
+
+			if w.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, w.Code)
+			}
+			if w.Header().Get("Content-Type") != SCIMContentType {
+				t.Errorf("Expected a SCIM response, got %s", w.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestSCIMHandler_RejectsBadRequests(t *testing.T) {
+	h := NewSCIMHandler(nil, nil, nil, nil, nil, &config.Config{})
+
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		method   string
+		target   string
+		body     string
+		scimType string
+	}{
+		{"Unsupported user filter", h.ListUsers, "GET", `/scim/v2/Users?filter=name.familyName+eq+"Doe"`, "", scimInvalidFilter},
+		{"Malformed group filter", h.ListGroups, "GET", `/scim/v2/Groups?filter=displayName+sw+"S"`, "", scimInvalidFilter},
+		{"User without userName", h.CreateUser, "POST", "/scim/v2/Users", `{"schemas": ["` + SCIMUserSchema + `"]}`, scimInvalidValue},
+		{"Group without displayName", h.CreateGroup, "POST", "/scim/v2/Groups", `{"schemas": ["` + SCIMGroupSchema + `"]}`, scimInvalidValue},
+		{"Invalid body", h.CreateUser, "POST", "/scim/v2/Users", `{`, scimInvalidSyntax},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.handler(w, httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body)))
+
+			var scimErr SCIMError
+			if err := json.NewDecoder(w.Body).Decode(&scimErr); err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != http.StatusBadRequest || scimErr.Status != "400" || scimErr.SCIMType != tt.scimType {
+				t.Errorf("Expected a 400 %s error, got %d %+v", tt.scimType, w.Code, scimErr)
+			}
+			if len(scimErr.Schemas) != 1 || scimErr.Schemas[0] != SCIMErrorSchema {
+				t.Errorf("Expected the SCIM error schema, got %v", scimErr.Schemas)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"encoding/json"
+	"oauth2-server/models"
+	"slices"
+	"testing"
+)
+
+func TestParseSCIMFilter(t *testing.T) {
+	tests := []struct {
+		filter    string
+		attribute string
+		value     string
+		wantErr   bool
+	}{
+		{filter: `userName eq "jane@example.com"`, attribute: "username", value: "jane@example.com"},
+		{filter: `externalId EQ "00u1"`, attribute: "externalid", value: "00u1"},
+		{filter: `displayName eq "Sales \"EMEA\""`, attribute: "displayname", value: `Sales "EMEA"`},
+		{filter: `userName co "jane"`, wantErr: true},
+		{filter: `userName eq "a" and active eq true`, wantErr: true},
+		{filter: `userName eq jane`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		f, err := parseSCIMFilter(tt.filter)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseSCIMFilter(%q) expected an error, got %+v", tt.filter, f)
+			}
+			continue
+		}
+		if err != nil || f.Attribute != tt.attribute || f.Value != tt.value {
+			t.Errorf("parseSCIMFilter(%q) = %+v, %v", tt.filter, f, err)
+		}
+	}
+}
+
+func patchOps(t *testing.T, body string) []SCIMPatchOperation {
+	var req SCIMPatchRequest
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatal(err)
+	}
+	return req.Operations
+}
+
+func TestApplySCIMUserPatch(t *testing.T) {
+	user := &models.User{Email: "jane@example.com", Name: "Jane", ExternalID: "00u1"}
+
+	// Azure AD style: no path, capitalised op and a string boolean
+	active, err := applySCIMUserPatch(user, patchOps(t, `{"Operations": [
+		{"op": "Replace", "value": {"active": "False", "name": {"formatted": "Jane Doe"}, "title": "Engineer"}},
+		{"op": "replace", "path": "userName", "value": "jane.doe@example.com"},
+		{"op": "remove", "path": "externalId"}
+	]}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if active == nil || *active {
+		t.Errorf("Expected active to be set to false, got %v", active)
+	}
+	if user.Email != "jane.doe@example.com" || user.Name != "Jane Doe" || user.ExternalID != "" {
+		t.Errorf("Unexpected user %+v", user)
+	}
+
+	if _, err := applySCIMUserPatch(user, patchOps(t, `{"Operations": [{"op": "move", "path": "active", "value": true}]}`)); err == nil {
+		t.Error("Expected an unsupported operation to fail")
+	}
+	if _, err := applySCIMUserPatch(user, patchOps(t, `{"Operations": [{"op": "replace", "path": "active", "value": "maybe"}]}`)); err == nil {
+		t.Error("Expected an invalid boolean to fail")
+	}
+}
+
+func TestApplySCIMGroupPatch(t *testing.T) {
+	group := &models.Group{DisplayName: "Sales", Members: []string{"u1", "u2"}}
+
+	err := applySCIMGroupPatch(group, patchOps(t, `{"Operations": [
+		{"op": "add", "path": "members", "value": [{"value": "u3"}, {"value": "u1"}]},
+		{"op": "remove", "path": "members[value eq \"u2\"]"},
+		{"op": "replace", "value": {"displayName": "Sales EMEA"}}
+	]}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if group.DisplayName != "Sales EMEA" || !slices.Equal(group.Members, []string{"u1", "u3"}) {
+		t.Errorf("Unexpected group %+v", group)
+	}
+
+	if err := applySCIMGroupPatch(group, patchOps(t, `{"Operations": [{"op": "replace", "path": "members", "value": [{"value": "u4"}]}]}`)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !slices.Equal(group.Members, []string{"u4"}) {
+		t.Errorf("Expected members to be replaced, got %v", group.Members)
+	}
+
+	if err := applySCIMGroupPatch(group, patchOps(t, `{"Operations": [{"op": "remove", "path": "members"}]}`)); err != nil || len(group.Members) != 0 {
+		t.Errorf("Expected every member removed, got %v, %v", group.Members, err)
+	}
+}
+
+func TestSCIMUser(t *testing.T) {
+	user := &models.User{ID: "u1", Email: "jane@example.com", Name: "Jane", Disabled: true}
+	resource := scimUser(user, []*models.Group{{ID: "g1", DisplayName: "Sales"}}, "https://op.example.com/scim/v2")
+
+	if resource.UserName != "jane@example.com" || resource.Active == nil || *resource.Active {
+		t.Errorf("Unexpected resource %+v", resource)
+	}
+	if resource.Meta.Location != "https://op.example.com/scim/v2/Users/u1" {
+		t.Errorf("Unexpected location %s", resource.Meta.Location)
+	}
+	if len(resource.Groups) != 1 || resource.Groups[0].Display != "Sales" {
+		t.Errorf("Expected the user's groups, got %+v", resource.Groups)
+	}
+
+	name := scimDisplayName(&SCIMUser{UserName: "x", Name: &SCIMName{GivenName: "Jane", FamilyName: "Doe"}})
+	if name != "Jane Doe" {
+		t.Errorf("Expected the given and family name, got %q", name)
+	}
+}
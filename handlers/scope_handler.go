@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"oauth2-server/middleware"
+	"oauth2-server/models"
+	"oauth2-server/repository"
+	"oauth2-server/utils"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ScopeHandler manages custom scope definitions. Changes are written to the
+// database and applied to the registry straight away, so the validator,
+// consent screen and discovery document pick them up without a restart.
+type ScopeHandler struct {
+	scopeRepo *repository.ScopeRepository
+	registry  *models.ScopeRegistry
+	validator utils.ScopeValidator
+	audit     *Auditor
+}
+
+func NewScopeHandler(scopeRepo *repository.ScopeRepository, registry *models.ScopeRegistry, validator utils.ScopeValidator, audit *Auditor) *ScopeHandler {
+	return &ScopeHandler{
+		scopeRepo: scopeRepo,
+		registry:  registry,
+		validator: validator,
+		audit:     audit,
+	}
+}
+
+// ScopeRequest is the body for creating or updating a custom scope
+type ScopeRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Claims      []string `json:"claims,omitempty"`
+	Sensitive   bool     `json:"sensitive"`
+	IsDefault   bool     `json:"is_default"`
+}
+
+// Reload replaces the registry's custom scopes with those stored in the
+// database. Built-in scopes are kept.
+func (h *ScopeHandler) Reload(ctx context.Context) error {
+	scopes, err := h.scopeRepo.FindAll(ctx)
+	if err != nil {
+		return err
+	}
+	h.registry.ReplaceCustomScopes(scopes)
+	return nil
+}
+
+// RunReload reloads custom scopes every interval so changes made through
+// another server instance are picked up
+func (h *ScopeHandler) RunReload(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := h.Reload(ctx); err != nil {
+				log.Printf("Failed to reload scopes: %v", err)
+			}
+		}
+	}
+}
+
+// ListScopes returns every registered scope, built-in and custom
+// GET /admin/scopes
+func (h *ScopeHandler) ListScopes(w http.ResponseWriter, r *http.Request) {
+	scopes := h.registry.GetAllScopes()
+	sort.Slice(scopes, func(i, j int) bool {
+		return scopes[i].Name < scopes[j].Name
+	})
+	respondJSON(w, http.StatusOK, map[string]interface{}{"scopes": scopes})
+}
+
+// PublicScope is the description of a scope shown to client developers and
+// consent UIs
+type PublicScope struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Sensitive   bool     `json:"sensitive"`
+	Claims      []string `json:"claims"`
+}
+
+// PublicScopes lists every registered scope with its description, in the
+// caller's language, and the claims it grants. It reads the registry, so
+// custom scopes appear as soon as they are created or reloaded.
+// GET /oauth/scopes
+func (h *ScopeHandler) PublicScopes(w http.ResponseWriter, r *http.Request) {
+	locale := middleware.LocaleOf(w)
+	all := h.registry.GetAllScopes()
+	scopes := make([]PublicScope, 0, len(all))
+	for _, scope := range all {
+		claims := scope.Claims
+		if claims == nil {
+			claims = []string{}
+		}
+		scopes = append(scopes, PublicScope{
+			Name:        scope.Name,
+			Description: utils.GlobalMessageCatalog.Translate(locale, scope.Description),
+			Sensitive:   scope.Sensitive,
+			Claims:      claims,
+		})
+	}
+	sort.Slice(scopes, func(i, j int) bool {
+		return scopes[i].Name < scopes[j].Name
+	})
+	respondJSON(w, http.StatusOK, map[string]interface{}{"scopes": scopes})
+}
+
+// CreateScope defines a new custom scope
+// POST /admin/scopes
+func (h *ScopeHandler) CreateScope(w http.ResponseWriter, r *http.Request) {
+	var req ScopeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+
+	if err := h.validator.ValidateScopeName(req.Name); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_scope", err.Error())
+		return
+	}
+	if req.Description == "" {
+		respondError(w, http.StatusBadRequest, "invalid_request", "Description is required")
+		return
+	}
+	if h.registry.IsValidScope(req.Name) {
+		respondError(w, http.StatusConflict, "scope_exists", "Scope already exists")
+		return
+	}
+
+	scope := req.definition()
+	if err := h.scopeRepo.Create(r.Context(), scope); err != nil {
+		respondRepositoryError(w, err, "Scope", "Failed to create scope")
+		return
+	}
+	h.registry.RegisterScope(scope)
+
+	h.audit.record(r, models.AuditScopeCreated, "", "", map[string]string{"scope": scope.Name})
+	respondJSON(w, http.StatusCreated, scope)
+}
+
+// UpdateScope changes the description, claims and flags of a custom scope
+// PUT /admin/scopes/{name}
+func (h *ScopeHandler) UpdateScope(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if !h.customScopeExists(w, name) {
+		return
+	}
+
+	var req ScopeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+	if req.Description == "" {
+		respondError(w, http.StatusBadRequest, "invalid_request", "Description is required")
+		return
+	}
+	req.Name = name
+
+	scope := req.definition()
+	if err := h.scopeRepo.Update(r.Context(), scope); err != nil {
+		respondRepositoryError(w, err, "Scope", "Failed to update scope")
+		return
+	}
+	h.registry.RegisterScope(scope)
+
+	h.audit.record(r, models.AuditScopeUpdated, "", "", map[string]string{"scope": scope.Name})
+	respondJSON(w, http.StatusOK, scope)
+}
+
+// DeleteScope removes a custom scope. Tokens already issued with it keep
+// their scope claim until they expire.
+// DELETE /admin/scopes/{name}
+func (h *ScopeHandler) DeleteScope(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if !h.customScopeExists(w, name) {
+		return
+	}
+
+	if err := h.scopeRepo.Delete(r.Context(), name); err != nil && !errors.Is(err, repository.ErrNotFound) {
+		respondInternalError(w, err, "Failed to delete scope")
+		return
+	}
+	h.registry.UnregisterScope(name)
+
+	h.audit.record(r, models.AuditScopeDeleted, "", "", map[string]string{"scope": name})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// customScopeExists writes an error response unless name is a registered custom scope
+func (h *ScopeHandler) customScopeExists(w http.ResponseWriter, name string) bool {
+	scope, exists := h.registry.GetScope(name)
+	if !exists {
+		respondError(w, http.StatusNotFound, "not_found", "Scope not found")
+		return false
+	}
+	if !scope.Custom {
+		respondError(w, http.StatusForbidden, "built_in_scope", "Built-in scopes cannot be modified")
+		return false
+	}
+	return true
+}
+
+func (req ScopeRequest) definition() *models.ScopeDefinition {
+	claims := req.Claims
+	if claims == nil {
+		claims = []string{}
+	}
+	return &models.ScopeDefinition{
+		Name:        req.Name,
+		Description: req.Description,
+		Claims:      claims,
+		IsDefault:   req.IsDefault,
+		Sensitive:   req.Sensitive,
+		Custom:      true,
+	}
+}
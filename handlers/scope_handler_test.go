@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"oauth2-server/models"
+	"oauth2-server/utils"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestScopeHandler_RejectsBuiltInChanges(t *testing.T) {
+	registry := models.NewScopeRegistry()
+	h := NewScopeHandler(nil, registry, utils.NewScopeValidator(registry), nil)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/admin/scopes/{name}", h.UpdateScope).Methods("PUT")
+	router.HandleFunc("/admin/scopes/{name}", h.DeleteScope).Methods("DELETE")
+
+	tests := []struct {
+		name     string
+		method   string
+		scope    string
+		expected int
+	}{
+		{"Update built-in", "PUT", "profile", http.StatusForbidden},
+		{"Delete built-in", "DELETE", "openid", http.StatusForbidden},
+		{"Delete unknown", "DELETE", "orders:read", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/admin/scopes/"+tt.scope, strings.NewReader(`{"description":"x"}`))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, w.Code)
+			}
+		})
+	}
+}
+
+func TestScopeHandler_CreateScopeValidation(t *testing.T) {
+	registry := models.NewScopeRegistry()
+	h := NewScopeHandler(nil, registry, utils.NewScopeValidator(registry), nil)
+
+	tests := []struct {
+		name     string
+		body     string
+		expected int
+	}{
+		{"Invalid name", `{"name":"orders read","description":"Read orders"}`, http.StatusBadRequest},
+		{"Missing description", `{"name":"orders:read"}`, http.StatusBadRequest},
+		{"Built-in name", `{"name":"email","description":"Email"}`, http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.CreateScope(w, httptest.NewRequest("POST", "/admin/scopes", strings.NewReader(tt.body)))
+
+			if w.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, w.Code)
+			}
+		})
+	}
+}
+
+func TestScopeRegistry_ReplaceCustomScopes(t *testing.T) {
+	registry := models.NewScopeRegistry()
+	registry.ReplaceCustomScopes([]*models.ScopeDefinition{
+		{Name: "orders:read", Description: "Read orders"},
+		{Name: "email", Description: "Shadowed built-in"},
+	})
+
+	if !registry.IsValidScope("orders:read") {
+		t.Error("Expected custom scope to be registered")
+	}
+	if scope, _ := registry.GetScope("email"); scope.Custom {
+		t.Error("Expected custom scope not to replace a built-in scope")
+	}
+
+	registry.ReplaceCustomScopes(nil)
+	if registry.IsValidScope("orders:read") {
+		t.Error("Expected custom scope removed from the database to be unregistered")
+	}
+	if !registry.IsValidScope("email") {
+		t.Error("Expected built-in scopes to survive a reload")
+	}
+}
+
+func TestScopeHandler_PublicScopes(t *testing.T) {
+	registry := models.NewScopeRegistry()
+	registry.RegisterScope(&models.ScopeDefinition{Name: "orders:read", Description: "Read orders", Sensitive: true, Custom: true})
+	h := NewScopeHandler(nil, registry, utils.NewScopeValidator(registry), nil)
+
+	w := httptest.NewRecorder()
+	h.PublicScopes(w, httptest.NewRequest("GET", "/oauth/scopes", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	var body struct {
+		Scopes []PublicScope `json:"scopes"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(body.Scopes) != len(registry.GetAllScopes()) {
+		t.Fatalf("Expected %d scopes, got %d", len(registry.GetAllScopes()), len(body.Scopes))
+	}
+
+	found := false
+	for _, scope := range body.Scopes {
+		if scope.Claims == nil {
+			t.Errorf("Expected claims of %s to be a list", scope.Name)
+		}
+		if scope.Name == "orders:read" {
+			found = true
+			if !scope.Sensitive || scope.Description != "Read orders" {
+				t.Errorf("Unexpected custom scope: %+v", scope)
+			}
+		}
+		if scope.Name == "email" && !slices.Contains(scope.Claims, "email_verified") {
+			t.Errorf("Expected email scope to map email_verified, got %v", scope.Claims)
+		}
+	}
+	if !found {
+		t.Error("Expected custom scope to be listed")
+	}
+}
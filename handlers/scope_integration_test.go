@@ -86,7 +86,7 @@ func TestScopeValidationFlow(t *testing.T) {
 	userRepo := repository.NewUserRepository(db)
 	clientRepo := repository.NewClientRepository(db)
 	authCodeRepo := repository.NewAuthCodeRepository(db)
-	sessionRepo := repository.NewSessionRepository(db)
+	authRequestRepo := repository.NewAuthorizationRequestRepository(db)
 	consentRepo := repository.NewUserConsentRepository(db)
 
 	// Load test keys
@@ -102,7 +102,7 @@ func TestScopeValidationFlow(t *testing.T) {
 		RefreshTokenExpiry: 86400,
 	}
 
-	handler := NewOAuthHandler(userRepo, clientRepo, authCodeRepo, sessionRepo, consentRepo, cfg)
+	handler := NewOAuthHandler(userRepo, clientRepo, authCodeRepo, authRequestRepo, consentRepo, nil, nil, nil, nil, nil, nil, nil, cfg)
 
 	// Create test client with allowed scopes
 	testClient := &models.Client{
@@ -216,15 +216,15 @@ func TestScopeValidationFlow(t *testing.T) {
 			if tt.checkRedirect {
 				// Should redirect to login page
 				location := w.Header().Get("Location")
-				if !strings.HasPrefix(location, "/auth/login?session_id=") {
+				if !strings.HasPrefix(location, "/auth/login?login_challenge=") {
 					t.Errorf("Expected redirect to login page, got: %s", location)
 				}
 
-				// Extract session ID and verify session was created
-				sessionID := strings.TrimPrefix(location, "/auth/login?session_id=")
-				session, err := sessionRepo.FindBySessionID(ctx, sessionID)
+				// Extract the challenge and verify the request was stored
+				challenge := strings.TrimPrefix(location, "/auth/login?login_challenge=")
+				session, err := authRequestRepo.FindByChallenge(ctx, challenge)
 				if err != nil {
-					t.Errorf("Failed to find session: %v", err)
+					t.Errorf("Failed to find authorization request: %v", err)
 					return
 				}
 
@@ -269,7 +269,7 @@ func TestClaimFiltering(t *testing.T) {
 	userRepo := repository.NewUserRepository(db)
 	clientRepo := repository.NewClientRepository(db)
 	authCodeRepo := repository.NewAuthCodeRepository(db)
-	sessionRepo := repository.NewSessionRepository(db)
+	authRequestRepo := repository.NewAuthorizationRequestRepository(db)
 	consentRepo := repository.NewUserConsentRepository(db)
 
 	// Load test keys
@@ -285,7 +285,7 @@ func TestClaimFiltering(t *testing.T) {
 		RefreshTokenExpiry: 86400,
 	}
 
-	handler := NewOAuthHandler(userRepo, clientRepo, authCodeRepo, sessionRepo, consentRepo, cfg)
+	handler := NewOAuthHandler(userRepo, clientRepo, authCodeRepo, authRequestRepo, consentRepo, nil, nil, nil, nil, nil, nil, nil, cfg)
 
 	// Create test user
 	testUser := &models.User{
@@ -435,7 +435,7 @@ func TestUserInfoClaimFiltering(t *testing.T) {
 	userRepo := repository.NewUserRepository(db)
 	clientRepo := repository.NewClientRepository(db)
 	authCodeRepo := repository.NewAuthCodeRepository(db)
-	sessionRepo := repository.NewSessionRepository(db)
+	authRequestRepo := repository.NewAuthorizationRequestRepository(db)
 	consentRepo := repository.NewUserConsentRepository(db)
 
 	// Load test keys
@@ -451,7 +451,7 @@ func TestUserInfoClaimFiltering(t *testing.T) {
 		RefreshTokenExpiry: 86400,
 	}
 
-	handler := NewOAuthHandler(userRepo, clientRepo, authCodeRepo, sessionRepo, consentRepo, cfg)
+	handler := NewOAuthHandler(userRepo, clientRepo, authCodeRepo, authRequestRepo, consentRepo, nil, nil, nil, nil, nil, nil, nil, cfg)
 
 	// Create test user
 	testUser := &models.User{
@@ -562,7 +562,7 @@ func TestScopeDowngrade(t *testing.T) {
 	userRepo := repository.NewUserRepository(db)
 	clientRepo := repository.NewClientRepository(db)
 	authCodeRepo := repository.NewAuthCodeRepository(db)
-	sessionRepo := repository.NewSessionRepository(db)
+	authRequestRepo := repository.NewAuthorizationRequestRepository(db)
 	consentRepo := repository.NewUserConsentRepository(db)
 
 	// Load test keys
@@ -578,7 +578,7 @@ func TestScopeDowngrade(t *testing.T) {
 		RefreshTokenExpiry: 86400,
 	}
 
-	handler := NewOAuthHandler(userRepo, clientRepo, authCodeRepo, sessionRepo, consentRepo, cfg)
+	handler := NewOAuthHandler(userRepo, clientRepo, authCodeRepo, authRequestRepo, consentRepo, nil, nil, nil, nil, nil, nil, nil, cfg)
 
 	// Create test user with explicit string ID
 	userID, _ := utils.GenerateRandomString(32)
@@ -794,7 +794,7 @@ func TestScopeDowngradeWithMultipleRefreshes(t *testing.T) {
 	userRepo := repository.NewUserRepository(db)
 	clientRepo := repository.NewClientRepository(db)
 	authCodeRepo := repository.NewAuthCodeRepository(db)
-	sessionRepo := repository.NewSessionRepository(db)
+	authRequestRepo := repository.NewAuthorizationRequestRepository(db)
 	consentRepo := repository.NewUserConsentRepository(db)
 
 	// Load test keys
@@ -810,7 +810,7 @@ func TestScopeDowngradeWithMultipleRefreshes(t *testing.T) {
 		RefreshTokenExpiry: 86400,
 	}
 
-	handler := NewOAuthHandler(userRepo, clientRepo, authCodeRepo, sessionRepo, consentRepo, cfg)
+	handler := NewOAuthHandler(userRepo, clientRepo, authCodeRepo, authRequestRepo, consentRepo, nil, nil, nil, nil, nil, nil, nil, cfg)
 
 	// Create test user with explicit string ID
 	userID, _ := utils.GenerateRandomString(32)
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"net/http"
+	"oauth2-server/models"
+	"oauth2-server/utils"
+)
+
+// describeSession records on a new SSO session the browser, operating
+// system and, when GeoIP is configured, location of the request starting it
+func (h *AuthHandler) describeSession(r *http.Request, session *models.SSOSession) {
+	session.Browser, session.OS = utils.ParseUserAgent(r.UserAgent())
+	if h.locations == nil {
+		return
+	}
+	if location, ok := h.locations.Lookup(clientIP(r)); ok {
+		session.Location = &location
+	}
+}
+
+// sessionDevice returns the browser and operating system of the session.
+// Sessions started before they were recorded are described from their
+// user agent.
+func sessionDevice(session *models.SSOSession) (string, string) {
+	if session.Browser == "" && session.OS == "" {
+		return utils.ParseUserAgent(session.UserAgent)
+	}
+	return session.Browser, session.OS
+}
+
+// sessionDeviceName names the session the way users recognise it, such as
+// "Chrome on macOS — Bangkok", or returns "" when nothing is known
+func sessionDeviceName(session *models.SSOSession) string {
+	browser, os := sessionDevice(session)
+	name := browser
+	if os != "" {
+		if name != "" {
+			name += " on "
+		}
+		name += os
+	}
+	if session.Location != nil {
+		if location := session.Location.String(); location != "" {
+			if name != "" {
+				name += " — "
+			}
+			name += location
+		}
+	}
+	return name
+}
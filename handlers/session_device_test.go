@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"oauth2-server/geoip"
+	"oauth2-server/models"
+	"testing"
+)
+
+func TestDescribeSession(t *testing.T) {
+	locations, err := geoip.New(map[string]models.GeoLocation{
+		"192.0.2.0/24": {City: "Bangkok", Country: "Thailand"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &AuthHandler{locations: locations}
+
+	req := httptest.NewRequest("POST", "/auth/login", nil)
+	req.RemoteAddr = "192.0.2.10:51234"
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36")
+
+	session := &models.SSOSession{UserAgent: req.UserAgent()}
+	h.describeSession(req, session)
+	if session.Browser != "Chrome" || session.OS != "macOS" || session.Location == nil || session.Location.City != "Bangkok" {
+		t.Fatalf("Unexpected session description: %+v", session)
+	}
+	if name := sessionDeviceName(session); name != "Chrome on macOS — Bangkok" {
+		t.Errorf("Expected a friendly device name, got %q", name)
+	}
+
+	req.RemoteAddr = "198.51.100.1:51234"
+	session = &models.SSOSession{}
+	h.describeSession(req, session)
+	if session.Location != nil {
+		t.Errorf("Expected no location for an unknown network, got %v", session.Location)
+	}
+}
+
+func TestSessionDeviceName(t *testing.T) {
+	tests := []struct {
+		name    string
+		session *models.SSOSession
+		want    string
+	}{
+		{"recorded", &models.SSOSession{Browser: "Firefox", OS: "Linux"}, "Firefox on Linux"},
+		{"from user agent", &models.SSOSession{UserAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) Version/17.5 Safari/604.1"}, "Safari on iOS"},
+		{"location only", &models.SSOSession{Location: &models.GeoLocation{Country: "Thailand"}}, "Thailand"},
+		{"unknown", &models.SSOSession{UserAgent: "custom-client"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sessionDeviceName(tt.session); got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
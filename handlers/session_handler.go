@@ -3,11 +3,14 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"oauth2-server/config"
+	"oauth2-server/models"
 	"oauth2-server/repository"
-	"oauth2-server/utils"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -18,6 +21,12 @@ type SessionHandler struct {
 	ssoSessionRepo *repository.SSOSessionRepository
 	consentRepo    *repository.UserConsentRepository
 	clientRepo     *repository.ClientRepository
+	trustedDevices *repository.TrustedDeviceRepository
+	auditRepo      *repository.AuditRepository
+	revoker        *Revoker
+	templates      *TemplateRenderer
+	audit          *Auditor
+	tokens         *TokenStore
 	config         *config.Config
 }
 
@@ -25,12 +34,24 @@ func NewSessionHandler(
 	ssoSessionRepo *repository.SSOSessionRepository,
 	consentRepo *repository.UserConsentRepository,
 	clientRepo *repository.ClientRepository,
+	trustedDevices *repository.TrustedDeviceRepository,
+	auditRepo *repository.AuditRepository,
+	revoker *Revoker,
+	templates *TemplateRenderer,
+	audit *Auditor,
+	tokens *TokenStore,
 	cfg *config.Config,
 ) *SessionHandler {
 	return &SessionHandler{
 		ssoSessionRepo: ssoSessionRepo,
 		consentRepo:    consentRepo,
 		clientRepo:     clientRepo,
+		trustedDevices: trustedDevices,
+		auditRepo:      auditRepo,
+		revoker:        revoker,
+		templates:      templates,
+		audit:          audit,
+		tokens:         tokens,
 		config:         cfg,
 	}
 }
@@ -43,11 +64,48 @@ type SessionResponse struct {
 	ExpiresAt    string `json:"expires_at"`
 	IPAddress    string `json:"ip_address,omitempty"`
 	UserAgent    string `json:"user_agent,omitempty"`
+	// DeviceName is a friendly name such as "Chrome on macOS — Bangkok",
+	// built from Browser, OS and Location
+	DeviceName string `json:"device_name,omitempty"`
+	Browser    string `json:"browser,omitempty"`
+	OS         string `json:"os,omitempty"`
+	Location   string `json:"location,omitempty"`
+	// IdleSeconds is the time since the session's last activity. With an
+	// idle timeout configured, IdleExpiresAt is when the session ends unless
+	// it is used, and IdleExpired means it already has.
+	IdleSeconds   int64  `json:"idle_seconds"`
+	IdleExpiresAt string `json:"idle_expires_at,omitempty"`
+	IdleExpired   bool   `json:"idle_expired"`
+}
+
+// newSessionResponse describes the session, including its idle status
+func newSessionResponse(session *models.SSOSession, cfg *config.Config) SessionResponse {
+	now := time.Now()
+	response := SessionResponse{
+		SessionID:    session.SessionID,
+		CreatedAt:    session.CreatedAt.Format(time.RFC3339),
+		LastActivity: session.LastActivity.Format(time.RFC3339),
+		ExpiresAt:    session.ExpiresAt.Format(time.RFC3339),
+		IPAddress:    session.IPAddress,
+		UserAgent:    session.UserAgent,
+		IdleSeconds:  int64(now.Sub(session.LastActivity).Seconds()),
+	}
+	response.Browser, response.OS = sessionDevice(session)
+	if session.Location != nil {
+		response.Location = session.Location.String()
+	}
+	response.DeviceName = sessionDeviceName(session)
+	if idleExpiresAt := session.IdleExpiresAt(time.Duration(cfg.SSOIdleTimeout) * time.Second); !idleExpiresAt.IsZero() {
+		response.IdleExpiresAt = idleExpiresAt.Format(time.RFC3339)
+		response.IdleExpired = !now.Before(idleExpiresAt)
+	}
+	return response
 }
 
 // ListSessionsResponse represents the response for listing sessions
 type ListSessionsResponse struct {
 	Sessions []SessionResponse `json:"sessions"`
+	PageInfo
 }
 
 // AuthorizationResponse represents an authorization in the API response
@@ -57,37 +115,45 @@ type AuthorizationResponse struct {
 	Scopes     []string `json:"scopes"`
 	GrantedAt  string   `json:"granted_at"`
 	ExpiresAt  string   `json:"expires_at,omitempty"`
+	// ExpiresIn is the remaining validity in seconds; RenewalDue means the
+	// user will be asked to renew the consent on the next authorization
+	ExpiresIn  int64 `json:"expires_in,omitempty"`
+	RenewalDue bool  `json:"renewal_due,omitempty"`
 }
 
 // ListAuthorizationsResponse represents the response for listing authorizations
 type ListAuthorizationsResponse struct {
 	Authorizations []AuthorizationResponse `json:"authorizations"`
+	PageInfo
 }
 
 // extractUserIDFromToken extracts and validates the user ID from the Authorization header
 func (h *SessionHandler) extractUserIDFromToken(r *http.Request) (string, error) {
+	userID, _, err := parseBearerToken(r, h.tokens, h.config)
+	return userID, err
+}
+
+// parseBearerToken validates the access token in the Authorization header and
+// returns its subject and scope. JWT, JWE and opaque tokens are supported;
+// machine tokens are rejected since there is no user behind them.
+func parseBearerToken(r *http.Request, tokens *TokenStore, cfg *config.Config) (string, string, error) {
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		return "", &AuthError{Code: "unauthorized", Message: "Authorization required"}
+		return "", "", &AuthError{Code: "unauthorized", Message: "Authorization required"}
 	}
 
-	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	
-	// Support both JWT and JWE tokens
-	if utils.IsJWE(tokenString) {
-		jweClaims, err := utils.ValidateJWE(tokenString, h.config.PrivateKey)
-		if err != nil {
-			return "", &AuthError{Code: "invalid_token", Message: "Invalid or expired token"}
-		}
-		return jweClaims.UserID, nil
+	tokenString, dpop := accessTokenFromHeader(authHeader)
+
+	// Support JWT, JWE and opaque tokens
+	token, err := tokens.resolveAccessToken(r.Context(), tokenString, cfg)
+	if err != nil || !presentedBindingMatches(r, token, tokenString, dpop, cfg) {
+		return "", "", &AuthError{Code: "invalid_token", Message: "Invalid or expired token"}
 	}
-	
-	jwtClaims, err := utils.ValidateToken(tokenString, h.config.PublicKey)
-	if err != nil {
-		return "", &AuthError{Code: "invalid_token", Message: "Invalid or expired token"}
+	if token.machine() {
+		return "", "", &AuthError{Code: "invalid_token", Message: "The access token was not issued for a user"}
 	}
-	
-	return jwtClaims.UserID, nil
+
+	return token.UserID, token.Scope, nil
 }
 
 // AuthError represents an authentication error
@@ -100,8 +166,9 @@ func (e *AuthError) Error() string {
 	return e.Message
 }
 
-// ListSessions returns all active SSO sessions for the authenticated user
-// GET /account/sessions
+// ListSessions returns a page of the authenticated user's SSO sessions,
+// optionally only the active ones
+// GET /account/sessions?limit=20&cursor=...&sort=created_at|last_activity&order=desc&active=true
 func (h *SessionHandler) ListSessions(w http.ResponseWriter, r *http.Request) {
 	// Extract user ID from access token
 	userID, err := h.extractUserIDFromToken(r)
@@ -114,30 +181,29 @@ func (h *SessionHandler) ListSessions(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctx := context.Background()
-	
-	// Fetch all sessions for the user
-	sessions, err := h.ssoSessionRepo.FindByUserID(ctx, userID)
+	ctx := r.Context()
+
+	page, err := parsePageRequest(r.URL.Query(), "created_at", "last_activity")
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, "server_error", "Failed to retrieve sessions")
+		respondError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	sessions, next, err := h.ssoSessionRepo.ListByUserID(ctx, userID, sessionFilter(r, h.config), page)
+	if err != nil {
+		respondPageError(w, err, "Failed to retrieve sessions")
 		return
 	}
 
 	// Convert to response format
 	sessionResponses := make([]SessionResponse, 0, len(sessions))
 	for _, session := range sessions {
-		sessionResponses = append(sessionResponses, SessionResponse{
-			SessionID:    session.SessionID,
-			CreatedAt:    session.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
-			LastActivity: session.LastActivity.Format("2006-01-02T15:04:05Z07:00"),
-			ExpiresAt:    session.ExpiresAt.Format("2006-01-02T15:04:05Z07:00"),
-			IPAddress:    session.IPAddress,
-			UserAgent:    session.UserAgent,
-		})
+		sessionResponses = append(sessionResponses, newSessionResponse(session, h.config))
 	}
 
 	response := ListSessionsResponse{
 		Sessions: sessionResponses,
+		PageInfo: newPageInfo(page, next),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -168,7 +234,7 @@ func (h *SessionHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctx := context.Background()
+	ctx := r.Context()
 	
 	// Verify the session belongs to the authenticated user
 	session, err := h.ssoSessionRepo.FindBySessionID(ctx, sessionID)
@@ -184,9 +250,10 @@ func (h *SessionHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
 
 	// Delete the session
 	if err := h.ssoSessionRepo.Delete(ctx, sessionID); err != nil {
-		respondError(w, http.StatusInternalServerError, "server_error", "Failed to revoke session")
+		respondInternalError(w, err, "Failed to revoke session")
 		return
 	}
+	h.audit.record(r, models.AuditSessionRevoked, userID, "", nil)
 
 	// Return success response
 	response := map[string]string{
@@ -198,8 +265,57 @@ func (h *SessionHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-// ListAuthorizations returns all active authorizations (consents) for the authenticated user
-// GET /account/authorizations
+// RevokeAllSessions logs the authenticated user out everywhere: it ends
+// their SSO sessions and revokes their refresh and access tokens. With
+// keep_current=true the SSO session of the calling browser is kept, so it
+// can get new tokens without signing in again.
+// DELETE /account/sessions
+func (h *SessionHandler) RevokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	userID, err := h.extractUserIDFromToken(r)
+	if err != nil {
+		if authErr, ok := err.(*AuthError); ok {
+			respondError(w, http.StatusUnauthorized, authErr.Code, authErr.Message)
+			return
+		}
+		respondError(w, http.StatusUnauthorized, "unauthorized", "Authentication failed")
+		return
+	}
+
+	ctx := r.Context()
+
+	keep := ""
+	if r.URL.Query().Get("keep_current") == "true" {
+		if cookie, err := r.Cookie(SSOCookieName); err == nil && cookie.Value != "" {
+			if session, err := h.ssoSessionRepo.FindBySessionID(ctx, cookie.Value); err == nil && session.UserID == userID {
+				keep = session.SessionID
+			}
+		}
+	}
+
+	revoked, err := h.revoker.RevokeSessions(ctx, userID, keep)
+	if err != nil {
+		respondInternalError(w, err, "Failed to revoke sessions")
+		return
+	}
+	h.audit.record(r, models.AuditSessionRevoked, userID, "", map[string]string{
+		"bulk":         "true",
+		"kept_current": strconv.FormatBool(keep != ""),
+	})
+
+	if keep == "" {
+		http.SetCookie(w, &http.Cookie{Name: SSOCookieName, Path: SSOCookiePath, MaxAge: -1})
+	}
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"message":      "All sessions revoked successfully",
+		"revoked":      revoked,
+		"kept_current": keep != "",
+	})
+}
+
+// ListAuthorizations returns a page of the authorizations (consents) the
+// authenticated user has granted, optionally for one client or only the
+// unexpired ones
+// GET /account/authorizations?limit=20&cursor=...&order=desc&client_id=...&active=true
 func (h *SessionHandler) ListAuthorizations(w http.ResponseWriter, r *http.Request) {
 	// Extract user ID from access token
 	userID, err := h.extractUserIDFromToken(r)
@@ -212,12 +328,17 @@ func (h *SessionHandler) ListAuthorizations(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	ctx := context.Background()
-	
-	// Fetch all consents for the user
-	consents, err := h.consentRepo.ListUserConsents(ctx, userID)
+	ctx := r.Context()
+
+	page, err := parsePageRequest(r.URL.Query(), "granted_at")
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	consents, next, err := h.consentRepo.ListPageByUserID(ctx, userID, consentFilter(r), page)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, "server_error", "Failed to retrieve authorizations")
+		respondPageError(w, err, "Failed to retrieve authorizations")
 		return
 	}
 
@@ -232,8 +353,12 @@ func (h *SessionHandler) ListAuthorizations(w http.ResponseWriter, r *http.Reque
 		}
 
 		expiresAt := ""
+		var expiresIn int64
 		if !consent.ExpiresAt.IsZero() {
 			expiresAt = consent.ExpiresAt.Format("2006-01-02T15:04:05Z07:00")
+			if remaining := time.Until(consent.ExpiresAt); remaining > 0 {
+				expiresIn = int64(remaining.Seconds())
+			}
 		}
 
 		authResponses = append(authResponses, AuthorizationResponse{
@@ -242,11 +367,14 @@ func (h *SessionHandler) ListAuthorizations(w http.ResponseWriter, r *http.Reque
 			Scopes:     consent.Scopes,
 			GrantedAt:  consent.GrantedAt.Format("2006-01-02T15:04:05Z07:00"),
 			ExpiresAt:  expiresAt,
+			ExpiresIn:  expiresIn,
+			RenewalDue: consentNeedsRenewal(consent, h.config, time.Now()),
 		})
 	}
 
 	response := ListAuthorizationsResponse{
 		Authorizations: authResponses,
+		PageInfo:       newPageInfo(page, next),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -277,7 +405,7 @@ func (h *SessionHandler) RevokeAuthorization(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	ctx := context.Background()
+	ctx := r.Context()
 	
 	// Verify the consent exists for this user and client
 	consent, err := h.consentRepo.FindByUserAndClient(ctx, userID, clientID)
@@ -292,11 +420,16 @@ func (h *SessionHandler) RevokeAuthorization(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	// Delete the consent record
-	if err := h.consentRepo.RevokeConsent(ctx, userID, clientID); err != nil {
-		respondError(w, http.StatusInternalServerError, "server_error", "Failed to revoke authorization")
+	// Revoke the consent, keeping its history
+	if err := h.consentRepo.RevokeConsent(ctx, userID, clientID, h.callerSessionRef(r, userID)); err != nil {
+		respondInternalError(w, err, "Failed to revoke authorization")
 		return
 	}
+	if err := h.revokeClientGrant(ctx, userID, clientID); err != nil {
+		respondInternalError(w, err, "Failed to revoke authorization")
+		return
+	}
+	h.audit.record(r, models.AuditConsentRevoked, userID, clientID, map[string]string{"scope": strings.Join(consent.Scopes, " ")})
 
 	// Return success response
 	response := map[string]string{
@@ -307,3 +440,75 @@ func (h *SessionHandler) RevokeAuthorization(w http.ResponseWriter, r *http.Requ
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(response)
 }
+
+// revokeClientGrant revokes the tokens the user holds for the client and
+// tells the client over its back channel
+func (h *SessionHandler) revokeClientGrant(ctx context.Context, userID, clientID string) error {
+	client, err := h.clientRepo.FindByClientID(ctx, clientID)
+	if errors.Is(err, repository.ErrNotFound) {
+		// A deleted client has no one to notify, but its tokens still go
+		client = &models.Client{ClientID: clientID}
+	} else if err != nil {
+		return err
+	}
+	return h.revoker.RevokeGrant(ctx, userID, client)
+}
+
+// callerSessionRef references the caller's SSO session in the consent
+// history, when the request carries the session cookie of the token's user
+func (h *SessionHandler) callerSessionRef(r *http.Request, userID string) string {
+	cookie, err := r.Cookie(SSOCookieName)
+	if err != nil {
+		return ""
+	}
+	session, err := h.ssoSessionRepo.FindBySessionID(r.Context(), cookie.Value)
+	if err != nil || session.UserID != userID {
+		return ""
+	}
+	return accountSessionRef(session.SessionID)
+}
+
+// RevokeAllAuthorizations revokes every authorization (consent) the
+// authenticated user has granted, so each application must ask again
+// DELETE /account/authorizations
+func (h *SessionHandler) RevokeAllAuthorizations(w http.ResponseWriter, r *http.Request) {
+	userID, err := h.extractUserIDFromToken(r)
+	if err != nil {
+		if authErr, ok := err.(*AuthError); ok {
+			respondError(w, http.StatusUnauthorized, authErr.Code, authErr.Message)
+			return
+		}
+		respondError(w, http.StatusUnauthorized, "unauthorized", "Authentication failed")
+		return
+	}
+
+	ctx := r.Context()
+
+	// List first so each revoked client is recorded in the audit log
+	consents, err := h.consentRepo.ListUserConsents(ctx, userID)
+	if err != nil {
+		respondInternalError(w, err, "Failed to revoke authorizations")
+		return
+	}
+
+	revoked, err := h.consentRepo.RevokeAllForUser(ctx, userID, h.callerSessionRef(r, userID))
+	if err != nil {
+		respondInternalError(w, err, "Failed to revoke authorizations")
+		return
+	}
+
+	// Each client loses the tokens it holds and is told to end the user's
+	// sessions, as when the authorizations are revoked one by one
+	for _, consent := range consents {
+		if err := h.revokeClientGrant(ctx, userID, consent.ClientID); err != nil {
+			respondInternalError(w, err, "Failed to revoke authorizations")
+			return
+		}
+		h.audit.record(r, models.AuditConsentRevoked, userID, consent.ClientID, map[string]string{"scope": strings.Join(consent.Scopes, " "), "bulk": "true"})
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "All authorizations revoked successfully",
+		"revoked": revoked,
+	})
+}
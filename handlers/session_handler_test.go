@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"oauth2-server/backchannel"
 	"oauth2-server/config"
 	"oauth2-server/models"
 	"oauth2-server/repository"
@@ -15,6 +16,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/mux"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -106,7 +108,7 @@ func TestListSessions(t *testing.T) {
 	consentRepo := repository.NewUserConsentRepository(db)
 	clientRepo := repository.NewClientRepository(db)
 
-	handler := NewSessionHandler(ssoSessionRepo, consentRepo, clientRepo, cfg)
+	handler := NewSessionHandler(ssoSessionRepo, consentRepo, clientRepo, nil, nil, nil, NewTemplateRenderer(false, "", ""), nil, nil, cfg)
 
 	// Create request
 	req := httptest.NewRequest("GET", "/account/sessions", nil)
@@ -229,7 +231,7 @@ func TestRevokeSession(t *testing.T) {
 	consentRepo := repository.NewUserConsentRepository(db)
 	clientRepo := repository.NewClientRepository(db)
 
-	handler := NewSessionHandler(ssoSessionRepo, consentRepo, clientRepo, cfg)
+	handler := NewSessionHandler(ssoSessionRepo, consentRepo, clientRepo, nil, nil, nil, NewTemplateRenderer(false, "", ""), nil, nil, cfg)
 
 	// Create request with mux vars
 	req := httptest.NewRequest("DELETE", "/account/sessions/session-to-revoke", nil)
@@ -286,7 +288,7 @@ func TestRevokeSessionUnauthorized(t *testing.T) {
 	consentRepo := repository.NewUserConsentRepository(db)
 	clientRepo := repository.NewClientRepository(db)
 
-	handler := NewSessionHandler(ssoSessionRepo, consentRepo, clientRepo, cfg)
+	handler := NewSessionHandler(ssoSessionRepo, consentRepo, clientRepo, nil, nil, nil, NewTemplateRenderer(false, "", ""), nil, nil, cfg)
 
 	// Create request without authorization header
 	req := httptest.NewRequest("DELETE", "/account/sessions/some-session", nil)
@@ -406,7 +408,7 @@ func TestListAuthorizations(t *testing.T) {
 		t.Fatalf("Failed to generate access token: %v", err)
 	}
 
-	handler := NewSessionHandler(ssoSessionRepo, consentRepo, clientRepo, cfg)
+	handler := NewSessionHandler(ssoSessionRepo, consentRepo, clientRepo, nil, nil, nil, NewTemplateRenderer(false, "", ""), nil, nil, cfg)
 
 	// Create request
 	req := httptest.NewRequest("GET", "/account/authorizations", nil)
@@ -506,13 +508,21 @@ func TestRevokeAuthorization(t *testing.T) {
 		t.Fatalf("Failed to create test user: %v", err)
 	}
 
-	// Create test client
+	// Create test client, listening for back-channel logout
+	logoutTokens := make(chan string, 1)
+	logoutServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logoutTokens <- r.PostFormValue("logout_token")
+	}))
+	defer logoutServer.Close()
+	logout := backchannel.NewNotifier(time.Second, 1, 0)
+
 	testClient := &models.Client{
-		ClientID:     "test-client-revoke",
-		ClientSecret: "secret",
-		Name:         "Test Revoke Application",
-		RedirectURIs: []string{"http://localhost:3000/callback"},
-		CreatedAt:    time.Now(),
+		ClientID:             "test-client-revoke",
+		ClientSecret:         "secret",
+		Name:                 "Test Revoke Application",
+		RedirectURIs:         []string{"http://localhost:3000/callback"},
+		BackchannelLogoutURI: logoutServer.URL,
+		CreatedAt:            time.Now(),
 	}
 	if err := clientRepo.Create(ctx, testClient); err != nil {
 		t.Fatalf("Failed to create test client: %v", err)
@@ -543,7 +553,8 @@ func TestRevokeAuthorization(t *testing.T) {
 		t.Fatalf("Failed to generate access token: %v", err)
 	}
 
-	handler := NewSessionHandler(ssoSessionRepo, consentRepo, clientRepo, cfg)
+	revoker := NewRevoker(ssoSessionRepo, repository.NewAuthCodeRepository(db), nil, logout, cfg)
+	handler := NewSessionHandler(ssoSessionRepo, consentRepo, clientRepo, nil, nil, revoker, NewTemplateRenderer(false, "", ""), nil, nil, cfg)
 
 	// Create request with mux vars
 	req := httptest.NewRequest("DELETE", "/account/authorizations/test-client-revoke", nil)
@@ -566,6 +577,22 @@ func TestRevokeAuthorization(t *testing.T) {
 	if err == nil {
 		t.Error("Expected consent to be deleted, but it still exists")
 	}
+
+	// The client is told, with a logout token for the user
+	select {
+	case token := <-logoutTokens:
+		parsed, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
+			return publicKey, nil
+		})
+		if err != nil {
+			t.Fatalf("Invalid logout token: %v", err)
+		}
+		if sub, _ := parsed.Claims.GetSubject(); sub != testUser.ID {
+			t.Errorf("Expected the logout token for %s, got %s", testUser.ID, sub)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a back-channel logout")
+	}
 }
 
 func TestRevokeAuthorizationNotFound(t *testing.T) {
@@ -624,7 +651,7 @@ func TestRevokeAuthorizationNotFound(t *testing.T) {
 		t.Fatalf("Failed to generate access token: %v", err)
 	}
 
-	handler := NewSessionHandler(ssoSessionRepo, consentRepo, clientRepo, cfg)
+	handler := NewSessionHandler(ssoSessionRepo, consentRepo, clientRepo, nil, nil, nil, NewTemplateRenderer(false, "", ""), nil, nil, cfg)
 
 	// Create request for non-existent authorization
 	req := httptest.NewRequest("DELETE", "/account/authorizations/non-existent-client", nil)
@@ -642,3 +669,235 @@ func TestRevokeAuthorizationNotFound(t *testing.T) {
 		t.Errorf("Expected status 404, got %d", rr.Code)
 	}
 }
+
+func TestRevokeAllAuthorizations(t *testing.T) {
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI("mongodb://localhost:27017"))
+	if err != nil {
+		t.Skip("MongoDB not available, skipping integration test")
+		return
+	}
+	defer client.Disconnect(ctx)
+
+	db := client.Database("oauth2_test_revoke_all_authorizations")
+	defer db.Drop(ctx)
+
+	ssoSessionRepo := repository.NewSSOSessionRepository(db)
+	consentRepo := repository.NewUserConsentRepository(db)
+	clientRepo := repository.NewClientRepository(db)
+
+	privateKey, publicKey, err := utils.LoadTestKeys()
+	if err != nil {
+		t.Fatalf("Failed to load test keys: %v", err)
+	}
+	cfg := &config.Config{PrivateKey: privateKey, PublicKey: publicKey, AccessTokenExpiry: 3600}
+
+	// Two consents for the user and one for someone else that must survive
+	for _, c := range []struct{ userID, clientID string }{
+		{"test-user-revoke-all", "client-a"},
+		{"test-user-revoke-all", "client-b"},
+		{"other-user", "client-a"},
+	} {
+		consent := &models.UserConsent{UserID: c.userID, ClientID: c.clientID, Scopes: []string{"openid"}}
+		if err := consentRepo.Create(ctx, consent); err != nil {
+			t.Fatalf("Failed to create test consent: %v", err)
+		}
+	}
+
+	// client-a listens for back-channel logout; client-b has been deleted
+	logoutTokens := make(chan string, 2)
+	logoutServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logoutTokens <- r.PostFormValue("logout_token")
+	}))
+	defer logoutServer.Close()
+	logout := backchannel.NewNotifier(time.Second, 1, 0)
+	if err := clientRepo.Create(ctx, &models.Client{ClientID: "client-a", Name: "Client A", BackchannelLogoutURI: logoutServer.URL}); err != nil {
+		t.Fatalf("Failed to create test client: %v", err)
+	}
+
+	revocations := repository.NewRevocationRepository(db)
+
+	session := &models.SSOSession{
+		SessionID:     "sso-revoke-all",
+		UserID:        "test-user-revoke-all",
+		Authenticated: true,
+		CreatedAt:     time.Now(),
+		ExpiresAt:     time.Now().Add(time.Hour),
+	}
+	if err := ssoSessionRepo.Create(ctx, session); err != nil {
+		t.Fatalf("Failed to create SSO session: %v", err)
+	}
+
+	accessToken, err := utils.GenerateAccessToken("test-user-revoke-all", "revokeall@example.com", "Revoke All", "openid", privateKey, 3600)
+	if err != nil {
+		t.Fatalf("Failed to generate access token: %v", err)
+	}
+
+	revoker := NewRevoker(ssoSessionRepo, repository.NewAuthCodeRepository(db), NewTokenStore(nil, revocations), logout, cfg)
+	handler := NewSessionHandler(ssoSessionRepo, consentRepo, clientRepo, nil, nil, revoker, NewTemplateRenderer(false, "", ""), nil, nil, cfg)
+	req := httptest.NewRequest("DELETE", "/account/authorizations", nil)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.AddCookie(&http.Cookie{Name: SSOCookieName, Value: session.SessionID})
+	rr := httptest.NewRecorder()
+	handler.RevokeAllAuthorizations(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+	var resp struct {
+		Revoked int64 `json:"revoked"`
+	}
+	json.NewDecoder(rr.Body).Decode(&resp)
+	if resp.Revoked != 2 {
+		t.Errorf("Expected 2 revoked authorizations, got %d", resp.Revoked)
+	}
+
+	remaining, _ := consentRepo.ListUserConsents(ctx, "test-user-revoke-all")
+	if len(remaining) != 0 {
+		t.Errorf("Expected no consents left for the user, got %d", len(remaining))
+	}
+	if _, err := consentRepo.FindByUserAndClient(ctx, "other-user", "client-a"); err != nil {
+		t.Error("Expected other users' consents to be kept")
+	}
+
+	// Every revoked client loses the user's tokens, the other user keeps theirs
+	for _, clientID := range []string{"client-a", "client-b"} {
+		if revokedAt, err := revocations.RevokedAt(ctx, "test-user-revoke-all", clientID); err != nil || revokedAt.IsZero() {
+			t.Errorf("Expected the grant to %s to be revoked, got %v (%v)", clientID, revokedAt, err)
+		}
+	}
+	if revokedAt, _ := revocations.RevokedAt(ctx, "other-user", "client-a"); !revokedAt.IsZero() {
+		t.Error("Expected other users' grants to be kept")
+	}
+
+	// The revocation is recorded against the session it was made from
+	history, err := consentRepo.FindHistory(ctx, "test-user-revoke-all", "client-a")
+	if err != nil {
+		t.Fatalf("Failed to load consent history: %v", err)
+	}
+	if n := len(history.History); n == 0 || history.History[n-1].SessionRef != accountSessionRef(session.SessionID) {
+		t.Errorf("Expected the revocation to reference the caller's session, got %+v", history.History)
+	}
+
+	select {
+	case token := <-logoutTokens:
+		parsed, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
+			return publicKey, nil
+		})
+		if err != nil {
+			t.Fatalf("Invalid logout token: %v", err)
+		}
+		if sub, _ := parsed.Claims.GetSubject(); sub != "test-user-revoke-all" {
+			t.Errorf("Expected the logout token for test-user-revoke-all, got %s", sub)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a back-channel logout")
+	}
+}
+
+func TestListAuthorizationsAbortsWhenClientDisconnects(t *testing.T) {
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI("mongodb://localhost:27017"))
+	if err != nil {
+		t.Skip("MongoDB not available, skipping integration test")
+		return
+	}
+	defer client.Disconnect(ctx)
+
+	db := client.Database("oauth2_test_request_context")
+	defer db.Drop(ctx)
+
+	privateKey, publicKey, err := utils.LoadTestKeys()
+	if err != nil {
+		t.Fatalf("Failed to load test keys: %v", err)
+	}
+	cfg := &config.Config{PrivateKey: privateKey, PublicKey: publicKey, AccessTokenExpiry: 3600}
+	handler := NewSessionHandler(repository.NewSSOSessionRepository(db), repository.NewUserConsentRepository(db), repository.NewClientRepository(db), nil, nil, nil, NewTemplateRenderer(false, "", ""), nil, nil, cfg)
+
+	accessToken, err := utils.GenerateAccessToken("test-user-disconnect", "disconnect@example.com", "Disconnect", "openid", privateKey, 3600)
+	if err != nil {
+		t.Fatalf("Failed to generate access token: %v", err)
+	}
+
+	// The client has gone away before the handler queries the database
+	requestCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	req := httptest.NewRequest("GET", "/account/authorizations", nil).WithContext(requestCtx)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	rr := httptest.NewRecorder()
+	handler.ListAuthorizations(rr, req)
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("Expected the query to be aborted with status 500, got %d", rr.Code)
+	}
+}
+
+func TestRevokeAllSessionsKeepCurrent(t *testing.T) {
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI("mongodb://localhost:27017"))
+	if err != nil {
+		t.Skip("MongoDB not available, skipping integration test")
+		return
+	}
+	defer client.Disconnect(ctx)
+
+	db := client.Database("oauth2_test_session_revoke_all")
+	defer db.Drop(ctx)
+
+	ssoSessionRepo := repository.NewSSOSessionRepository(db)
+
+	privateKey, publicKey, err := utils.LoadTestKeys()
+	if err != nil {
+		t.Fatalf("Failed to load test keys: %v", err)
+	}
+	cfg := &config.Config{
+		PrivateKey:         privateKey,
+		PublicKey:          publicKey,
+		AccessTokenExpiry:  3600,
+		RefreshTokenExpiry: 86400,
+	}
+
+	for _, id := range []string{"current", "laptop", "phone"} {
+		if err := ssoSessionRepo.Create(ctx, &models.SSOSession{
+			SessionID:     id,
+			UserID:        "user-everywhere",
+			Authenticated: true,
+			CreatedAt:     time.Now(),
+			ExpiresAt:     time.Now().Add(time.Hour),
+			LastActivity:  time.Now(),
+		}); err != nil {
+			t.Fatalf("Failed to create session %s: %v", id, err)
+		}
+	}
+
+	accessToken, err := utils.GenerateAccessToken("user-everywhere", "everywhere@example.com", "Everywhere", "openid", privateKey, 3600)
+	if err != nil {
+		t.Fatalf("Failed to generate access token: %v", err)
+	}
+
+	revoker := NewRevoker(ssoSessionRepo, repository.NewAuthCodeRepository(db), nil, nil, cfg)
+	handler := NewSessionHandler(ssoSessionRepo, repository.NewUserConsentRepository(db), repository.NewClientRepository(db), nil, nil, revoker, NewTemplateRenderer(false, "", ""), nil, nil, cfg)
+
+	req := httptest.NewRequest("DELETE", "/account/sessions?keep_current=true", nil)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.AddCookie(&http.Cookie{Name: SSOCookieName, Value: "current"})
+	rr := httptest.NewRecorder()
+	handler.RevokeAllSessions(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var body map[string]interface{}
+	json.NewDecoder(rr.Body).Decode(&body)
+	if body["revoked"] != float64(2) || body["kept_current"] != true {
+		t.Errorf("Unexpected response: %v", body)
+	}
+
+	sessions, err := ssoSessionRepo.FindByUserID(ctx, "user-everywhere")
+	if err != nil {
+		t.Fatalf("Failed to list sessions: %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].SessionID != "current" {
+		t.Errorf("Expected only the current session to remain, got %d sessions", len(sessions))
+	}
+}
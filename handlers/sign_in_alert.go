@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"oauth2-server/models"
+	"oauth2-server/repository"
+	"oauth2-server/utils"
+	"slices"
+	"strings"
+	"time"
+)
+
+// signInConfirmationExpiry is how long the link confirming an unfamiliar
+// sign-in can be used
+const signInConfirmationExpiry = 30 * time.Minute
+
+// Reasons a sign-in is unfamiliar
+const (
+	unfamiliarDevice  = "new_device"
+	unfamiliarNetwork = "new_network"
+	unfamiliarCountry = "new_country"
+)
+
+// signInOrigin is where a sign-in came from, as compared with the user's
+// earlier sign-ins
+type signInOrigin struct {
+	device  string
+	network string
+	country string
+}
+
+// sessionOrigin returns where the SSO session was started from
+func sessionOrigin(session *models.SSOSession) signInOrigin {
+	origin := signInOrigin{device: session.UserAgent}
+	if browser, os := sessionDevice(session); browser != "" || os != "" {
+		origin.device = browser + "/" + os
+	}
+	ip := session.IPAddress
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	origin.network = utils.IPNetwork(ip)
+	if session.Location != nil {
+		origin.country = session.Location.Country
+	}
+	return origin
+}
+
+// unfamiliar lists what about the origin the profile has not seen before.
+// Countries are only compared once the profile has recorded one, so turning
+// on GeoIP does not flag every user's next sign-in.
+func (o signInOrigin) unfamiliar(profile *models.SignInProfile) []string {
+	var reasons []string
+	if !slices.Contains(profile.Devices, o.device) {
+		reasons = append(reasons, unfamiliarDevice)
+	}
+	if !slices.Contains(profile.Networks, o.network) {
+		reasons = append(reasons, unfamiliarNetwork)
+	}
+	if o.country != "" && len(profile.Countries) > 0 && !slices.Contains(profile.Countries, o.country) {
+		reasons = append(reasons, unfamiliarCountry)
+	}
+	return reasons
+}
+
+// unfamiliarSignIn compares the new session with the user's earlier
+// sign-ins. The first recorded sign-in of a user is never unfamiliar.
+func (h *AuthHandler) unfamiliarSignIn(ctx context.Context, session *models.SSOSession) []string {
+	if h.signInProfiles == nil {
+		return nil
+	}
+	profile, err := h.signInProfiles.FindByUserID(ctx, session.UserID)
+	if err != nil {
+		if !errors.Is(err, repository.ErrNotFound) {
+			log.Printf("Failed to load sign-in profile of %s: %v", session.UserID, err)
+		}
+		return nil
+	}
+	return sessionOrigin(session).unfamiliar(profile)
+}
+
+// rememberSignIn adds the session's origin to the user's sign-in profile
+func (h *AuthHandler) rememberSignIn(ctx context.Context, session *models.SSOSession) {
+	if h.signInProfiles == nil {
+		return
+	}
+	origin := sessionOrigin(session)
+	if err := h.signInProfiles.Remember(ctx, session.UserID, origin.device, origin.network, origin.country); err != nil {
+		log.Printf("Failed to update sign-in profile of %s: %v", session.UserID, err)
+	}
+}
+
+// holdForConfirmation makes the new session wait for the user to follow the
+// link it returns, which continues the authorization request resumeChallenge
+// names
+func (h *AuthHandler) holdForConfirmation(session *models.SSOSession, resumeChallenge string) (string, error) {
+	token, err := utils.GenerateRandomString(32)
+	if err != nil {
+		return "", err
+	}
+	session.Authenticated = false
+	session.ConfirmationHash = secretHash(token)
+	session.ResumeChallenge = resumeChallenge
+	session.ExpiresAt = time.Now().Add(signInConfirmationExpiry)
+	return h.config.PublicURL + "/auth/confirm-sign-in?token=" + url.QueryEscape(token), nil
+}
+
+// notifyUnfamiliarSignIn emails the user about a sign-in from somewhere new.
+// With a confirmation link the session stays unusable until it is followed.
+func (h *AuthHandler) notifyUnfamiliarSignIn(ctx context.Context, user *models.User, session *models.SSOSession, confirmationLink string) error {
+	from := sessionDeviceName(session)
+	if from == "" {
+		from = "an unrecognised device"
+	}
+	var body strings.Builder
+	fmt.Fprintf(&body, "Your account was just signed in to from %s (IP address %s) at %s.\n\n",
+		from, session.IPAddress, session.CreatedAt.UTC().Format(time.RFC1123))
+	if confirmationLink != "" {
+		fmt.Fprintf(&body, "If this was you, confirm the sign-in within %d minutes by opening this link: %s\n\n", int(signInConfirmationExpiry.Minutes()), confirmationLink)
+		body.WriteString("If it was not you, do not open the link and change your password.")
+	} else {
+		body.WriteString("If this was you, you can ignore this email. If it was not, change your password and sign out the session at " + h.config.PublicURL + "/account.")
+	}
+	return h.mailer.Send(ctx, user.Email, "New sign-in to your account", body.String())
+}
+
+// ConfirmSignIn activates a sign-in held for confirmation. Opened in the
+// browser that signed in, it continues the authorization request the user
+// was signing in for.
+// GET /auth/confirm-sign-in?token=...
+func (h *AuthHandler) ConfirmSignIn(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		respondError(w, http.StatusBadRequest, "invalid_request", "Missing token")
+		return
+	}
+
+	ctx := r.Context()
+	ssoSession, err := h.ssoSessionRepo.FindByConfirmationHash(ctx, secretHash(token))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_token", "Invalid or expired confirmation link")
+		return
+	}
+	if err := h.ssoSessionRepo.Confirm(ctx, ssoSession.SessionID, time.Now().Add(7*24*time.Hour)); err != nil {
+		respondInternalError(w, err, "Failed to confirm sign-in")
+		return
+	}
+	h.rememberSignIn(ctx, ssoSession)
+	h.audit.record(r, models.AuditLoginConfirmed, ssoSession.UserID, "", nil)
+
+	cookie, err := r.Cookie(SSOCookieName)
+	if err == nil && cookie.Value == ssoSession.SessionID {
+		if location, ok := h.continueAuthorization(ctx, ssoSession.ResumeChallenge); ok {
+			http.Redirect(w, r, location, http.StatusFound)
+			return
+		}
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{
+		"message": "Sign-in confirmed, you can continue in the browser you signed in from",
+	})
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"context"
+	"oauth2-server/config"
+	"oauth2-server/models"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionOrigin(t *testing.T) {
+	session := &models.SSOSession{
+		IPAddress: "192.0.2.10:51234",
+		UserAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) Chrome/126.0.0.0 Safari/537.36",
+		Location:  &models.GeoLocation{City: "Bangkok", Country: "Thailand"},
+	}
+	want := signInOrigin{device: "Chrome/macOS", network: "192.0.2.0", country: "Thailand"}
+	if got := sessionOrigin(session); got != want {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+
+	// A user agent that names no known browser is compared as is
+	if got := sessionOrigin(&models.SSOSession{UserAgent: "custom-client", IPAddress: "2001:db8:1:2::1"}); got.device != "custom-client" || got.network != "2001:db8:1::" {
+		t.Errorf("Unexpected origin %+v", got)
+	}
+}
+
+func TestSignInOrigin_Unfamiliar(t *testing.T) {
+	profile := &models.SignInProfile{
+		Devices:   []string{"Chrome/macOS"},
+		Networks:  []string{"192.0.2.0"},
+		Countries: []string{"Thailand"},
+	}
+
+	tests := []struct {
+		name   string
+		origin signInOrigin
+		want   []string
+	}{
+		{"known", signInOrigin{"Chrome/macOS", "192.0.2.0", "Thailand"}, nil},
+		{"new device", signInOrigin{"Firefox/Windows", "192.0.2.0", "Thailand"}, []string{unfamiliarDevice}},
+		{"new network and country", signInOrigin{"Chrome/macOS", "198.51.100.0", "Japan"}, []string{unfamiliarNetwork, unfamiliarCountry}},
+		{"unknown country", signInOrigin{"Chrome/macOS", "192.0.2.0", ""}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.origin.unfamiliar(profile); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	// Countries are not compared before GeoIP has recorded one
+	profile.Countries = nil
+	if got := (signInOrigin{"Chrome/macOS", "192.0.2.0", "Japan"}).unfamiliar(profile); got != nil {
+		t.Errorf("Expected no reasons without recorded countries, got %v", got)
+	}
+}
+
+type sentMail struct {
+	to, subject, body string
+}
+
+type recordingMailer struct {
+	sent []sentMail
+}
+
+func (m *recordingMailer) Send(ctx context.Context, to, subject, body string) error {
+	m.sent = append(m.sent, sentMail{to, subject, body})
+	return nil
+}
+
+func TestHoldForConfirmation(t *testing.T) {
+	mail := &recordingMailer{}
+	h := &AuthHandler{mailer: mail, config: &config.Config{PublicURL: "https://auth.example.com"}}
+	session := &models.SSOSession{
+		Authenticated: true,
+		CreatedAt:     time.Now(),
+		ExpiresAt:     time.Now().Add(7 * 24 * time.Hour),
+		IPAddress:     "192.0.2.10:51234",
+		Browser:       "Chrome",
+		OS:            "macOS",
+	}
+
+	link, err := h.holdForConfirmation(session, "pending-request")
+	if err != nil {
+		t.Fatalf("holdForConfirmation failed: %v", err)
+	}
+	token := strings.TrimPrefix(link, "https://auth.example.com/auth/confirm-sign-in?token=")
+	if token == link || session.Authenticated || session.ConfirmationHash != secretHash(token) || session.ResumeChallenge != "pending-request" {
+		t.Fatalf("Expected the session to wait for %s, got %+v", link, session)
+	}
+	if time.Until(session.ExpiresAt) > signInConfirmationExpiry {
+		t.Errorf("Expected the held session to expire with the link, got %v", session.ExpiresAt)
+	}
+
+	user := &models.User{Email: "user@example.com"}
+	if err := h.notifyUnfamiliarSignIn(context.Background(), user, session, link); err != nil {
+		t.Fatalf("notifyUnfamiliarSignIn failed: %v", err)
+	}
+	if len(mail.sent) != 1 || mail.sent[0].to != user.Email || mail.sent[0].subject != "New sign-in to your account" {
+		t.Fatalf("Unexpected emails %+v", mail.sent)
+	}
+	if body := mail.sent[0].body; !strings.Contains(body, "Chrome on macOS") || !strings.Contains(body, link) {
+		t.Errorf("Expected the device and confirmation link in the email, got %q", body)
+	}
+}
@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"net/http"
+	"oauth2-server/middleware"
+)
+
+// SLOHandler reports how each tracked route is doing against its latency and
+// error budget
+type SLOHandler struct {
+	tracker *middleware.SLOTracker
+}
+
+func NewSLOHandler(tracker *middleware.SLOTracker) *SLOHandler {
+	return &SLOHandler{tracker: tracker}
+}
+
+// Summary lists the burn rate and status of every tracked route
+// GET /admin/slo
+func (h *SLOHandler) Summary(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"window_seconds": int64(h.tracker.Window().Seconds()),
+		"routes":         h.tracker.Summary(),
+	})
+}
@@ -9,6 +9,7 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"oauth2-server/config"
+	"oauth2-server/mailer"
 	"oauth2-server/middleware"
 	"oauth2-server/models"
 	"oauth2-server/repository"
@@ -40,7 +41,7 @@ func TestFirstLoginFlow(t *testing.T) {
 	userRepo := repository.NewUserRepository(db)
 	clientRepo := repository.NewClientRepository(db)
 	authCodeRepo := repository.NewAuthCodeRepository(db)
-	sessionRepo := repository.NewSessionRepository(db)
+	authRequestRepo := repository.NewAuthorizationRequestRepository(db)
 	ssoSessionRepo := repository.NewSSOSessionRepository(db)
 	consentRepo := repository.NewUserConsentRepository(db)
 
@@ -82,9 +83,9 @@ func TestFirstLoginFlow(t *testing.T) {
 		t.Fatalf("Failed to create test client: %v", err)
 	}
 
-	oauthHandler := NewOAuthHandler(userRepo, clientRepo, authCodeRepo, sessionRepo, consentRepo, cfg)
-	_ = NewAuthHandler(userRepo, clientRepo, authCodeRepo, sessionRepo, ssoSessionRepo, cfg)
-	consentHandler := NewConsentHandler(clientRepo, consentRepo, authCodeRepo, sessionRepo, cfg)
+	oauthHandler := NewOAuthHandler(userRepo, clientRepo, authCodeRepo, authRequestRepo, consentRepo, ssoSessionRepo, nil, nil, nil, nil, nil, nil, cfg)
+	_ = NewAuthHandler(userRepo, clientRepo, authCodeRepo, authRequestRepo, ssoSessionRepo, repository.NewEmailVerificationRepository(db), nil, nil, mailer.NewLogMailer(), nil, nil, NewTemplateRenderer(false, "", ""), nil, nil, nil, cfg)
+	consentHandler := NewConsentHandler(clientRepo, consentRepo, authCodeRepo, authRequestRepo, NewTemplateRenderer(false, "", ""), nil, cfg)
 
 	// Step 1: User visits authorization endpoint without SSO session
 	req := httptest.NewRequest("GET", "/oauth/authorize?response_type=code&client_id=first-login-client&redirect_uri=http://localhost:3000/callback&scope=openid+profile+email&state=test-state", nil)
@@ -98,22 +99,21 @@ func TestFirstLoginFlow(t *testing.T) {
 	}
 
 	location := w.Header().Get("Location")
-	if !strings.Contains(location, "/auth/login?session_id=") {
+	if !strings.Contains(location, "/auth/login?login_challenge=") {
 		t.Fatalf("Expected redirect to login page, got: %s", location)
 	}
 
-	// Extract session ID
-	sessionID := strings.TrimPrefix(location, "/auth/login?session_id=")
+	// Extract the login challenge
+	challenge := strings.TrimPrefix(location, "/auth/login?login_challenge=")
 
 	// Step 2: User submits login form (simulated)
 	// In real flow, user would see login page and submit credentials
-	// Here we directly call the login handler with the session
-	_, err = sessionRepo.FindBySessionID(ctx, sessionID)
-	if err != nil {
-		t.Fatalf("Failed to find session: %v", err)
+	request, err := authRequestRepo.FindByChallenge(ctx, challenge)
+	if err != nil || request.Stage != models.AuthorizationStageLogin {
+		t.Fatalf("Failed to find the request waiting for login: %v", err)
 	}
 
-	// Simulate successful authentication by creating SSO session and auth code
+	// Simulate successful authentication by creating SSO session
 	ssoSessionID, _ := utils.GenerateRandomString(32)
 	ssoSession := &models.SSOSession{
 		SessionID:     ssoSessionID,
@@ -129,20 +129,24 @@ func TestFirstLoginFlow(t *testing.T) {
 		t.Fatalf("Failed to create SSO session: %v", err)
 	}
 
-	// Step 3: After login, user should be redirected to consent screen
-	// Check if consent exists (it shouldn't for first login)
-	hasConsent, _ := consentRepo.HasConsent(ctx, testUser.ID, testClient.ClientID, []string{"openid", "profile", "email"})
-	if hasConsent {
-		t.Fatal("Expected no consent for first login")
+	// Step 3: After login, the request continues through the authorization
+	// endpoint, which asks for consent since there is none for first login
+	req = httptest.NewRequest("GET", "/oauth/authorize?"+authorizeParams(request).Encode(), nil)
+	req = req.WithContext(context.WithValue(req.Context(), middleware.SSOSessionContextKey, ssoSession))
+	w = httptest.NewRecorder()
+
+	oauthHandler.Authorize(w, req)
+
+	location = w.Header().Get("Location")
+	if !strings.HasPrefix(location, "/oauth/consent?consent_challenge=") {
+		t.Fatalf("Expected redirect to consent screen, got: %s", location)
 	}
+	consentChallenge := strings.TrimPrefix(location, "/oauth/consent?consent_challenge=")
 
 	// Step 4: User sees consent screen and approves
 	form := url.Values{}
 	form.Set("action", "allow")
-	form.Set("client_id", testClient.ClientID)
-	form.Set("scope", "openid profile email")
-	form.Set("state", "test-state")
-	form.Set("redirect_uri", "http://localhost:3000/callback")
+	form.Set("consent_challenge", consentChallenge)
 
 	req = httptest.NewRequest("POST", "/oauth/consent", strings.NewReader(form.Encode()))
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
@@ -194,7 +198,7 @@ func TestSecondAppWithSSO(t *testing.T) {
 	userRepo := repository.NewUserRepository(db)
 	clientRepo := repository.NewClientRepository(db)
 	authCodeRepo := repository.NewAuthCodeRepository(db)
-	sessionRepo := repository.NewSessionRepository(db)
+	authRequestRepo := repository.NewAuthorizationRequestRepository(db)
 	ssoSessionRepo := repository.NewSSOSessionRepository(db)
 	consentRepo := repository.NewUserConsentRepository(db)
 
@@ -263,7 +267,7 @@ func TestSecondAppWithSSO(t *testing.T) {
 		t.Fatalf("Failed to create consent: %v", err)
 	}
 
-	oauthHandler := NewOAuthHandler(userRepo, clientRepo, authCodeRepo, sessionRepo, consentRepo, cfg)
+	oauthHandler := NewOAuthHandler(userRepo, clientRepo, authCodeRepo, authRequestRepo, consentRepo, ssoSessionRepo, nil, nil, nil, nil, nil, nil, cfg)
 
 	// User visits authorization endpoint with SSO session
 	req := httptest.NewRequest("GET", "/oauth/authorize?response_type=code&client_id=second-app-client&redirect_uri=http://localhost:3001/callback&scope=openid+profile+email&state=second-state", nil)
@@ -312,7 +316,7 @@ func TestLogoutFlow(t *testing.T) {
 	userRepo := repository.NewUserRepository(db)
 	clientRepo := repository.NewClientRepository(db)
 	authCodeRepo := repository.NewAuthCodeRepository(db)
-	sessionRepo := repository.NewSessionRepository(db)
+	authRequestRepo := repository.NewAuthorizationRequestRepository(db)
 	ssoSessionRepo := repository.NewSSOSessionRepository(db)
 	consentRepo := repository.NewUserConsentRepository(db)
 
@@ -370,8 +374,8 @@ func TestLogoutFlow(t *testing.T) {
 		t.Fatalf("Failed to create SSO session: %v", err)
 	}
 
-	authHandler := NewAuthHandler(userRepo, clientRepo, authCodeRepo, sessionRepo, ssoSessionRepo, cfg)
-	oauthHandler := NewOAuthHandler(userRepo, clientRepo, authCodeRepo, sessionRepo, consentRepo, cfg)
+	authHandler := NewAuthHandler(userRepo, clientRepo, authCodeRepo, authRequestRepo, ssoSessionRepo, repository.NewEmailVerificationRepository(db), nil, nil, mailer.NewLogMailer(), nil, nil, NewTemplateRenderer(false, "", ""), nil, nil, nil, cfg)
+	oauthHandler := NewOAuthHandler(userRepo, clientRepo, authCodeRepo, authRequestRepo, consentRepo, ssoSessionRepo, nil, nil, nil, nil, nil, nil, cfg)
 
 	// Step 1: Verify SSO session exists
 	foundSession, err := ssoSessionRepo.FindBySessionID(ctx, ssoSessionID)
@@ -453,7 +457,7 @@ func TestExpiredSessionFlow(t *testing.T) {
 	userRepo := repository.NewUserRepository(db)
 	clientRepo := repository.NewClientRepository(db)
 	authCodeRepo := repository.NewAuthCodeRepository(db)
-	sessionRepo := repository.NewSessionRepository(db)
+	authRequestRepo := repository.NewAuthorizationRequestRepository(db)
 	ssoSessionRepo := repository.NewSSOSessionRepository(db)
 	consentRepo := repository.NewUserConsentRepository(db)
 
@@ -511,8 +515,8 @@ func TestExpiredSessionFlow(t *testing.T) {
 	}
 
 	// Setup SSO middleware
-	ssoMiddleware := middleware.SSOMiddleware(ssoSessionRepo)
-	oauthHandler := NewOAuthHandler(userRepo, clientRepo, authCodeRepo, sessionRepo, consentRepo, cfg)
+	ssoMiddleware := middleware.SSOMiddleware(ssoSessionRepo, middleware.NewSessionActivity(0, 0))
+	oauthHandler := NewOAuthHandler(userRepo, clientRepo, authCodeRepo, authRequestRepo, consentRepo, ssoSessionRepo, nil, nil, nil, nil, nil, nil, cfg)
 
 	// Create request with expired SSO cookie
 	req := httptest.NewRequest("GET", "/oauth/authorize?response_type=code&client_id=expired-client&redirect_uri=http://localhost:3003/callback&scope=openid+profile&state=expired-state", nil)
@@ -557,7 +561,7 @@ func TestConsentRevocationFlow(t *testing.T) {
 	userRepo := repository.NewUserRepository(db)
 	clientRepo := repository.NewClientRepository(db)
 	authCodeRepo := repository.NewAuthCodeRepository(db)
-	sessionRepo := repository.NewSessionRepository(db)
+	authRequestRepo := repository.NewAuthorizationRequestRepository(db)
 	ssoSessionRepo := repository.NewSSOSessionRepository(db)
 	consentRepo := repository.NewUserConsentRepository(db)
 
@@ -626,8 +630,8 @@ func TestConsentRevocationFlow(t *testing.T) {
 		t.Fatalf("Failed to create consent: %v", err)
 	}
 
-	oauthHandler := NewOAuthHandler(userRepo, clientRepo, authCodeRepo, sessionRepo, consentRepo, cfg)
-	sessionHandler := NewSessionHandler(ssoSessionRepo, consentRepo, clientRepo, cfg)
+	oauthHandler := NewOAuthHandler(userRepo, clientRepo, authCodeRepo, authRequestRepo, consentRepo, ssoSessionRepo, nil, nil, nil, nil, nil, nil, cfg)
+	sessionHandler := NewSessionHandler(ssoSessionRepo, consentRepo, clientRepo, nil, nil, nil, NewTemplateRenderer(false, "", ""), nil, nil, cfg)
 
 	// Step 1: Verify auto-approval works with consent
 	req := httptest.NewRequest("GET", "/oauth/authorize?response_type=code&client_id=revoke-client&redirect_uri=http://localhost:3004/callback&scope=openid+profile+email&state=before-revoke", nil)
@@ -715,7 +719,7 @@ func TestPromptParameterLogin(t *testing.T) {
 	userRepo := repository.NewUserRepository(db)
 	clientRepo := repository.NewClientRepository(db)
 	authCodeRepo := repository.NewAuthCodeRepository(db)
-	sessionRepo := repository.NewSessionRepository(db)
+	authRequestRepo := repository.NewAuthorizationRequestRepository(db)
 	ssoSessionRepo := repository.NewSSOSessionRepository(db)
 	consentRepo := repository.NewUserConsentRepository(db)
 
@@ -784,7 +788,7 @@ func TestPromptParameterLogin(t *testing.T) {
 		t.Fatalf("Failed to create consent: %v", err)
 	}
 
-	oauthHandler := NewOAuthHandler(userRepo, clientRepo, authCodeRepo, sessionRepo, consentRepo, cfg)
+	oauthHandler := NewOAuthHandler(userRepo, clientRepo, authCodeRepo, authRequestRepo, consentRepo, ssoSessionRepo, nil, nil, nil, nil, nil, nil, cfg)
 
 	// Request with prompt=login should force re-authentication even with valid SSO
 	req := httptest.NewRequest("GET", "/oauth/authorize?response_type=code&client_id=prompt-login-client&redirect_uri=http://localhost:3005/callback&scope=openid+profile&state=login-state&prompt=login", nil)
@@ -824,7 +828,7 @@ func TestPromptParameterConsent(t *testing.T) {
 	userRepo := repository.NewUserRepository(db)
 	clientRepo := repository.NewClientRepository(db)
 	authCodeRepo := repository.NewAuthCodeRepository(db)
-	sessionRepo := repository.NewSessionRepository(db)
+	authRequestRepo := repository.NewAuthorizationRequestRepository(db)
 	ssoSessionRepo := repository.NewSSOSessionRepository(db)
 	consentRepo := repository.NewUserConsentRepository(db)
 
@@ -893,7 +897,7 @@ func TestPromptParameterConsent(t *testing.T) {
 		t.Fatalf("Failed to create consent: %v", err)
 	}
 
-	oauthHandler := NewOAuthHandler(userRepo, clientRepo, authCodeRepo, sessionRepo, consentRepo, cfg)
+	oauthHandler := NewOAuthHandler(userRepo, clientRepo, authCodeRepo, authRequestRepo, consentRepo, ssoSessionRepo, nil, nil, nil, nil, nil, nil, cfg)
 
 	// Request with prompt=consent should force consent screen even with existing consent
 	req := httptest.NewRequest("GET", "/oauth/authorize?response_type=code&client_id=prompt-consent-client&redirect_uri=http://localhost:3006/callback&scope=openid+profile+email&state=consent-state&prompt=consent", nil)
@@ -933,7 +937,7 @@ func TestPromptParameterNone(t *testing.T) {
 	userRepo := repository.NewUserRepository(db)
 	clientRepo := repository.NewClientRepository(db)
 	authCodeRepo := repository.NewAuthCodeRepository(db)
-	sessionRepo := repository.NewSessionRepository(db)
+	authRequestRepo := repository.NewAuthorizationRequestRepository(db)
 	ssoSessionRepo := repository.NewSSOSessionRepository(db)
 	consentRepo := repository.NewUserConsentRepository(db)
 
@@ -975,7 +979,7 @@ func TestPromptParameterNone(t *testing.T) {
 		t.Fatalf("Failed to create test client: %v", err)
 	}
 
-	oauthHandler := NewOAuthHandler(userRepo, clientRepo, authCodeRepo, sessionRepo, consentRepo, cfg)
+	oauthHandler := NewOAuthHandler(userRepo, clientRepo, authCodeRepo, authRequestRepo, consentRepo, ssoSessionRepo, nil, nil, nil, nil, nil, nil, cfg)
 
 	// Test 1: prompt=none without SSO session returns login_required
 	t.Run("without SSO returns login_required", func(t *testing.T) {
@@ -1083,3 +1087,189 @@ func TestPromptParameterNone(t *testing.T) {
 
 	t.Log("prompt=none flow completed successfully")
 }
+
+// TestBFFRelayFlow tests relaying an SSO session into a downstream API token
+func TestBFFRelayFlow(t *testing.T) {
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI("mongodb://localhost:27017"))
+	if err != nil {
+		t.Skip("MongoDB not available, skipping integration test")
+		return
+	}
+	defer client.Disconnect(ctx)
+
+	db := client.Database("oauth2_test_bff_relay")
+	defer db.Drop(ctx)
+
+	userRepo := repository.NewUserRepository(db)
+	clientRepo := repository.NewClientRepository(db)
+	ssoSessionRepo := repository.NewSSOSessionRepository(db)
+	consentRepo := repository.NewUserConsentRepository(db)
+
+	privateKey, publicKey, err := utils.LoadTestKeys()
+	if err != nil {
+		t.Fatalf("Failed to load test keys: %v", err)
+	}
+
+	cfg := &config.Config{
+		PrivateKey:     privateKey,
+		PublicKey:      publicKey,
+		BFFTokenExpiry: 300,
+	}
+
+	if err := userRepo.Create(ctx, &models.User{
+		ID:        "bff-user",
+		Email:     "bff@example.com",
+		Name:      "BFF User",
+		Password:  "hashed_password",
+		CreatedAt: time.Now(),
+	}); err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+
+	if err := clientRepo.Create(ctx, &models.Client{
+		ClientID:         "bff-client",
+		ClientSecret:     "bff-secret",
+		Name:             "BFF",
+		RedirectURIs:     []string{"http://localhost:3000/callback"},
+		AllowedScopes:    []string{"openid", "profile", "email"},
+		GrantTypes:       []string{"authorization_code", BFFRelayGrantType},
+		AllowedResources: []string{"https://api.example.com"},
+		CreatedAt:        time.Now(),
+	}); err != nil {
+		t.Fatalf("Failed to create test client: %v", err)
+	}
+
+	ssoSession := &models.SSOSession{
+		SessionID:     "bff-sso-session",
+		UserID:        "bff-user",
+		Authenticated: true,
+		CreatedAt:     time.Now(),
+		ExpiresAt:     time.Now().Add(time.Hour),
+		LastActivity:  time.Now(),
+	}
+	if err := ssoSessionRepo.Create(ctx, ssoSession); err != nil {
+		t.Fatalf("Failed to create SSO session: %v", err)
+	}
+
+	handler := NewBFFHandler(userRepo, clientRepo, ssoSessionRepo, consentRepo, nil, nil, nil, nil, nil, cfg)
+	relay := func(params url.Values) *httptest.ResponseRecorder {
+		form := url.Values{
+			"client_id":     {"bff-client"},
+			"client_secret": {"bff-secret"},
+			"audience":      {"https://api.example.com"},
+			"scope":         {"openid profile"},
+		}
+		for key, values := range params {
+			form[key] = values
+		}
+		req := httptest.NewRequest("POST", "/bff/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: middleware.SSOCookieName, Value: ssoSession.SessionID})
+		w := httptest.NewRecorder()
+		handler.RelayToken(w, req)
+		return w
+	}
+
+	t.Run("requires consent", func(t *testing.T) {
+		w := relay(nil)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "invalid_grant") {
+			t.Fatalf("Expected 400 invalid_grant without consent, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	if err := consentRepo.Save(ctx, &models.UserConsent{
+		UserID:   "bff-user",
+		ClientID: "bff-client",
+		Scopes:   []string{"openid", "profile"},
+	}, ""); err != nil {
+		t.Fatalf("Failed to save consent: %v", err)
+	}
+
+	t.Run("issues an audience-restricted token", func(t *testing.T) {
+		w := relay(nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		if strings.Contains(w.Body.String(), "refresh_token") {
+			t.Error("Expected no refresh token for a relayed token")
+		}
+	})
+
+	t.Run("rejects an unregistered audience", func(t *testing.T) {
+		w := relay(url.Values{"audience": {"https://other.example.com"}})
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "invalid_target") {
+			t.Fatalf("Expected 400 invalid_target, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("rejects an ended session", func(t *testing.T) {
+		if err := ssoSessionRepo.Delete(ctx, ssoSession.SessionID); err != nil {
+			t.Fatalf("Failed to delete SSO session: %v", err)
+		}
+		w := relay(nil)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "invalid_grant") {
+			t.Fatalf("Expected 400 invalid_grant, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+}
+
+// TestDirectLogin_NoRefreshToken tests that a login without a login_challenge
+// returns an access token but no refresh token, since no client asked for it
+func TestDirectLogin_NoRefreshToken(t *testing.T) {
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI("mongodb://localhost:27017"))
+	if err != nil || client.Ping(ctx, nil) != nil {
+		t.Skip("MongoDB not available, skipping integration test")
+		return
+	}
+	defer client.Disconnect(ctx)
+
+	db := client.Database("oauth2_test_direct_login")
+	defer db.Drop(ctx)
+
+	userRepo := repository.NewUserRepository(db)
+	ssoSessionRepo := repository.NewSSOSessionRepository(db)
+
+	privateKey, publicKey, err := utils.LoadTestKeys()
+	if err != nil {
+		t.Fatalf("Failed to load test keys: %v", err)
+	}
+
+	cfg := &config.Config{
+		PrivateKey:         privateKey,
+		PublicKey:          publicKey,
+		AccessTokenExpiry:  3600,
+		RefreshTokenExpiry: 86400,
+	}
+
+	hashed, err := utils.HashPassword("password123")
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	if err := userRepo.Create(ctx, &models.User{
+		ID:        "direct-login-user",
+		Email:     "direct@example.com",
+		Name:      "Direct Login User",
+		Password:  hashed,
+		CreatedAt: time.Now(),
+	}); err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+
+	handler := NewAuthHandler(userRepo, nil, nil, nil, ssoSessionRepo, nil, nil, nil, nil, nil, nil, NewTemplateRenderer(false, "", ""), nil, nil, nil, cfg)
+	req := httptest.NewRequest("POST", "/auth/login", strings.NewReader(`{"email":"direct@example.com","password":"password123"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	handler.Login(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), "access_token") {
+		t.Error("Expected an access token")
+	}
+	if strings.Contains(w.Body.String(), "refresh_token") {
+		t.Error("Expected no refresh token without a client to bind it to")
+	}
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"oauth2-server/config"
+	"oauth2-server/jwks"
+	"oauth2-server/models"
+	"oauth2-server/repository"
+	"oauth2-server/utils"
+	"time"
+)
+
+// StateHandler issues and verifies HMAC-signed OAuth state values so relying
+// parties get CSRF protection on their callback without rolling their own
+type StateHandler struct {
+	clientRepo *repository.ClientRepository
+	stateRepo  *repository.StateRepository
+	secret     []byte
+	clientKeys *jwks.Cache
+	config     *config.Config
+}
+
+func NewStateHandler(
+	clientRepo *repository.ClientRepository,
+	stateRepo *repository.StateRepository,
+	secret []byte,
+	clientKeys *jwks.Cache,
+	cfg *config.Config,
+) *StateHandler {
+	return &StateHandler{
+		clientRepo: clientRepo,
+		stateRepo:  stateRepo,
+		secret:     secret,
+		clientKeys: clientKeys,
+		config:     cfg,
+	}
+}
+
+// StateIssueResponse is returned by the state issuing endpoint
+type StateIssueResponse struct {
+	State     string `json:"state"`
+	ExpiresIn int64  `json:"expires_in"`
+}
+
+// StateVerifyResponse is returned by the state verification endpoint
+type StateVerifyResponse struct {
+	Valid    bool   `json:"valid"`
+	ClientID string `json:"client_id"`
+	Data     string `json:"data,omitempty"`
+	IssuedAt int64  `json:"issued_at"`
+}
+
+// IssueState creates a signed state value for the authenticated client
+// POST /state/issue
+func (h *StateHandler) IssueState(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_request", "Failed to parse form")
+		return
+	}
+
+	client, ok := h.authenticateClient(w, r)
+	if !ok {
+		return
+	}
+
+	state, _, err := utils.GenerateSignedState(h.secret, client.ClientID, r.FormValue("data"), h.ttl())
+	if err != nil {
+		respondInternalError(w, err, "Failed to generate state")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, StateIssueResponse{
+		State:     state,
+		ExpiresIn: h.config.StateTTL,
+	})
+}
+
+// VerifyState validates a state value returned on the client's callback.
+// Each state can be verified only once; a second attempt is reported as reuse.
+// POST /state/verify
+func (h *StateHandler) VerifyState(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_request", "Failed to parse form")
+		return
+	}
+
+	client, ok := h.authenticateClient(w, r)
+	if !ok {
+		return
+	}
+
+	state := r.FormValue("state")
+	if state == "" {
+		respondError(w, http.StatusBadRequest, "invalid_request", "Missing state parameter")
+		return
+	}
+
+	payload, err := utils.VerifySignedState(h.secret, state, client.ClientID)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_state", err.Error())
+		return
+	}
+
+	ctx := r.Context()
+	if err := h.stateRepo.MarkUsed(ctx, payload.ID, client.ClientID, time.Unix(payload.Exp, 0)); err != nil {
+		if errors.Is(err, repository.ErrStateReused) {
+			respondError(w, http.StatusBadRequest, "invalid_state", "State has already been used")
+			return
+		}
+		respondInternalError(w, err, "Failed to record state")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, StateVerifyResponse{
+		Valid:    true,
+		ClientID: payload.ClientID,
+		Data:     payload.Data,
+		IssuedAt: payload.Iat,
+	})
+}
+
+// authenticateClient checks client_id and, for confidential clients, their
+// credentials as at the token endpoint. Public clients are identified by
+// client_id only.
+func (h *StateHandler) authenticateClient(w http.ResponseWriter, r *http.Request) (*models.Client, bool) {
+	clientID, clientSecret := clientCredentials(r)
+	if clientID == "" {
+		respondError(w, http.StatusBadRequest, "invalid_request", "Missing client_id")
+		return nil, false
+	}
+
+	client, err := h.clientRepo.FindByClientID(r.Context(), clientID)
+	if err != nil || ((client.ClientSecret != "" || client.UsesCertificateAuth()) && !authenticateClient(r, h.clientKeys, client, clientSecret, h.config)) {
+		respondError(w, http.StatusUnauthorized, "invalid_client", "Invalid client credentials")
+		return nil, false
+	}
+
+	if client.Disabled {
+		respondClientDisabled(w, http.StatusUnauthorized)
+		return nil, false
+	}
+
+	return client, true
+}
+
+func (h *StateHandler) ttl() time.Duration {
+	return time.Duration(h.config.StateTTL) * time.Second
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"oauth2-server/middleware"
+	"oauth2-server/templates"
+	"oauth2-server/utils"
+)
+
+// TemplateRenderer renders HTML templates. In production it serves the
+// embedded set parsed once at startup, with any page found in the override
+// directory used in place of the embedded one; in development mode it
+// re-parses the files from disk on every request and shows template errors
+// in the page.
+type TemplateRenderer struct {
+	dev         bool
+	dir         string
+	overrideDir string
+
+	mu     sync.RWMutex
+	parsed map[string]*template.Template
+}
+
+// NewTemplateRenderer creates a renderer. dir is only used in development
+// mode and defaults to "templates"; overrideDir is only used outside it.
+func NewTemplateRenderer(dev bool, dir, overrideDir string) *TemplateRenderer {
+	if dir == "" {
+		dir = "templates"
+	}
+	return &TemplateRenderer{
+		dev:         dev,
+		dir:         dir,
+		overrideDir: overrideDir,
+		parsed:      make(map[string]*template.Template),
+	}
+}
+
+// templateFuncs are the functions available to templates. "t" translates
+// page text into the request locale and "locale" returns the locale itself.
+func templateFuncs(locale string) template.FuncMap {
+	return template.FuncMap{
+		"t": func(message string) string {
+			return utils.GlobalMessageCatalog.Translate(locale, message)
+		},
+		"locale": func() string { return locale },
+	}
+}
+
+// Render executes the named template (e.g. "login.html") into w, translated
+// into the locale negotiated for the request
+func (t *TemplateRenderer) Render(w http.ResponseWriter, name string, data interface{}) {
+	tmpl, err := t.lookup(name)
+	if err != nil {
+		t.renderError(w, name, err)
+		return
+	}
+
+	// The cached template is never executed, so each request can clone it
+	// with its own translation functions
+	tmpl, err = tmpl.Clone()
+	if err != nil {
+		t.renderError(w, name, err)
+		return
+	}
+	tmpl.Funcs(templateFuncs(middleware.LocaleOf(w)))
+
+	// Execute into a buffer so a failing template doesn't send half a page
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		t.renderError(w, name, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+func (t *TemplateRenderer) lookup(name string) (*template.Template, error) {
+	if t.dev {
+		return template.New(name).Funcs(templateFuncs(utils.DefaultLocale)).ParseFiles(filepath.Join(t.dir, name))
+	}
+
+	t.mu.RLock()
+	tmpl, exists := t.parsed[name]
+	t.mu.RUnlock()
+	if exists {
+		return tmpl, nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if tmpl, exists := t.parsed[name]; exists {
+		return tmpl, nil
+	}
+
+	tmpl, err := t.parse(name)
+	if err != nil {
+		return nil, err
+	}
+	t.parsed[name] = tmpl
+	return tmpl, nil
+}
+
+// parse reads the named template from the override directory when it has
+// one by that name, and from the embedded set otherwise
+func (t *TemplateRenderer) parse(name string) (*template.Template, error) {
+	tmpl := template.New(name).Funcs(templateFuncs(utils.DefaultLocale))
+	if t.overrideDir != "" {
+		path := filepath.Join(t.overrideDir, name)
+		if _, err := os.Stat(path); err == nil {
+			return tmpl.ParseFiles(path)
+		}
+	}
+	return tmpl.ParseFS(templates.FS, name)
+}
+
+// Preload parses every embedded template, or its override, so syntax errors
+// surface at startup
+func (t *TemplateRenderer) Preload() error {
+	if t.dev {
+		return nil
+	}
+
+	names, err := fs.Glob(templates.FS, "*.html")
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if _, err := t.lookup(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *TemplateRenderer) renderError(w http.ResponseWriter, name string, err error) {
+	if !t.dev {
+		respondInternalError(w, fmt.Errorf("template %s: %w", name, err), "Template error")
+		return
+	}
+	log.Printf("Template %s error: %v", name, err)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusInternalServerError)
+	w.Write([]byte("<h1>Template error: " + template.HTMLEscapeString(name) + "</h1><pre>" +
+		template.HTMLEscapeString(err.Error()) + "</pre>"))
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"oauth2-server/middleware"
+	"oauth2-server/utils"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTemplateRenderer_Embedded(t *testing.T) {
+	renderer := NewTemplateRenderer(false, "", "")
+	if err := renderer.Preload(); err != nil {
+		t.Fatalf("Failed to preload embedded templates: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	renderer.Render(w, "login.html", map[string]interface{}{"LoginChallenge": "challenge-123"})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "challenge-123") {
+		t.Error("Expected rendered page to contain the login challenge")
+	}
+}
+
+func TestTemplateRenderer_TranslatesIntoRequestLocale(t *testing.T) {
+	renderer := NewTemplateRenderer(false, "", "")
+	handler := middleware.LocaleMiddleware(utils.GlobalMessageCatalog)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		renderer.Render(w, "consent.html", map[string]interface{}{"ClientName": "Demo App"})
+	}))
+
+	for _, tc := range []struct{ uiLocales, want string }{
+		{"th", "ปฏิเสธ"},
+		{"en", "Deny"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/oauth/consent?ui_locales="+tc.uiLocales, nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		body := w.Body.String()
+		if !strings.Contains(body, tc.want) {
+			t.Errorf("ui_locales=%s: expected page to contain %q", tc.uiLocales, tc.want)
+		}
+		if !strings.Contains(body, `lang="`+tc.uiLocales+`"`) {
+			t.Errorf("ui_locales=%s: expected html lang attribute", tc.uiLocales)
+		}
+	}
+}
+
+func TestTemplateRenderer_DevModeReloadsAndShowsErrors(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "page.html")
+	renderer := NewTemplateRenderer(true, dir, "")
+
+	os.WriteFile(path, []byte("first {{.}}"), 0644)
+	w := httptest.NewRecorder()
+	renderer.Render(w, "page.html", "render")
+	if w.Body.String() != "first render" {
+		t.Errorf("Expected 'first render', got %q", w.Body.String())
+	}
+
+	// Edits are picked up without restarting
+	os.WriteFile(path, []byte("second {{.}}"), 0644)
+	w = httptest.NewRecorder()
+	renderer.Render(w, "page.html", "render")
+	if w.Body.String() != "second render" {
+		t.Errorf("Expected 'second render', got %q", w.Body.String())
+	}
+
+	// Broken templates report the parse error in development mode
+	os.WriteFile(path, []byte("{{.Broken"), 0644)
+	w = httptest.NewRecorder()
+	renderer.Render(w, "page.html", nil)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status 500, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "page.html") {
+		t.Errorf("Expected verbose template error, got %q", w.Body.String())
+	}
+}
+
+func TestTemplateRenderer_Overrides(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "login.html"), []byte(`<p>{{t "Sign in"}} at Acme, {{.LoginChallenge}}</p>`), 0644)
+	renderer := NewTemplateRenderer(false, "", dir)
+	if err := renderer.Preload(); err != nil {
+		t.Fatalf("Failed to preload templates: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	renderer.Render(w, "login.html", map[string]interface{}{"LoginChallenge": "challenge-123"})
+	if w.Body.String() != "<p>Sign in at Acme, challenge-123</p>" {
+		t.Errorf("Expected the override page, got %q", w.Body.String())
+	}
+
+	// Pages without an override still come from the embedded set
+	w = httptest.NewRecorder()
+	renderer.Render(w, "register.html", map[string]interface{}{"LoginChallenge": "challenge-123"})
+	if !strings.Contains(w.Body.String(), "registerForm") {
+		t.Error("Expected the embedded registration page")
+	}
+}
+
+func TestTemplateRenderer_ClientBranding(t *testing.T) {
+	renderer := NewTemplateRenderer(false, "", "")
+	for _, name := range []string{"login.html", "register.html", "consent.html"} {
+		w := httptest.NewRecorder()
+		renderer.Render(w, name, map[string]interface{}{
+			"ClientName": "Acme",
+			"LogoURI":    "https://acme.example.com/logo.png",
+			"BrandColor": "#ff6600",
+		})
+
+		body := w.Body.String()
+		if !strings.Contains(body, `src="https://acme.example.com/logo.png"`) {
+			t.Errorf("%s: expected the client logo", name)
+		}
+		if !strings.Contains(body, "background: #ff6600") {
+			t.Errorf("%s: expected the brand color", name)
+		}
+	}
+}
@@ -2,8 +2,12 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"oauth2-server/config"
+	"oauth2-server/jwks"
+	"oauth2-server/models"
+	"oauth2-server/policy"
 	"oauth2-server/repository"
 	"oauth2-server/utils"
 	"time"
@@ -17,32 +21,52 @@ const (
 )
 
 type TokenExchangeHandler struct {
-	userRepo   *repository.UserRepository
-	clientRepo *repository.ClientRepository
-	config     *config.Config
+	userRepo    *repository.UserRepository
+	clientRepo  *repository.ClientRepository
+	audit       *Auditor
+	tokens      *TokenStore
+	dpopNonces  *utils.NonceSource
+	tokenPolicy policy.Policy
+	clientKeys  *jwks.Cache
+	config      *config.Config
 }
 
 func NewTokenExchangeHandler(
 	userRepo *repository.UserRepository,
 	clientRepo *repository.ClientRepository,
+	audit *Auditor,
+	tokens *TokenStore,
+	dpopNonces *utils.NonceSource,
+	tokenPolicy policy.Policy,
+	clientKeys *jwks.Cache,
 	cfg *config.Config,
 ) *TokenExchangeHandler {
 	return &TokenExchangeHandler{
-		userRepo:   userRepo,
-		clientRepo: clientRepo,
-		config:     cfg,
+		userRepo:    userRepo,
+		clientRepo:  clientRepo,
+		audit:       audit,
+		tokens:      tokens,
+		dpopNonces:  dpopNonces,
+		tokenPolicy: tokenPolicy,
+		clientKeys:  clientKeys,
+		config:      cfg,
 	}
 }
 
 type TokenExchangeRequest struct {
-	GrantType          string `json:"grant_type"`
-	SubjectToken       string `json:"subject_token"`
-	SubjectTokenType   string `json:"subject_token_type"`
-	RequestedTokenType string `json:"requested_token_type,omitempty"`
-	Scope              string `json:"scope,omitempty"`
-	ClientID           string `json:"client_id"`
-	ClientSecret       string `json:"client_secret"`
-	IsEncryptedJWE     bool   `json:"is_encrypted_jwe,omitempty"`
+	GrantType          string   `json:"grant_type"`
+	SubjectToken       string   `json:"subject_token"`
+	SubjectTokenType   string   `json:"subject_token_type"`
+	ActorToken         string   `json:"actor_token,omitempty"`
+	ActorTokenType     string   `json:"actor_token_type,omitempty"`
+	RequestedTokenType string   `json:"requested_token_type,omitempty"`
+	Audience           []string `json:"audience,omitempty"`
+	Resource           []string `json:"resource,omitempty"`
+	Scope              string   `json:"scope,omitempty"`
+	Claims             string   `json:"claims,omitempty"` // OIDC claims request narrowing the ID token
+	ClientID           string   `json:"client_id"`
+	ClientSecret       string   `json:"client_secret"`
+	IsEncryptedJWE     bool     `json:"is_encrypted_jwe,omitempty"`
 }
 
 type TokenExchangeResponse struct {
@@ -55,6 +79,16 @@ type TokenExchangeResponse struct {
 	Scope           string `json:"scope,omitempty"`
 }
 
+// exchangeToken is the identity carried by a subject or actor token
+type exchangeToken struct {
+	UserID   string
+	Email    string
+	Name     string
+	Scope    string
+	ClientID string
+	Act      *utils.ActorClaim
+}
+
 func (h *TokenExchangeHandler) HandleTokenExchange(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
 		respondError(w, http.StatusBadRequest, "invalid_request", "Failed to parse form")
@@ -65,12 +99,16 @@ func (h *TokenExchangeHandler) HandleTokenExchange(w http.ResponseWriter, r *htt
 		GrantType:          r.FormValue("grant_type"),
 		SubjectToken:       r.FormValue("subject_token"),
 		SubjectTokenType:   r.FormValue("subject_token_type"),
+		ActorToken:         r.FormValue("actor_token"),
+		ActorTokenType:     r.FormValue("actor_token_type"),
 		RequestedTokenType: r.FormValue("requested_token_type"),
+		Audience:           r.Form["audience"],
+		Resource:           r.Form["resource"],
 		Scope:              r.FormValue("scope"),
-		ClientID:           r.FormValue("client_id"),
-		ClientSecret:       r.FormValue("client_secret"),
+		Claims:             r.FormValue("claims"),
 		IsEncryptedJWE:     r.FormValue("is_encrypted_jwe") == "true",
 	}
+	req.ClientID, req.ClientSecret = clientCredentials(r)
 
 	if req.GrantType != TokenExchangeGrantType {
 		respondError(w, http.StatusBadRequest, "unsupported_grant_type", "Grant type not supported")
@@ -82,54 +120,120 @@ func (h *TokenExchangeHandler) HandleTokenExchange(w http.ResponseWriter, r *htt
 		return
 	}
 
-	ctx := context.Background()
+	// actor_token and actor_token_type must be sent together
+	if (req.ActorToken == "") != (req.ActorTokenType == "") {
+		respondError(w, http.StatusBadRequest, "invalid_request", "actor_token and actor_token_type must be provided together")
+		return
+	}
+	if req.ActorToken != "" && req.ActorTokenType != AccessTokenType {
+		respondError(w, http.StatusBadRequest, "invalid_request", "Unsupported actor_token_type")
+		return
+	}
+
+	if req.RequestedTokenType == "" {
+		req.RequestedTokenType = AccessTokenType
+	}
+	if req.RequestedTokenType != AccessTokenType && req.RequestedTokenType != RefreshTokenType && req.RequestedTokenType != IDTokenType {
+		respondError(w, http.StatusBadRequest, "invalid_request", "Unsupported requested_token_type")
+		return
+	}
+
+	requestedClaims, err := utils.ParseIDTokenClaimsRequest(req.Claims)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	ctx := r.Context()
 	client, err := h.clientRepo.FindByClientID(ctx, req.ClientID)
-	if err != nil || client.ClientSecret != req.ClientSecret {
+	if err != nil {
+		respondError(w, http.StatusUnauthorized, "invalid_client", "Invalid client credentials")
+		return
+	}
+	if (client.ClientSecret != "" || client.UsesCertificateAuth()) && !authenticateClient(r, h.clientKeys, client, req.ClientSecret, h.config) {
 		respondError(w, http.StatusUnauthorized, "invalid_client", "Invalid client credentials")
 		return
 	}
 
-	var userID, email, name, scope string
-	if utils.IsJWE(req.SubjectToken) {
-		claims, err := utils.ValidateJWE(req.SubjectToken, h.config.PrivateKey)
-		if err != nil {
-			respondError(w, http.StatusBadRequest, "invalid_grant", "Invalid subject token")
-			return
-		}
-		userID = claims.UserID
-		email = claims.Email
-		name = claims.Name
-		scope = claims.Scope
-	} else if utils.IsJWT(req.SubjectToken) {
-		claims, err := utils.ValidateToken(req.SubjectToken, h.config.PublicKey)
+	if client.Disabled {
+		respondClientDisabled(w, http.StatusUnauthorized)
+		return
+	}
+
+	thumbprint, ok := certificateBinding(r, client, h.config)
+	if !ok {
+		respondError(w, http.StatusBadRequest, "invalid_request", "A client certificate is required for certificate-bound tokens")
+		return
+	}
+	jkt, ok := dpopBinding(w, r, h.dpopNonces, client, h.config)
+	if !ok {
+		return
+	}
+	cnf := utils.NewConfirmationClaim(thumbprint, jkt)
+
+	// Only clients registered with a token exchange policy may exchange
+	// tokens, and only delegation clients may present an actor token
+	if !models.IsValidTokenExchangePolicy(client.TokenExchange) {
+		respondError(w, http.StatusBadRequest, "unauthorized_client", "Client is not allowed to use token exchange")
+		return
+	}
+	if req.ActorToken != "" && client.TokenExchange != models.TokenExchangeDelegation {
+		respondError(w, http.StatusBadRequest, "unauthorized_client", "Client is not allowed to use delegation")
+		return
+	}
+
+	audience, err := exchangeAudience(req.Audience, req.Resource, client.AllowedResources)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_target", err.Error())
+		return
+	}
+
+	subject, err := h.parseExchangeToken(ctx, req.SubjectToken)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_grant", "Invalid subject token")
+		return
+	}
+
+	// The actor becomes the outermost act claim; any delegation already
+	// recorded in the subject token is kept as the previous actor
+	act := subject.Act
+	if req.ActorToken != "" {
+		actor, err := h.parseExchangeToken(ctx, req.ActorToken)
 		if err != nil {
-			respondError(w, http.StatusBadRequest, "invalid_grant", "Invalid subject token")
+			respondError(w, http.StatusBadRequest, "invalid_grant", "Invalid actor token")
 			return
 		}
-		userID = claims.UserID
-		email = claims.Email
-		name = claims.Name
-		scope = claims.Scope
-	} else {
-		respondError(w, http.StatusBadRequest, "invalid_grant", "Invalid token format")
+		act = &utils.ActorClaim{Subject: actor.UserID, ClientID: actor.ClientID, Act: subject.Act}
+	}
+
+	// Audience restriction and act claims are only carried by signed access tokens
+	if (act != nil || len(audience) > 0) && (req.IsEncryptedJWE || req.RequestedTokenType != AccessTokenType) {
+		respondError(w, http.StatusBadRequest, "invalid_request", "Audience and delegation are only supported for JWT access tokens")
+		return
+	}
+	if cnf != nil && req.IsEncryptedJWE {
+		respondError(w, http.StatusBadRequest, "invalid_request", "Encrypted tokens cannot be bound to a certificate or DPoP key")
 		return
 	}
 
 	// Get user from database to ensure user exists and get latest info
-	user, err := h.userRepo.FindByID(ctx, userID)
+	user, err := h.userRepo.FindByID(ctx, subject.UserID)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, "server_error", "Failed to find user")
+		respondInternalError(w, err, "Failed to find user")
 		return
 	}
 
+	email := subject.Email
 	if email == "" {
 		email = user.Email
 	}
+	name := subject.Name
 	if name == "" {
 		name = user.Name
 	}
 
 	// Validate and normalize scope
+	scope := subject.Scope
 	if req.Scope != "" {
 		if !utils.ValidateScope(req.Scope) {
 			respondError(w, http.StatusBadRequest, "invalid_scope", "Invalid scope requested")
@@ -139,96 +243,204 @@ func (h *TokenExchangeHandler) HandleTokenExchange(w http.ResponseWriter, r *htt
 	} else if scope == "" {
 		scope = utils.GetDefaultScope()
 	}
+	scope, ok = applyTokenPolicy(w, r, h.tokenPolicy, h.audit, TokenExchangeGrantType, user, client, scope)
+	if !ok {
+		return
+	}
 
-	var accessToken, refreshToken, idToken string
+	// ID token claims are filtered by scope and narrowed to the claims the
+	// downstream audience asked for
+	idTokenReq := &idTokenRequest{User: user, Client: client, Scope: scope, Claims: requestedClaims}
 	expiresIn := h.config.AccessTokenExpiry
 
-	if req.IsEncryptedJWE {
-		accessToken, err = utils.GenerateJWEAccessToken(
-			userID,
-			email,
-			name,
-			scope,
-			h.config.PublicKey,
-			time.Now().Add(time.Duration(expiresIn)*time.Second).Unix(),
-		)
+	var response TokenExchangeResponse
+	switch req.RequestedTokenType {
+	case RefreshTokenType:
+		refreshToken, err := h.issueRefreshToken(user.ID, req.ClientID, scope, req.IsEncryptedJWE)
 		if err != nil {
-			respondError(w, http.StatusInternalServerError, "server_error", "Failed to generate access token")
+			respondInternalError(w, err, "Failed to generate refresh token")
 			return
 		}
+		response = TokenExchangeResponse{
+			AccessToken:     refreshToken,
+			IssuedTokenType: RefreshTokenType,
+			TokenType:       "N_A",
+			ExpiresIn:       h.config.RefreshTokenExpiry,
+			Scope:           scope,
+		}
 
-		refreshToken, err = utils.GenerateJWERefreshToken(
-			userID,
-			h.config.PublicKey,
-			time.Now().Add(time.Duration(h.config.RefreshTokenExpiry)*time.Second).Unix(),
-		)
+	case IDTokenType:
+		idToken, err := h.issueIDToken(idTokenReq, req.IsEncryptedJWE)
 		if err != nil {
-			respondError(w, http.StatusInternalServerError, "server_error", "Failed to generate refresh token")
+			respondInternalError(w, err, "Failed to generate ID token")
 			return
 		}
+		response = TokenExchangeResponse{
+			AccessToken:     idToken,
+			IssuedTokenType: IDTokenType,
+			TokenType:       "N_A",
+			ExpiresIn:       expiresIn,
+			Scope:           scope,
+		}
 
-		// Generate ID token with filtered claims based on scopes
-		userClaims := utils.GetIDTokenClaimsForUser(user, scope, "")
-		idToken, err = utils.GenerateJWEIDToken(
-			userID,
-			req.ClientID,
-			userClaims,
-			h.config.PublicKey,
-			time.Now().Add(time.Duration(expiresIn)*time.Second).Unix(),
-		)
+	default:
+		var accessToken string
+		if req.IsEncryptedJWE {
+			accessToken, err = utils.GenerateJWEAccessToken(
+				user.ID,
+				email,
+				name,
+				scope,
+				jweEncryptionKey(h.config),
+				time.Now().Add(time.Duration(expiresIn)*time.Second).Unix(),
+			)
+		} else {
+			accessToken, err = utils.GenerateBoundAccessToken(
+				user.ID,
+				"",
+				email,
+				name,
+				scope,
+				audience,
+				act,
+				cnf,
+				h.config.PrivateKey,
+				expiresIn,
+			)
+		}
 		if err != nil {
-			respondError(w, http.StatusInternalServerError, "server_error", "Failed to generate ID token")
+			respondInternalError(w, err, "Failed to generate access token")
 			return
 		}
-	} else {
-		accessToken, err = utils.GenerateAccessToken(
-			userID,
-			email,
-			name,
-			scope,
-			h.config.PrivateKey,
-			expiresIn,
-		)
+
+		response = TokenExchangeResponse{
+			AccessToken:     accessToken,
+			IssuedTokenType: AccessTokenType,
+			TokenType:       tokenType(cnf),
+			ExpiresIn:       expiresIn,
+			Scope:           scope,
+		}
+
+		// Delegated tokens are issued on their own so the actor cannot
+		// refresh its way to tokens without the act claim
+		if act == nil {
+			response.RefreshToken, err = h.issueRefreshToken(user.ID, req.ClientID, scope, req.IsEncryptedJWE)
+			if err != nil {
+				respondInternalError(w, err, "Failed to generate refresh token")
+				return
+			}
+
+			response.IDToken, err = h.issueIDToken(idTokenReq, req.IsEncryptedJWE)
+			if err != nil {
+				respondInternalError(w, err, "Failed to generate ID token")
+				return
+			}
+		}
+	}
+
+	details := map[string]string{"grant_type": TokenExchangeGrantType, "scope": scope, "issued_token_type": response.IssuedTokenType}
+	if act != nil {
+		details["actor"] = act.Subject
+	}
+	h.audit.record(r, models.AuditTokenIssued, user.ID, req.ClientID, details)
+
+	respondJSON(w, http.StatusOK, response)
+}
+
+// parseExchangeToken validates a JWT or JWE access token presented in an
+// exchange and returns the identity it carries. Tokens of revoked users and
+// clients are rejected.
+func (h *TokenExchangeHandler) parseExchangeToken(ctx context.Context, token string) (*exchangeToken, error) {
+	if utils.IsJWE(token) {
+		claims, err := utils.ValidateJWE(token, jweDecryptionKeys(h.config)...)
 		if err != nil {
-			respondError(w, http.StatusInternalServerError, "server_error", "Failed to generate access token")
-			return
+			return nil, err
+		}
+		if err := h.tokens.checkRevocation(ctx, claims.UserID, claims.ClientID, time.Unix(claims.Iat, 0)); err != nil {
+			return nil, err
 		}
+		return &exchangeToken{
+			UserID:   claims.UserID,
+			Email:    claims.Email,
+			Name:     claims.Name,
+			Scope:    claims.Scope,
+			ClientID: claims.ClientID,
+		}, nil
+	}
 
-		refreshToken, err = utils.GenerateRefreshToken(
-			userID,
-			scope,
-			h.config.PrivateKey,
-			h.config.RefreshTokenExpiry,
-		)
+	if utils.IsJWT(token) {
+		claims, err := utils.ValidateToken(token, h.config.PublicKey)
 		if err != nil {
-			respondError(w, http.StatusInternalServerError, "server_error", "Failed to generate refresh token")
-			return
+			return nil, err
+		}
+		if err := h.tokens.checkRevocation(ctx, claims.UserID, claims.ClientID, claimIssuedAt(claims.IssuedAt)); err != nil {
+			return nil, err
 		}
+		return &exchangeToken{
+			UserID:   claims.UserID,
+			Email:    claims.Email,
+			Name:     claims.Name,
+			Scope:    claims.Scope,
+			ClientID: claims.ClientID,
+			Act:      claims.Act,
+		}, nil
+	}
 
-		// Generate ID token with filtered claims based on scopes
-		userClaims := utils.GetIDTokenClaimsForUser(user, scope, "")
-		idToken, err = utils.GenerateIDToken(
+	return nil, errors.New("invalid token format")
+}
+
+func (h *TokenExchangeHandler) issueRefreshToken(userID, clientID, scope string, encrypted bool) (string, error) {
+	if encrypted {
+		return utils.GenerateJWERefreshTokenForClient(
 			userID,
-			req.ClientID,
-			userClaims,
-			h.config.PrivateKey,
-			expiresIn,
+			clientID,
+			scope,
+			jweEncryptionKey(h.config),
+			time.Now().Add(time.Duration(h.config.RefreshTokenExpiry)*time.Second).Unix(),
 		)
-		if err != nil {
-			respondError(w, http.StatusInternalServerError, "server_error", "Failed to generate ID token")
-			return
-		}
 	}
+	return utils.GenerateRefreshToken(userID, clientID, scope, h.config.PrivateKey, h.config.RefreshTokenExpiry)
+}
 
-	response := TokenExchangeResponse{
-		AccessToken:     accessToken,
-		IssuedTokenType: AccessTokenType,
-		TokenType:       "Bearer",
-		ExpiresIn:       expiresIn,
-		RefreshToken:    refreshToken,
-		IDToken:         idToken,
-		Scope:           scope,
+// issueIDToken signs an ID token for the client. A client that registered
+// for encrypted ID tokens gets it encrypted to its own key, even when the
+// server-encrypted JWE format was asked for.
+func (h *TokenExchangeHandler) issueIDToken(req *idTokenRequest, encrypted bool) (string, error) {
+	if encrypted && req.Client.IDTokenEncryptedAlg == "" {
+		return utils.GenerateJWEIDToken(
+			req.User.ID,
+			req.Client.ClientID,
+			req.userClaims(),
+			jweEncryptionKey(h.config),
+			time.Now().Add(time.Duration(h.config.AccessTokenExpiry)*time.Second).Unix(),
+		)
 	}
+	return buildIDToken(req, h.config)
+}
 
-	respondJSON(w, http.StatusOK, response)
+// exchangeAudience combines the audience and resource parameters into the
+// audience of the issued token. Both must name resource servers registered
+// for the client; resources must additionally be absolute URIs (RFC 8707).
+func exchangeAudience(audiences, resources, allowed []string) ([]string, error) {
+	if err := utils.ValidateResources(resources, allowed); err != nil {
+		return nil, err
+	}
+
+	allowedSet := make(map[string]bool, len(allowed))
+	for _, resource := range allowed {
+		allowedSet[resource] = true
+	}
+
+	var combined []string
+	seen := make(map[string]bool)
+	for _, aud := range append(append([]string{}, audiences...), resources...) {
+		if !allowedSet[aud] {
+			return nil, errors.New("audience not allowed for this client: " + aud)
+		}
+		if !seen[aud] {
+			seen[aud] = true
+			combined = append(combined, aud)
+		}
+	}
+	return combined, nil
 }
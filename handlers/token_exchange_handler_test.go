@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"oauth2-server/config"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokenExchangeHandler_RejectsInvalidParameters(t *testing.T) {
+	h := NewTokenExchangeHandler(nil, nil, nil, nil, nil, nil, nil, &config.Config{})
+
+	tests := []struct {
+		name   string
+		params url.Values
+	}{
+		{"actor token without type", url.Values{"actor_token": {"token"}}},
+		{"actor type without token", url.Values{"actor_token_type": {AccessTokenType}}},
+		{"unsupported actor type", url.Values{"actor_token": {"token"}, "actor_token_type": {IDTokenType}}},
+		{"unsupported requested type", url.Values{"requested_token_type": {"urn:ietf:params:oauth:token-type:saml2"}}},
+		{"invalid claims request", url.Values{"claims": {"{"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{
+				"grant_type":         {TokenExchangeGrantType},
+				"subject_token":      {"subject"},
+				"subject_token_type": {AccessTokenType},
+			}
+			for key, values := range tt.params {
+				form[key] = values
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+
+			h.HandleTokenExchange(w, req)
+
+			if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "invalid_request") {
+				t.Errorf("Expected 400 invalid_request, got %d: %s", w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestExchangeAudience(t *testing.T) {
+	allowed := []string{"https://api.example.com", "orders-service"}
+
+	tests := []struct {
+		name      string
+		audiences []string
+		resources []string
+		expected  []string
+		wantErr   bool
+	}{
+		{"nothing requested", nil, nil, nil, false},
+		{"logical audience", []string{"orders-service"}, nil, []string{"orders-service"}, false},
+		{"audience and resource", []string{"orders-service"}, []string{"https://api.example.com"}, []string{"orders-service", "https://api.example.com"}, false},
+		{"duplicates collapsed", []string{"https://api.example.com"}, []string{"https://api.example.com"}, []string{"https://api.example.com"}, false},
+		{"unregistered audience", []string{"billing-service"}, nil, nil, true},
+		{"resource must be a URI", nil, []string{"orders-service"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := exchangeAudience(tt.audiences, tt.resources, allowed)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("exchangeAudience() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("exchangeAudience() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"net/http"
+	"oauth2-server/models"
+	"oauth2-server/policy"
+	"strings"
+)
+
+// applyTokenPolicy runs tokenPolicy, the policy loaded from
+// TOKEN_POLICY_FILE, for a grant and returns the scope to issue. A nil
+// tokenPolicy allows every grant as requested. A denied grant is answered with access_denied, and one left without
+// scopes with invalid_scope, and recorded with audit. ok is false once an
+// error response has been written. user is nil for grants without a user,
+// client for tokens issued at login.
+func applyTokenPolicy(w http.ResponseWriter, r *http.Request, tokenPolicy policy.Policy, audit *Auditor, grantType string, user *models.User, client *models.Client, scope string) (string, bool) {
+	if tokenPolicy == nil {
+		tokenPolicy = policy.AllowAll{}
+	}
+	scopes := strings.Fields(scope)
+	decision, err := tokenPolicy.Evaluate(r.Context(), &policy.Input{
+		User:      user,
+		Client:    client,
+		Scopes:    scopes,
+		GrantType: grantType,
+		Context: map[string]string{
+			"ip":         clientIP(r),
+			"user_agent": r.UserAgent(),
+			"resource":   strings.Join(r.Form["resource"], " "),
+		},
+	})
+	if err != nil {
+		respondInternalError(w, err, "Failed to evaluate token policy")
+		return "", false
+	}
+
+	var userID, clientID string
+	if user != nil {
+		userID = user.ID
+	}
+	if client != nil {
+		clientID = client.ClientID
+	}
+
+	if !decision.Allow {
+		audit.record(r, models.AuditTokenDenied, userID, clientID, map[string]string{
+			"grant_type": grantType,
+			"scope":      scope,
+			"rule":       decision.Reason,
+		})
+		respondError(w, http.StatusForbidden, "access_denied", "Token issuance denied by policy")
+		return "", false
+	}
+	if len(decision.Scopes) == 0 && len(scopes) > 0 {
+		audit.record(r, models.AuditTokenDenied, userID, clientID, map[string]string{
+			"grant_type": grantType,
+			"scope":      scope,
+			"rule":       decision.Reason,
+		})
+		respondError(w, http.StatusBadRequest, "invalid_scope", "None of the requested scopes are permitted by policy")
+		return "", false
+	}
+	return strings.Join(decision.Scopes, " "), true
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"oauth2-server/models"
+	"oauth2-server/policy"
+	"testing"
+)
+
+func TestApplyTokenPolicy(t *testing.T) {
+	rules, err := policy.NewExpressionPolicy([]policy.Rule{
+		{Name: "contractors-no-admin", When: `"contractor" in user.roles`, RemoveScopes: []string{"admin"}},
+		{Name: "machines-no-admin", When: `grant_type == "client_credentials" && "admin" in scopes`, Deny: true},
+	})
+	if err != nil {
+		t.Fatalf("Failed to compile policy: %v", err)
+	}
+
+	contractor := &models.User{ID: "u1", Roles: []string{"contractor"}}
+	client := &models.Client{ClientID: "app"}
+
+	tests := []struct {
+		name      string
+		grantType string
+		user      *models.User
+		scope     string
+		expected  string
+		status    int
+	}{
+		{"Scopes removed", "authorization_code", contractor, "openid admin", "openid", http.StatusOK},
+		{"Nothing left", "refresh_token", contractor, "admin", "", http.StatusBadRequest},
+		{"Denied", "client_credentials", nil, "openid admin", "", http.StatusForbidden},
+		{"Untouched", "client_credentials", nil, "openid", "openid", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "/oauth/token", nil)
+			scope, ok := applyTokenPolicy(w, r, rules, nil, tt.grantType, tt.user, client, tt.scope)
+
+			if tt.status == http.StatusOK {
+				if !ok || scope != tt.expected {
+					t.Errorf("Expected scope %q, got %q (ok=%v)", tt.expected, scope, ok)
+				}
+				return
+			}
+			if ok || w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d (ok=%v)", tt.status, w.Code, ok)
+			}
+		})
+	}
+}
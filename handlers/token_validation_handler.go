@@ -1,25 +1,70 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 	"oauth2-server/config"
 	"oauth2-server/utils"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type TokenValidationHandler struct {
+	tokens *TokenStore
 	config *config.Config
+	cache  *validationCache // nil when disabled
 }
 
-func NewTokenValidationHandler(cfg *config.Config) *TokenValidationHandler {
-	return &TokenValidationHandler{
+func NewTokenValidationHandler(tokens *TokenStore, cfg *config.Config) *TokenValidationHandler {
+	h := &TokenValidationHandler{
+		tokens: tokens,
 		config: cfg,
 	}
+	if cfg.TokenValidationCacheSize > 0 && cfg.TokenValidationCacheTTL > 0 {
+		h.cache = newValidationCache(int(cfg.TokenValidationCacheSize), time.Duration(cfg.TokenValidationCacheTTL)*time.Second)
+	}
+	return h
 }
 
+const audienceMismatchError = "Token audience does not match resource"
+
+// Reasons a token is reported invalid, in the reason field of the response
+const (
+	reasonInvalidToken      = "invalid_token"
+	reasonRevoked           = "revoked"
+	reasonWrongTokenType    = "wrong_token_type"
+	reasonAudienceMismatch  = "audience_mismatch"
+	reasonInsufficientScope = "insufficient_scope"
+)
+
+// Token types a caller can expect
+const (
+	accessTokenType  = "access_token"
+	refreshTokenType = "refresh_token"
+)
+
+// TokenValidationRequest is the token to validate and the policy it must
+// meet. GET requests pass the same fields as query parameters.
 type TokenValidationRequest struct {
 	Token string `json:"token"`
+	// Resource is the resource server the token is presented to. When set,
+	// tokens whose audience does not include it are reported as invalid.
+	Resource string `json:"resource,omitempty"`
+	// Audience must be in the token's aud claim. Unlike Resource, tokens
+	// not restricted to any resource server do not match it.
+	Audience string `json:"audience,omitempty"`
+	// Scope lists, space separated, the scopes the token must have
+	Scope string `json:"scope,omitempty"`
+	// ExpectedTokenType is access_token (the default) or refresh_token
+	ExpectedTokenType string `json:"expected_token_type,omitempty"`
+	// CheckRevocation, on unless false, rejects tokens revoked by logout,
+	// account suspension or client deletion
+	CheckRevocation *bool `json:"check_revocation,omitempty"`
 }
 
 type TokenValidationResponse struct {
@@ -27,8 +72,21 @@ type TokenValidationResponse struct {
 	TokenType string                 `json:"token_type,omitempty"`
 	Claims    map[string]interface{} `json:"claims,omitempty"`
 	Error     string                 `json:"error,omitempty"`
-	ExpiresAt int64                  `json:"expires_at,omitempty"`
-	IssuedAt  int64                  `json:"issued_at,omitempty"`
+	// Reason is a stable code for why the token is invalid, one of
+	// invalid_token, revoked, wrong_token_type, audience_mismatch and
+	// insufficient_scope
+	Reason        string   `json:"reason,omitempty"`
+	MissingScopes []string `json:"missing_scopes,omitempty"`
+	ExpiresAt     int64    `json:"expires_at,omitempty"`
+	IssuedAt      int64    `json:"issued_at,omitempty"`
+}
+
+// validatedToken is the outcome of checking a token itself, before the
+// caller's policy is applied
+type validatedToken struct {
+	response TokenValidationResponse
+	audience []string
+	scope    string
 }
 
 func (h *TokenValidationHandler) ValidateToken(w http.ResponseWriter, r *http.Request) {
@@ -40,7 +98,20 @@ func (h *TokenValidationHandler) ValidateToken(w http.ResponseWriter, r *http.Re
 			return
 		}
 	} else {
-		req.Token = r.URL.Query().Get("token")
+		query := r.URL.Query()
+		req.Token = query.Get("token")
+		req.Resource = query.Get("resource")
+		req.Audience = query.Get("audience")
+		req.Scope = query.Get("scope")
+		req.ExpectedTokenType = query.Get("expected_token_type")
+		if value := query.Get("check_revocation"); value != "" {
+			check, err := strconv.ParseBool(value)
+			if err != nil {
+				respondError(w, http.StatusBadRequest, "invalid_request", "check_revocation must be true or false")
+				return
+			}
+			req.CheckRevocation = &check
+		}
 		if req.Token == "" {
 			authHeader := r.Header.Get("Authorization")
 			if authHeader != "" {
@@ -49,58 +120,261 @@ func (h *TokenValidationHandler) ValidateToken(w http.ResponseWriter, r *http.Re
 		}
 	}
 
-	if req.Token == "" {
-		respondError(w, http.StatusBadRequest, "invalid_request", "Missing token parameter")
+	response, err := h.Validate(r.Context(), &req)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_request", err.Error())
 		return
 	}
+	respondJSON(w, http.StatusOK, response)
+}
+
+// Validate checks req.Token and the policy in req. It fails only for a
+// malformed request; an invalid token is reported in the response.
+func (h *TokenValidationHandler) Validate(ctx context.Context, req *TokenValidationRequest) (TokenValidationResponse, error) {
+	if req.Token == "" {
+		return TokenValidationResponse{}, errors.New("Missing token parameter")
+	}
+	expectedType := req.ExpectedTokenType
+	if expectedType == "" {
+		expectedType = accessTokenType
+	}
+	if expectedType != accessTokenType && expectedType != refreshTokenType {
+		return TokenValidationResponse{}, errors.New("expected_token_type must be access_token or refresh_token")
+	}
+
+	result := h.validate(ctx, req.Token, expectedType, req.CheckRevocation == nil || *req.CheckRevocation)
+	return applyValidationPolicy(result, req), nil
+}
+
+// validate checks the token's signature or encryption, expiry, kind and,
+// withRevocation, revocation. Fully checked results are cached.
+func (h *TokenValidationHandler) validate(ctx context.Context, token, tokenType string, withRevocation bool) validatedToken {
+	if h.cache == nil {
+		return h.check(ctx, token, tokenType, withRevocation)
+	}
+
+	// A cached result had revocation checked, so it also answers callers
+	// that skip the check
+	key := validationCacheKey(token, tokenType)
+	if result, ok := h.cache.get(key); ok {
+		return result
+	}
+	result := h.check(ctx, token, tokenType, withRevocation)
+	if withRevocation {
+		var expiry time.Time
+		if result.response.ExpiresAt > 0 {
+			expiry = time.Unix(result.response.ExpiresAt, 0)
+		}
+		h.cache.put(key, result, expiry)
+	}
+	return result
+}
 
-	response := TokenValidationResponse{}
+func (h *TokenValidationHandler) check(ctx context.Context, token, tokenType string, withRevocation bool) validatedToken {
+	revoked := func(userID, clientID string, issuedAt time.Time) error {
+		if !withRevocation {
+			return nil
+		}
+		return h.tokens.checkRevocation(ctx, userID, clientID, issuedAt)
+	}
+	invalid := func(reason string, err error) validatedToken {
+		return validatedToken{response: TokenValidationResponse{Valid: false, Reason: reason, Error: err.Error()}}
+	}
+
+	if tokenType == refreshTokenType {
+		return h.checkRefreshToken(token, revoked, invalid)
+	}
 
-	if utils.IsJWE(req.Token) {
-		claims, err := utils.ValidateJWE(req.Token, h.config.PrivateKey)
+	if utils.IsJWE(token) {
+		claims, err := utils.ValidateJWE(token, jweDecryptionKeys(h.config)...)
 		if err != nil {
-			response.Valid = false
-			response.Error = err.Error()
-		} else {
-			response.Valid = true
-			response.TokenType = "JWE"
-			response.Claims = map[string]interface{}{
+			return invalid(reasonInvalidToken, err)
+		}
+		if claims.TokenUse != "" {
+			return invalid(reasonWrongTokenType, utils.ErrTokenType)
+		}
+		if err := revoked(claims.UserID, "", time.Unix(claims.Iat, 0)); err != nil {
+			return invalid(reasonRevoked, err)
+		}
+		result := validatedToken{scope: claims.Scope, response: TokenValidationResponse{
+			Valid:     true,
+			TokenType: "JWE",
+			Claims: map[string]interface{}{
 				"sub":   claims.UserID,
 				"email": claims.Email,
 				"name":  claims.Name,
 				"scope": claims.Scope,
 				"aud":   claims.Aud,
-			}
-			response.ExpiresAt = claims.Exp
-			response.IssuedAt = claims.Iat
+			},
+			ExpiresAt: claims.Exp,
+			IssuedAt:  claims.Iat,
+		}}
+		if claims.Aud != "" {
+			result.audience = []string{claims.Aud}
 		}
-	} else if utils.IsJWT(req.Token) {
-		claims, err := utils.ValidateToken(req.Token, h.config.PublicKey)
-		if err != nil {
-			response.Valid = false
-			response.Error = err.Error()
-		} else {
-			response.Valid = true
-			response.TokenType = "JWT"
-			response.Claims = map[string]interface{}{
+		return result
+	}
+
+	if utils.IsJWT(token) {
+		claims, err := utils.ValidateToken(token, h.config.PublicKey)
+		if errors.Is(err, utils.ErrTokenType) {
+			return invalid(reasonWrongTokenType, err)
+		} else if err != nil {
+			return invalid(reasonInvalidToken, err)
+		}
+		if err := revoked(claims.UserID, claims.ClientID, claimIssuedAt(claims.IssuedAt)); err != nil {
+			return invalid(reasonRevoked, err)
+		}
+		result := validatedToken{audience: claims.Audience, scope: claims.Scope, response: TokenValidationResponse{
+			Valid:     true,
+			TokenType: "JWT",
+			Claims: map[string]interface{}{
 				"sub":   claims.UserID,
 				"email": claims.Email,
 				"name":  claims.Name,
 				"scope": claims.Scope,
-			}
-			if claims.ExpiresAt != nil {
-				response.ExpiresAt = claims.ExpiresAt.Unix()
-			}
-			if claims.IssuedAt != nil {
-				response.IssuedAt = claims.IssuedAt.Unix()
-			}
+			},
+		}}
+		if claims.ClientID != "" {
+			result.response.Claims["client_id"] = claims.ClientID
 		}
-	} else {
-		response.Valid = false
-		response.Error = "Invalid token format"
+		if claims.GrantType != "" {
+			// Machine tokens have no profile claims
+			result.response.Claims["gty"] = claims.GrantType
+			delete(result.response.Claims, "email")
+			delete(result.response.Claims, "name")
+		}
+		if len(claims.Audience) > 0 {
+			result.response.Claims["aud"] = []string(claims.Audience)
+		}
+		if claims.Cnf != nil {
+			result.response.Claims["cnf"] = claims.Cnf
+		}
+		if claims.ExpiresAt != nil {
+			result.response.ExpiresAt = claims.ExpiresAt.Unix()
+		}
+		if claims.IssuedAt != nil {
+			result.response.IssuedAt = claims.IssuedAt.Unix()
+		}
+		return result
 	}
 
-	respondJSON(w, http.StatusOK, response)
+	if h.tokens.opaqueTokens() == nil {
+		return invalid(reasonInvalidToken, errors.New("Invalid token format"))
+	}
+
+	// Anything else may be an opaque token issued from the token store.
+	// Revoked opaque tokens are deleted, so they are never found.
+	info, err := h.tokens.resolveAccessToken(ctx, token, h.config)
+	if err != nil {
+		return invalid(reasonInvalidToken, err)
+	}
+	result := validatedToken{audience: info.Audience, scope: info.Scope, response: TokenValidationResponse{
+		Valid:     true,
+		TokenType: "opaque",
+		Claims: map[string]interface{}{
+			"sub":       info.UserID,
+			"scope":     info.Scope,
+			"client_id": info.ClientID,
+		},
+		ExpiresAt: info.ExpiresAt.Unix(),
+		IssuedAt:  info.IssuedAt.Unix(),
+	}}
+	if len(info.Audience) > 0 {
+		result.response.Claims["aud"] = info.Audience
+	}
+	if info.GrantType != "" {
+		result.response.Claims["gty"] = info.GrantType
+	}
+	if cnf := utils.NewConfirmationClaim(info.Thumbprint, info.JKT); cnf != nil {
+		result.response.Claims["cnf"] = cnf
+	}
+	return result
+}
+
+// checkRefreshToken validates a signed or encrypted refresh token
+func (h *TokenValidationHandler) checkRefreshToken(token string, revoked func(string, string, time.Time) error, invalid func(string, error) validatedToken) validatedToken {
+	var userID, clientID, scope, format string
+	var issuedAt, expiresAt int64
+
+	switch {
+	case utils.IsJWE(token):
+		claims, err := utils.ValidateJWERefreshToken(token, jweDecryptionKeys(h.config)...)
+		if err != nil {
+			return invalid(reasonInvalidToken, err)
+		}
+		userID, clientID, scope, format = claims.UserID, claims.ClientID, claims.Scope, "JWE"
+		issuedAt, expiresAt = claims.Iat, claims.Exp
+	case utils.IsJWT(token):
+		claims, err := utils.ValidateRefreshToken(token, h.config.PublicKey)
+		if errors.Is(err, utils.ErrTokenType) {
+			return invalid(reasonWrongTokenType, err)
+		} else if err != nil {
+			return invalid(reasonInvalidToken, err)
+		}
+		userID, clientID, scope, format = claims.UserID, claims.ClientID, claims.Scope, "JWT"
+		issuedAt = claimIssuedAt(claims.IssuedAt).Unix()
+		if claims.ExpiresAt != nil {
+			expiresAt = claims.ExpiresAt.Unix()
+		}
+	default:
+		// Refresh tokens are never opaque
+		return invalid(reasonWrongTokenType, utils.ErrTokenType)
+	}
+
+	if err := revoked(userID, clientID, time.Unix(issuedAt, 0)); err != nil {
+		return invalid(reasonRevoked, err)
+	}
+	return validatedToken{scope: scope, response: TokenValidationResponse{
+		Valid:     true,
+		TokenType: format,
+		Claims: map[string]interface{}{
+			"sub":       userID,
+			"scope":     scope,
+			"client_id": clientID,
+		},
+		ExpiresAt: expiresAt,
+		IssuedAt:  issuedAt,
+	}}
+}
+
+// applyValidationPolicy checks a valid token against the resource, audience
+// and scopes the caller requires
+func applyValidationPolicy(result validatedToken, req *TokenValidationRequest) TokenValidationResponse {
+	response := result.response
+	if !response.Valid {
+		return response
+	}
+
+	reject := func(reason, message string) TokenValidationResponse {
+		return TokenValidationResponse{Valid: false, Reason: reason, Error: message}
+	}
+	if req.Resource != "" && !utils.AudienceAllows(result.audience, req.Resource) {
+		return reject(reasonAudienceMismatch, audienceMismatchError)
+	}
+	if req.Audience != "" && !slices.Contains(result.audience, req.Audience) {
+		return reject(reasonAudienceMismatch, "Token audience does not include "+req.Audience)
+	}
+
+	var missing []string
+	for _, scope := range strings.Fields(req.Scope) {
+		if !utils.HasScope(result.scope, scope) {
+			missing = append(missing, scope)
+		}
+	}
+	if len(missing) > 0 {
+		rejected := reject(reasonInsufficientScope, "Token is missing required scopes")
+		rejected.MissingScopes = missing
+		return rejected
+	}
+	return response
+}
+
+// WritePrometheus writes the validation cache counters, if the cache is on
+func (h *TokenValidationHandler) WritePrometheus(w io.Writer) {
+	if h.cache != nil {
+		h.cache.writePrometheus(w)
+	}
 }
 
 func (h *TokenValidationHandler) ValidateTokenMiddleware(next http.HandlerFunc) http.HandlerFunc {
@@ -111,23 +385,14 @@ func (h *TokenValidationHandler) ValidateTokenMiddleware(next http.HandlerFunc)
 			return
 		}
 
-		token := strings.TrimPrefix(authHeader, "Bearer ")
+		token, dpop := accessTokenFromHeader(authHeader)
 		if token == authHeader {
 			respondError(w, http.StatusUnauthorized, "unauthorized", "Bearer token required")
 			return
 		}
 
-		var valid bool
-
-		if utils.IsJWE(token) {
-			_, err := utils.ValidateJWE(token, h.config.PrivateKey)
-			valid = err == nil
-		} else if utils.IsJWT(token) {
-			_, err := utils.ValidateToken(token, h.config.PublicKey)
-			valid = err == nil
-		}
-
-		if !valid {
+		info, err := h.tokens.resolveAccessToken(r.Context(), token, h.config)
+		if err != nil || !presentedBindingMatches(r, info, token, dpop, h.config) {
 			respondError(w, http.StatusUnauthorized, "invalid_token", "Invalid or expired token")
 			return
 		}
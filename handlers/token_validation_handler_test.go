@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"oauth2-server/config"
+	"oauth2-server/utils"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenValidationHandler_ValidateToken_Resource(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	cfg := &config.Config{PrivateKey: privateKey, PublicKey: &privateKey.PublicKey}
+	h := NewTokenValidationHandler(nil, cfg)
+
+	boundToken, err := utils.GenerateAccessTokenForClient("user-1", "client-1", "user@example.com", "User", "openid", []string{"https://api.example.com"}, privateKey, 3600)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	unboundToken, err := utils.GenerateAccessToken("user-1", "user@example.com", "User", "openid", privateKey, 3600)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		token     string
+		resource  string
+		wantValid bool
+	}{
+		{"no resource", boundToken, "", true},
+		{"matching resource", boundToken, "https://api.example.com", true},
+		{"other resource", boundToken, "https://billing.example.com", false},
+		{"token without audience", unboundToken, "https://billing.example.com", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := url.Values{"token": {tt.token}}
+			if tt.resource != "" {
+				query.Set("resource", tt.resource)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/token/validate?"+query.Encode(), nil)
+			rec := httptest.NewRecorder()
+
+			h.ValidateToken(rec, req)
+
+			var resp TokenValidationResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if resp.Valid != tt.wantValid {
+				t.Errorf("Valid = %v, want %v (error %q)", resp.Valid, tt.wantValid, resp.Error)
+			}
+			if !tt.wantValid && resp.Error != audienceMismatchError {
+				t.Errorf("Error = %q, want %q", resp.Error, audienceMismatchError)
+			}
+		})
+	}
+}
+
+func TestTokenAudience(t *testing.T) {
+	api := "https://api.example.com"
+	billing := "https://billing.example.com"
+
+	tests := []struct {
+		name      string
+		requested []string
+		granted   []string
+		allowed   []string
+		want      []string
+		wantErr   bool
+	}{
+		{"nothing requested or granted", nil, nil, []string{api}, nil, false},
+		{"granted carried over", nil, []string{api, billing}, []string{api, billing}, []string{api, billing}, false},
+		{"narrowed to one granted resource", []string{api}, []string{api, billing}, []string{api, billing}, []string{api}, false},
+		{"registered but not granted", []string{billing}, []string{api}, []string{api, billing}, nil, true},
+		{"registered, nothing granted", []string{billing}, nil, []string{api, billing}, []string{billing}, false},
+		{"not registered", []string{billing}, nil, []string{api}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tokenAudience(tt.requested, tt.granted, tt.allowed)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("tokenAudience() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tokenAudience() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenValidationHandler_ValidateToken_Policy(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	h := NewTokenValidationHandler(nil, &config.Config{PrivateKey: privateKey, PublicKey: &privateKey.PublicKey})
+
+	accessToken, _ := utils.GenerateAccessTokenForClient("user-1", "client-1", "", "", "openid orders:read", []string{"https://api.example.com"}, privateKey, 3600)
+	unrestricted, _ := utils.GenerateAccessTokenForClient("user-1", "client-1", "", "", "openid", nil, privateKey, 3600)
+	refreshToken, _ := utils.GenerateRefreshToken("user-1", "client-1", "openid", privateKey, 3600)
+
+	tests := []struct {
+		name       string
+		query      url.Values
+		wantValid  bool
+		wantReason string
+	}{
+		{"required scopes granted", url.Values{"token": {accessToken}, "scope": {"orders:read openid"}}, true, ""},
+		{"required scope missing", url.Values{"token": {accessToken}, "scope": {"orders:write"}}, false, reasonInsufficientScope},
+		{"expected audience", url.Values{"token": {accessToken}, "audience": {"https://api.example.com"}}, true, ""},
+		{"unrestricted token and expected audience", url.Values{"token": {unrestricted}, "audience": {"https://api.example.com"}}, false, reasonAudienceMismatch},
+		{"refresh token as access token", url.Values{"token": {refreshToken}}, false, reasonWrongTokenType},
+		{"refresh token expected", url.Values{"token": {refreshToken}, "expected_token_type": {"refresh_token"}}, true, ""},
+		{"access token as refresh token", url.Values{"token": {accessToken}, "expected_token_type": {"refresh_token"}}, false, reasonWrongTokenType},
+		{"malformed token", url.Values{"token": {"not-a-token"}}, false, reasonInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/token/validate?"+tt.query.Encode(), nil)
+			rec := httptest.NewRecorder()
+
+			h.ValidateToken(rec, req)
+
+			var resp TokenValidationResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if resp.Valid != tt.wantValid || resp.Reason != tt.wantReason {
+				t.Errorf("Got valid=%v reason=%q (%q), want valid=%v reason=%q", resp.Valid, resp.Reason, resp.Error, tt.wantValid, tt.wantReason)
+			}
+			if tt.wantReason == reasonInsufficientScope && !reflect.DeepEqual(resp.MissingScopes, []string{"orders:write"}) {
+				t.Errorf("Expected the missing scope to be named, got %v", resp.MissingScopes)
+			}
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/token/validate?token=x&expected_token_type=id_token", nil)
+	rec := httptest.NewRecorder()
+	h.ValidateToken(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected an unknown token type to be a bad request, got %d", rec.Code)
+	}
+}
+
+func TestValidationCache(t *testing.T) {
+	cache := newValidationCache(2, time.Minute)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	valid := validatedToken{response: TokenValidationResponse{Valid: true}}
+	a, b, c := validationCacheKey("a", accessTokenType), validationCacheKey("b", accessTokenType), validationCacheKey("c", accessTokenType)
+	cache.put(a, valid, time.Time{})
+	cache.put(b, valid, now.Add(10*time.Second))
+
+	if validationCacheKey("a", refreshTokenType) == a {
+		t.Error("Expected the expected token type to be part of the key")
+	}
+	if _, ok := cache.get(a); !ok {
+		t.Error("Expected a cached result")
+	}
+
+	// b expires with its token, before the TTL
+	now = now.Add(11 * time.Second)
+	if _, ok := cache.get(b); ok {
+		t.Error("Expected the result to expire with the token")
+	}
+
+	// Holding two results, adding a third evicts the least recently used
+	cache.put(b, valid, time.Time{})
+	cache.get(a)
+	cache.put(c, valid, time.Time{})
+	if _, ok := cache.get(b); ok {
+		t.Error("Expected the least recently used result to be evicted")
+	}
+	if _, ok := cache.get(a); !ok {
+		t.Error("Expected the recently used result to be kept")
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok := cache.get(a); ok {
+		t.Error("Expected the result to expire after the TTL")
+	}
+
+	var metrics strings.Builder
+	cache.writePrometheus(&metrics)
+	if !strings.Contains(metrics.String(), "oauth_token_validation_cache_evictions_total 1") {
+		t.Errorf("Unexpected metrics:\n%s", metrics.String())
+	}
+}
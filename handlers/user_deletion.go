@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"context"
+	"oauth2-server/repository"
+)
+
+// UserDeleter removes a user: every token issued to them is revoked and the
+// sessions, pending authorization requests, consents, group memberships,
+// pending email verifications, remembered devices and sign-in history held
+// about them are deleted along with the account. The OAuth clients they
+// registered are deleted too, as if they had deleted each one themselves.
+// Audit log entries are kept.
+type UserDeleter struct {
+	userRepo         *repository.UserRepository
+	clientRepo       *repository.ClientRepository
+	authRequests     *repository.AuthorizationRequestRepository
+	consentRepo      *repository.UserConsentRepository
+	groupRepo        *repository.GroupRepository
+	verificationRepo *repository.EmailVerificationRepository
+	trustedDevices   *repository.TrustedDeviceRepository
+	signInProfiles   *repository.SignInProfileRepository
+	revoker          *Revoker
+}
+
+func NewUserDeleter(
+	userRepo *repository.UserRepository,
+	clientRepo *repository.ClientRepository,
+	authRequests *repository.AuthorizationRequestRepository,
+	consentRepo *repository.UserConsentRepository,
+	groupRepo *repository.GroupRepository,
+	verificationRepo *repository.EmailVerificationRepository,
+	trustedDevices *repository.TrustedDeviceRepository,
+	signInProfiles *repository.SignInProfileRepository,
+	revoker *Revoker,
+) *UserDeleter {
+	return &UserDeleter{
+		userRepo:         userRepo,
+		clientRepo:       clientRepo,
+		authRequests:     authRequests,
+		consentRepo:      consentRepo,
+		groupRepo:        groupRepo,
+		verificationRepo: verificationRepo,
+		trustedDevices:   trustedDevices,
+		signInProfiles:   signInProfiles,
+		revoker:          revoker,
+	}
+}
+
+// Delete removes the user and their data, returning ErrNotFound when no
+// user has that ID
+func (d *UserDeleter) Delete(ctx context.Context, userID string) error {
+	cleanups := []func(context.Context, string) error{
+		d.revoker.RevokeUser,
+		d.authRequests.DeleteByUserID,
+		d.consentRepo.DeleteByUserID,
+		d.groupRepo.RemoveMember,
+		d.verificationRepo.DeleteByUserID,
+		d.trustedDevices.DeleteByUserID,
+		d.signInProfiles.DeleteByUserID,
+		d.deleteOwnedClients,
+	}
+	for _, cleanup := range cleanups {
+		if err := cleanup(ctx, userID); err != nil {
+			return err
+		}
+	}
+	return d.userRepo.Delete(ctx, userID)
+}
+
+// deleteOwnedClients deletes the clients the user registered along with the
+// consents granted to them, revoking every token they were issued. Left in
+// place, they would keep working with no owner able to manage them.
+func (d *UserDeleter) deleteOwnedClients(ctx context.Context, userID string) error {
+	clients, err := d.clientRepo.FindByOwner(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, client := range clients {
+		if err := d.consentRepo.DeleteByClientID(ctx, client.ClientID); err != nil {
+			return err
+		}
+		if err := d.revoker.RevokeClient(ctx, client.ClientID); err != nil {
+			return err
+		}
+		if err := d.clientRepo.Delete(ctx, client.ClientID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
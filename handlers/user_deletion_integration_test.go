@@ -0,0 +1,94 @@
+//go:build integration
+// +build integration
+
+package handlers
+
+import (
+	"context"
+	"oauth2-server/config"
+	"oauth2-server/models"
+	"oauth2-server/repository"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TestUserDeleter_DeletesOwnedClients tests that deleting a user deletes the
+// clients they registered, with the consents granted to them and their
+// tokens, and leaves other users' clients alone
+func TestUserDeleter_DeletesOwnedClients(t *testing.T) {
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI("mongodb://localhost:27017"))
+	if err != nil || client.Ping(ctx, nil) != nil {
+		t.Skip("MongoDB not available, skipping integration test")
+		return
+	}
+	defer client.Disconnect(ctx)
+
+	db := client.Database("oauth2_test_user_deletion")
+	defer db.Drop(ctx)
+
+	userRepo := repository.NewUserRepository(db)
+	clientRepo := repository.NewClientRepository(db)
+	consentRepo := repository.NewUserConsentRepository(db)
+	revocations := repository.NewRevocationRepository(db)
+
+	cfg := &config.Config{AccessTokenExpiry: 3600, RefreshTokenExpiry: 86400}
+	revoker := NewRevoker(repository.NewSSOSessionRepository(db), repository.NewAuthCodeRepository(db), NewTokenStore(nil, revocations), nil, cfg)
+	deleter := NewUserDeleter(
+		userRepo,
+		clientRepo,
+		repository.NewAuthorizationRequestRepository(db),
+		consentRepo,
+		repository.NewGroupRepository(db),
+		repository.NewEmailVerificationRepository(db),
+		repository.NewTrustedDeviceRepository(db),
+		repository.NewSignInProfileRepository(db),
+		revoker,
+	)
+
+	for _, user := range []*models.User{
+		{ID: "owner", Email: "owner@example.com", Name: "Owner", Password: "hashed_password", CreatedAt: time.Now()},
+		{ID: "other", Email: "other@example.com", Name: "Other", Password: "hashed_password", CreatedAt: time.Now()},
+	} {
+		if err := userRepo.Create(ctx, user); err != nil {
+			t.Fatalf("Failed to create test user: %v", err)
+		}
+	}
+	for _, c := range []*models.Client{
+		{ClientID: "owned-client", Name: "Owned", OwnerUserID: "owner", CreatedAt: time.Now()},
+		{ClientID: "other-client", Name: "Other", OwnerUserID: "other", CreatedAt: time.Now()},
+	} {
+		if err := clientRepo.Create(ctx, c); err != nil {
+			t.Fatalf("Failed to create test client: %v", err)
+		}
+	}
+	for _, clientID := range []string{"owned-client", "other-client"} {
+		if err := consentRepo.Save(ctx, &models.UserConsent{UserID: "other", ClientID: clientID, Scopes: []string{"openid"}}, ""); err != nil {
+			t.Fatalf("Failed to save consent: %v", err)
+		}
+	}
+
+	if err := deleter.Delete(ctx, "owner"); err != nil {
+		t.Fatalf("Failed to delete user: %v", err)
+	}
+
+	if _, err := clientRepo.FindByClientID(ctx, "owned-client"); err == nil {
+		t.Error("Expected the owned client to be deleted")
+	}
+	if _, err := consentRepo.FindByUserAndClient(ctx, "other", "owned-client"); err == nil {
+		t.Error("Expected consents granted to the owned client to be deleted")
+	}
+	if revokedAt, err := revocations.RevokedAt(ctx, "", "owned-client"); err != nil || revokedAt.IsZero() {
+		t.Errorf("Expected the owned client's tokens to be revoked, got %v (%v)", revokedAt, err)
+	}
+
+	if _, err := clientRepo.FindByClientID(ctx, "other-client"); err != nil {
+		t.Errorf("Expected another user's client to stay, got %v", err)
+	}
+	if _, err := consentRepo.FindByUserAndClient(ctx, "other", "other-client"); err != nil {
+		t.Errorf("Expected consents to another user's client to stay, got %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"oauth2-server/config"
+	"oauth2-server/models"
+	"oauth2-server/utils"
+)
+
+// userInfoSigningAlgs are the algorithms UserInfo responses can be signed with
+var userInfoSigningAlgs = []string{"RS256"}
+
+// JWTContentType is the content type of a UserInfo response returned as a
+// signed or encrypted JWT
+const JWTContentType = "application/jwt"
+
+// userInfoAsJWT reports whether the client registered for signed or
+// encrypted UserInfo responses rather than plain JSON
+func userInfoAsJWT(client *models.Client) bool {
+	return client.UserInfoSignedAlg != "" || client.UserInfoEncryptedAlg != ""
+}
+
+// userInfoJWT encodes the claims as the client registered: a JWT signed with
+// the server key, encrypted to the client's key, or signed and then encrypted
+// (OpenID Connect Core section 5.3.2)
+func userInfoJWT(client *models.Client, claims map[string]interface{}, cfg *config.Config) (string, error) {
+	var payload []byte
+	var cty string
+	if client.UserInfoSignedAlg != "" {
+		signed, err := utils.GenerateUserInfoToken(client.ClientID, claims, cfg.PrivateKey)
+		if err != nil || client.UserInfoEncryptedAlg == "" {
+			return signed, err
+		}
+		payload, cty = []byte(signed), "JWT"
+	} else {
+		// Encrypted only: the claims themselves are the plaintext
+		var err error
+		if payload, err = json.Marshal(claims); err != nil {
+			return "", err
+		}
+	}
+
+	return encryptToClient(client, payload, client.UserInfoEncryptedAlg, client.UserInfoEncryptedEnc, cty)
+}
+
+// encryptToClient encrypts payload to the client's registered key for alg
+func encryptToClient(client *models.Client, payload []byte, alg, enc, cty string) (string, error) {
+	key := client.JWKS.EncryptionKey(alg)
+	if key == nil {
+		return "", errors.New("client has no encryption key")
+	}
+	publicKey, err := utils.ParsePublicKeyJWK(key.N, key.E)
+	if err != nil {
+		return "", err
+	}
+	if enc == "" {
+		enc = utils.DefaultJWEContentEnc
+	}
+	return utils.EncryptJWEForClient(payload, publicKey, key.Kid, alg, enc, cty)
+}
+
+// encryptIDToken wraps a signed ID token in a JWE addressed to the client
+// when it registered id_token_encrypted_response_alg, making it a nested
+// JWT (OpenID Connect Core section 10.2). Other clients get it unchanged.
+func encryptIDToken(client *models.Client, idToken string) (string, error) {
+	if client.IDTokenEncryptedAlg == "" {
+		return idToken, nil
+	}
+	return encryptToClient(client, []byte(idToken), client.IDTokenEncryptedAlg, client.IDTokenEncryptedEnc, "JWT")
+}
+
+// respondJWT writes a signed or encrypted JWT response body
+func respondJWT(w http.ResponseWriter, status int, token string) {
+	w.Header().Set("Content-Type", JWTContentType)
+	w.WriteHeader(status)
+	w.Write([]byte(token))
+}
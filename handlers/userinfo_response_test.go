@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http/httptest"
+	"oauth2-server/config"
+	"oauth2-server/models"
+	"oauth2-server/utils"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestUserInfoJWT(t *testing.T) {
+	serverKey, err := utils.GenerateRSAKeyPair(2048)
+	if err != nil {
+		t.Fatalf("Failed to generate server key: %v", err)
+	}
+	clientKey, err := utils.GenerateRSAKeyPair(2048)
+	if err != nil {
+		t.Fatalf("Failed to generate client key: %v", err)
+	}
+	cfg := &config.Config{PrivateKey: serverKey, PublicKey: &serverKey.PublicKey}
+	jwks := &models.JSONWebKeySet{Keys: []models.JSONWebKey{
+		{Kty: "RSA", Use: "sig", Kid: "signing", N: "AQAB", E: "AQAB"},
+		{
+			Kty: "RSA",
+			Use: "enc",
+			Kid: "encryption",
+			N:   base64.RawURLEncoding.EncodeToString(clientKey.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(clientKey.E)).Bytes()),
+		},
+	}}
+	claims := map[string]interface{}{"sub": "user-1", "email": "user@example.com"}
+
+	verify := func(t *testing.T, token string) {
+		t.Helper()
+		parsed, err := jwt.Parse(token, func(*jwt.Token) (interface{}, error) { return &serverKey.PublicKey, nil },
+			jwt.WithValidMethods(userInfoSigningAlgs), jwt.WithAudience("client-1"))
+		if err != nil {
+			t.Fatalf("Failed to verify signed response: %v", err)
+		}
+		if sub, _ := parsed.Claims.GetSubject(); sub != "user-1" {
+			t.Errorf("Expected sub user-1, got %q", sub)
+		}
+	}
+
+	t.Run("Plain JSON", func(t *testing.T) {
+		if userInfoAsJWT(&models.Client{ClientID: "client-1"}) {
+			t.Error("Expected a client without settings to get plain JSON")
+		}
+	})
+
+	t.Run("Signed", func(t *testing.T) {
+		client := &models.Client{ClientID: "client-1", UserInfoSignedAlg: "RS256"}
+		token, err := userInfoJWT(client, claims, cfg)
+		if err != nil {
+			t.Fatalf("Failed to sign: %v", err)
+		}
+		verify(t, token)
+	})
+
+	t.Run("Encrypted", func(t *testing.T) {
+		client := &models.Client{ClientID: "client-1", UserInfoEncryptedAlg: "RSA-OAEP-256", JWKS: jwks}
+		token, err := userInfoJWT(client, claims, cfg)
+		if err != nil {
+			t.Fatalf("Failed to encrypt: %v", err)
+		}
+		header, plaintext, err := utils.DecryptClientJWE(token, clientKey)
+		if err != nil {
+			t.Fatalf("Failed to decrypt: %v", err)
+		}
+		if header["enc"] != utils.DefaultJWEContentEnc || header["kid"] != "encryption" || header["cty"] != "" {
+			t.Errorf("Unexpected header %v", header)
+		}
+		var decrypted map[string]interface{}
+		if err := json.Unmarshal(plaintext, &decrypted); err != nil || decrypted["email"] != "user@example.com" {
+			t.Errorf("Expected the claims as plaintext, got %s", plaintext)
+		}
+	})
+
+	t.Run("Signed then encrypted", func(t *testing.T) {
+		client := &models.Client{
+			ClientID:             "client-1",
+			UserInfoSignedAlg:    "RS256",
+			UserInfoEncryptedAlg: "RSA-OAEP",
+			UserInfoEncryptedEnc: "A256GCM",
+			JWKS:                 jwks,
+		}
+		token, err := userInfoJWT(client, claims, cfg)
+		if err != nil {
+			t.Fatalf("Failed to sign and encrypt: %v", err)
+		}
+		header, plaintext, err := utils.DecryptClientJWE(token, clientKey)
+		if err != nil {
+			t.Fatalf("Failed to decrypt: %v", err)
+		}
+		if header["cty"] != "JWT" {
+			t.Errorf("Expected cty JWT for a nested token, got %v", header)
+		}
+		verify(t, string(plaintext))
+	})
+
+	t.Run("Response content type", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		respondJWT(w, 200, "a.b.c")
+		if w.Header().Get("Content-Type") != JWTContentType || w.Body.String() != "a.b.c" {
+			t.Errorf("Unexpected response %q %q", w.Header().Get("Content-Type"), w.Body.String())
+		}
+	})
+}
+
+func TestEncryptIDToken(t *testing.T) {
+	serverKey, err := utils.GenerateRSAKeyPair(2048)
+	if err != nil {
+		t.Fatalf("Failed to generate server key: %v", err)
+	}
+	clientKey, err := utils.GenerateRSAKeyPair(2048)
+	if err != nil {
+		t.Fatalf("Failed to generate client key: %v", err)
+	}
+	jwks := &models.JSONWebKeySet{Keys: []models.JSONWebKey{{
+		Kty: "RSA",
+		Kid: "encryption",
+		N:   base64.RawURLEncoding.EncodeToString(clientKey.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(clientKey.E)).Bytes()),
+	}}}
+	idToken, err := utils.GenerateIDToken("user-1", "client-1", map[string]interface{}{}, serverKey, 3600)
+	if err != nil {
+		t.Fatalf("Failed to generate ID token: %v", err)
+	}
+
+	t.Run("Not registered", func(t *testing.T) {
+		token, err := encryptIDToken(&models.Client{ClientID: "client-1", JWKS: jwks}, idToken)
+		if err != nil || token != idToken {
+			t.Errorf("Expected the signed ID token unchanged, got %q, %v", token, err)
+		}
+	})
+
+	t.Run("Signed then encrypted", func(t *testing.T) {
+		client := &models.Client{ClientID: "client-1", IDTokenEncryptedAlg: "RSA-OAEP-256", JWKS: jwks}
+		token, err := encryptIDToken(client, idToken)
+		if err != nil {
+			t.Fatalf("Failed to encrypt: %v", err)
+		}
+		header, plaintext, err := utils.DecryptClientJWE(token, clientKey)
+		if err != nil {
+			t.Fatalf("Failed to decrypt with the client key: %v", err)
+		}
+		if header["cty"] != "JWT" || header["kid"] != "encryption" || header["enc"] != utils.DefaultJWEContentEnc {
+			t.Errorf("Unexpected header %v", header)
+		}
+		if string(plaintext) != idToken {
+			t.Error("Expected the signed ID token as plaintext")
+		}
+		var claims map[string]interface{}
+		if err := utils.DecryptJWE(token, serverKey, &claims); err == nil {
+			t.Error("Expected the server key not to decrypt a token for the client")
+		}
+	})
+
+	t.Run("Missing key", func(t *testing.T) {
+		client := &models.Client{ClientID: "client-1", IDTokenEncryptedAlg: "RSA-OAEP-256"}
+		if _, err := encryptIDToken(client, idToken); err == nil {
+			t.Error("Expected an error without a registered key")
+		}
+	})
+}
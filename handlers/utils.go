@@ -2,21 +2,99 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"net"
 	"net/http"
+	"oauth2-server/config"
+	"oauth2-server/middleware"
 	"oauth2-server/models"
+	"oauth2-server/utils"
+	"strings"
+	"time"
 )
 
+// respondClientDisabled reports that a suspended client attempted to
+// authorize or obtain tokens
+func respondClientDisabled(w http.ResponseWriter, status int) {
+	respondError(w, status, "client_disabled", "Client has been disabled")
+}
+
+// redirectURIAllowed checks a requested redirect URI against the client's
+// registration. In production mode wildcard matching falls back to exact
+// matching and confidential clients are only redirected over https, which
+// also covers clients registered before production mode was turned on.
+func redirectURIAllowed(client *models.Client, redirectURI string, cfg *config.Config) bool {
+	mode := client.RedirectMatching
+	if cfg.ProductionMode {
+		if client.ClientSecret != "" && !strings.HasPrefix(redirectURI, "https://") {
+			return false
+		}
+		if mode == models.RedirectMatchWildcard {
+			mode = models.RedirectMatchExact
+		}
+	}
+	return utils.RedirectURIAllowed(redirectURI, client.RedirectURIs, mode)
+}
+
+// resolveScope validates the scope a client requested, falling back to
+// defaultScope when none was. Strict clients get an error for unknown or
+// unauthorized scopes; lenient clients have them dropped and get the rest,
+// failing only when nothing remains.
+func resolveScope(client *models.Client, requested, defaultScope string) (string, error) {
+	scope := requested
+	if scope == "" {
+		scope = defaultScope
+	} else if client.ScopeMode != models.ScopeModeLenient {
+		if err := utils.GlobalScopeValidator.ValidateScope(scope); err != nil {
+			return "", err
+		}
+	}
+	// Normalizing also drops unknown scopes for lenient clients
+	scope = utils.NormalizeScope(scope)
+	if scope == "" {
+		return "", errors.New("none of the requested scopes are supported")
+	}
+
+	if client.ScopeMode != models.ScopeModeLenient || len(client.AllowedScopes) == 0 {
+		if err := utils.GlobalScopeValidator.ValidateScopeAgainstAllowed(scope, client.AllowedScopes); err != nil {
+			return "", err
+		}
+		return scope, nil
+	}
+
+	scope = utils.IntersectScopes(scope, strings.Join(client.AllowedScopes, " "))
+	if scope == "" {
+		return "", errors.New("none of the requested scopes are allowed for this client")
+	}
+	return scope, nil
+}
+
+// clientIP returns the address of the caller without its port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// requestFingerprint is the soft fingerprint of the request's network and
+// user agent
+func requestFingerprint(r *http.Request) string {
+	return utils.SoftFingerprint(clientIP(r), r.UserAgent())
+}
+
 func respondJSON(w http.ResponseWriter, status int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(data)
 }
 
-func respondError(w http.ResponseWriter, status int, error, description string) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(models.ErrorResponse{
-		Error:            error,
-		ErrorDescription: description,
-	})
+// sessionActivity is the SSO session idle timeout and activity throttle
+// configured for the server
+func sessionActivity(cfg *config.Config) middleware.SessionActivity {
+	return middleware.NewSessionActivity(
+		time.Duration(cfg.SSOIdleTimeout)*time.Second,
+		time.Duration(cfg.SSOActivityInterval)*time.Second,
+	)
 }
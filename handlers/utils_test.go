@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"oauth2-server/config"
+	"oauth2-server/models"
+	"oauth2-server/utils"
+	"testing"
+)
+
+func TestRespondClientDisabled(t *testing.T) {
+	w := httptest.NewRecorder()
+	respondClientDisabled(w, http.StatusUnauthorized)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401, got %d", w.Code)
+	}
+
+	var resp models.ErrorResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Error != "client_disabled" {
+		t.Errorf("Expected error 'client_disabled', got %q", resp.Error)
+	}
+}
+
+func TestRedirectURIAllowed_ProductionMode(t *testing.T) {
+	confidential := &models.Client{
+		ClientSecret:     "secret",
+		RedirectURIs:     []string{"http://app.example.com/cb", "https://*.dev.example.com/cb"},
+		RedirectMatching: models.RedirectMatchWildcard,
+	}
+	dev := &config.Config{}
+	production := &config.Config{ProductionMode: true}
+
+	if !redirectURIAllowed(confidential, "http://app.example.com/cb", dev) || !redirectURIAllowed(confidential, "https://pr-1.dev.example.com/cb", dev) {
+		t.Error("Expected both redirect URIs to be allowed outside production mode")
+	}
+	if redirectURIAllowed(confidential, "http://app.example.com/cb", production) {
+		t.Error("Expected an http redirect URI to be refused for a confidential client in production mode")
+	}
+	if redirectURIAllowed(confidential, "https://pr-1.dev.example.com/cb", production) {
+		t.Error("Expected wildcard matching to be off in production mode")
+	}
+
+	public := &models.Client{RedirectURIs: []string{"http://127.0.0.1/cb"}}
+	if !redirectURIAllowed(public, "http://127.0.0.1:50123/cb", production) {
+		t.Error("Expected a public client's loopback redirect URI to be allowed in production mode")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := map[string]string{
+		"192.0.2.1:1234":   "192.0.2.1",
+		"[2001:db8::1]:80": "2001:db8::1",
+		"192.0.2.1":        "192.0.2.1",
+	}
+	for remoteAddr, want := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+		if got := clientIP(req); got != want {
+			t.Errorf("clientIP(%q) = %q, want %q", remoteAddr, got, want)
+		}
+	}
+}
+
+func TestResolveScope(t *testing.T) {
+	strict := &models.Client{AllowedScopes: []string{"openid", "profile"}}
+	lenient := &models.Client{AllowedScopes: []string{"openid", "profile"}, ScopeMode: models.ScopeModeLenient}
+
+	tests := []struct {
+		name      string
+		client    *models.Client
+		requested string
+		want      string
+		wantErr   bool
+	}{
+		{"strict default", strict, "", "", true},
+		{"strict allowed", strict, "openid profile", "openid profile", false},
+		{"strict unknown scope", strict, "openid unknown_scope", "", true},
+		{"strict unauthorized scope", strict, "openid email", "", true},
+		{"lenient drops unknown and unauthorized", lenient, "openid unknown_scope email profile", "openid profile", false},
+		{"lenient nothing left", lenient, "email unknown_scope", "", true},
+		{"lenient without restrictions", &models.Client{ScopeMode: models.ScopeModeLenient}, "openid email unknown_scope", "openid email", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveScope(tt.client, tt.requested, utils.GetDefaultScope())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveScope() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("resolveScope() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
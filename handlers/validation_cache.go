@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// validationCache remembers recent results of /token/validate by token
+// hash, so resource servers that validate every request do not repeat the
+// signature check and revocation lookup. Results expire after the cache TTL
+// or with the token, whichever is first; the least recently used result is
+// evicted once size are held. A token revoked meanwhile is reported valid
+// until its result expires.
+type validationCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[[sha256.Size]byte]*list.Element
+	lru     *list.List // of *validationCacheEntry, most recently used first
+
+	hits      int64
+	misses    int64
+	evictions int64
+	now       func() time.Time
+}
+
+type validationCacheEntry struct {
+	key       [sha256.Size]byte
+	result    validatedToken
+	expiresAt time.Time
+}
+
+func newValidationCache(size int, ttl time.Duration) *validationCache {
+	return &validationCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[[sha256.Size]byte]*list.Element, size),
+		lru:     list.New(),
+		now:     time.Now,
+	}
+}
+
+// validationCacheKey hashes the token with the kind it was validated as, so
+// tokens are never held in memory
+func validationCacheKey(token, tokenType string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(tokenType + "\x00" + token))
+}
+
+func (c *validationCache) get(key [sha256.Size]byte) (validatedToken, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if ok && c.now().Before(elem.Value.(*validationCacheEntry).expiresAt) {
+		c.hits++
+		c.lru.MoveToFront(elem)
+		return elem.Value.(*validationCacheEntry).result, true
+	}
+	if ok {
+		c.remove(elem)
+	}
+	c.misses++
+	return validatedToken{}, false
+}
+
+// put caches a result until the TTL ends, or until tokenExpiry when that is
+// sooner and not zero
+func (c *validationCache) put(key [sha256.Size]byte, result validatedToken, tokenExpiry time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expiresAt := c.now().Add(c.ttl)
+	if !tokenExpiry.IsZero() && tokenExpiry.Before(expiresAt) {
+		expiresAt = tokenExpiry
+	}
+	entry := &validationCacheEntry{key: key, result: result, expiresAt: expiresAt}
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+		c.evictions++
+	}
+}
+
+func (c *validationCache) remove(elem *list.Element) {
+	delete(c.entries, elem.Value.(*validationCacheEntry).key)
+	c.lru.Remove(elem)
+}
+
+// writePrometheus writes the cache counters in the Prometheus text
+// exposition format
+func (c *validationCache) writePrometheus(w io.Writer) {
+	c.mu.Lock()
+	size, hits, misses, evictions := c.lru.Len(), c.hits, c.misses, c.evictions
+	c.mu.Unlock()
+
+	metric := func(name, kind, help string, value interface{}) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, value)
+	}
+	metric("oauth_token_validation_cache_size", "gauge", "Validation results held in the cache.", size)
+	metric("oauth_token_validation_cache_hits_total", "counter", "Token validations answered from the cache.", hits)
+	metric("oauth_token_validation_cache_misses_total", "counter", "Token validations that were not cached.", misses)
+	metric("oauth_token_validation_cache_evictions_total", "counter", "Validation results evicted to keep the cache within its size.", evictions)
+}
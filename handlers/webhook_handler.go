@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"oauth2-server/models"
+	"oauth2-server/repository"
+	"oauth2-server/utils"
+	"oauth2-server/webhook"
+	"slices"
+
+	"github.com/gorilla/mux"
+)
+
+// auditWebhookEvents maps audit events to the webhook event published for them
+var auditWebhookEvents = map[string]string{
+	models.AuditUserCreated:     webhook.EventUserCreated,
+	models.AuditConsentRevoked:  webhook.EventConsentRevoked,
+	models.AuditSessionRevoked:  webhook.EventSessionRevoked,
+	models.AuditTokenIssued:     webhook.EventTokenIssued,
+	models.AuditLoginFailure:    webhook.EventLoginFailed,
+	models.AuditLoginUnfamiliar: webhook.EventLoginUnfamiliar,
+}
+
+// publishWebhook publishes the webhook event for an audit event, if it has one
+func publishWebhook(webhooks *webhook.Dispatcher, auditEvent, userID, clientID string, details map[string]string) {
+	eventType, ok := auditWebhookEvents[auditEvent]
+	if !ok || webhooks == nil {
+		return
+	}
+	webhooks.Publish(&webhook.Event{
+		Type:     eventType,
+		UserID:   userID,
+		ClientID: clientID,
+		Data:     details,
+	})
+}
+
+// WebhookHandler lets administrators register webhooks for security events
+type WebhookHandler struct {
+	webhookRepo *repository.WebhookRepository
+}
+
+func NewWebhookHandler(webhookRepo *repository.WebhookRepository) *WebhookHandler {
+	return &WebhookHandler{
+		webhookRepo: webhookRepo,
+	}
+}
+
+// WebhookRequest is the body for registering a webhook
+type WebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+// validate checks the URL and that every event is one webhooks can receive
+func (req *WebhookRequest) validate() string {
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return "url must be an absolute http or https URL"
+	}
+	if len(req.Events) == 0 {
+		return "At least one event is required"
+	}
+	for _, event := range req.Events {
+		if !slices.Contains(webhook.EventTypes, event) {
+			return "Unsupported event: " + event
+		}
+	}
+	return ""
+}
+
+// ListWebhooks returns every registered webhook without its secret
+// GET /admin/webhooks
+func (h *WebhookHandler) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	hooks, err := h.webhookRepo.FindAll(r.Context())
+	if err != nil {
+		respondInternalError(w, err, "Failed to list webhooks")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"webhooks":         hooks,
+		"events_supported": webhook.EventTypes,
+	})
+}
+
+// CreateWebhook registers a webhook and returns its signing secret, which is
+// not shown again
+// POST /admin/webhooks
+func (h *WebhookHandler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var req WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+	if msg := req.validate(); msg != "" {
+		respondError(w, http.StatusBadRequest, "invalid_request", msg)
+		return
+	}
+
+	id, err := utils.GenerateRandomString(16)
+	if err != nil {
+		respondInternalError(w, err, "Failed to create webhook")
+		return
+	}
+	secret, err := utils.GenerateRandomString(32)
+	if err != nil {
+		respondInternalError(w, err, "Failed to create webhook")
+		return
+	}
+
+	hook := &models.Webhook{
+		ID:     id,
+		URL:    req.URL,
+		Events: req.Events,
+		Secret: secret,
+	}
+	if err := h.webhookRepo.Create(r.Context(), hook); err != nil {
+		respondInternalError(w, err, "Failed to create webhook")
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, map[string]interface{}{
+		"id":         hook.ID,
+		"url":        hook.URL,
+		"events":     hook.Events,
+		"secret":     hook.Secret,
+		"created_at": hook.CreatedAt,
+	})
+}
+
+// DeleteWebhook stops deliveries to a webhook
+// DELETE /admin/webhooks/{id}
+func (h *WebhookHandler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if err := h.webhookRepo.Delete(r.Context(), mux.Vars(r)["id"]); err != nil {
+		respondRepositoryError(w, err, "Webhook", "Failed to delete webhook")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"oauth2-server/webhook"
+	"testing"
+)
+
+func TestWebhookRequestValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		req   WebhookRequest
+		valid bool
+	}{
+		{"valid", WebhookRequest{URL: "https://siem.example.com/hook", Events: []string{webhook.EventLoginFailed}}, true},
+		{"relative URL", WebhookRequest{URL: "/hook", Events: []string{webhook.EventLoginFailed}}, false},
+		{"unsupported scheme", WebhookRequest{URL: "ftp://example.com", Events: []string{webhook.EventLoginFailed}}, false},
+		{"no events", WebhookRequest{URL: "https://example.com"}, false},
+		{"unknown event", WebhookRequest{URL: "https://example.com", Events: []string{"user.deleted"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if msg := tt.req.validate(); (msg == "") != tt.valid {
+				t.Errorf("validate() = %q, want valid=%v", msg, tt.valid)
+			}
+		})
+	}
+}
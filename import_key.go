@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"oauth2-server/utils"
+	"os"
+	"path/filepath"
+)
+
+// runImportKey implements `oauth2-server import-key`. It installs a private
+// key exported from another identity provider as the signing key, so tokens
+// that provider issued keep verifying after migrating its issuer here. The
+// key is used from the next server start.
+func runImportKey(args []string) error {
+	flags := flag.NewFlagSet("import-key", flag.ContinueOnError)
+	keyFile := flags.String("key", "", "PEM or JWK file holding the RSA private key")
+	kid := flags.String("kid", "", "key ID to publish (default: the JWK kid, else the key thumbprint)")
+	force := flags.Bool("force", false, "replace the current signing key, keeping it as .bak")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *keyFile == "" {
+		return errors.New("-key is required")
+	}
+
+	data, err := os.ReadFile(*keyFile)
+	if err != nil {
+		return err
+	}
+
+	imported, err := importKey(keysDir, data, *kid, *force)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Imported signing key with kid %s; restart the server to use it", imported)
+	return nil
+}
+
+// importKey writes the key to dir as the active signing key and returns the
+// kid it was stored under. An existing key is only replaced with force.
+func importKey(dir string, data []byte, kid string, force bool) (string, error) {
+	privateKey, jwkKid, err := utils.ImportPrivateKey(data)
+	if err != nil {
+		return "", err
+	}
+	if kid == "" {
+		kid = jwkKid
+	}
+	if kid == "" {
+		kid = utils.KeyThumbprint(&privateKey.PublicKey)
+	}
+
+	privateKeyPath := filepath.Join(dir, utils.PrivateKeyFile)
+	if _, err := os.Stat(privateKeyPath); err == nil && !force {
+		return "", fmt.Errorf("%s already exists; pass -force to replace it", privateKeyPath)
+	}
+
+	// A replaced key is kept so the migration can be rolled back
+	if err := utils.ReplaceSigningKey(dir, privateKey, kid); err != nil {
+		return "", err
+	}
+	return kid, nil
+}
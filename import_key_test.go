@@ -0,0 +1,52 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"oauth2-server/utils"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestImportKey(t *testing.T) {
+	dir := t.TempDir()
+	privateKey, err := utils.GenerateRSAKeyPair(2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
+
+	kid, err := importKey(dir, data, "migrated", false)
+	if err != nil {
+		t.Fatalf("Failed to import key: %v", err)
+	}
+	if kid != "migrated" {
+		t.Errorf("Expected kid migrated, got %s", kid)
+	}
+
+	loaded, err := utils.LoadPrivateKeyFromFile(filepath.Join(dir, utils.PrivateKeyFile))
+	if err != nil || !loaded.Equal(privateKey) {
+		t.Fatalf("Expected the imported key to be saved, got %v", err)
+	}
+	storedKid, _ := os.ReadFile(filepath.Join(dir, utils.KeyIDFile))
+	if strings.TrimSpace(string(storedKid)) != "migrated" {
+		t.Errorf("Expected stored kid migrated, got %q", storedKid)
+	}
+
+	// An existing key is only replaced on request, and kept as a backup
+	if _, err := importKey(dir, data, "second", false); err == nil {
+		t.Error("Expected import over an existing key to fail without force")
+	}
+	kid, err = importKey(dir, data, "", true)
+	if err != nil {
+		t.Fatalf("Failed to replace key: %v", err)
+	}
+	if kid != utils.KeyThumbprint(&privateKey.PublicKey) {
+		t.Errorf("Expected the thumbprint as default kid, got %s", kid)
+	}
+	if _, err := os.Stat(filepath.Join(dir, utils.PrivateKeyFile+".bak")); err != nil {
+		t.Error("Expected the replaced key to be backed up")
+	}
+}
@@ -0,0 +1,203 @@
+package jwks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+// Cache fetches the key sets clients publish at their jwks_uri and keeps
+// them for reuse, so verifying a client's signature does not call the client
+// back on every request
+type Cache struct {
+	client      *http.Client
+	maxAge      time.Duration
+	minRefresh  time.Duration
+	maxAttempts int
+	backoff     time.Duration
+	now         func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*entry
+}
+
+type entry struct {
+	mu        sync.Mutex // one fetch of a key set at a time
+	keys      *jose.JSONWebKeySet
+	expiresAt time.Time
+	checkedAt time.Time // last fetch attempt, successful or not
+	err       error     // why the last fetch failed
+}
+
+// NewCache creates a cache that keeps a key set for at most maxAge, or less
+// when the response's Cache-Control max-age says so, and fetches a key set
+// again at most once per minRefresh. Each fetch is tried up to maxAttempts
+// times, waiting backoff before the first retry and doubling the wait after
+// each one.
+func NewCache(timeout, maxAge, minRefresh time.Duration, maxAttempts int, backoff time.Duration) *Cache {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	return &Cache{
+		client:      &http.Client{Timeout: timeout},
+		maxAge:      maxAge,
+		minRefresh:  minRefresh,
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
+		now:         time.Now,
+		entries:     map[string]*entry{},
+	}
+}
+
+// Key returns the signing key with the given ID from the key set at uri, or
+// its first signing key when kid is empty. A key ID the cached set does not
+// have fetches the set again, so a rotated key is picked up; requests with
+// made-up key IDs cannot make the cache call the client more often than
+// once per minRefresh.
+func (c *Cache) Key(ctx context.Context, uri, kid string) (*jose.JSONWebKey, error) {
+	e := c.entry(uri)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.keys == nil || !c.now().Before(e.expiresAt) {
+		if err := c.refresh(ctx, uri, e); err != nil {
+			return nil, err
+		}
+	}
+	if key := signingKey(e.keys, kid); key != nil {
+		return key, nil
+	}
+	if c.now().Sub(e.checkedAt) >= c.minRefresh {
+		if err := c.refresh(ctx, uri, e); err != nil {
+			return nil, err
+		}
+		if key := signingKey(e.keys, kid); key != nil {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+func (c *Cache) entry(uri string) *entry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[uri]
+	if !ok {
+		e = &entry{}
+		c.entries[uri] = e
+	}
+	return e
+}
+
+// refresh fetches the key set unless the last attempt failed less than
+// minRefresh ago, in which case that failure is returned again. Keys whose
+// lifetime has ended are dropped when the fetch fails.
+func (c *Cache) refresh(ctx context.Context, uri string, e *entry) error {
+	now := c.now()
+	if e.err != nil && now.Sub(e.checkedAt) < c.minRefresh {
+		return e.err
+	}
+	e.checkedAt = now
+
+	keys, maxAge, err := c.fetch(ctx, uri)
+	if err != nil {
+		e.err = fmt.Errorf("fetching JWKS: %w", err)
+		if !now.Before(e.expiresAt) {
+			e.keys = nil
+		}
+		return e.err
+	}
+	if maxAge < 0 || maxAge > c.maxAge {
+		maxAge = c.maxAge
+	}
+	if maxAge < c.minRefresh {
+		maxAge = c.minRefresh
+	}
+	e.keys, e.expiresAt, e.err = keys, now.Add(maxAge), nil
+	return nil
+}
+
+// fetch downloads the key set, retrying network errors, rate limiting and
+// server errors. maxAge is the response's Cache-Control max-age, or -1.
+func (c *Cache) fetch(ctx context.Context, uri string) (*jose.JSONWebKeySet, time.Duration, error) {
+	wait := c.backoff
+	for attempt := 1; ; attempt++ {
+		keys, maxAge, retry, err := c.get(ctx, uri)
+		if err == nil {
+			return keys, maxAge, nil
+		}
+		if !retry || attempt >= c.maxAttempts {
+			return nil, 0, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, 0, ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// get makes one attempt and reports whether a failure is worth retrying
+func (c *Cache) get(ctx context.Context, uri string) (*jose.JSONWebKeySet, time.Duration, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, 0, ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("status %d", resp.StatusCode)
+		return nil, 0, resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, 0, true, err
+	}
+	var keys jose.JSONWebKeySet
+	if err := json.Unmarshal(body, &keys); err != nil {
+		return nil, 0, false, err
+	}
+	if len(keys.Keys) == 0 {
+		return nil, 0, false, errors.New("key set is empty")
+	}
+	return &keys, cacheMaxAge(resp.Header.Get("Cache-Control")), false, nil
+}
+
+// cacheMaxAge returns the max-age of a Cache-Control header, or -1
+func cacheMaxAge(header string) time.Duration {
+	for _, directive := range strings.Split(header, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		if !strings.EqualFold(name, "max-age") {
+			continue
+		}
+		if seconds, err := strconv.Atoi(strings.Trim(value, `"`)); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	return -1
+}
+
+// signingKey finds a public key in the set not reserved for encryption
+func signingKey(keys *jose.JSONWebKeySet, kid string) *jose.JSONWebKey {
+	for i, key := range keys.Keys {
+		if (kid == "" || key.KeyID == kid) && key.Use != "enc" && key.IsPublic() && key.Valid() {
+			return &keys.Keys[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package jwks
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+// keyServer publishes the keys in kids, failing the first failures requests
+func keyServer(t *testing.T, kids *[]string, failures int) (*httptest.Server, *int32) {
+	t.Helper()
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n := atomic.AddInt32(&requests, 1); int(n) <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var set jose.JSONWebKeySet
+		for _, kid := range *kids {
+			set.Keys = append(set.Keys, jose.JSONWebKey{Key: &privateKey.PublicKey, KeyID: kid, Algorithm: "RS256", Use: "sig"})
+		}
+		w.Header().Set("Cache-Control", "public, max-age=600")
+		json.NewEncoder(w).Encode(set)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestCache_RefetchesOnUnknownKeyID(t *testing.T) {
+	kids := []string{"key-1"}
+	server, requests := keyServer(t, &kids, 0)
+	cache := NewCache(time.Second, time.Hour, time.Minute, 1, 0)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	if key, err := cache.Key(ctx, server.URL, "key-1"); err != nil || key.KeyID != "key-1" {
+		t.Fatalf("Expected key-1, got %v, %v", key, err)
+	}
+	if _, err := cache.Key(ctx, server.URL, ""); err != nil || *requests != 1 {
+		t.Fatalf("Expected the cached set to be used, got %v after %d requests", err, *requests)
+	}
+
+	// A rotated key is not fetched again until the refresh interval passed
+	kids = append(kids, "key-2")
+	if _, err := cache.Key(ctx, server.URL, "key-2"); err == nil || *requests != 1 {
+		t.Fatalf("Expected an unknown key without a new request, got %v after %d requests", err, *requests)
+	}
+	now = now.Add(time.Minute)
+	if key, err := cache.Key(ctx, server.URL, "key-2"); err != nil || key.KeyID != "key-2" || *requests != 2 {
+		t.Fatalf("Expected key-2 after a refresh, got %v, %v after %d requests", key, err, *requests)
+	}
+
+	// The set expires with the response's max-age
+	now = now.Add(10 * time.Minute)
+	cache.Key(ctx, server.URL, "key-1")
+	if *requests != 3 {
+		t.Errorf("Expected the set to be fetched again after max-age, got %d requests", *requests)
+	}
+}
+
+func TestCache_RetriesServerErrors(t *testing.T) {
+	kids := []string{"key-1"}
+	server, requests := keyServer(t, &kids, 2)
+
+	if _, err := NewCache(time.Second, time.Hour, time.Minute, 2, time.Millisecond).Key(context.Background(), server.URL, "key-1"); err == nil {
+		t.Fatal("Expected the fetch to give up after 2 attempts")
+	}
+	atomic.StoreInt32(requests, 0)
+	if _, err := NewCache(time.Second, time.Hour, time.Minute, 3, time.Millisecond).Key(context.Background(), server.URL, "key-1"); err != nil || *requests != 3 {
+		t.Errorf("Expected the third attempt to succeed, got %v after %d requests", err, *requests)
+	}
+}
+
+func TestCacheMaxAge(t *testing.T) {
+	tests := map[string]time.Duration{
+		"max-age=300":            5 * time.Minute,
+		"public, max-age=\"60\"": time.Minute,
+		"no-store":               -1,
+		"":                       -1,
+	}
+	for header, want := range tests {
+		if got := cacheMaxAge(header); got != want {
+			t.Errorf("cacheMaxAge(%q) = %v, want %v", header, got, want)
+		}
+	}
+}
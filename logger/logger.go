@@ -177,7 +177,9 @@ func (l *Logger) Detail(level LogLevel, actionInfo ActionInfo, data interface{},
 		Action:            actionInfo.Action,
 		ActionDescription: actionInfo.ActionDescription,
 		SubAction:         actionInfo.SubAction,
-		Message:           dataToString(data),
+		TransactionID:     l.transactionID,
+		SessionID:         l.sessionID,
+		Message:           dataToString(maskedData),
 		Metadata: map[string]interface{}{
 			"data": maskedData,
 		},
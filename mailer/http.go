@@ -0,0 +1,64 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// HTTPMailer hands emails to a transactional email API as a JSON POST of
+// from, to, subject and text
+type HTTPMailer struct {
+	url           string
+	authorization string
+	from          string
+	client        *http.Client
+}
+
+// NewHTTPMailer creates a mailer posting to url. authorization, when set, is
+// sent as the Authorization header, e.g. "Bearer <api key>".
+func NewHTTPMailer(url, authorization, from string, timeout time.Duration) *HTTPMailer {
+	return &HTTPMailer{
+		url:           url,
+		authorization: authorization,
+		from:          from,
+		client:        &http.Client{Timeout: timeout},
+	}
+}
+
+func (m *HTTPMailer) Send(ctx context.Context, to, subject, body string) error {
+	if err := checkHeaders(to, subject); err != nil {
+		return err
+	}
+	payload, err := json.Marshal(map[string]string{
+		"from":    m.from,
+		"to":      to,
+		"subject": subject,
+		"text":    body,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if m.authorization != "" {
+		req.Header.Set("Authorization", m.authorization)
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("mail API responded with %d", resp.StatusCode)
+	}
+	return nil
+}
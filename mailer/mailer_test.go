@@ -0,0 +1,60 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogMailer_DoesNotLogBody(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	NewLogMailer().Send(context.Background(), "user@example.com", "Your sign-in code", "Your code is 123456")
+	if strings.Contains(buf.String(), "123456") {
+		t.Errorf("Expected the body to stay out of the log, got %q", buf.String())
+	}
+	if !strings.Contains(buf.String(), "user@example.com") {
+		t.Errorf("Expected the recipient in the log, got %q", buf.String())
+	}
+}
+
+func TestHTTPMailer(t *testing.T) {
+	var received map[string]string
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer server.Close()
+
+	m := NewHTTPMailer(server.URL, "Bearer api-key", "no-reply@example.com", time.Second)
+	if err := m.Send(context.Background(), "user@example.com", "Verify", "Open this link"); err != nil {
+		t.Fatalf("Expected the email to be accepted: %v", err)
+	}
+	if authorization != "Bearer api-key" || received["from"] != "no-reply@example.com" || received["to"] != "user@example.com" || received["text"] != "Open this link" {
+		t.Errorf("Unexpected request %v with %q", received, authorization)
+	}
+
+	if err := m.Send(context.Background(), "user@example.com\r\nBcc: attacker@example.com", "Verify", "body"); err == nil {
+		t.Error("Expected a recipient with a line break to be rejected")
+	}
+}
+
+func TestMessage(t *testing.T) {
+	msg := string(message("no-reply@example.com", "user@example.com", "ยืนยันอีเมล", "body"))
+	if !strings.Contains(msg, "Subject: =?utf-8?q?") {
+		t.Errorf("Expected an encoded subject, got %q", msg)
+	}
+	if !strings.HasSuffix(msg, "\r\n\r\nbody\r\n") {
+		t.Errorf("Expected the body after the headers, got %q", msg)
+	}
+}
@@ -0,0 +1,108 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"time"
+)
+
+// SMTPMailer sends emails through an SMTP relay. The connection is upgraded
+// with STARTTLS whenever the relay offers it, and credentials are only sent
+// over TLS or to a relay on localhost.
+type SMTPMailer struct {
+	addr     string
+	host     string
+	username string
+	password string
+	from     string
+}
+
+// NewSMTPMailer creates a mailer sending from the from address through the
+// relay at addr (host:port). username and password may be empty for a relay
+// that does not require authentication.
+func NewSMTPMailer(addr, username, password, from string) (*SMTPMailer, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP address %q: %v", addr, err)
+	}
+	return &SMTPMailer{
+		addr:     addr,
+		host:     host,
+		username: username,
+		password: password,
+		from:     from,
+	}, nil
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, to, subject, body string) error {
+	if err := checkHeaders(to, subject); err != nil {
+		return err
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", m.addr)
+	if err != nil {
+		return err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(30 * time.Second)
+	}
+	conn.SetDeadline(deadline)
+
+	client, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: m.host, MinVersion: tls.VersionTLS12}); err != nil {
+			return err
+		}
+	}
+	if m.username != "" {
+		// PlainAuth refuses to send the password without TLS, except to localhost
+		if err := client.Auth(smtp.PlainAuth("", m.username, m.password, m.host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(m.from); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message(m.from, to, subject, body)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// message builds a plain text UTF-8 email
+func message(from, to, subject, body string) []byte {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	msg.WriteString(body)
+	msg.WriteString("\r\n")
+	return msg.Bytes()
+}
@@ -3,297 +3,258 @@ package main
 import (
 	"context"
 	"crypto/rsa"
+	"crypto/x509"
+	"errors"
 	"log"
-	"net/http"
+	"net"
 	"oauth2-server/config"
 	"oauth2-server/database"
-	"oauth2-server/handlers"
-	"oauth2-server/logger"
-	"oauth2-server/middleware"
 	"oauth2-server/repository"
+	"oauth2-server/router"
 	"oauth2-server/utils"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"time"
-
-	"github.com/gorilla/mux"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// CORS middleware
-func corsMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Allow requests from React dev server
-		origin := r.Header.Get("Origin")
-		if origin == "" {
-			origin = "*"
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "import-key" {
+		if err := runImportKey(os.Args[2:]); err != nil {
+			log.Fatalf("Failed to import key: %v", err)
 		}
-
-		w.Header().Set("Access-Control-Allow-Origin", origin)
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Accept, X-Requested-With")
-		w.Header().Set("Access-Control-Allow-Credentials", "true")
-		w.Header().Set("Access-Control-Max-Age", "3600")
-
-		// Handle preflight requests
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "bootstrap" {
+		if err := runBootstrap(os.Args[2:]); err != nil {
+			log.Fatalf("Failed to bootstrap: %v", err)
 		}
+		return
+	}
 
-		next.ServeHTTP(w, r)
-	})
-}
-
-func main() {
 	cfg := config.Load()
+	utils.TokenIssuer = cfg.IssuerURL
+	if err := setPasswordHashing(cfg); err != nil {
+		log.Fatalf("Invalid password hashing policy: %v", err)
+	}
 
-	privateKey, publicKey, err := loadOrGenerateKeys()
+	privateKey, publicKey, keyID, err := loadOrGenerateKeys()
 	if err != nil {
 		log.Fatalf("Failed to load keys: %v", err)
 	}
 	cfg.PrivateKey = privateKey
 	cfg.PublicKey = publicKey
+	utils.SigningKeyID = keyID
+	encryptionKey, encryptionKeyID, err := loadOrGenerateEncryptionKey()
+	if err != nil {
+		log.Fatalf("Failed to load encryption key: %v", err)
+	}
+	cfg.EncryptionKey = encryptionKey
+	cfg.EncryptionKeyID = encryptionKeyID
+	utils.EncryptionKeyID = encryptionKeyID
+	utils.AcceptLegacyJWE = cfg.JWEAcceptLegacy
+	if cfg.AcceptUntypedTokens {
+		// Every token issued from now on is typed
+		utils.UntypedTokensIssuedBefore = time.Now()
+	}
 
-	db, err := database.Connect(cfg.MongoURI, cfg.DatabaseName)
+	if cfg.MTLSClientCAFile != "" {
+		pemData, err := os.ReadFile(cfg.MTLSClientCAFile)
+		if err != nil {
+			log.Fatalf("Failed to read mTLS client CA file: %v", err)
+		}
+		cfg.MTLSClientCAs = x509.NewCertPool()
+		if !cfg.MTLSClientCAs.AppendCertsFromPEM(pemData) {
+			log.Fatalf("No certificates found in %s", cfg.MTLSClientCAFile)
+		}
+	}
+
+	db, err := database.Connect(cfg.MongoURI, cfg.DatabaseName, time.Duration(cfg.DBOperationTimeout)*time.Second, database.PoolOptions{
+		MaxSize:     uint64(max(cfg.DBMaxPoolSize, 0)),
+		MinSize:     uint64(max(cfg.DBMinPoolSize, 0)),
+		MaxIdleTime: time.Duration(cfg.DBMaxConnIdleTime) * time.Second,
+	})
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
-	defer db.Close()
-
 	log.Println("Connected to MongoDB successfully")
 
-	if err := createIndexes(db.DB); err != nil {
+	// SIGINT and SIGTERM stop the background jobs and drain the server
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := database.CreateIndexes(db.DB); err != nil {
 		log.Fatalf("Failed to create indexes: %v", err)
 	}
+	if verified, err := repository.NewUserRepository(db.DB).MarkLegacyEmailsVerified(ctx); err != nil {
+		log.Fatalf("Failed to mark existing accounts verified: %v", err)
+	} else if verified > 0 {
+		log.Printf("Marked %d accounts created before email verification as verified", verified)
+	}
 
-	userRepo := repository.NewUserRepository(db.DB)
-	clientRepo := repository.NewClientRepository(db.DB)
-	authCodeRepo := repository.NewAuthCodeRepository(db.DB)
-	sessionRepo := repository.NewSessionRepository(db.DB)
-	ssoSessionRepo := repository.NewSSOSessionRepository(db.DB)
-	consentRepo := repository.NewUserConsentRepository(db.DB)
-
-	authHandler := handlers.NewAuthHandler(userRepo, clientRepo, authCodeRepo, sessionRepo, ssoSessionRepo, cfg)
-	oauthHandler := handlers.NewOAuthHandler(userRepo, clientRepo, authCodeRepo, sessionRepo, consentRepo, cfg)
-	clientHandler := handlers.NewClientHandler(clientRepo, utils.GlobalScopeRegistry, utils.GlobalScopeValidator)
-	discoveryHandler := handlers.NewDiscoveryHandler("http://localhost:"+cfg.ServerPort, utils.GlobalScopeRegistry)
-	jwksHandler := handlers.NewJWKSHandler(publicKey)
-	tokenExchangeHandler := handlers.NewTokenExchangeHandler(userRepo, clientRepo, cfg)
-	tokenValidationHandler := handlers.NewTokenValidationHandler(cfg)
-	consentHandler := handlers.NewConsentHandler(clientRepo, consentRepo, authCodeRepo, sessionRepo, cfg)
-	sessionHandler := handlers.NewSessionHandler(ssoSessionRepo, consentRepo, clientRepo, cfg)
-
-	r := mux.NewRouter()
-
-	// Add CORS middleware
-	r.Use(corsMiddleware)
-	r.Use(func(h http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			pCtx := r.Context()
-			csLog := logger.NewLoggerWithConfig("auth-server", "1.0.0", &logger.LoggerConfig{
-				Detail: logger.LogOutputConfig{
-					Path:    "logs/detail",
-					Console: true,
-					File:    true,
-				},
-				Summary: logger.LogOutputConfig{
-					Path:    "logs/summary",
-					Console: true,
-					File:    true,
-				},
-			})
-
-			pCtx = context.WithValue(pCtx, "logger", csLog)
-			r = r.WithContext(pCtx)
-
-			// set the new context with logger
-
-			h.ServeHTTP(w, r)
-		})
-	})
+	if cfg.BootstrapAdminEmail != "" {
+		if err := bootstrap(ctx, repository.NewUserRepository(db.DB), repository.NewClientRepository(db.DB), cfg, os.Stdout); err != nil {
+			log.Fatalf("Failed to bootstrap admin: %v", err)
+		}
+	}
 
-	r.HandleFunc("/.well-known/openid-configuration", discoveryHandler.WellKnown).Methods("GET")
-	r.HandleFunc("/.well-known/jwks.json", jwksHandler.JWKS).Methods("GET")
+	if cfg.VerifyIndexes {
+		database.VerifyIndexUsage(db.DB)
+	}
 
-	r.HandleFunc("/auth/register", authHandler.ShowRegister).Methods("GET")
-	r.HandleFunc("/auth/register", authHandler.Register).Methods("POST", "OPTIONS")
-	r.HandleFunc("/auth/login", authHandler.ShowLogin).Methods("GET")
-	r.HandleFunc("/auth/login", authHandler.Login).Methods("POST", "OPTIONS")
-	r.HandleFunc("/auth/logout", authHandler.Logout).Methods("POST", "OPTIONS")
+	endpoints, err := router.NewHandlers(cfg, db.DB, keyID)
+	if err != nil {
+		log.Fatalf("Failed to set up handlers: %v", err)
+	}
+	endpoints.Start(ctx, cfg)
+	r := router.New(cfg, endpoints)
 
-	// Apply SSO middleware to authorization and consent endpoints
-	r.Handle("/oauth/authorize", middleware.SSOMiddleware(ssoSessionRepo)(http.HandlerFunc(oauthHandler.Authorize))).Methods("GET", "OPTIONS")
-	r.Handle("/oauth/consent", middleware.SSOMiddleware(ssoSessionRepo)(http.HandlerFunc(consentHandler.ShowConsent))).Methods("GET", "OPTIONS")
-	r.Handle("/oauth/consent", middleware.SSOMiddleware(ssoSessionRepo)(http.HandlerFunc(consentHandler.HandleConsent))).Methods("POST", "OPTIONS")
-	r.HandleFunc("/oauth/token", oauthHandler.Token).Methods("POST", "OPTIONS")
-	r.HandleFunc("/oauth/userinfo", oauthHandler.UserInfo).Methods("GET", "OPTIONS")
+	log.Printf("OAuth2 Server starting on port %s", cfg.ServerPort)
+	log.Printf("Issuing tokens as %s", cfg.IssuerURL)
+	log.Printf("Using RS256 for JWT signing")
+	if len(cfg.CORSAllowedOrigins) > 0 {
+		log.Printf("CORS enabled with credentials for %s", strings.Join(cfg.CORSAllowedOrigins, ", "))
+	}
 
-	r.HandleFunc("/token/exchange", tokenExchangeHandler.HandleTokenExchange).Methods("POST", "OPTIONS")
-	r.HandleFunc("/token/validate", tokenValidationHandler.ValidateToken).Methods("GET", "POST", "OPTIONS")
+	server := newServer(cfg, r)
+	ln, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		log.Fatalf("Failed to listen on port %s: %v", cfg.ServerPort, err)
+	}
+	if cfg.TLSCertFile != "" {
+		log.Printf("TLS enabled, requesting client certificates for mTLS")
+	}
+	grpcDone, err := startGRPC(ctx, cfg, endpoints)
+	if err != nil {
+		log.Fatalf("Failed to start gRPC server: %v", err)
+	}
 
-	r.HandleFunc("/clients/register", clientHandler.RegisterClient).Methods("POST", "OPTIONS")
+	exitCode := 0
+	if err := serve(ctx, server, ln, cfg.TLSCertFile, cfg.TLSKeyFile, time.Duration(cfg.ShutdownTimeout)*time.Second); err != nil {
+		log.Printf("Server stopped with error: %v", err)
+		exitCode = 1
+	} else {
+		log.Println("Server stopped, in-flight requests drained")
+	}
+	// Stops the gRPC server too when the HTTP server failed
+	stop()
+	if err := <-grpcDone; err != nil {
+		log.Printf("gRPC server stopped with error: %v", err)
+		exitCode = 1
+	}
 
-	// Session management endpoints
-	r.HandleFunc("/account/sessions", sessionHandler.ListSessions).Methods("GET", "OPTIONS")
-	r.HandleFunc("/account/sessions/{session_id}", sessionHandler.RevokeSession).Methods("DELETE", "OPTIONS")
+	if err := db.Close(); err != nil {
+		log.Printf("Failed to close database connection: %v", err)
+		exitCode = 1
+	}
+	os.Exit(exitCode)
+}
 
-	// Authorization management endpoints
-	r.HandleFunc("/account/authorizations", sessionHandler.ListAuthorizations).Methods("GET", "OPTIONS")
-	r.HandleFunc("/account/authorizations/{client_id}", sessionHandler.RevokeAuthorization).Methods("DELETE", "OPTIONS")
+const (
+	// keysDir holds the signing key files, relative to the working directory
+	keysDir = "keys"
 
-	r.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
-	}).Methods("GET")
+	// legacyKeyID is the kid published before key IDs were recorded, kept
+	// for key pairs generated without a kid file
+	legacyKeyID = "1"
+)
 
-	log.Printf("OAuth2 Server starting on port %s", cfg.ServerPort)
-	log.Printf("Using RS256 for JWT signing")
-	log.Printf("CORS enabled for development")
-	log.Fatal(http.ListenAndServe(":"+cfg.ServerPort, r))
+// setPasswordHashing makes new password hashes follow the configured
+// algorithm and costs
+func setPasswordHashing(cfg *config.Config) error {
+	hasher := utils.PasswordHasher{
+		Algorithm:        cfg.PasswordHashAlgorithm,
+		BcryptCost:       int(cfg.PasswordBcryptCost),
+		Argon2Memory:     uint32(cfg.PasswordArgon2Memory),
+		Argon2Iterations: uint32(cfg.PasswordArgon2Iterations),
+		Argon2Threads:    uint8(cfg.PasswordArgon2Threads),
+	}
+	if cfg.PasswordArgon2Threads > 255 {
+		return errors.New("at most 255 argon2 threads are supported")
+	}
+	if err := hasher.Validate(); err != nil {
+		return err
+	}
+	utils.PasswordHashing = hasher
+	return nil
 }
 
-func loadOrGenerateKeys() (*rsa.PrivateKey, *rsa.PublicKey, error) {
-	privateKeyPath := "keys/private.pem"
-	publicKeyPath := "keys/public.pem"
+func loadOrGenerateKeys() (*rsa.PrivateKey, *rsa.PublicKey, string, error) {
+	privateKeyPath := filepath.Join(keysDir, utils.PrivateKeyFile)
+	publicKeyPath := filepath.Join(keysDir, utils.PublicKeyFile)
 
 	if _, err := os.Stat(privateKeyPath); os.IsNotExist(err) {
 		log.Println("Generating new RSA key pair...")
 
-		if err := os.MkdirAll("keys", 0700); err != nil {
-			return nil, nil, err
-		}
-
 		privateKey, err := utils.GenerateRSAKeyPair(2048)
 		if err != nil {
-			return nil, nil, err
-		}
-
-		if err := utils.SavePrivateKeyToFile(privateKey, privateKeyPath); err != nil {
-			return nil, nil, err
+			return nil, nil, "", err
 		}
 
-		if err := utils.SavePublicKeyToFile(&privateKey.PublicKey, publicKeyPath); err != nil {
-			return nil, nil, err
+		kid := utils.KeyThumbprint(&privateKey.PublicKey)
+		if err := utils.SaveSigningKey(keysDir, privateKey, kid); err != nil {
+			return nil, nil, "", err
 		}
 
 		log.Println("RSA key pair generated and saved")
-		return privateKey, &privateKey.PublicKey, nil
+		return privateKey, &privateKey.PublicKey, kid, nil
 	}
 
 	log.Println("Loading existing RSA key pair...")
 	privateKey, err := utils.LoadPrivateKeyFromFile(privateKeyPath)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, "", err
 	}
 
 	publicKey, err := utils.LoadPublicKeyFromFile(publicKeyPath)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, "", err
 	}
 
-	log.Println("RSA key pair loaded successfully")
-	return privateKey, publicKey, nil
-}
-
-func createIndexes(db *mongo.Database) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	usersCollection := db.Collection("users")
-	_, err := usersCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys:    bson.D{{Key: "email", Value: 1}},
-		Options: options.Index().SetUnique(true),
-	})
-	if err != nil {
-		return err
-	}
-
-	clientsCollection := db.Collection("clients")
-	_, err = clientsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys:    bson.D{{Key: "client_id", Value: 1}},
-		Options: options.Index().SetUnique(true),
-	})
-	if err != nil {
-		return err
-	}
-
-	authCodesCollection := db.Collection("auth_codes")
-	_, err = authCodesCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys:    bson.D{{Key: "code", Value: 1}},
-		Options: options.Index().SetUnique(true),
-	})
-	if err != nil {
-		return err
+	kid := legacyKeyID
+	if data, err := os.ReadFile(filepath.Join(keysDir, utils.KeyIDFile)); err == nil && strings.TrimSpace(string(data)) != "" {
+		kid = strings.TrimSpace(string(data))
 	}
 
-	_, err = authCodesCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys:    bson.D{{Key: "expires_at", Value: 1}},
-		Options: options.Index().SetExpireAfterSeconds(0),
-	})
-	if err != nil {
-		return err
-	}
+	log.Printf("RSA key pair loaded successfully (kid %s)", kid)
+	return privateKey, publicKey, kid, nil
+}
 
-	sessionsCollection := db.Collection("sessions")
-	_, err = sessionsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys:    bson.D{{Key: "session_id", Value: 1}},
-		Options: options.Index().SetUnique(true),
-	})
-	if err != nil {
-		return err
-	}
+// loadOrGenerateEncryptionKey loads the key pair JWE tokens are encrypted to,
+// generating it on first start. Tokens encrypted to the signing key before
+// then stay readable while JWE_ACCEPT_SIGNING_KEY is set.
+func loadOrGenerateEncryptionKey() (*rsa.PrivateKey, string, error) {
+	privateKeyPath := filepath.Join(keysDir, utils.EncryptionKeyFile)
 
-	_, err = sessionsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys:    bson.D{{Key: "expires_at", Value: 1}},
-		Options: options.Index().SetExpireAfterSeconds(0),
-	})
-	if err != nil {
-		return err
-	}
+	if _, err := os.Stat(privateKeyPath); os.IsNotExist(err) {
+		log.Println("Generating new RSA encryption key...")
 
-	// SSO Sessions indexes
-	ssoSessionsCollection := db.Collection("sso_sessions")
-	_, err = ssoSessionsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys:    bson.D{{Key: "session_id", Value: 1}},
-		Options: options.Index().SetUnique(true),
-	})
-	if err != nil {
-		return err
-	}
+		privateKey, err := utils.GenerateRSAKeyPair(2048)
+		if err != nil {
+			return nil, "", err
+		}
 
-	_, err = ssoSessionsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys: bson.D{{Key: "user_id", Value: 1}},
-	})
-	if err != nil {
-		return err
-	}
+		kid := utils.KeyThumbprint(&privateKey.PublicKey)
+		if err := utils.SaveEncryptionKey(keysDir, privateKey, kid); err != nil {
+			return nil, "", err
+		}
 
-	_, err = ssoSessionsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys: bson.D{{Key: "expires_at", Value: 1}},
-	})
-	if err != nil {
-		return err
+		log.Println("RSA encryption key generated and saved")
+		return privateKey, kid, nil
 	}
 
-	// User Consents indexes
-	userConsentsCollection := db.Collection("user_consents")
-	_, err = userConsentsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "client_id", Value: 1}},
-		Options: options.Index().SetUnique(true),
-	})
+	privateKey, err := utils.LoadPrivateKeyFromFile(privateKeyPath)
 	if err != nil {
-		return err
+		return nil, "", err
 	}
 
-	_, err = userConsentsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys: bson.D{{Key: "user_id", Value: 1}},
-	})
-	if err != nil {
-		return err
+	kid := utils.KeyThumbprint(&privateKey.PublicKey)
+	if data, err := os.ReadFile(filepath.Join(keysDir, utils.EncryptionKeyIDFile)); err == nil && strings.TrimSpace(string(data)) != "" {
+		kid = strings.TrimSpace(string(data))
 	}
 
-	log.Println("Database indexes created successfully")
-	return nil
+	log.Printf("RSA encryption key loaded successfully (kid %s)", kid)
+	return privateKey, kid, nil
 }
@@ -0,0 +1,194 @@
+package middleware
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"oauth2-server/models"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// limiterBackoff is the factor the limit shrinks by when a request is
+	// slower than the target latency
+	limiterBackoff = 0.9
+
+	// limiterRetryAfter is the Retry-After, in seconds, sent with a shed
+	// request
+	limiterRetryAfter = 1
+)
+
+// ConcurrencyLimiter bounds how many requests a handler serves at once and
+// queues the rest for a while before shedding them with a 503. The limit
+// adapts to the handler's latency: it grows by about one per round of
+// requests served within the target latency and shrinks by a tenth on every
+// slower one, so a struggling database is given less work until it recovers.
+type ConcurrencyLimiter struct {
+	mu       sync.Mutex
+	limit    float64
+	min, max float64
+	target   time.Duration
+
+	inFlight     int
+	queue        *list.List // of *limiterWaiter, oldest first
+	queueSize    int
+	queueTimeout time.Duration
+
+	served int64
+	shed   int64
+	now    func() time.Time
+}
+
+type limiterWaiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+// NewConcurrencyLimiter starts at maxInFlight and never goes below one
+// request in flight. Up to queueSize requests wait at most queueTimeout for
+// a slot.
+func NewConcurrencyLimiter(maxInFlight, queueSize int, queueTimeout, target time.Duration) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{
+		limit:        float64(maxInFlight),
+		min:          1,
+		max:          float64(maxInFlight),
+		target:       target,
+		queue:        list.New(),
+		queueSize:    queueSize,
+		queueTimeout: queueTimeout,
+		now:          time.Now,
+	}
+}
+
+// Middleware admits requests within the current limit, queues the rest and
+// answers those that cannot be served in time with 503 temporarily_unavailable
+func (l *ConcurrencyLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !l.acquire(r) {
+			respondShed(w)
+			return
+		}
+
+		start := l.now()
+		defer func() { l.release(l.now().Sub(start)) }()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// acquire takes a slot, waiting in the queue if none is free. It reports
+// false when the queue is full, the wait times out or the client goes away.
+func (l *ConcurrencyLimiter) acquire(r *http.Request) bool {
+	l.mu.Lock()
+	if l.inFlight < l.slots() && l.queue.Len() == 0 {
+		l.inFlight++
+		l.mu.Unlock()
+		return true
+	}
+	if l.queue.Len() >= l.queueSize {
+		l.shed++
+		l.mu.Unlock()
+		return false
+	}
+	waiter := &limiterWaiter{ready: make(chan struct{})}
+	element := l.queue.PushBack(waiter)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(l.queueTimeout)
+	defer timer.Stop()
+	select {
+	case <-waiter.ready:
+		return true
+	case <-timer.C:
+	case <-r.Context().Done():
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if waiter.granted {
+		// A slot was handed over just as the wait ended
+		return true
+	}
+	l.queue.Remove(element)
+	l.shed++
+	return false
+}
+
+// release frees a slot, adapts the limit to how long the request took and
+// hands free slots to queued requests
+func (l *ConcurrencyLimiter) release(elapsed time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inFlight--
+	l.served++
+	if elapsed > l.target {
+		l.limit = max(l.min, l.limit*limiterBackoff)
+	} else {
+		l.limit = min(l.max, l.limit+1/l.limit)
+	}
+
+	for l.inFlight < l.slots() && l.queue.Len() > 0 {
+		waiter := l.queue.Remove(l.queue.Front()).(*limiterWaiter)
+		waiter.granted = true
+		l.inFlight++
+		close(waiter.ready)
+	}
+}
+
+func (l *ConcurrencyLimiter) slots() int {
+	return int(l.limit)
+}
+
+// LimiterStats is a snapshot of the limiter
+type LimiterStats struct {
+	Limit    int   `json:"limit"`
+	InFlight int   `json:"in_flight"`
+	Queued   int   `json:"queued"`
+	Served   int64 `json:"served"`
+	Shed     int64 `json:"shed"`
+}
+
+// Stats returns the current limit and load
+func (l *ConcurrencyLimiter) Stats() LimiterStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return LimiterStats{
+		Limit:    l.slots(),
+		InFlight: l.inFlight,
+		Queued:   l.queue.Len(),
+		Served:   l.served,
+		Shed:     l.shed,
+	}
+}
+
+// WritePrometheus writes the limiter state, labelled with route, in the
+// Prometheus text exposition format
+func (l *ConcurrencyLimiter) WritePrometheus(w io.Writer, route string) {
+	stats := l.Stats()
+	metric := func(name, kind, help string, value interface{}) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s{route=%q} %v\n", name, help, name, kind, name, route, value)
+	}
+	metric("oauth_concurrency_limit", "gauge", "Current adaptive limit on requests in flight.", stats.Limit)
+	metric("oauth_concurrency_in_flight", "gauge", "Requests being served.", stats.InFlight)
+	metric("oauth_concurrency_queued", "gauge", "Requests waiting for a slot.", stats.Queued)
+	metric("oauth_concurrency_shed_total", "counter", "Requests rejected with 503 because of load.", stats.Shed)
+}
+
+// respondShed tells the client to retry shortly
+func respondShed(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(limiterRetryAfter))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(models.ErrorResponse{
+		Error:            "temporarily_unavailable",
+		ErrorDescription: "The server is overloaded, retry later",
+	})
+}
@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimiter_QueuesAndSheds(t *testing.T) {
+	limiter := NewConcurrencyLimiter(1, 1, time.Second, time.Hour)
+
+	started := make(chan struct{}, 2)
+	unblock := make(chan struct{})
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-unblock
+	}))
+
+	codes := make(chan int, 2)
+	var wg sync.WaitGroup
+	serve := func() {
+		defer wg.Done()
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "/oauth/token", nil))
+		codes <- w.Code
+	}
+
+	wg.Add(1)
+	go serve()
+	<-started
+	wg.Add(1)
+	go serve()
+	waitFor(t, func() bool { return limiter.Stats().Queued == 1 })
+
+	// The slot and the queue are taken, so a third request is shed
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/oauth/token", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected 503 with Retry-After, got %d %v", w.Code, w.Header())
+	}
+	if !strings.Contains(w.Body.String(), "temporarily_unavailable") {
+		t.Errorf("Expected temporarily_unavailable, got %s", w.Body.String())
+	}
+
+	// Preflight requests are never limited
+	preflight := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	w = httptest.NewRecorder()
+	preflight.ServeHTTP(w, httptest.NewRequest("OPTIONS", "/oauth/token", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected preflight to pass, got %d", w.Code)
+	}
+
+	close(unblock)
+	wg.Wait()
+	close(codes)
+	for code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("Expected the queued request to be served, got %d", code)
+		}
+	}
+	if stats := limiter.Stats(); stats.Served != 2 || stats.Shed != 1 || stats.InFlight != 0 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestConcurrencyLimiter_QueueTimeout(t *testing.T) {
+	limiter := NewConcurrencyLimiter(1, 1, 10*time.Millisecond, time.Hour)
+	if !limiter.acquire(httptest.NewRequest("POST", "/oauth/token", nil)) {
+		t.Fatal("Expected a free slot")
+	}
+	if limiter.acquire(httptest.NewRequest("POST", "/oauth/token", nil)) {
+		t.Error("Expected the queued request to time out")
+	}
+	if stats := limiter.Stats(); stats.Queued != 0 || stats.Shed != 1 {
+		t.Errorf("Expected the timed out request to leave the queue, got %+v", stats)
+	}
+}
+
+func TestConcurrencyLimiter_AdaptsToLatency(t *testing.T) {
+	limiter := NewConcurrencyLimiter(10, 0, time.Second, 100*time.Millisecond)
+
+	// Slow requests shrink the limit, but never below one
+	for i := 0; i < 50; i++ {
+		limiter.inFlight++
+		limiter.release(time.Second)
+	}
+	if limit := limiter.Stats().Limit; limit != 1 {
+		t.Errorf("Expected the limit to fall to 1, got %d", limit)
+	}
+
+	// Fast requests grow it back, up to the configured maximum
+	for i := 0; i < 100; i++ {
+		limiter.inFlight++
+		limiter.release(time.Millisecond)
+	}
+	if limit := limiter.Stats().Limit; limit != 10 {
+		t.Errorf("Expected the limit to recover to 10, got %d", limit)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
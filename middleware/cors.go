@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+// Request and response headers browsers may use across origins
+const (
+	corsAllowMethods  = "GET, POST, PUT, DELETE, OPTIONS"
+	corsAllowHeaders  = "Authorization, Content-Type, Accept, DPoP, X-Requested-With, X-CSRF-Token"
+	corsExposeHeaders = "WWW-Authenticate, DPoP-Nonce, Retry-After"
+	corsMaxAge        = 3600
+)
+
+// CORSPolicy decides which other origins a browser may call the server from
+type CORSPolicy struct {
+	// AllowedOrigins may call every endpoint, with cookies
+	AllowedOrigins []string
+	// PublicOrigins may call PublicPaths without cookies; "*" allows any
+	// origin. These are the endpoints single-page apps talk to directly.
+	PublicOrigins []string
+	PublicPaths   []string
+}
+
+// CORS answers preflight requests and adds CORS headers for origins the
+// policy allows. Other origins get no CORS headers, so browsers keep their
+// pages from reading the response.
+func CORS(policy CORSPolicy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin != "" {
+				w.Header().Add("Vary", "Origin")
+				policy.allow(w.Header(), origin, r.URL.Path)
+			}
+
+			if r.Method == http.MethodOptions {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (p CORSPolicy) allow(h http.Header, origin, path string) {
+	credentials := slices.Contains(p.AllowedOrigins, origin)
+	if !credentials {
+		if !slices.Contains(p.PublicPaths, path) {
+			return
+		}
+		if !slices.Contains(p.PublicOrigins, "*") && !slices.Contains(p.PublicOrigins, origin) {
+			return
+		}
+	}
+
+	h.Set("Access-Control-Allow-Origin", origin)
+	if credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	h.Set("Access-Control-Allow-Methods", corsAllowMethods)
+	h.Set("Access-Control-Allow-Headers", corsAllowHeaders)
+	h.Set("Access-Control-Expose-Headers", corsExposeHeaders)
+	h.Set("Access-Control-Max-Age", strconv.Itoa(corsMaxAge))
+}
@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORS(t *testing.T) {
+	handler := CORS(CORSPolicy{
+		AllowedOrigins: []string{"https://app.example.com"},
+		PublicOrigins:  []string{"*"},
+		PublicPaths:    []string{"/oauth/token"},
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		origin      string
+		allowed     bool
+		credentials bool
+		status      int
+	}{
+		{"Trusted origin", "GET", "/account/sessions", "https://app.example.com", true, true, http.StatusOK},
+		{"Public endpoint", "POST", "/oauth/token", "https://spa.example.net", true, false, http.StatusOK},
+		{"Other endpoint", "GET", "/account/sessions", "https://spa.example.net", false, false, http.StatusOK},
+		{"Preflight", "OPTIONS", "/oauth/token", "https://spa.example.net", true, false, http.StatusNoContent},
+		{"Rejected preflight", "OPTIONS", "/admin/users", "https://spa.example.net", false, false, http.StatusNoContent},
+		{"Same origin", "GET", "/oauth/token", "", false, false, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, w.Code)
+			}
+			allowOrigin := w.Header().Get("Access-Control-Allow-Origin")
+			if tt.allowed && allowOrigin != tt.origin {
+				t.Errorf("Expected Access-Control-Allow-Origin %q, got %q", tt.origin, allowOrigin)
+			}
+			if !tt.allowed && allowOrigin != "" {
+				t.Errorf("Expected no CORS headers, got %q", allowOrigin)
+			}
+			if credentials := w.Header().Get("Access-Control-Allow-Credentials") == "true"; credentials != tt.credentials {
+				t.Errorf("Expected credentials %v, got %v", tt.credentials, credentials)
+			}
+		})
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	csp := "default-src 'self'; frame-ancestors 'none'"
+	handler := SecurityHeaders(31536000, csp)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/oauth/consent", nil))
+
+	for header, want := range map[string]string{
+		"Strict-Transport-Security": "max-age=31536000; includeSubDomains",
+		"X-Content-Type-Options":    "nosniff",
+		"Content-Security-Policy":   csp,
+		"X-Frame-Options":           "DENY",
+	} {
+		if got := w.Header().Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+
+	// Framing allowed by the policy and HSTS turned off
+	handler = SecurityHeaders(0, "frame-ancestors https://portal.example.com")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/oauth/consent", nil))
+	if w.Header().Get("X-Frame-Options") != "" || w.Header().Get("Strict-Transport-Security") != "" {
+		t.Errorf("Unexpected headers %v", w.Header())
+	}
+}
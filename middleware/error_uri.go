@@ -0,0 +1,43 @@
+package middleware
+
+import "net/http"
+
+// ErrorURIMiddleware exposes base, a page documenting the error codes, on the
+// response writer so error responses can link to base#<code> in error_uri
+// (RFC 6749 section 5.2). An empty base leaves error_uri out.
+func ErrorURIMiddleware(base string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if base == "" {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(&errorURIResponseWriter{ResponseWriter: w, base: base}, r)
+		})
+	}
+}
+
+// ErrorURIOf returns the error_uri for an error code from a response writer
+// created by ErrorURIMiddleware, or "" without one. Writers wrapped around it
+// by later middleware are unwrapped.
+func ErrorURIOf(w http.ResponseWriter, code string) string {
+	for {
+		switch rw := w.(type) {
+		case *errorURIResponseWriter:
+			return rw.base + "#" + code
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = rw.Unwrap()
+		default:
+			return ""
+		}
+	}
+}
+
+type errorURIResponseWriter struct {
+	http.ResponseWriter
+	base string
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *errorURIResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
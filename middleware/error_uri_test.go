@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestErrorURIOf_ThroughWrappedWriter(t *testing.T) {
+	var got string
+	handler := ErrorURIMiddleware("https://docs.example.com/errors")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = ErrorURIOf(&statusResponseWriter{ResponseWriter: w}, "invalid_request")
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/oauth/token", nil))
+	if got != "https://docs.example.com/errors#invalid_request" {
+		t.Errorf("Expected error_uri for the code, got %q", got)
+	}
+
+	handler = ErrorURIMiddleware("")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = ErrorURIOf(w, "invalid_request")
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/oauth/token", nil))
+	if got != "" {
+		t.Errorf("Expected no error_uri without a base, got %q", got)
+	}
+}
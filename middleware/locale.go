@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"oauth2-server/utils"
+)
+
+const (
+	// LocaleCookieName remembers the ui_locales choice across the login and
+	// consent pages that follow the authorization request
+	LocaleCookieName = "oauth_ui_locale"
+
+	// LocaleContextKey is the context key for storing the negotiated locale
+	LocaleContextKey = "locale"
+)
+
+// LocaleMiddleware negotiates the response locale from the ui_locales
+// parameter, the locale cookie and Accept-Language, in that order. The locale
+// is added to the request context and exposed on the response writer so
+// error responses can be translated without access to the request.
+func LocaleMiddleware(catalog *utils.MessageCatalog) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			uiLocales := r.URL.Query().Get("ui_locales")
+			if cookie, err := r.Cookie(LocaleCookieName); err == nil && uiLocales == "" {
+				uiLocales = cookie.Value
+			}
+
+			locale := catalog.Negotiate(utils.ParseLocales(uiLocales, r.Header.Get("Accept-Language")))
+
+			// Keep an explicit ui_locales choice for the rest of the flow
+			if r.URL.Query().Get("ui_locales") != "" {
+				http.SetCookie(w, &http.Cookie{
+					Name:     LocaleCookieName,
+					Value:    locale,
+					Path:     "/",
+					HttpOnly: true,
+					SameSite: http.SameSiteLaxMode,
+				})
+			}
+
+			ctx := context.WithValue(r.Context(), LocaleContextKey, locale)
+			next.ServeHTTP(&localeResponseWriter{ResponseWriter: w, locale: locale}, r.WithContext(ctx))
+		})
+	}
+}
+
+// LocaleFromContext returns the negotiated locale, or the default locale when
+// the middleware did not run
+func LocaleFromContext(ctx context.Context) string {
+	if locale, ok := ctx.Value(LocaleContextKey).(string); ok {
+		return locale
+	}
+	return utils.DefaultLocale
+}
+
+// LocaleOf returns the locale carried by a response writer created by
+// LocaleMiddleware, or the default locale. Writers wrapped around it by later
+// middleware are unwrapped.
+func LocaleOf(w http.ResponseWriter) string {
+	for {
+		switch rw := w.(type) {
+		case *localeResponseWriter:
+			return rw.locale
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = rw.Unwrap()
+		default:
+			return utils.DefaultLocale
+		}
+	}
+}
+
+type localeResponseWriter struct {
+	http.ResponseWriter
+	locale string
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *localeResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
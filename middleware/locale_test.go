@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"oauth2-server/utils"
+	"testing"
+)
+
+func TestLocaleOf_ThroughWrappedWriter(t *testing.T) {
+	catalog := utils.NewMessageCatalog()
+	catalog.Register("th", map[string]string{})
+
+	var got string
+	handler := LocaleMiddleware(catalog)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Later middleware, such as SLO tracking, wraps the writer again
+		got = LocaleOf(&statusResponseWriter{ResponseWriter: w})
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/auth/login?ui_locales=th", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if got != "th" {
+		t.Errorf("Expected locale th, got %q", got)
+	}
+
+	if locale := LocaleOf(httptest.NewRecorder()); locale != utils.DefaultLocale {
+		t.Errorf("Expected the default locale without the middleware, got %q", locale)
+	}
+}
@@ -0,0 +1,185 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"oauth2-server/logger"
+	"oauth2-server/utils"
+	"strings"
+)
+
+const (
+	// LoggerContextKey is the context key for the request's logger, read by
+	// mlog.L in handlers
+	LoggerContextKey = "logger"
+
+	// TransactionIDHeader and SessionIDHeader carry the IDs a caller wants
+	// the request logged under; the transaction ID is echoed in the response
+	TransactionIDHeader = "X-Transaction-ID"
+	SessionIDHeader     = "X-Session-ID"
+
+	// maxLoggedBody is the largest request body copied into the inbound log
+	maxLoggedBody = 64 << 10
+)
+
+// sensitiveFields are form, query and JSON fields whose values never reach
+// the logs
+var sensitiveFields = []string{
+	"password", "new_password", "current_password",
+	"client_secret", "client_assertion",
+	"code", "code_verifier", "device_code", "user_code",
+	"token", "access_token", "refresh_token", "id_token", "id_token_hint",
+	"subject_token", "actor_token", "session",
+}
+
+// RequestMaskingRules masks credentials in the inbound request log entry
+var RequestMaskingRules = buildRequestMaskingRules()
+
+func buildRequestMaskingRules() []logger.MaskingRule {
+	rules := []logger.MaskingRule{
+		{Field: "headers.Authorization", Type: logger.MaskingTypeFull},
+		{Field: "headers.Cookie", Type: logger.MaskingTypeFull},
+		{Field: "body.email", Type: logger.MaskingTypeEmail},
+	}
+	for _, field := range sensitiveFields {
+		rules = append(rules,
+			logger.MaskingRule{Field: "body." + field, Type: logger.MaskingTypeFull},
+			logger.MaskingRule{Field: "query." + field, Type: logger.MaskingTypeFull},
+		)
+	}
+	return rules
+}
+
+// RequestLogger gives every request its own logger transaction. The inbound
+// request is logged with credentials masked, and a summary with the method,
+// path, status and duration is flushed once the handler returns.
+func RequestLogger(service, version string, config *logger.LoggerConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			transactionID := r.Header.Get(TransactionIDHeader)
+			if transactionID == "" {
+				transactionID, _ = utils.GenerateRandomString(16)
+			}
+
+			l := logger.NewLoggerWithConfig(service, version, config)
+			l.StartTransaction(transactionID, r.Header.Get(SessionIDHeader))
+			l.Info(logger.ActionInfo{
+				Action:            "inbound",
+				ActionDescription: r.Method + " " + r.URL.Path,
+			}, inboundData(r), RequestMaskingRules...)
+
+			w.Header().Set(TransactionIDHeader, transactionID)
+			sw := &statusResponseWriter{ResponseWriter: w}
+			ctx := context.WithValue(r.Context(), LoggerContextKey, l)
+			next.ServeHTTP(sw, r.WithContext(ctx))
+
+			status := sw.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			l.AddMetadata("method", r.Method)
+			l.AddMetadata("path", r.URL.Path)
+			l.AddMetadata("ip_address", r.RemoteAddr)
+			l.AddMetadata("user_agent", r.UserAgent())
+			if status >= http.StatusInternalServerError {
+				l.FlushError(status, http.StatusText(status))
+			} else {
+				l.Flush(status, http.StatusText(status))
+			}
+		})
+	}
+}
+
+// inboundData describes the request for the inbound log entry. Form and JSON
+// bodies are included; the body is restored for the handler.
+func inboundData(r *http.Request) map[string]interface{} {
+	headers := make(map[string]string, len(r.Header))
+	for key, values := range r.Header {
+		headers[key] = strings.Join(values, ", ")
+	}
+
+	data := map[string]interface{}{
+		"method":  r.Method,
+		"path":    r.URL.Path,
+		"query":   flattenValues(r.URL.Query()),
+		"headers": headers,
+	}
+	if body := readBody(r); body != nil {
+		data["body"] = body
+	}
+	return data
+}
+
+func readBody(r *http.Request) interface{} {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/x-www-form-urlencoded" && mediaType != "application/json" {
+		return nil
+	}
+
+	buf, err := io.ReadAll(io.LimitReader(r.Body, maxLoggedBody+1))
+	r.Body = readCloser{io.MultiReader(bytes.NewReader(buf), r.Body), r.Body}
+	if err != nil || len(buf) > maxLoggedBody {
+		return nil
+	}
+
+	if mediaType == "application/json" {
+		var body interface{}
+		if json.Unmarshal(buf, &body) != nil {
+			return nil
+		}
+		return body
+	}
+	form, err := url.ParseQuery(string(buf))
+	if err != nil {
+		return nil
+	}
+	return flattenValues(form)
+}
+
+// flattenValues turns url.Values into single strings so masking rules can
+// address each field directly
+func flattenValues(values url.Values) map[string]string {
+	flat := make(map[string]string, len(values))
+	for key, vs := range values {
+		flat[key] = strings.Join(vs, ",")
+	}
+	return flat
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// statusResponseWriter remembers the status code written by the handler
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *statusResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"oauth2-server/logger"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readLogs(t *testing.T, dir string) []logger.DetailLog {
+	t.Helper()
+	files, _ := filepath.Glob(filepath.Join(dir, "*.log"))
+	var logs []logger.DetailLog
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("Failed to read log: %v", err)
+		}
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			var entry logger.DetailLog
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				t.Fatalf("Invalid log line %q: %v", line, err)
+			}
+			logs = append(logs, entry)
+		}
+	}
+	return logs
+}
+
+func TestRequestLogger_MasksCredentialsAndFlushesSummary(t *testing.T) {
+	dir := t.TempDir()
+	config := &logger.LoggerConfig{
+		Detail:  logger.LogOutputConfig{Path: dir + "/detail/", File: true},
+		Summary: logger.LogOutputConfig{Path: dir + "/summary/", File: true},
+	}
+
+	var handlerBody string
+	var hasLogger bool
+	handler := RequestLogger("test", "1.0.0", config)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, hasLogger = r.Context().Value(LoggerContextKey).(*logger.Logger)
+		body, _ := io.ReadAll(r.Body)
+		handlerBody = string(body)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+
+	form := "grant_type=authorization_code&code=secret-code&client_secret=s3cr3t&client_id=app"
+	req := httptest.NewRequest("POST", "/oauth/token?token=query-token", strings.NewReader(form))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer header-token")
+	req.Header.Set(TransactionIDHeader, "txn-1")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if handlerBody != form {
+		t.Errorf("Expected the handler to read the full body, got %q", handlerBody)
+	}
+	if !hasLogger {
+		t.Error("Expected the request logger in the handler context")
+	}
+	if got := w.Header().Get(TransactionIDHeader); got != "txn-1" {
+		t.Errorf("Expected transaction ID txn-1 in the response, got %q", got)
+	}
+
+	details := readLogs(t, dir+"/detail")
+	if len(details) != 1 {
+		t.Fatalf("Expected 1 detail log, got %d", len(details))
+	}
+	raw, _ := json.Marshal(details[0])
+	for _, secret := range []string{"secret-code", "s3cr3t", "query-token", "header-token"} {
+		if strings.Contains(string(raw), secret) {
+			t.Errorf("Detail log leaks %q: %s", secret, raw)
+		}
+	}
+	if !strings.Contains(string(raw), "authorization_code") {
+		t.Errorf("Expected non-sensitive fields to be logged: %s", raw)
+	}
+
+	summaries := readLogs(t, dir+"/summary")
+	if len(summaries) != 1 {
+		t.Fatalf("Expected 1 summary log, got %d", len(summaries))
+	}
+	summary := summaries[0]
+	if summary.StatusCode != http.StatusBadRequest || summary.TransactionID != "txn-1" {
+		t.Errorf("Unexpected summary status %d, transaction %q", summary.StatusCode, summary.TransactionID)
+	}
+	if summary.Metadata["method"] != "POST" || summary.Metadata["path"] != "/oauth/token" {
+		t.Errorf("Unexpected summary metadata: %v", summary.Metadata)
+	}
+}
+
+func TestRequestLogger_GeneratesTransactionID(t *testing.T) {
+	config := &logger.LoggerConfig{}
+	handler := RequestLogger("test", "1.0.0", config)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	if w.Header().Get(TransactionIDHeader) == "" {
+		t.Error("Expected a generated transaction ID")
+	}
+}
@@ -0,0 +1,255 @@
+package middleware
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// sloShortWindow is the window the fast burn rate is measured over, next
+	// to the configured long window
+	sloShortWindow = 5 * time.Minute
+
+	// sloFastBurnRate is the burn rate that, sustained over both windows,
+	// spends a 30 day error budget in about two days
+	sloFastBurnRate = 14.4
+)
+
+// SLO states reported per route
+const (
+	SLOStatusOK       = "ok"       // the error budget lasts the window
+	SLOStatusWarning  = "warning"  // the budget is being spent faster than it accrues
+	SLOStatusCritical = "critical" // fast burn in both windows
+)
+
+// sloLatencyBuckets are the upper bounds, in seconds, of the request
+// duration histogram
+var sloLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// SLOBudget is the objective of one route: requests slower than Latency or
+// failing with a 5xx are bad, and at most ErrorBudget of them (a fraction,
+// e.g. 0.01) may be bad
+type SLOBudget struct {
+	Latency     time.Duration
+	ErrorBudget float64
+}
+
+// SLOTracker records, for every route with a budget, how many requests were
+// slow or failed and how fast the error budget is being spent. Counts are
+// kept per minute for the burn rate window and in total for Prometheus.
+type SLOTracker struct {
+	mu     sync.Mutex
+	window time.Duration
+	routes map[string]*routeSLO
+	now    func() time.Time
+}
+
+type sloBucket struct {
+	minute int64
+	total  int64
+	bad    int64
+}
+
+type routeSLO struct {
+	budget   SLOBudget
+	buckets  []sloBucket // ring of per-minute counts covering the window
+	total    int64
+	slow     int64
+	errors   int64
+	duration []int64 // histogram counts per sloLatencyBuckets bound, plus +Inf
+	sum      float64
+}
+
+// NewSLOTracker tracks the routes, named by their mux path template, that
+// have a budget. Burn rates are measured over window.
+func NewSLOTracker(budgets map[string]SLOBudget, window time.Duration) *SLOTracker {
+	minutes := int(window / time.Minute)
+	if minutes < int(sloShortWindow/time.Minute) {
+		minutes = int(sloShortWindow / time.Minute)
+	}
+
+	routes := make(map[string]*routeSLO, len(budgets))
+	for route, budget := range budgets {
+		routes[route] = &routeSLO{
+			budget:   budget,
+			buckets:  make([]sloBucket, minutes),
+			duration: make([]int64, len(sloLatencyBuckets)+1),
+		}
+	}
+	return &SLOTracker{
+		window: time.Duration(minutes) * time.Minute,
+		routes: routes,
+		now:    time.Now,
+	}
+}
+
+// Window is the long window burn rates are measured over
+func (t *SLOTracker) Window() time.Duration {
+	return t.window
+}
+
+// Middleware times every request to a tracked route. It must run after mux
+// has matched the route.
+func (t *SLOTracker) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := ""
+		if current := mux.CurrentRoute(r); current != nil {
+			route, _ = current.GetPathTemplate()
+		}
+		if _, tracked := t.routes[route]; !tracked || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := t.now()
+		sw := &statusResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+		t.record(route, sw.status, t.now().Sub(start))
+	})
+}
+
+func (t *SLOTracker) record(route string, status int, elapsed time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	slo := t.routes[route]
+	slow := elapsed > slo.budget.Latency
+	failed := status >= http.StatusInternalServerError
+
+	slo.total++
+	if slow {
+		slo.slow++
+	}
+	if failed {
+		slo.errors++
+	}
+	seconds := elapsed.Seconds()
+	slo.sum += seconds
+	slo.duration[sort.SearchFloat64s(sloLatencyBuckets, seconds)]++
+
+	minute := t.now().Unix() / 60
+	bucket := &slo.buckets[minute%int64(len(slo.buckets))]
+	if bucket.minute != minute {
+		*bucket = sloBucket{minute: minute}
+	}
+	bucket.total++
+	if slow || failed {
+		bucket.bad++
+	}
+}
+
+// burnRate is how many times faster than allowed the error budget was spent
+// over the last window
+func (t *SLOTracker) burnRate(slo *routeSLO, window time.Duration) float64 {
+	now := t.now().Unix() / 60
+	oldest := now - int64(window/time.Minute) + 1
+
+	var total, bad int64
+	for _, bucket := range slo.buckets {
+		if bucket.minute >= oldest && bucket.minute <= now {
+			total += bucket.total
+			bad += bucket.bad
+		}
+	}
+	if total == 0 || slo.budget.ErrorBudget <= 0 {
+		return 0
+	}
+	return float64(bad) / float64(total) / slo.budget.ErrorBudget
+}
+
+// SLORouteSummary is the state of one route's objective
+type SLORouteSummary struct {
+	Route           string  `json:"route"`
+	LatencyBudgetMS int64   `json:"latency_budget_ms"`
+	ErrorBudget     float64 `json:"error_budget"`
+	Requests        int64   `json:"requests"`
+	SlowRequests    int64   `json:"slow_requests"`
+	ErrorRequests   int64   `json:"error_requests"`
+	BurnRate        float64 `json:"burn_rate"`
+	BurnRateShort   float64 `json:"burn_rate_5m"`
+	Status          string  `json:"status"`
+}
+
+// Summary returns every tracked route, sorted by route. Request counts are
+// totals since start; burn rates cover the window and the last five minutes.
+func (t *SLOTracker) Summary() []SLORouteSummary {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	summaries := make([]SLORouteSummary, 0, len(t.routes))
+	for route, slo := range t.routes {
+		long := t.burnRate(slo, t.window)
+		short := t.burnRate(slo, sloShortWindow)
+
+		status := SLOStatusOK
+		if long >= sloFastBurnRate && short >= sloFastBurnRate {
+			status = SLOStatusCritical
+		} else if long > 1 {
+			status = SLOStatusWarning
+		}
+
+		summaries = append(summaries, SLORouteSummary{
+			Route:           route,
+			LatencyBudgetMS: slo.budget.Latency.Milliseconds(),
+			ErrorBudget:     slo.budget.ErrorBudget,
+			Requests:        slo.total,
+			SlowRequests:    slo.slow,
+			ErrorRequests:   slo.errors,
+			BurnRate:        long,
+			BurnRateShort:   short,
+			Status:          status,
+		})
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Route < summaries[j].Route })
+	return summaries
+}
+
+// WritePrometheus writes the tracked routes in the Prometheus text
+// exposition format
+func (t *SLOTracker) WritePrometheus(w io.Writer) {
+	summaries := t.Summary()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	metric := func(name, kind, help string, value func(s SLORouteSummary) string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		for _, s := range summaries {
+			fmt.Fprintf(w, "%s{route=%q} %s\n", name, s.Route, value(s))
+		}
+	}
+	metric("oauth_slo_requests_total", "counter", "Requests served per route.", func(s SLORouteSummary) string { return fmt.Sprint(s.Requests) })
+	metric("oauth_slo_slow_requests_total", "counter", "Requests slower than the route's latency budget.", func(s SLORouteSummary) string { return fmt.Sprint(s.SlowRequests) })
+	metric("oauth_slo_error_requests_total", "counter", "Requests answered with a 5xx status.", func(s SLORouteSummary) string { return fmt.Sprint(s.ErrorRequests) })
+	metric("oauth_slo_latency_budget_seconds", "gauge", "Latency budget per route.", func(s SLORouteSummary) string { return fmt.Sprint(float64(s.LatencyBudgetMS) / 1000) })
+	metric("oauth_slo_error_budget_ratio", "gauge", "Fraction of requests allowed to be slow or failed.", func(s SLORouteSummary) string { return fmt.Sprint(s.ErrorBudget) })
+	metric("oauth_slo_burn_rate", "gauge", "Error budget burn rate over the SLO window.", func(s SLORouteSummary) string { return fmt.Sprint(s.BurnRate) })
+	metric("oauth_slo_burn_rate_5m", "gauge", "Error budget burn rate over the last five minutes.", func(s SLORouteSummary) string { return fmt.Sprint(s.BurnRateShort) })
+
+	fmt.Fprintf(w, "# HELP oauth_request_duration_seconds Request duration per route.\n# TYPE oauth_request_duration_seconds histogram\n")
+	for _, s := range summaries {
+		slo := t.routes[s.Route]
+		var cumulative int64
+		for i, bound := range sloLatencyBuckets {
+			cumulative += slo.duration[i]
+			fmt.Fprintf(w, "oauth_request_duration_seconds_bucket{route=%q,le=\"%g\"} %d\n", s.Route, bound, cumulative)
+		}
+		cumulative += slo.duration[len(sloLatencyBuckets)]
+		fmt.Fprintf(w, "oauth_request_duration_seconds_bucket{route=%q,le=\"+Inf\"} %d\n", s.Route, cumulative)
+		fmt.Fprintf(w, "oauth_request_duration_seconds_sum{route=%q} %g\n", s.Route, slo.sum)
+		fmt.Fprintf(w, "oauth_request_duration_seconds_count{route=%q} %d\n", s.Route, slo.total)
+	}
+}
+
+// ServeMetrics serves the Prometheus metrics
+// GET /metrics
+func (t *SLOTracker) ServeMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	t.WritePrometheus(w)
+}
@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestSLOTracker_BurnRateAndStatus(t *testing.T) {
+	tracker := NewSLOTracker(map[string]SLOBudget{
+		"/oauth/token": {Latency: 100 * time.Millisecond, ErrorBudget: 0.01},
+	}, time.Hour)
+	now := time.Unix(1700000000, 0)
+	tracker.now = func() time.Time { return now }
+
+	status := http.StatusOK
+	r := mux.NewRouter()
+	r.Use(tracker.Middleware)
+	r.HandleFunc("/oauth/token", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	})
+	r.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {})
+
+	serve := func(path string) {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", path, nil))
+	}
+
+	for i := 0; i < 99; i++ {
+		serve("/oauth/token")
+	}
+	status = http.StatusInternalServerError
+	serve("/oauth/token")
+	serve("/health")
+
+	summary := tracker.Summary()
+	if len(summary) != 1 {
+		t.Fatalf("Expected only the budgeted route, got %+v", summary)
+	}
+	token := summary[0]
+	if token.Requests != 100 || token.ErrorRequests != 1 || token.SlowRequests != 0 {
+		t.Errorf("Unexpected counts %+v", token)
+	}
+	if token.BurnRate != 1 || token.Status != SLOStatusOK {
+		t.Errorf("Expected the budget to be spent exactly at rate 1, got %v %s", token.BurnRate, token.Status)
+	}
+
+	// Twenty failures out of 120 burn the budget about 17 times too fast
+	for i := 0; i < 20; i++ {
+		serve("/oauth/token")
+	}
+	token = tracker.Summary()[0]
+	if token.Status != SLOStatusCritical {
+		t.Errorf("Expected a critical fast burn, got %v %s", token.BurnRate, token.Status)
+	}
+
+	// Once the short window has passed, only the long window still burns
+	now = now.Add(10 * time.Minute)
+	status = http.StatusOK
+	serve("/oauth/token")
+	token = tracker.Summary()[0]
+	if token.BurnRateShort != 0 || token.Status != SLOStatusWarning {
+		t.Errorf("Expected a warning after the fast burn, got %v/%v %s", token.BurnRate, token.BurnRateShort, token.Status)
+	}
+}
+
+func TestSLOTracker_SlowRequestsSpendBudget(t *testing.T) {
+	tracker := NewSLOTracker(map[string]SLOBudget{
+		"/oauth/authorize": {Latency: time.Second, ErrorBudget: 0.5},
+	}, time.Hour)
+	start := time.Unix(1700000000, 0)
+	calls := 0
+	tracker.now = func() time.Time {
+		calls++
+		// Each request takes two seconds
+		return start.Add(time.Duration(calls/2) * 2 * time.Second)
+	}
+
+	r := mux.NewRouter()
+	r.Use(tracker.Middleware)
+	r.HandleFunc("/oauth/authorize", func(w http.ResponseWriter, r *http.Request) {})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/oauth/authorize", nil))
+
+	token := tracker.Summary()[0]
+	if token.SlowRequests != 1 || token.BurnRate != 2 {
+		t.Errorf("Expected a slow request burning at rate 2, got %+v", token)
+	}
+}
+
+func TestSLOTracker_ServeMetrics(t *testing.T) {
+	tracker := NewSLOTracker(map[string]SLOBudget{
+		"/oauth/token": {Latency: 500 * time.Millisecond, ErrorBudget: 0.01},
+	}, time.Hour)
+	tracker.record("/oauth/token", http.StatusOK, 20*time.Millisecond)
+
+	w := httptest.NewRecorder()
+	tracker.ServeMetrics(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := w.Body.String()
+	for _, want := range []string{
+		"# TYPE oauth_slo_requests_total counter",
+		`oauth_slo_requests_total{route="/oauth/token"} 1`,
+		`oauth_slo_latency_budget_seconds{route="/oauth/token"} 0.5`,
+		`oauth_request_duration_seconds_bucket{route="/oauth/token",le="0.01"} 0`,
+		`oauth_request_duration_seconds_bucket{route="/oauth/token",le="0.025"} 1`,
+		`oauth_request_duration_seconds_bucket{route="/oauth/token",le="+Inf"} 1`,
+		`oauth_request_duration_seconds_count{route="/oauth/token"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected %q in metrics:\n%s", want, body)
+		}
+	}
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("Unexpected content type %q", w.Header().Get("Content-Type"))
+	}
+}
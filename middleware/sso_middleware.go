@@ -3,6 +3,7 @@ package middleware
 import (
 	"context"
 	"net/http"
+	"oauth2-server/models"
 	"oauth2-server/repository"
 	"time"
 )
@@ -15,9 +16,46 @@ const (
 	SSOSessionContextKey = "sso_session"
 )
 
+// SessionActivity controls how SSO session activity is tracked
+type SessionActivity struct {
+	// IdleTimeout ends a session that has seen no activity for this long;
+	// zero disables the idle timeout
+	IdleTimeout time.Duration
+	// UpdateInterval is the minimum time between two writes of a session's
+	// last activity
+	UpdateInterval time.Duration
+}
+
+func NewSessionActivity(idleTimeout, updateInterval time.Duration) SessionActivity {
+	return SessionActivity{
+		IdleTimeout:    idleTimeout,
+		UpdateInterval: updateInterval,
+	}
+}
+
+// Active reports whether the session can still be used
+func (a SessionActivity) Active(session *models.SSOSession) bool {
+	return session.Active(time.Now(), a.IdleTimeout)
+}
+
+// Touch records activity on the session unless it was last recorded less
+// than UpdateInterval ago. The throttle uses the stored timestamp, so it
+// holds across server instances.
+func (a SessionActivity) Touch(ctx context.Context, ssoRepo *repository.SSOSessionRepository, session *models.SSOSession) {
+	now := time.Now()
+	if now.Sub(session.LastActivity) < a.UpdateInterval {
+		return
+	}
+	if err := ssoRepo.UpdateLastActivity(ctx, session.SessionID); err == nil {
+		session.LastActivity = now
+	}
+}
+
 // SSOMiddleware creates middleware that validates SSO sessions from cookies
-// and adds them to the request context for downstream handlers
-func SSOMiddleware(ssoRepo *repository.SSOSessionRepository) func(http.Handler) http.Handler {
+// and adds them to the request context for downstream handlers. Each request
+// with an active session counts as activity in it; sessions past their idle
+// timeout are ignored as if they had expired.
+func SSOMiddleware(ssoRepo *repository.SSOSessionRepository, activity SessionActivity) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Extract SSO cookie from request
@@ -26,10 +64,9 @@ func SSOMiddleware(ssoRepo *repository.SSOSessionRepository) func(http.Handler)
 				// Validate session against database
 				session, err := ssoRepo.FindBySessionID(r.Context(), cookie.Value)
 				if err == nil && session != nil {
-					// Check if session is authenticated and not expired
-					if session.Authenticated && session.ExpiresAt.After(time.Now()) {
-						// Update last activity timestamp on valid session
-						_ = ssoRepo.UpdateLastActivity(r.Context(), session.SessionID)
+					// Check if session is authenticated, not expired and not idle
+					if activity.Active(session) {
+						activity.Touch(r.Context(), ssoRepo, session)
 						
 						// Add session to request context for downstream handlers
 						ctx := context.WithValue(r.Context(), SSOSessionContextKey, session)
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"context"
+	"oauth2-server/models"
+	"testing"
+	"time"
+)
+
+func TestSessionActivity_Active(t *testing.T) {
+	now := time.Now()
+	activity := NewSessionActivity(30*time.Minute, time.Minute)
+
+	tests := []struct {
+		name     string
+		session  models.SSOSession
+		activity SessionActivity
+		expected bool
+	}{
+		{"Recently used", models.SSOSession{Authenticated: true, ExpiresAt: now.Add(time.Hour), LastActivity: now.Add(-time.Minute)}, activity, true},
+		{"Idle too long", models.SSOSession{Authenticated: true, ExpiresAt: now.Add(time.Hour), LastActivity: now.Add(-time.Hour)}, activity, false},
+		{"Idle without timeout", models.SSOSession{Authenticated: true, ExpiresAt: now.Add(time.Hour), LastActivity: now.Add(-time.Hour)}, NewSessionActivity(0, time.Minute), true},
+		{"Past absolute expiry", models.SSOSession{Authenticated: true, ExpiresAt: now.Add(-time.Second), LastActivity: now}, activity, false},
+		{"Not authenticated", models.SSOSession{ExpiresAt: now.Add(time.Hour), LastActivity: now}, activity, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.activity.Active(&tt.session); got != tt.expected {
+				t.Errorf("Expected Active to be %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestSessionActivity_TouchIsThrottled(t *testing.T) {
+	lastActivity := time.Now().Add(-10 * time.Second)
+	session := &models.SSOSession{SessionID: "sso-1", LastActivity: lastActivity}
+
+	// Within the update interval no write is made, so no repository is needed
+	NewSessionActivity(0, time.Minute).Touch(context.Background(), nil, session)
+
+	if !session.LastActivity.Equal(lastActivity) {
+		t.Error("Expected last activity to be left alone within the update interval")
+	}
+}
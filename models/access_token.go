@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// Access token formats a client can be configured with
+const (
+	TokenFormatJWT    = "jwt"    // self-contained signed token (default)
+	TokenFormatOpaque = "opaque" // random reference resolved from the token store
+)
+
+// IsValidTokenFormat reports whether format is a known access token format
+func IsValidTokenFormat(format string) bool {
+	return format == TokenFormatJWT || format == TokenFormatOpaque
+}
+
+// AccessToken is the server-side record of an opaque access token. Deleting
+// the record revokes the token immediately.
+type AccessToken struct {
+	Token      string    `bson:"token" json:"-"`
+	UserID     string    `bson:"user_id" json:"user_id"`
+	ClientID   string    `bson:"client_id" json:"client_id"`
+	GrantType  string    `bson:"gty,omitempty" json:"gty,omitempty"` // client_credentials for machine tokens
+	Scope      string    `bson:"scope" json:"scope"`
+	Audience   []string  `bson:"audience,omitempty" json:"audience,omitempty"`
+	Thumbprint string    `bson:"x5t_s256,omitempty" json:"x5t#S256,omitempty"` // client certificate the token is bound to
+	JKT        string    `bson:"jkt,omitempty" json:"jkt,omitempty"`           // DPoP key the token is bound to
+	ExpiresAt  time.Time `bson:"expires_at" json:"expires_at"`
+	CreatedAt  time.Time `bson:"created_at" json:"created_at"`
+}
@@ -0,0 +1,67 @@
+package models
+
+import "time"
+
+// Audit event types
+const (
+	AuditLoginSuccess        = "login_success"
+	AuditLoginFailure        = "login_failure"
+	AuditLogout              = "logout"
+	AuditConsentGranted      = "consent_granted"
+	AuditConsentDenied       = "consent_denied"
+	AuditTokenIssued         = "token_issued"
+	AuditUserDisabled        = "user_disabled"
+	AuditUserEnabled         = "user_enabled"
+	AuditPasswordResetForced = "password_reset_forced"
+	AuditUserDeleted         = "user_deleted"
+	AuditConsentRevoked      = "consent_revoked"
+	AuditCodeIPMismatch      = "code_ip_mismatch"
+	AuditCodeSessionEnded    = "code_session_ended"
+	AuditSessionRevoked      = "session_revoked"
+	AuditClientRegistered    = "client_registered"
+	AuditClientUpdated       = "client_updated"
+	AuditClientDeleted       = "client_deleted"
+	AuditClientSecretRotated = "client_secret_rotated"
+	AuditScopeCreated        = "scope_created"
+	AuditScopeUpdated        = "scope_updated"
+	AuditScopeDeleted        = "scope_deleted"
+	AuditUserCreated         = "user_created"
+	AuditTokenDenied         = "token_denied"
+	AuditCodeReplayed        = "code_replayed"
+	AuditIdentityLinked      = "identity_linked"
+	AuditAccountExported     = "account_exported"
+	AuditMFAVerified         = "mfa_verified"
+	AuditMFAFailure          = "mfa_failure"
+	AuditDeviceForgotten     = "device_forgotten"
+	AuditLoginUnfamiliar     = "login_unfamiliar"
+	AuditLoginConfirmed      = "login_confirmed"
+
+	AuditConsentMessageApproved = "consent_message_approved"
+	AuditConsentMessageRejected = "consent_message_rejected"
+)
+
+// AuditEntry is one record of the tamper-evident audit log. Each entry
+// stores the hash of the entry before it, so altering or removing an entry
+// breaks the chain from that point on.
+type AuditEntry struct {
+	ID        string            `bson:"_id,omitempty" json:"id"`
+	Seq       int64             `bson:"seq" json:"seq"`
+	Event     string            `bson:"event" json:"event"`
+	UserID    string            `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	ClientID  string            `bson:"client_id,omitempty" json:"client_id,omitempty"`
+	IPAddress string            `bson:"ip_address,omitempty" json:"ip_address,omitempty"`
+	Details   map[string]string `bson:"details,omitempty" json:"details,omitempty"`
+	CreatedAt time.Time         `bson:"created_at" json:"created_at"`
+	PrevHash  string            `bson:"prev_hash" json:"prev_hash"`
+	Hash      string            `bson:"hash" json:"hash"`
+}
+
+// AuditAnchor records the chain head at a point in time, signed with the
+// server key so the head itself cannot be rewritten along with the entries
+type AuditAnchor struct {
+	ID        string    `bson:"_id,omitempty" json:"id"`
+	Seq       int64     `bson:"seq" json:"seq"`
+	HeadHash  string    `bson:"head_hash" json:"head_hash"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	Signature string    `bson:"signature" json:"signature"`
+}
@@ -0,0 +1,48 @@
+package models
+
+import "time"
+
+// Stages of a pending authorization request. Each is answered by a
+// different page, which only accepts a request in its own stage.
+const (
+	AuthorizationStageLogin   = "login"   // waiting for the user to sign in
+	AuthorizationStageMFA     = "mfa"     // waiting for the signed-in user's second factor
+	AuthorizationStageConsent = "consent" // waiting for the signed-in user's consent
+)
+
+// AuthorizationRequest is an authorization request the user agent left the
+// authorization endpoint with to sign in, complete MFA or consent. It is
+// stored under an opaque Challenge, the only value the login, MFA and consent
+// pages are given; the request parameters never travel through them.
+type AuthorizationRequest struct {
+	Challenge       string    `bson:"challenge" json:"challenge"`
+	Stage           string    `bson:"stage" json:"stage"`
+	UserID          string    `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	ClientID        string    `bson:"client_id" json:"client_id"`
+	RedirectURI     string    `bson:"redirect_uri" json:"redirect_uri"`
+	Scope           string    `bson:"scope" json:"scope"`
+	State           string    `bson:"state" json:"state"`
+	ResponseType    string    `bson:"response_type" json:"response_type"`
+	Nonce           string    `bson:"nonce,omitempty" json:"nonce,omitempty"`
+	CodeChallenge   string    `bson:"code_challenge,omitempty" json:"code_challenge,omitempty"`
+	ChallengeMethod string    `bson:"challenge_method,omitempty" json:"challenge_method,omitempty"`
+	Resources       []string  `bson:"resources,omitempty" json:"resources,omitempty"`
+	LoginHint       string    `bson:"login_hint,omitempty" json:"login_hint,omitempty"`
+	ACRValues       string    `bson:"acr_values,omitempty" json:"acr_values,omitempty"`
+	RequestIP       string    `bson:"request_ip,omitempty" json:"request_ip,omitempty"`
+	UserAgent       string    `bson:"user_agent,omitempty" json:"user_agent,omitempty"`
+	ExpiresAt       time.Time `bson:"expires_at" json:"expires_at"`
+	CreatedAt       time.Time `bson:"created_at" json:"created_at"`
+
+	// MFACodeHash is the SHA-256 of the one-time code emailed for step-up
+	// authentication; MFAAttempts counts the wrong codes entered for it
+	MFACodeHash      string    `bson:"mfa_code_hash,omitempty" json:"-"`
+	MFACodeExpiresAt time.Time `bson:"mfa_code_expires_at,omitempty" json:"-"`
+	MFAAttempts      int       `bson:"mfa_attempts" json:"-"`
+}
+
+// Pending reports whether the request is waiting in the given stage and has
+// not expired
+func (a *AuthorizationRequest) Pending(stage string, now time.Time) bool {
+	return a.Stage == stage && now.Before(a.ExpiresAt)
+}
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// States of a pending CLI login
+const (
+	CLILoginPending  = "pending"
+	CLILoginApproved = "approved"
+	CLILoginDenied   = "denied"
+)
+
+// CLILogin is a login started by a command line client. The CLI polls the
+// token endpoint with the device code while the user approves the short user
+// code at /activate.
+type CLILogin struct {
+	DeviceCode   string    `bson:"device_code" json:"-"`
+	UserCode     string    `bson:"user_code" json:"user_code"`
+	ClientID     string    `bson:"client_id" json:"client_id"`
+	Scope        string    `bson:"scope" json:"scope"`
+	Status       string    `bson:"status" json:"status"`
+	UserID       string    `bson:"user_id,omitempty" json:"user_id,omitempty"` // set once approved or denied
+	LastPolledAt time.Time `bson:"last_polled_at,omitempty" json:"-"`
+	ExpiresAt    time.Time `bson:"expires_at" json:"expires_at"`
+	CreatedAt    time.Time `bson:"created_at" json:"created_at"`
+}
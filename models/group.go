@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// Group is a named set of users, kept in step with a corporate identity
+// provider through SCIM. Members holds user IDs.
+type Group struct {
+	ID          string    `bson:"_id" json:"id"`
+	DisplayName string    `bson:"display_name" json:"display_name"`
+	ExternalID  string    `bson:"external_id,omitempty" json:"external_id,omitempty"`
+	Members     []string  `bson:"members" json:"members"`
+	CreatedAt   time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time `bson:"updated_at" json:"updated_at"`
+}
@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// MetadataVersion is the version of the published discovery document and
+// when it last changed
+type MetadataVersion struct {
+	ID         string    `bson:"_id" json:"-"`
+	Version    string    `bson:"version" json:"metadata_version"`
+	ModifiedAt time.Time `bson:"modified_at" json:"last_modified"`
+}
@@ -5,22 +5,263 @@ import (
 )
 
 type User struct {
-	ID           string    `bson:"_id,omitempty" json:"id"`
-	Email        string    `bson:"email" json:"email"`
-	Password     string    `bson:"password" json:"-"`
-	Name         string    `bson:"name" json:"name"`
-	CreatedAt    time.Time `bson:"created_at" json:"created_at"`
+	ID                    string    `bson:"_id,omitempty" json:"id"`
+	Email                 string    `bson:"email" json:"email"`
+	Password              string    `bson:"password" json:"-"`
+	Name                  string    `bson:"name" json:"name"`
+	EmailVerified         bool      `bson:"email_verified" json:"email_verified"`
+	Roles                 []string  `bson:"roles,omitempty" json:"roles,omitempty"`
+	Disabled              bool      `bson:"disabled,omitempty" json:"disabled,omitempty"`
+	PasswordResetRequired bool      `bson:"password_reset_required,omitempty" json:"password_reset_required,omitempty"`
+	CreatedAt             time.Time `bson:"created_at" json:"created_at"`
+
+	Identities []ExternalIdentity `bson:"identities,omitempty" json:"identities,omitempty"`
+	// ExternalID is the user's ID at the identity provider that provisioned
+	// the account through SCIM
+	ExternalID string `bson:"external_id,omitempty" json:"external_id,omitempty"`
+}
+
+// ExternalIdentity links a user to an account at an upstream identity provider
+type ExternalIdentity struct {
+	Provider string    `bson:"provider" json:"provider"`
+	Subject  string    `bson:"subject" json:"subject"`
+	Email    string    `bson:"email,omitempty" json:"email,omitempty"`
+	LinkedAt time.Time `bson:"linked_at" json:"linked_at"`
+}
+
+// RoleAdmin grants access to the admin API
+const RoleAdmin = "admin"
+
+// HasRole reports whether the user has been assigned the given role
+func (u *User) HasRole(role string) bool {
+	for _, r := range u.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// EmailVerification is a pending email address confirmation. LoginChallenge
+// links it to the authorization request the user registered from so the
+// request can resume after the link is followed.
+type EmailVerification struct {
+	Token          string    `bson:"token" json:"token"`
+	UserID         string    `bson:"user_id" json:"user_id"`
+	Email          string    `bson:"email" json:"email"`
+	LoginChallenge string    `bson:"login_challenge,omitempty" json:"login_challenge,omitempty"`
+	ExpiresAt      time.Time `bson:"expires_at" json:"expires_at"`
+	CreatedAt      time.Time `bson:"created_at" json:"created_at"`
 }
 
 type Client struct {
-	ID            string    `bson:"_id,omitempty" json:"id"`
-	ClientID      string    `bson:"client_id" json:"client_id"`
-	ClientSecret  string    `bson:"client_secret" json:"-"`
-	RedirectURIs  []string  `bson:"redirect_uris" json:"redirect_uris"`
-	Name          string    `bson:"name" json:"name"`
-	AllowedScopes []string  `bson:"allowed_scopes,omitempty" json:"allowed_scopes,omitempty"`
-	GrantTypes    []string  `bson:"grant_types,omitempty" json:"grant_types,omitempty"`
-	CreatedAt     time.Time `bson:"created_at" json:"created_at"`
+	ID               string    `bson:"_id,omitempty" json:"id"`
+	ClientID         string    `bson:"client_id" json:"client_id"`
+	ClientSecret     string    `bson:"client_secret" json:"-"`
+	OwnerUserID      string    `bson:"owner_user_id,omitempty" json:"owner_user_id,omitempty"`
+	RedirectURIs     []string  `bson:"redirect_uris" json:"redirect_uris"`
+	Name             string    `bson:"name" json:"name"`
+	AllowedScopes    []string  `bson:"allowed_scopes,omitempty" json:"allowed_scopes,omitempty"`
+	GrantTypes       []string  `bson:"grant_types,omitempty" json:"grant_types,omitempty"`
+	ResponseTypes    []string  `bson:"response_types,omitempty" json:"response_types,omitempty"`
+	AllowedResources []string  `bson:"allowed_resources,omitempty" json:"allowed_resources,omitempty"` // resource indicators (RFC 8707) the client may request
+	ConsentPolicy    string    `bson:"consent_policy,omitempty" json:"consent_policy,omitempty"`
+	ConsentTTLDays   int64     `bson:"consent_ttl_days,omitempty" json:"consent_ttl_days,omitempty"`
+	PromptNonePolicy string    `bson:"prompt_none_policy,omitempty" json:"prompt_none_policy,omitempty"`
+	ScopeMode        string    `bson:"scope_mode,omitempty" json:"scope_mode,omitempty"`                       // strict (default) or lenient
+	RedirectMatching string    `bson:"redirect_uri_matching,omitempty" json:"redirect_uri_matching,omitempty"` // exact (default), ignore_query or wildcard_subdomain
+	TokenExchange    string    `bson:"token_exchange_policy,omitempty" json:"token_exchange_policy,omitempty"` // empty: the client may not exchange tokens
+	TokenFormat      string    `bson:"access_token_format,omitempty" json:"access_token_format,omitempty"`     // jwt (default) or opaque
+	Disabled         bool      `bson:"disabled,omitempty" json:"disabled,omitempty"`                           // suspended without deleting registration or consents
+	FirstParty       bool      `bson:"first_party,omitempty" json:"first_party,omitempty"`                     // registered by an administrator or at bootstrap
+	CreatedAt        time.Time `bson:"created_at" json:"created_at"`
+
+	// Mutual TLS (RFC 8705)
+	TokenEndpointAuthMethod string `bson:"token_endpoint_auth_method,omitempty" json:"token_endpoint_auth_method,omitempty"`                                 // empty: client secret
+	TLSClientAuthSubjectDN  string `bson:"tls_client_auth_subject_dn,omitempty" json:"tls_client_auth_subject_dn,omitempty"`                                 // tls_client_auth: expected certificate subject
+	TLSClientThumbprint     string `bson:"tls_client_certificate_thumbprint,omitempty" json:"tls_client_certificate_thumbprint,omitempty"`                   // self_signed_tls_client_auth: x5t#S256 of the registered certificate
+	CertificateBoundTokens  bool   `bson:"tls_client_certificate_bound_access_tokens,omitempty" json:"tls_client_certificate_bound_access_tokens,omitempty"` // bind access tokens to the client certificate
+
+	// DPoP (RFC 9449): the client must prove possession of a key, with a
+	// server nonce, at the token endpoint and tokens are bound to that key
+	DPoPBoundTokens bool `bson:"dpop_bound_access_tokens,omitempty" json:"dpop_bound_access_tokens,omitempty"`
+
+	// UserInfo responses are returned as a signed JWT, encrypted to a key in
+	// JWKS, or both, when these are set; otherwise as plain JSON
+	UserInfoSignedAlg    string         `bson:"userinfo_signed_response_alg,omitempty" json:"userinfo_signed_response_alg,omitempty"`
+	UserInfoEncryptedAlg string         `bson:"userinfo_encrypted_response_alg,omitempty" json:"userinfo_encrypted_response_alg,omitempty"`
+	UserInfoEncryptedEnc string         `bson:"userinfo_encrypted_response_enc,omitempty" json:"userinfo_encrypted_response_enc,omitempty"`
+	JWKS                 *JSONWebKeySet `bson:"jwks,omitempty" json:"jwks,omitempty"` // the client's public keys
+
+	// JWKSURI is where the client publishes its public keys, fetched to
+	// verify its private_key_jwt assertions and request objects in place of
+	// registered JWKS
+	JWKSURI string `bson:"jwks_uri,omitempty" json:"jwks_uri,omitempty"`
+
+	// ID tokens are signed and then encrypted to a key in JWKS when set
+	IDTokenEncryptedAlg string `bson:"id_token_encrypted_response_alg,omitempty" json:"id_token_encrypted_response_alg,omitempty"`
+	IDTokenEncryptedEnc string `bson:"id_token_encrypted_response_enc,omitempty" json:"id_token_encrypted_response_enc,omitempty"`
+
+	// A short message shown on the consent page beneath the scopes, once an
+	// administrator has approved it. Changing the message withdraws approval.
+	ConsentMessage         string `bson:"consent_message,omitempty" json:"consent_message,omitempty"`
+	ConsentMessageApproved bool   `bson:"consent_message_approved,omitempty" json:"consent_message_approved,omitempty"`
+
+	// Branding for the login, registration and consent pages: an https logo
+	// and an accent color in #rrggbb form
+	LogoURI    string `bson:"logo_uri,omitempty" json:"logo_uri,omitempty"`
+	BrandColor string `bson:"brand_color,omitempty" json:"brand_color,omitempty"`
+
+	// OpenID Connect Back-Channel Logout: a logout token is posted here when
+	// the user revokes the client's authorization
+	BackchannelLogoutURI string `bson:"backchannel_logout_uri,omitempty" json:"backchannel_logout_uri,omitempty"`
+}
+
+// JSONWebKeySet is a set of public keys registered by a client (RFC 7517)
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `bson:"keys" json:"keys"`
+}
+
+// JSONWebKey is a public RSA key; other key types are stored but not used
+type JSONWebKey struct {
+	Kty string `bson:"kty" json:"kty"`
+	Use string `bson:"use,omitempty" json:"use,omitempty"`
+	Alg string `bson:"alg,omitempty" json:"alg,omitempty"`
+	Kid string `bson:"kid,omitempty" json:"kid,omitempty"`
+	N   string `bson:"n,omitempty" json:"n,omitempty"`
+	E   string `bson:"e,omitempty" json:"e,omitempty"`
+}
+
+// SigningKey returns the RSA key with the given ID, or the first one when
+// kid is empty, that is not reserved for encryption
+func (s *JSONWebKeySet) SigningKey(kid string) *JSONWebKey {
+	if s == nil {
+		return nil
+	}
+	for i, key := range s.Keys {
+		if key.Kty != "RSA" || key.N == "" || key.E == "" || key.Use == "enc" {
+			continue
+		}
+		if kid == "" || key.Kid == kid {
+			return &s.Keys[i]
+		}
+	}
+	return nil
+}
+
+// EncryptionKey returns the first RSA key usable for encryption with alg
+func (s *JSONWebKeySet) EncryptionKey(alg string) *JSONWebKey {
+	if s == nil {
+		return nil
+	}
+	for i, key := range s.Keys {
+		if key.Kty != "RSA" || key.N == "" || key.E == "" {
+			continue
+		}
+		if (key.Use == "" || key.Use == "enc") && (key.Alg == "" || key.Alg == alg) {
+			return &s.Keys[i]
+		}
+	}
+	return nil
+}
+
+// Consent persistence policies. The global default comes from config and a
+// client may override it through Client.ConsentPolicy.
+const (
+	ConsentPolicyPermanent = "permanent" // stored until revoked
+	ConsentPolicyTTL       = "ttl"       // stored with an expiration
+	ConsentPolicySession   = "session"   // never stored, ask every time
+)
+
+// IsValidConsentPolicy reports whether policy is a known consent policy
+func IsValidConsentPolicy(policy string) bool {
+	switch policy {
+	case ConsentPolicyPermanent, ConsentPolicyTTL, ConsentPolicySession:
+		return true
+	}
+	return false
+}
+
+// What prompt=none requires before a code is issued silently. Clients
+// default to PromptNoneConsent; PromptNoneAuthentication is only honored for
+// first-party clients, which users never need to approve.
+const (
+	PromptNoneConsent        = "consent"        // SSO session and stored consent
+	PromptNoneAuthentication = "authentication" // SSO session only
+)
+
+// IsValidPromptNonePolicy reports whether policy is a known prompt=none policy
+func IsValidPromptNonePolicy(policy string) bool {
+	return policy == PromptNoneConsent || policy == PromptNoneAuthentication
+}
+
+// How requests for unknown or unauthorized scopes are handled. Strict clients
+// get an invalid_scope error; lenient clients have those scopes dropped and
+// are granted the rest, which the token response reports.
+const (
+	ScopeModeStrict  = "strict"
+	ScopeModeLenient = "lenient"
+)
+
+// IsValidScopeMode reports whether mode is a known scope mode
+func IsValidScopeMode(mode string) bool {
+	return mode == ScopeModeStrict || mode == ScopeModeLenient
+}
+
+// How a requested redirect URI is matched against the registered ones.
+// Exact matching is the default; the others loosen it for clients that need
+// a dynamic query string or per-branch preview hosts during development.
+const (
+	RedirectMatchExact       = "exact"
+	RedirectMatchIgnoreQuery = "ignore_query"       // the query string may differ
+	RedirectMatchWildcard    = "wildcard_subdomain" // https://*.dev.example.com matches one subdomain label
+)
+
+// IsValidRedirectMatching reports whether mode is a known redirect URI matching mode
+func IsValidRedirectMatching(mode string) bool {
+	switch mode {
+	case RedirectMatchExact, RedirectMatchIgnoreQuery, RedirectMatchWildcard:
+		return true
+	}
+	return false
+}
+
+// Token exchange (RFC 8693) permissions. A client without a policy cannot use
+// the token exchange grant at all.
+const (
+	TokenExchangeImpersonation = "impersonation" // exchange a subject token only
+	TokenExchangeDelegation    = "delegation"    // also act on the subject's behalf with an actor token
+)
+
+// IsValidTokenExchangePolicy reports whether policy is a known token exchange policy
+func IsValidTokenExchangePolicy(policy string) bool {
+	return policy == TokenExchangeImpersonation || policy == TokenExchangeDelegation
+}
+
+// Token endpoint authentication methods. Clients without a method
+// authenticate with their client secret.
+const (
+	AuthMethodClientSecretBasic       = "client_secret_basic"
+	AuthMethodClientSecretPost        = "client_secret_post"
+	AuthMethodTLSClientAuth           = "tls_client_auth"             // CA-issued certificate with a registered subject DN
+	AuthMethodSelfSignedTLSClientAuth = "self_signed_tls_client_auth" // certificate with a registered thumbprint
+	AuthMethodPrivateKeyJWT           = "private_key_jwt"             // JWT signed with a key in jwks or at jwks_uri (RFC 7523)
+)
+
+// IsValidTokenEndpointAuthMethod reports whether method is a known token
+// endpoint authentication method
+func IsValidTokenEndpointAuthMethod(method string) bool {
+	switch method {
+	case AuthMethodClientSecretBasic, AuthMethodClientSecretPost, AuthMethodTLSClientAuth, AuthMethodSelfSignedTLSClientAuth, AuthMethodPrivateKeyJWT:
+		return true
+	}
+	return false
+}
+
+// UsesCertificateAuth reports whether the client authenticates with a TLS
+// client certificate instead of its secret
+func (c *Client) UsesCertificateAuth() bool {
+	return c.TokenEndpointAuthMethod == AuthMethodTLSClientAuth || c.TokenEndpointAuthMethod == AuthMethodSelfSignedTLSClientAuth
 }
 
 type AuthorizationCode struct {
@@ -32,8 +273,34 @@ type AuthorizationCode struct {
 	Nonce           string    `bson:"nonce,omitempty" json:"nonce,omitempty"`
 	CodeChallenge   string    `bson:"code_challenge,omitempty" json:"code_challenge,omitempty"`
 	ChallengeMethod string    `bson:"challenge_method,omitempty" json:"challenge_method,omitempty"`
+	Resources       []string  `bson:"resources,omitempty" json:"resources,omitempty"`
 	ExpiresAt       time.Time `bson:"expires_at" json:"expires_at"`
 	CreatedAt       time.Time `bson:"created_at" json:"created_at"`
+
+	// When and from where the authorization request that produced the code
+	// was made, compared with the redemption to spot replayed codes
+	RequestedAt      time.Time `bson:"requested_at,omitempty" json:"requested_at,omitempty"`
+	RequestIP        string    `bson:"request_ip,omitempty" json:"request_ip,omitempty"`
+	RequestUserAgent string    `bson:"request_user_agent,omitempty" json:"request_user_agent,omitempty"`
+	// Fingerprint is a soft fingerprint of the request's network and user
+	// agent, which survives the address changes of a mobile user
+	Fingerprint string `bson:"fingerprint,omitempty" json:"fingerprint,omitempty"`
+
+	// SSOSessionID is the SSO session the code was issued in. Logging out or
+	// revoking that session before the code is redeemed is audited, and
+	// rejected with RejectCodeEndedSession.
+	SSOSessionID string `bson:"sso_session_id,omitempty" json:"-"`
+
+	// AuthTime is when the user authenticated, from the SSO session; it is
+	// the auth_time of ID tokens issued for the code and on later refreshes
+	AuthTime time.Time `bson:"auth_time,omitempty" json:"auth_time,omitempty"`
+	// ACR is the authentication context of the SSO session, "mfa" when the
+	// user completed a second factor; it is the acr of the ID token
+	ACR string `bson:"acr,omitempty" json:"acr,omitempty"`
+
+	// RedeemedAt is set when the code is exchanged for tokens. Redeemed
+	// codes are kept until they expire so a replay can be detected.
+	RedeemedAt time.Time `bson:"redeemed_at,omitempty" json:"redeemed_at,omitempty"`
 }
 
 type TokenResponse struct {
@@ -54,6 +321,7 @@ type UserInfo struct {
 type ErrorResponse struct {
 	Error            string `json:"error"`
 	ErrorDescription string `json:"error_description,omitempty"`
+	ErrorURI         string `json:"error_uri,omitempty"`
 }
 
 type SSOSession struct {
@@ -66,13 +334,140 @@ type SSOSession struct {
 	LastActivity  time.Time `bson:"last_activity" json:"last_activity"`
 	IPAddress     string    `bson:"ip_address,omitempty" json:"ip_address,omitempty"`
 	UserAgent     string    `bson:"user_agent,omitempty" json:"user_agent,omitempty"`
+	// Browser, OS and Location describe where the session was started, from
+	// the user agent and the GeoIP database, so users can recognise it
+	Browser  string       `bson:"browser,omitempty" json:"browser,omitempty"`
+	OS       string       `bson:"os,omitempty" json:"os,omitempty"`
+	Location *GeoLocation `bson:"location,omitempty" json:"location,omitempty"`
+	// MFAAt is when the user completed a second factor in this session, or
+	// signed in from a trusted device; zero when only a password was used
+	MFAAt time.Time `bson:"mfa_at,omitempty" json:"mfa_at,omitempty"`
+	// A sign-in from an unfamiliar device waiting for the user to confirm it
+	// by email is not Authenticated and stores the SHA-256 of the link's
+	// token. ResumeChallenge is the login challenge of the authorization
+	// request it continues.
+	ConfirmationHash string `bson:"confirmation_hash,omitempty" json:"-"`
+	ResumeChallenge  string `bson:"resume_challenge,omitempty" json:"-"`
 }
 
+// SignInProfile records the devices, networks and countries a user has
+// signed in from, so a sign-in from somewhere new can be flagged
+type SignInProfile struct {
+	UserID    string    `bson:"_id" json:"user_id"`
+	Devices   []string  `bson:"devices" json:"devices"`
+	Networks  []string  `bson:"networks" json:"networks"`
+	Countries []string  `bson:"countries,omitempty" json:"countries,omitempty"`
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// GeoLocation is where an IP address is registered
+type GeoLocation struct {
+	City    string `bson:"city,omitempty" json:"city,omitempty"`
+	Region  string `bson:"region,omitempty" json:"region,omitempty"`
+	Country string `bson:"country,omitempty" json:"country,omitempty"`
+}
+
+// String names the location by its most specific part, such as "Bangkok"
+func (l GeoLocation) String() string {
+	switch {
+	case l.City != "":
+		return l.City
+	case l.Region != "":
+		return l.Region
+	default:
+		return l.Country
+	}
+}
+
+// TrustedDevice is a browser the user chose to remember after completing
+// MFA, so step-up authentication does not challenge it again until it
+// expires. TokenHash is the SHA-256 of the remember-device cookie.
+type TrustedDevice struct {
+	ID         string    `bson:"_id" json:"id"`
+	UserID     string    `bson:"user_id" json:"user_id"`
+	TokenHash  string    `bson:"token_hash" json:"-"`
+	UserAgent  string    `bson:"user_agent,omitempty" json:"user_agent,omitempty"`
+	IPAddress  string    `bson:"ip_address,omitempty" json:"ip_address,omitempty"`
+	CreatedAt  time.Time `bson:"created_at" json:"created_at"`
+	LastUsedAt time.Time `bson:"last_used_at" json:"last_used_at"`
+	ExpiresAt  time.Time `bson:"expires_at" json:"expires_at"`
+}
+
+// IdleExpiresAt is when the session ends for lack of activity under
+// idleTimeout; the zero time when there is no idle timeout
+func (s *SSOSession) IdleExpiresAt(idleTimeout time.Duration) time.Time {
+	if idleTimeout <= 0 {
+		return time.Time{}
+	}
+	return s.LastActivity.Add(idleTimeout)
+}
+
+// Active reports whether the session is authenticated, not past its absolute
+// expiry and, when idleTimeout is set, not idle for longer than idleTimeout
+func (s *SSOSession) Active(now time.Time, idleTimeout time.Duration) bool {
+	if !s.Authenticated || !now.Before(s.ExpiresAt) {
+		return false
+	}
+	idleExpiresAt := s.IdleExpiresAt(idleTimeout)
+	return idleExpiresAt.IsZero() || now.Before(idleExpiresAt)
+}
+
+// Consent statuses. A revoked consent is kept with its history; granting
+// again makes it active.
+const (
+	ConsentStatusActive  = "active"
+	ConsentStatusRevoked = "revoked"
+)
+
+// Consent history actions
+const (
+	ConsentEventGranted     = "granted"      // consent given, first time or again
+	ConsentEventScopesAdded = "scopes_added" // more scopes added to an active consent
+	ConsentEventRevoked     = "revoked"
+	ConsentEventExpired     = "expired" // the consent lapsed before it was granted again
+)
+
 type UserConsent struct {
 	ID        string    `bson:"_id,omitempty" json:"id"`
 	UserID    string    `bson:"user_id" json:"user_id"`
 	ClientID  string    `bson:"client_id" json:"client_id"`
 	Scopes    []string  `bson:"scopes" json:"scopes"`
+	Status    string    `bson:"status" json:"status"`
 	GrantedAt time.Time `bson:"granted_at" json:"granted_at"`
 	ExpiresAt time.Time `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+	// ScopeExpiresAt holds expiries for time-limited scope grants (e.g. a
+	// payment scope valid for 15 minutes). Scopes without an entry last as
+	// long as the consent itself.
+	ScopeExpiresAt map[string]time.Time `bson:"scope_expires_at,omitempty" json:"scope_expires_at,omitempty"`
+	// Version counts the changes recorded in History
+	Version int            `bson:"version" json:"version"`
+	History []ConsentEvent `bson:"history,omitempty" json:"-"`
+}
+
+// ConsentEvent is one change to a consent. SessionRef is the SHA-256 of the
+// SSO session the user acted from, when known, so the history never holds a
+// usable session ID; Actor is set when an administrator made the change.
+type ConsentEvent struct {
+	Action     string    `bson:"action" json:"action"`
+	Scopes     []string  `bson:"scopes,omitempty" json:"scopes,omitempty"`
+	SessionRef string    `bson:"session_ref,omitempty" json:"session_ref,omitempty"`
+	Actor      string    `bson:"actor,omitempty" json:"actor,omitempty"`
+	At         time.Time `bson:"at" json:"at"`
+}
+
+// ScopeExpired reports whether a time-limited grant for the scope has expired
+func (c *UserConsent) ScopeExpired(scope string, now time.Time) bool {
+	expiresAt, ok := c.ScopeExpiresAt[scope]
+	return ok && !expiresAt.After(now)
+}
+
+// ActiveScopes returns the granted scopes whose own grant has not expired
+func (c *UserConsent) ActiveScopes(now time.Time) []string {
+	active := make([]string, 0, len(c.Scopes))
+	for _, scope := range c.Scopes {
+		if !c.ScopeExpired(scope, now) {
+			active = append(active, scope)
+		}
+	}
+	return active
 }
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// Subjects whose tokens can be revoked as a whole
+const (
+	RevocationSubjectUser   = "user"
+	RevocationSubjectClient = "client"
+	RevocationSubjectGrant  = "grant" // one user's tokens for one client
+)
+
+// GrantSubjectID identifies the grant of a user to a client as a
+// revocation subject
+func GrantSubjectID(userID, clientID string) string {
+	return userID + ":" + clientID
+}
+
+// Revocation records when every token of a user, client or grant was revoked.
+// Self-contained tokens issued before RevokedAt are no longer honored; the
+// record expires once no such token can still be valid.
+type Revocation struct {
+	ID          string    `bson:"_id" json:"-"`
+	SubjectType string    `bson:"subject_type" json:"subject_type"`
+	SubjectID   string    `bson:"subject_id" json:"subject_id"`
+	RevokedAt   time.Time `bson:"revoked_at" json:"revoked_at"`
+	ExpiresAt   time.Time `bson:"expires_at" json:"expires_at"`
+}
@@ -1,5 +1,7 @@
 package models
 
+import "sync"
+
 // ScopeDefinition represents a scope with its metadata
 type ScopeDefinition struct {
 	Name        string   `json:"name" bson:"name"`
@@ -8,11 +10,21 @@ type ScopeDefinition struct {
 	IsDefault   bool     `json:"is_default" bson:"is_default"`
 	ParentScope string   `json:"parent_scope,omitempty" bson:"parent_scope,omitempty"`
 	ChildScopes []string `json:"child_scopes,omitempty" bson:"child_scopes,omitempty"`
+	// Sensitive scopes are highlighted on the consent screen
+	Sensitive bool `json:"sensitive" bson:"sensitive"`
+	// Custom scopes are defined through the admin API and stored in the
+	// database; built-in scopes cannot be changed or removed
+	Custom bool `json:"custom" bson:"custom"`
 }
 
-// ScopeRegistry holds all available scopes
+// ScopeRegistry holds all available scopes. It is safe for concurrent use
+// so custom scopes can be reloaded while requests are being served.
 type ScopeRegistry struct {
 	Scopes map[string]*ScopeDefinition
+	mu     sync.RWMutex
+	// generation counts changes, so documents built from the registry can
+	// tell when they are stale
+	generation uint64
 }
 
 // NewScopeRegistry creates a new scope registry with standard OIDC scopes
@@ -68,28 +80,81 @@ func NewScopeRegistry() *ScopeRegistry {
 		IsDefault:   false,
 	})
 
+	registry.RegisterScope(&ScopeDefinition{
+		Name:        "admin",
+		Description: "Administrative access to user management",
+		Claims:      []string{},
+		IsDefault:   false,
+	})
+
 	return registry
 }
 
 // RegisterScope adds a scope to the registry
 func (r *ScopeRegistry) RegisterScope(scope *ScopeDefinition) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	r.Scopes[scope.Name] = scope
+	r.generation++
+}
+
+// UnregisterScope removes a scope from the registry
+func (r *ScopeRegistry) UnregisterScope(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.Scopes, name)
+	r.generation++
+}
+
+// ReplaceCustomScopes swaps the registered custom scopes for the given set,
+// leaving built-in scopes untouched. Custom scopes never shadow built-in ones.
+func (r *ScopeRegistry) ReplaceCustomScopes(custom []*ScopeDefinition) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.generation++
+
+	for name, scope := range r.Scopes {
+		if scope.Custom {
+			delete(r.Scopes, name)
+		}
+	}
+	for _, scope := range custom {
+		if existing, exists := r.Scopes[scope.Name]; exists && !existing.Custom {
+			continue
+		}
+		scope.Custom = true
+		r.Scopes[scope.Name] = scope
+	}
+}
+
+// Generation returns a number that changes whenever a scope is registered,
+// replaced or removed
+func (r *ScopeRegistry) Generation() uint64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.generation
 }
 
 // GetScope retrieves a scope definition
 func (r *ScopeRegistry) GetScope(name string) (*ScopeDefinition, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	scope, exists := r.Scopes[name]
 	return scope, exists
 }
 
 // IsValidScope checks if a scope exists in the registry
 func (r *ScopeRegistry) IsValidScope(name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	_, exists := r.Scopes[name]
 	return exists
 }
 
 // GetAllScopes returns all registered scopes
 func (r *ScopeRegistry) GetAllScopes() []*ScopeDefinition {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	scopes := make([]*ScopeDefinition, 0, len(r.Scopes))
 	for _, scope := range r.Scopes {
 		scopes = append(scopes, scope)
@@ -99,6 +164,8 @@ func (r *ScopeRegistry) GetAllScopes() []*ScopeDefinition {
 
 // GetDefaultScopes returns scopes marked as default
 func (r *ScopeRegistry) GetDefaultScopes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	var defaults []string
 	for name, scope := range r.Scopes {
 		if scope.IsDefault {
@@ -110,6 +177,8 @@ func (r *ScopeRegistry) GetDefaultScopes() []string {
 
 // GetClaimsForScopes returns all claims for given scopes
 func (r *ScopeRegistry) GetClaimsForScopes(scopes []string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	claimsMap := make(map[string]bool)
 	
 	for _, scopeName := range scopes {
@@ -129,6 +198,8 @@ func (r *ScopeRegistry) GetClaimsForScopes(scopes []string) []string {
 
 // ExpandScopes expands parent scopes to include child scopes
 func (r *ScopeRegistry) ExpandScopes(scopes []string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	expanded := make(map[string]bool)
 	
 	var expand func(string)
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// Webhook is a URL registered to receive signed security events. The secret
+// keys the HMAC signature of every delivery and is only shown when the
+// webhook is created.
+type Webhook struct {
+	ID        string    `bson:"_id" json:"id"`
+	URL       string    `bson:"url" json:"url"`
+	Events    []string  `bson:"events" json:"events"`
+	Secret    string    `bson:"secret" json:"-"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+}
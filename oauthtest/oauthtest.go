@@ -0,0 +1,283 @@
+// Package oauthtest runs a local issuer for the integration tests of
+// services that accept this server's tokens. It needs no MongoDB: users and
+// clients live in memory and tokens are signed with a key generated for the
+// test.
+//
+// The issuer serves discovery, JWKS, UserInfo and the client_credentials
+// grant of the token endpoint, with the same document, key set and claims as
+// the real server. Flows that need the login and consent pages are not
+// served; IssueToken and IssueIDToken mint the tokens they would return.
+//
+//	issuer := oauthtest.NewServer(t)
+//	token := issuer.IssueToken(&models.User{ID: "user-1", Email: "a@example.com"}, "openid", "email")
+//	// configure the service under test with issuer.URL and send token
+package oauthtest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"oauth2-server/config"
+	"oauth2-server/handlers"
+	"oauth2-server/models"
+	"oauth2-server/utils"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+)
+
+// Default client seeded into every server. IssueToken issues tokens to it.
+const (
+	DefaultClientID     = "test-client"
+	DefaultClientSecret = "test-secret"
+)
+
+// keyID is the kid of the test signing key
+const keyID = "oauthtest"
+
+// Server is a running test issuer. URL is its issuer URL.
+type Server struct {
+	*httptest.Server
+	PrivateKey *rsa.PrivateKey
+
+	t       testing.TB
+	mu      sync.Mutex
+	users   map[string]*models.User
+	clients map[string]*models.Client
+}
+
+// TokenRequest describes a token to mint
+type TokenRequest struct {
+	// User is the token subject; nil for a client_credentials token whose
+	// subject is the client
+	User     *models.User
+	ClientID string // DefaultClientID when empty
+	Scopes   []string
+	Audience []string
+	// ExpiresIn is the token lifetime, an hour when zero. A negative value
+	// mints an already expired token.
+	ExpiresIn time.Duration
+}
+
+// NewServer starts an issuer that is closed when the test ends
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("oauthtest: generate key: %v", err)
+	}
+
+	s := &Server{
+		PrivateKey: key,
+		t:          t,
+		users:      map[string]*models.User{},
+		clients:    map[string]*models.Client{},
+	}
+	s.AddClient(&models.Client{
+		ClientID:      DefaultClientID,
+		ClientSecret:  DefaultClientSecret,
+		Name:          "Test Client",
+		AllowedScopes: []string{"openid", "profile", "email"},
+	})
+
+	r := mux.NewRouter()
+	s.Server = httptest.NewServer(r)
+	t.Cleanup(s.Close)
+
+	discovery := handlers.NewDiscoveryHandler(s.URL, utils.GlobalScopeRegistry, keyID, nil, nil, 0, &config.Config{}, []string{"client_credentials"})
+	jwks := handlers.NewJWKSHandler(&key.PublicKey, keyID, nil, "", 0)
+	r.HandleFunc("/.well-known/openid-configuration", discovery.WellKnown).Methods("GET")
+	r.HandleFunc("/.well-known/oauth-authorization-server", discovery.WellKnown).Methods("GET")
+	r.HandleFunc("/.well-known/jwks.json", jwks.JWKS).Methods("GET")
+	r.HandleFunc("/oauth/token", s.token).Methods("POST")
+	r.HandleFunc("/oauth/userinfo", s.userInfo).Methods("GET")
+	return s
+}
+
+// AddUser seeds a user, replacing any with the same ID
+func (s *Server) AddUser(user *models.User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[user.ID] = user
+}
+
+// AddClient seeds a client, replacing any with the same client ID
+func (s *Server) AddClient(client *models.Client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clients[client.ClientID] = client
+}
+
+// IssueToken seeds user and returns an access token issued to it for the
+// default client
+func (s *Server) IssueToken(user *models.User, scopes ...string) string {
+	s.t.Helper()
+	s.AddUser(user)
+	return s.Token(TokenRequest{User: user, Scopes: scopes})
+}
+
+// Token mints an access token as the server's token endpoint would
+func (s *Server) Token(req TokenRequest) string {
+	s.t.Helper()
+	token, err := s.accessToken(req)
+	if err != nil {
+		s.t.Fatalf("oauthtest: sign token: %v", err)
+	}
+	return token
+}
+
+func (s *Server) accessToken(req TokenRequest) (string, error) {
+	if req.ClientID == "" {
+		req.ClientID = DefaultClientID
+	}
+	if req.ExpiresIn == 0 {
+		req.ExpiresIn = time.Hour
+	}
+	subject := req.ClientID
+	if req.User != nil {
+		subject = req.User.ID
+	}
+	audience := req.Audience
+	if len(audience) == 0 {
+		audience = []string{s.URL}
+	}
+	jti, err := utils.GenerateRandomString(22)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	return s.sign(utils.AccessTokenType, utils.AccessTokenClaims{
+		UserID:   subject,
+		Scope:    strings.Join(req.Scopes, " "),
+		ClientID: req.ClientID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    s.URL,
+			Subject:   subject,
+			Audience:  audience,
+			ExpiresAt: jwt.NewNumericDate(now.Add(req.ExpiresIn)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ID:        jti,
+		},
+	})
+}
+
+// IssueIDToken seeds user and returns an ID token issued to clientID with
+// the claims scopes release
+func (s *Server) IssueIDToken(user *models.User, clientID string, scopes ...string) string {
+	s.t.Helper()
+	s.AddUser(user)
+
+	claims := jwt.MapClaims{}
+	for name, value := range utils.GetIDTokenClaimsForUser(user, strings.Join(scopes, " "), "") {
+		claims[name] = value
+	}
+	claims["iss"] = s.URL
+	claims["sub"] = user.ID
+	claims["aud"] = clientID
+	claims["iat"] = time.Now().Unix()
+	claims["exp"] = time.Now().Add(time.Hour).Unix()
+	token, err := s.sign("", claims)
+	if err != nil {
+		s.t.Fatalf("oauthtest: sign ID token: %v", err)
+	}
+	return token
+}
+
+// sign signs claims with the test key; an empty typ keeps the default JWT
+func (s *Server) sign(typ string, claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = keyID
+	if typ != "" {
+		token.Header["typ"] = typ
+	}
+	return token.SignedString(s.PrivateKey)
+}
+
+// token serves the client_credentials grant
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	clientID, secret, ok := r.BasicAuth()
+	if !ok {
+		clientID, secret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+	s.mu.Lock()
+	client := s.clients[clientID]
+	s.mu.Unlock()
+
+	switch {
+	case client == nil || client.ClientSecret == "" || client.ClientSecret != secret:
+		respondError(w, http.StatusUnauthorized, "invalid_client", "Invalid client credentials")
+		return
+	case r.PostFormValue("grant_type") != "client_credentials":
+		respondError(w, http.StatusBadRequest, "unsupported_grant_type", "Only client_credentials is served by the test issuer")
+		return
+	}
+
+	scopes := strings.Fields(r.PostFormValue("scope"))
+	if len(scopes) == 0 {
+		scopes = []string{"openid"}
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(client.AllowedScopes, scope) {
+			respondError(w, http.StatusBadRequest, "invalid_scope", "Scope "+scope+" is not allowed for this client")
+			return
+		}
+	}
+
+	accessToken, err := s.accessToken(TokenRequest{ClientID: clientID, Scopes: scopes, Audience: r.PostForm["resource"]})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "server_error", "Failed to generate access token")
+		return
+	}
+	respondJSON(w, http.StatusOK, models.TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(time.Hour / time.Second),
+		Scope:       strings.Join(scopes, " "),
+	})
+}
+
+// userInfo returns the claims of a seeded user that the token's scopes
+// release
+func (s *Server) userInfo(w http.ResponseWriter, r *http.Request) {
+	raw, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		respondError(w, http.StatusUnauthorized, "invalid_token", "Missing bearer token")
+		return
+	}
+
+	var claims utils.AccessTokenClaims
+	token, err := jwt.ParseWithClaims(raw, &claims, func(*jwt.Token) (interface{}, error) {
+		return &s.PrivateKey.PublicKey, nil
+	}, jwt.WithValidMethods([]string{"RS256"}), jwt.WithIssuer(s.URL), jwt.WithExpirationRequired())
+	if err != nil || token.Header["typ"] != utils.AccessTokenType {
+		respondError(w, http.StatusUnauthorized, "invalid_token", "Invalid or expired token")
+		return
+	}
+
+	s.mu.Lock()
+	user := s.users[claims.UserID]
+	s.mu.Unlock()
+	if user == nil {
+		respondError(w, http.StatusUnauthorized, "invalid_token", "Token subject is not a user")
+		return
+	}
+	respondJSON(w, http.StatusOK, utils.FilterClaimsForUser(user, claims.Scope))
+}
+
+func respondJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func respondError(w http.ResponseWriter, status int, code, description string) {
+	respondJSON(w, status, models.ErrorResponse{Error: code, ErrorDescription: description})
+}
@@ -0,0 +1,97 @@
+package oauthtest_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"oauth2-server/client"
+	"oauth2-server/models"
+	"oauth2-server/oauthtest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer_IssuedTokens(t *testing.T) {
+	issuer := oauthtest.NewServer(t)
+	ctx := context.Background()
+
+	rp, err := client.New(ctx, client.Config{Issuer: issuer.URL, ClientID: oauthtest.DefaultClientID})
+	if err != nil {
+		t.Fatalf("Discovery failed: %v", err)
+	}
+
+	user := &models.User{ID: "user-1", Email: "user@example.com", Name: "Test User", EmailVerified: true}
+	claims, err := rp.UserInfo(ctx, issuer.IssueToken(user, "openid", "email"))
+	if err != nil {
+		t.Fatalf("UserInfo failed: %v", err)
+	}
+	if claims["sub"] != "user-1" || claims["email"] != "user@example.com" {
+		t.Errorf("Unexpected UserInfo %v", claims)
+	}
+	if _, ok := claims["name"]; ok {
+		t.Error("Expected the profile claims to need the profile scope")
+	}
+
+	idToken, err := rp.VerifyIDToken(ctx, issuer.IssueIDToken(user, oauthtest.DefaultClientID, "openid", "profile"), "")
+	if err != nil {
+		t.Fatalf("ID token verification failed: %v", err)
+	}
+	if idToken.Subject != "user-1" || idToken.String("name") != "Test User" {
+		t.Errorf("Unexpected ID token claims %+v", idToken)
+	}
+
+	// An ID token is not an access token
+	var oauthErr *client.Error
+	if _, err := rp.UserInfo(ctx, issuer.IssueIDToken(user, oauthtest.DefaultClientID, "openid")); !errors.As(err, &oauthErr) || oauthErr.Code != "invalid_token" {
+		t.Errorf("Expected an ID token to be rejected at UserInfo, got %v", err)
+	}
+
+	expired := issuer.Token(oauthtest.TokenRequest{User: user, Scopes: []string{"openid"}, ExpiresIn: -time.Minute})
+	if _, err := rp.UserInfo(ctx, expired); !errors.As(err, &oauthErr) || oauthErr.Code != "invalid_token" {
+		t.Errorf("Expected an expired token to be rejected, got %v", err)
+	}
+}
+
+func TestServer_ClientCredentials(t *testing.T) {
+	issuer := oauthtest.NewServer(t)
+	issuer.AddClient(&models.Client{ClientID: "service-a", ClientSecret: "secret-a", AllowedScopes: []string{"orders:read"}})
+
+	post := func(form url.Values) (*http.Response, map[string]interface{}) {
+		resp, err := http.Post(issuer.URL+"/oauth/token", "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var body map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp, body
+	}
+
+	resp, body := post(url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {"service-a"},
+		"client_secret": {"secret-a"},
+		"scope":         {"orders:read"},
+	})
+	if resp.StatusCode != http.StatusOK || body["access_token"] == "" || body["scope"] != "orders:read" {
+		t.Errorf("Unexpected token response %d %v", resp.StatusCode, body)
+	}
+
+	resp, body = post(url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {"service-a"},
+		"client_secret": {"secret-a"},
+		"scope":         {"orders:write"},
+	})
+	if resp.StatusCode != http.StatusBadRequest || body["error"] != "invalid_scope" {
+		t.Errorf("Expected invalid_scope, got %d %v", resp.StatusCode, body)
+	}
+
+	resp, body = post(url.Values{"grant_type": {"client_credentials"}, "client_id": {"service-a"}, "client_secret": {"wrong"}})
+	if resp.StatusCode != http.StatusUnauthorized || body["error"] != "invalid_client" {
+		t.Errorf("Expected invalid_client, got %d %v", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,616 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expression is a compiled boolean expression in a small subset of CEL:
+//
+//	literals     "text" 'text' 42 true false null [a, b]
+//	fields       user.roles  context.ip  context["user_agent"]
+//	operators    ! - == != < <= > >= in && || ( )
+//	functions    size(x)
+//	methods      s.contains(t) s.startsWith(t) s.endsWith(t) s.matches(re)
+//
+// && and || short-circuit. A missing map key evaluates to null.
+type Expression struct {
+	source string
+	root   node
+}
+
+// Compile parses an expression
+func Compile(source string) (*Expression, error) {
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q at %d", p.peek().text, p.peek().pos)
+	}
+	return &Expression{source: source, root: root}, nil
+}
+
+// String returns the expression source
+func (e *Expression) String() string {
+	return e.source
+}
+
+// Eval evaluates the expression; it is an error for it not to yield a bool
+func (e *Expression) Eval(vars map[string]interface{}) (bool, error) {
+	value, err := e.root.eval(vars)
+	if err != nil {
+		return false, err
+	}
+	result, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("expression yields %s, not bool", typeName(value))
+	}
+	return result, nil
+}
+
+// Tokens
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOperator
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// operators are matched longest first
+var operators = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "-", "(", ")", "[", "]", ",", "."}
+
+func tokenize(source string) ([]token, error) {
+	var tokens []token
+	runes := []rune(source)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		case r == '"' || r == '\'':
+			start := i
+			var text strings.Builder
+			for i++; i < len(runes) && runes[i] != r; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				text.WriteRune(runes[i])
+			}
+			if i == len(runes) {
+				return nil, fmt.Errorf("unterminated string at %d", start)
+			}
+			i++
+			tokens = append(tokens, token{tokenString, text.String(), start})
+
+		case unicode.IsDigit(r):
+			start := i
+			for i < len(runes) && unicode.IsDigit(runes[i]) {
+				i++
+			}
+			tokens = append(tokens, token{tokenNumber, string(runes[start:i]), start})
+
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, token{tokenIdent, string(runes[start:i]), start})
+
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(string(runes[i:]), op) {
+					tokens = append(tokens, token{tokenOperator, op, i})
+					i += len([]rune(op))
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected %q at %d", r, i)
+			}
+		}
+	}
+	return append(tokens, token{tokenEOF, "end of expression", len(runes)}), nil
+}
+
+// Parser
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token if it is the operator or keyword text
+func (p *parser) accept(text string) bool {
+	t := p.peek()
+	if (t.kind == tokenOperator || t.kind == tokenIdent) && t.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if !p.accept(text) {
+		return fmt.Errorf("expected %q at %d, got %q", text, p.peek().pos, p.peek().text)
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.accept("||") {
+		var right node
+		if right, err = p.parseAnd(); err == nil {
+			left = &logicalNode{or: true, left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseRelation()
+	for err == nil && p.accept("&&") {
+		var right node
+		if right, err = p.parseRelation(); err == nil {
+			left = &logicalNode{left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *parser) parseRelation() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">", "in"} {
+		if p.accept(op) {
+			right, err := p.parseUnary()
+			if err != nil {
+				return nil, err
+			}
+			return &binaryNode{op: op, left: left, right: right}, nil
+		}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	for _, op := range []string{"!", "-"} {
+		if p.accept(op) {
+			operand, err := p.parseUnary()
+			if err != nil {
+				return nil, err
+			}
+			return &unaryNode{op: op, operand: operand}, nil
+		}
+	}
+	return p.parsePostfix()
+}
+
+func (p *parser) parsePostfix() (node, error) {
+	n, err := p.parsePrimary()
+	for err == nil {
+		switch {
+		case p.accept("."):
+			name := p.next()
+			if name.kind != tokenIdent {
+				return nil, fmt.Errorf("expected a field or method name at %d", name.pos)
+			}
+			if p.accept("(") {
+				var args []node
+				if args, err = p.parseArgs(")"); err == nil {
+					n = &callNode{name: name.text, target: n, args: args}
+				}
+			} else {
+				n = &indexNode{target: n, key: &literalNode{name.text}}
+			}
+		case p.accept("["):
+			var key node
+			if key, err = p.parseOr(); err == nil {
+				if err = p.expect("]"); err == nil {
+					n = &indexNode{target: n, key: key}
+				}
+			}
+		default:
+			return n, nil
+		}
+	}
+	return nil, err
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenString:
+		return &literalNode{t.text}, nil
+	case tokenNumber:
+		n, err := strconv.ParseInt(t.text, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", t.text)
+		}
+		return &literalNode{n}, nil
+	case tokenIdent:
+		switch t.text {
+		case "true":
+			return &literalNode{true}, nil
+		case "false":
+			return &literalNode{false}, nil
+		case "null":
+			return &literalNode{nil}, nil
+		}
+		if p.accept("(") {
+			args, err := p.parseArgs(")")
+			if err != nil {
+				return nil, err
+			}
+			return &callNode{name: t.text, args: args}, nil
+		}
+		return &variableNode{t.text}, nil
+	case tokenOperator:
+		switch t.text {
+		case "(":
+			n, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return n, p.expect(")")
+		case "[":
+			items, err := p.parseArgs("]")
+			if err != nil {
+				return nil, err
+			}
+			return &listNode{items}, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+}
+
+// parseArgs parses a comma separated list up to the closing operator
+func (p *parser) parseArgs(closing string) ([]node, error) {
+	var args []node
+	if p.accept(closing) {
+		return args, nil
+	}
+	for {
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if p.accept(closing) {
+			return args, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Evaluation. Values are string, int64, bool, nil, []interface{} and
+// map[string]interface{}.
+
+type node interface {
+	eval(vars map[string]interface{}) (interface{}, error)
+}
+
+type literalNode struct{ value interface{} }
+
+func (n *literalNode) eval(map[string]interface{}) (interface{}, error) {
+	return n.value, nil
+}
+
+type variableNode struct{ name string }
+
+func (n *variableNode) eval(vars map[string]interface{}) (interface{}, error) {
+	value, ok := vars[n.name]
+	if !ok {
+		return nil, fmt.Errorf("undeclared reference to %q", n.name)
+	}
+	return value, nil
+}
+
+type listNode struct{ items []node }
+
+func (n *listNode) eval(vars map[string]interface{}) (interface{}, error) {
+	values := make([]interface{}, len(n.items))
+	for i, item := range n.items {
+		value, err := item.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+type indexNode struct {
+	target node
+	key    node
+}
+
+func (n *indexNode) eval(vars map[string]interface{}) (interface{}, error) {
+	target, err := n.target.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	key, err := n.key.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	switch t := target.(type) {
+	case map[string]interface{}:
+		name, ok := key.(string)
+		if !ok {
+			return nil, fmt.Errorf("map key must be a string, got %s", typeName(key))
+		}
+		return t[name], nil
+	case []interface{}:
+		i, ok := key.(int64)
+		if !ok || i < 0 || i >= int64(len(t)) {
+			return nil, errors.New("list index out of range")
+		}
+		return t[i], nil
+	}
+	return nil, fmt.Errorf("cannot index %s", typeName(target))
+}
+
+type unaryNode struct {
+	op      string
+	operand node
+}
+
+func (n *unaryNode) eval(vars map[string]interface{}) (interface{}, error) {
+	value, err := n.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "!" {
+		b, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("! needs a bool, got %s", typeName(value))
+		}
+		return !b, nil
+	}
+	i, ok := value.(int64)
+	if !ok {
+		return nil, fmt.Errorf("- needs an int, got %s", typeName(value))
+	}
+	return -i, nil
+}
+
+type logicalNode struct {
+	or          bool
+	left, right node
+}
+
+func (n *logicalNode) eval(vars map[string]interface{}) (interface{}, error) {
+	for _, operand := range []node{n.left, n.right} {
+		value, err := operand.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		b, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("&& and || need bools, got %s", typeName(value))
+		}
+		if b == n.or {
+			return b, nil
+		}
+	}
+	return !n.or, nil
+}
+
+type binaryNode struct {
+	op          string
+	left, right node
+}
+
+func (n *binaryNode) eval(vars map[string]interface{}) (interface{}, error) {
+	left, err := n.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return equal(left, right), nil
+	case "!=":
+		return !equal(left, right), nil
+	case "in":
+		switch r := right.(type) {
+		case []interface{}:
+			for _, item := range r {
+				if equal(left, item) {
+					return true, nil
+				}
+			}
+			return false, nil
+		case map[string]interface{}:
+			key, ok := left.(string)
+			_, found := r[key]
+			return ok && found, nil
+		}
+		return nil, fmt.Errorf("in needs a list or map, got %s", typeName(right))
+	}
+
+	// Ordering compares two ints or two strings
+	var cmp int
+	switch l := left.(type) {
+	case int64:
+		r, ok := right.(int64)
+		if !ok {
+			return nil, fmt.Errorf("cannot compare int with %s", typeName(right))
+		}
+		cmp = compare(l, r)
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return nil, fmt.Errorf("cannot compare string with %s", typeName(right))
+		}
+		cmp = strings.Compare(l, r)
+	default:
+		return nil, fmt.Errorf("cannot order %s", typeName(left))
+	}
+	switch n.op {
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	}
+	return cmp >= 0, nil
+}
+
+type callNode struct {
+	name   string
+	target node // nil for global functions
+	args   []node
+}
+
+func (n *callNode) eval(vars map[string]interface{}) (interface{}, error) {
+	args := make([]interface{}, len(n.args))
+	for i, arg := range n.args {
+		value, err := arg.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = value
+	}
+
+	if n.target == nil {
+		if n.name != "size" || len(args) != 1 {
+			return nil, fmt.Errorf("unknown function %s/%d", n.name, len(args))
+		}
+		switch v := args[0].(type) {
+		case string:
+			return int64(len([]rune(v))), nil
+		case []interface{}:
+			return int64(len(v)), nil
+		case map[string]interface{}:
+			return int64(len(v)), nil
+		}
+		return nil, fmt.Errorf("size of %s", typeName(args[0]))
+	}
+
+	target, err := n.target.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := target.(string)
+	if !ok || len(args) != 1 {
+		return nil, fmt.Errorf("unknown method %s.%s/%d", typeName(target), n.name, len(args))
+	}
+	arg, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("%s needs a string argument", n.name)
+	}
+	switch n.name {
+	case "contains":
+		return strings.Contains(s, arg), nil
+	case "startsWith":
+		return strings.HasPrefix(s, arg), nil
+	case "endsWith":
+		return strings.HasSuffix(s, arg), nil
+	case "matches":
+		re, err := regexp.Compile(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern: %w", err)
+		}
+		return re.MatchString(s), nil
+	}
+	return nil, fmt.Errorf("unknown method string.%s", n.name)
+}
+
+func equal(a, b interface{}) bool {
+	switch a := a.(type) {
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !equal(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		return false
+	}
+	if _, ok := b.(map[string]interface{}); ok {
+		return false
+	}
+	if _, ok := b.([]interface{}); ok {
+		return false
+	}
+	return a == b
+}
+
+func compare(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case int64:
+		return "int"
+	case bool:
+		return "bool"
+	case []interface{}:
+		return "list"
+	case map[string]interface{}:
+		return "map"
+	}
+	return fmt.Sprintf("%T", value)
+}
@@ -0,0 +1,74 @@
+package policy
+
+import "testing"
+
+func TestExpression_Eval(t *testing.T) {
+	vars := map[string]interface{}{
+		"user": map[string]interface{}{
+			"email": "alice@contractor.example.com",
+			"roles": []interface{}{"contractor", "viewer"},
+		},
+		"scopes":     []interface{}{"openid", "admin"},
+		"grant_type": "authorization_code",
+		"context":    map[string]interface{}{"ip": "10.1.2.3"},
+	}
+
+	tests := []struct {
+		expr     string
+		expected bool
+	}{
+		{`"contractor" in user.roles`, true},
+		{`'admin' in user.roles`, false},
+		{`"admin" in scopes && "contractor" in user.roles`, true},
+		{`grant_type == "client_credentials" || size(scopes) > 1`, true},
+		{`!(grant_type == "authorization_code")`, false},
+		{`user.email.endsWith("@contractor.example.com")`, true},
+		{`user.email.startsWith("bob")`, false},
+		{`context.ip.matches("^10\\.")`, true},
+		{`context["ip"].contains("1.2")`, true},
+		{`context.missing == null`, true},
+		{`"ip" in context`, true},
+		{`size(user.roles) >= 2 && size("abc") == 3`, true},
+		{`user.roles[0] == "contractor"`, true},
+		{`scopes == ["openid", "admin"]`, true},
+		{`-1 < 0`, true},
+		{`"b" > "a"`, true},
+		// && short-circuits, so the type error on the right is never reached
+		{`false && size(1) == 1`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			expr, err := Compile(tt.expr)
+			if err != nil {
+				t.Fatalf("Failed to compile: %v", err)
+			}
+			result, err := expr.Eval(vars)
+			if err != nil {
+				t.Fatalf("Failed to evaluate: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestExpression_Errors(t *testing.T) {
+	for _, src := range []string{`"unterminated`, `user.`, `(true`, `true true`, `a ==`, `#`} {
+		if _, err := Compile(src); err == nil {
+			t.Errorf("Expected %q not to compile", src)
+		}
+	}
+
+	vars := map[string]interface{}{"n": int64(1), "s": "x"}
+	for _, src := range []string{`n`, `unknown == 1`, `n < "a"`, `!s`, `s.reverse("a")`, `size(n) == 0`, `n in s`} {
+		expr, err := Compile(src)
+		if err != nil {
+			t.Fatalf("Failed to compile %q: %v", src, err)
+		}
+		if _, err := expr.Eval(vars); err == nil {
+			t.Errorf("Expected %q to fail", src)
+		}
+	}
+}
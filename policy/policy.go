@@ -0,0 +1,172 @@
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"oauth2-server/models"
+	"os"
+	"slices"
+)
+
+// Input describes a token about to be issued. User is nil for grants that
+// act for the client itself, such as client_credentials; Client is nil for
+// tokens issued straight to a user at login.
+type Input struct {
+	User      *models.User
+	Client    *models.Client
+	Scopes    []string
+	GrantType string
+	Context   map[string]string // request attributes such as ip and resource
+}
+
+// Decision is a policy's answer. When Allow is true the token is issued with
+// Scopes, which may be fewer than requested; Reason names the rule that
+// denied or shrank the grant.
+type Decision struct {
+	Allow  bool
+	Scopes []string
+	Reason string
+}
+
+// Policy is consulted before every token is issued and may deny the grant or
+// remove scopes from it
+type Policy interface {
+	Evaluate(ctx context.Context, in *Input) (*Decision, error)
+}
+
+// AllowAll is the default policy: every grant is issued as requested
+type AllowAll struct{}
+
+func (AllowAll) Evaluate(ctx context.Context, in *Input) (*Decision, error) {
+	return &Decision{Allow: true, Scopes: in.Scopes}, nil
+}
+
+// Rule is one entry of an expression policy. When is an expression over the
+// input (see Compile); when it holds, the grant is denied or RemoveScopes are
+// taken out of it.
+type Rule struct {
+	Name         string   `json:"name"`
+	When         string   `json:"when"`
+	Deny         bool     `json:"deny,omitempty"`
+	RemoveScopes []string `json:"remove_scopes,omitempty"`
+}
+
+type compiledRule struct {
+	Rule
+	when *Expression
+}
+
+// ExpressionPolicy applies rules in order. The first matching deny rule
+// denies the grant; matching remove rules shrink it, and later rules see the
+// shrunk scopes. A grant is allowed when no deny rule matches.
+type ExpressionPolicy struct {
+	rules []compiledRule
+}
+
+// NewExpressionPolicy compiles the rules, so a malformed expression is
+// reported at startup rather than when a token is requested
+func NewExpressionPolicy(rules []Rule) (*ExpressionPolicy, error) {
+	compiled := make([]compiledRule, 0, len(rules))
+	for i, rule := range rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		if !rule.Deny && len(rule.RemoveScopes) == 0 {
+			return nil, fmt.Errorf("%s: must deny or remove scopes", rule.Name)
+		}
+		when, err := Compile(rule.When)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", rule.Name, err)
+		}
+		compiled = append(compiled, compiledRule{Rule: rule, when: when})
+	}
+	return &ExpressionPolicy{rules: compiled}, nil
+}
+
+// LoadFile reads an expression policy from a JSON array of rules
+func LoadFile(path string) (*ExpressionPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("invalid policy file: %w", err)
+	}
+	return NewExpressionPolicy(rules)
+}
+
+func (p *ExpressionPolicy) Evaluate(ctx context.Context, in *Input) (*Decision, error) {
+	decision := &Decision{Allow: true, Scopes: slices.Clone(in.Scopes)}
+	for _, rule := range p.rules {
+		matched, err := rule.when.Eval(variables(in, decision.Scopes))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", rule.Name, err)
+		}
+		if !matched {
+			continue
+		}
+		if rule.Deny {
+			return &Decision{Allow: false, Reason: rule.Name}, nil
+		}
+
+		before := len(decision.Scopes)
+		decision.Scopes = slices.DeleteFunc(decision.Scopes, func(scope string) bool {
+			return slices.Contains(rule.RemoveScopes, scope)
+		})
+		if len(decision.Scopes) < before && decision.Reason == "" {
+			decision.Reason = rule.Name
+		}
+	}
+	return decision, nil
+}
+
+// variables exposes the input to expressions as user, client, scopes,
+// grant_type and context. Missing users and clients are empty maps, so
+// user.roles is an empty list rather than an error.
+func variables(in *Input, scopes []string) map[string]interface{} {
+	user := map[string]interface{}{"roles": []interface{}{}}
+	if in.User != nil {
+		user = map[string]interface{}{
+			"id":             in.User.ID,
+			"email":          in.User.Email,
+			"name":           in.User.Name,
+			"email_verified": in.User.EmailVerified,
+			"roles":          list(in.User.Roles),
+		}
+	}
+
+	client := map[string]interface{}{"grant_types": []interface{}{}, "allowed_scopes": []interface{}{}}
+	if in.Client != nil {
+		client = map[string]interface{}{
+			"client_id":      in.Client.ClientID,
+			"name":           in.Client.Name,
+			"owner_user_id":  in.Client.OwnerUserID,
+			"public":         in.Client.ClientSecret == "" && !in.Client.UsesCertificateAuth(),
+			"grant_types":    list(in.Client.GrantTypes),
+			"allowed_scopes": list(in.Client.AllowedScopes),
+		}
+	}
+
+	context := make(map[string]interface{}, len(in.Context))
+	for key, value := range in.Context {
+		context[key] = value
+	}
+
+	return map[string]interface{}{
+		"user":       user,
+		"client":     client,
+		"scopes":     list(scopes),
+		"grant_type": in.GrantType,
+		"context":    context,
+	}
+}
+
+func list(values []string) []interface{} {
+	result := make([]interface{}, len(values))
+	for i, value := range values {
+		result[i] = value
+	}
+	return result
+}
@@ -0,0 +1,124 @@
+package policy
+
+import (
+	"context"
+	"oauth2-server/models"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestAllowAll(t *testing.T) {
+	decision, err := AllowAll{}.Evaluate(context.Background(), &Input{Scopes: []string{"openid", "admin"}})
+	if err != nil || !decision.Allow || len(decision.Scopes) != 2 {
+		t.Errorf("Expected the grant to be allowed as requested, got %+v %v", decision, err)
+	}
+}
+
+func TestExpressionPolicy(t *testing.T) {
+	p, err := NewExpressionPolicy([]Rule{
+		{Name: "contractors-no-admin", When: `"contractor" in user.roles`, RemoveScopes: []string{"admin"}},
+		{Name: "no-admin-for-machines", When: `grant_type == "client_credentials" && "admin" in scopes`, Deny: true},
+		{Name: "blocked-network", When: `context.ip.startsWith("192.0.2.")`, Deny: true},
+	})
+	if err != nil {
+		t.Fatalf("Failed to compile policy: %v", err)
+	}
+
+	contractor := &models.User{ID: "u1", Roles: []string{"contractor"}}
+	employee := &models.User{ID: "u2", Roles: []string{"admin"}}
+	client := &models.Client{ClientID: "app"}
+
+	tests := []struct {
+		name    string
+		input   *Input
+		allowed bool
+		scopes  []string
+		reason  string
+	}{
+		{
+			name:    "Contractor loses admin",
+			input:   &Input{User: contractor, Client: client, Scopes: []string{"openid", "admin"}, GrantType: "authorization_code"},
+			allowed: true,
+			scopes:  []string{"openid"},
+			reason:  "contractors-no-admin",
+		},
+		{
+			name:    "Employee keeps admin",
+			input:   &Input{User: employee, Client: client, Scopes: []string{"openid", "admin"}, GrantType: "authorization_code"},
+			allowed: true,
+			scopes:  []string{"openid", "admin"},
+		},
+		{
+			name:   "Machine asking for admin is denied",
+			input:  &Input{Client: client, Scopes: []string{"admin"}, GrantType: "client_credentials"},
+			reason: "no-admin-for-machines",
+		},
+		{
+			name:   "Blocked network",
+			input:  &Input{User: employee, Scopes: []string{"openid"}, GrantType: "password", Context: map[string]string{"ip": "192.0.2.7"}},
+			reason: "blocked-network",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.input.Context == nil {
+				tt.input.Context = map[string]string{"ip": "10.0.0.1"}
+			}
+			decision, err := p.Evaluate(context.Background(), tt.input)
+			if err != nil {
+				t.Fatalf("Failed to evaluate: %v", err)
+			}
+			if decision.Allow != tt.allowed || decision.Reason != tt.reason {
+				t.Errorf("Expected allow=%v reason=%q, got %+v", tt.allowed, tt.reason, decision)
+			}
+			if tt.allowed && !slices.Equal(decision.Scopes, tt.scopes) {
+				t.Errorf("Expected scopes %v, got %v", tt.scopes, decision.Scopes)
+			}
+		})
+	}
+
+	if !slices.Equal(tests[0].input.Scopes, []string{"openid", "admin"}) {
+		t.Error("Expected the input scopes to be left unchanged")
+	}
+}
+
+func TestNewExpressionPolicy_Rejects(t *testing.T) {
+	if _, err := NewExpressionPolicy([]Rule{{Name: "noop", When: "true"}}); err == nil {
+		t.Error("Expected a rule that neither denies nor removes scopes to be rejected")
+	}
+	if _, err := NewExpressionPolicy([]Rule{{Name: "broken", When: "user.", Deny: true}}); err == nil {
+		t.Error("Expected a malformed expression to be rejected")
+	}
+}
+
+func TestExpressionPolicy_EvaluationErrorFailsClosed(t *testing.T) {
+	p, err := NewExpressionPolicy([]Rule{{Name: "typo", When: `size(grant_type) > "3"`, Deny: true}})
+	if err != nil {
+		t.Fatalf("Failed to compile policy: %v", err)
+	}
+	if _, err := p.Evaluate(context.Background(), &Input{}); err == nil {
+		t.Error("Expected an evaluation error")
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	os.WriteFile(path, []byte(`[{"name":"deny-all","when":"true","deny":true}]`), 0600)
+
+	p, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to load policy: %v", err)
+	}
+	decision, err := p.Evaluate(context.Background(), &Input{})
+	if err != nil || decision.Allow {
+		t.Errorf("Expected the grant to be denied, got %+v %v", decision, err)
+	}
+
+	os.WriteFile(path, []byte(`{"name":"not a list"}`), 0600)
+	if _, err := LoadFile(path); err == nil {
+		t.Error("Expected a malformed file to be rejected")
+	}
+}
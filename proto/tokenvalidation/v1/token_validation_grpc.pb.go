@@ -0,0 +1,192 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             (unknown)
+// source: tokenvalidation/v1/token_validation.proto
+
+package tokenvalidationv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	TokenValidation_Validate_FullMethodName  = "/oauth2server.tokenvalidation.v1.TokenValidation/Validate"
+	TokenValidation_WatchJWKS_FullMethodName = "/oauth2server.tokenvalidation.v1.TokenValidation/WatchJWKS"
+)
+
+// TokenValidationClient is the client API for TokenValidation service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// TokenValidation lets internal services validate tokens issued by the
+// server, and follow its signing keys, over gRPC instead of HTTP.
+type TokenValidationClient interface {
+	// Validate checks a token and the caller's policy as POST
+	// /token/validate does. An invalid token is a normal response with
+	// valid false, not an error.
+	Validate(ctx context.Context, in *ValidateRequest, opts ...grpc.CallOption) (*ValidateResponse, error)
+	// WatchJWKS sends the current key set, then the new one each time the
+	// signing keys change, until the caller cancels or the server stops.
+	WatchJWKS(ctx context.Context, in *WatchJWKSRequest, opts ...grpc.CallOption) (TokenValidation_WatchJWKSClient, error)
+}
+
+type tokenValidationClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTokenValidationClient(cc grpc.ClientConnInterface) TokenValidationClient {
+	return &tokenValidationClient{cc}
+}
+
+func (c *tokenValidationClient) Validate(ctx context.Context, in *ValidateRequest, opts ...grpc.CallOption) (*ValidateResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ValidateResponse)
+	err := c.cc.Invoke(ctx, TokenValidation_Validate_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tokenValidationClient) WatchJWKS(ctx context.Context, in *WatchJWKSRequest, opts ...grpc.CallOption) (TokenValidation_WatchJWKSClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &TokenValidation_ServiceDesc.Streams[0], TokenValidation_WatchJWKS_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &tokenValidationWatchJWKSClient{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type TokenValidation_WatchJWKSClient interface {
+	Recv() (*JWKS, error)
+	grpc.ClientStream
+}
+
+type tokenValidationWatchJWKSClient struct {
+	grpc.ClientStream
+}
+
+func (x *tokenValidationWatchJWKSClient) Recv() (*JWKS, error) {
+	m := new(JWKS)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// TokenValidationServer is the server API for TokenValidation service.
+// All implementations must embed UnimplementedTokenValidationServer
+// for forward compatibility
+//
+// TokenValidation lets internal services validate tokens issued by the
+// server, and follow its signing keys, over gRPC instead of HTTP.
+type TokenValidationServer interface {
+	// Validate checks a token and the caller's policy as POST
+	// /token/validate does. An invalid token is a normal response with
+	// valid false, not an error.
+	Validate(context.Context, *ValidateRequest) (*ValidateResponse, error)
+	// WatchJWKS sends the current key set, then the new one each time the
+	// signing keys change, until the caller cancels or the server stops.
+	WatchJWKS(*WatchJWKSRequest, TokenValidation_WatchJWKSServer) error
+	mustEmbedUnimplementedTokenValidationServer()
+}
+
+// UnimplementedTokenValidationServer must be embedded to have forward compatible implementations.
+type UnimplementedTokenValidationServer struct {
+}
+
+func (UnimplementedTokenValidationServer) Validate(context.Context, *ValidateRequest) (*ValidateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Validate not implemented")
+}
+func (UnimplementedTokenValidationServer) WatchJWKS(*WatchJWKSRequest, TokenValidation_WatchJWKSServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchJWKS not implemented")
+}
+func (UnimplementedTokenValidationServer) mustEmbedUnimplementedTokenValidationServer() {}
+
+// UnsafeTokenValidationServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to TokenValidationServer will
+// result in compilation errors.
+type UnsafeTokenValidationServer interface {
+	mustEmbedUnimplementedTokenValidationServer()
+}
+
+func RegisterTokenValidationServer(s grpc.ServiceRegistrar, srv TokenValidationServer) {
+	s.RegisterService(&TokenValidation_ServiceDesc, srv)
+}
+
+func _TokenValidation_Validate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ValidateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TokenValidationServer).Validate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TokenValidation_Validate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TokenValidationServer).Validate(ctx, req.(*ValidateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TokenValidation_WatchJWKS_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchJWKSRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TokenValidationServer).WatchJWKS(m, &tokenValidationWatchJWKSServer{ServerStream: stream})
+}
+
+type TokenValidation_WatchJWKSServer interface {
+	Send(*JWKS) error
+	grpc.ServerStream
+}
+
+type tokenValidationWatchJWKSServer struct {
+	grpc.ServerStream
+}
+
+func (x *tokenValidationWatchJWKSServer) Send(m *JWKS) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// TokenValidation_ServiceDesc is the grpc.ServiceDesc for TokenValidation service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var TokenValidation_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "oauth2server.tokenvalidation.v1.TokenValidation",
+	HandlerType: (*TokenValidationServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Validate",
+			Handler:    _TokenValidation_Validate_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchJWKS",
+			Handler:       _TokenValidation_WatchJWKS_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "tokenvalidation/v1/token_validation.proto",
+}
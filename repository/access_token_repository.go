@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"context"
+	"oauth2-server/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// AccessTokenRepository stores opaque access tokens
+type AccessTokenRepository struct {
+	collection *mongo.Collection
+	// reads serves token lookups for validation and introspection
+	reads *mongo.Collection
+}
+
+func NewAccessTokenRepository(db *mongo.Database) *AccessTokenRepository {
+	collection := db.Collection("access_tokens")
+	return &AccessTokenRepository{
+		collection: collection,
+		reads:      collection,
+	}
+}
+
+// ReadFromSecondaries sends token lookups to replica set secondaries. Writes
+// stay on the primary, so a new token may briefly be unknown and a revoked
+// one briefly still found.
+func (r *AccessTokenRepository) ReadFromSecondaries() {
+	r.reads = secondaryReads(r.collection)
+}
+
+func (r *AccessTokenRepository) Create(ctx context.Context, token *models.AccessToken) error {
+	token.CreatedAt = time.Now()
+	_, err := r.collection.InsertOne(ctx, token)
+	return translate(err)
+}
+
+// FindByToken returns the token record, or ErrNotFound when the
+// token is unknown or has expired
+func (r *AccessTokenRepository) FindByToken(ctx context.Context, token string) (*models.AccessToken, error) {
+	var accessToken models.AccessToken
+	filter := bson.M{"token": token, "expires_at": bson.M{"$gt": time.Now()}}
+	if err := r.reads.FindOne(ctx, filter).Decode(&accessToken); err != nil {
+		return nil, translate(err)
+	}
+	return &accessToken, nil
+}
+
+// Delete revokes a single token
+func (r *AccessTokenRepository) Delete(ctx context.Context, token string) error {
+	_, err := r.collection.DeleteOne(ctx, bson.M{"token": token})
+	return err
+}
+
+// DeleteByUserID revokes every opaque token issued for the user
+func (r *AccessTokenRepository) DeleteByUserID(ctx context.Context, userID string) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
+
+// DeleteByUserAndClient revokes every opaque token issued to the client for
+// the user
+func (r *AccessTokenRepository) DeleteByUserAndClient(ctx context.Context, userID, clientID string) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"user_id": userID, "client_id": clientID})
+	return err
+}
+
+// DeleteByClientID revokes every opaque token issued to the client
+func (r *AccessTokenRepository) DeleteByClientID(ctx context.Context, clientID string) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"client_id": clientID})
+	return err
+}
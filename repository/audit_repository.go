@@ -0,0 +1,284 @@
+package repository
+
+import (
+	"context"
+	"oauth2-server/models"
+	"oauth2-server/utils"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AuditRepository stores the hash-chained audit log, its signed anchors and
+// how far each audit export has got
+type AuditRepository struct {
+	entries *mongo.Collection
+	anchors *mongo.Collection
+	exports *mongo.Collection
+	// mu serializes appends so each entry links to the current head
+	mu sync.Mutex
+}
+
+func NewAuditRepository(db *mongo.Database) *AuditRepository {
+	return &AuditRepository{
+		entries: db.Collection("audit_log"),
+		anchors: db.Collection("audit_anchors"),
+		exports: db.Collection("audit_exports"),
+	}
+}
+
+// Append links the entry to the current chain head and stores it. Sequence,
+// timestamps and hashes are filled in here. A second server appending at the
+// same time loses on the unique seq index and retries against the new head.
+func (r *AuditRepository) Append(ctx context.Context, entry *models.AuditEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for attempt := 0; ; attempt++ {
+		head, err := r.Head(ctx)
+		if err != nil && err != ErrNotFound {
+			return err
+		}
+
+		entry.Seq = 1
+		entry.PrevHash = ""
+		if head != nil {
+			entry.Seq = head.Seq + 1
+			entry.PrevHash = head.Hash
+		}
+		entry.CreatedAt = utils.AuditTime(time.Now())
+		entry.Hash = utils.HashAuditEntry(entry)
+
+		_, err = r.entries.InsertOne(ctx, entry)
+		if mongo.IsDuplicateKeyError(err) {
+			if attempt < 3 {
+				continue
+			}
+			return ErrConflict
+		}
+		return err
+	}
+}
+
+// Head returns the most recent entry of the chain
+func (r *AuditRepository) Head(ctx context.Context) (*models.AuditEntry, error) {
+	var entry models.AuditEntry
+	opts := options.FindOne().SetSort(bson.D{{Key: "seq", Value: -1}})
+	if err := r.entries.FindOne(ctx, bson.M{}, opts).Decode(&entry); err != nil {
+		return nil, translate(err)
+	}
+	return &entry, nil
+}
+
+// AuditFilter narrows a listing of the audit log. Empty fields and zero
+// times match every entry; Since is inclusive and Until exclusive.
+type AuditFilter struct {
+	UserID   string
+	ClientID string
+	Event    string
+	Since    time.Time
+	Until    time.Time
+}
+
+// List returns the newest entries matching the filter first
+func (r *AuditRepository) List(ctx context.Context, f AuditFilter, limit int64) ([]*models.AuditEntry, error) {
+	filter := bson.M{}
+	if f.UserID != "" {
+		filter["user_id"] = f.UserID
+	}
+	if f.ClientID != "" {
+		filter["client_id"] = f.ClientID
+	}
+	if f.Event != "" {
+		filter["event"] = f.Event
+	}
+	if !f.Since.IsZero() || !f.Until.IsZero() {
+		createdAt := bson.M{}
+		if !f.Since.IsZero() {
+			createdAt["$gte"] = f.Since
+		}
+		if !f.Until.IsZero() {
+			createdAt["$lt"] = f.Until
+		}
+		filter["created_at"] = createdAt
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "seq", Value: -1}}).SetLimit(limit)
+	cursor, err := r.entries.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var entries []*models.AuditEntry
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// All returns the whole chain in sequence order
+func (r *AuditRepository) All(ctx context.Context) ([]*models.AuditEntry, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "seq", Value: 1}})
+	cursor, err := r.entries.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var entries []*models.AuditEntry
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// CountEvents counts a client's entries of one event type since the given time
+func (r *AuditRepository) CountEvents(ctx context.Context, clientID, event string, since time.Time) (int64, error) {
+	filter := bson.M{"client_id": clientID, "event": event}
+	if !since.IsZero() {
+		filter["created_at"] = bson.M{"$gte": since}
+	}
+	return r.entries.CountDocuments(ctx, filter)
+}
+
+// DailyCount is the number of audit entries on one UTC day
+type DailyCount struct {
+	Date  string `bson:"_id" json:"date"` // YYYY-MM-DD
+	Count int64  `bson:"count" json:"count"`
+}
+
+// CountEventsByDay counts a client's entries of one event type per UTC day
+// since the given time, oldest first. Days without entries are left out.
+func (r *AuditRepository) CountEventsByDay(ctx context.Context, clientID, event string, since time.Time) ([]DailyCount, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"client_id": clientID, "event": event, "created_at": bson.M{"$gte": since}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$created_at"}},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	}
+	cursor, err := r.entries.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var counts []DailyCount
+	if err := cursor.All(ctx, &counts); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// CountEventTypes counts a client's entries of each of the event types since
+// the given time. Event types without entries are left out.
+func (r *AuditRepository) CountEventTypes(ctx context.Context, clientID string, events []string, since time.Time) (map[string]int64, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"client_id": clientID, "event": bson.M{"$in": events}, "created_at": bson.M{"$gte": since}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$event", "count": bson.M{"$sum": 1}}}},
+	}
+	cursor, err := r.entries.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rows []struct {
+		Event string `bson:"_id"`
+		Count int64  `bson:"count"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.Event] = row.Count
+	}
+	return counts, nil
+}
+
+// LastEvent returns a client's most recent entry of one event type
+func (r *AuditRepository) LastEvent(ctx context.Context, clientID, event string) (*models.AuditEntry, error) {
+	opts := options.FindOne().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	var entry models.AuditEntry
+	if err := r.entries.FindOne(ctx, bson.M{"client_id": clientID, "event": event}, opts).Decode(&entry); err != nil {
+		return nil, translate(err)
+	}
+	return &entry, nil
+}
+
+// ListAfter returns up to limit entries following seq, in sequence order
+func (r *AuditRepository) ListAfter(ctx context.Context, seq, limit int64) ([]*models.AuditEntry, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "seq", Value: 1}}).SetLimit(limit)
+	cursor, err := r.entries.Find(ctx, bson.M{"seq": bson.M{"$gt": seq}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var entries []*models.AuditEntry
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// ExportPosition returns the sequence of the last entry the named export
+// delivered, 0 when it has not delivered any
+func (r *AuditRepository) ExportPosition(ctx context.Context, name string) (int64, error) {
+	var position struct {
+		Seq int64 `bson:"seq"`
+	}
+	err := r.exports.FindOne(ctx, bson.M{"_id": name}).Decode(&position)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	return position.Seq, err
+}
+
+// SetExportPosition records the last entry the named export delivered
+func (r *AuditRepository) SetExportPosition(ctx context.Context, name string, seq int64) error {
+	_, err := r.exports.UpdateOne(ctx,
+		bson.M{"_id": name},
+		bson.M{"$set": bson.M{"seq": seq, "updated_at": time.Now()}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// CreateAnchor stores a signed chain head
+func (r *AuditRepository) CreateAnchor(ctx context.Context, anchor *models.AuditAnchor) error {
+	_, err := r.anchors.InsertOne(ctx, anchor)
+	return translate(err)
+}
+
+// LatestAnchor returns the most recent anchor
+func (r *AuditRepository) LatestAnchor(ctx context.Context) (*models.AuditAnchor, error) {
+	var anchor models.AuditAnchor
+	opts := options.FindOne().SetSort(bson.D{{Key: "seq", Value: -1}})
+	if err := r.anchors.FindOne(ctx, bson.M{}, opts).Decode(&anchor); err != nil {
+		return nil, translate(err)
+	}
+	return &anchor, nil
+}
+
+// ListAnchors returns all anchors in sequence order
+func (r *AuditRepository) ListAnchors(ctx context.Context) ([]*models.AuditAnchor, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "seq", Value: 1}})
+	cursor, err := r.anchors.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var anchors []*models.AuditAnchor
+	if err := cursor.All(ctx, &anchors); err != nil {
+		return nil, err
+	}
+	return anchors, nil
+}
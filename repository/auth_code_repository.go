@@ -22,19 +22,54 @@ func NewAuthCodeRepository(db *mongo.Database) *AuthCodeRepository {
 func (r *AuthCodeRepository) Create(ctx context.Context, code *models.AuthorizationCode) error {
 	code.CreatedAt = time.Now()
 	_, err := r.collection.InsertOne(ctx, code)
-	return err
+	return translate(err)
 }
 
 func (r *AuthCodeRepository) FindByCode(ctx context.Context, code string) (*models.AuthorizationCode, error) {
 	var authCode models.AuthorizationCode
 	err := r.collection.FindOne(ctx, bson.M{"code": code}).Decode(&authCode)
 	if err != nil {
-		return nil, err
+		return nil, translate(err)
 	}
 	return &authCode, nil
 }
 
+// Redeem marks the code as exchanged for tokens. Only one caller can redeem
+// a code; the others get ErrConflict, as does redeeming it again later.
+func (r *AuthCodeRepository) Redeem(ctx context.Context, code string) error {
+	filter := bson.M{"code": code, "redeemed_at": bson.M{"$exists": false}}
+	result, err := r.collection.UpdateOne(ctx, filter, bson.M{
+		"$set": bson.M{"redeemed_at": time.Now()},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrConflict
+	}
+	return nil
+}
+
 func (r *AuthCodeRepository) Delete(ctx context.Context, code string) error {
 	_, err := r.collection.DeleteOne(ctx, bson.M{"code": code})
 	return err
 }
+
+// DeleteByUserID removes any unredeemed authorization codes issued to the user
+func (r *AuthCodeRepository) DeleteByUserID(ctx context.Context, userID string) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
+
+// DeleteByClientID removes any unredeemed authorization codes issued to the client
+func (r *AuthCodeRepository) DeleteByClientID(ctx context.Context, clientID string) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"client_id": clientID})
+	return err
+}
+
+// DeleteByUserAndClient removes any unredeemed authorization codes issued to
+// the client for the user
+func (r *AuthCodeRepository) DeleteByUserAndClient(ctx context.Context, userID, clientID string) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"user_id": userID, "client_id": clientID})
+	return err
+}
@@ -0,0 +1,102 @@
+package repository
+
+import (
+	"context"
+	"oauth2-server/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// AuthorizationRequestRepository stores the authorization requests waiting
+// for login, MFA or consent, keyed by their challenge
+type AuthorizationRequestRepository struct {
+	collection *mongo.Collection
+}
+
+func NewAuthorizationRequestRepository(db *mongo.Database) *AuthorizationRequestRepository {
+	return &AuthorizationRequestRepository{
+		collection: db.Collection("authorization_requests"),
+	}
+}
+
+func (r *AuthorizationRequestRepository) Create(ctx context.Context, request *models.AuthorizationRequest) error {
+	request.CreatedAt = time.Now()
+	_, err := r.collection.InsertOne(ctx, request)
+	return translate(err)
+}
+
+func (r *AuthorizationRequestRepository) FindByChallenge(ctx context.Context, challenge string) (*models.AuthorizationRequest, error) {
+	var request models.AuthorizationRequest
+	err := r.collection.FindOne(ctx, bson.M{"challenge": challenge}).Decode(&request)
+	if err != nil {
+		return nil, translate(err)
+	}
+	return &request, nil
+}
+
+// FindPendingDuplicate returns a request waiting for login created since the
+// given time with the same authorization request parameters. It is used to
+// collapse rapid duplicate /oauth/authorize submissions (refresh, back
+// button, double click) into a single pending request.
+func (r *AuthorizationRequestRepository) FindPendingDuplicate(ctx context.Context, request *models.AuthorizationRequest, since time.Time) (*models.AuthorizationRequest, error) {
+	var existing models.AuthorizationRequest
+	err := r.collection.FindOne(ctx, bson.M{
+		"client_id":        request.ClientID,
+		"redirect_uri":     request.RedirectURI,
+		"scope":            request.Scope,
+		"state":            request.State,
+		"nonce":            optionalValue(request.Nonce),
+		"code_challenge":   optionalValue(request.CodeChallenge),
+		"challenge_method": optionalValue(request.ChallengeMethod),
+		"resources":        optionalValues(request.Resources),
+		"login_hint":       optionalValue(request.LoginHint),
+		"acr_values":       optionalValue(request.ACRValues),
+		"stage":            models.AuthorizationStageLogin,
+		"created_at":       bson.M{"$gte": since},
+		"expires_at":       bson.M{"$gt": time.Now()},
+	}).Decode(&existing)
+	if err != nil {
+		return nil, translate(err)
+	}
+	return &existing, nil
+}
+
+// optionalValue matches omitempty fields, which are absent from the stored
+// document when empty
+func optionalValue(value string) interface{} {
+	if value == "" {
+		return bson.M{"$in": bson.A{"", nil}}
+	}
+	return value
+}
+
+// optionalValues matches omitempty list fields in order, which are absent
+// from the stored document when empty
+func optionalValues(values []string) interface{} {
+	if len(values) == 0 {
+		return bson.M{"$in": bson.A{nil, bson.A{}}}
+	}
+	return values
+}
+
+func (r *AuthorizationRequestRepository) Update(ctx context.Context, request *models.AuthorizationRequest) error {
+	_, err := r.collection.UpdateOne(
+		ctx,
+		bson.M{"challenge": request.Challenge},
+		bson.M{"$set": request},
+	)
+	return err
+}
+
+func (r *AuthorizationRequestRepository) Delete(ctx context.Context, challenge string) error {
+	_, err := r.collection.DeleteOne(ctx, bson.M{"challenge": challenge})
+	return err
+}
+
+// DeleteByUserID removes the pending requests of a signed-in user
+func (r *AuthorizationRequestRepository) DeleteByUserID(ctx context.Context, userID string) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"context"
+	"oauth2-server/database"
+	"oauth2-server/models"
+	"testing"
+	"time"
+)
+
+func setupAuthorizationRequestTestDB(t *testing.T) (*AuthorizationRequestRepository, func()) {
+	db, err := database.Connect("mongodb://localhost:27017", "oauth2_test_authorization_requests", 0, database.PoolOptions{})
+	if err != nil {
+		t.Fatalf("Failed to connect to test database: %v", err)
+	}
+
+	repo := NewAuthorizationRequestRepository(db.DB)
+	repo.collection.Drop(context.Background())
+
+	cleanup := func() {
+		repo.collection.Drop(context.Background())
+		db.Close()
+	}
+	return repo, cleanup
+}
+
+func TestAuthorizationRequestRepository_FindPendingDuplicate(t *testing.T) {
+	repo, cleanup := setupAuthorizationRequestTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	since := time.Now().Add(-time.Minute)
+
+	pending := func(challenge string, resources ...string) *models.AuthorizationRequest {
+		return &models.AuthorizationRequest{
+			Challenge:    challenge,
+			Stage:        models.AuthorizationStageLogin,
+			ClientID:     "client-1",
+			RedirectURI:  "http://localhost:3000/callback",
+			Scope:        "openid profile",
+			State:        "state-1",
+			ResponseType: "code",
+			Resources:    resources,
+			ExpiresAt:    time.Now().Add(10 * time.Minute),
+		}
+	}
+	if err := repo.Create(ctx, pending("challenge-api", "https://api.example.com")); err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		resources []string
+		want      string
+	}{
+		{"same resources", []string{"https://api.example.com"}, "challenge-api"},
+		{"other resource", []string{"https://admin.example.com"}, ""},
+		{"additional resource", []string{"https://api.example.com", "https://admin.example.com"}, ""},
+		{"no resource", nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			existing, err := repo.FindPendingDuplicate(ctx, pending("new", tt.resources...), since)
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("Expected no duplicate, got %s", existing.Challenge)
+				}
+				return
+			}
+			if err != nil || existing.Challenge != tt.want {
+				t.Errorf("Expected %s, got %v (%v)", tt.want, existing, err)
+			}
+		})
+	}
+
+	// A request without resources only matches one without resources
+	if err := repo.Create(ctx, pending("challenge-none")); err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	existing, err := repo.FindPendingDuplicate(ctx, pending("new"), since)
+	if err != nil || existing.Challenge != "challenge-none" {
+		t.Errorf("Expected challenge-none, got %v (%v)", existing, err)
+	}
+}
@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"context"
+	"oauth2-server/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CLILoginRepository stores CLI logins waiting to be approved at /activate
+type CLILoginRepository struct {
+	collection *mongo.Collection
+}
+
+func NewCLILoginRepository(db *mongo.Database) *CLILoginRepository {
+	return &CLILoginRepository{
+		collection: db.Collection("cli_logins"),
+	}
+}
+
+func (r *CLILoginRepository) Create(ctx context.Context, login *models.CLILogin) error {
+	login.Status = models.CLILoginPending
+	login.CreatedAt = time.Now()
+	_, err := r.collection.InsertOne(ctx, login)
+	return translate(err)
+}
+
+// FindPendingByUserCode returns the unexpired login still waiting for the
+// user code, or ErrNotFound
+func (r *CLILoginRepository) FindPendingByUserCode(ctx context.Context, userCode string) (*models.CLILogin, error) {
+	var login models.CLILogin
+	filter := bson.M{
+		"user_code":  userCode,
+		"status":     models.CLILoginPending,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}
+	if err := r.collection.FindOne(ctx, filter).Decode(&login); err != nil {
+		return nil, translate(err)
+	}
+	return &login, nil
+}
+
+// Decide approves or denies a pending login on behalf of the user. It returns
+// ErrConflict if the login was already decided or has expired.
+func (r *CLILoginRepository) Decide(ctx context.Context, userCode, status, userID string) error {
+	filter := bson.M{
+		"user_code":  userCode,
+		"status":     models.CLILoginPending,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}
+	result, err := r.collection.UpdateOne(ctx, filter, bson.M{
+		"$set": bson.M{"status": status, "user_id": userID},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrConflict
+	}
+	return nil
+}
+
+// Poll records a poll for the device code and returns the login as it was
+// before, so the caller can tell how long ago the previous poll was
+func (r *CLILoginRepository) Poll(ctx context.Context, deviceCode string) (*models.CLILogin, error) {
+	var login models.CLILogin
+	err := r.collection.FindOneAndUpdate(ctx,
+		bson.M{"device_code": deviceCode},
+		bson.M{"$set": bson.M{"last_polled_at": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.Before),
+	).Decode(&login)
+	if err != nil {
+		return nil, translate(err)
+	}
+	return &login, nil
+}
+
+// Redeem removes an approved login so its device code yields tokens once.
+// It returns ErrNotFound if the login was already redeemed.
+func (r *CLILoginRepository) Redeem(ctx context.Context, deviceCode string) (*models.CLILogin, error) {
+	var login models.CLILogin
+	filter := bson.M{"device_code": deviceCode, "status": models.CLILoginApproved}
+	if err := r.collection.FindOneAndDelete(ctx, filter).Decode(&login); err != nil {
+		return nil, translate(err)
+	}
+	return &login, nil
+}
+
+// Delete removes a login, e.g. once it was denied or has expired
+func (r *CLILoginRepository) Delete(ctx context.Context, deviceCode string) error {
+	_, err := r.collection.DeleteOne(ctx, bson.M{"device_code": deviceCode})
+	return err
+}
@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"container/list"
+	"fmt"
+	"io"
+	"oauth2-server/models"
+	"sync"
+	"time"
+)
+
+// ClientCache keeps recently looked up clients in memory for a short TTL,
+// evicting the least recently used once it holds size clients. Lookups of
+// unknown clients are not cached, so a newly registered client is found at
+// once. Changes made through another server instance are seen when the
+// entry expires.
+type ClientCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[string]*list.Element
+	lru     *list.List // of *clientCacheEntry, most recently used first
+
+	hits      int64
+	misses    int64
+	evictions int64
+	now       func() time.Time
+}
+
+type clientCacheEntry struct {
+	client    models.Client
+	expiresAt time.Time
+}
+
+// NewClientCache holds up to size clients, each for at most ttl
+func NewClientCache(size int, ttl time.Duration) *ClientCache {
+	return &ClientCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*list.Element, size),
+		lru:     list.New(),
+		now:     time.Now,
+	}
+}
+
+// get returns a copy of the cached client, so callers may change it freely
+func (c *ClientCache) get(clientID string) (*models.Client, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[clientID]
+	if ok && c.now().Before(elem.Value.(*clientCacheEntry).expiresAt) {
+		c.hits++
+		c.lru.MoveToFront(elem)
+		client := elem.Value.(*clientCacheEntry).client
+		return &client, true
+	}
+	if ok {
+		c.remove(elem)
+	}
+	c.misses++
+	return nil, false
+}
+
+func (c *ClientCache) put(client *models.Client) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &clientCacheEntry{client: *client, expiresAt: c.now().Add(c.ttl)}
+	if elem, ok := c.entries[client.ClientID]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[client.ClientID] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+		c.evictions++
+	}
+}
+
+// invalidate drops the client after it has been changed or deleted
+func (c *ClientCache) invalidate(clientID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[clientID]; ok {
+		c.remove(elem)
+	}
+}
+
+func (c *ClientCache) remove(elem *list.Element) {
+	delete(c.entries, elem.Value.(*clientCacheEntry).client.ClientID)
+	c.lru.Remove(elem)
+}
+
+// ClientCacheStats is a snapshot of the cache
+type ClientCacheStats struct {
+	Size      int   `json:"size"`
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Evictions int64 `json:"evictions"`
+}
+
+// HitRate is the fraction of lookups answered from the cache
+func (s ClientCacheStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// Stats returns the cache's size and lookup counts
+func (c *ClientCache) Stats() ClientCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return ClientCacheStats{
+		Size:      c.lru.Len(),
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+	}
+}
+
+// WritePrometheus writes the cache counters in the Prometheus text
+// exposition format
+func (c *ClientCache) WritePrometheus(w io.Writer) {
+	stats := c.Stats()
+	metric := func(name, kind, help string, value interface{}) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, value)
+	}
+	metric("oauth_client_cache_size", "gauge", "Clients held in the lookup cache.", stats.Size)
+	metric("oauth_client_cache_hits_total", "counter", "Client lookups answered from the cache.", stats.Hits)
+	metric("oauth_client_cache_misses_total", "counter", "Client lookups that went to the database.", stats.Misses)
+	metric("oauth_client_cache_evictions_total", "counter", "Clients evicted to keep the cache within its size.", stats.Evictions)
+	metric("oauth_client_cache_hit_ratio", "gauge", "Fraction of client lookups answered from the cache.", stats.HitRate())
+}
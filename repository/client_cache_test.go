@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"oauth2-server/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientCache(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := NewClientCache(2, time.Minute)
+	cache.now = func() time.Time { return now }
+
+	if _, ok := cache.get("a"); ok {
+		t.Fatal("Expected a miss on an empty cache")
+	}
+
+	cache.put(&models.Client{ClientID: "a", Name: "App A"})
+	client, ok := cache.get("a")
+	if !ok || client.Name != "App A" {
+		t.Fatalf("Expected cached client, got %+v, %v", client, ok)
+	}
+
+	// Callers get a copy, so changing it leaves the cache intact
+	client.Name = "changed"
+	if client, _ := cache.get("a"); client.Name != "App A" {
+		t.Errorf("Expected cached name to be unchanged, got %q", client.Name)
+	}
+
+	// b is the least recently used once c is added
+	cache.put(&models.Client{ClientID: "b"})
+	cache.get("a")
+	cache.put(&models.Client{ClientID: "c"})
+	if _, ok := cache.get("b"); ok {
+		t.Error("Expected least recently used client to be evicted")
+	}
+	if _, ok := cache.get("a"); !ok {
+		t.Error("Expected recently used client to stay cached")
+	}
+
+	cache.invalidate("a")
+	if _, ok := cache.get("a"); ok {
+		t.Error("Expected invalidated client to be gone")
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok := cache.get("c"); ok {
+		t.Error("Expected expired client to be gone")
+	}
+
+	stats := cache.Stats()
+	if stats.Size != 0 || stats.Hits != 4 || stats.Misses != 4 || stats.Evictions != 1 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	if stats.HitRate() != 0.5 {
+		t.Errorf("Expected hit rate 0.5, got %v", stats.HitRate())
+	}
+
+	var metrics strings.Builder
+	cache.WritePrometheus(&metrics)
+	for _, line := range []string{"oauth_client_cache_hits_total 4", "oauth_client_cache_hit_ratio 0.5"} {
+		if !strings.Contains(metrics.String(), line) {
+			t.Errorf("Expected metrics to contain %q, got:\n%s", line, metrics.String())
+		}
+	}
+}
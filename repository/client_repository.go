@@ -7,29 +7,194 @@ import (
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type ClientRepository struct {
 	collection *mongo.Collection
+	// reads serves client lookups on the authorization and token paths
+	reads *mongo.Collection
+	// cache, when set, answers repeated lookups by client_id
+	cache *ClientCache
 }
 
 func NewClientRepository(db *mongo.Database) *ClientRepository {
+	collection := db.Collection("clients")
 	return &ClientRepository{
-		collection: db.Collection("clients"),
+		collection: collection,
+		reads:      collection,
+	}
+}
+
+// ReadFromSecondaries sends client lookups to replica set secondaries.
+// Writes stay on the primary, so a lookup may briefly miss a recent change.
+func (r *ClientRepository) ReadFromSecondaries() {
+	r.reads = secondaryReads(r.collection)
+}
+
+// UseCache answers FindByClientID from cache when it can. Every change made
+// through the repository drops the client from the cache.
+func (r *ClientRepository) UseCache(cache *ClientCache) {
+	r.cache = cache
+}
+
+func (r *ClientRepository) invalidate(clientID string) {
+	if r.cache != nil {
+		r.cache.invalidate(clientID)
 	}
 }
 
 func (r *ClientRepository) Create(ctx context.Context, client *models.Client) error {
 	client.CreatedAt = time.Now()
 	_, err := r.collection.InsertOne(ctx, client)
-	return err
+	return translate(err)
 }
 
 func (r *ClientRepository) FindByClientID(ctx context.Context, clientID string) (*models.Client, error) {
+	if r.cache != nil {
+		if client, ok := r.cache.get(clientID); ok {
+			return client, nil
+		}
+	}
+
 	var client models.Client
-	err := r.collection.FindOne(ctx, bson.M{"client_id": clientID}).Decode(&client)
+	err := r.reads.FindOne(ctx, bson.M{"client_id": clientID}).Decode(&client)
 	if err != nil {
-		return nil, err
+		return nil, translate(err)
+	}
+	if r.cache != nil {
+		r.cache.put(&client)
 	}
 	return &client, nil
 }
+
+// FindByOwner returns the clients owned by a user, newest first
+func (r *ClientRepository) FindByOwner(ctx context.Context, ownerUserID string) ([]*models.Client, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cursor, err := r.collection.Find(ctx, bson.M{"owner_user_id": ownerUserID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var clients []*models.Client
+	if err := cursor.All(ctx, &clients); err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+// UpdateSettings stores the client's editable settings. Credentials, owner
+// and creation time are left unchanged.
+func (r *ClientRepository) UpdateSettings(ctx context.Context, client *models.Client) error {
+	defer r.invalidate(client.ClientID)
+	_, err := r.collection.UpdateOne(ctx, bson.M{"client_id": client.ClientID}, bson.M{
+		"$set": bson.M{
+			"name":                  client.Name,
+			"redirect_uris":         client.RedirectURIs,
+			"allowed_scopes":        client.AllowedScopes,
+			"grant_types":           client.GrantTypes,
+			"response_types":        client.ResponseTypes,
+			"allowed_resources":     client.AllowedResources,
+			"consent_policy":        client.ConsentPolicy,
+			"consent_ttl_days":      client.ConsentTTLDays,
+			"prompt_none_policy":    client.PromptNonePolicy,
+			"scope_mode":            client.ScopeMode,
+			"redirect_uri_matching": client.RedirectMatching,
+			"token_exchange_policy": client.TokenExchange,
+			"access_token_format":   client.TokenFormat,
+
+			"token_endpoint_auth_method":                 client.TokenEndpointAuthMethod,
+			"tls_client_auth_subject_dn":                 client.TLSClientAuthSubjectDN,
+			"tls_client_certificate_thumbprint":          client.TLSClientThumbprint,
+			"tls_client_certificate_bound_access_tokens": client.CertificateBoundTokens,
+
+			"dpop_bound_access_tokens": client.DPoPBoundTokens,
+
+			"userinfo_signed_response_alg":    client.UserInfoSignedAlg,
+			"userinfo_encrypted_response_alg": client.UserInfoEncryptedAlg,
+			"userinfo_encrypted_response_enc": client.UserInfoEncryptedEnc,
+			"jwks":                            client.JWKS,
+			"jwks_uri":                        client.JWKSURI,
+
+			"id_token_encrypted_response_alg": client.IDTokenEncryptedAlg,
+			"id_token_encrypted_response_enc": client.IDTokenEncryptedEnc,
+
+			"consent_message":          client.ConsentMessage,
+			"consent_message_approved": client.ConsentMessageApproved,
+
+			"logo_uri":    client.LogoURI,
+			"brand_color": client.BrandColor,
+
+			"backchannel_logout_uri": client.BackchannelLogoutURI,
+		},
+	})
+	return err
+}
+
+// FindPendingConsentMessages returns the clients whose consent message has
+// not been approved, oldest first
+func (r *ClientRepository) FindPendingConsentMessages(ctx context.Context) ([]*models.Client, error) {
+	filter := bson.M{
+		"consent_message":          bson.M{"$nin": bson.A{nil, ""}},
+		"consent_message_approved": bson.M{"$ne": true},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var clients []*models.Client
+	if err := cursor.All(ctx, &clients); err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+// ApproveConsentMessage approves the client's consent message if it is
+// still message. It returns ErrConflict when the message has changed.
+func (r *ClientRepository) ApproveConsentMessage(ctx context.Context, clientID, message string) error {
+	defer r.invalidate(clientID)
+	result, err := r.collection.UpdateOne(ctx, bson.M{"client_id": clientID, "consent_message": message}, bson.M{
+		"$set": bson.M{"consent_message_approved": true},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrConflict
+	}
+	return nil
+}
+
+// ClearConsentMessage removes the client's consent message
+func (r *ClientRepository) ClearConsentMessage(ctx context.Context, clientID string) error {
+	defer r.invalidate(clientID)
+	result, err := r.collection.UpdateOne(ctx, bson.M{"client_id": clientID}, bson.M{
+		"$unset": bson.M{"consent_message": "", "consent_message_approved": ""},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// UpdateSecret replaces the client secret
+func (r *ClientRepository) UpdateSecret(ctx context.Context, clientID, secret string) error {
+	defer r.invalidate(clientID)
+	_, err := r.collection.UpdateOne(ctx, bson.M{"client_id": clientID}, bson.M{
+		"$set": bson.M{"client_secret": secret},
+	})
+	return err
+}
+
+func (r *ClientRepository) Delete(ctx context.Context, clientID string) error {
+	defer r.invalidate(clientID)
+	_, err := r.collection.DeleteOne(ctx, bson.M{"client_id": clientID})
+	return err
+}
@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"context"
+	"oauth2-server/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type EmailVerificationRepository struct {
+	collection *mongo.Collection
+}
+
+func NewEmailVerificationRepository(db *mongo.Database) *EmailVerificationRepository {
+	return &EmailVerificationRepository{
+		collection: db.Collection("email_verifications"),
+	}
+}
+
+func (r *EmailVerificationRepository) Create(ctx context.Context, verification *models.EmailVerification) error {
+	verification.CreatedAt = time.Now()
+	_, err := r.collection.InsertOne(ctx, verification)
+	return translate(err)
+}
+
+func (r *EmailVerificationRepository) FindByToken(ctx context.Context, token string) (*models.EmailVerification, error) {
+	var verification models.EmailVerification
+	err := r.collection.FindOne(ctx, bson.M{"token": token}).Decode(&verification)
+	if err != nil {
+		return nil, translate(err)
+	}
+	return &verification, nil
+}
+
+func (r *EmailVerificationRepository) Delete(ctx context.Context, token string) error {
+	_, err := r.collection.DeleteOne(ctx, bson.M{"token": token})
+	return err
+}
+
+// DeleteByUserID removes every pending verification for a user, e.g. once
+// the address has been confirmed through one of them
+func (r *EmailVerificationRepository) DeleteByUserID(ctx context.Context, userID string) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
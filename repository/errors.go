@@ -0,0 +1,39 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Errors returned by every repository in place of driver errors, so handlers
+// can tell the cases apart without knowing about the database
+var (
+	// ErrNotFound means no document matched
+	ErrNotFound = errors.New("not found")
+	// ErrDuplicate means a document with the same unique key already exists
+	ErrDuplicate = errors.New("already exists")
+	// ErrConflict means the document changed state since it was read, e.g. a
+	// one-time value was already used
+	ErrConflict = errors.New("conflict")
+	// ErrUnavailable wraps timeouts and network errors talking to the
+	// database; the operation may succeed if retried
+	ErrUnavailable = errors.New("database unavailable")
+)
+
+// translate maps driver errors to the repository errors above. Other errors
+// are returned unchanged.
+func translate(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return ErrNotFound
+	case mongo.IsDuplicateKeyError(err):
+		return ErrDuplicate
+	case mongo.IsTimeout(err) || mongo.IsNetworkError(err):
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	return err
+}
@@ -0,0 +1,39 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestTranslate(t *testing.T) {
+	duplicate := mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 11000, Message: "E11000 duplicate key error"}}}
+	other := errors.New("connection refused")
+
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"nil", nil, nil},
+		{"no documents", mongo.ErrNoDocuments, ErrNotFound},
+		{"duplicate key", duplicate, ErrDuplicate},
+		{"other", other, other},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := translate(tt.err); got != tt.want {
+				t.Errorf("translate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTranslate_Unavailable(t *testing.T) {
+	err := translate(context.DeadlineExceeded)
+	if !errors.Is(err, ErrUnavailable) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected a timeout to be ErrUnavailable wrapping the cause, got %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+package repository
+
+import (
+	"context"
+	"oauth2-server/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GroupRepository stores the user groups provisioned through SCIM
+type GroupRepository struct {
+	collection *mongo.Collection
+}
+
+func NewGroupRepository(db *mongo.Database) *GroupRepository {
+	return &GroupRepository{
+		collection: db.Collection("groups"),
+	}
+}
+
+func (r *GroupRepository) Create(ctx context.Context, group *models.Group) error {
+	group.CreatedAt = time.Now()
+	group.UpdatedAt = group.CreatedAt
+	if group.Members == nil {
+		group.Members = []string{}
+	}
+	_, err := r.collection.InsertOne(ctx, group)
+	return translate(err)
+}
+
+func (r *GroupRepository) FindByID(ctx context.Context, id string) (*models.Group, error) {
+	return r.findOne(ctx, bson.M{"_id": id})
+}
+
+func (r *GroupRepository) FindByDisplayName(ctx context.Context, displayName string) (*models.Group, error) {
+	return r.findOne(ctx, bson.M{"display_name": displayName})
+}
+
+func (r *GroupRepository) FindByExternalID(ctx context.Context, externalID string) (*models.Group, error) {
+	return r.findOne(ctx, bson.M{"external_id": externalID})
+}
+
+func (r *GroupRepository) findOne(ctx context.Context, filter bson.M) (*models.Group, error) {
+	var group models.Group
+	if err := r.collection.FindOne(ctx, filter).Decode(&group); err != nil {
+		return nil, translate(err)
+	}
+	return &group, nil
+}
+
+// FindByMember returns the groups a user belongs to
+func (r *GroupRepository) FindByMember(ctx context.Context, userID string) ([]*models.Group, error) {
+	cursor, err := r.collection.Find(ctx, bson.M{"members": userID}, options.Find().SetSort(bson.D{{Key: "display_name", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	groups := []*models.Group{}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// ListFrom returns up to limit groups after skipping offset, ordered by
+// creation time, and the total group count
+func (r *GroupRepository) ListFrom(ctx context.Context, offset, limit int64) ([]*models.Group, int64, error) {
+	total, err := r.collection.CountDocuments(ctx, bson.M{})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: 1}}).
+		SetSkip(offset).
+		SetLimit(limit)
+	cursor, err := r.collection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	groups := []*models.Group{}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, 0, err
+	}
+	return groups, total, nil
+}
+
+// Update replaces the group's name, external ID and members
+func (r *GroupRepository) Update(ctx context.Context, group *models.Group) error {
+	group.UpdatedAt = time.Now()
+	result, err := r.collection.UpdateOne(ctx, bson.M{"_id": group.ID}, bson.M{"$set": bson.M{
+		"display_name": group.DisplayName,
+		"external_id":  group.ExternalID,
+		"members":      group.Members,
+		"updated_at":   group.UpdatedAt,
+	}})
+	if err != nil {
+		return translate(err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RemoveMember takes a user out of every group, e.g. when it is deleted
+func (r *GroupRepository) RemoveMember(ctx context.Context, userID string) error {
+	_, err := r.collection.UpdateMany(ctx, bson.M{"members": userID}, bson.M{
+		"$pull": bson.M{"members": userID},
+		"$set":  bson.M{"updated_at": time.Now()},
+	})
+	return err
+}
+
+func (r *GroupRepository) Delete(ctx context.Context, id string) error {
+	result, err := r.collection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"context"
+	"oauth2-server/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const discoveryMetadataID = "discovery"
+
+// MetadataRepository stores the discovery document version shared by all
+// server instances, so Last-Modified survives restarts and a change is
+// announced only once
+type MetadataRepository struct {
+	collection *mongo.Collection
+}
+
+func NewMetadataRepository(db *mongo.Database) *MetadataRepository {
+	return &MetadataRepository{
+		collection: db.Collection("server_metadata"),
+	}
+}
+
+// Record stores version as the current discovery version. It returns the
+// stored version and whether this call replaced an earlier one; the first
+// version recorded is not reported as a change.
+func (r *MetadataRepository) Record(ctx context.Context, version string) (*models.MetadataVersion, bool, error) {
+	now := time.Now()
+	result, err := r.collection.UpdateOne(ctx,
+		bson.M{"_id": discoveryMetadataID, "version": bson.M{"$ne": version}},
+		bson.M{"$set": bson.M{"version": version, "modified_at": now}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return nil, false, err
+	}
+	if err == nil && (result.ModifiedCount > 0 || result.UpsertedCount > 0) {
+		current := &models.MetadataVersion{ID: discoveryMetadataID, Version: version, ModifiedAt: now}
+		return current, result.ModifiedCount > 0, nil
+	}
+
+	// Another instance already recorded this version
+	var current models.MetadataVersion
+	if err := r.collection.FindOne(ctx, bson.M{"_id": discoveryMetadataID}).Decode(&current); err != nil {
+		return nil, false, err
+	}
+	return &current, false, nil
+}
@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrInvalidCursor means a page cursor is malformed or was issued for a
+// different sort order
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// PageRequest selects one page of a list ordered by a time field, newest
+// first unless Ascending is set. Paging is keyset based: the cursor holds
+// the sort value and ID of the last item returned, so items added or removed
+// between requests do not shift the pages that follow.
+type PageRequest struct {
+	Limit     int64
+	Cursor    string
+	SortField string
+	Ascending bool
+}
+
+// pageCursor is the decoded form of a cursor
+type pageCursor struct {
+	Sort string    `json:"s"`
+	Time time.Time `json:"t"`
+	ID   string    `json:"id"`
+}
+
+func (p PageRequest) sortKey() string {
+	if p.Ascending {
+		return p.SortField + ":asc"
+	}
+	return p.SortField + ":desc"
+}
+
+// find adds the cursor's position to conds and returns the find options for
+// the page. One item more than the limit is fetched to tell whether another
+// page follows. idField breaks ties between items with the same sort value.
+func (p PageRequest) find(conds bson.A, idField string) (bson.M, *options.FindOptions, error) {
+	order, after := -1, "$lt"
+	if p.Ascending {
+		order, after = 1, "$gt"
+	}
+
+	if p.Cursor != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(p.Cursor)
+		if err != nil {
+			return nil, nil, ErrInvalidCursor
+		}
+		var c pageCursor
+		if err := json.Unmarshal(raw, &c); err != nil || c.Sort != p.sortKey() {
+			return nil, nil, ErrInvalidCursor
+		}
+		conds = append(conds, bson.M{"$or": bson.A{
+			bson.M{p.SortField: bson.M{after: c.Time}},
+			bson.M{p.SortField: c.Time, idField: bson.M{after: c.ID}},
+		}})
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: p.SortField, Value: order}, {Key: idField, Value: order}}).
+		SetLimit(p.Limit + 1)
+	return bson.M{"$and": conds}, opts, nil
+}
+
+// pageOf trims the extra item fetched by find and returns the cursor of the
+// next page, or "" when items holds the last page. key returns an item's
+// sort value and ID.
+func pageOf[T any](p PageRequest, items []T, key func(T) (time.Time, string)) ([]T, string) {
+	if int64(len(items)) <= p.Limit {
+		return items, ""
+	}
+	items = items[:p.Limit]
+	t, id := key(items[len(items)-1])
+	raw, _ := json.Marshal(pageCursor{Sort: p.sortKey(), Time: t, ID: id})
+	return items, base64.RawURLEncoding.EncodeToString(raw)
+}
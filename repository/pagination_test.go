@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestPageRequest_Cursor(t *testing.T) {
+	type item struct {
+		at time.Time
+		id string
+	}
+	now := time.Now().Truncate(time.Millisecond)
+	items := []item{{now, "c"}, {now, "b"}, {now.Add(-time.Minute), "a"}}
+	key := func(i item) (time.Time, string) { return i.at, i.id }
+
+	page := PageRequest{Limit: 2, SortField: "created_at"}
+	got, next := pageOf(page, items, key)
+	if len(got) != 2 || next == "" {
+		t.Fatalf("Expected a full page and a cursor, got %d items and %q", len(got), next)
+	}
+	if _, last := pageOf(page, items[:2], key); last != "" {
+		t.Errorf("Expected no cursor on the last page, got %q", last)
+	}
+
+	page.Cursor = next
+	filter, _, err := page.find(bson.A{bson.M{"user_id": "u1"}}, "session_id")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if conds := filter["$and"].(bson.A); len(conds) != 2 {
+		t.Errorf("Expected the cursor position to be added to the filter, got %v", conds)
+	}
+
+	// A cursor only continues the order it was issued for
+	page.Ascending = true
+	if _, _, err := page.find(bson.A{}, "session_id"); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("Expected ErrInvalidCursor for another order, got %v", err)
+	}
+	page.Cursor = "not-a-cursor"
+	if _, _, err := page.find(bson.A{}, "session_id"); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("Expected ErrInvalidCursor for garbage, got %v", err)
+	}
+}
@@ -0,0 +1,17 @@
+package repository
+
+import (
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// secondaryReads returns a handle on the collection that reads from a
+// secondary when one is available and falls back to the primary otherwise
+func secondaryReads(collection *mongo.Collection) *mongo.Collection {
+	reads, err := collection.Clone(options.Collection().SetReadPreference(readpref.SecondaryPreferred()))
+	if err != nil {
+		return collection
+	}
+	return reads
+}
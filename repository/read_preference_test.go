@@ -0,0 +1,28 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestClientRepository_ReadFromSecondaries(t *testing.T) {
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI("mongodb://localhost:27017"))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer client.Disconnect(ctx)
+
+	repo := NewClientRepository(client.Database("oauth2_test_read_preference"))
+	if repo.reads != repo.collection {
+		t.Fatal("Expected lookups to use the primary by default")
+	}
+
+	repo.ReadFromSecondaries()
+	if repo.reads == repo.collection || repo.reads.Name() != "clients" {
+		t.Error("Expected lookups to use a separate secondary-preferred handle on clients")
+	}
+}
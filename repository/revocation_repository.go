@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"context"
+	"oauth2-server/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RevocationRepository records user, client and grant revocations, one
+// record per subject
+type RevocationRepository struct {
+	collection *mongo.Collection
+}
+
+func NewRevocationRepository(db *mongo.Database) *RevocationRepository {
+	return &RevocationRepository{
+		collection: db.Collection("revocations"),
+	}
+}
+
+// Revoke marks every token the subject holds as revoked now. The record is
+// kept for ttl, the longest lifetime of a token issued before it.
+func (r *RevocationRepository) Revoke(ctx context.Context, subjectType, subjectID string, ttl time.Duration) error {
+	now := time.Now()
+	update := bson.M{"$set": bson.M{
+		"subject_type": subjectType,
+		"subject_id":   subjectID,
+		"revoked_at":   now,
+		"expires_at":   now.Add(ttl),
+	}}
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": revocationID(subjectType, subjectID)}, update, options.Update().SetUpsert(true))
+	return translate(err)
+}
+
+// RevokedAt returns the latest revocation of the user, the client or the
+// user's grant to the client, or the zero time when none has been revoked.
+// Empty IDs are ignored.
+func (r *RevocationRepository) RevokedAt(ctx context.Context, userID, clientID string) (time.Time, error) {
+	var ids []string
+	if userID != "" {
+		ids = append(ids, revocationID(models.RevocationSubjectUser, userID))
+	}
+	if clientID != "" {
+		ids = append(ids, revocationID(models.RevocationSubjectClient, clientID))
+	}
+	if userID != "" && clientID != "" {
+		ids = append(ids, revocationID(models.RevocationSubjectGrant, models.GrantSubjectID(userID, clientID)))
+	}
+	if len(ids) == 0 {
+		return time.Time{}, nil
+	}
+
+	// Checked on every token use, so only the revocation times are read
+	opts := options.Find().SetProjection(bson.M{"revoked_at": 1})
+	cursor, err := r.collection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}}, opts)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer cursor.Close(ctx)
+
+	var revocations []models.Revocation
+	if err := cursor.All(ctx, &revocations); err != nil {
+		return time.Time{}, err
+	}
+
+	var latest time.Time
+	for _, revocation := range revocations {
+		if revocation.RevokedAt.After(latest) {
+			latest = revocation.RevokedAt
+		}
+	}
+	return latest, nil
+}
+
+func revocationID(subjectType, subjectID string) string {
+	return subjectType + ":" + subjectID
+}
@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"context"
+	"oauth2-server/database"
+	"oauth2-server/models"
+	"testing"
+	"time"
+)
+
+func setupRevocationTestDB(t *testing.T) (*RevocationRepository, func()) {
+	db, err := database.Connect("mongodb://localhost:27017", "oauth2_test_revocations", 0, database.PoolOptions{})
+	if err != nil {
+		t.Fatalf("Failed to connect to test database: %v", err)
+	}
+
+	repo := NewRevocationRepository(db.DB)
+	repo.collection.Drop(context.Background())
+
+	return repo, func() {
+		repo.collection.Drop(context.Background())
+		db.Close()
+	}
+}
+
+func TestRevocationRepository_RevokedAt(t *testing.T) {
+	repo, cleanup := setupRevocationTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	revokedAt, err := repo.RevokedAt(ctx, "user-1", "client-1")
+	if err != nil {
+		t.Fatalf("Failed to look up revocation: %v", err)
+	}
+	if !revokedAt.IsZero() {
+		t.Errorf("Expected no revocation, got %v", revokedAt)
+	}
+
+	if err := repo.Revoke(ctx, models.RevocationSubjectUser, "user-1", time.Hour); err != nil {
+		t.Fatalf("Failed to revoke user: %v", err)
+	}
+	userRevokedAt, err := repo.RevokedAt(ctx, "user-1", "")
+	if err != nil || userRevokedAt.IsZero() {
+		t.Fatalf("Expected the user revocation, got %v, %v", userRevokedAt, err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if err := repo.Revoke(ctx, models.RevocationSubjectClient, "client-1", time.Hour); err != nil {
+		t.Fatalf("Failed to revoke client: %v", err)
+	}
+	latest, err := repo.RevokedAt(ctx, "user-1", "client-1")
+	if err != nil {
+		t.Fatalf("Failed to look up revocation: %v", err)
+	}
+	if !latest.After(userRevokedAt) {
+		t.Errorf("Expected the later client revocation, got %v", latest)
+	}
+
+	// Revocations are per subject
+	other, err := repo.RevokedAt(ctx, "user-2", "")
+	if err != nil || !other.IsZero() {
+		t.Errorf("Expected no revocation for another user, got %v, %v", other, err)
+	}
+}
+
+func TestRevocationRepository_Grant(t *testing.T) {
+	repo, cleanup := setupRevocationTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	if err := repo.Revoke(ctx, models.RevocationSubjectGrant, models.GrantSubjectID("user-1", "client-1"), time.Hour); err != nil {
+		t.Fatalf("Failed to revoke grant: %v", err)
+	}
+
+	revokedAt, err := repo.RevokedAt(ctx, "user-1", "client-1")
+	if err != nil || revokedAt.IsZero() {
+		t.Errorf("Expected the grant revocation, got %v, %v", revokedAt, err)
+	}
+
+	// The user's tokens for other clients are unaffected
+	other, err := repo.RevokedAt(ctx, "user-1", "client-2")
+	if err != nil || !other.IsZero() {
+		t.Errorf("Expected no revocation for another client, got %v, %v", other, err)
+	}
+}
@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"oauth2-server/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrScopeExists is returned when creating a scope whose name is taken
+var ErrScopeExists = fmt.Errorf("scope %w", ErrDuplicate)
+
+// ScopeRepository stores custom scope definitions created through the admin API
+type ScopeRepository struct {
+	collection *mongo.Collection
+}
+
+func NewScopeRepository(db *mongo.Database) *ScopeRepository {
+	return &ScopeRepository{
+		collection: db.Collection("scopes"),
+	}
+}
+
+func (r *ScopeRepository) Create(ctx context.Context, scope *models.ScopeDefinition) error {
+	_, err := r.collection.InsertOne(ctx, scope)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrScopeExists
+	}
+	return err
+}
+
+func (r *ScopeRepository) FindByName(ctx context.Context, name string) (*models.ScopeDefinition, error) {
+	var scope models.ScopeDefinition
+	if err := r.collection.FindOne(ctx, bson.M{"name": name}).Decode(&scope); err != nil {
+		return nil, translate(err)
+	}
+	return &scope, nil
+}
+
+// FindAll returns every custom scope sorted by name
+func (r *ScopeRepository) FindAll(ctx context.Context) ([]*models.ScopeDefinition, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}})
+	cursor, err := r.collection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var scopes []*models.ScopeDefinition
+	if err := cursor.All(ctx, &scopes); err != nil {
+		return nil, err
+	}
+	return scopes, nil
+}
+
+// Update replaces the stored definition, returning ErrNotFound
+// when no scope has that name
+func (r *ScopeRepository) Update(ctx context.Context, scope *models.ScopeDefinition) error {
+	result, err := r.collection.ReplaceOne(ctx, bson.M{"name": scope.Name}, scope)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Delete removes the scope, returning ErrNotFound when it does not exist
+func (r *ScopeRepository) Delete(ctx context.Context, name string) error {
+	result, err := r.collection.DeleteOne(ctx, bson.M{"name": name})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
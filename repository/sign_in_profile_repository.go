@@ -0,0 +1,53 @@
+package repository
+
+import (
+	"context"
+	"oauth2-server/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SignInProfileRepository stores where each user has signed in from
+type SignInProfileRepository struct {
+	collection *mongo.Collection
+}
+
+func NewSignInProfileRepository(db *mongo.Database) *SignInProfileRepository {
+	return &SignInProfileRepository{
+		collection: db.Collection("sign_in_profiles"),
+	}
+}
+
+// FindByUserID returns the user's profile, or ErrNotFound before their
+// first recorded sign-in
+func (r *SignInProfileRepository) FindByUserID(ctx context.Context, userID string) (*models.SignInProfile, error) {
+	var profile models.SignInProfile
+	if err := r.collection.FindOne(ctx, bson.M{"_id": userID}).Decode(&profile); err != nil {
+		return nil, translate(err)
+	}
+	return &profile, nil
+}
+
+// Remember adds a sign-in's device, network and, when known, country to the
+// user's profile
+func (r *SignInProfileRepository) Remember(ctx context.Context, userID, device, network, country string) error {
+	seen := bson.M{"devices": device, "networks": network}
+	if country != "" {
+		seen["countries"] = country
+	}
+	_, err := r.collection.UpdateOne(ctx,
+		bson.M{"_id": userID},
+		bson.M{"$addToSet": seen, "$set": bson.M{"updated_at": time.Now()}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// DeleteByUserID forgets where the user signed in from
+func (r *SignInProfileRepository) DeleteByUserID(ctx context.Context, userID string) error {
+	_, err := r.collection.DeleteOne(ctx, bson.M{"_id": userID})
+	return err
+}
@@ -42,10 +42,20 @@ func (r *SSOSessionRepository) createIndexes(ctx context.Context) error {
 		Keys: bson.D{{Key: "expires_at", Value: 1}},
 	}
 	
+	// Compound indexes serve ListByUserID in either sort order
+	createdAtIndex := mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}, {Key: "session_id", Value: -1}},
+	}
+	lastActivityIndex := mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "last_activity", Value: -1}, {Key: "session_id", Value: -1}},
+	}
+
 	_, err := r.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
 		sessionIDIndex,
 		userIDIndex,
 		expiresAtIndex,
+		createdAtIndex,
+		lastActivityIndex,
 	})
 	
 	return err
@@ -59,14 +69,14 @@ func (r *SSOSessionRepository) Create(ctx context.Context, session *models.SSOSe
 		session.LastActivity = time.Now()
 	}
 	_, err := r.collection.InsertOne(ctx, session)
-	return err
+	return translate(err)
 }
 
 func (r *SSOSessionRepository) FindBySessionID(ctx context.Context, sessionID string) (*models.SSOSession, error) {
 	var session models.SSOSession
 	err := r.collection.FindOne(ctx, bson.M{"session_id": sessionID}).Decode(&session)
 	if err != nil {
-		return nil, err
+		return nil, translate(err)
 	}
 	return &session, nil
 }
@@ -80,6 +90,43 @@ func (r *SSOSessionRepository) UpdateLastActivity(ctx context.Context, sessionID
 	return err
 }
 
+// MarkMFA records that the user of the session completed a second factor
+func (r *SSOSessionRepository) MarkMFA(ctx context.Context, sessionID string, at time.Time) error {
+	_, err := r.collection.UpdateOne(
+		ctx,
+		bson.M{"session_id": sessionID},
+		bson.M{"$set": bson.M{"mfa_at": at}},
+	)
+	return err
+}
+
+// FindByConfirmationHash returns the unexpired sign-in waiting for the
+// confirmation link whose token hashes to confirmationHash
+func (r *SSOSessionRepository) FindByConfirmationHash(ctx context.Context, confirmationHash string) (*models.SSOSession, error) {
+	var session models.SSOSession
+	err := r.collection.FindOne(ctx, bson.M{
+		"confirmation_hash": confirmationHash,
+		"expires_at":        bson.M{"$gt": time.Now()},
+	}).Decode(&session)
+	if err != nil {
+		return nil, translate(err)
+	}
+	return &session, nil
+}
+
+// Confirm activates a sign-in the user confirmed by email until expiresAt
+func (r *SSOSessionRepository) Confirm(ctx context.Context, sessionID string, expiresAt time.Time) error {
+	_, err := r.collection.UpdateOne(
+		ctx,
+		bson.M{"session_id": sessionID},
+		bson.M{
+			"$set":   bson.M{"authenticated": true, "expires_at": expiresAt, "last_activity": time.Now()},
+			"$unset": bson.M{"confirmation_hash": "", "resume_challenge": ""},
+		},
+	)
+	return err
+}
+
 func (r *SSOSessionRepository) Delete(ctx context.Context, sessionID string) error {
 	_, err := r.collection.DeleteOne(ctx, bson.M{"session_id": sessionID})
 	return err
@@ -110,3 +157,67 @@ func (r *SSOSessionRepository) FindByUserID(ctx context.Context, userID string)
 	
 	return sessions, nil
 }
+
+// SessionFilter narrows a listing of a user's SSO sessions
+type SessionFilter struct {
+	// ActiveOnly leaves out sessions past their expiry and, when IdleTimeout
+	// is set, sessions idle for longer than it
+	ActiveOnly  bool
+	IdleTimeout time.Duration
+}
+
+// ListByUserID returns one page of the user's SSO sessions, sorted by
+// created_at or last_activity, and the cursor of the next page
+func (r *SSOSessionRepository) ListByUserID(ctx context.Context, userID string, f SessionFilter, page PageRequest) ([]*models.SSOSession, string, error) {
+	conds := bson.A{bson.M{"user_id": userID}}
+	if f.ActiveOnly {
+		now := time.Now()
+		conds = append(conds, bson.M{"expires_at": bson.M{"$gt": now}})
+		if f.IdleTimeout > 0 {
+			conds = append(conds, bson.M{"last_activity": bson.M{"$gt": now.Add(-f.IdleTimeout)}})
+		}
+	}
+
+	filter, opts, err := page.find(conds, "session_id")
+	if err != nil {
+		return nil, "", err
+	}
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	defer cursor.Close(ctx)
+
+	var sessions []*models.SSOSession
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, "", err
+	}
+	sessions, next := pageOf(page, sessions, func(s *models.SSOSession) (time.Time, string) {
+		if page.SortField == "last_activity" {
+			return s.LastActivity, s.SessionID
+		}
+		return s.CreatedAt, s.SessionID
+	})
+	return sessions, next, nil
+}
+
+// DeleteByUserID removes every SSO session belonging to the user
+func (r *SSOSessionRepository) DeleteByUserID(ctx context.Context, userID string) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
+
+// DeleteByUserIDExcept removes the user's SSO sessions other than
+// keepSessionID, or all of them when keepSessionID is empty, and returns how
+// many were removed
+func (r *SSOSessionRepository) DeleteByUserIDExcept(ctx context.Context, userID, keepSessionID string) (int64, error) {
+	filter := bson.M{"user_id": userID}
+	if keepSessionID != "" {
+		filter["session_id"] = bson.M{"$ne": keepSessionID}
+	}
+	result, err := r.collection.DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
@@ -6,13 +6,11 @@ import (
 	"oauth2-server/models"
 	"testing"
 	"time"
-
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 func setupSSOSessionTestDB(t *testing.T) (*database.Database, *SSOSessionRepository, func()) {
 	// Connect to test database
-	db, err := database.Connect("mongodb://localhost:27017", "oauth2_test_sso_sessions")
+	db, err := database.Connect("mongodb://localhost:27017", "oauth2_test_sso_sessions", 0, database.PoolOptions{})
 	if err != nil {
 		t.Fatalf("Failed to connect to test database: %v", err)
 	}
@@ -85,8 +83,8 @@ func TestSSOSessionRepository_FindBySessionID(t *testing.T) {
 
 	// Test finding non-existent session
 	_, err := repo.FindBySessionID(ctx, "non-existent")
-	if err != mongo.ErrNoDocuments {
-		t.Errorf("Expected ErrNoDocuments, got %v", err)
+	if err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
 	}
 
 	// Create a session
@@ -176,7 +174,7 @@ func TestSSOSessionRepository_Delete(t *testing.T) {
 
 	// Verify session no longer exists
 	_, err = repo.FindBySessionID(ctx, session.SessionID)
-	if err != mongo.ErrNoDocuments {
+	if err != ErrNotFound {
 		t.Error("Session should not exist after deletion")
 	}
 }
@@ -225,12 +223,12 @@ func TestSSOSessionRepository_DeleteExpired(t *testing.T) {
 
 	// Verify expired sessions are gone
 	_, err = repo.FindBySessionID(ctx, "expired-1")
-	if err != mongo.ErrNoDocuments {
+	if err != ErrNotFound {
 		t.Error("Expired session 1 should be deleted")
 	}
 
 	_, err = repo.FindBySessionID(ctx, "expired-2")
-	if err != mongo.ErrNoDocuments {
+	if err != ErrNotFound {
 		t.Error("Expired session 2 should be deleted")
 	}
 
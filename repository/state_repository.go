@@ -0,0 +1,39 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrStateReused is returned when a signed state value is redeemed twice
+var ErrStateReused = fmt.Errorf("state already used: %w", ErrConflict)
+
+// StateRepository records redeemed signed state values so a state can only be
+// verified once. Entries expire together with the state itself.
+type StateRepository struct {
+	collection *mongo.Collection
+}
+
+func NewStateRepository(db *mongo.Database) *StateRepository {
+	return &StateRepository{
+		collection: db.Collection("used_states"),
+	}
+}
+
+// MarkUsed records the state ID, returning ErrStateReused if it was already used
+func (r *StateRepository) MarkUsed(ctx context.Context, stateID, clientID string, expiresAt time.Time) error {
+	_, err := r.collection.InsertOne(ctx, bson.M{
+		"state_id":   stateID,
+		"client_id":  clientID,
+		"used_at":    time.Now(),
+		"expires_at": expiresAt,
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrStateReused
+	}
+	return err
+}
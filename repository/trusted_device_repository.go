@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"context"
+	"oauth2-server/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TrustedDeviceRepository stores the browsers users chose to remember after
+// completing MFA
+type TrustedDeviceRepository struct {
+	collection *mongo.Collection
+}
+
+func NewTrustedDeviceRepository(db *mongo.Database) *TrustedDeviceRepository {
+	return &TrustedDeviceRepository{
+		collection: db.Collection("trusted_devices"),
+	}
+}
+
+func (r *TrustedDeviceRepository) Create(ctx context.Context, device *models.TrustedDevice) error {
+	device.CreatedAt = time.Now()
+	device.LastUsedAt = device.CreatedAt
+	_, err := r.collection.InsertOne(ctx, device)
+	return translate(err)
+}
+
+// FindByTokenHash returns the unexpired device whose remember-device cookie
+// hashes to tokenHash
+func (r *TrustedDeviceRepository) FindByTokenHash(ctx context.Context, tokenHash string) (*models.TrustedDevice, error) {
+	var device models.TrustedDevice
+	err := r.collection.FindOne(ctx, bson.M{
+		"token_hash": tokenHash,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&device)
+	if err != nil {
+		return nil, translate(err)
+	}
+	return &device, nil
+}
+
+// Touch records that the device skipped an MFA challenge
+func (r *TrustedDeviceRepository) Touch(ctx context.Context, id string) error {
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"last_used_at": time.Now()}})
+	return err
+}
+
+// ListByUserID returns the user's unexpired devices, most recently used first
+func (r *TrustedDeviceRepository) ListByUserID(ctx context.Context, userID string) ([]*models.TrustedDevice, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "last_used_at", Value: -1}})
+	cursor, err := r.collection.Find(ctx, bson.M{
+		"user_id":    userID,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	devices := []*models.TrustedDevice{}
+	if err := cursor.All(ctx, &devices); err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
+
+// Delete forgets one of the user's devices, returning ErrNotFound when the
+// user has no device with that ID
+func (r *TrustedDeviceRepository) Delete(ctx context.Context, userID, id string) error {
+	result, err := r.collection.DeleteOne(ctx, bson.M{"_id": id, "user_id": userID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteByUserID forgets every device of the user
+func (r *TrustedDeviceRepository) DeleteByUserID(ctx context.Context, userID string) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
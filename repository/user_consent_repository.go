@@ -12,19 +12,34 @@ import (
 
 type UserConsentRepository struct {
 	collection *mongo.Collection
+	// reads serves consent checks on the authorization and token paths
+	reads *mongo.Collection
 }
 
 func NewUserConsentRepository(db *mongo.Database) *UserConsentRepository {
+	collection := db.Collection("user_consents")
 	repo := &UserConsentRepository{
-		collection: db.Collection("user_consents"),
+		collection: collection,
+		reads:      collection,
 	}
 	
 	// Create indexes
 	repo.createIndexes(context.Background())
+	repo.backfillStatus(context.Background())
 	
 	return repo
 }
 
+// backfillStatus marks consents stored before consents had a status as
+// active, so lookups by status find them
+func (r *UserConsentRepository) backfillStatus(ctx context.Context) error {
+	_, err := r.collection.UpdateMany(ctx,
+		bson.M{"status": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"status": models.ConsentStatusActive}},
+	)
+	return err
+}
+
 func (r *UserConsentRepository) createIndexes(ctx context.Context) error {
 	// Create unique compound index on user_id + client_id
 	userClientIndex := mongo.IndexModel{
@@ -34,79 +49,264 @@ func (r *UserConsentRepository) createIndexes(ctx context.Context) error {
 		},
 		Options: options.Index().SetUnique(true),
 	}
+
+	// Create compound index for consent checks, which only match active consents
+	statusIndex := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "user_id", Value: 1},
+			{Key: "client_id", Value: 1},
+			{Key: "status", Value: 1},
+		},
+	}
 	
 	// Create index on user_id for listing user consents
 	userIDIndex := mongo.IndexModel{
 		Keys: bson.D{{Key: "user_id", Value: 1}},
 	}
 	
+	// Create index on client_id for revoking a client's consents
+	clientIDIndex := mongo.IndexModel{
+		Keys: bson.D{{Key: "client_id", Value: 1}},
+	}
+
+	// Create compound index for paging through a user's consents
+	grantedAtIndex := mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "granted_at", Value: -1}, {Key: "client_id", Value: -1}},
+	}
+
 	_, err := r.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
 		userClientIndex,
+		statusIndex,
 		userIDIndex,
+		clientIDIndex,
+		grantedAtIndex,
 	})
 	
 	return err
 }
 
+// ReadFromSecondaries sends consent checks to replica set secondaries.
+// Writes stay on the primary, so a check may briefly miss a consent that was
+// just granted or revoked.
+func (r *UserConsentRepository) ReadFromSecondaries() {
+	r.reads = secondaryReads(r.collection)
+}
+
 func (r *UserConsentRepository) Create(ctx context.Context, consent *models.UserConsent) error {
 	if consent.GrantedAt.IsZero() {
 		consent.GrantedAt = time.Now()
 	}
+	if consent.Status == "" {
+		consent.Status = models.ConsentStatusActive
+	}
+	if len(consent.History) == 0 {
+		consent.History = []models.ConsentEvent{{Action: models.ConsentEventGranted, Scopes: consent.Scopes, At: consent.GrantedAt}}
+		consent.Version = 1
+	}
 	_, err := r.collection.InsertOne(ctx, consent)
-	return err
+	return translate(err)
 }
 
+// Save stores the consent for a user and client, updating any existing
+// record so that a renewed consent gets fresh grant and expiry times. The
+// change is appended to the consent's history with the reference of the SSO
+// session the user granted it from.
+func (r *UserConsentRepository) Save(ctx context.Context, consent *models.UserConsent, sessionRef string) error {
+	if consent.GrantedAt.IsZero() {
+		consent.GrantedAt = time.Now()
+	}
+	filter := bson.M{
+		"user_id":   consent.UserID,
+		"client_id": consent.ClientID,
+	}
+
+	var existing *models.UserConsent
+	var stored models.UserConsent
+	if err := r.collection.FindOne(ctx, filter).Decode(&stored); err == nil {
+		existing = &stored
+	} else if err := translate(err); err != ErrNotFound {
+		return err
+	}
+	events := grantEvents(existing, consent, sessionRef)
+
+	set := bson.M{
+		"scopes":     consent.Scopes,
+		"status":     models.ConsentStatusActive,
+		"granted_at": consent.GrantedAt,
+	}
+	unset := bson.M{}
+	if consent.ExpiresAt.IsZero() {
+		unset["expires_at"] = ""
+	} else {
+		set["expires_at"] = consent.ExpiresAt
+	}
+	if len(consent.ScopeExpiresAt) == 0 {
+		unset["scope_expires_at"] = ""
+	} else {
+		set["scope_expires_at"] = consent.ScopeExpiresAt
+	}
+	update := bson.M{
+		"$set":  set,
+		"$push": bson.M{"history": bson.M{"$each": events}},
+		"$inc":  bson.M{"version": len(events)},
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	_, err := r.collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	return translate(err)
+}
+
+// grantEvents describes granting consent over the existing record: a new or
+// revoked consent is granted, an expired one is recorded as expired and
+// granted again, and an active one either gains scopes or is granted again
+// as is
+func grantEvents(existing, consent *models.UserConsent, sessionRef string) []models.ConsentEvent {
+	at := consent.GrantedAt
+	granted := models.ConsentEvent{Action: models.ConsentEventGranted, Scopes: consent.Scopes, SessionRef: sessionRef, At: at}
+	if existing == nil || existing.Status == models.ConsentStatusRevoked {
+		return []models.ConsentEvent{granted}
+	}
+	if !existing.ExpiresAt.IsZero() && !existing.ExpiresAt.After(at) {
+		expired := models.ConsentEvent{Action: models.ConsentEventExpired, Scopes: existing.Scopes, At: existing.ExpiresAt}
+		return []models.ConsentEvent{expired, granted}
+	}
+
+	had := make(map[string]bool, len(existing.Scopes))
+	for _, scope := range existing.ActiveScopes(at) {
+		had[scope] = true
+	}
+	var added []string
+	for _, scope := range consent.Scopes {
+		if !had[scope] {
+			added = append(added, scope)
+		}
+	}
+	if len(added) == 0 {
+		return []models.ConsentEvent{granted}
+	}
+	return []models.ConsentEvent{{Action: models.ConsentEventScopesAdded, Scopes: added, SessionRef: sessionRef, At: at}}
+}
+
+// FindByUserAndClient returns the user's active consent for the client
 func (r *UserConsentRepository) FindByUserAndClient(ctx context.Context, userID, clientID string) (*models.UserConsent, error) {
+	var consent models.UserConsent
+	err := r.reads.FindOne(ctx, bson.M{
+		"user_id":   userID,
+		"client_id": clientID,
+		"status":    models.ConsentStatusActive,
+	}).Decode(&consent)
+	if err != nil {
+		return nil, translate(err)
+	}
+	return &consent, nil
+}
+
+// FindHistory returns the user's consent for the client, active or revoked,
+// with its history
+func (r *UserConsentRepository) FindHistory(ctx context.Context, userID, clientID string) (*models.UserConsent, error) {
 	var consent models.UserConsent
 	err := r.collection.FindOne(ctx, bson.M{
 		"user_id":   userID,
 		"client_id": clientID,
 	}).Decode(&consent)
 	if err != nil {
-		return nil, err
+		return nil, translate(err)
 	}
 	return &consent, nil
 }
 
-func (r *UserConsentRepository) HasConsent(ctx context.Context, userID, clientID string, scopes []string) (bool, error) {
+// ConsentStatus describes how a stored consent relates to a set of requested scopes
+type ConsentStatus int
+
+const (
+	// ConsentAbsent means there is no usable consent record. An expired
+	// consent that also lacks requested scopes is reported as absent.
+	ConsentAbsent ConsentStatus = iota
+	// ConsentGranted means the consent is valid and covers every requested scope
+	ConsentGranted
+	// ConsentExpired means the consent covers every requested scope but has expired
+	ConsentExpired
+	// ConsentInsufficient means the consent is valid but lacks some requested
+	// scopes or their time-limited grants have expired
+	ConsentInsufficient
+)
+
+// CheckConsent reports the status of a user's consent for a client against
+// the requested scopes, along with the stored consent when one exists
+func (r *UserConsentRepository) CheckConsent(ctx context.Context, userID, clientID string, scopes []string) (ConsentStatus, *models.UserConsent, error) {
 	consent, err := r.FindByUserAndClient(ctx, userID, clientID)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return false, nil
+		if err == ErrNotFound {
+			return ConsentAbsent, nil, nil
 		}
-		return false, err
+		return ConsentAbsent, nil, err
 	}
 	
-	// Check if consent is expired
-	if !consent.ExpiresAt.IsZero() && consent.ExpiresAt.Before(time.Now()) {
-		return false, nil
-	}
+	now := time.Now()
+	expired := !consent.ExpiresAt.IsZero() && consent.ExpiresAt.Before(now)
 	
-	// Check if all requested scopes are included in the stored consent
+	// Check if all requested scopes are included in the stored consent.
+	// Time-limited scope grants that have expired no longer count.
 	consentScopeMap := make(map[string]bool)
-	for _, scope := range consent.Scopes {
+	for _, scope := range consent.ActiveScopes(now) {
 		consentScopeMap[scope] = true
 	}
 	
+	covered := true
 	for _, requestedScope := range scopes {
 		if !consentScopeMap[requestedScope] {
-			return false, nil
+			covered = false
+			break
 		}
 	}
 	
-	return true, nil
+	switch {
+	case covered && !expired:
+		return ConsentGranted, consent, nil
+	case covered && expired:
+		return ConsentExpired, consent, nil
+	case !expired:
+		return ConsentInsufficient, consent, nil
+	}
+	return ConsentAbsent, consent, nil
+}
+
+func (r *UserConsentRepository) HasConsent(ctx context.Context, userID, clientID string, scopes []string) (bool, error) {
+	status, _, err := r.CheckConsent(ctx, userID, clientID, scopes)
+	if err != nil {
+		return false, err
+	}
+	return status == ConsentGranted, nil
+}
+
+// revokeUpdate marks active consents revoked and records who revoked them
+func revokeUpdate(event models.ConsentEvent) bson.M {
+	event.Action = models.ConsentEventRevoked
+	event.At = time.Now()
+	return bson.M{
+		"$set":  bson.M{"status": models.ConsentStatusRevoked},
+		"$push": bson.M{"history": event},
+		"$inc":  bson.M{"version": 1},
+	}
 }
 
-func (r *UserConsentRepository) RevokeConsent(ctx context.Context, userID, clientID string) error {
-	_, err := r.collection.DeleteOne(ctx, bson.M{
+// RevokeConsent revokes the user's consent for the client, keeping the
+// record and its history. sessionRef references the SSO session the user
+// revoked it from, when known.
+func (r *UserConsentRepository) RevokeConsent(ctx context.Context, userID, clientID, sessionRef string) error {
+	_, err := r.collection.UpdateOne(ctx, bson.M{
 		"user_id":   userID,
 		"client_id": clientID,
-	})
+		"status":    models.ConsentStatusActive,
+	}, revokeUpdate(models.ConsentEvent{SessionRef: sessionRef}))
 	return err
 }
 
+// ListUserConsents returns the user's active consents
 func (r *UserConsentRepository) ListUserConsents(ctx context.Context, userID string) ([]*models.UserConsent, error) {
-	cursor, err := r.collection.Find(ctx, bson.M{"user_id": userID})
+	cursor, err := r.collection.Find(ctx, bson.M{"user_id": userID, "status": models.ConsentStatusActive})
 	if err != nil {
 		return nil, err
 	}
@@ -119,3 +319,96 @@ func (r *UserConsentRepository) ListUserConsents(ctx context.Context, userID str
 	
 	return consents, nil
 }
+
+// ConsentFilter narrows a listing of a user's consents
+type ConsentFilter struct {
+	ClientID string
+	// Status selects active (the default) or revoked consents
+	Status string
+	// ActiveOnly leaves out consents past their expiry
+	ActiveOnly bool
+}
+
+// ListPageByUserID returns one page of the user's consents, sorted by
+// granted_at, and the cursor of the next page
+func (r *UserConsentRepository) ListPageByUserID(ctx context.Context, userID string, f ConsentFilter, page PageRequest) ([]*models.UserConsent, string, error) {
+	status := f.Status
+	if status == "" {
+		status = models.ConsentStatusActive
+	}
+	conds := bson.A{bson.M{"user_id": userID}, bson.M{"status": status}}
+	if f.ClientID != "" {
+		conds = append(conds, bson.M{"client_id": f.ClientID})
+	}
+	if f.ActiveOnly {
+		conds = append(conds, bson.M{"$or": bson.A{
+			bson.M{"expires_at": bson.M{"$exists": false}},
+			bson.M{"expires_at": time.Time{}},
+			bson.M{"expires_at": bson.M{"$gt": time.Now()}},
+		}})
+	}
+
+	filter, opts, err := page.find(conds, "client_id")
+	if err != nil {
+		return nil, "", err
+	}
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	defer cursor.Close(ctx)
+
+	var consents []*models.UserConsent
+	if err := cursor.All(ctx, &consents); err != nil {
+		return nil, "", err
+	}
+	consents, next := pageOf(page, consents, func(c *models.UserConsent) (time.Time, string) {
+		return c.GrantedAt, c.ClientID
+	})
+	return consents, next, nil
+}
+
+// DeleteByUserID removes all consents the user has granted, with their
+// history
+func (r *UserConsentRepository) DeleteByUserID(ctx context.Context, userID string) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
+
+// CountByClientID returns how many users have an active consent for the client
+func (r *UserConsentRepository) CountByClientID(ctx context.Context, clientID string) (int64, error) {
+	return r.collection.CountDocuments(ctx, bson.M{"client_id": clientID, "status": models.ConsentStatusActive})
+}
+
+// DeleteByClientID removes every consent granted to the client, with their
+// history
+func (r *UserConsentRepository) DeleteByClientID(ctx context.Context, clientID string) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"client_id": clientID})
+	return err
+}
+
+// RevokeAllForUser revokes every active consent the user has granted in one
+// bulk operation and returns how many were revoked
+func (r *UserConsentRepository) RevokeAllForUser(ctx context.Context, userID, sessionRef string) (int64, error) {
+	result, err := r.collection.UpdateMany(ctx,
+		bson.M{"user_id": userID, "status": models.ConsentStatusActive},
+		revokeUpdate(models.ConsentEvent{SessionRef: sessionRef}),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// RevokeAllForClient revokes every user's active consent for the client in
+// one bulk operation on behalf of the admin and returns how many were revoked
+func (r *UserConsentRepository) RevokeAllForClient(ctx context.Context, clientID, adminID string) (int64, error) {
+	result, err := r.collection.UpdateMany(ctx,
+		bson.M{"client_id": clientID, "status": models.ConsentStatusActive},
+		revokeUpdate(models.ConsentEvent{Actor: adminID}),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
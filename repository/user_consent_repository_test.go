@@ -4,15 +4,14 @@ import (
 	"context"
 	"oauth2-server/database"
 	"oauth2-server/models"
+	"strings"
 	"testing"
 	"time"
-
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 func setupUserConsentTestDB(t *testing.T) (*database.Database, *UserConsentRepository, func()) {
 	// Connect to test database
-	db, err := database.Connect("mongodb://localhost:27017", "oauth2_test_user_consents")
+	db, err := database.Connect("mongodb://localhost:27017", "oauth2_test_user_consents", 0, database.PoolOptions{})
 	if err != nil {
 		t.Fatalf("Failed to connect to test database: %v", err)
 	}
@@ -80,8 +79,8 @@ func TestUserConsentRepository_FindByUserAndClient(t *testing.T) {
 
 	// Test finding non-existent consent
 	_, err := repo.FindByUserAndClient(ctx, "non-existent-user", "non-existent-client")
-	if err != mongo.ErrNoDocuments {
-		t.Errorf("Expected ErrNoDocuments, got %v", err)
+	if err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
 	}
 
 	// Create a consent
@@ -236,6 +235,130 @@ func TestUserConsentRepository_HasConsent_ExpiredConsent(t *testing.T) {
 	}
 }
 
+func TestUserConsentRepository_HasConsent_ExpiredScopeGrant(t *testing.T) {
+	_, repo, cleanup := setupUserConsentTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	// The consent is valid but its payment grant lapsed a minute ago
+	consent := &models.UserConsent{
+		UserID:    "user-payment",
+		ClientID:  "client-payment",
+		Scopes:    []string{"openid", "payment"},
+		ExpiresAt: time.Now().Add(24 * time.Hour),
+		ScopeExpiresAt: map[string]time.Time{
+			"payment": time.Now().Add(-1 * time.Minute),
+		},
+	}
+	repo.Create(ctx, consent)
+
+	hasConsent, err := repo.HasConsent(ctx, "user-payment", "client-payment", []string{"openid"})
+	if err != nil {
+		t.Fatalf("HasConsent returned error: %v", err)
+	}
+	if !hasConsent {
+		t.Error("HasConsent should return true for scopes without an expired grant")
+	}
+
+	status, _, err := repo.CheckConsent(ctx, "user-payment", "client-payment", []string{"openid", "payment"})
+	if err != nil {
+		t.Fatalf("CheckConsent returned error: %v", err)
+	}
+	if status != ConsentInsufficient {
+		t.Errorf("Expected ConsentInsufficient for an expired scope grant, got %v", status)
+	}
+}
+
+func TestUserConsentRepository_CheckConsent(t *testing.T) {
+	_, repo, cleanup := setupUserConsentTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	repo.Create(ctx, &models.UserConsent{
+		UserID:    "user-valid",
+		ClientID:  "client-status",
+		Scopes:    []string{"openid", "profile"},
+		ExpiresAt: time.Now().Add(1 * time.Hour),
+	})
+	repo.Create(ctx, &models.UserConsent{
+		UserID:    "user-expired",
+		ClientID:  "client-status",
+		Scopes:    []string{"openid", "profile"},
+		ExpiresAt: time.Now().Add(-1 * time.Hour),
+	})
+
+	tests := []struct {
+		name   string
+		userID string
+		scopes []string
+		want   ConsentStatus
+	}{
+		{"granted", "user-valid", []string{"openid"}, ConsentGranted},
+		{"insufficient", "user-valid", []string{"openid", "email"}, ConsentInsufficient},
+		{"expired renewal", "user-expired", []string{"openid", "profile"}, ConsentExpired},
+		{"expired with new scopes", "user-expired", []string{"openid", "email"}, ConsentAbsent},
+		{"absent", "user-none", []string{"openid"}, ConsentAbsent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, _, err := repo.CheckConsent(ctx, tt.userID, "client-status", tt.scopes)
+			if err != nil {
+				t.Fatalf("CheckConsent returned error: %v", err)
+			}
+			if status != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, status)
+			}
+		})
+	}
+}
+
+func TestUserConsentRepository_SaveRenewsExpiredConsent(t *testing.T) {
+	_, repo, cleanup := setupUserConsentTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	repo.Create(ctx, &models.UserConsent{
+		UserID:    "user-renew",
+		ClientID:  "client-renew",
+		Scopes:    []string{"openid", "profile"},
+		ExpiresAt: time.Now().Add(-1 * time.Hour),
+	})
+
+	err := repo.Save(ctx, &models.UserConsent{
+		UserID:    "user-renew",
+		ClientID:  "client-renew",
+		Scopes:    []string{"openid", "profile"},
+		ExpiresAt: time.Now().Add(1 * time.Hour),
+	}, "session-ref")
+	if err != nil {
+		t.Fatalf("Failed to save consent: %v", err)
+	}
+
+	hasConsent, err := repo.HasConsent(ctx, "user-renew", "client-renew", []string{"openid", "profile"})
+	if err != nil {
+		t.Fatalf("HasConsent returned error: %v", err)
+	}
+	if !hasConsent {
+		t.Error("HasConsent should return true after renewal")
+	}
+
+	consent, err := repo.FindHistory(ctx, "user-renew", "client-renew")
+	if err != nil {
+		t.Fatalf("Failed to find consent history: %v", err)
+	}
+	var actions []string
+	for _, event := range consent.History {
+		actions = append(actions, event.Action)
+	}
+	if strings.Join(actions, ",") != "granted,expired,granted" || consent.Version != 3 {
+		t.Errorf("Expected granted, expired and granted again at version 3, got %v at version %d", actions, consent.Version)
+	}
+}
+
 func TestUserConsentRepository_HasConsent_NoExpiration(t *testing.T) {
 	_, repo, cleanup := setupUserConsentTestDB(t)
 	defer cleanup()
@@ -284,14 +407,14 @@ func TestUserConsentRepository_RevokeConsent(t *testing.T) {
 	}
 
 	// Revoke consent
-	err = repo.RevokeConsent(ctx, "user-revoke", "client-revoke")
+	err = repo.RevokeConsent(ctx, "user-revoke", "client-revoke", "session-ref")
 	if err != nil {
 		t.Fatalf("Failed to revoke consent: %v", err)
 	}
 
 	// Verify consent no longer exists
 	_, err = repo.FindByUserAndClient(ctx, "user-revoke", "client-revoke")
-	if err != mongo.ErrNoDocuments {
+	if err != ErrNotFound {
 		t.Error("Consent should not exist after revocation")
 	}
 
@@ -300,6 +423,48 @@ func TestUserConsentRepository_RevokeConsent(t *testing.T) {
 	if hasConsent {
 		t.Error("HasConsent should return false after revocation")
 	}
+
+	// The revoked consent is kept with its history
+	revoked, err := repo.FindHistory(ctx, "user-revoke", "client-revoke")
+	if err != nil {
+		t.Fatalf("Revoked consent should be kept: %v", err)
+	}
+	last := revoked.History[len(revoked.History)-1]
+	if revoked.Status != models.ConsentStatusRevoked || last.Action != models.ConsentEventRevoked || last.SessionRef != "session-ref" {
+		t.Errorf("Expected a revoked consent with a revoked event, got status %q and event %+v", revoked.Status, last)
+	}
+}
+
+func TestGrantEvents(t *testing.T) {
+	now := time.Now()
+	granted := &models.UserConsent{Scopes: []string{"openid", "profile", "email"}, GrantedAt: now}
+	tests := []struct {
+		name     string
+		existing *models.UserConsent
+		want     []string
+	}{
+		{"new", nil, []string{models.ConsentEventGranted}},
+		{"revoked", &models.UserConsent{Status: models.ConsentStatusRevoked, Scopes: []string{"openid"}}, []string{models.ConsentEventGranted}},
+		{"expired", &models.UserConsent{Status: models.ConsentStatusActive, Scopes: []string{"openid"}, ExpiresAt: now.Add(-time.Hour)}, []string{models.ConsentEventExpired, models.ConsentEventGranted}},
+		{"more scopes", &models.UserConsent{Status: models.ConsentStatusActive, Scopes: []string{"openid"}}, []string{models.ConsentEventScopesAdded}},
+		{"same scopes", &models.UserConsent{Status: models.ConsentStatusActive, Scopes: []string{"openid", "profile", "email"}}, []string{models.ConsentEventGranted}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, event := range grantEvents(tt.existing, granted, "session-ref") {
+				got = append(got, event.Action)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	added := grantEvents(&models.UserConsent{Status: models.ConsentStatusActive, Scopes: []string{"openid"}}, granted, "session-ref")[0]
+	if strings.Join(added.Scopes, " ") != "profile email" || added.SessionRef != "session-ref" {
+		t.Errorf("Expected profile and email added from the session, got %+v", added)
+	}
 }
 
 func TestUserConsentRepository_ListUserConsents(t *testing.T) {
@@ -431,7 +596,7 @@ func TestUserConsentRepository_EdgeCases(t *testing.T) {
 	})
 
 	t.Run("Revoke non-existent consent", func(t *testing.T) {
-		err := repo.RevokeConsent(ctx, "non-existent-user", "non-existent-client")
+		err := repo.RevokeConsent(ctx, "non-existent-user", "non-existent-client", "")
 		// Should not error, just no-op
 		if err != nil {
 			t.Errorf("RevokeConsent should not error for non-existent consent: %v", err)
@@ -3,11 +3,13 @@ package repository
 import (
 	"context"
 	"oauth2-server/models"
+	"regexp"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type UserRepository struct {
@@ -24,7 +26,7 @@ func (r *UserRepository) Create(ctx context.Context, user *models.User) error {
 	user.CreatedAt = time.Now()
 	result, err := r.collection.InsertOne(ctx, user)
 	if err != nil {
-		return err
+		return translate(err)
 	}
 	// Set the ID from the inserted document
 	if oid, ok := result.InsertedID.(string); ok {
@@ -39,26 +41,219 @@ func (r *UserRepository) FindByEmail(ctx context.Context, email string) (*models
 	var user models.User
 	err := r.collection.FindOne(ctx, bson.M{"email": email}).Decode(&user)
 	if err != nil {
-		return nil, err
+		return nil, translate(err)
 	}
 	return &user, nil
 }
 
 func (r *UserRepository) FindByID(ctx context.Context, id string) (*models.User, error) {
+	return r.findByID(ctx, id)
+}
+
+// profileProjection leaves out the fields only sign-in, account linking and
+// SCIM need
+var profileProjection = bson.M{"password": 0, "identities": 0, "external_id": 0}
+
+// FindProfileByID returns the user without the password hash, linked
+// identities or SCIM external ID. The token and UserInfo endpoints use it so
+// each request reads only the fields that become claims.
+func (r *UserRepository) FindProfileByID(ctx context.Context, id string) (*models.User, error) {
+	return r.findByID(ctx, id, options.FindOne().SetProjection(profileProjection))
+}
+
+func (r *UserRepository) findByID(ctx context.Context, id string, opts ...*options.FindOneOptions) (*models.User, error) {
 	var user models.User
 	// Try to find by string ID first
-	err := r.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&user)
+	err := r.collection.FindOne(ctx, bson.M{"_id": id}, opts...).Decode(&user)
 	if err == nil {
 		return &user, nil
 	}
-	
+
 	// If not found, try as ObjectID
 	if oid, err := primitive.ObjectIDFromHex(id); err == nil {
-		err = r.collection.FindOne(ctx, bson.M{"_id": oid}).Decode(&user)
+		err = r.collection.FindOne(ctx, bson.M{"_id": oid}, opts...).Decode(&user)
 		if err == nil {
 			return &user, nil
 		}
 	}
-	
-	return nil, err
+
+	return nil, translate(err)
+}
+
+// FindByIdentity returns the user linked to an upstream identity
+func (r *UserRepository) FindByIdentity(ctx context.Context, provider, subject string) (*models.User, error) {
+	var user models.User
+	err := r.collection.FindOne(ctx, bson.M{
+		"identities": bson.M{"$elemMatch": bson.M{"provider": provider, "subject": subject}},
+	}).Decode(&user)
+	if err != nil {
+		return nil, translate(err)
+	}
+	return &user, nil
+}
+
+// LinkIdentity adds an upstream identity to a user
+func (r *UserRepository) LinkIdentity(ctx context.Context, id string, identity models.ExternalIdentity) error {
+	identity.LinkedAt = time.Now()
+	result, err := r.collection.UpdateOne(ctx, userIDFilter(id), bson.M{"$push": bson.M{"identities": identity}})
+	if err != nil {
+		return translate(err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *UserRepository) MarkEmailVerified(ctx context.Context, id string) error {
+	_, err := r.collection.UpdateOne(ctx, userIDFilter(id), bson.M{"$set": bson.M{"email_verified": true}})
+	return err
+}
+
+// MarkLegacyEmailsVerified marks accounts created before email verification
+// existed as verified, so REQUIRE_EMAIL_VERIFICATION does not lock them out.
+// Those are the accounts without an email_verified field; every account
+// created since stores it. It returns how many accounts were updated.
+func (r *UserRepository) MarkLegacyEmailsVerified(ctx context.Context) (int64, error) {
+	result, err := r.collection.UpdateMany(ctx,
+		bson.M{"email_verified": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"email_verified": true}},
+	)
+	if err != nil {
+		return 0, translate(err)
+	}
+	return result.ModifiedCount, nil
+}
+
+// List returns a page of users whose email or name contains search
+// (case-insensitive), ordered by creation time, and the total match count
+func (r *UserRepository) List(ctx context.Context, search string, page, limit int64) ([]*models.User, int64, error) {
+	filter := bson.M{}
+	if search != "" {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(search), Options: "i"}
+		filter = bson.M{"$or": bson.A{
+			bson.M{"email": pattern},
+			bson.M{"name": pattern},
+		}}
+	}
+	return r.list(ctx, filter, (page-1)*limit, limit)
+}
+
+// ListFrom returns up to limit users after skipping offset, ordered by
+// creation time, and the total user count
+func (r *UserRepository) ListFrom(ctx context.Context, offset, limit int64) ([]*models.User, int64, error) {
+	return r.list(ctx, bson.M{}, offset, limit)
+}
+
+func (r *UserRepository) list(ctx context.Context, filter bson.M, offset, limit int64) ([]*models.User, int64, error) {
+	total, err := r.collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: 1}}).
+		SetSkip(offset).
+		SetLimit(limit)
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	users := []*models.User{}
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+// FindByExternalID returns the user a provisioning identity provider knows
+// by externalID
+func (r *UserRepository) FindByExternalID(ctx context.Context, externalID string) (*models.User, error) {
+	var user models.User
+	err := r.collection.FindOne(ctx, bson.M{"external_id": externalID}).Decode(&user)
+	if err != nil {
+		return nil, translate(err)
+	}
+	return &user, nil
+}
+
+// UpdateProfile sets the user's email, name and external ID
+func (r *UserRepository) UpdateProfile(ctx context.Context, id, email, name, externalID string) error {
+	update := bson.M{"$set": bson.M{"email": email, "name": name, "external_id": externalID}}
+	if externalID == "" {
+		update = bson.M{"$set": bson.M{"email": email, "name": name}, "$unset": bson.M{"external_id": ""}}
+	}
+	result, err := r.collection.UpdateOne(ctx, userIDFilter(id), update)
+	if err != nil {
+		return translate(err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *UserRepository) SetDisabled(ctx context.Context, id string, disabled bool) error {
+	return r.setFlag(ctx, id, "disabled", disabled)
+}
+
+func (r *UserRepository) SetPasswordResetRequired(ctx context.Context, id string, required bool) error {
+	return r.setFlag(ctx, id, "password_reset_required", required)
+}
+
+// AddRole assigns a role to the user; assigning a role it has is a no-op
+func (r *UserRepository) AddRole(ctx context.Context, id, role string) error {
+	result, err := r.collection.UpdateOne(ctx, userIDFilter(id), bson.M{"$addToSet": bson.M{"roles": role}})
+	if err != nil {
+		return translate(err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// UpdatePassword stores a new password hash and clears any pending reset
+func (r *UserRepository) UpdatePassword(ctx context.Context, id, hashedPassword string) error {
+	_, err := r.collection.UpdateOne(ctx, userIDFilter(id), bson.M{
+		"$set":   bson.M{"password": hashedPassword},
+		"$unset": bson.M{"password_reset_required": ""},
+	})
+	return err
+}
+
+func (r *UserRepository) Delete(ctx context.Context, id string) error {
+	result, err := r.collection.DeleteOne(ctx, userIDFilter(id))
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *UserRepository) setFlag(ctx context.Context, id, field string, value bool) error {
+	update := bson.M{"$set": bson.M{field: true}}
+	if !value {
+		update = bson.M{"$unset": bson.M{field: ""}}
+	}
+	result, err := r.collection.UpdateOne(ctx, userIDFilter(id), update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// userIDFilter matches a user stored with either a string or ObjectID _id
+func userIDFilter(id string) bson.M {
+	if oid, err := primitive.ObjectIDFromHex(id); err == nil {
+		return bson.M{"_id": bson.M{"$in": bson.A{id, oid}}}
+	}
+	return bson.M{"_id": id}
 }
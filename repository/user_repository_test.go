@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"context"
+	"oauth2-server/database"
+	"oauth2-server/models"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func setupUserTestDB(t *testing.T) (*UserRepository, func()) {
+	db, err := database.Connect("mongodb://localhost:27017", "oauth2_test_users", 0, database.PoolOptions{})
+	if err != nil {
+		t.Fatalf("Failed to connect to test database: %v", err)
+	}
+
+	repo := NewUserRepository(db.DB)
+	repo.collection.Drop(context.Background())
+
+	return repo, func() {
+		repo.collection.Drop(context.Background())
+		db.Close()
+	}
+}
+
+func TestUserRepository_MarkLegacyEmailsVerified(t *testing.T) {
+	repo, cleanup := setupUserTestDB(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	// An account stored before users had an email_verified field
+	if _, err := repo.collection.InsertOne(ctx, bson.M{"_id": "legacy-user", "email": "legacy@example.com", "created_at": time.Now()}); err != nil {
+		t.Fatalf("Failed to insert legacy user: %v", err)
+	}
+	if err := repo.Create(ctx, &models.User{ID: "new-user", Email: "new@example.com"}); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	verified, err := repo.MarkLegacyEmailsVerified(ctx)
+	if err != nil {
+		t.Fatalf("MarkLegacyEmailsVerified failed: %v", err)
+	}
+	if verified != 1 {
+		t.Errorf("Expected 1 account to be marked verified, got %d", verified)
+	}
+
+	legacy, err := repo.FindByID(ctx, "legacy-user")
+	if err != nil || !legacy.EmailVerified {
+		t.Errorf("Expected the legacy account to be verified, got %+v (%v)", legacy, err)
+	}
+	created, err := repo.FindByID(ctx, "new-user")
+	if err != nil || created.EmailVerified {
+		t.Errorf("Expected the new account to stay unverified, got %+v (%v)", created, err)
+	}
+
+	// Running it again changes nothing
+	if verified, err := repo.MarkLegacyEmailsVerified(ctx); err != nil || verified != 0 {
+		t.Errorf("Expected no further accounts, got %d (%v)", verified, err)
+	}
+}
@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"context"
+	"oauth2-server/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// WebhookRepository stores the webhooks registered for security events
+type WebhookRepository struct {
+	collection *mongo.Collection
+}
+
+func NewWebhookRepository(db *mongo.Database) *WebhookRepository {
+	return &WebhookRepository{
+		collection: db.Collection("webhooks"),
+	}
+}
+
+func (r *WebhookRepository) Create(ctx context.Context, hook *models.Webhook) error {
+	hook.CreatedAt = time.Now()
+	_, err := r.collection.InsertOne(ctx, hook)
+	return translate(err)
+}
+
+// FindAll returns every webhook, oldest first
+func (r *WebhookRepository) FindAll(ctx context.Context) ([]*models.Webhook, error) {
+	return r.find(ctx, bson.M{})
+}
+
+// FindByEvent returns the webhooks subscribed to an event type
+func (r *WebhookRepository) FindByEvent(ctx context.Context, event string) ([]*models.Webhook, error) {
+	return r.find(ctx, bson.M{"events": event})
+}
+
+func (r *WebhookRepository) find(ctx context.Context, filter bson.M) ([]*models.Webhook, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	hooks := []*models.Webhook{}
+	if err := cursor.All(ctx, &hooks); err != nil {
+		return nil, err
+	}
+	return hooks, nil
+}
+
+// Delete removes a webhook, returning ErrNotFound when none has that ID
+func (r *WebhookRepository) Delete(ctx context.Context, id string) error {
+	result, err := r.collection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
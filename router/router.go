@@ -0,0 +1,575 @@
+package router
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"oauth2-server/auditexport"
+	"oauth2-server/backchannel"
+	"oauth2-server/breach"
+	"oauth2-server/config"
+	"oauth2-server/federation"
+	"oauth2-server/geoip"
+	"oauth2-server/handlers"
+	"oauth2-server/jwks"
+	"oauth2-server/mailer"
+	"oauth2-server/middleware"
+	"oauth2-server/policy"
+	"oauth2-server/repository"
+	"oauth2-server/utils"
+	"oauth2-server/webhook"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// corsPublicPaths are the endpoints single-page apps on other origins call
+// directly, open to CORS_PUBLIC_ORIGINS
+var corsPublicPaths = []string{
+	"/oauth/token",
+	"/oauth/userinfo",
+	"/.well-known/jwks.json",
+	"/.well-known/openid-configuration",
+	"/.well-known/oauth-authorization-server",
+}
+
+// Handlers are the endpoint handlers the router dispatches to
+type Handlers struct {
+	Auth            *handlers.AuthHandler
+	OAuth           *handlers.OAuthHandler
+	CLILogin        *handlers.CLILoginHandler
+	Client          *handlers.ClientHandler
+	ClientStats     *handlers.ClientStatsHandler
+	Discovery       *handlers.DiscoveryHandler
+	Policy          *handlers.PolicyHandler
+	JWKS            *handlers.JWKSHandler
+	TokenExchange   *handlers.TokenExchangeHandler
+	BFF             *handlers.BFFHandler
+	TokenValidation *handlers.TokenValidationHandler
+	Consent         *handlers.ConsentHandler
+	Session         *handlers.SessionHandler
+	Admin           *handlers.AdminHandler
+	Audit           *handlers.AuditHandler
+	Webhook         *handlers.WebhookHandler
+	Developer       *handlers.DeveloperHandler
+	Scope           *handlers.ScopeHandler
+	State           *handlers.StateHandler
+	Federation      *handlers.FederationHandler
+	SCIM            *handlers.SCIMHandler
+	Account         *handlers.AccountHandler
+
+	// SSOSessions resolves the SSO cookie for the browser-facing pages
+	SSOSessions *repository.SSOSessionRepository
+	// ClientCache holds recently looked up clients; nil when disabled
+	ClientCache *repository.ClientCache
+	// AuditExporters stream the audit log to the configured file and URL
+	AuditExporters []*auditexport.Exporter
+}
+
+// NewHandlers builds the repositories on db and every handler from them.
+// keyID is the kid of the configured signing key.
+func NewHandlers(cfg *config.Config, db *mongo.Database, keyID string) (*Handlers, error) {
+	var tokenPolicy policy.Policy = policy.AllowAll{}
+	if cfg.TokenPolicyFile != "" {
+		var err error
+		tokenPolicy, err = policy.LoadFile(cfg.TokenPolicyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load token policy: %w", err)
+		}
+	}
+	var identityProviders federation.Providers
+	if cfg.IdentityProvidersFile != "" {
+		var err error
+		identityProviders, err = federation.LoadFile(cfg.IdentityProvidersFile)
+		if err != nil {
+			return nil, fmt.Errorf("load identity providers: %w", err)
+		}
+	}
+	var locations *geoip.Database
+	if cfg.GeoIPFile != "" {
+		var err error
+		if locations, err = geoip.LoadFile(cfg.GeoIPFile); err != nil {
+			return nil, fmt.Errorf("load GeoIP database: %w", err)
+		}
+	}
+	var breached handlers.BreachChecker
+	if cfg.PasswordBreachListFile != "" {
+		list, err := breach.LoadFile(cfg.PasswordBreachListFile)
+		if err != nil {
+			return nil, fmt.Errorf("load password breach list: %w", err)
+		}
+		breached = list
+	}
+
+	userRepo := repository.NewUserRepository(db)
+	clientRepo := repository.NewClientRepository(db)
+	authCodeRepo := repository.NewAuthCodeRepository(db)
+	authRequestRepo := repository.NewAuthorizationRequestRepository(db)
+	ssoSessionRepo := repository.NewSSOSessionRepository(db)
+	consentRepo := repository.NewUserConsentRepository(db)
+	verificationRepo := repository.NewEmailVerificationRepository(db)
+	stateRepo := repository.NewStateRepository(db)
+	auditRepo := repository.NewAuditRepository(db)
+	scopeRepo := repository.NewScopeRepository(db)
+	cliLoginRepo := repository.NewCLILoginRepository(db)
+	accessTokenRepo := repository.NewAccessTokenRepository(db)
+	trustedDeviceRepo := repository.NewTrustedDeviceRepository(db)
+	signInProfileRepo := repository.NewSignInProfileRepository(db)
+	webhookRepo := repository.NewWebhookRepository(db)
+	clientKeys := jwks.NewCache(
+		time.Duration(cfg.JWKSFetchTimeout)*time.Second,
+		time.Duration(cfg.JWKSCacheMaxAge)*time.Second,
+		time.Duration(cfg.JWKSRefreshInterval)*time.Second,
+		int(cfg.JWKSFetchAttempts),
+		500*time.Millisecond,
+	)
+	tokens := handlers.NewTokenStore(accessTokenRepo, repository.NewRevocationRepository(db))
+	audit := handlers.NewAuditor(auditRepo, webhook.NewDispatcher(
+		webhookRepo,
+		time.Duration(cfg.WebhookTimeout)*time.Second,
+		int(cfg.WebhookMaxAttempts),
+		time.Duration(cfg.WebhookRetryBackoff)*time.Second,
+	))
+	// Logout tokens are retried like webhook deliveries
+	logoutNotifier := backchannel.NewNotifier(
+		time.Duration(cfg.WebhookTimeout)*time.Second,
+		int(cfg.WebhookMaxAttempts),
+		time.Duration(cfg.WebhookRetryBackoff)*time.Second,
+	)
+
+	// Hot-path lookups may be served by secondaries to scale reads
+	for _, collection := range cfg.SecondaryReads {
+		switch collection {
+		case "clients":
+			clientRepo.ReadFromSecondaries()
+		case "user_consents":
+			consentRepo.ReadFromSecondaries()
+		case "access_tokens":
+			accessTokenRepo.ReadFromSecondaries()
+		default:
+			return nil, fmt.Errorf("DB_SECONDARY_READS: unsupported collection %q", collection)
+		}
+		log.Printf("Reading %s from secondaries", collection)
+	}
+
+	var clientCache *repository.ClientCache
+	if cfg.ClientCacheSize > 0 && cfg.ClientCacheTTL > 0 {
+		clientCache = repository.NewClientCache(int(cfg.ClientCacheSize), time.Duration(cfg.ClientCacheTTL)*time.Second)
+		clientRepo.UseCache(clientCache)
+	}
+
+	scopeHandler := handlers.NewScopeHandler(scopeRepo, utils.GlobalScopeRegistry, utils.GlobalScopeValidator, audit)
+	if err := scopeHandler.Reload(context.Background()); err != nil {
+		return nil, fmt.Errorf("load custom scopes: %w", err)
+	}
+
+	if cfg.TranslationsDir != "" {
+		if err := utils.GlobalMessageCatalog.LoadDir(cfg.TranslationsDir); err != nil {
+			return nil, fmt.Errorf("load translations: %w", err)
+		}
+	}
+	templates := handlers.NewTemplateRenderer(cfg.DevMode, cfg.TemplateDir, cfg.TemplateOverrideDir)
+	if err := templates.Preload(); err != nil {
+		return nil, fmt.Errorf("parse templates: %w", err)
+	}
+	if cfg.DevMode {
+		log.Printf("Development mode: templates reloaded from %s on every request", cfg.TemplateDir)
+	}
+
+	var dpopNonces *utils.NonceSource
+	if cfg.DPoPNonceLifetime > 0 {
+		dpopNonces = utils.NewNonceSource(utils.DeriveNonceKey(cfg.PrivateKey), time.Duration(cfg.DPoPNonceLifetime)*time.Second)
+	}
+
+	stateSecret := []byte(cfg.StateSigningKey)
+	if len(stateSecret) == 0 {
+		stateSecret = utils.DeriveStateKey(cfg.PrivateKey)
+	}
+
+	oauthHandler := handlers.NewOAuthHandler(userRepo, clientRepo, authCodeRepo, authRequestRepo, consentRepo, ssoSessionRepo, trustedDeviceRepo, audit, tokens, dpopNonces, tokenPolicy, clientKeys, cfg)
+	cliLoginHandler := handlers.NewCLILoginHandler(userRepo, clientRepo, cliLoginRepo, templates, audit, tokens, dpopNonces, tokenPolicy, clientKeys, cfg)
+	oauthHandler.RegisterGrant(handlers.DeviceCodeGrantType, cliLoginHandler)
+	clientHandler := handlers.NewClientHandler(clientRepo, utils.GlobalScopeRegistry, utils.GlobalScopeValidator, audit, cfg)
+	revoker := handlers.NewRevoker(ssoSessionRepo, authCodeRepo, tokens, logoutNotifier, cfg)
+	mail, err := newMailer(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("set up mailer: %w", err)
+	}
+	authHandler := handlers.NewAuthHandler(userRepo, clientRepo, authCodeRepo, authRequestRepo, ssoSessionRepo, verificationRepo, trustedDeviceRepo, signInProfileRepo, mail, locations, breached, templates, audit, tokenPolicy, identityProviders, cfg)
+	groupRepo := repository.NewGroupRepository(db)
+	deleter := handlers.NewUserDeleter(userRepo, clientRepo, authRequestRepo, consentRepo, groupRepo, verificationRepo, trustedDeviceRepo, signInProfileRepo, revoker)
+
+	auditExporters, err := newAuditExporters(cfg, auditRepo)
+	if err != nil {
+		return nil, fmt.Errorf("set up audit export: %w", err)
+	}
+
+	var encryptionKey *rsa.PublicKey
+	if cfg.EncryptionKey != nil {
+		encryptionKey = &cfg.EncryptionKey.PublicKey
+	}
+
+	return &Handlers{
+		Auth:            authHandler,
+		OAuth:           oauthHandler,
+		CLILogin:        cliLoginHandler,
+		Client:          clientHandler,
+		ClientStats:     handlers.NewClientStatsHandler(userRepo, clientRepo, consentRepo, auditRepo, tokens, clientKeys, cfg),
+		Discovery:       handlers.NewDiscoveryHandler(cfg.IssuerURL, utils.GlobalScopeRegistry, keyID, repository.NewMetadataRepository(db), cfg.MetadataWebhooks, cfg.MetadataCacheMaxAge, cfg, oauthHandler.GrantTypes()),
+		Policy:          handlers.NewPolicyHandler(cfg.IssuerURL, utils.GlobalScopeRegistry, cfg, oauthHandler.GrantTypes()),
+		JWKS:            handlers.NewJWKSHandler(cfg.PublicKey, keyID, encryptionKey, cfg.EncryptionKeyID, cfg.MetadataCacheMaxAge),
+		TokenExchange:   handlers.NewTokenExchangeHandler(userRepo, clientRepo, audit, tokens, dpopNonces, tokenPolicy, clientKeys, cfg),
+		BFF:             handlers.NewBFFHandler(userRepo, clientRepo, ssoSessionRepo, consentRepo, audit, tokens, dpopNonces, tokenPolicy, clientKeys, cfg),
+		TokenValidation: handlers.NewTokenValidationHandler(tokens, cfg),
+		Consent:         handlers.NewConsentHandler(clientRepo, consentRepo, authCodeRepo, authRequestRepo, templates, audit, cfg),
+		Session:         handlers.NewSessionHandler(ssoSessionRepo, consentRepo, clientRepo, trustedDeviceRepo, auditRepo, revoker, templates, audit, tokens, cfg),
+		Admin:           handlers.NewAdminHandler(userRepo, clientRepo, ssoSessionRepo, consentRepo, deleter, revoker, audit, tokens, cfg),
+		Audit:           handlers.NewAuditHandler(auditRepo, cfg),
+		Webhook:         handlers.NewWebhookHandler(webhookRepo),
+		Developer:       handlers.NewDeveloperHandler(userRepo, clientRepo, consentRepo, auditRepo, clientHandler, revoker, audit, tokens, cfg),
+		Scope:           scopeHandler,
+		State:           handlers.NewStateHandler(clientRepo, stateRepo, stateSecret, clientKeys, cfg),
+		Federation:      handlers.NewFederationHandler(authHandler, stateRepo, stateSecret, cfg),
+		SCIM:            handlers.NewSCIMHandler(userRepo, groupRepo, deleter, revoker, audit, cfg),
+		Account:         handlers.NewAccountHandler(userRepo, clientRepo, ssoSessionRepo, consentRepo, groupRepo, auditRepo, deleter, audit, tokens, cfg),
+		SSOSessions:     ssoSessionRepo,
+		ClientCache:     clientCache,
+		AuditExporters:  auditExporters,
+	}, nil
+}
+
+// newMailer creates the mailer MAIL_TRANSPORT selects. Production mode
+// requires a real transport, since links and codes that are never delivered
+// lock users out.
+func newMailer(cfg *config.Config) (mailer.Mailer, error) {
+	switch cfg.MailTransport {
+	case "smtp":
+		if cfg.SMTPAddr == "" || cfg.MailFrom == "" {
+			return nil, errors.New("smtp transport requires SMTP_ADDR and MAIL_FROM")
+		}
+		return mailer.NewSMTPMailer(cfg.SMTPAddr, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom)
+	case "http":
+		if cfg.MailHTTPURL == "" || cfg.MailFrom == "" {
+			return nil, errors.New("http transport requires MAIL_HTTP_URL and MAIL_FROM")
+		}
+		return mailer.NewHTTPMailer(cfg.MailHTTPURL, cfg.MailHTTPAuthorization, cfg.MailFrom, 30*time.Second), nil
+	case "log", "":
+		if cfg.ProductionMode {
+			return nil, errors.New("the log transport does not deliver email and is not allowed in production mode")
+		}
+		return mailer.NewLogMailer(), nil
+	}
+	return nil, fmt.Errorf("unknown mail transport %q", cfg.MailTransport)
+}
+
+// newAuditExporters creates an exporter for each configured audit export
+// destination
+func newAuditExporters(cfg *config.Config, auditRepo *repository.AuditRepository) ([]*auditexport.Exporter, error) {
+	if cfg.AuditExportFile == "" && cfg.AuditExportURL == "" {
+		return nil, nil
+	}
+	encoder, err := auditexport.NewEncoder(cfg.AuditExportFormat, cfg.ServiceName, cfg.ServiceVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	var exporters []*auditexport.Exporter
+	if cfg.AuditExportFile != "" {
+		sink := auditexport.NewFileSink(cfg.AuditExportFile)
+		exporters = append(exporters, auditexport.NewExporter("file", auditRepo, sink, encoder, cfg.PrivateKey, auditexport.DefaultBatchSize))
+	}
+	if cfg.AuditExportURL != "" {
+		sink := auditexport.NewHTTPSink(cfg.AuditExportURL, cfg.AuditExportAuthorization, encoder.ContentType(), 30*time.Second)
+		exporters = append(exporters, auditexport.NewExporter("http", auditRepo, sink, encoder, cfg.PrivateKey, auditexport.DefaultBatchSize))
+	}
+	return exporters, nil
+}
+
+// Start publishes the current discovery metadata and runs the background
+// jobs enabled in cfg until ctx is cancelled
+func (h *Handlers) Start(ctx context.Context, cfg *config.Config) {
+	metadata := h.Discovery.Refresh(ctx)
+	log.Printf("Discovery metadata version %s", metadata.Version)
+	if cfg.MetadataCheckInterval > 0 {
+		go h.Discovery.RunWatch(ctx, time.Duration(cfg.MetadataCheckInterval)*time.Second)
+	}
+
+	if cfg.ScopeReloadInterval > 0 {
+		go h.Scope.RunReload(ctx, time.Duration(cfg.ScopeReloadInterval)*time.Second)
+	}
+
+	if cfg.AuditAnchorInterval > 0 {
+		go h.Audit.RunAnchoring(ctx, time.Duration(cfg.AuditAnchorInterval)*time.Second)
+	}
+
+	if cfg.AuditExportInterval > 0 {
+		for _, exporter := range h.AuditExporters {
+			go exporter.Run(ctx, time.Duration(cfg.AuditExportInterval)*time.Second)
+		}
+	}
+}
+
+// New builds the router serving every endpoint. Security headers, CORS,
+// request logging, locale negotiation, error URIs and SLO tracking apply to
+// all routes; the browser-facing pages also resolve the SSO session cookie.
+func New(cfg *config.Config, h *Handlers) *mux.Router {
+	r := mux.NewRouter()
+	r.NotFoundHandler = http.HandlerFunc(handlers.NotFound)
+	r.MethodNotAllowedHandler = http.HandlerFunc(handlers.MethodNotAllowed)
+
+	r.Use(middleware.SecurityHeaders(cfg.HSTSMaxAge, cfg.ContentSecurityPolicy))
+	r.Use(middleware.CORS(middleware.CORSPolicy{
+		AllowedOrigins: cfg.CORSAllowedOrigins,
+		PublicOrigins:  cfg.CORSPublicOrigins,
+		PublicPaths:    corsPublicPaths,
+	}))
+	r.Use(middleware.RequestLogger(cfg.ServiceName, cfg.ServiceVersion, cfg.Logging))
+	r.Use(middleware.LocaleMiddleware(utils.GlobalMessageCatalog))
+	r.Use(middleware.ErrorURIMiddleware(cfg.ErrorURIBase))
+	sloTracker := middleware.NewSLOTracker(sloBudgets(cfg), time.Duration(cfg.SLOWindow)*time.Second)
+	r.Use(sloTracker.Middleware)
+	sloHandler := handlers.NewSLOHandler(sloTracker)
+
+	activity := middleware.NewSessionActivity(
+		time.Duration(cfg.SSOIdleTimeout)*time.Second,
+		time.Duration(cfg.SSOActivityInterval)*time.Second,
+	)
+	sso := func(handler http.HandlerFunc) http.Handler {
+		return middleware.SSOMiddleware(h.SSOSessions, activity)(handler)
+	}
+
+	r.HandleFunc("/.well-known/openid-configuration", h.Discovery.WellKnown).Methods("GET", "OPTIONS")
+	// RFC 8414 authorization server metadata, the same document for OAuth
+	// clients that do not use OpenID Connect discovery
+	r.HandleFunc("/.well-known/oauth-authorization-server", h.Discovery.WellKnown).Methods("GET", "OPTIONS")
+	r.HandleFunc("/.well-known/jwks.json", h.JWKS.JWKS).Methods("GET", "OPTIONS")
+	r.HandleFunc("/.well-known/oauth-policy", h.Policy.Policy).Methods("GET")
+
+	r.HandleFunc("/auth/register", h.Auth.ShowRegister).Methods("GET")
+	r.HandleFunc("/auth/register", h.Auth.Register).Methods("POST", "OPTIONS")
+	r.HandleFunc("/auth/login", h.Auth.ShowLogin).Methods("GET")
+	r.HandleFunc("/auth/login", h.Auth.Login).Methods("POST", "OPTIONS")
+	r.HandleFunc("/auth/logout", h.Auth.Logout).Methods("POST", "OPTIONS")
+	r.HandleFunc("/auth/select-account", h.Auth.ShowSelectAccount).Methods("GET")
+	r.HandleFunc("/auth/select-account", h.Auth.SelectAccount).Methods("POST")
+	r.HandleFunc("/auth/verify-email", h.Auth.VerifyEmail).Methods("GET")
+	r.HandleFunc("/auth/confirm-sign-in", h.Auth.ConfirmSignIn).Methods("GET")
+	r.Handle("/auth/mfa", sso(h.Auth.ShowMFA)).Methods("GET")
+	r.Handle("/auth/mfa", sso(h.Auth.VerifyMFA)).Methods("POST")
+	r.HandleFunc("/auth/verify-email/resend", h.Auth.ResendVerification).Methods("POST", "OPTIONS")
+
+	// Login through an upstream identity provider
+	r.HandleFunc("/auth/federated/{provider}/start", h.Federation.Start).Methods("GET")
+	r.HandleFunc("/auth/federated/{provider}/callback", h.Federation.Callback).Methods("GET")
+
+	// Apply SSO middleware to authorization and consent endpoints
+	r.Handle("/oauth/authorize", sso(h.OAuth.Authorize)).Methods("GET", "OPTIONS")
+	r.Handle("/oauth/consent", sso(h.Consent.ShowConsent)).Methods("GET", "OPTIONS")
+	r.Handle("/oauth/consent", sso(h.Consent.HandleConsent)).Methods("POST", "OPTIONS")
+
+	// JSON login and consent API for custom login UIs
+	r.HandleFunc("/api/auth/login", h.Auth.LoginChallenge).Methods("GET", "OPTIONS")
+	r.HandleFunc("/api/auth/login/accept", h.Auth.AcceptLogin).Methods("POST", "OPTIONS")
+	r.HandleFunc("/api/auth/login/reject", h.Auth.RejectLogin).Methods("POST", "OPTIONS")
+	r.Handle("/api/auth/consent", sso(h.Consent.ConsentChallenge)).Methods("GET", "OPTIONS")
+	r.Handle("/api/auth/consent/accept", sso(h.Consent.AcceptConsent)).Methods("POST", "OPTIONS")
+	r.Handle("/api/auth/consent/reject", sso(h.Consent.RejectConsent)).Methods("POST", "OPTIONS")
+
+	// CLI login: the CLI starts a login and polls the token endpoint while
+	// the user approves its code at /activate
+	r.HandleFunc("/cli/authorize", h.CLILogin.StartLogin).Methods("POST", "OPTIONS")
+	r.Handle("/activate", sso(h.CLILogin.ShowActivate)).Methods("GET")
+	r.Handle("/activate", sso(h.CLILogin.Activate)).Methods("POST")
+
+	// Token requests beyond the adaptive concurrency limit queue briefly and
+	// are then shed, so a surge of refreshes cannot overwhelm the database
+	var tokenLimiter *middleware.ConcurrencyLimiter
+	tokenHandler := http.Handler(http.HandlerFunc(h.OAuth.Token))
+	if cfg.TokenMaxInFlight > 0 {
+		tokenLimiter = middleware.NewConcurrencyLimiter(
+			int(cfg.TokenMaxInFlight),
+			int(cfg.TokenQueueSize),
+			time.Duration(cfg.TokenQueueTimeout)*time.Millisecond,
+			time.Duration(cfg.TokenLatencyTarget)*time.Millisecond,
+		)
+		tokenHandler = tokenLimiter.Middleware(tokenHandler)
+	}
+	r.Handle("/oauth/token", tokenHandler).Methods("POST", "OPTIONS")
+	r.HandleFunc("/oauth/userinfo", h.OAuth.UserInfo).Methods("GET", "OPTIONS")
+	r.HandleFunc("/oauth/scopes", h.Scope.PublicScopes).Methods("GET", "OPTIONS")
+
+	r.HandleFunc("/token/exchange", h.TokenExchange.HandleTokenExchange).Methods("POST", "OPTIONS")
+	r.HandleFunc("/bff/token", h.BFF.RelayToken).Methods("POST", "OPTIONS")
+	r.HandleFunc("/token/validate", h.TokenValidation.ValidateToken).Methods("GET", "POST", "OPTIONS")
+
+	r.HandleFunc("/clients/register", h.Client.RegisterClient).Methods("POST", "OPTIONS")
+	r.HandleFunc("/clients/{client_id}/stats", h.ClientStats.ClientStats).Methods("GET", "OPTIONS")
+
+	// Signed state helper for relying parties
+	r.HandleFunc("/state/issue", h.State.IssueState).Methods("POST", "OPTIONS")
+	r.HandleFunc("/state/verify", h.State.VerifyState).Methods("POST", "OPTIONS")
+
+	// Session management endpoints
+	r.HandleFunc("/account/sessions", h.Session.ListSessions).Methods("GET", "OPTIONS")
+	r.HandleFunc("/account/sessions", h.Session.RevokeAllSessions).Methods("DELETE", "OPTIONS")
+	r.HandleFunc("/account/sessions/{session_id}", h.Session.RevokeSession).Methods("DELETE", "OPTIONS")
+
+	// Browsers remembered after MFA
+	r.HandleFunc("/account/devices", h.Session.ListDevices).Methods("GET", "OPTIONS")
+	r.HandleFunc("/account/devices", h.Session.RevokeAllDevices).Methods("DELETE", "OPTIONS")
+	r.HandleFunc("/account/devices/{device_id}", h.Session.RevokeDevice).Methods("DELETE", "OPTIONS")
+
+	// Authorization management endpoints
+	r.HandleFunc("/account/authorizations", h.Session.ListAuthorizations).Methods("GET", "OPTIONS")
+	r.HandleFunc("/account/authorizations", h.Session.RevokeAllAuthorizations).Methods("DELETE", "OPTIONS")
+	r.HandleFunc("/account/authorizations/{client_id}", h.Session.RevokeAuthorization).Methods("DELETE", "OPTIONS")
+
+	// Server-rendered account page for deployments without a frontend
+	r.Handle("/account", sso(h.Session.ShowAccount)).Methods("GET")
+	r.Handle("/account", sso(h.Session.ManageAccount)).Methods("POST")
+
+	// Self-service data export and account deletion
+	r.HandleFunc("/account", h.Account.DeleteAccount).Methods("DELETE", "OPTIONS")
+	r.HandleFunc("/account/export", h.Account.ExportAccount).Methods("GET", "OPTIONS")
+
+	// Developer portal: users manage the clients they own
+	r.HandleFunc("/developer/clients", h.Developer.ListClients).Methods("GET", "OPTIONS")
+	r.HandleFunc("/developer/clients", h.Developer.CreateClient).Methods("POST", "OPTIONS")
+	r.HandleFunc("/developer/clients/{client_id}", h.Developer.GetClient).Methods("GET", "OPTIONS")
+	r.HandleFunc("/developer/clients/{client_id}", h.Developer.UpdateClient).Methods("PUT", "OPTIONS")
+	r.HandleFunc("/developer/clients/{client_id}", h.Developer.DeleteClient).Methods("DELETE", "OPTIONS")
+	r.HandleFunc("/developer/clients/{client_id}/rotate-secret", h.Developer.RotateSecret).Methods("POST", "OPTIONS")
+	r.HandleFunc("/developer/clients/{client_id}/stats", h.Developer.ClientStats).Methods("GET", "OPTIONS")
+
+	// Admin user management (admin scope + admin role)
+	admin := h.Admin.RequireAdmin
+	r.HandleFunc("/admin/users", admin(h.Admin.ListUsers)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/admin/users/{user_id}", admin(h.Admin.GetUser)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/admin/users/{user_id}", admin(h.Admin.DeleteUser)).Methods("DELETE", "OPTIONS")
+	r.HandleFunc("/admin/users/{user_id}/sessions", admin(h.Admin.ListUserSessions)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/admin/users/{user_id}/revoke-sessions", admin(h.Admin.RevokeUserSessions)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/admin/users/{user_id}/consents", admin(h.Admin.ListUserConsents)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/admin/users/{user_id}/consents/{client_id}/history", admin(h.Admin.ConsentHistory)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/admin/users/{user_id}/disable", admin(h.Admin.DisableUser)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/admin/users/{user_id}/enable", admin(h.Admin.EnableUser)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/admin/users/{user_id}/force-password-reset", admin(h.Admin.ForcePasswordReset)).Methods("POST", "OPTIONS")
+
+	// Admin client management
+	r.HandleFunc("/admin/clients", admin(h.Client.RegisterFirstPartyClient)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/admin/clients/{client_id}", admin(h.Admin.DeleteClient)).Methods("DELETE", "OPTIONS")
+	r.HandleFunc("/admin/clients/{client_id}/rotate-secret", admin(h.Admin.RotateClientSecret)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/admin/clients/{client_id}/consents", admin(h.Admin.RevokeClientConsents)).Methods("DELETE", "OPTIONS")
+	r.HandleFunc("/admin/consent-messages", admin(h.Admin.ListPendingConsentMessages)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/admin/clients/{client_id}/consent-message/approve", admin(h.Admin.ApproveConsentMessage)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/admin/clients/{client_id}/consent-message", admin(h.Admin.RejectConsentMessage)).Methods("DELETE", "OPTIONS")
+
+	// Custom scope definitions
+	r.HandleFunc("/admin/scopes", admin(h.Scope.ListScopes)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/admin/scopes", admin(h.Scope.CreateScope)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/admin/scopes/{name}", admin(h.Scope.UpdateScope)).Methods("PUT", "OPTIONS")
+	r.HandleFunc("/admin/scopes/{name}", admin(h.Scope.DeleteScope)).Methods("DELETE", "OPTIONS")
+
+	// Security event webhooks
+	r.HandleFunc("/admin/webhooks", admin(h.Webhook.ListWebhooks)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/admin/webhooks", admin(h.Webhook.CreateWebhook)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/admin/webhooks/{id}", admin(h.Webhook.DeleteWebhook)).Methods("DELETE", "OPTIONS")
+
+	// Tamper-evident audit log
+	r.HandleFunc("/admin/audit", admin(h.Audit.ListEntries)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/admin/audit/verify", admin(h.Audit.Verify)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/admin/audit/anchor", admin(h.Audit.Anchor)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/admin/audit/export/verify", admin(h.Audit.VerifyExport)).Methods("POST", "OPTIONS")
+
+	// SCIM 2.0 provisioning for corporate identity providers (bearer token)
+	scim := h.SCIM.RequireToken
+	r.HandleFunc("/scim/v2/ServiceProviderConfig", scim(h.SCIM.ServiceProviderConfig)).Methods("GET")
+	r.HandleFunc("/scim/v2/Users", scim(h.SCIM.ListUsers)).Methods("GET")
+	r.HandleFunc("/scim/v2/Users", scim(h.SCIM.CreateUser)).Methods("POST")
+	r.HandleFunc("/scim/v2/Users/{id}", scim(h.SCIM.GetUser)).Methods("GET")
+	r.HandleFunc("/scim/v2/Users/{id}", scim(h.SCIM.PatchUser)).Methods("PATCH")
+	r.HandleFunc("/scim/v2/Users/{id}", scim(h.SCIM.DeleteUser)).Methods("DELETE")
+	r.HandleFunc("/scim/v2/Groups", scim(h.SCIM.ListGroups)).Methods("GET")
+	r.HandleFunc("/scim/v2/Groups", scim(h.SCIM.CreateGroup)).Methods("POST")
+	r.HandleFunc("/scim/v2/Groups/{id}", scim(h.SCIM.GetGroup)).Methods("GET")
+	r.HandleFunc("/scim/v2/Groups/{id}", scim(h.SCIM.PatchGroup)).Methods("PATCH")
+	r.HandleFunc("/scim/v2/Groups/{id}", scim(h.SCIM.DeleteGroup)).Methods("DELETE")
+
+	// Latency and error budgets
+	r.HandleFunc("/admin/slo", admin(sloHandler.Summary)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/metrics", metricsAuth(cfg.MetricsTokens, admin)(func(w http.ResponseWriter, req *http.Request) {
+		sloTracker.ServeMetrics(w, req)
+		if tokenLimiter != nil {
+			tokenLimiter.WritePrometheus(w, "/oauth/token")
+		}
+		if h.ClientCache != nil {
+			h.ClientCache.WritePrometheus(w)
+		}
+		h.TokenValidation.WritePrometheus(w)
+	})).Methods("GET")
+
+	r.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	}).Methods("GET")
+
+	return r
+}
+
+// defaultSLOLatencyBudgets, in milliseconds, are tracked when
+// SLO_LATENCY_BUDGETS is not set
+var defaultSLOLatencyBudgets = map[string]int64{
+	"/oauth/token":     500,
+	"/oauth/authorize": 1000,
+}
+
+// defaultSLOErrorBudget is the percentage of requests a route without an
+// SLO_ERROR_BUDGETS entry may spend on slow or failed requests
+const defaultSLOErrorBudget = 1.0
+
+// sloBudgets builds the per-route budgets from the configured latency and
+// error budgets
+// metricsAuth lets a request bearing one of the metrics tokens through and
+// hands every other request to admin, so /metrics is never public
+func metricsAuth(tokens []string, admin func(http.HandlerFunc) http.HandlerFunc) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		requireAdmin := admin(next)
+		return func(w http.ResponseWriter, r *http.Request) {
+			token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if found && token != "" {
+				for _, allowed := range tokens {
+					if subtle.ConstantTimeCompare([]byte(token), []byte(allowed)) == 1 {
+						next(w, r)
+						return
+					}
+				}
+			}
+			requireAdmin(w, r)
+		}
+	}
+}
+
+func sloBudgets(cfg *config.Config) map[string]middleware.SLOBudget {
+	latencies := cfg.SLOLatencyBudgets
+	if len(latencies) == 0 {
+		latencies = defaultSLOLatencyBudgets
+	}
+
+	budgets := make(map[string]middleware.SLOBudget, len(latencies))
+	for route, ms := range latencies {
+		errorBudget, ok := cfg.SLOErrorBudgets[route]
+		if !ok {
+			errorBudget = defaultSLOErrorBudget
+		}
+		budgets[route] = middleware.SLOBudget{
+			Latency:     time.Duration(ms) * time.Millisecond,
+			ErrorBudget: errorBudget / 100,
+		}
+	}
+	return budgets
+}
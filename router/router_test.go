@@ -0,0 +1,168 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"oauth2-server/config"
+	"oauth2-server/logger"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func testConfig() *config.Config {
+	return &config.Config{
+		Logging:           &logger.LoggerConfig{},
+		CORSPublicOrigins: []string{"*"},
+		HSTSMaxAge:        31536000,
+	}
+}
+
+func TestNew_RegistersEndpoints(t *testing.T) {
+	r := New(testConfig(), &Handlers{})
+
+	for _, route := range []struct{ method, path string }{
+		{"GET", "/.well-known/openid-configuration"},
+		{"GET", "/oauth/authorize"},
+		{"GET", "/oauth/consent"},
+		{"POST", "/oauth/consent"},
+		{"POST", "/oauth/token"},
+		{"GET", "/oauth/userinfo"},
+		{"GET", "/oauth/scopes"},
+		{"POST", "/auth/login"},
+		{"POST", "/auth/logout"},
+		{"GET", "/auth/confirm-sign-in"},
+		{"GET", "/auth/mfa"},
+		{"POST", "/auth/mfa"},
+		{"GET", "/auth/federated/google/start"},
+		{"GET", "/auth/federated/google/callback"},
+		{"GET", "/account"},
+		{"POST", "/account"},
+		{"DELETE", "/account"},
+		{"GET", "/account/export"},
+		{"GET", "/account/sessions"},
+		{"DELETE", "/account/sessions"},
+		{"DELETE", "/account/sessions/sso-1"},
+		{"GET", "/account/devices"},
+		{"DELETE", "/account/devices/device-1"},
+		{"GET", "/account/authorizations"},
+		{"DELETE", "/account/authorizations"},
+		{"DELETE", "/account/authorizations/client-1"},
+		{"GET", "/api/auth/login"},
+		{"POST", "/api/auth/consent/accept"},
+		{"GET", "/activate"},
+		{"GET", "/admin/users"},
+		{"POST", "/admin/users/u-1/revoke-sessions"},
+		{"POST", "/admin/clients/client-1/rotate-secret"},
+		{"PATCH", "/scim/v2/Users/user-1"},
+		{"DELETE", "/scim/v2/Groups/group-1"},
+		{"GET", "/metrics"},
+	} {
+		var match mux.RouteMatch
+		if !r.Match(httptest.NewRequest(route.method, route.path, nil), &match) || match.MatchErr != nil {
+			t.Errorf("Expected a route for %s %s", route.method, route.path)
+		}
+	}
+}
+
+func TestNew_AppliesMiddleware(t *testing.T) {
+	r := New(testConfig(), &Handlers{})
+
+	req := httptest.NewRequest("OPTIONS", "/oauth/token", nil)
+	req.Header.Set("Origin", "https://spa.example.com")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected preflight status 204, got %d", w.Code)
+	}
+	if w.Header().Get("Access-Control-Allow-Origin") != "https://spa.example.com" {
+		t.Errorf("Expected CORS headers on the token endpoint, got %v", w.Header())
+	}
+	if w.Header().Get("Strict-Transport-Security") == "" {
+		t.Error("Expected security headers")
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/health", nil))
+	if w.Code != http.StatusOK || w.Header().Get("X-Transaction-ID") == "" {
+		t.Errorf("Expected a logged 200 from /health, got %d %v", w.Code, w.Header())
+	}
+}
+
+func TestNew_UnknownRoutesReturnOAuthErrors(t *testing.T) {
+	r := New(testConfig(), &Handlers{})
+
+	for _, tt := range []struct {
+		method, path string
+		status       int
+	}{
+		{"GET", "/no/such/path", http.StatusNotFound},
+		{"DELETE", "/health", http.StatusMethodNotAllowed},
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+		if w.Code != tt.status || w.Header().Get("Content-Type") != "application/json" || !strings.Contains(w.Body.String(), `"error":`) {
+			t.Errorf("%s %s: expected a JSON %d, got %d %s", tt.method, tt.path, tt.status, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestNewMailer(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.Config
+		wantErr bool
+	}{
+		{"log in development", config.Config{MailTransport: "log"}, false},
+		{"log in production", config.Config{MailTransport: "log", ProductionMode: true}, true},
+		{"smtp", config.Config{MailTransport: "smtp", SMTPAddr: "smtp.example.com:587", MailFrom: "no-reply@example.com", ProductionMode: true}, false},
+		{"smtp without a relay", config.Config{MailTransport: "smtp", MailFrom: "no-reply@example.com"}, true},
+		{"http", config.Config{MailTransport: "http", MailHTTPURL: "https://mail.example.com/send", MailFrom: "no-reply@example.com"}, false},
+		{"unknown", config.Config{MailTransport: "sendmail"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newMailer(&tt.cfg); (err != nil) != tt.wantErr {
+				t.Errorf("newMailer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMetricsAuth(t *testing.T) {
+	denyAll := func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}
+	metrics := metricsAuth([]string{"scrape-token"}, denyAll)(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name          string
+		authorization string
+		expected      int
+	}{
+		{"Metrics token", "Bearer scrape-token", http.StatusOK},
+		{"No token", "", http.StatusUnauthorized},
+		{"Other token", "Bearer admin-access-token", http.StatusUnauthorized},
+		{"Not a bearer token", "Basic scrape-token", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/metrics", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			metrics(w, r)
+			if w.Code != tt.expected {
+				t.Errorf("Expected %d, got %d", tt.expected, w.Code)
+			}
+		})
+	}
+}
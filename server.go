@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"oauth2-server/config"
+	"oauth2-server/grpcserver"
+	"oauth2-server/router"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// newServer builds the HTTP server with the configured timeouts. When a
+// certificate is configured the server terminates TLS itself.
+func newServer(cfg *config.Config, handler http.Handler) *http.Server {
+	server := &http.Server{
+		Addr:              ":" + cfg.ServerPort,
+		Handler:           handler,
+		ReadHeaderTimeout: time.Duration(cfg.ServerReadTimeout) * time.Second,
+		ReadTimeout:       time.Duration(cfg.ServerReadTimeout) * time.Second,
+		WriteTimeout:      time.Duration(cfg.ServerWriteTimeout) * time.Second,
+		IdleTimeout:       time.Duration(cfg.ServerIdleTimeout) * time.Second,
+	}
+
+	if cfg.TLSCertFile != "" {
+		// Client certificates are requested but not verified by the handshake:
+		// self-signed certificates are valid for self_signed_tls_client_auth,
+		// and each client's certificate is checked at the token endpoint
+		server.TLSConfig = &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			ClientAuth: tls.RequestClientCert,
+		}
+	}
+
+	return server
+}
+
+// serve accepts connections on ln until ctx is cancelled, then stops
+// accepting new ones and waits up to shutdownTimeout for in-flight requests
+// to finish. Connections still open after that are closed.
+func serve(ctx context.Context, server *http.Server, ln net.Listener, certFile, keyFile string, shutdownTimeout time.Duration) error {
+	errCh := make(chan error, 1)
+	go func() {
+		if certFile != "" {
+			errCh <- server.ServeTLS(ln, certFile, keyFile)
+		} else {
+			errCh <- server.Serve(ln)
+		}
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		server.Close()
+		return err
+	}
+
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// startGRPC serves token validation over gRPC when GRPC_PORT is set. The
+// returned channel receives the result once ctx is cancelled and the
+// server has stopped, or at once when gRPC is disabled.
+func startGRPC(ctx context.Context, cfg *config.Config, endpoints *router.Handlers) (<-chan error, error) {
+	done := make(chan error, 1)
+	if cfg.GRPCPort == "" {
+		done <- nil
+		return done, nil
+	}
+
+	var creds credentials.TransportCredentials
+	if cfg.GRPCTLSCertFile != "" {
+		tlsConfig, err := grpcserver.TLSConfig(cfg.GRPCTLSCertFile, cfg.GRPCTLSKeyFile, cfg.GRPCClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	} else {
+		log.Printf("gRPC TLS is off; only expose port %s to trusted networks", cfg.GRPCPort)
+	}
+
+	ln, err := net.Listen("tcp", ":"+cfg.GRPCPort)
+	if err != nil {
+		return nil, err
+	}
+	server := grpcserver.New(endpoints.TokenValidation, endpoints.JWKS.KeySet())
+	log.Printf("gRPC token validation listening on port %s", cfg.GRPCPort)
+	go func() {
+		done <- server.Serve(ctx, ln, creds, time.Duration(cfg.ShutdownTimeout)*time.Second)
+	}()
+	return done, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"oauth2-server/config"
+	"testing"
+	"time"
+)
+
+func TestNewServer_Timeouts(t *testing.T) {
+	cfg := &config.Config{ServerPort: "9000", ServerReadTimeout: 5, ServerWriteTimeout: 10, ServerIdleTimeout: 60}
+	server := newServer(cfg, http.NotFoundHandler())
+
+	if server.Addr != ":9000" {
+		t.Errorf("Expected address :9000, got %s", server.Addr)
+	}
+	if server.ReadTimeout != 5*time.Second || server.WriteTimeout != 10*time.Second || server.IdleTimeout != time.Minute {
+		t.Errorf("Unexpected timeouts: read %v, write %v, idle %v", server.ReadTimeout, server.WriteTimeout, server.IdleTimeout)
+	}
+	if server.TLSConfig != nil {
+		t.Error("Expected no TLS config without a certificate")
+	}
+
+	cfg.TLSCertFile = "server.crt"
+	if newServer(cfg, http.NotFoundHandler()).TLSConfig == nil {
+		t.Error("Expected a TLS config with a certificate")
+	}
+}
+
+func TestServe_DrainsInFlightRequests(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("done"))
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	server := &http.Server{Handler: handler}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- serve(ctx, server, ln, "", "", 5*time.Second) }()
+
+	type result struct {
+		body string
+		err  error
+	}
+	responses := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/")
+		if err != nil {
+			responses <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		responses <- result{string(body), err}
+	}()
+
+	<-started
+	cancel()
+
+	// The server stops accepting connections but waits for the request
+	select {
+	case err := <-served:
+		t.Fatalf("serve returned before the request finished: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	if res := <-responses; res.err != nil || res.body != "done" {
+		t.Errorf("Expected in-flight request to complete, got %q, %v", res.body, res.err)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("Expected clean shutdown, got %v", err)
+	}
+}
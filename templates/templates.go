@@ -0,0 +1,10 @@
+// Package templates embeds the server-rendered HTML pages so production
+// binaries don't depend on the working directory.
+package templates
+
+import "embed"
+
+// FS holds the embedded *.html templates
+//
+//go:embed *.html
+var FS embed.FS
@@ -17,7 +17,7 @@ if curl -s "$OAUTH_SERVER/.well-known/openid-configuration" > /dev/null; then
     echo "${GREEN}✓ OAuth server is running${NC}"
 else
     echo "${RED}✗ OAuth server is not running at $OAUTH_SERVER${NC}"
-    echo "Please start the OAuth server first: go run main.go"
+    echo "Please start the OAuth server first: go run ."
     exit 1
 fi
 
@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"oauth2-server/models"
+	"testing"
+	"time"
+)
+
+func buildAuditChain(events ...string) []*models.AuditEntry {
+	entries := make([]*models.AuditEntry, 0, len(events))
+	prevHash := ""
+	for i, event := range events {
+		entry := &models.AuditEntry{
+			Seq:       int64(i + 1),
+			Event:     event,
+			UserID:    "user-1",
+			CreatedAt: AuditTime(time.Now()),
+			PrevHash:  prevHash,
+		}
+		entry.Hash = HashAuditEntry(entry)
+		prevHash = entry.Hash
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestVerifyAuditChain(t *testing.T) {
+	entries := buildAuditChain(models.AuditLoginSuccess, models.AuditTokenIssued, models.AuditLogout)
+	if seq, err := VerifyAuditChain(entries); err != nil {
+		t.Fatalf("Expected intact chain, broken at %d: %v", seq, err)
+	}
+
+	tests := []struct {
+		name    string
+		tamper  func([]*models.AuditEntry) []*models.AuditEntry
+		brokeAt int64
+	}{
+		{"Modified entry", func(e []*models.AuditEntry) []*models.AuditEntry {
+			e[1].UserID = "someone-else"
+			return e
+		}, 2},
+		{"Removed entry", func(e []*models.AuditEntry) []*models.AuditEntry {
+			return append(e[:1], e[2:]...)
+		}, 3},
+		{"Rehashed entry", func(e []*models.AuditEntry) []*models.AuditEntry {
+			e[0].Event = models.AuditLoginFailure
+			e[0].Hash = HashAuditEntry(e[0])
+			return e
+		}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain := tt.tamper(buildAuditChain(models.AuditLoginSuccess, models.AuditTokenIssued, models.AuditLogout))
+			seq, err := VerifyAuditChain(chain)
+			if err == nil {
+				t.Fatal("Expected tampering to be detected")
+			}
+			if seq != tt.brokeAt {
+				t.Errorf("Expected chain to break at %d, got %d", tt.brokeAt, seq)
+			}
+		})
+	}
+}
+
+func TestAuditAnchorSignature(t *testing.T) {
+	privateKey, publicKey, err := generateTestKeys()
+	if err != nil {
+		t.Fatalf("Failed to generate keys: %v", err)
+	}
+
+	anchor := &models.AuditAnchor{Seq: 3, HeadHash: "abc123", CreatedAt: AuditTime(time.Now())}
+	anchor.Signature, err = SignAuditAnchor(anchor, privateKey)
+	if err != nil {
+		t.Fatalf("Failed to sign anchor: %v", err)
+	}
+
+	if err := VerifyAuditAnchor(anchor, publicKey); err != nil {
+		t.Errorf("Expected anchor to verify, got %v", err)
+	}
+
+	anchor.HeadHash = "rewritten"
+	if err := VerifyAuditAnchor(anchor, publicKey); err == nil {
+		t.Error("Expected a rewritten head hash to fail verification")
+	}
+}
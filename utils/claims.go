@@ -1,7 +1,10 @@
 package utils
 
 import (
+	"encoding/json"
+	"errors"
 	"oauth2-server/models"
+	"sort"
 	"strings"
 )
 
@@ -138,3 +141,44 @@ func FilterClaimsForUser(user *models.User, scopes string) map[string]interface{
 func GetIDTokenClaimsForUser(user *models.User, scopes string, nonce string) map[string]interface{} {
 	return GlobalClaimFilter.GetIDTokenClaims(user, scopes, nonce)
 }
+
+// ParseIDTokenClaimsRequest returns the claim names requested for the ID token
+// by an OpenID Connect claims request parameter, e.g.
+// {"id_token":{"email":null,"name":{"essential":true}}}. An empty parameter
+// requests nothing specific and returns nil.
+func ParseIDTokenClaimsRequest(raw string) ([]string, error) {
+	if raw == "" {
+		return nil, nil
+	}
+
+	var request struct {
+		IDToken map[string]json.RawMessage `json:"id_token"`
+	}
+	if err := json.Unmarshal([]byte(raw), &request); err != nil {
+		return nil, errors.New("claims parameter is not a valid claims request")
+	}
+
+	names := make([]string, 0, len(request.IDToken))
+	for name := range request.IDToken {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// NarrowClaims keeps only the requested claims out of claims that were already
+// filtered by scope, so a claims request can never add a claim the scopes do
+// not allow. sub and nonce are always kept. A nil request keeps everything.
+func NarrowClaims(claims map[string]interface{}, requested []string) map[string]interface{} {
+	if requested == nil {
+		return claims
+	}
+
+	narrowed := make(map[string]interface{}, len(requested)+2)
+	for _, name := range append([]string{"sub", "nonce"}, requested...) {
+		if value, ok := claims[name]; ok {
+			narrowed[name] = value
+		}
+	}
+	return narrowed
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"oauth2-server/models"
+	"reflect"
 	"testing"
 )
 
@@ -161,3 +162,52 @@ func TestGlobalClaimFilter(t *testing.T) {
 		}
 	})
 }
+
+func TestParseIDTokenClaimsRequest(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		expected []string
+		wantErr  bool
+	}{
+		{"empty", "", nil, false},
+		{"id token claims", `{"id_token":{"name":{"essential":true},"email":null}}`, []string{"email", "name"}, false},
+		{"userinfo only", `{"userinfo":{"email":null}}`, []string{}, false},
+		{"invalid json", `{"id_token":`, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseIDTokenClaimsRequest(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseIDTokenClaimsRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("ParseIDTokenClaimsRequest() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNarrowClaims(t *testing.T) {
+	claims := map[string]interface{}{
+		"sub":   "user123",
+		"nonce": "n-0S6",
+		"email": "test@example.com",
+		"name":  "Test User",
+	}
+
+	if got := NarrowClaims(claims, nil); len(got) != len(claims) {
+		t.Errorf("Expected nil request to keep all claims, got %v", got)
+	}
+
+	got := NarrowClaims(claims, []string{"email", "phone_number"})
+	expected := map[string]interface{}{
+		"sub":   "user123",
+		"nonce": "n-0S6",
+		"email": "test@example.com",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("NarrowClaims() = %v, want %v", got, expected)
+	}
+}
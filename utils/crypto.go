@@ -3,20 +3,10 @@ package utils
 import (
 	"crypto/rand"
 	"encoding/base64"
-
-	"golang.org/x/crypto/bcrypt"
+	"math/big"
+	"strings"
 )
 
-func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	return string(bytes), err
-}
-
-func CheckPasswordHash(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
-}
-
 func GenerateRandomString(length int) (string, error) {
 	bytes := make([]byte, length)
 	if _, err := rand.Read(bytes); err != nil {
@@ -24,3 +14,37 @@ func GenerateRandomString(length int) (string, error) {
 	}
 	return base64.URLEncoding.EncodeToString(bytes)[:length], nil
 }
+
+// userCodeAlphabet leaves out vowels, to avoid spelling words, and letters
+// easily confused with digits
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+// GenerateUserCode returns a short code for a user to type, such as
+// "WDJB-MJHT"
+func GenerateUserCode() (string, error) {
+	code := make([]byte, 8)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(userCodeAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		code[i] = userCodeAlphabet[n.Int64()]
+	}
+	return string(code[:4]) + "-" + string(code[4:]), nil
+}
+
+// NormalizeUserCode formats a typed user code the way GenerateUserCode does,
+// ignoring case, spaces and dashes. Codes of the wrong length are returned
+// uppercased without a dash so they simply fail to match.
+func NormalizeUserCode(code string) string {
+	code = strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(code))
+	if len(code) != 8 {
+		return code
+	}
+	return code[:4] + "-" + code[4:]
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -184,3 +185,33 @@ func TestGenerateRandomStringUniqueness(t *testing.T) {
 		t.Errorf("Expected %d unique strings, got %d", count, len(generated))
 	}
 }
+
+func TestGenerateUserCode(t *testing.T) {
+	code, err := GenerateUserCode()
+	if err != nil {
+		t.Fatalf("GenerateUserCode() error = %v", err)
+	}
+	if len(code) != 9 || code[4] != '-' {
+		t.Fatalf("Expected a code like XXXX-XXXX, got %q", code)
+	}
+	for i, c := range code {
+		if i != 4 && !strings.ContainsRune(userCodeAlphabet, c) {
+			t.Errorf("Unexpected character %q in %q", c, code)
+		}
+	}
+}
+
+func TestNormalizeUserCode(t *testing.T) {
+	tests := map[string]string{
+		"WDJB-MJHT":   "WDJB-MJHT",
+		"wdjbmjht":    "WDJB-MJHT",
+		"wdjb mjht":   "WDJB-MJHT",
+		" WD-JB-MJHT": "WDJB-MJHT",
+		"wdj":         "WDJ",
+	}
+	for input, want := range tests {
+		if got := NormalizeUserCode(input); got != want {
+			t.Errorf("NormalizeUserCode(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
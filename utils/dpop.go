@@ -0,0 +1,214 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// DPoPProofType is the typ header of a DPoP proof (RFC 9449)
+const DPoPProofType = "dpop+jwt"
+
+// DPoPSigningAlgs are the algorithms accepted for DPoP proofs
+var DPoPSigningAlgs = []string{"RS256", "PS256", "ES256"}
+
+// DPoPProof is a DPoP proof whose signature and request binding have been
+// verified
+type DPoPProof struct {
+	ID         string // jti
+	Nonce      string
+	TokenHash  string // ath, the hash of the access token presented with it
+	IssuedAt   time.Time
+	Thumbprint string // JWK thumbprint of the proof key, the cnf jkt of bound tokens
+}
+
+type dpopClaims struct {
+	Method string `json:"htm"`
+	URL    string `json:"htu"`
+	Nonce  string `json:"nonce,omitempty"`
+	ATH    string `json:"ath,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// dpopJWK is the public key carried in the jwk header of a proof
+type dpopJWK struct {
+	Kty string `json:"kty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	D   string `json:"d,omitempty"`
+}
+
+// ParseDPoPProof verifies a DPoP proof with the public key in its header and
+// checks that it was made for a request with this method and URL no more than
+// maxAge from now. The nonce and ath are left for the caller to check.
+func ParseDPoPProof(proof, method, requestURL string, maxAge time.Duration) (*DPoPProof, error) {
+	claims := &dpopClaims{}
+	var thumbprint string
+	_, err := jwt.ParseWithClaims(proof, claims, func(token *jwt.Token) (interface{}, error) {
+		if typ, _ := token.Header["typ"].(string); typ != DPoPProofType {
+			return nil, errors.New("typ must be " + DPoPProofType)
+		}
+		key, keyThumbprint, err := parseDPoPKey(token.Header["jwk"])
+		if err != nil {
+			return nil, err
+		}
+		thumbprint = keyThumbprint
+		return key, nil
+	}, jwt.WithValidMethods(DPoPSigningAlgs))
+	if err != nil {
+		return nil, errors.New("invalid DPoP proof: " + err.Error())
+	}
+
+	if claims.ID == "" || claims.IssuedAt == nil {
+		return nil, errors.New("DPoP proof must carry jti and iat")
+	}
+	if !strings.EqualFold(claims.Method, method) {
+		return nil, errors.New("DPoP proof was made for another method")
+	}
+	if normalizeDPoPURL(claims.URL) != normalizeDPoPURL(requestURL) {
+		return nil, errors.New("DPoP proof was made for another URL")
+	}
+	age := time.Since(claims.IssuedAt.Time)
+	if age > maxAge || age < -maxAge {
+		return nil, errors.New("DPoP proof is too old or from the future")
+	}
+
+	return &DPoPProof{
+		ID:         claims.ID,
+		Nonce:      claims.Nonce,
+		TokenHash:  claims.ATH,
+		IssuedAt:   claims.IssuedAt.Time,
+		Thumbprint: thumbprint,
+	}, nil
+}
+
+// AccessTokenHash returns the ath value of a DPoP proof presented with the
+// access token
+func AccessTokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// parseDPoPKey decodes the public JWK of a proof and returns it with its
+// RFC 7638 thumbprint
+func parseDPoPKey(header interface{}) (interface{}, string, error) {
+	raw, err := json.Marshal(header)
+	if err != nil || header == nil {
+		return nil, "", errors.New("missing jwk header")
+	}
+	var jwk dpopJWK
+	if err := json.Unmarshal(raw, &jwk); err != nil {
+		return nil, "", errors.New("malformed jwk header")
+	}
+	if jwk.D != "" {
+		return nil, "", errors.New("jwk header must not contain a private key")
+	}
+
+	switch jwk.Kty {
+	case "RSA":
+		n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+		e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+		if errN != nil || errE != nil || len(n) == 0 || len(e) == 0 {
+			return nil, "", errors.New("malformed RSA jwk")
+		}
+		key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		return key, KeyThumbprint(key), nil
+
+	case "EC":
+		if jwk.Crv != "P-256" {
+			return nil, "", errors.New("unsupported curve " + jwk.Crv)
+		}
+		x, errX := base64.RawURLEncoding.DecodeString(jwk.X)
+		y, errY := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if errX != nil || errY != nil {
+			return nil, "", errors.New("malformed EC jwk")
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, "", errors.New("EC jwk is not on the curve")
+		}
+		// Required members in lexicographic order, without whitespace
+		sum := sha256.Sum256([]byte(`{"crv":"P-256","kty":"EC","x":"` + jwk.X + `","y":"` + jwk.Y + `"}`))
+		return key, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+	}
+
+	return nil, "", errors.New("unsupported jwk key type " + jwk.Kty)
+}
+
+// normalizeDPoPURL drops the query and fragment, which htu does not cover,
+// and lowercases the scheme and host
+func normalizeDPoPURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String()
+}
+
+// NonceSource issues the server nonces clients put in DPoP proofs. Nonces are
+// derived from the secret and the current period rather than stored, so
+// every instance sharing the secret accepts them and they rotate on their
+// own. A nonce is accepted in the period it was issued in and the next one.
+type NonceSource struct {
+	secret []byte
+	period time.Duration
+}
+
+func NewNonceSource(secret []byte, period time.Duration) *NonceSource {
+	return &NonceSource{secret: secret, period: period}
+}
+
+// Current returns the nonce of the current period
+func (s *NonceSource) Current() string {
+	return s.nonce(s.window(time.Now()))
+}
+
+// Valid reports whether nonce is the current or the previous period's nonce
+func (s *NonceSource) Valid(nonce string) bool {
+	window := s.window(time.Now())
+	for _, w := range []int64{window, window - 1} {
+		if hmac.Equal([]byte(nonce), []byte(s.nonce(w))) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *NonceSource) window(t time.Time) int64 {
+	return t.UnixNano() / int64(s.period)
+}
+
+func (s *NonceSource) nonce(window int64) string {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(window))
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write(buf[:])
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// DeriveNonceKey derives the DPoP nonce secret from the server's RSA private
+// key, so every instance using the key issues the same nonces
+func DeriveNonceKey(privateKey *rsa.PrivateKey) []byte {
+	mac := hmac.New(sha256.New, privateKey.D.Bytes())
+	mac.Write([]byte("oauth2-server dpop nonce"))
+	return mac.Sum(nil)
+}
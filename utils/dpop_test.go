@@ -0,0 +1,137 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testTokenURL = "https://auth.example.com/oauth/token"
+
+// signTestProof signs a DPoP proof with key, publishing jwk in its header
+func signTestProof(t *testing.T, method jwt.SigningMethod, key interface{}, jwk map[string]string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["typ"] = DPoPProofType
+	token.Header["jwk"] = jwk
+	proof, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("Failed to sign proof: %v", err)
+	}
+	return proof
+}
+
+func proofClaims(method, url string) jwt.MapClaims {
+	return jwt.MapClaims{"jti": "proof-1", "htm": method, "htu": url, "iat": time.Now().Unix()}
+}
+
+func ecJWK(key *ecdsa.PrivateKey) map[string]string {
+	return map[string]string{
+		"kty": "EC",
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+func TestParseDPoPProof_EC(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	claims := proofClaims("POST", testTokenURL)
+	claims["nonce"] = "server-nonce"
+	proof := signTestProof(t, jwt.SigningMethodES256, key, ecJWK(key), claims)
+
+	// htu does not cover the query
+	parsed, err := ParseDPoPProof(proof, "POST", testTokenURL+"?x=1", time.Minute)
+	if err != nil {
+		t.Fatalf("Expected a valid proof, got %v", err)
+	}
+	if parsed.Nonce != "server-nonce" || parsed.ID != "proof-1" || parsed.Thumbprint == "" {
+		t.Errorf("Unexpected proof %+v", parsed)
+	}
+
+	if _, err := ParseDPoPProof(proof, "GET", testTokenURL, time.Minute); err == nil {
+		t.Error("Expected a proof for another method to be rejected")
+	}
+	if _, err := ParseDPoPProof(proof, "POST", "https://auth.example.com/oauth/userinfo", time.Minute); err == nil {
+		t.Error("Expected a proof for another URL to be rejected")
+	}
+
+	old := proofClaims("POST", testTokenURL)
+	old["iat"] = time.Now().Add(-time.Hour).Unix()
+	if _, err := ParseDPoPProof(signTestProof(t, jwt.SigningMethodES256, key, ecJWK(key), old), "POST", testTokenURL, time.Minute); err == nil {
+		t.Error("Expected a stale proof to be rejected")
+	}
+
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	forged := signTestProof(t, jwt.SigningMethodES256, other, ecJWK(key), proofClaims("POST", testTokenURL))
+	if _, err := ParseDPoPProof(forged, "POST", testTokenURL, time.Minute); err == nil {
+		t.Error("Expected a proof not signed by its jwk to be rejected")
+	}
+}
+
+func TestParseDPoPProof_RSA(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	jwk := map[string]string{
+		"kty": "RSA",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+
+	parsed, err := ParseDPoPProof(signTestProof(t, jwt.SigningMethodRS256, key, jwk, proofClaims("POST", testTokenURL)), "POST", testTokenURL, time.Minute)
+	if err != nil {
+		t.Fatalf("Expected a valid proof, got %v", err)
+	}
+	if parsed.Thumbprint != KeyThumbprint(&key.PublicKey) {
+		t.Errorf("Expected the RFC 7638 thumbprint, got %s", parsed.Thumbprint)
+	}
+
+	jwk["d"] = base64.RawURLEncoding.EncodeToString(key.D.Bytes())
+	_, err = ParseDPoPProof(signTestProof(t, jwt.SigningMethodRS256, key, jwk, proofClaims("POST", testTokenURL)), "POST", testTokenURL, time.Minute)
+	if err == nil || !strings.Contains(err.Error(), "private") {
+		t.Errorf("Expected a private jwk to be rejected, got %v", err)
+	}
+}
+
+func TestParseDPoPProof_RequiresType(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, proofClaims("POST", testTokenURL))
+	token.Header["jwk"] = ecJWK(key)
+	proof, _ := token.SignedString(key)
+
+	if _, err := ParseDPoPProof(proof, "POST", testTokenURL, time.Minute); err == nil {
+		t.Error("Expected a proof without typ dpop+jwt to be rejected")
+	}
+}
+
+func TestNonceSource(t *testing.T) {
+	source := NewNonceSource([]byte("secret"), time.Hour)
+	nonce := source.Current()
+
+	if !source.Valid(nonce) {
+		t.Error("Expected the current nonce to be valid")
+	}
+	if source.Valid("") || source.Valid("made-up") {
+		t.Error("Expected unknown nonces to be rejected")
+	}
+
+	previous := source.nonce(source.window(time.Now()) - 1)
+	if !source.Valid(previous) {
+		t.Error("Expected the previous period's nonce to stay valid")
+	}
+	if source.Valid(source.nonce(source.window(time.Now()) - 2)) {
+		t.Error("Expected older nonces to be rejected")
+	}
+
+	if NewNonceSource([]byte("other"), time.Hour).Valid(nonce) {
+		t.Error("Expected a nonce from another secret to be rejected")
+	}
+}
@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net"
+)
+
+// SoftFingerprint summarises where a request came from: the user agent and
+// the network of the IP address, a /24 for IPv4 and a /48 for IPv6, so a
+// user moving between addresses of the same network keeps the fingerprint.
+// It is a signal for audits, not an identifier.
+func SoftFingerprint(ip, userAgent string) string {
+	sum := sha256.Sum256([]byte(IPNetwork(ip) + "\n" + userAgent))
+	return base64.RawURLEncoding.EncodeToString(sum[:16])
+}
+
+// IPNetwork returns the /24 of an IPv4 or the /48 of an IPv6 address, or ip
+// itself when it does not parse
+func IPNetwork(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return parsed.Mask(net.CIDRMask(48, 128)).String()
+}
@@ -0,0 +1,21 @@
+package utils
+
+import "testing"
+
+func TestSoftFingerprint(t *testing.T) {
+	const ua = "Mozilla/5.0 (iPhone)"
+	base := SoftFingerprint("203.0.113.10", ua)
+
+	if SoftFingerprint("203.0.113.200", ua) != base {
+		t.Error("Expected addresses in the same /24 to share a fingerprint")
+	}
+	if SoftFingerprint("203.0.114.10", ua) == base {
+		t.Error("Expected another network to change the fingerprint")
+	}
+	if SoftFingerprint("203.0.113.10", "curl/8.0") == base {
+		t.Error("Expected another user agent to change the fingerprint")
+	}
+	if SoftFingerprint("2001:db8:1:2::1", ua) != SoftFingerprint("2001:db8:1:ffff::9", ua) {
+		t.Error("Expected addresses in the same IPv6 /48 to share a fingerprint")
+	}
+}
@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"slices"
+	"strings"
+)
+
+// DefaultGrantTypes is used for clients registered without grant_types
+var DefaultGrantTypes = []string{"authorization_code", "refresh_token"}
+
+// GrantTypeAllowed checks a grant type against a client's registered grant
+// types. An empty list falls back to DefaultGrantTypes.
+func GrantTypeAllowed(grantType string, allowed []string) bool {
+	if len(allowed) == 0 {
+		allowed = DefaultGrantTypes
+	}
+	return slices.Contains(allowed, grantType)
+}
+
+// ResponseTypeGrantTypes returns the grant types a response type relies on:
+// authorization_code when it returns a code, implicit when it returns tokens
+// from the authorization endpoint. Hybrid response types need both.
+func ResponseTypeGrantTypes(responseType string) []string {
+	var grantTypes []string
+	values := strings.Fields(responseType)
+	if slices.Contains(values, "code") {
+		grantTypes = append(grantTypes, "authorization_code")
+	}
+	if slices.Contains(values, "token") || slices.Contains(values, "id_token") {
+		grantTypes = append(grantTypes, "implicit")
+	}
+	return grantTypes
+}
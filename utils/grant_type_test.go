@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestGrantTypeAllowed(t *testing.T) {
+	tests := []struct {
+		name      string
+		grantType string
+		allowed   []string
+		want      bool
+	}{
+		{"default allows authorization_code", "authorization_code", nil, true},
+		{"default rejects client_credentials", "client_credentials", nil, false},
+		{"registered", "client_credentials", []string{"client_credentials"}, true},
+		{"not registered", "authorization_code", []string{"client_credentials"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := GrantTypeAllowed(tt.grantType, tt.allowed); got != tt.want {
+				t.Errorf("GrantTypeAllowed(%q, %v) = %v, want %v", tt.grantType, tt.allowed, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResponseTypeGrantTypes(t *testing.T) {
+	tests := []struct {
+		responseType string
+		want         []string
+	}{
+		{"code", []string{"authorization_code"}},
+		{"id_token token", []string{"implicit"}},
+		{"code id_token", []string{"authorization_code", "implicit"}},
+	}
+
+	for _, tt := range tests {
+		if got := ResponseTypeGrantTypes(tt.responseType); !slices.Equal(got, tt.want) {
+			t.Errorf("ResponseTypeGrantTypes(%q) = %v, want %v", tt.responseType, got, tt.want)
+		}
+	}
+}
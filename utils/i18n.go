@@ -0,0 +1,289 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultLocale is the language messages are written in
+const DefaultLocale = "en"
+
+// GlobalMessageCatalog holds the translations used for error descriptions and
+// page text
+var GlobalMessageCatalog = NewMessageCatalog()
+
+func init() {
+	GlobalMessageCatalog.Register("th", thaiMessages)
+}
+
+// MessageCatalog maps locales to translations of English messages. The English
+// text is the key, so a message without a translation is shown as written.
+type MessageCatalog struct {
+	mu       sync.RWMutex
+	messages map[string]map[string]string
+}
+
+// NewMessageCatalog creates a catalog that only knows the default locale
+func NewMessageCatalog() *MessageCatalog {
+	return &MessageCatalog{messages: make(map[string]map[string]string)}
+}
+
+// Register adds translations for a locale, merging with any already registered
+func (c *MessageCatalog) Register(locale string, messages map[string]string) {
+	locale = strings.ToLower(locale)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.messages[locale] == nil {
+		c.messages[locale] = make(map[string]string, len(messages))
+	}
+	for key, value := range messages {
+		c.messages[locale][key] = value
+	}
+}
+
+// LoadDir registers the translation files in dir. Each file is a JSON object
+// mapping English messages to their translation and is named after its
+// locale, e.g. "de.json" or "pt-br.json". Entries for a locale that is
+// already registered replace the built-in translations of those messages.
+func (c *MessageCatalog) LoadDir(dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var messages map[string]string
+		if err := json.Unmarshal(data, &messages); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		c.Register(strings.TrimSuffix(filepath.Base(path), ".json"), messages)
+	}
+	return nil
+}
+
+// Supports reports whether the catalog has translations for the locale
+func (c *MessageCatalog) Supports(locale string) bool {
+	locale = strings.ToLower(locale)
+	if locale == DefaultLocale {
+		return true
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, ok := c.messages[locale]
+	return ok
+}
+
+// Translate returns the message in the given locale, or the message itself
+// when there is no translation
+func (c *MessageCatalog) Translate(locale, message string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if translated, ok := c.messages[strings.ToLower(locale)][message]; ok {
+		return translated
+	}
+	return message
+}
+
+// Negotiate picks the first preferred locale the catalog supports. Region
+// subtags fall back to their language ("th-TH" matches "th").
+func (c *MessageCatalog) Negotiate(preferred []string) string {
+	for _, tag := range preferred {
+		tag = strings.ToLower(tag)
+		if c.Supports(tag) {
+			return tag
+		}
+		if base, _, found := strings.Cut(tag, "-"); found && c.Supports(base) {
+			return base
+		}
+	}
+	return DefaultLocale
+}
+
+// ParseLocales returns the caller's preferred locales: the space separated
+// OIDC ui_locales first, then Accept-Language ordered by quality
+func ParseLocales(uiLocales, acceptLanguage string) []string {
+	var locales []string
+	locales = append(locales, strings.Fields(uiLocales)...)
+
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var accepted []weighted
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.TrimSpace(tag)
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		if value, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q <= 0 {
+			continue
+		}
+		accepted = append(accepted, weighted{tag, q})
+	}
+	sort.SliceStable(accepted, func(i, j int) bool { return accepted[i].q > accepted[j].q })
+
+	for _, a := range accepted {
+		locales = append(locales, a.tag)
+	}
+	return locales
+}
+
+// thaiMessages translates the most common error descriptions, the consent
+// page and the CLI activation page
+var thaiMessages = map[string]string{
+	// Error descriptions
+	"Invalid request body":                    "รูปแบบคำขอไม่ถูกต้อง",
+	"Missing required parameters":             "ขาดพารามิเตอร์ที่จำเป็น",
+	"Failed to parse form":                    "ไม่สามารถอ่านข้อมูลฟอร์มได้",
+	"Authentication failed":                   "การยืนยันตัวตนล้มเหลว",
+	"Invalid client credentials":              "ข้อมูลรับรองของไคลเอนต์ไม่ถูกต้อง",
+	"Client not found":                        "ไม่พบไคลเอนต์",
+	"Invalid redirect URI":                    "Redirect URI ไม่ถูกต้อง",
+	"Invalid email or password":               "อีเมลหรือรหัสผ่านไม่ถูกต้อง",
+	"Invalid or expired token":                "โทเค็นไม่ถูกต้องหรือหมดอายุ",
+	"Invalid refresh token":                   "Refresh token ไม่ถูกต้อง",
+	"Invalid or expired verification link":    "ลิงก์ยืนยันไม่ถูกต้องหรือหมดอายุ",
+	"Invalid or expired confirmation link":    "ลิงก์ยืนยันการเข้าสู่ระบบไม่ถูกต้องหรือหมดอายุ",
+	"Grant type not supported":                "ไม่รองรับ grant type นี้",
+	"User not found":                          "ไม่พบผู้ใช้",
+	"User already exists":                     "มีผู้ใช้นี้อยู่แล้ว",
+	"This account has been disabled":          "บัญชีนี้ถูกระงับการใช้งาน",
+	"Session not found":                       "ไม่พบเซสชัน",
+	"Token does not belong to an active user": "โทเค็นไม่ได้เป็นของผู้ใช้ที่ใช้งานอยู่",
+	"Client already exists":                   "มีไคลเอนต์นี้อยู่แล้ว",
+	"Invalid verification code":               "รหัสยืนยันไม่ถูกต้อง",
+
+	// Consent page
+	"Authorization Request":                 "คำขอสิทธิ์การเข้าถึง",
+	"Application Access Request":            "แอปพลิเคชันขอเข้าถึงบัญชี",
+	"is requesting access to your account.": "ต้องการเข้าถึงบัญชีของคุณ",
+	"Renew access:":                         "ต่ออายุสิทธิ์:",
+	"you previously allowed":                "คุณเคยอนุญาตให้",
+	"to access your account":                "เข้าถึงบัญชีของคุณ",
+	"on":                                    "เมื่อ",
+	"but that permission has expired. Confirm to keep using the application.": "แต่สิทธิ์นั้นหมดอายุแล้ว กรุณายืนยันเพื่อใช้งานแอปพลิเคชันต่อ",
+	"You already allowed:":                                     "คุณอนุญาตไปแล้ว:",
+	"Previously granted permissions:":                          "สิทธิ์ที่เคยอนุญาต:",
+	"This application is requesting additional access:":        "แอปพลิเคชันนี้ขอสิทธิ์เพิ่มเติม:",
+	"This application will be able to:":                        "แอปพลิเคชันนี้จะสามารถ:",
+	"Uncheck any optional permission you don't want to share.": "ยกเลิกการเลือกสิทธิ์ที่ไม่บังคับซึ่งคุณไม่ต้องการแบ่งปัน",
+	"(required)":       "(จำเป็น)",
+	"sensitive":        "ข้อมูลอ่อนไหว",
+	"Deny":             "ปฏิเสธ",
+	"Allow":            "อนุญาต",
+	"Allow once":       "อนุญาตครั้งนี้เท่านั้น",
+	"Renew access":     "ต่ออายุสิทธิ์",
+	"Security Notice:": "ข้อควรระวัง:",
+	"Why":              "เหตุผลที่",
+	"is asking:":       "ขอสิทธิ์:",
+	"Allow once grants access for this sign-in only. Choose it on a shared computer.":                      "อนุญาตครั้งนี้เท่านั้นจะให้สิทธิ์เฉพาะการเข้าสู่ระบบครั้งนี้ เหมาะสำหรับคอมพิวเตอร์ที่ใช้ร่วมกัน",
+	"Only authorize applications you trust. You can revoke access at any time from your account settings.": "อนุญาตเฉพาะแอปพลิเคชันที่คุณไว้วางใจ คุณสามารถยกเลิกสิทธิ์ได้ทุกเมื่อจากการตั้งค่าบัญชี",
+
+	// Login and registration pages
+	"Sign in to continue":              "เข้าสู่ระบบเพื่อดำเนินการต่อ",
+	"Application:":                     "แอปพลิเคชัน:",
+	"wants to access your information": "ต้องการเข้าถึงข้อมูลของคุณ",
+	"New password":                     "รหัสผ่านใหม่",
+	"or":                               "หรือ",
+	"Sign in with":                     "เข้าสู่ระบบด้วย",
+	"Requested permissions:":           "สิทธิ์ที่ขอเข้าถึง:",
+	"Don't have an account?":           "ยังไม่มีบัญชี?",
+	"Register":                         "ลงทะเบียน",
+	"Something went wrong: no redirect URI was returned":    "เกิดข้อผิดพลาด: ไม่พบ redirect URI",
+	"Please set a new password to continue":                 "กรุณาตั้งรหัสผ่านใหม่เพื่อดำเนินการต่อ",
+	"Sign-in failed":                                        "เข้าสู่ระบบไม่สำเร็จ",
+	"Connection error":                                      "เกิดข้อผิดพลาดในการเชื่อมต่อ",
+	"Create a new account":                                  "สร้างบัญชีใหม่",
+	"You will return to this application after registering": "ลงทะเบียนแล้วจะกลับไปยังแอปพลิเคชันนี้",
+	"Full name":                "ชื่อ-นามสกุล",
+	"Already have an account?": "มีบัญชีอยู่แล้ว?",
+	"Registration successful! Check your email to verify your account before signing in.": "ลงทะเบียนสำเร็จ! กรุณาตรวจสอบอีเมลเพื่อยืนยันบัญชีก่อนเข้าสู่ระบบ",
+	"We sent you an email to confirm this sign-in. Open the link in it to continue.":      "เราส่งอีเมลให้คุณยืนยันการเข้าสู่ระบบครั้งนี้ เปิดลิงก์ในอีเมลเพื่อดำเนินการต่อ",
+	"Registration successful! Signing you in...":                                          "ลงทะเบียนสำเร็จ! กำลังเข้าสู่ระบบ...",
+	"Registration failed": "ลงทะเบียนไม่สำเร็จ",
+
+	// CLI activation page
+	"Activate a device": "เปิดใช้งานอุปกรณ์",
+	"Sign in to approve the login from your command line tool.": "เข้าสู่ระบบเพื่ออนุมัติการล็อกอินจากเครื่องมือบรรทัดคำสั่งของคุณ",
+	"Email":    "อีเมล",
+	"Password": "รหัสผ่าน",
+	"Sign in":  "เข้าสู่ระบบ",
+	"Continue": "ดำเนินการต่อ",
+	"Enter the code shown by your command line tool":               "กรอกรหัสที่แสดงในเครื่องมือบรรทัดคำสั่งของคุณ",
+	"This code is invalid or has expired.":                         "รหัสนี้ไม่ถูกต้องหรือหมดอายุแล้ว",
+	"is asking to sign in as you from a command line tool.":        "ขอเข้าสู่ระบบในนามของคุณจากเครื่องมือบรรทัดคำสั่ง",
+	"Only approve if you started this login and the code matches:": "อนุมัติเฉพาะเมื่อคุณเป็นผู้เริ่มการล็อกอินนี้และรหัสตรงกัน:",
+	"Login approved. You can return to your command line tool.":    "อนุมัติการล็อกอินแล้ว คุณสามารถกลับไปที่เครื่องมือบรรทัดคำสั่งได้",
+	"Login denied. The command line tool will not be signed in.":   "ปฏิเสธการล็อกอินแล้ว เครื่องมือบรรทัดคำสั่งจะไม่ได้เข้าสู่ระบบ",
+
+	// Account chooser page
+	"Choose an account":   "เลือกบัญชี",
+	"to continue to":      "เพื่อไปยัง",
+	"Current account":     "บัญชีปัจจุบัน",
+	"Use another account": "ใช้บัญชีอื่น",
+
+	// Two-step verification page
+	"Two-step verification":           "การยืนยันตัวตนสองขั้นตอน",
+	"asks you to confirm it's you.":   "ขอให้คุณยืนยันว่าเป็นคุณ",
+	"We sent a verification code to":  "เราส่งรหัสยืนยันไปที่",
+	"Verification code":               "รหัสยืนยัน",
+	"Don't ask again on this browser": "ไม่ต้องถามอีกบนเบราว์เซอร์นี้",
+	"Verify":                          "ยืนยัน",
+	"Verification failed":             "ยืนยันไม่สำเร็จ",
+
+	// Account page
+	"Your account": "บัญชีของคุณ",
+	"Sign in to manage the applications and devices that can access your account.": "เข้าสู่ระบบเพื่อจัดการแอปพลิเคชันและอุปกรณ์ที่เข้าถึงบัญชีของคุณได้",
+	"Connected applications": "แอปพลิเคชันที่เชื่อมต่อ",
+	"Allowed on":             "อนุญาตเมื่อ",
+	"Remove access":          "ยกเลิกสิทธิ์",
+	"No applications have access to your account.": "ยังไม่มีแอปพลิเคชันที่เข้าถึงบัญชีของคุณ",
+	"Signed-in sessions":                           "เซสชันที่เข้าสู่ระบบอยู่",
+	"Unknown device":                               "อุปกรณ์ที่ไม่รู้จัก",
+	"This device":                                  "อุปกรณ์นี้",
+	"Last active":                                  "ใช้งานล่าสุด",
+	"Sign out":                                     "ออกจากระบบ",
+	"Recent activity":                              "กิจกรรมล่าสุด",
+	"No recent activity.":                          "ไม่มีกิจกรรมล่าสุด",
+	"Signed in":                                    "เข้าสู่ระบบ",
+	"Failed sign-in attempt":                       "เข้าสู่ระบบไม่สำเร็จ",
+	"Signed out":                                   "ออกจากระบบ",
+	"Allowed access to":                            "อนุญาตให้เข้าถึง",
+	"Denied access to":                             "ปฏิเสธการเข้าถึงของ",
+	"Removed access for":                           "ยกเลิกสิทธิ์ของ",
+	"Signed out a session":                         "ออกจากระบบเซสชันหนึ่ง",
+	"Linked a sign-in provider":                    "เชื่อมบัญชีผู้ให้บริการเข้าสู่ระบบ",
+	"Downloaded your data":                         "ดาวน์โหลดข้อมูลของคุณ",
+	"Completed two-step verification":              "ยืนยันตัวตนสองขั้นตอนสำเร็จ",
+	"Signed in from a new device or location":      "เข้าสู่ระบบจากอุปกรณ์หรือสถานที่ใหม่",
+	"Confirmed a sign-in":                          "ยืนยันการเข้าสู่ระบบ",
+
+	// Built-in scope descriptions
+	"OpenID Connect authentication":            "ยืนยันตัวตนด้วย OpenID Connect",
+	"Access to user profile information":       "เข้าถึงข้อมูลโปรไฟล์ของผู้ใช้",
+	"Access to user email address":             "เข้าถึงอีเมลของผู้ใช้",
+	"Access to user phone number":              "เข้าถึงหมายเลขโทรศัพท์ของผู้ใช้",
+	"Access to user postal address":            "เข้าถึงที่อยู่ของผู้ใช้",
+	"Request refresh token for offline access": "ขอ refresh token เพื่อเข้าถึงแบบออฟไลน์",
+	"Administrative access to user management": "สิทธิ์ผู้ดูแลในการจัดการผู้ใช้",
+}
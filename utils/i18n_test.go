@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseLocales(t *testing.T) {
+	tests := []struct {
+		name           string
+		uiLocales      string
+		acceptLanguage string
+		expected       []string
+	}{
+		{"empty", "", "", nil},
+		{"ui_locales first", "th en", "fr", []string{"th", "en", "fr"}},
+		{"ordered by quality", "", "en;q=0.5, th-TH, fr;q=0.8", []string{"th-TH", "fr", "en"}},
+		{"wildcard and zero quality skipped", "", "*, de;q=0, ja", []string{"ja"}},
+		{"malformed quality skipped", "", "de;q=abc, ja", []string{"ja"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ParseLocales(tt.uiLocales, tt.acceptLanguage)
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("ParseLocales() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestMessageCatalog(t *testing.T) {
+	catalog := NewMessageCatalog()
+	catalog.Register("TH", map[string]string{"Client not found": "ไม่พบไคลเอนต์"})
+
+	if got := catalog.Negotiate([]string{"fr", "th-TH"}); got != "th" {
+		t.Errorf("Negotiate() = %q, want th", got)
+	}
+	if got := catalog.Negotiate([]string{"fr"}); got != DefaultLocale {
+		t.Errorf("Negotiate() = %q, want %q", got, DefaultLocale)
+	}
+	if got := catalog.Translate("th", "Client not found"); got != "ไม่พบไคลเอนต์" {
+		t.Errorf("Translate() = %q", got)
+	}
+
+	// Untranslated messages and unknown locales fall back to the English text
+	if got := catalog.Translate("th", "Scope not found"); got != "Scope not found" {
+		t.Errorf("Translate() = %q, want the original message", got)
+	}
+	if got := catalog.Translate("fr", "Client not found"); got != "Client not found" {
+		t.Errorf("Translate() = %q, want the original message", got)
+	}
+
+	// Later registrations extend the catalog
+	catalog.Register("th", map[string]string{"Scope not found": "ไม่พบ scope"})
+	if got := catalog.Translate("th", "Client not found"); got != "ไม่พบไคลเอนต์" {
+		t.Errorf("Translate() = %q after merge", got)
+	}
+}
+
+func TestMessageCatalog_LoadDir(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "de.json"), []byte(`{"Allow": "Erlauben"}`), 0644)
+	os.WriteFile(filepath.Join(dir, "th.json"), []byte(`{"Deny": "ไม่อนุญาต"}`), 0644)
+	os.WriteFile(filepath.Join(dir, "README.txt"), []byte("not a translation"), 0644)
+
+	catalog := NewMessageCatalog()
+	catalog.Register("th", map[string]string{"Deny": "ปฏิเสธ", "Allow": "อนุญาต"})
+	if err := catalog.LoadDir(dir); err != nil {
+		t.Fatalf("LoadDir() error = %v", err)
+	}
+
+	if got := catalog.Translate("de", "Allow"); got != "Erlauben" {
+		t.Errorf("Translate(de) = %q", got)
+	}
+	// Files override single built-in messages and keep the rest
+	if got := catalog.Translate("th", "Deny"); got != "ไม่อนุญาต" {
+		t.Errorf("Translate(th, Deny) = %q", got)
+	}
+	if got := catalog.Translate("th", "Allow"); got != "อนุญาต" {
+		t.Errorf("Translate(th, Allow) = %q", got)
+	}
+
+	os.WriteFile(filepath.Join(dir, "fr.json"), []byte(`{"Allow": `), 0644)
+	if err := catalog.LoadDir(dir); err == nil {
+		t.Error("Expected an invalid translation file to fail")
+	}
+}
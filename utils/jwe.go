@@ -10,28 +10,37 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"slices"
 	"strings"
 	"time"
+
+	"github.com/go-jose/go-jose/v4"
 )
 
 // JWEClaims represents generic claims in a JWE token
 type JWEClaims struct {
-	UserID string `json:"sub"`
-	Email  string `json:"email,omitempty"`
-	Name   string `json:"name,omitempty"`
-	Scope  string `json:"scope,omitempty"`
-	Aud    string `json:"aud,omitempty"`
-	Exp    int64  `json:"exp"`
-	Iat    int64  `json:"iat"`
+	UserID    string `json:"sub"`
+	Email     string `json:"email,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	GrantType string `json:"gty,omitempty"`
+	Aud       string `json:"aud,omitempty"`
+	Exp       int64  `json:"exp"`
+	Iat       int64  `json:"iat"`
+	// TokenUse is set on refresh tokens, which are not valid elsewhere
+	TokenUse string `json:"token_use,omitempty"`
 }
 
-// JWEAccessTokenClaims for access tokens
+// JWEAccessTokenClaims for access tokens. GrantType is the gty claim, set
+// like the JWT one on machine tokens.
 type JWEAccessTokenClaims struct {
-	UserID   string `json:"sub"`
-	Scope    string `json:"scope"`
-	ClientID string `json:"client_id,omitempty"`
-	Exp      int64  `json:"exp"`
-	Iat      int64  `json:"iat"`
+	UserID    string `json:"sub"`
+	Scope     string `json:"scope"`
+	ClientID  string `json:"client_id,omitempty"`
+	GrantType string `json:"gty,omitempty"`
+	Exp       int64  `json:"exp"`
+	Iat       int64  `json:"iat"`
 }
 
 // JWEIDTokenClaims for ID tokens
@@ -48,140 +57,218 @@ type JWEIDTokenClaims struct {
 	Iat           int64  `json:"iat"`
 }
 
-// JWERefreshTokenClaims for refresh tokens
+// JWERefreshTokenClaims for refresh tokens. TokenUse marks the token as a
+// refresh token so an encrypted access token cannot be redeemed in its place.
 type JWERefreshTokenClaims struct {
-	UserID string `json:"sub"`
-	Exp    int64  `json:"exp"`
-	Iat    int64  `json:"iat"`
+	UserID   string `json:"sub"`
+	Scope    string `json:"scope,omitempty"`
+	ClientID string `json:"client_id,omitempty"`
+	TokenUse string `json:"token_use,omitempty"`
+	Exp      int64  `json:"exp"`
+	Iat      int64  `json:"iat"`
 }
 
-// EncryptJWE encrypts data into JWE format using RSA-OAEP + AES-256-GCM
+// jweRefreshTokenUse is the token_use value of encrypted refresh tokens
+const jweRefreshTokenUse = "refresh"
+
+// JWE tokens this server issues are RFC 7516 compact serializations with
+// RSA-OAEP-256 key encryption and A256GCM content encryption, so any JOSE
+// library holding the private key can read them
+const (
+	jweKeyAlg       = jose.RSA_OAEP_256
+	jweContentEnc   = jose.A256GCM
+	legacyJWEHeader = `{"alg":"RSA-OAEP","enc":"A256GCM"}`
+)
+
+// AcceptLegacyJWE keeps tokens from the earlier hand-rolled format readable.
+// Those were labelled RSA-OAEP but used SHA-256, and folded the GCM tag into
+// the ciphertext, leaving the fifth segment empty. Off unless turned on, so
+// a server that never read JWE_ACCEPT_LEGACY only accepts RFC 7516 tokens;
+// turn it off once every such token has expired.
+var AcceptLegacyJWE = false
+
+// EncryptionKeyID is the kid of the server's encryption key, set at startup
+// when the key is loaded. While empty, JWE tokens name the signing key, the key they were encrypted
+// to before the server had a separate encryption key.
+var EncryptionKeyID string
+
+// EncryptJWE encrypts data to the server's own key with RSA-OAEP-256 and
+// A256GCM. The kid header names the encryption key.
 func EncryptJWE(data interface{}, publicKey *rsa.PublicKey) (string, error) {
-	// Marshal data to JSON
 	jsonData, err := json.Marshal(data)
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal data: %w", err)
 	}
 
-	// Generate random AES key (256-bit)
-	aesKey := make([]byte, 32)
-	if _, err := rand.Read(aesKey); err != nil {
-		return "", fmt.Errorf("failed to generate AES key: %w", err)
-	}
-
-	// Encrypt AES key with RSA-OAEP
-	encryptedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKey, aesKey, nil)
+	encrypter, err := jose.NewEncrypter(jweContentEnc, jose.Recipient{
+		Algorithm: jweKeyAlg,
+		Key:       publicKey,
+		KeyID:     jweKeyID(),
+	}, nil)
 	if err != nil {
-		return "", fmt.Errorf("failed to encrypt AES key: %w", err)
+		return "", fmt.Errorf("failed to create encrypter: %w", err)
 	}
-
-	// Create AES-GCM cipher
-	block, err := aes.NewCipher(aesKey)
+	encrypted, err := encrypter.Encrypt(jsonData)
 	if err != nil {
-		return "", fmt.Errorf("failed to create AES cipher: %w", err)
+		return "", fmt.Errorf("failed to encrypt data: %w", err)
 	}
+	return encrypted.CompactSerialize()
+}
 
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return "", fmt.Errorf("failed to create GCM: %w", err)
+// DecryptJWE decrypts a JWE token with the server's RSA private key and
+// unmarshals its payload into target. Tokens in the legacy format are
+// accepted while AcceptLegacyJWE is set.
+func DecryptJWE(jweToken string, privateKey *rsa.PrivateKey, target interface{}) error {
+	var plaintext []byte
+	object, err := jose.ParseEncryptedCompact(jweToken, []jose.KeyAlgorithm{jweKeyAlg}, []jose.ContentEncryption{jweContentEnc})
+	if err == nil {
+		if plaintext, err = object.Decrypt(privateKey); err != nil {
+			return fmt.Errorf("failed to decrypt data: %w", err)
+		}
+	} else if AcceptLegacyJWE && isLegacyJWE(jweToken) {
+		if plaintext, err = decryptLegacyJWE(jweToken, privateKey); err != nil {
+			return err
+		}
+	} else {
+		return errors.New("invalid JWE format")
 	}
 
-	// Generate nonce
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err := rand.Read(nonce); err != nil {
-		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	if err := json.Unmarshal(plaintext, target); err != nil {
+		return fmt.Errorf("failed to unmarshal data: %w", err)
 	}
+	return nil
+}
 
-	// Encrypt data
-	ciphertext := gcm.Seal(nil, nonce, jsonData, nil)
+// jweKeyID returns the kid of the key server JWE tokens are encrypted to
+func jweKeyID() string {
+	if EncryptionKeyID != "" {
+		return EncryptionKeyID
+	}
+	return SigningKeyID
+}
 
-	// JWE Compact Serialization: header.encryptedKey.iv.ciphertext.tag
-	// For simplicity, we combine ciphertext and tag (GCM already includes tag)
-	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RSA-OAEP","enc":"A256GCM"}`))
-	encKey := base64.RawURLEncoding.EncodeToString(encryptedKey)
-	iv := base64.RawURLEncoding.EncodeToString(nonce)
-	ct := base64.RawURLEncoding.EncodeToString(ciphertext)
+// decryptJWEWithKeys decrypts a JWE token with the first of privateKeys that
+// can, so tokens encrypted to an earlier key stay readable
+func decryptJWEWithKeys(jweToken string, privateKeys []*rsa.PrivateKey, target interface{}) error {
+	err := errors.New("no decryption key")
+	for _, privateKey := range privateKeys {
+		if err = DecryptJWE(jweToken, privateKey, target); err == nil {
+			return nil
+		}
+	}
+	return err
+}
 
-	return fmt.Sprintf("%s.%s.%s.%s.", header, encKey, iv, ct), nil
+// isLegacyJWE reports whether a token has the fixed header and empty tag
+// segment of the legacy format
+func isLegacyJWE(token string) bool {
+	parts := strings.Split(token, ".")
+	return len(parts) == 5 && parts[4] == "" &&
+		parts[0] == base64.RawURLEncoding.EncodeToString([]byte(legacyJWEHeader))
 }
 
-// DecryptJWE decrypts JWE token using RSA private key
-func DecryptJWE(jweToken string, privateKey *rsa.PrivateKey, target interface{}) error {
+// decryptLegacyJWE decrypts a token in the legacy format
+func decryptLegacyJWE(jweToken string, privateKey *rsa.PrivateKey) ([]byte, error) {
 	parts := strings.Split(jweToken, ".")
-	if len(parts) != 5 {
-		return errors.New("invalid JWE format")
-	}
 
 	// Decode encrypted key
 	encryptedKey, err := base64.RawURLEncoding.DecodeString(parts[1])
 	if err != nil {
-		return fmt.Errorf("failed to decode encrypted key: %w", err)
+		return nil, fmt.Errorf("failed to decode encrypted key: %w", err)
 	}
 
 	// Decrypt AES key with RSA-OAEP
 	aesKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, privateKey, encryptedKey, nil)
 	if err != nil {
-		return fmt.Errorf("failed to decrypt AES key: %w", err)
+		return nil, fmt.Errorf("failed to decrypt AES key: %w", err)
 	}
 
 	// Decode IV (nonce)
 	nonce, err := base64.RawURLEncoding.DecodeString(parts[2])
 	if err != nil {
-		return fmt.Errorf("failed to decode nonce: %w", err)
+		return nil, fmt.Errorf("failed to decode nonce: %w", err)
 	}
 
-	// Decode ciphertext
+	// Decode ciphertext, which carries the GCM tag
 	ciphertext, err := base64.RawURLEncoding.DecodeString(parts[3])
 	if err != nil {
-		return fmt.Errorf("failed to decode ciphertext: %w", err)
+		return nil, fmt.Errorf("failed to decode ciphertext: %w", err)
 	}
 
-	// Create AES-GCM cipher
 	block, err := aes.NewCipher(aesKey)
 	if err != nil {
-		return fmt.Errorf("failed to create AES cipher: %w", err)
+		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
 	}
-
 	gcm, err := cipher.NewGCM(block)
 	if err != nil {
-		return fmt.Errorf("failed to create GCM: %w", err)
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
 	}
 
-	// Decrypt data
 	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
 	if err != nil {
-		return fmt.Errorf("failed to decrypt data: %w", err)
-	}
-
-	// Unmarshal into target
-	if err := json.Unmarshal(plaintext, target); err != nil {
-		return fmt.Errorf("failed to unmarshal data: %w", err)
+		return nil, fmt.Errorf("failed to decrypt data: %w", err)
 	}
-
-	return nil
+	return plaintext, nil
 }
 
 // GenerateJWEAccessToken creates an encrypted access token
 func GenerateJWEAccessToken(userID, email, name, scope string, publicKey *rsa.PublicKey, expiry int64) (string, error) {
+	return GenerateJWEAccessTokenForClient(userID, "", email, name, scope, publicKey, expiry)
+}
+
+// GenerateJWEAccessTokenForClient creates an encrypted access token naming
+// the client it was issued to, so revoking the client or the user's grant to
+// it revokes the token
+func GenerateJWEAccessTokenForClient(userID, clientID, email, name, scope string, publicKey *rsa.PublicKey, expiry int64) (string, error) {
 	claims := JWEAccessTokenClaims{
-		UserID: userID,
-		Scope:  scope,
-		Exp:    expiry,
-		Iat:    time.Now().Unix(),
+		UserID:   userID,
+		Scope:    scope,
+		ClientID: clientID,
+		Exp:      expiry,
+		Iat:      time.Now().Unix(),
 	}
 	return EncryptJWE(claims, publicKey)
 }
 
 // GenerateJWERefreshToken creates an encrypted refresh token
 func GenerateJWERefreshToken(userID string, publicKey *rsa.PublicKey, expiry int64) (string, error) {
+	return GenerateJWERefreshTokenForClient(userID, "", "", publicKey, expiry)
+}
+
+// GenerateJWERefreshTokenForClient creates an encrypted refresh token bound
+// to the client it was issued to and carrying the granted scope, so it can be
+// redeemed at the refresh grant
+func GenerateJWERefreshTokenForClient(userID, clientID, scope string, publicKey *rsa.PublicKey, expiry int64) (string, error) {
 	claims := JWERefreshTokenClaims{
-		UserID: userID,
-		Exp:    expiry,
-		Iat:    time.Now().Unix(),
+		UserID:   userID,
+		Scope:    scope,
+		ClientID: clientID,
+		TokenUse: jweRefreshTokenUse,
+		Exp:      expiry,
+		Iat:      time.Now().Unix(),
 	}
 	return EncryptJWE(claims, publicKey)
 }
 
+// ValidateJWERefreshToken decrypts an encrypted refresh token with the first
+// of privateKeys that can and checks that it has not expired and really is a
+// refresh token
+func ValidateJWERefreshToken(jweToken string, privateKeys ...*rsa.PrivateKey) (*JWERefreshTokenClaims, error) {
+	var claims JWERefreshTokenClaims
+	if err := decryptJWEWithKeys(jweToken, privateKeys, &claims); err != nil {
+		return nil, err
+	}
+
+	if claims.TokenUse != jweRefreshTokenUse {
+		return nil, errors.New("not a refresh token")
+	}
+	if time.Now().Unix() > claims.Exp {
+		return nil, errors.New("token expired")
+	}
+
+	return &claims, nil
+}
+
 // GenerateJWEIDToken creates an encrypted ID token with filtered claims
 func GenerateJWEIDToken(userID, clientID string, userClaims map[string]interface{}, publicKey *rsa.PublicKey, expiry int64) (string, error) {
 	// Start with user claims (already filtered by scope)
@@ -195,7 +282,7 @@ func GenerateJWEIDToken(userID, clientID string, userClaims map[string]interface
 	// Add standard JWT claims
 	claims["sub"] = userID
 	claims["aud"] = clientID
-	claims["iss"] = "oauth2-server"
+	claims["iss"] = TokenIssuer
 	claims["exp"] = expiry
 	claims["iat"] = time.Now().Unix()
 	
@@ -210,17 +297,18 @@ func GenerateJWEIDTokenLegacy(userID, email, name, clientID string, publicKey *r
 		EmailVerified: true,
 		Name:          name,
 		Aud:           clientID,
-		Iss:           "oauth2-server",
+		Iss:           TokenIssuer,
 		Exp:           expiry,
 		Iat:           time.Now().Unix(),
 	}
 	return EncryptJWE(claims, publicKey)
 }
 
-// ValidateJWE validates and decrypts a JWE token
-func ValidateJWE(jweToken string, privateKey *rsa.PrivateKey) (*JWEClaims, error) {
+// ValidateJWE validates and decrypts a JWE token, trying privateKeys in order:
+// the encryption key first, then keys earlier tokens were encrypted to
+func ValidateJWE(jweToken string, privateKeys ...*rsa.PrivateKey) (*JWEClaims, error) {
 	var claims JWEClaims
-	if err := DecryptJWE(jweToken, privateKey, &claims); err != nil {
+	if err := decryptJWEWithKeys(jweToken, privateKeys, &claims); err != nil {
 		return nil, err
 	}
 
@@ -243,3 +331,43 @@ func IsJWT(token string) bool {
 	parts := strings.Split(token, ".")
 	return len(parts) == 3
 }
+
+// Key management and content encryption algorithms accepted for responses
+// encrypted to a client's key
+var (
+	JWEKeyAlgs     = []string{"RSA-OAEP", "RSA-OAEP-256"}
+	JWEContentEncs = []string{"A128CBC-HS256", "A256GCM"}
+)
+
+// DefaultJWEContentEnc is the enc used when a client registers only an alg
+// (OpenID Connect Dynamic Client Registration section 2)
+const DefaultJWEContentEnc = "A128CBC-HS256"
+
+// EncryptJWEForClient encrypts payload to a client's RSA key with one of
+// JWEKeyAlgs and JWEContentEncs. cty is set when the payload is itself a JWT.
+func EncryptJWEForClient(payload []byte, publicKey *rsa.PublicKey, kid, alg, enc, cty string) (string, error) {
+	if !slices.Contains(JWEKeyAlgs, alg) {
+		return "", errors.New("unsupported alg " + alg)
+	}
+	if !slices.Contains(JWEContentEncs, enc) {
+		return "", errors.New("unsupported enc " + enc)
+	}
+
+	opts := &jose.EncrypterOptions{}
+	if cty != "" {
+		opts.WithContentType(jose.ContentType(cty))
+	}
+	encrypter, err := jose.NewEncrypter(jose.ContentEncryption(enc), jose.Recipient{
+		Algorithm: jose.KeyAlgorithm(alg),
+		Key:       publicKey,
+		KeyID:     kid,
+	}, opts)
+	if err != nil {
+		return "", fmt.Errorf("failed to create encrypter: %w", err)
+	}
+	encrypted, err := encrypter.Encrypt(payload)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt payload: %w", err)
+	}
+	return encrypted.CompactSerialize()
+}
@@ -1,8 +1,18 @@
 package utils
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/go-jose/go-jose/v4"
 )
 
 func TestEncryptDecryptJWE(t *testing.T) {
@@ -92,6 +102,44 @@ func TestGenerateJWERefreshToken(t *testing.T) {
 	}
 }
 
+func TestValidateJWERefreshToken(t *testing.T) {
+	privateKey, err := GenerateRSAKeyPair(2048)
+	if err != nil {
+		t.Fatalf("Failed to generate keys: %v", err)
+	}
+	publicKey := &privateKey.PublicKey
+
+	expiry := time.Now().Add(24 * time.Hour).Unix()
+	token, err := GenerateJWERefreshTokenForClient("user123", "client-a", "openid profile", publicKey, expiry)
+	if err != nil {
+		t.Fatalf("Failed to generate JWE refresh token: %v", err)
+	}
+
+	claims, err := ValidateJWERefreshToken(token, privateKey)
+	if err != nil {
+		t.Fatalf("Failed to validate JWE refresh token: %v", err)
+	}
+	if claims.UserID != "user123" || claims.ClientID != "client-a" || claims.Scope != "openid profile" {
+		t.Errorf("Unexpected claims: %+v", claims)
+	}
+
+	expired, err := GenerateJWERefreshTokenForClient("user123", "client-a", "openid", publicKey, time.Now().Add(-time.Minute).Unix())
+	if err != nil {
+		t.Fatalf("Failed to generate JWE refresh token: %v", err)
+	}
+	if _, err := ValidateJWERefreshToken(expired, privateKey); err == nil {
+		t.Error("Expected expired refresh token to be rejected")
+	}
+
+	accessToken, err := GenerateJWEAccessToken("user123", "", "", "openid", publicKey, expiry)
+	if err != nil {
+		t.Fatalf("Failed to generate JWE access token: %v", err)
+	}
+	if _, err := ValidateJWERefreshToken(accessToken, privateKey); err == nil {
+		t.Error("Expected access token to be rejected as a refresh token")
+	}
+}
+
 func TestGenerateJWEIDToken(t *testing.T) {
 	privateKey, err := GenerateRSAKeyPair(2048)
 	if err != nil {
@@ -183,6 +231,44 @@ func TestDecryptJWEWithWrongKey(t *testing.T) {
 	}
 }
 
+func TestValidateJWE_EncryptionKeyThenSigningKey(t *testing.T) {
+	signingKey, err := GenerateRSAKeyPair(2048)
+	if err != nil {
+		t.Fatalf("Failed to generate keys: %v", err)
+	}
+	encryptionKey, err := GenerateRSAKeyPair(2048)
+	if err != nil {
+		t.Fatalf("Failed to generate keys: %v", err)
+	}
+	originalKeyID := EncryptionKeyID
+	EncryptionKeyID = "enc-1"
+	defer func() { EncryptionKeyID = originalKeyID }()
+	expiry := time.Now().Add(time.Hour).Unix()
+
+	token, err := GenerateJWEAccessToken("user123", "", "", "openid", &encryptionKey.PublicKey, expiry)
+	if err != nil {
+		t.Fatalf("Failed to generate JWE token: %v", err)
+	}
+	if header, _ := jose.ParseEncryptedCompact(token, []jose.KeyAlgorithm{jweKeyAlg}, []jose.ContentEncryption{jweContentEnc}); header == nil || header.Header.KeyID != "enc-1" {
+		t.Error("Expected the kid header to name the encryption key")
+	}
+	if _, err := ValidateJWE(token, encryptionKey, signingKey); err != nil {
+		t.Errorf("Expected a token encrypted to the encryption key to validate: %v", err)
+	}
+
+	// Issued before the server had an encryption key
+	earlier, err := GenerateJWERefreshTokenForClient("user123", "client-1", "openid", &signingKey.PublicKey, expiry)
+	if err != nil {
+		t.Fatalf("Failed to generate JWE token: %v", err)
+	}
+	if _, err := ValidateJWERefreshToken(earlier, encryptionKey, signingKey); err != nil {
+		t.Errorf("Expected a token encrypted to the signing key to validate: %v", err)
+	}
+	if _, err := ValidateJWERefreshToken(earlier, encryptionKey); err == nil {
+		t.Error("Expected the token to be rejected without the signing key")
+	}
+}
+
 func TestIsJWEAndIsJWT(t *testing.T) {
 	jweToken := "header.encrypted_key.iv.ciphertext."
 	if !IsJWE(jweToken) {
@@ -213,3 +299,122 @@ func TestDecryptJWEInvalidToken(t *testing.T) {
 		t.Error("Expected error when decrypting invalid token")
 	}
 }
+
+func TestEncryptJWEForClient(t *testing.T) {
+	privateKey, err := GenerateRSAKeyPair(2048)
+	if err != nil {
+		t.Fatalf("Failed to generate keys: %v", err)
+	}
+	payload := []byte(`{"sub":"user123"}`)
+
+	for _, alg := range JWEKeyAlgs {
+		for _, enc := range JWEContentEncs {
+			t.Run(alg+"/"+enc, func(t *testing.T) {
+				token, err := EncryptJWEForClient(payload, &privateKey.PublicKey, "client-key", alg, enc, "JWT")
+				if err != nil {
+					t.Fatalf("Failed to encrypt: %v", err)
+				}
+				header, plaintext, err := DecryptClientJWE(token, privateKey)
+				if err != nil {
+					t.Fatalf("Failed to decrypt: %v", err)
+				}
+				if string(plaintext) != string(payload) {
+					t.Errorf("Expected %s, got %s", payload, plaintext)
+				}
+				if header["alg"] != alg || header["enc"] != enc || header["kid"] != "client-key" || header["cty"] != "JWT" {
+					t.Errorf("Unexpected header %v", header)
+				}
+			})
+		}
+	}
+
+	if _, err := EncryptJWEForClient(payload, &privateKey.PublicKey, "", "RSA1_5", "A256GCM", ""); err == nil {
+		t.Error("Expected RSA1_5 to be rejected")
+	}
+	if _, err := EncryptJWEForClient(payload, &privateKey.PublicKey, "", "RSA-OAEP-256", "A128GCM", ""); err == nil {
+		t.Error("Expected A128GCM to be rejected")
+	}
+}
+
+func TestEncryptJWE_IsStandardCompactJWE(t *testing.T) {
+	privateKey, err := GenerateRSAKeyPair(2048)
+	if err != nil {
+		t.Fatalf("Failed to generate keys: %v", err)
+	}
+
+	token, err := EncryptJWE(map[string]string{"sub": "user123"}, &privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("Failed to encrypt JWE: %v", err)
+	}
+	for i, part := range strings.Split(token, ".") {
+		if part == "" && i != 1 {
+			t.Errorf("Expected segment %d to be present", i)
+		}
+	}
+
+	// Any JOSE library can read it
+	object, err := jose.ParseEncryptedCompact(token, []jose.KeyAlgorithm{jose.RSA_OAEP_256}, []jose.ContentEncryption{jose.A256GCM})
+	if err != nil {
+		t.Fatalf("Failed to parse with go-jose: %v", err)
+	}
+	if object.Header.KeyID != SigningKeyID {
+		t.Errorf("Expected kid %q, got %q", SigningKeyID, object.Header.KeyID)
+	}
+	plaintext, err := object.Decrypt(privateKey)
+	if err != nil || string(plaintext) != `{"sub":"user123"}` {
+		t.Errorf("Unexpected plaintext %s (%v)", plaintext, err)
+	}
+}
+
+// encryptLegacyJWE builds a token in the format issued before JWEs were
+// standard: a fixed RSA-OAEP header over SHA-256 OAEP, with the GCM tag left
+// in the ciphertext and an empty fifth segment
+func encryptLegacyJWE(t *testing.T, data interface{}, publicKey *rsa.PublicKey) string {
+	t.Helper()
+	jsonData, _ := json.Marshal(data)
+	aesKey := make([]byte, 32)
+	rand.Read(aesKey)
+	encryptedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKey, aesKey, nil)
+	if err != nil {
+		t.Fatalf("Failed to encrypt key: %v", err)
+	}
+	block, _ := aes.NewCipher(aesKey)
+	gcm, _ := cipher.NewGCM(block)
+	nonce := make([]byte, gcm.NonceSize())
+	rand.Read(nonce)
+
+	return strings.Join([]string{
+		base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RSA-OAEP","enc":"A256GCM"}`)),
+		base64.RawURLEncoding.EncodeToString(encryptedKey),
+		base64.RawURLEncoding.EncodeToString(nonce),
+		base64.RawURLEncoding.EncodeToString(gcm.Seal(nil, nonce, jsonData, nil)),
+		"",
+	}, ".")
+}
+
+func TestDecryptJWE_LegacyFormat(t *testing.T) {
+	privateKey, err := GenerateRSAKeyPair(2048)
+	if err != nil {
+		t.Fatalf("Failed to generate keys: %v", err)
+	}
+	claims := JWERefreshTokenClaims{UserID: "user123", TokenUse: jweRefreshTokenUse, Exp: time.Now().Add(time.Hour).Unix()}
+	token := encryptLegacyJWE(t, claims, &privateKey.PublicKey)
+
+	AcceptLegacyJWE = true
+	defer func() { AcceptLegacyJWE = false }()
+	if !IsJWE(token) {
+		t.Fatal("Expected legacy tokens to still be routed as JWE")
+	}
+	decrypted, err := ValidateJWERefreshToken(token, privateKey)
+	if err != nil {
+		t.Fatalf("Expected the legacy token to be accepted: %v", err)
+	}
+	if decrypted.UserID != "user123" {
+		t.Errorf("Expected sub user123, got %q", decrypted.UserID)
+	}
+
+	AcceptLegacyJWE = false
+	if _, err := ValidateJWERefreshToken(token, privateKey); err == nil {
+		t.Error("Expected the legacy token to be rejected once the deprecation window ends")
+	}
+}
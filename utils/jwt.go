@@ -5,65 +5,208 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// TokenIssuer is the iss claim of every token this server issues and the
+// issuer required when validating one, set at startup from ISSUER_URL.
+// Every token is bound to the issuer that is set, so a wrong value rejects
+// tokens rather than accepting foreign ones.
+var TokenIssuer = "http://localhost:8080"
+
+// SigningKeyID is the kid of the active signing key, named in the header of
+// every signed token and in the JWKS, set at startup when the key is loaded
+var SigningKeyID = "1"
+
+// Token types named in the typ header, so that a token of one kind is never
+// accepted as another: access tokens follow RFC 9068, refresh tokens get a
+// type of their own and logout tokens follow OpenID Connect Back-Channel
+// Logout. ID tokens keep the default JWT.
+const (
+	AccessTokenType  = "at+jwt"
+	RefreshTokenType = "refresh+jwt"
+	LogoutTokenType  = "logout+jwt"
+)
+
+// BackchannelLogoutEvent is the events member that marks a JWT as a logout
+// token
+const BackchannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+
+// ErrTokenType is returned when validating a token of another kind, such as
+// a refresh or ID token presented as an access token
+var ErrTokenType = errors.New("unexpected token type")
+
+// UntypedTokensIssuedBefore keeps access and refresh tokens issued before
+// tokens were typed valid until they expire: a token without a type is
+// accepted if issued before this time. It is set to the startup time while
+// ACCEPT_UNTYPED_TOKENS is on; the zero value rejects every untyped token.
+var UntypedTokensIssuedBefore time.Time
+
 type JWTClaims struct {
-	UserID string `json:"sub"`
-	Email  string `json:"email,omitempty"`
-	Name   string `json:"name,omitempty"`
-	Scope  string `json:"scope,omitempty"`
+	UserID    string             `json:"sub"`
+	Email     string             `json:"email,omitempty"`
+	Name      string             `json:"name,omitempty"`
+	Scope     string             `json:"scope,omitempty"`
+	ClientID  string             `json:"client_id,omitempty"`
+	GrantType string             `json:"gty,omitempty"`
+	Act       *ActorClaim        `json:"act,omitempty"`
+	Cnf       *ConfirmationClaim `json:"cnf,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// ActorClaim identifies the party acting on behalf of the token subject
+// (RFC 8693 section 4.1). Act holds the previous actor of a delegation chain.
+type ActorClaim struct {
+	Subject  string      `json:"sub"`
+	ClientID string      `json:"client_id,omitempty"`
+	Act      *ActorClaim `json:"act,omitempty"`
+}
+
 type IDTokenClaims struct {
 	jwt.RegisteredClaims
 	// Additional claims are added dynamically via MapClaims
 }
 
 type AccessTokenClaims struct {
-	UserID   string `json:"sub"`
-	Scope    string `json:"scope"`
-	ClientID string `json:"client_id,omitempty"`
+	UserID   string             `json:"sub"`
+	Scope    string             `json:"scope"`
+	ClientID string             `json:"client_id,omitempty"`
+	Act      *ActorClaim        `json:"act,omitempty"`
+	Cnf      *ConfirmationClaim `json:"cnf,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// ClientCredentialsGrantType is the gty claim of machine tokens: access
+// tokens a client obtained for itself, with no user behind them
+const ClientCredentialsGrantType = "client_credentials"
+
+// ClientAccessTokenClaims are the claims of a machine token. The subject is
+// the client and there are no profile claims.
+type ClientAccessTokenClaims struct {
+	Scope     string             `json:"scope"`
+	ClientID  string             `json:"client_id"`
+	GrantType string             `json:"gty"`
+	Cnf       *ConfirmationClaim `json:"cnf,omitempty"`
 	jwt.RegisteredClaims
 }
 
 func GenerateAccessToken(userID, email, name, scope string, privateKey *rsa.PrivateKey, expiry int64) (string, error) {
+	return GenerateAccessTokenForClient(userID, "", email, name, scope, nil, privateKey, expiry)
+}
+
+// GenerateAccessTokenForClient issues an access token that records the client
+// it was issued to, so resource endpoints can check the user's consent for it.
+// A non-empty audience restricts the token to those resource servers (RFC 8707).
+func GenerateAccessTokenForClient(userID, clientID, email, name, scope string, audience []string, privateKey *rsa.PrivateKey, expiry int64) (string, error) {
+	return GenerateDelegatedAccessToken(userID, clientID, email, name, scope, audience, nil, privateKey, expiry)
+}
+
+// GenerateDelegatedAccessToken issues an access token whose act claim names
+// the actor using it on the subject's behalf. A nil actor issues an ordinary
+// access token.
+func GenerateDelegatedAccessToken(userID, clientID, email, name, scope string, audience []string, actor *ActorClaim, privateKey *rsa.PrivateKey, expiry int64) (string, error) {
+	return GenerateBoundAccessToken(userID, clientID, email, name, scope, audience, actor, nil, privateKey, expiry)
+}
+
+// GenerateBoundAccessToken issues an access token bound to the client
+// certificate (RFC 8705) or DPoP key (RFC 9449) named in cnf. A nil cnf
+// issues an ordinary bearer token.
+func GenerateBoundAccessToken(userID, clientID, email, name, scope string, audience []string, actor *ActorClaim, cnf *ConfirmationClaim, privateKey *rsa.PrivateKey, expiry int64) (string, error) {
+	jti, err := GenerateRandomString(22)
+	if err != nil {
+		return "", err
+	}
+	// Tokens not restricted to resource servers are for this server's own
+	// endpoints
+	if len(audience) == 0 {
+		audience = []string{TokenIssuer}
+	}
+
 	claims := AccessTokenClaims{
-		UserID: userID,
-		Scope:  scope,
+		UserID:   userID,
+		Scope:    scope,
+		ClientID: clientID,
+		Act:      actor,
 		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    TokenIssuer,
 			Subject:   userID,
+			Audience:  audience,
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Duration(expiry) * time.Second)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ID:        jti,
 		},
+		Cnf: cnf,
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
-	return token.SignedString(privateKey)
+	return signToken(claims, AccessTokenType, privateKey)
 }
 
+// GenerateClientAccessToken issues a machine token to a client acting on
+// its own behalf. It only has an audience when resource servers were
+// requested, and a non-nil cnf binds it like GenerateBoundAccessToken.
+func GenerateClientAccessToken(clientID, scope string, audience []string, cnf *ConfirmationClaim, privateKey *rsa.PrivateKey, expiry int64) (string, error) {
+	jti, err := GenerateRandomString(22)
+	if err != nil {
+		return "", err
+	}
+
+	claims := ClientAccessTokenClaims{
+		Scope:     scope,
+		ClientID:  clientID,
+		GrantType: ClientCredentialsGrantType,
+		Cnf:       cnf,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    TokenIssuer,
+			Subject:   clientID,
+			Audience:  audience,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Duration(expiry) * time.Second)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ID:        jti,
+		},
+	}
+
+	return signToken(claims, AccessTokenType, privateKey)
+}
+
+// RefreshTokenClaims binds a refresh token to the client it was issued to
+// and the resource servers the original grant covered. AuthTime is when the
+// user authenticated for the original grant, carried into ID tokens issued
+// on refresh.
 type RefreshTokenClaims struct {
-	UserID string `json:"sub"`
-	Scope  string `json:"scope"`
+	UserID    string   `json:"sub"`
+	Scope     string   `json:"scope"`
+	ClientID  string   `json:"client_id,omitempty"`
+	Resources []string `json:"resources,omitempty"`
+	AuthTime  int64    `json:"auth_time,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func GenerateRefreshToken(userID, scope string, privateKey *rsa.PrivateKey, expiry int64) (string, error) {
+func GenerateRefreshToken(userID, clientID, scope string, privateKey *rsa.PrivateKey, expiry int64) (string, error) {
+	return GenerateRefreshTokenForResources(userID, clientID, scope, nil, 0, privateKey, expiry)
+}
+
+// GenerateRefreshTokenForResources issues a refresh token that remembers the
+// resource indicators and authentication time (unix seconds, 0 when unknown)
+// of the original grant
+func GenerateRefreshTokenForResources(userID, clientID, scope string, resources []string, authTime int64, privateKey *rsa.PrivateKey, expiry int64) (string, error) {
 	claims := RefreshTokenClaims{
-		UserID: userID,
-		Scope:  scope,
+		UserID:    userID,
+		Scope:     scope,
+		ClientID:  clientID,
+		Resources: resources,
+		AuthTime:  authTime,
 		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    TokenIssuer,
 			Subject:   userID,
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Duration(expiry) * time.Second)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
-	return token.SignedString(privateKey)
+	return signToken(claims, RefreshTokenType, privateKey)
 }
 
 // GenerateIDToken generates an ID token with filtered claims based on scopes
@@ -81,10 +224,42 @@ func GenerateIDToken(userID, clientID string, userClaims map[string]interface{},
 	claims["aud"] = clientID
 	claims["exp"] = time.Now().Add(time.Duration(expiry) * time.Second).Unix()
 	claims["iat"] = time.Now().Unix()
-	claims["iss"] = "http://localhost:8080"
+	claims["iss"] = TokenIssuer
 
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
-	return token.SignedString(privateKey)
+	return signToken(claims, "", privateKey)
+}
+
+// GenerateUserInfoToken signs a UserInfo response for the client it is
+// addressed to (OpenID Connect Core section 5.3.2)
+func GenerateUserInfoToken(clientID string, userClaims map[string]interface{}, privateKey *rsa.PrivateKey) (string, error) {
+	claims := jwt.MapClaims{}
+	for key, value := range userClaims {
+		claims[key] = value
+	}
+	claims["aud"] = clientID
+	claims["iat"] = time.Now().Unix()
+	claims["iss"] = TokenIssuer
+
+	return signToken(claims, "", privateKey)
+}
+
+// GenerateLogoutToken signs a back-channel logout token telling the client
+// to end the user's sessions. The jti is unique per logout so the client can
+// reject a token it has already processed; unlike an ID token it never
+// carries a nonce.
+func GenerateLogoutToken(userID, clientID, jti string, privateKey *rsa.PrivateKey, expiry int64) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":    TokenIssuer,
+		"sub":    userID,
+		"aud":    clientID,
+		"iat":    now.Unix(),
+		"exp":    now.Add(time.Duration(expiry) * time.Second).Unix(),
+		"jti":    jti,
+		"events": map[string]interface{}{BackchannelLogoutEvent: map[string]interface{}{}},
+	}
+
+	return signToken(claims, LogoutTokenType, privateKey)
 }
 
 // GenerateIDTokenLegacy generates an ID token with explicit claims (deprecated, use GenerateIDToken with filtered claims)
@@ -97,26 +272,55 @@ func GenerateIDTokenLegacy(userID, email, name, clientID string, privateKey *rsa
 		"aud":            clientID,
 		"exp":            time.Now().Add(time.Duration(expiry) * time.Second).Unix(),
 		"iat":            time.Now().Unix(),
-		"iss":            "oauth2-server",
+		"iss":            TokenIssuer,
 	}
 
+	return signToken(claims, "", privateKey)
+}
+
+// signToken signs the claims with RS256, naming the signing key in the kid
+// header so verifiers can pick it from the JWKS. An empty typ keeps the
+// default JWT.
+func signToken(claims jwt.Claims, typ string, privateKey *rsa.PrivateKey) (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = SigningKeyID
+	if typ != "" {
+		token.Header["typ"] = typ
+	}
 	return token.SignedString(privateKey)
 }
 
+// checkTokenType rejects a token whose typ header is not typ. The media type
+// form, such as application/at+jwt, is accepted too (RFC 8725 section 3.11).
+// Untyped tokens pass only under UntypedTokensIssuedBefore.
+func checkTokenType(token *jwt.Token, typ string, issuedAt *jwt.NumericDate) error {
+	header, _ := token.Header["typ"].(string)
+	header = strings.TrimPrefix(strings.ToLower(header), "application/")
+	if header == typ {
+		return nil
+	}
+	if (header == "" || header == "jwt") && issuedAt != nil && issuedAt.Before(UntypedTokensIssuedBefore) {
+		return nil
+	}
+	return ErrTokenType
+}
+
 func ValidateToken(tokenString string, publicKey *rsa.PublicKey) (*JWTClaims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
 			return nil, errors.New("unexpected signing method")
 		}
 		return publicKey, nil
-	})
+	}, jwt.WithIssuer(TokenIssuer))
 
 	if err != nil {
 		return nil, err
 	}
 
 	if claims, ok := token.Claims.(*JWTClaims); ok && token.Valid {
+		if err := checkTokenType(token, AccessTokenType, claims.IssuedAt); err != nil {
+			return nil, err
+		}
 		return claims, nil
 	}
 
@@ -129,13 +333,16 @@ func ValidateRefreshToken(tokenString string, publicKey *rsa.PublicKey) (*Refres
 			return nil, errors.New("unexpected signing method")
 		}
 		return publicKey, nil
-	})
+	}, jwt.WithIssuer(TokenIssuer))
 
 	if err != nil {
 		return nil, err
 	}
 
 	if claims, ok := token.Claims.(*RefreshTokenClaims); ok && token.Valid {
+		if err := checkTokenType(token, RefreshTokenType, claims.IssuedAt); err != nil {
+			return nil, err
+		}
 		return claims, nil
 	}
 
@@ -1,13 +1,43 @@
 package utils
 
 import (
+	"bytes"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
 	"encoding/pem"
+	"errors"
+	"math/big"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
+// Signing key files within the keys directory
+const (
+	PrivateKeyFile = "private.pem"
+	PublicKeyFile  = "public.pem"
+	KeyIDFile      = "kid"
+)
+
+// Encryption key files within the keys directory. JWE tokens are encrypted
+// to this key pair rather than to the signing key.
+const (
+	EncryptionKeyFile   = "encryption.pem"
+	EncryptionKeyIDFile = "encryption_kid"
+)
+
+// minImportedKeyBits is the smallest RSA key accepted as a signing key
+const minImportedKeyBits = 2048
+
+// ErrUnsupportedKeyType is returned when importing a key the server cannot
+// use. Tokens are signed with RS256 and encrypted with RSA-OAEP, so only RSA
+// keys can be imported.
+var ErrUnsupportedKeyType = errors.New("unsupported key type: only RSA private keys can be imported")
+
 func GenerateRSAKeyPair(bits int) (*rsa.PrivateKey, error) {
 	privateKey, err := rsa.GenerateKey(rand.Reader, bits)
 	if err != nil {
@@ -59,3 +89,172 @@ func LoadPublicKeyFromFile(filename string) (*rsa.PublicKey, error) {
 	}
 	return ParsePublicKey(string(keyData))
 }
+
+// ImportPrivateKey parses an RSA private key exported from another identity
+// provider, either PEM encoded (PKCS#1 or PKCS#8) or as a JWK. For a JWK the
+// kid it carries is returned too.
+func ImportPrivateKey(data []byte) (*rsa.PrivateKey, string, error) {
+	data = bytes.TrimSpace(data)
+
+	var privateKey *rsa.PrivateKey
+	var kid string
+	if bytes.HasPrefix(data, []byte("{")) {
+		key, jwkKid, err := parsePrivateKeyJWK(data)
+		if err != nil {
+			return nil, "", err
+		}
+		privateKey, kid = key, jwkKid
+	} else {
+		key, err := parsePrivateKeyPEM(data)
+		if err != nil {
+			return nil, "", err
+		}
+		privateKey = key
+	}
+
+	if privateKey.N.BitLen() < minImportedKeyBits {
+		return nil, "", errors.New("RSA keys must be at least 2048 bits")
+	}
+	return privateKey, kid, nil
+}
+
+func parsePrivateKeyPEM(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("key is neither PEM nor JWK")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return nil, ErrUnsupportedKeyType
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, ErrUnsupportedKeyType
+	}
+	return rsaKey, nil
+}
+
+// privateJWK holds the RSA private key members of a JWK (RFC 7518 section 6.3)
+type privateJWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	D   string `json:"d"`
+	P   string `json:"p"`
+	Q   string `json:"q"`
+}
+
+func parsePrivateKeyJWK(data []byte) (*rsa.PrivateKey, string, error) {
+	var jwk privateJWK
+	if err := json.Unmarshal(data, &jwk); err != nil {
+		return nil, "", errors.New("invalid JWK: " + err.Error())
+	}
+	if jwk.Kty != "RSA" {
+		return nil, "", ErrUnsupportedKeyType
+	}
+	if jwk.D == "" || jwk.P == "" || jwk.Q == "" {
+		return nil, "", errors.New("JWK must be a private key including the primes p and q")
+	}
+
+	values := make([]*big.Int, 0, 5)
+	for _, member := range []string{jwk.N, jwk.E, jwk.D, jwk.P, jwk.Q} {
+		decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(member, "="))
+		if err != nil || len(decoded) == 0 {
+			return nil, "", errors.New("invalid JWK: malformed key parameter")
+		}
+		values = append(values, new(big.Int).SetBytes(decoded))
+	}
+	if !values[1].IsInt64() || values[1].Int64() > 1<<31-1 {
+		return nil, "", errors.New("invalid JWK: exponent too large")
+	}
+
+	privateKey := &rsa.PrivateKey{
+		PublicKey: rsa.PublicKey{N: values[0], E: int(values[1].Int64())},
+		D:         values[2],
+		Primes:    []*big.Int{values[3], values[4]},
+	}
+	if err := privateKey.Validate(); err != nil {
+		return nil, "", errors.New("invalid JWK: " + err.Error())
+	}
+	privateKey.Precompute()
+
+	return privateKey, jwk.Kid, nil
+}
+
+// ParsePublicKeyJWK builds an RSA public key from the n and e members of a
+// JWK. Keys shorter than 2048 bits are rejected.
+func ParsePublicKeyJWK(n, e string) (*rsa.PublicKey, error) {
+	modulus, errN := base64.RawURLEncoding.DecodeString(strings.TrimRight(n, "="))
+	exponent, errE := base64.RawURLEncoding.DecodeString(strings.TrimRight(e, "="))
+	if errN != nil || errE != nil || len(modulus) == 0 || len(exponent) == 0 {
+		return nil, errors.New("invalid JWK: malformed key parameter")
+	}
+	eInt := new(big.Int).SetBytes(exponent)
+	if !eInt.IsInt64() || eInt.Int64() > 1<<31-1 || eInt.Int64() < 3 {
+		return nil, errors.New("invalid JWK: bad exponent")
+	}
+	publicKey := &rsa.PublicKey{N: new(big.Int).SetBytes(modulus), E: int(eInt.Int64())}
+	if publicKey.N.BitLen() < 2048 {
+		return nil, errors.New("invalid JWK: RSA keys must be at least 2048 bits")
+	}
+	return publicKey, nil
+}
+
+// SaveSigningKey writes the signing key pair and its kid to dir
+func SaveSigningKey(dir string, privateKey *rsa.PrivateKey, kid string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	if err := SavePrivateKeyToFile(privateKey, filepath.Join(dir, PrivateKeyFile)); err != nil {
+		return err
+	}
+	if err := SavePublicKeyToFile(&privateKey.PublicKey, filepath.Join(dir, PublicKeyFile)); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, KeyIDFile), []byte(kid+"\n"), 0644)
+}
+
+// SaveEncryptionKey writes the encryption private key and its kid to dir
+func SaveEncryptionKey(dir string, privateKey *rsa.PrivateKey, kid string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	if err := SavePrivateKeyToFile(privateKey, filepath.Join(dir, EncryptionKeyFile)); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, EncryptionKeyIDFile), []byte(kid+"\n"), 0644)
+}
+
+// ReplaceSigningKey saves privateKey as the signing key in dir, keeping the
+// files of the key it replaces as .bak so the change can be rolled back
+func ReplaceSigningKey(dir string, privateKey *rsa.PrivateKey, kid string) error {
+	for _, name := range []string{PrivateKeyFile, PublicKeyFile, KeyIDFile} {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			if err := os.Rename(path, path+".bak"); err != nil {
+				return err
+			}
+		}
+	}
+	return SaveSigningKey(dir, privateKey, kid)
+}
+
+// KeyThumbprint returns the RFC 7638 JWK thumbprint of an RSA public key,
+// a stable kid for keys that do not come with one
+func KeyThumbprint(publicKey *rsa.PublicKey) string {
+	e := base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+	n := base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
+
+	// Required members in lexicographic order, without whitespace
+	sum := sha256.Sum256([]byte(`{"e":"` + e + `","kty":"RSA","n":"` + n + `"}`))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -1,6 +1,15 @@
 package utils
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
 	"os"
 	"testing"
 )
@@ -139,3 +148,120 @@ func TestGenerateRSAKeyPairSmallSize(t *testing.T) {
 		t.Error("Expected non-nil private key")
 	}
 }
+
+func TestImportPrivateKey(t *testing.T) {
+	privateKey, err := GenerateRSAKeyPair(2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key pair: %v", err)
+	}
+
+	pkcs8, _ := x509.MarshalPKCS8PrivateKey(privateKey)
+	b64 := func(i *big.Int) string { return base64.RawURLEncoding.EncodeToString(i.Bytes()) }
+	jwk, _ := json.Marshal(map[string]string{
+		"kty": "RSA",
+		"kid": "old-idp-2024",
+		"n":   b64(privateKey.N),
+		"e":   b64(big.NewInt(int64(privateKey.E))),
+		"d":   b64(privateKey.D),
+		"p":   b64(privateKey.Primes[0]),
+		"q":   b64(privateKey.Primes[1]),
+	})
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantKid string
+	}{
+		{"PKCS#1 PEM", pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)}), ""},
+		{"PKCS#8 PEM", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}), ""},
+		{"JWK", jwk, "old-idp-2024"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			imported, kid, err := ImportPrivateKey(tt.data)
+			if err != nil {
+				t.Fatalf("Failed to import key: %v", err)
+			}
+			if !imported.Equal(privateKey) {
+				t.Error("Imported key does not match the original")
+			}
+			if kid != tt.wantKid {
+				t.Errorf("Expected kid %q, got %q", tt.wantKid, kid)
+			}
+		})
+	}
+}
+
+func TestImportPrivateKey_Rejects(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecDER, _ := x509.MarshalECPrivateKey(ecKey)
+	smallKey, _ := GenerateRSAKeyPair(1024)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"EC PEM", pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER})},
+		{"EC JWK", []byte(`{"kty":"EC","crv":"P-256","x":"AA","y":"AA","d":"AA"}`)},
+		{"public JWK", []byte(`{"kty":"RSA","n":"AQAB","e":"AQAB"}`)},
+		{"small RSA key", pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(smallKey)})},
+		{"garbage", []byte("not a key")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := ImportPrivateKey(tt.data); err == nil {
+				t.Error("Expected import to fail")
+			}
+		})
+	}
+
+	if _, _, err := ImportPrivateKey(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER})); !errors.Is(err, ErrUnsupportedKeyType) {
+		t.Errorf("Expected ErrUnsupportedKeyType for EC keys, got %v", err)
+	}
+}
+
+func TestKeyThumbprint(t *testing.T) {
+	privateKey, err := GenerateRSAKeyPair(2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key pair: %v", err)
+	}
+	other, _ := GenerateRSAKeyPair(2048)
+
+	thumbprint := KeyThumbprint(&privateKey.PublicKey)
+	if thumbprint != KeyThumbprint(&privateKey.PublicKey) {
+		t.Error("Expected a stable thumbprint")
+	}
+	if thumbprint == KeyThumbprint(&other.PublicKey) {
+		t.Error("Expected different keys to have different thumbprints")
+	}
+	if len(thumbprint) != 43 {
+		t.Errorf("Expected a base64url SHA-256 thumbprint, got %q", thumbprint)
+	}
+}
+
+func TestParsePublicKeyJWK(t *testing.T) {
+	privateKey, err := GenerateRSAKeyPair(2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	n := base64.RawURLEncoding.EncodeToString(privateKey.N.Bytes())
+	e := base64.RawURLEncoding.EncodeToString(big.NewInt(int64(privateKey.E)).Bytes())
+
+	publicKey, err := ParsePublicKeyJWK(n, e)
+	if err != nil {
+		t.Fatalf("Failed to parse JWK: %v", err)
+	}
+	if !publicKey.Equal(&privateKey.PublicKey) {
+		t.Error("Expected the parsed key to equal the original")
+	}
+
+	small, _ := GenerateRSAKeyPair(1024)
+	if _, err := ParsePublicKeyJWK(base64.RawURLEncoding.EncodeToString(small.N.Bytes()), e); err == nil {
+		t.Error("Expected a 1024 bit key to be rejected")
+	}
+	if _, err := ParsePublicKeyJWK("!!", e); err == nil {
+		t.Error("Expected a malformed modulus to be rejected")
+	}
+}
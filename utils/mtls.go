@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// ConfirmationClaim binds a token to a key held by the client (RFC 7800):
+// the certificate thumbprint of RFC 8705 or the DPoP key thumbprint of
+// RFC 9449.
+type ConfirmationClaim struct {
+	X5tS256 string `json:"x5t#S256,omitempty"`
+	JKT     string `json:"jkt,omitempty"`
+}
+
+// NewConfirmationClaim returns the cnf claim for a token bound to a
+// certificate or DPoP key thumbprint, or nil for a bearer token
+func NewConfirmationClaim(x5tS256, jkt string) *ConfirmationClaim {
+	if x5tS256 == "" && jkt == "" {
+		return nil
+	}
+	return &ConfirmationClaim{X5tS256: x5tS256, JKT: jkt}
+}
+
+// CertificateThumbprint returns the base64url encoded SHA-256 hash of the
+// DER encoded certificate, the x5t#S256 value of RFC 8705
+func CertificateThumbprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// ParseForwardedCertificate decodes a client certificate forwarded by a TLS
+// terminating proxy. The PEM may be URL encoded (nginx's
+// $ssl_client_escaped_cert) or sent with its line breaks replaced by spaces.
+func ParseForwardedCertificate(value string) (*x509.Certificate, error) {
+	if value == "" {
+		return nil, errors.New("no certificate forwarded")
+	}
+	if strings.Contains(value, "%") {
+		unescaped, err := url.QueryUnescape(value)
+		if err != nil {
+			return nil, err
+		}
+		value = unescaped
+	}
+
+	// Restore line breaks some proxies fold into spaces, leaving the
+	// BEGIN/END markers intact
+	const begin, end = "-----BEGIN CERTIFICATE-----", "-----END CERTIFICATE-----"
+	if body, found := strings.CutPrefix(strings.TrimSpace(value), begin); found {
+		body, _, _ = strings.Cut(body, end)
+		value = begin + "\n" + strings.Join(strings.Fields(body), "\n") + "\n" + end + "\n"
+	}
+
+	block, _ := pem.Decode([]byte(value))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("forwarded certificate is not PEM encoded")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
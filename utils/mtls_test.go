@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func generateTestCertificate(t *testing.T) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+	return cert
+}
+
+func TestCertificateThumbprint(t *testing.T) {
+	cert := generateTestCertificate(t)
+
+	thumbprint := CertificateThumbprint(cert)
+	if len(thumbprint) != 43 || strings.ContainsAny(thumbprint, "+/=") {
+		t.Errorf("Expected an unpadded base64url SHA-256 hash, got %q", thumbprint)
+	}
+	if CertificateThumbprint(generateTestCertificate(t)) == thumbprint {
+		t.Error("Expected different certificates to have different thumbprints")
+	}
+}
+
+func TestParseForwardedCertificate(t *testing.T) {
+	cert := generateTestCertificate(t)
+	pemText := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+
+	tests := []struct {
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{"plain PEM", pemText, false},
+		{"URL encoded", url.QueryEscape(pemText), false},
+		{"line breaks folded into spaces", strings.ReplaceAll(strings.TrimSpace(pemText), "\n", " "), false},
+		{"empty", "", true},
+		{"not PEM", "certificate", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseForwardedCertificate(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseForwardedCertificate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !got.Equal(cert) {
+				t.Error("Expected the forwarded certificate to be returned")
+			}
+		})
+	}
+}
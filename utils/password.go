@@ -0,0 +1,158 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hashing algorithms
+const (
+	PasswordBcrypt   = "bcrypt"
+	PasswordArgon2id = "argon2id"
+)
+
+// PasswordHasher hashes passwords with the configured algorithm and cost
+type PasswordHasher struct {
+	Algorithm  string
+	BcryptCost int
+	// Argon2Memory is in KiB
+	Argon2Memory     uint32
+	Argon2Iterations uint32
+	Argon2Threads    uint8
+}
+
+// PasswordHashing is the policy new password hashes are created with. main
+// sets it from the configuration.
+var PasswordHashing = PasswordHasher{
+	Algorithm:        PasswordBcrypt,
+	BcryptCost:       bcrypt.DefaultCost,
+	Argon2Memory:     64 * 1024,
+	Argon2Iterations: 3,
+	Argon2Threads:    2,
+}
+
+// Validate rejects an unknown algorithm and unusable costs
+func (p PasswordHasher) Validate() error {
+	switch p.Algorithm {
+	case PasswordBcrypt:
+		if p.BcryptCost < bcrypt.MinCost || p.BcryptCost > bcrypt.MaxCost {
+			return fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	case PasswordArgon2id:
+		if p.Argon2Iterations < 1 || p.Argon2Threads < 1 {
+			return errors.New("argon2 iterations and threads must be at least 1")
+		}
+		if p.Argon2Memory < 8*uint32(p.Argon2Threads) {
+			return errors.New("argon2 memory must be at least 8 KiB per thread")
+		}
+	default:
+		return fmt.Errorf("unknown password hash algorithm %q", p.Algorithm)
+	}
+	return nil
+}
+
+// MaxPasswordLength is the longest password the policy can hash, in bytes;
+// bcrypt ignores everything after 72 bytes, so it refuses longer passwords
+func (p PasswordHasher) MaxPasswordLength() int {
+	if p.Algorithm == PasswordBcrypt {
+		return 72
+	}
+	return 0
+}
+
+// Hash returns a salted hash of the password
+func (p PasswordHasher) Hash(password string) (string, error) {
+	if p.Algorithm != PasswordArgon2id {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), p.BcryptCost)
+		return string(hash), err
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	params := argon2Params{memory: p.Argon2Memory, iterations: p.Argon2Iterations, threads: p.Argon2Threads}
+	return params.hash(password, salt, 32), nil
+}
+
+// NeedsRehash reports whether the hash was created with another algorithm
+// or a lower cost than the policy's, so it should be replaced the next time
+// the password is known
+func (p PasswordHasher) NeedsRehash(hash string) bool {
+	if p.Algorithm == PasswordArgon2id {
+		params, _, _, err := parseArgon2Hash(hash)
+		return err != nil || params.memory < p.Argon2Memory || params.iterations < p.Argon2Iterations || params.threads < p.Argon2Threads
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost < p.BcryptCost
+}
+
+// HashPassword hashes a password with PasswordHashing
+func HashPassword(password string) (string, error) {
+	return PasswordHashing.Hash(password)
+}
+
+// CheckPasswordHash reports whether the password matches a bcrypt or
+// argon2id hash, whatever policy it was created with
+func CheckPasswordHash(password, hash string) bool {
+	if !strings.HasPrefix(hash, "$"+PasswordArgon2id+"$") {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	}
+	params, salt, key, err := parseArgon2Hash(hash)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(params.hash(password, salt, uint32(len(key)))), []byte(hash)) == 1
+}
+
+// PasswordNeedsRehash reports whether a stored hash is weaker than
+// PasswordHashing
+func PasswordNeedsRehash(hash string) bool {
+	return PasswordHashing.NeedsRehash(hash)
+}
+
+type argon2Params struct {
+	memory     uint32
+	iterations uint32
+	threads    uint8
+}
+
+// hash encodes an argon2id key in the PHC string format,
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+func (a argon2Params) hash(password string, salt []byte, keyLen uint32) string {
+	key := argon2.IDKey([]byte(password), salt, a.iterations, a.memory, a.threads, keyLen)
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", PasswordArgon2id, argon2.Version,
+		a.memory, a.iterations, a.threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+func parseArgon2Hash(hash string) (argon2Params, []byte, []byte, error) {
+	var params argon2Params
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != PasswordArgon2id {
+		return params, nil, nil, errors.New("not an argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, errors.New("unsupported argon2 version")
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.iterations, &params.threads); err != nil || params.iterations < 1 || params.threads < 1 {
+		return params, nil, nil, errors.New("invalid argon2 parameters")
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, errors.New("invalid argon2 key")
+	}
+	return params, salt, key, nil
+}
@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPasswordHasher_Argon2id(t *testing.T) {
+	hasher := PasswordHasher{Algorithm: PasswordArgon2id, Argon2Memory: 1024, Argon2Iterations: 1, Argon2Threads: 1}
+	if err := hasher.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	hash, err := hasher.Hash("mySecretPassword123")
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Fatalf("Expected a PHC argon2id hash, got %s", hash)
+	}
+	if !CheckPasswordHash("mySecretPassword123", hash) {
+		t.Error("Expected password to match argon2id hash")
+	}
+	if CheckPasswordHash("wrongPassword", hash) {
+		t.Error("Expected wrong password to not match argon2id hash")
+	}
+	if hasher.NeedsRehash(hash) {
+		t.Error("Expected a hash created with the policy to be kept")
+	}
+
+	stronger := hasher
+	stronger.Argon2Iterations = 2
+	if !stronger.NeedsRehash(hash) {
+		t.Error("Expected a hash with fewer iterations to need rehashing")
+	}
+	if CheckPasswordHash("mySecretPassword123", strings.Replace(hash, "p=1", "p=0", 1)) {
+		t.Error("Expected a hash with invalid parameters to fail")
+	}
+}
+
+func TestPasswordHasher_NeedsRehash(t *testing.T) {
+	weak, err := PasswordHasher{Algorithm: PasswordBcrypt, BcryptCost: 4}.Hash("password")
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		hasher PasswordHasher
+		want   bool
+	}{
+		{"same cost", PasswordHasher{Algorithm: PasswordBcrypt, BcryptCost: 4}, false},
+		{"higher cost", PasswordHasher{Algorithm: PasswordBcrypt, BcryptCost: 5}, true},
+		{"other algorithm", PasswordHasher{Algorithm: PasswordArgon2id, Argon2Memory: 1024, Argon2Iterations: 1, Argon2Threads: 1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.hasher.NeedsRehash(weak); got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestPasswordHasher_Validate(t *testing.T) {
+	invalid := []PasswordHasher{
+		{Algorithm: "md5"},
+		{Algorithm: PasswordBcrypt, BcryptCost: 3},
+		{Algorithm: PasswordArgon2id, Argon2Memory: 1024, Argon2Iterations: 0, Argon2Threads: 1},
+		{Algorithm: PasswordArgon2id, Argon2Memory: 8, Argon2Iterations: 1, Argon2Threads: 2},
+	}
+	for _, hasher := range invalid {
+		if err := hasher.Validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", hasher)
+		}
+	}
+	if err := PasswordHashing.Validate(); err != nil {
+		t.Errorf("Expected the default policy to be valid: %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+package utils
+
+import (
+	"fmt"
+	"net/url"
+	"oauth2-server/models"
+	"strings"
+)
+
+// RedirectURIAllowed checks a requested redirect URI against a client's
+// registered ones. URIs must match exactly, except that a registered
+// loopback IP redirect URI accepts any port, since native apps listen on
+// an ephemeral port chosen at runtime (RFC 8252 section 7.3). The
+// ignore_query and wildcard_subdomain modes loosen the match further.
+func RedirectURIAllowed(requested string, registered []string, mode string) bool {
+	for _, uri := range registered {
+		if uri == requested || loopbackRedirectMatches(uri, requested) {
+			return true
+		}
+		switch mode {
+		case models.RedirectMatchIgnoreQuery:
+			if queryIgnoredRedirectMatches(uri, requested) {
+				return true
+			}
+		case models.RedirectMatchWildcard:
+			if wildcardRedirectMatches(uri, requested) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ValidateRedirectURI checks a redirect URI being registered. It must be an
+// absolute URI without a fragment (RFC 6749 section 3.1.2). A wildcard is
+// only allowed under the wildcard_subdomain mode, as the leftmost label of a
+// host with at least two more labels, so it cannot match a whole domain
+// such as *.com.
+func ValidateRedirectURI(uri, mode string) error {
+	u, err := url.Parse(uri)
+	if err != nil || !u.IsAbs() {
+		return fmt.Errorf("redirect URI must be an absolute URI: %s", uri)
+	}
+	if strings.Contains(uri, "#") {
+		return fmt.Errorf("redirect URI must not contain a fragment: %s", uri)
+	}
+	if !strings.Contains(u.Host, "*") {
+		return nil
+	}
+	if mode != models.RedirectMatchWildcard {
+		return fmt.Errorf("redirect URI may only contain a wildcard with wildcard_subdomain matching: %s", uri)
+	}
+	suffix, ok := strings.CutPrefix(u.Hostname(), "*.")
+	if !ok || strings.Contains(suffix, "*") || strings.Count(suffix, ".") < 1 {
+		return fmt.Errorf("redirect URI wildcard must be the leftmost label of a domain: %s", uri)
+	}
+	return nil
+}
+
+// loopbackRedirectMatches reports whether requested is the registered
+// loopback redirect URI with a different port. Only the http scheme and the
+// IP literals 127.0.0.1 and [::1] qualify; "localhost" may resolve to
+// something other than the loopback interface.
+func loopbackRedirectMatches(registered, requested string) bool {
+	reg, err := url.Parse(registered)
+	if err != nil || !isLoopbackRedirect(reg) {
+		return false
+	}
+	req, err := url.Parse(requested)
+	if err != nil || !isLoopbackRedirect(req) {
+		return false
+	}
+	return reg.Hostname() == req.Hostname() &&
+		reg.EscapedPath() == req.EscapedPath() &&
+		reg.RawQuery == req.RawQuery &&
+		req.User == nil &&
+		req.Fragment == ""
+}
+
+func isLoopbackRedirect(u *url.URL) bool {
+	if u.Scheme != "http" {
+		return false
+	}
+	host := u.Hostname()
+	return host == "127.0.0.1" || host == "::1"
+}
+
+// queryIgnoredRedirectMatches reports whether requested is the registered
+// redirect URI with a different query string
+func queryIgnoredRedirectMatches(registered, requested string) bool {
+	reg, req, ok := parseRedirectPair(registered, requested)
+	return ok &&
+		reg.Scheme == req.Scheme &&
+		reg.Host == req.Host &&
+		reg.EscapedPath() == req.EscapedPath()
+}
+
+// wildcardRedirectMatches reports whether requested is the registered
+// redirect URI with its leading "*" host label replaced by exactly one label
+func wildcardRedirectMatches(registered, requested string) bool {
+	reg, req, ok := parseRedirectPair(registered, requested)
+	if !ok {
+		return false
+	}
+	suffix, ok := strings.CutPrefix(reg.Hostname(), "*")
+	if !ok || !strings.HasPrefix(suffix, ".") {
+		return false
+	}
+	label, ok := strings.CutSuffix(req.Hostname(), suffix)
+	return ok && label != "" && !strings.ContainsAny(label, ".*") &&
+		reg.Scheme == req.Scheme &&
+		reg.Port() == req.Port() &&
+		reg.EscapedPath() == req.EscapedPath() &&
+		reg.RawQuery == req.RawQuery
+}
+
+// parseRedirectPair parses a registered and a requested redirect URI for the
+// looser matching modes. Requested URIs with credentials or a fragment never
+// match.
+func parseRedirectPair(registered, requested string) (*url.URL, *url.URL, bool) {
+	reg, err := url.Parse(registered)
+	if err != nil {
+		return nil, nil, false
+	}
+	req, err := url.Parse(requested)
+	if err != nil || req.User != nil || strings.Contains(requested, "#") {
+		return nil, nil, false
+	}
+	return reg, req, true
+}
@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"oauth2-server/models"
+	"testing"
+)
+
+func TestRedirectURIAllowed(t *testing.T) {
+	registered := []string{
+		"https://app.example.com/callback",
+		"http://127.0.0.1/callback",
+		"http://[::1]:8080/cb?app=cli",
+		"http://localhost:3000/callback",
+	}
+
+	tests := []struct {
+		requested string
+		want      bool
+	}{
+		{"https://app.example.com/callback", true},
+		{"https://app.example.com/callback/", false},
+		{"https://app.example.com/callback?next=/admin", false},
+		{"https://app.example.com:8443/callback", false},
+		{"HTTPS://app.example.com/callback", false},
+
+		// Loopback IP redirect URIs accept any port
+		{"http://127.0.0.1/callback", true},
+		{"http://127.0.0.1:51004/callback", true},
+		{"http://127.0.0.1:51004/other", false},
+		{"http://127.0.0.1:51004/callback?x=1", false},
+		{"http://127.0.0.1:51004/callback#frag", false},
+		{"http://user@127.0.0.1:51004/callback", false},
+		{"https://127.0.0.1:51004/callback", false},
+		{"http://127.0.0.2:51004/callback", false},
+		{"http://[::1]:49152/cb?app=cli", true},
+		{"http://[::1]:49152/cb", false},
+
+		// localhost is matched exactly
+		{"http://localhost:3000/callback", true},
+		{"http://localhost:3001/callback", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := RedirectURIAllowed(tt.requested, registered, models.RedirectMatchExact); got != tt.want {
+			t.Errorf("RedirectURIAllowed(%q) = %v, want %v", tt.requested, got, tt.want)
+		}
+	}
+}
+
+func TestRedirectURIAllowed_MatchingModes(t *testing.T) {
+	tests := []struct {
+		mode       string
+		registered string
+		requested  string
+		want       bool
+	}{
+		// Exact matching ignores the looser forms
+		{models.RedirectMatchExact, "https://app.example.com/cb", "https://app.example.com/cb?tenant=a", false},
+		{models.RedirectMatchExact, "https://*.dev.example.com/cb", "https://pr-1.dev.example.com/cb", false},
+
+		{models.RedirectMatchIgnoreQuery, "https://app.example.com/cb", "https://app.example.com/cb?tenant=a", true},
+		{models.RedirectMatchIgnoreQuery, "https://app.example.com/cb?v=1", "https://app.example.com/cb?v=2", true},
+		{models.RedirectMatchIgnoreQuery, "https://app.example.com/cb", "https://app.example.com/cb/other?x=1", false},
+		{models.RedirectMatchIgnoreQuery, "https://app.example.com/cb", "http://app.example.com/cb?x=1", false},
+		{models.RedirectMatchIgnoreQuery, "https://app.example.com/cb", "https://app.example.com:8443/cb?x=1", false},
+		{models.RedirectMatchIgnoreQuery, "https://app.example.com/cb", "https://app.example.com/cb?x=1#frag", false},
+		{models.RedirectMatchIgnoreQuery, "https://app.example.com/cb", "https://user@app.example.com/cb?x=1", false},
+
+		{models.RedirectMatchWildcard, "https://*.dev.example.com/cb", "https://pr-1.dev.example.com/cb", true},
+		{models.RedirectMatchWildcard, "https://*.dev.example.com:8443/cb", "https://pr-1.dev.example.com:8443/cb", true},
+		{models.RedirectMatchWildcard, "https://*.dev.example.com/cb", "https://dev.example.com/cb", false},
+		{models.RedirectMatchWildcard, "https://*.dev.example.com/cb", "https://a.b.dev.example.com/cb", false},
+		{models.RedirectMatchWildcard, "https://*.dev.example.com/cb", "https://evil.com/.dev.example.com/cb", false},
+		{models.RedirectMatchWildcard, "https://*.dev.example.com/cb", "https://pr-1.dev.example.com.evil.com/cb", false},
+		{models.RedirectMatchWildcard, "https://*.dev.example.com/cb", "https://pr-1.dev.example.com/cb?x=1", false},
+		{models.RedirectMatchWildcard, "https://*.dev.example.com/cb", "http://pr-1.dev.example.com/cb", false},
+		{models.RedirectMatchWildcard, "https://*.dev.example.com/cb", "https://pr-1.dev.example.com/cb#frag", false},
+	}
+
+	for _, tt := range tests {
+		if got := RedirectURIAllowed(tt.requested, []string{tt.registered}, tt.mode); got != tt.want {
+			t.Errorf("RedirectURIAllowed(%q, %q, %s) = %v, want %v", tt.requested, tt.registered, tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestValidateRedirectURI(t *testing.T) {
+	tests := []struct {
+		uri     string
+		mode    string
+		wantErr bool
+	}{
+		{uri: "https://app.example.com/cb"},
+		{uri: "com.example.app:/oauth2redirect"},
+		{uri: "https://*.dev.example.com/cb", mode: models.RedirectMatchWildcard},
+		{uri: "/cb", wantErr: true},
+		{uri: "https://app.example.com/cb#section", wantErr: true},
+		{uri: "https://app.example.com/cb#", wantErr: true},
+		{uri: "https://*.dev.example.com/cb", wantErr: true},
+		{uri: "https://*.com/cb", mode: models.RedirectMatchWildcard, wantErr: true},
+		{uri: "https://dev.*.example.com/cb", mode: models.RedirectMatchWildcard, wantErr: true},
+		{uri: "https://pr-*.example.com/cb", mode: models.RedirectMatchWildcard, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		err := ValidateRedirectURI(tt.uri, tt.mode)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ValidateRedirectURI(%q, %q) error = %v, wantErr %v", tt.uri, tt.mode, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// ValidateResourceIndicator checks that a resource parameter is an absolute
+// URI without a fragment, as required by RFC 8707
+func ValidateResourceIndicator(resource string) error {
+	u, err := url.Parse(resource)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return fmt.Errorf("resource must be an absolute URI: %s", resource)
+	}
+	if u.Fragment != "" {
+		return fmt.Errorf("resource must not contain a fragment: %s", resource)
+	}
+	return nil
+}
+
+// ValidateResources checks the requested resource indicators against the
+// resources allowed for the request. Nothing is allowed when allowed is empty.
+func ValidateResources(requested, allowed []string) error {
+	allowedSet := make(map[string]bool, len(allowed))
+	for _, resource := range allowed {
+		allowedSet[resource] = true
+	}
+
+	for _, resource := range requested {
+		if err := ValidateResourceIndicator(resource); err != nil {
+			return err
+		}
+		if !allowedSet[resource] {
+			return fmt.Errorf("resource not allowed for this client: %s", resource)
+		}
+	}
+	return nil
+}
+
+// AudienceAllows reports whether a token audience permits use at the given
+// resource. Tokens without an audience, or issued for this server alone
+// because no resource was requested, are not restricted to any resource.
+func AudienceAllows(audience []string, resource string) bool {
+	if len(audience) == 0 || (len(audience) == 1 && audience[0] == TokenIssuer) {
+		return true
+	}
+	for _, aud := range audience {
+		if aud == resource {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package utils
+
+import "testing"
+
+func TestValidateResources(t *testing.T) {
+	allowed := []string{"https://api.example.com", "https://billing.example.com/v1"}
+
+	tests := []struct {
+		name      string
+		requested []string
+		allowed   []string
+		wantErr   bool
+	}{
+		{"No resources", nil, allowed, false},
+		{"Allowed resource", []string{"https://api.example.com"}, allowed, false},
+		{"Multiple allowed", []string{"https://api.example.com", "https://billing.example.com/v1"}, allowed, false},
+		{"Not allowed", []string{"https://other.example.com"}, allowed, true},
+		{"Relative URI", []string{"/api"}, []string{"/api"}, true},
+		{"Fragment", []string{"https://api.example.com#x"}, []string{"https://api.example.com#x"}, true},
+		{"Client without resources", []string{"https://api.example.com"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateResources(tt.requested, tt.allowed)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateResources() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAudienceAllows(t *testing.T) {
+	if !AudienceAllows(nil, "https://api.example.com") {
+		t.Error("Expected a token without audience to be accepted")
+	}
+	if !AudienceAllows([]string{"https://api.example.com"}, "https://api.example.com") {
+		t.Error("Expected matching audience to be accepted")
+	}
+	if AudienceAllows([]string{"https://api.example.com"}, "https://billing.example.com") {
+		t.Error("Expected mismatched audience to be rejected")
+	}
+	if !AudienceAllows([]string{TokenIssuer}, "https://api.example.com") {
+		t.Error("Expected a token issued for this server alone to be accepted")
+	}
+}
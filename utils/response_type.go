@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"sort"
+	"strings"
+)
+
+// SupportedResponseTypes lists the response types a client may register for.
+// Only the authorization code flow is implemented, so implicit and hybrid
+// response types are refused at registration and at the authorization
+// endpoint alike.
+var SupportedResponseTypes = []string{"code"}
+
+// DefaultResponseTypes is used for clients registered without response_types
+var DefaultResponseTypes = []string{"code"}
+
+// NormalizeResponseType sorts the space-separated values of a response type so
+// that "id_token code" and "code id_token" compare equal
+func NormalizeResponseType(responseType string) string {
+	values := strings.Fields(responseType)
+	sort.Slice(values, func(i, j int) bool {
+		return responseTypeOrder(values[i]) < responseTypeOrder(values[j])
+	})
+	return strings.Join(values, " ")
+}
+
+// IsSupportedResponseType checks if a response type is one clients can register for
+func IsSupportedResponseType(responseType string) bool {
+	normalized := NormalizeResponseType(responseType)
+	for _, supported := range SupportedResponseTypes {
+		if supported == normalized {
+			return true
+		}
+	}
+	return false
+}
+
+// ResponseTypeAllowed checks a requested response type against a client's
+// registered response types. An empty list falls back to DefaultResponseTypes.
+func ResponseTypeAllowed(requested string, allowed []string) bool {
+	if len(allowed) == 0 {
+		allowed = DefaultResponseTypes
+	}
+
+	normalized := NormalizeResponseType(requested)
+	for _, responseType := range allowed {
+		if NormalizeResponseType(responseType) == normalized {
+			return true
+		}
+	}
+	return false
+}
+
+func responseTypeOrder(value string) int {
+	switch value {
+	case "code":
+		return 0
+	case "id_token":
+		return 1
+	case "token":
+		return 2
+	}
+	return 3
+}
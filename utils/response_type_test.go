@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestNormalizeResponseType(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"code", "code"},
+		{"id_token code", "code id_token"},
+		{"token id_token code", "code id_token token"},
+		{"  code   token ", "code token"},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizeResponseType(tt.input); got != tt.want {
+			t.Errorf("NormalizeResponseType(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestIsSupportedResponseType(t *testing.T) {
+	tests := []struct {
+		responseType string
+		want         bool
+	}{
+		{"code", true},
+		{"id_token code", false},
+		{"token", false},
+		{"none", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := IsSupportedResponseType(tt.responseType); got != tt.want {
+			t.Errorf("IsSupportedResponseType(%q) = %v, want %v", tt.responseType, got, tt.want)
+		}
+	}
+}
+
+func TestResponseTypeAllowed(t *testing.T) {
+	tests := []struct {
+		name      string
+		requested string
+		allowed   []string
+		want      bool
+	}{
+		{"default allows code", "code", nil, true},
+		{"default rejects id_token", "code id_token", nil, false},
+		{"registered combination", "id_token code", []string{"code id_token"}, true},
+		{"not registered", "code", []string{"code id_token"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ResponseTypeAllowed(tt.requested, tt.allowed); got != tt.want {
+				t.Errorf("ResponseTypeAllowed(%q, %v) = %v, want %v", tt.requested, tt.allowed, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidState  = errors.New("invalid state")
+	ErrStateExpired  = errors.New("state expired")
+	ErrStateMismatch = errors.New("state was issued to a different client")
+)
+
+// SignedState is the payload carried inside an HMAC-signed state value
+type SignedState struct {
+	ID       string `json:"jti"`
+	ClientID string `json:"cid"`
+	Data     string `json:"dat,omitempty"`
+	Exp      int64  `json:"exp"`
+	Iat      int64  `json:"iat"`
+}
+
+// GenerateSignedState issues an opaque state value bound to a client. data is
+// optional application context (e.g. the page to return to) carried back to
+// the relying party on callback.
+func GenerateSignedState(secret []byte, clientID, data string, ttl time.Duration) (string, *SignedState, error) {
+	id, err := GenerateRandomString(24)
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+	payload := &SignedState{
+		ID:       id,
+		ClientID: clientID,
+		Data:     data,
+		Exp:      now.Add(ttl).Unix(),
+		Iat:      now.Unix(),
+	}
+
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return "", nil, err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payloadJSON)
+	return encoded + "." + signState(secret, encoded), payload, nil
+}
+
+// VerifySignedState checks the signature, expiry and client binding of a
+// state value. Reuse detection is left to the caller since it needs storage.
+func VerifySignedState(secret []byte, state, clientID string) (*SignedState, error) {
+	parts := strings.Split(state, ".")
+	if len(parts) != 2 {
+		return nil, ErrInvalidState
+	}
+
+	expected := signState(secret, parts[0])
+	if !hmac.Equal([]byte(expected), []byte(parts[1])) {
+		return nil, ErrInvalidState
+	}
+
+	payloadJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidState
+	}
+
+	var payload SignedState
+	if err := json.Unmarshal(payloadJSON, &payload); err != nil {
+		return nil, ErrInvalidState
+	}
+
+	if time.Now().Unix() > payload.Exp {
+		return nil, ErrStateExpired
+	}
+
+	if payload.ClientID != clientID {
+		return nil, ErrStateMismatch
+	}
+
+	return &payload, nil
+}
+
+// DeriveStateKey derives a stable HMAC key for state signing from the server's
+// RSA private key, used when no dedicated STATE_SIGNING_KEY is configured
+func DeriveStateKey(privateKey *rsa.PrivateKey) []byte {
+	mac := hmac.New(sha256.New, privateKey.D.Bytes())
+	mac.Write([]byte("oauth2-server state signing"))
+	return mac.Sum(nil)
+}
+
+func signState(secret []byte, encodedPayload string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(encodedPayload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
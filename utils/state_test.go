@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignedStateRoundTrip(t *testing.T) {
+	secret := []byte("test-secret")
+
+	state, issued, err := GenerateSignedState(secret, "client-1", "/dashboard", time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to generate state: %v", err)
+	}
+
+	verified, err := VerifySignedState(secret, state, "client-1")
+	if err != nil {
+		t.Fatalf("Expected state to verify, got %v", err)
+	}
+
+	if verified.ID != issued.ID {
+		t.Errorf("Expected state ID %s, got %s", issued.ID, verified.ID)
+	}
+	if verified.Data != "/dashboard" {
+		t.Errorf("Expected data /dashboard, got %s", verified.Data)
+	}
+}
+
+func TestVerifySignedStateRejections(t *testing.T) {
+	secret := []byte("test-secret")
+
+	state, _, err := GenerateSignedState(secret, "client-1", "", time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to generate state: %v", err)
+	}
+	expired, _, err := GenerateSignedState(secret, "client-1", "", -time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to generate state: %v", err)
+	}
+
+	parts := strings.Split(state, ".")
+	tampered := parts[0] + "x." + parts[1]
+
+	tests := []struct {
+		name     string
+		secret   []byte
+		state    string
+		clientID string
+		expected error
+	}{
+		{"Wrong secret", []byte("other-secret"), state, "client-1", ErrInvalidState},
+		{"Tampered payload", secret, tampered, "client-1", ErrInvalidState},
+		{"Malformed state", secret, "not-a-state", "client-1", ErrInvalidState},
+		{"Expired state", secret, expired, "client-1", ErrStateExpired},
+		{"Different client", secret, state, "client-2", ErrStateMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := VerifySignedState(tt.secret, tt.state, tt.clientID); err != tt.expected {
+				t.Errorf("Expected error %v, got %v", tt.expected, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+// DecryptClientJWE decrypts a JWE made by EncryptJWEForClient the way a
+// client would, returning its protected header and plaintext. It exists for
+// tests.
+func DecryptClientJWE(token string, privateKey *rsa.PrivateKey) (map[string]string, []byte, error) {
+	object, err := jose.ParseEncryptedCompact(token, []jose.KeyAlgorithm{jose.RSA_OAEP, jose.RSA_OAEP_256}, []jose.ContentEncryption{jose.A128CBC_HS256, jose.A256GCM})
+	if err != nil {
+		return nil, nil, err
+	}
+	plaintext, err := object.Decrypt(privateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	protected, err := base64.RawURLEncoding.DecodeString(strings.SplitN(token, ".", 2)[0])
+	if err != nil {
+		return nil, nil, errors.New("invalid protected header")
+	}
+	var header map[string]string
+	if err := json.Unmarshal(protected, &header); err != nil {
+		return nil, nil, err
+	}
+	return header, plaintext, nil
+}
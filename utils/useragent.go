@@ -0,0 +1,46 @@
+package utils
+
+import "strings"
+
+// userAgentBrowsers are checked in order, since most browsers also claim to
+// be the ones they are built on (Edge sends "Chrome/" and "Safari/")
+var userAgentBrowsers = []struct{ token, name string }{
+	{"Edg", "Edge"},
+	{"OPR/", "Opera"},
+	{"SamsungBrowser/", "Samsung Internet"},
+	{"Firefox/", "Firefox"},
+	{"FxiOS/", "Firefox"},
+	{"CriOS/", "Chrome"},
+	{"Chrome/", "Chrome"},
+	{"Safari/", "Safari"},
+	{"curl/", "curl"},
+}
+
+var userAgentSystems = []struct{ token, name string }{
+	{"iPhone", "iOS"},
+	{"iPad", "iPadOS"},
+	{"Android", "Android"},
+	{"Windows", "Windows"},
+	{"CrOS", "ChromeOS"},
+	{"Mac OS X", "macOS"},
+	{"Macintosh", "macOS"},
+	{"Linux", "Linux"},
+}
+
+// ParseUserAgent returns the browser and operating system named by a
+// User-Agent header, or empty strings for those it does not recognise
+func ParseUserAgent(userAgent string) (browser, os string) {
+	for _, b := range userAgentBrowsers {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	for _, s := range userAgentSystems {
+		if strings.Contains(userAgent, s.token) {
+			os = s.name
+			break
+		}
+	}
+	return browser, os
+}
@@ -0,0 +1,24 @@
+package utils
+
+import "testing"
+
+func TestParseUserAgent(t *testing.T) {
+	tests := []struct {
+		userAgent   string
+		browser, os string
+	}{
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36", "Chrome", "macOS"},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36 Edg/126.0.0.0", "Edge", "Windows"},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Mobile/15E148 Safari/604.1", "Safari", "iOS"},
+		{"Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:127.0) Gecko/20100101 Firefox/127.0", "Firefox", "Linux"},
+		{"Mozilla/5.0 (Linux; Android 14; SM-S918B) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/25.0 Chrome/121.0.0.0 Mobile Safari/537.36", "Samsung Internet", "Android"},
+		{"curl/8.6.0", "curl", ""},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		browser, os := ParseUserAgent(tt.userAgent)
+		if browser != tt.browser || os != tt.os {
+			t.Errorf("ParseUserAgent(%q) = %q, %q; want %q, %q", tt.userAgent, browser, os, tt.browser, tt.os)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"oauth2-server/models"
+	"oauth2-server/utils"
+	"strconv"
+	"time"
+)
+
+// Security event types delivered to webhooks
+const (
+	EventUserCreated     = "user.created"
+	EventConsentRevoked  = "consent.revoked"
+	EventSessionRevoked  = "session.revoked"
+	EventTokenIssued     = "token.issued"
+	EventLoginFailed     = "login.failed"
+	EventLoginUnfamiliar = "login.unfamiliar"
+)
+
+// EventTypes lists every event a webhook can subscribe to
+var EventTypes = []string{
+	EventUserCreated,
+	EventConsentRevoked,
+	EventSessionRevoked,
+	EventTokenIssued,
+	EventLoginFailed,
+	EventLoginUnfamiliar,
+}
+
+// Headers sent with every delivery. The signature is the hex HMAC-SHA256,
+// keyed by the webhook secret, of the timestamp, a dot and the body.
+const (
+	IDHeader        = "X-Webhook-ID"
+	TimestampHeader = "X-Webhook-Timestamp"
+	SignatureHeader = "X-Webhook-Signature"
+)
+
+// Event is the JSON body of a delivery
+type Event struct {
+	ID        string            `json:"id"`
+	Type      string            `json:"type"`
+	CreatedAt time.Time         `json:"created_at"`
+	UserID    string            `json:"user_id,omitempty"`
+	ClientID  string            `json:"client_id,omitempty"`
+	Data      map[string]string `json:"data,omitempty"`
+}
+
+// Store finds the webhooks subscribed to an event type
+type Store interface {
+	FindByEvent(ctx context.Context, event string) ([]*models.Webhook, error)
+}
+
+// Dispatcher delivers events to subscribed webhooks in the background.
+// Failed deliveries are retried with exponential backoff; a receiver should
+// use the event ID to ignore duplicates.
+type Dispatcher struct {
+	store       Store
+	client      *http.Client
+	maxAttempts int
+	backoff     time.Duration
+}
+
+// NewDispatcher creates a dispatcher that tries each delivery up to
+// maxAttempts times, waiting backoff before the first retry and doubling the
+// wait after each one
+func NewDispatcher(store Store, timeout time.Duration, maxAttempts int, backoff time.Duration) *Dispatcher {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	return &Dispatcher{
+		store:       store,
+		client:      &http.Client{Timeout: timeout},
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
+	}
+}
+
+// Publish sends the event to every webhook subscribed to its type without
+// blocking the caller
+func (d *Dispatcher) Publish(event *Event) {
+	if event.ID == "" {
+		event.ID, _ = utils.GenerateRandomString(16)
+	}
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now().UTC()
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		hooks, err := d.store.FindByEvent(ctx, event.Type)
+		cancel()
+		if err != nil {
+			log.Printf("Failed to find webhooks for %s: %v", event.Type, err)
+			return
+		}
+
+		body, err := json.Marshal(event)
+		if err != nil {
+			log.Printf("Failed to encode webhook event %s: %v", event.Type, err)
+			return
+		}
+		for _, hook := range hooks {
+			go d.deliver(hook, event.ID, body)
+		}
+	}()
+}
+
+// deliver posts the body until the receiver accepts it, gives a permanent
+// error or the attempts run out
+func (d *Dispatcher) deliver(hook *models.Webhook, eventID string, body []byte) {
+	wait := d.backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := d.send(hook, eventID, body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt >= d.maxAttempts {
+			log.Printf("Webhook %s gave up on event %s after %d attempts: %v", hook.ID, eventID, attempt, err)
+			return
+		}
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// send makes one delivery attempt and reports whether a failure is worth
+// retrying. Rate limiting and server errors are; other client errors are not.
+func (d *Dispatcher) send(hook *models.Webhook, eventID string, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(IDHeader, eventID)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, "sha256="+Sign(hook.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("receiver responded with %d", resp.StatusCode)
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
+
+// Sign returns the hex HMAC-SHA256 of timestamp + "." + body. Receivers
+// recompute it with the webhook secret and reject stale timestamps to stop
+// replays.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
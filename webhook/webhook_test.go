@@ -0,0 +1,88 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"oauth2-server/models"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeStore []*models.Webhook
+
+func (s fakeStore) FindByEvent(ctx context.Context, event string) ([]*models.Webhook, error) {
+	var hooks []*models.Webhook
+	for _, hook := range s {
+		for _, e := range hook.Events {
+			if e == event {
+				hooks = append(hooks, hook)
+			}
+		}
+	}
+	return hooks, nil
+}
+
+func TestDispatcher_SignsAndRetries(t *testing.T) {
+	var attempts int32
+	received := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+	}))
+	defer server.Close()
+
+	store := fakeStore{
+		{ID: "hook-1", URL: server.URL, Events: []string{EventLoginFailed}, Secret: "secret"},
+		{ID: "hook-2", URL: server.URL, Events: []string{EventUserCreated}, Secret: "other"},
+	}
+	d := NewDispatcher(store, time.Second, 3, time.Millisecond)
+	d.Publish(&Event{Type: EventLoginFailed, UserID: "user-1", Data: map[string]string{"reason": "invalid_password"}})
+
+	select {
+	case r := <-received:
+		body := <-bodies
+		want := "sha256=" + Sign("secret", r.Header.Get(TimestampHeader), body)
+		if r.Header.Get(SignatureHeader) != want {
+			t.Errorf("Unexpected signature %q, want %q", r.Header.Get(SignatureHeader), want)
+		}
+		var event Event
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Fatalf("Invalid event body: %v", err)
+		}
+		if event.Type != EventLoginFailed || event.UserID != "user-1" || event.ID != r.Header.Get(IDHeader) {
+			t.Errorf("Unexpected event %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the event to be delivered")
+	}
+
+	if got := atomic.LoadInt32(&attempts); got != 3 {
+		t.Errorf("Expected 3 attempts, got %d", got)
+	}
+}
+
+func TestDispatcher_ClientErrorIsNotRetried(t *testing.T) {
+	d := NewDispatcher(fakeStore{}, time.Second, 5, time.Millisecond)
+
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusGone)
+	}))
+	defer server.Close()
+
+	d.deliver(&models.Webhook{ID: "hook-1", URL: server.URL}, "event-1", []byte("{}"))
+	if got := atomic.LoadInt32(&attempts); got != 1 {
+		t.Errorf("Expected a single attempt, got %d", got)
+	}
+}